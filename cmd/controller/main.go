@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"k8s.io/client-go/rest"
@@ -33,9 +34,14 @@ import (
 	_ "github.com/kanisterio/kanister/pkg/function"
 	"github.com/kanisterio/kanister/pkg/handler"
 	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/objectstore"
 	"github.com/kanisterio/kanister/pkg/resource"
 )
 
+// shutdownTimeout bounds the time spent draining object store operations
+// once a shutdown signal is received
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	ctx := context.Background()
 
@@ -82,6 +88,11 @@ func main() {
 	select {
 	case <-signalChan:
 		log.Infof("shutdown signal received, exiting...")
+		sctx, scancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := objectstore.Shutdown(sctx); err != nil {
+			log.Errorf("Failed to drain object store operations: %+v", err)
+		}
+		scancel()
 		cancel()
 		return
 	}
@@ -0,0 +1,316 @@
+// Package main runs a scripted scenario against an object store using the
+// public objectstore API. It is a runnable reference for the API and a quick
+// compatibility check for new providers and S3 compatible appliances.
+//
+// The object store is configured with environment variables:
+//
+//	OBJECTSTORE_PROVIDER        S3 (default), GCS or Azure
+//	OBJECTSTORE_BUCKET          bucket to use. Created, and deleted at the
+//	                            end, if it does not exist
+//	OBJECTSTORE_REGION          region of the bucket, if it is created
+//	OBJECTSTORE_ENDPOINT        endpoint of S3 compatible object stores
+//	OBJECTSTORE_SKIP_SSL_VERIFY set to "true" to skip SSL verification
+//
+// Credentials are read from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// AZURE_STORAGE_ACCOUNT_NAME and AZURE_STORAGE_ACCOUNT_KEY, or the Google
+// application default credentials.
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/compute/v1"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+const (
+	providerEnv      = "OBJECTSTORE_PROVIDER"
+	bucketEnv        = "OBJECTSTORE_BUCKET"
+	regionEnv        = "OBJECTSTORE_REGION"
+	endpointEnv      = "OBJECTSTORE_ENDPOINT"
+	skipSSLVerifyEnv = "OBJECTSTORE_SKIP_SSL_VERIFY"
+
+	timeout = 10 * time.Minute
+)
+
+// step is the result of one step of the scenario
+type step struct {
+	name    string
+	calls   int
+	latency time.Duration
+	err     error
+}
+
+// demo runs the steps of the scenario and records their results
+type demo struct {
+	steps []*step
+}
+
+// run runs f as the named step. f counts the API calls it makes with the
+// call function it is passed.
+func (d *demo) run(name string, f func(call func()) error) error {
+	s := &step{name: name}
+	start := time.Now()
+	s.err = f(func() { s.calls++ })
+	s.latency = time.Since(start)
+	d.steps = append(d.steps, s)
+	return s.err
+}
+
+func (d *demo) summary() (failed int) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tRESULT\tCALLS\tLATENCY\tERROR")
+	var calls int
+	var total time.Duration
+	for _, s := range d.steps {
+		result, msg := "PASS", ""
+		if s.err != nil {
+			result, msg = "FAIL", s.err.Error()
+			failed++
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", s.name, result, s.calls, s.latency, msg)
+		calls += s.calls
+		total += s.latency
+	}
+	fmt.Fprintf(w, "TOTAL\t%d/%d passed\t%d\t%s\t\n", len(d.steps)-failed, len(d.steps), calls, total)
+	w.Flush()
+	return failed
+}
+
+func main() {
+	d := &demo{}
+	err := d.scenario()
+	if d.summary() != 0 || err != nil {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func (d *demo) scenario() error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	config, secret, err := configFromEnv(ctx)
+	if err != nil {
+		return err
+	}
+	bucketName := os.Getenv(bucketEnv)
+	if bucketName == "" {
+		return errors.Errorf("%s must be set", bucketEnv)
+	}
+	var provider objectstore.Provider
+	if err := d.run("NewProvider", func(call func()) error {
+		provider, err = objectstore.NewProvider(ctx, config, secret)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	var bucket objectstore.Bucket
+	var created bool
+	if err := d.run("GetOrCreateBucket", func(call func()) error {
+		call()
+		if bucket, err = provider.GetBucket(ctx, bucketName); err == nil {
+			return nil
+		}
+		call()
+		bucket, err = provider.CreateBucket(ctx, bucketName, os.Getenv(regionEnv))
+		created = err == nil
+		return err
+	}); err != nil {
+		return err
+	}
+	if created {
+		// Deferred calls run last to first, so the bucket is deleted after
+		// the scratch directory
+		defer d.run("DeleteBucket", func(call func()) error {
+			call()
+			return provider.DeleteBucket(context.Background(), bucketName)
+		})
+	}
+
+	// All objects are created under a scratch directory, which is deleted
+	// even if the scenario fails
+	prefix := "objectstore-demo-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	var dir objectstore.Directory
+	if err := d.run("CreateDirectory", func(call func()) error {
+		call()
+		dir, err = bucket.CreateDirectory(ctx, prefix)
+		return err
+	}); err != nil {
+		return err
+	}
+	defer d.run("DeleteDirectory", func(call func()) error {
+		call()
+		return dir.DeleteDirectory(context.Background())
+	})
+
+	return d.objects(ctx, dir)
+}
+
+// objects creates, reads, lists and deletes objects in dir
+func (d *demo) objects(ctx context.Context, dir objectstore.Directory) error {
+	data := []byte("Hello from the objectstore demo")
+	tags := map[string]string{"demo-owner": "kanister", "demo-purpose": "compatibility-check"}
+	var sub objectstore.Directory
+	var err error
+	if err := d.run("CreateSubDirectory", func(call func()) error {
+		call()
+		sub, err = dir.CreateDirectory(ctx, "nested")
+		return err
+	}); err != nil {
+		return err
+	}
+	if err := d.run("PutBytesWithTags", func(call func()) error {
+		call()
+		return dir.PutBytes(ctx, "tagged", data, tags)
+	}); err != nil {
+		return err
+	}
+	if err := d.run("PutStream", func(call func()) error {
+		call()
+		return sub.Put(ctx, "streamed", bytes.NewReader(data), int64(len(data)), nil)
+	}); err != nil {
+		return err
+	}
+	if err := d.run("GetBytesWithTags", func(call func()) error {
+		call()
+		got, gotTags, err := dir.GetBytes(ctx, "tagged")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(got, data) {
+			return errors.Errorf("read %d bytes that do not match the %d bytes written", len(got), len(data))
+		}
+		for k, v := range tags {
+			if gotTags[k] != v {
+				return errors.Errorf("tag %s is %q, expected %q", k, gotTags[k], v)
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := d.run("GetStream", func(call func()) error {
+		call()
+		r, _, err := sub.Get(ctx, "streamed")
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			return errors.Wrap(err, "failed to read object")
+		}
+		if !bytes.Equal(got, data) {
+			return errors.Errorf("read %d bytes that do not match the %d bytes written", len(got), len(data))
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	if err := d.run("List", func(call func()) error {
+		call()
+		objs, err := dir.ListObjects(ctx)
+		if err != nil {
+			return err
+		}
+		if err := expectNames(objs, "tagged"); err != nil {
+			return errors.Wrap(err, "unexpected objects")
+		}
+		call()
+		dirs, err := dir.ListDirectories(ctx)
+		if err != nil {
+			return err
+		}
+		names := make([]string, 0, len(dirs))
+		for name := range dirs {
+			names = append(names, name)
+		}
+		return errors.Wrap(expectNames(names, "nested"), "unexpected directories")
+	}); err != nil {
+		return err
+	}
+	return d.run("Delete", func(call func()) error {
+		call()
+		if err := dir.Delete(ctx, "tagged"); err != nil {
+			return err
+		}
+		call()
+		if _, _, err := dir.GetBytes(ctx, "tagged"); err == nil {
+			return errors.New("deleted object can still be read")
+		}
+		return nil
+	})
+}
+
+// expectNames checks that names holds exactly the expected names. Directory
+// names may be reported with a trailing separator.
+func expectNames(names []string, expected ...string) error {
+	got := make([]string, 0, len(names))
+	for _, n := range names {
+		if len(n) > 1 && n[len(n)-1] == '/' {
+			n = n[:len(n)-1]
+		}
+		got = append(got, n)
+	}
+	sort.Strings(got)
+	sort.Strings(expected)
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		return errors.Errorf("found %v, expected %v", got, expected)
+	}
+	return nil
+}
+
+func configFromEnv(ctx context.Context) (objectstore.ProviderConfig, *objectstore.Secret, error) {
+	config := objectstore.ProviderConfig{
+		Type:          objectstore.ProviderTypeS3,
+		Endpoint:      os.Getenv(endpointEnv),
+		SkipSSLVerify: os.Getenv(skipSSLVerifyEnv) == "true",
+	}
+	if t := os.Getenv(providerEnv); t != "" {
+		config.Type = objectstore.ProviderType(t)
+	}
+	if !objectstore.Supported(config.Type) {
+		return config, nil, errors.Errorf("unsupported provider %q", config.Type)
+	}
+	secret := &objectstore.Secret{}
+	switch config.Type {
+	case objectstore.ProviderTypeS3:
+		secret.Type = objectstore.SecretTypeAwsAccessKey
+		secret.Aws = &objectstore.SecretAws{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}
+	case objectstore.ProviderTypeGCS:
+		creds, err := google.FindDefaultCredentials(ctx, compute.ComputeScope)
+		if err != nil {
+			return config, nil, errors.Wrap(err, "failed to find Google application default credentials")
+		}
+		secret.Type = objectstore.SecretTypeGcpServiceAccountKey
+		secret.Gcp = &objectstore.SecretGcp{
+			ServiceKey: string(creds.JSON),
+			ProjectID:  creds.ProjectID,
+		}
+	case objectstore.ProviderTypeAzure:
+		secret.Type = objectstore.SecretTypeAzStorageAccount
+		secret.Azure = &objectstore.SecretAzure{
+			StorageAccount: os.Getenv("AZURE_STORAGE_ACCOUNT_NAME"),
+			StorageKey:     os.Getenv("AZURE_STORAGE_ACCOUNT_KEY"),
+		}
+	}
+	return config, secret, nil
+}
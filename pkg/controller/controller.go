@@ -42,6 +42,7 @@ import (
 	"github.com/kanisterio/kanister/pkg/client/clientset/versioned"
 	"github.com/kanisterio/kanister/pkg/client/clientset/versioned/scheme"
 	"github.com/kanisterio/kanister/pkg/eventer"
+	"github.com/kanisterio/kanister/pkg/kube"
 	"github.com/kanisterio/kanister/pkg/param"
 	"github.com/kanisterio/kanister/pkg/reconcile"
 	"github.com/kanisterio/kanister/pkg/validate"
@@ -367,9 +368,16 @@ func (c *Controller) runAction(ctx context.Context, as *crv1alpha1.ActionSet, aI
 			}
 			var rf func(*crv1alpha1.ActionSet) error
 			if err != nil {
+				oomKilled := errors.Cause(err) == kube.ErrOOMKilled
 				rf = func(ras *crv1alpha1.ActionSet) error {
 					ras.Status.State = crv1alpha1.StateFailed
 					ras.Status.Actions[aIDX].Phases[i].State = crv1alpha1.StateFailed
+					if oomKilled {
+						if ras.Annotations == nil {
+							ras.Annotations = make(map[string]string)
+						}
+						ras.Annotations[kube.OOMKilledAnnotation] = "true"
+					}
 					return nil
 				}
 			} else {
@@ -0,0 +1,190 @@
+package function
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/clock"
+
+	kanister "github.com/kanisterio/kanister/pkg"
+	"github.com/kanisterio/kanister/pkg/format"
+	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/param"
+)
+
+func init() {
+	kanister.Register(&cassandraRepairFunc{})
+}
+
+var (
+	_ kanister.Func = (*cassandraRepairFunc)(nil)
+)
+
+const (
+	// CassandraRepairNamespaceArg provides the namespace of the Cassandra pods
+	CassandraRepairNamespaceArg = "namespace"
+	// CassandraRepairPodsArg provides the space separated names of the pods
+	// to repair, in the order in which they are repaired
+	CassandraRepairPodsArg = "pods"
+	// CassandraRepairContainerArg provides the container in which nodetool
+	// is run
+	CassandraRepairContainerArg = "container"
+	// CassandraRepairKeyspacesArg provides the keyspaces to repair. All the
+	// keyspaces are repaired if it is not set.
+	CassandraRepairKeyspacesArg = "keyspaces"
+	// CassandraRepairDurationsOutput maps each pod to the duration of its repair
+	CassandraRepairDurationsOutput = "repairDurations"
+	cassandraRepairPollInterval    = 30 * time.Second
+	// cassandraRepairStopTimeout is how long an interrupted repair is
+	// waited for once it is stopped
+	cassandraRepairStopTimeout = time.Minute
+)
+
+var netstatsModePattern = regexp.MustCompile(`(?m)^Mode:\s*(\S+)`)
+
+type cassandraRepairFunc struct{}
+
+func (*cassandraRepairFunc) Name() string {
+	return "CassandraRepair"
+}
+
+// podExecutor runs a command in a Cassandra node
+type podExecutor func(ctx context.Context, pod string, cmd []string) (string, error)
+
+// repairClock is the part of clock.Clock used by repairs, so that tests can
+// replace it to avoid real sleeps
+type repairClock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+func (*cassandraRepairFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
+	var namespace, pods, container string
+	var keyspaces []string
+	if err := Arg(args, CassandraRepairNamespaceArg, &namespace); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, CassandraRepairPodsArg, &pods); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, CassandraRepairContainerArg, &container); err != nil {
+		return nil, err
+	}
+	if err := OptArg(args, CassandraRepairKeyspacesArg, &keyspaces, []string{}); err != nil {
+		return nil, err
+	}
+	cli, err := kube.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	// kube.Exec cannot be cancelled, so an interrupted repair is ended by
+	// stopping it with nodetool
+	exec := func(ctx context.Context, pod string, cmd []string) (string, error) {
+		stdout, stderr, err := kube.Exec(cli, namespace, pod, container, cmd)
+		format.Log(pod, container, stderr)
+		return stdout, err
+	}
+	durations, err := cassandraRepair(ctx, exec, clock.RealClock{}, strings.Fields(pods), keyspaces, cassandraRepairPollInterval)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(durations))
+	for pod, d := range durations {
+		out[pod] = d.String()
+	}
+	return map[string]interface{}{CassandraRepairDurationsOutput: out}, nil
+}
+
+func (*cassandraRepairFunc) RequiredArgs() []string {
+	return []string{CassandraRepairNamespaceArg, CassandraRepairPodsArg, CassandraRepairContainerArg}
+}
+
+// cassandraRepair runs a full repair on each pod in turn, so that only one
+// node in the ring is repairing at any time. Progress is logged from
+// `nodetool netstats` every pollInterval while a repair is running.
+func cassandraRepair(ctx context.Context, exec podExecutor, clk repairClock, pods, keyspaces []string, pollInterval time.Duration) (map[string]time.Duration, error) {
+	if len(pods) == 0 {
+		return nil, errors.New("No pods specified for repair")
+	}
+	cmd := append([]string{"nodetool", "repair", "--full"}, keyspaces...)
+	durations := make(map[string]time.Duration, len(pods))
+	for _, pod := range pods {
+		out, err := exec(ctx, pod, []string{"nodetool", "netstats"})
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to get status of node %s", pod)
+		}
+		if mode := netstatsMode(out); mode != "NORMAL" {
+			return nil, errors.Errorf("Node %s is in mode %q, expected NORMAL", pod, mode)
+		}
+		start := clk.Now()
+		if err := repairNode(ctx, exec, clk, pod, cmd, pollInterval); err != nil {
+			return nil, err
+		}
+		durations[pod] = clk.Now().Sub(start)
+		log.Infof("Repair of node %s completed in %s", pod, durations[pod])
+	}
+	return durations, nil
+}
+
+// repairResult is the output of a repair command
+type repairResult struct {
+	out string
+	err error
+}
+
+// repairNode runs the repair command on the pod and waits for it to
+// complete. If ctx is done first, the validation compactions of the repair
+// are stopped, which fails the repair on the node and ends the command.
+func repairNode(ctx context.Context, exec podExecutor, clk repairClock, pod string, cmd []string, pollInterval time.Duration) error {
+	done := make(chan repairResult, 1)
+	go func() {
+		out, err := exec(ctx, pod, cmd)
+		done <- repairResult{out, err}
+	}()
+	for {
+		select {
+		case r := <-done:
+			format.Log(pod, "nodetool", r.out)
+			return errors.Wrapf(r.err, "Repair of node %s failed", pod)
+		case <-ctx.Done():
+			stopRepair(exec, clk, pod, done)
+			return errors.Wrapf(ctx.Err(), "Repair of node %s interrupted", pod)
+		case <-clk.After(pollInterval):
+			out, err := exec(ctx, pod, []string{"nodetool", "netstats"})
+			if err != nil {
+				log.WithError(err).Warnf("Failed to get repair progress of node %s", pod)
+				continue
+			}
+			format.Log(pod, "nodetool", out)
+		}
+	}
+}
+
+// stopRepair stops the repair running on the pod, and waits up to
+// cassandraRepairStopTimeout for its command to end
+func stopRepair(exec podExecutor, clk repairClock, pod string, done <-chan repairResult) {
+	// ctx is done, so the command runs without it
+	if _, err := exec(context.Background(), pod, []string{"nodetool", "stop", "--", "VALIDATION"}); err != nil {
+		log.WithError(err).Warnf("Failed to stop the repair of node %s", pod)
+		return
+	}
+	select {
+	case r := <-done:
+		format.Log(pod, "nodetool", r.out)
+	case <-clk.After(cassandraRepairStopTimeout):
+		log.Warnf("Repair of node %s did not stop within %s", pod, cassandraRepairStopTimeout)
+	}
+}
+
+// netstatsMode returns the operating mode reported by `nodetool netstats`
+func netstatsMode(out string) string {
+	m := netstatsModePattern.FindStringSubmatch(out)
+	if m == nil {
+		return ""
+	}
+	return m[1]
+}
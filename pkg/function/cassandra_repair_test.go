@@ -0,0 +1,200 @@
+package function
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type CassandraRepairSuite struct{}
+
+var _ = Suite(&CassandraRepairSuite{})
+
+const netstatsNormal = `Mode: NORMAL
+Not sending any streams.
+Read Repair Statistics:
+Attempted: 0
+Mismatch (Blocking): 0
+Mismatch (Background): 0
+Pool Name                    Active   Pending      Completed   Dropped
+Large messages                  n/a         0              0         0
+Small messages                  n/a         0            112         0
+Gossip messages                 n/a         0           3018         0
+`
+
+// fakeClock advances by a minute every time it is read. Its timers of
+// pollInterval fire at once, and others never do.
+type fakeClock struct {
+	mu           sync.Mutex
+	now          time.Time
+	pollInterval time.Duration
+	waits        []time.Duration
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.now = fc.now.Add(time.Minute)
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.waits = append(fc.waits, d)
+	if d != fc.pollInterval {
+		return nil
+	}
+	ch := make(chan time.Time, 1)
+	ch <- fc.now
+	return ch
+}
+
+// fakeNodetool records the commands run on each pod. Repairs complete once
+// their progress is polled, or fail once they are stopped.
+type fakeNodetool struct {
+	mu         sync.Mutex
+	netstats   map[string]string
+	failRepair string
+	// waitStop makes repairs run until they are stopped
+	waitStop   bool
+	running    int
+	maxRunning int
+	polled     chan struct{}
+	stopped    chan struct{}
+	cmds       []string
+}
+
+func newFakeNodetool() *fakeNodetool {
+	return &fakeNodetool{polled: make(chan struct{}, 1), stopped: make(chan struct{})}
+}
+
+func (f *fakeNodetool) exec(ctx context.Context, pod string, cmd []string) (string, error) {
+	f.mu.Lock()
+	f.cmds = append(f.cmds, pod+": "+strings.Join(cmd, " "))
+	running := f.running
+	f.mu.Unlock()
+	switch cmd[1] {
+	case "netstats":
+		if running != 0 {
+			select {
+			case f.polled <- struct{}{}:
+			default:
+			}
+		}
+		if out, ok := f.netstats[pod]; ok {
+			return out, nil
+		}
+		return netstatsNormal, nil
+	case "stop":
+		close(f.stopped)
+		return "", nil
+	case "repair":
+		f.mu.Lock()
+		f.running++
+		if f.running > f.maxRunning {
+			f.maxRunning = f.running
+		}
+		f.mu.Unlock()
+		var err error
+		if f.waitStop {
+			<-f.stopped
+			err = errors.New("command terminated with exit code 2")
+		} else {
+			<-f.polled
+		}
+		f.mu.Lock()
+		f.running--
+		f.mu.Unlock()
+		if pod == f.failRepair {
+			err = errors.New("command terminated with exit code 2")
+		}
+		if err != nil {
+			return "error: Repair job has failed", err
+		}
+		return "[2018-08-01 10:00:00,000] Repair completed successfully", nil
+	}
+	return "", errors.Errorf("unexpected command %v", cmd)
+}
+
+func (s *CassandraRepairSuite) TestRepairSequential(c *C) {
+	f := newFakeNodetool()
+	clk := &fakeClock{pollInterval: 5 * time.Second}
+	pods := []string{"cassandra-0", "cassandra-1", "cassandra-2"}
+	durations, err := cassandraRepair(context.Background(), f.exec, clk, pods, []string{"ks1", "ks2"}, clk.pollInterval)
+	c.Assert(err, IsNil)
+	c.Assert(durations, DeepEquals, map[string]time.Duration{
+		"cassandra-0": time.Minute,
+		"cassandra-1": time.Minute,
+		"cassandra-2": time.Minute,
+	})
+	c.Assert(f.maxRunning, Equals, 1)
+
+	var repairs, polls int
+	for _, cmd := range f.cmds {
+		switch {
+		case strings.HasSuffix(cmd, "nodetool repair --full ks1 ks2"):
+			repairs++
+		case strings.HasSuffix(cmd, "nodetool netstats"):
+			polls++
+		}
+	}
+	c.Assert(repairs, Equals, 3)
+	// One status check before, and at least one progress check during, each repair
+	c.Assert(polls >= 6, Equals, true)
+	for _, d := range clk.waits {
+		c.Assert(d, Equals, clk.pollInterval)
+	}
+}
+
+func (s *CassandraRepairSuite) TestRepairInterrupted(c *C) {
+	f := newFakeNodetool()
+	f.waitStop = true
+	clk := &fakeClock{pollInterval: time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// Cancel once the repair polls its progress
+		<-f.polled
+		cancel()
+	}()
+	_, err := cassandraRepair(ctx, f.exec, clk, []string{"cassandra-0", "cassandra-1"}, nil, clk.pollInterval)
+	c.Assert(err, ErrorMatches, "Repair of node cassandra-0 interrupted.*")
+	// The repair is stopped, and its command has ended
+	c.Assert(f.running, Equals, 0)
+	var stops int
+	for _, cmd := range f.cmds {
+		if cmd == "cassandra-0: nodetool stop -- VALIDATION" {
+			stops++
+		}
+		c.Check(strings.HasPrefix(cmd, "cassandra-1: nodetool repair"), Equals, false)
+	}
+	c.Assert(stops, Equals, 1)
+}
+
+func (s *CassandraRepairSuite) TestRepairFailure(c *C) {
+	f := newFakeNodetool()
+	f.failRepair = "cassandra-1"
+	clk := &fakeClock{pollInterval: time.Second}
+	pods := []string{"cassandra-0", "cassandra-1", "cassandra-2"}
+	_, err := cassandraRepair(context.Background(), f.exec, clk, pods, nil, clk.pollInterval)
+	c.Assert(err, ErrorMatches, "Repair of node cassandra-1 failed.*")
+	// cassandra-2 must not be repaired after the failure
+	for _, cmd := range f.cmds {
+		c.Check(strings.HasPrefix(cmd, "cassandra-2: nodetool repair"), Equals, false)
+	}
+}
+
+func (s *CassandraRepairSuite) TestRepairNodeNotNormal(c *C) {
+	f := newFakeNodetool()
+	f.netstats = map[string]string{"cassandra-0": "Mode: JOINING\nNot sending any streams."}
+	clk := &fakeClock{pollInterval: time.Second}
+	_, err := cassandraRepair(context.Background(), f.exec, clk, []string{"cassandra-0"}, nil, clk.pollInterval)
+	c.Assert(err, ErrorMatches, `Node cassandra-0 is in mode "JOINING", expected NORMAL`)
+
+	_, err = cassandraRepair(context.Background(), f.exec, clk, nil, nil, clk.pollInterval)
+	c.Assert(err, NotNil)
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/pkg/errors"
 
 	kanister "github.com/kanisterio/kanister/pkg"
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
@@ -63,7 +64,7 @@ func (*deleteDataFunc) Exec(ctx context.Context, tp param.TemplateParams, args m
 	// Generate delete command
 	cmd := generateDeleteCommand(artifact, tp.Profile)
 	// Use KubeTask to delete the artifact
-	return kubeTask(ctx, namespace, "kanisterio/kanister-tools:0.14.0", cmd)
+	return kubeTask(ctx, namespace, "kanisterio/kanister-tools:0.14.0", cmd, output.VerifyNone)
 }
 
 func (*deleteDataFunc) RequiredArgs() []string {
@@ -2,10 +2,12 @@ package function
 
 import (
 	"context"
-	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
 
 	kanister "github.com/kanisterio/kanister/pkg"
 	"github.com/kanisterio/kanister/pkg/format"
@@ -27,6 +29,9 @@ const (
 	KubeExecPodNameArg       = "pod"
 	KubeExecContainerNameArg = "container"
 	KubeExecCommandArg       = "command"
+	// KubeExecVerifyOutputArg enables the verification of the outputs
+	// against the record written by `kando output --record`
+	KubeExecVerifyOutputArg = "verifyOutput"
 )
 
 type kubeExecFunc struct{}
@@ -40,25 +45,83 @@ func parseLogAndCreateOutput(out string) (map[string]interface{}, error) {
 		return nil, nil
 	}
 	var op map[string]interface{}
-	logs := regexp.MustCompile("[\n]").Split(out, -1)
-	for _, l := range logs {
+	for _, l := range strings.Split(out, "\n") {
 		// Log should contain "###Phase-output###:" string
-		if strings.Contains(l, output.PhaseOpString) {
-			if op == nil {
-				op = make(map[string]interface{})
-			}
-			pattern := regexp.MustCompile(`###Phase-output###:(.*?)*$`)
-			match := pattern.FindAllStringSubmatch(l, 1)
-			opObj, err := output.UnmarshalOutput(match[0][1])
-			if err != nil {
-				return nil, err
-			}
-			op[opObj.Key] = opObj.Value
+		i := strings.Index(l, output.PhaseOpString)
+		if i < 0 {
+			continue
 		}
+		if op == nil {
+			op = make(map[string]interface{})
+		}
+		opObj, err := output.UnmarshalOutput(strings.TrimSpace(l[i+len(output.PhaseOpString):]))
+		if err != nil {
+			return nil, err
+		}
+		op[opObj.Key] = opObj.Value
 	}
 	return op, nil
 }
 
+// verifyOutput cross-checks the outputs parsed from the logs against the
+// record left in the container. Discrepancies fail the phase only in strict
+// mode.
+func verifyOutput(mode output.VerifyMode, out map[string]interface{}, record string) error {
+	var recorded map[string]string
+	if record != "" {
+		var err error
+		if recorded, err = output.ParseRecord([]byte(record)); err != nil {
+			return err
+		}
+	}
+	err := output.Verify(out, recorded)
+	if err == nil || mode == output.VerifyStrict {
+		return err
+	}
+	m := err.(*output.ErrOutputMismatch)
+	log.WithFields(log.Fields{
+		"missing":    m.Missing,
+		"mismatched": m.Mismatched,
+		"unrecorded": m.Unrecorded,
+	}).Warn(err.Error())
+	return nil
+}
+
+// verifyModeArg returns the output verification mode of the optional
+// argument argName
+func verifyModeArg(args map[string]interface{}, argName string) (output.VerifyMode, error) {
+	var verify string
+	if err := OptArg(args, argName, &verify, string(output.VerifyNone)); err != nil {
+		return output.VerifyNone, err
+	}
+	mode := output.VerifyMode(verify)
+	return mode, output.ValidateVerifyMode(mode)
+}
+
+// podRecordPath returns the termination message path of the pods of
+// functions that run commands in new pods, so that the record written by
+// `kando output --record` can be read once the pod has completed
+func podRecordPath(mode output.VerifyMode) string {
+	if mode == output.VerifyNone {
+		return ""
+	}
+	return output.RecordPath
+}
+
+// verifyPodOutput cross-checks the outputs parsed from the logs of the
+// completed pod against the record that the pod reports as its termination
+// message
+func verifyPodOutput(ctx context.Context, cli kubernetes.Interface, mode output.VerifyMode, pod *v1.Pod, out map[string]interface{}) error {
+	if mode == output.VerifyNone {
+		return nil
+	}
+	record, err := kube.GetPodTerminationMessage(ctx, cli, pod.Namespace, pod.Name)
+	if err != nil {
+		return errors.Wrap(err, "Failed to fetch output record from the pod")
+	}
+	return verifyOutput(mode, out, record)
+}
+
 func (kef *kubeExecFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
 	cli, err := kube.NewClient()
 	if err != nil {
@@ -78,6 +141,17 @@ func (kef *kubeExecFunc) Exec(ctx context.Context, tp param.TemplateParams, args
 	if err = Arg(args, KubeExecCommandArg, &cmd); err != nil {
 		return nil, err
 	}
+	mode, err := verifyModeArg(args, KubeExecVerifyOutputArg)
+	if err != nil {
+		return nil, err
+	}
+	if mode != output.VerifyNone {
+		// Remove the record of a previous phase run in the same container
+		if _, stderr, err := kube.Exec(cli, namespace, pod, container, []string{"rm", "-f", output.RecordPath}); err != nil {
+			format.Log(pod, container, stderr)
+			return nil, errors.Wrap(err, "Failed to remove output record")
+		}
+	}
 
 	stdout, stderr, err := kube.Exec(cli, namespace, pod, container, cmd)
 	format.Log(pod, container, stdout)
@@ -87,7 +161,17 @@ func (kef *kubeExecFunc) Exec(ctx context.Context, tp param.TemplateParams, args
 	}
 
 	out, err := parseLogAndCreateOutput(stdout)
-	return out, errors.Wrap(err, "Failed to generate output")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to generate output")
+	}
+	if mode != output.VerifyNone {
+		// A missing record is verified as an empty one
+		record, _, _ := kube.Exec(cli, namespace, pod, container, []string{"cat", output.RecordPath})
+		if err := verifyOutput(mode, out, record); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
 }
 
 func (*kubeExecFunc) RequiredArgs() []string {
@@ -14,6 +14,7 @@ import (
 	crv1alpha1 "github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
 	"github.com/kanisterio/kanister/pkg/client/clientset/versioned"
 	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 	"github.com/kanisterio/kanister/pkg/resource"
 	"github.com/kanisterio/kanister/pkg/testutil"
@@ -162,6 +163,9 @@ func (s *KubeExecTest) TestParseLogAndCreateOutput(c *C) {
 			map[string]interface{}{"version": "0.14.0", "path": "/backup/path"}, IsNil, NotNil},
 		{"Random message ###Phase-output###: {\"key\":\"version\",\"value\":\"0.14.0\"}", map[string]interface{}{"version": "0.14.0"}, IsNil, NotNil},
 		{"Random message with newline \n###Phase-output###: {\"key\":\"version\",\"value\":\"0.14.0\"}", map[string]interface{}{"version": "0.14.0"}, IsNil, NotNil},
+		// Numbered outputs are not deduplicated, even if their numbers restart
+		{"###Phase-output###: {\"key\":\"version\",\"value\":\"0.14.0\",\"seq\":2}\n###Phase-output###: {\"key\":\"path\",\"value\":\"/backup/path\",\"seq\":1}",
+			map[string]interface{}{"version": "0.14.0", "path": "/backup/path"}, IsNil, NotNil},
 		{"###Phase-output###: Invalid message", nil, NotNil, IsNil},
 		{"Random message", nil, IsNil, IsNil},
 	} {
@@ -173,3 +177,29 @@ func (s *KubeExecTest) TestParseLogAndCreateOutput(c *C) {
 		}
 	}
 }
+
+func (s *KubeExecTest) TestVerifyOutput(c *C) {
+	lines := []string{
+		"###Phase-output###: {\"key\":\"version\",\"value\":\"0.14.0\"}",
+		"###Phase-output###: {\"key\":\"path\",\"value\":\"/backup/path\"}",
+	}
+	record := `{"version":"0.14.0","path":"/backup/path"}`
+	out, err := parseLogAndCreateOutput(strings.Join(lines, "\n"))
+	c.Assert(err, IsNil)
+	for _, mode := range []output.VerifyMode{output.VerifyWarn, output.VerifyStrict} {
+		c.Check(verifyOutput(mode, out, record), IsNil)
+	}
+
+	// The log collector dropped a line
+	out, err = parseLogAndCreateOutput(lines[0])
+	c.Assert(err, IsNil)
+	err = verifyOutput(output.VerifyStrict, out, record)
+	c.Check(output.IsOutputMismatch(err), Equals, true)
+	c.Check(err, ErrorMatches, ".*missing from logs: path")
+	c.Check(verifyOutput(output.VerifyWarn, out, record), IsNil)
+
+	// Outputs printed without a record
+	err = verifyOutput(output.VerifyStrict, out, "")
+	c.Check(err, ErrorMatches, ".*not recorded: version")
+	c.Check(verifyOutput(output.VerifyStrict, out, "garbage"), ErrorMatches, "Failed to unmarshal output record.*")
+}
@@ -8,6 +8,7 @@ import (
 	kanister "github.com/kanisterio/kanister/pkg"
 	"github.com/kanisterio/kanister/pkg/format"
 	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -18,6 +19,11 @@ const (
 	KubeTaskNamespaceArg = "namespace"
 	KubeTaskImageArg     = "image"
 	KubeTaskCommandArg   = "command"
+	// KubeTaskVerifyOutputArg enables the verification of the outputs
+	// against the record written by `kando output --record`, which the pod
+	// reports as its termination message. Records are limited to 4096
+	// bytes.
+	KubeTaskVerifyOutputArg = "verifyOutput"
 )
 
 func init() {
@@ -37,7 +43,7 @@ func generateJobName(jobPrefix string) string {
 	return jobPrefix + jobNameSuffix
 }
 
-func kubeTask(ctx context.Context, namespace, image string, command []string) (map[string]interface{}, error) {
+func kubeTask(ctx context.Context, namespace, image string, command []string, mode output.VerifyMode) (map[string]interface{}, error) {
 	var serviceAccount string
 	var err error
 	clientset, err := kube.NewClient()
@@ -61,6 +67,8 @@ func kubeTask(ctx context.Context, namespace, image string, command []string) (m
 		Image:              image,
 		Command:            command,
 		ServiceAccountName: serviceAccount,
+
+		TerminationMessagePath: podRecordPath(mode),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to create pod for KubeTask")
@@ -83,7 +91,13 @@ func kubeTask(ctx context.Context, namespace, image string, command []string) (m
 	format.Log(pod.Name, pod.Spec.Containers[0].Name, logs)
 
 	out, err := parseLogAndCreateOutput(logs)
-	return out, errors.Wrap(err, "Failed to parse phase output")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse phase output")
+	}
+	if err := verifyPodOutput(ctx, clientset, mode, pod, out); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 func (ktf *kubeTaskFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
@@ -99,7 +113,11 @@ func (ktf *kubeTaskFunc) Exec(ctx context.Context, tp param.TemplateParams, args
 	if err = OptArg(args, KubeTaskNamespaceArg, &namespace, ""); err != nil {
 		return nil, err
 	}
-	return kubeTask(ctx, namespace, image, command)
+	mode, err := verifyModeArg(args, KubeTaskVerifyOutputArg)
+	if err != nil {
+		return nil, err
+	}
+	return kubeTask(ctx, namespace, image, command, mode)
 }
 
 func (*kubeTaskFunc) RequiredArgs() []string {
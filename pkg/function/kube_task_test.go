@@ -13,6 +13,7 @@ import (
 	kanister "github.com/kanisterio/kanister/pkg"
 	crv1alpha1 "github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
 	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
@@ -105,3 +106,28 @@ func (s *KubeTaskSuite) TestKubeTask(c *C) {
 		}
 	}
 }
+
+func (s *KubeTaskSuite) TestKubeTaskVerifyOutput(c *C) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	args := map[string]interface{}{
+		KubeTaskNamespaceArg: s.namespace,
+		KubeTaskImageArg:     "kanisterio/kanister-tools:0.14.0",
+		// Both outputs are recorded, as `kando output --record` would, but
+		// the log line of version is dropped
+		KubeTaskCommandArg: []string{
+			"sh",
+			"-c",
+			`echo '{"version":"0.14.0","path":"/backup"}' > ` + output.RecordPath + ` && kando output path /backup`,
+		},
+		KubeTaskVerifyOutputArg: string(output.VerifyStrict),
+	}
+	_, err := (&kubeTaskFunc{}).Exec(ctx, param.TemplateParams{}, args)
+	c.Assert(output.IsOutputMismatch(err), Equals, true)
+	c.Assert(err, ErrorMatches, ".*missing from logs: version")
+
+	args[KubeTaskVerifyOutputArg] = string(output.VerifyWarn)
+	out, err := (&kubeTaskFunc{}).Exec(ctx, param.TemplateParams{}, args)
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, map[string]interface{}{"path": "/backup"})
+}
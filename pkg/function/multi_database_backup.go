@@ -0,0 +1,253 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+
+	kanister "github.com/kanisterio/kanister/pkg"
+	"github.com/kanisterio/kanister/pkg/format"
+	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/param"
+)
+
+const (
+	// MultiDatabaseBackupNamespaceArg provides the namespace of the database pod
+	MultiDatabaseBackupNamespaceArg = "namespace"
+	// MultiDatabaseBackupPodArg provides the pod running the database
+	MultiDatabaseBackupPodArg = "pod"
+	// MultiDatabaseBackupContainerArg provides the container in which the dumps are run
+	MultiDatabaseBackupContainerArg = "container"
+	// MultiDatabaseBackupDatabasesArg provides the names of the databases to back up
+	MultiDatabaseBackupDatabasesArg = "databases"
+	// MultiDatabaseBackupArtifactPrefixArg provides the path under which the dumps are stored
+	MultiDatabaseBackupArtifactPrefixArg = "backupArtifactPrefix"
+	// MultiDatabaseBackupDumpCommandArg provides the command that writes the
+	// dump of the database, passed as its last argument, to stdout
+	MultiDatabaseBackupDumpCommandArg = "dumpCommand"
+	// MultiDatabaseBackupMaxConcurrencyArg provides the maximum number of dumps run at once
+	MultiDatabaseBackupMaxConcurrencyArg = "maxConcurrency"
+	// MultiDatabaseBackupManifestOutput is the path of the manifest
+	MultiDatabaseBackupManifestOutput = "manifest"
+	// MultiDatabaseBackupDatabasesOutput maps each database to the path of its dump
+	MultiDatabaseBackupDatabasesOutput = "databases"
+
+	multiDatabaseBackupManifestName = "manifest.json"
+	multiDatabaseBackupDumpName     = "dump"
+	defaultMultiDatabaseDumpCommand = "pg_dump"
+	defaultMultiDatabaseConcurrency = 4
+	multiDatabaseManifestVersion    = 1
+)
+
+func init() {
+	kanister.Register(&multiDatabaseBackupFunc{})
+}
+
+var _ kanister.Func = (*multiDatabaseBackupFunc)(nil)
+
+// multiDatabaseManifest lists the dumps of a MultiDatabaseBackup, so that
+// databases can be restored individually
+type multiDatabaseManifest struct {
+	Version   int                     `json:"version"`
+	Databases []multiDatabaseArtifact `json:"databases"`
+}
+
+type multiDatabaseArtifact struct {
+	Name     string `json:"name"`
+	Artifact string `json:"artifact,omitempty"`
+	// Error is set if the dump of the database failed
+	Error string `json:"error,omitempty"`
+}
+
+// databaseDumper dumps a database to the artifact path in the object store
+type databaseDumper func(ctx context.Context, database, artifact string) error
+
+type multiDatabaseBackupFunc struct{}
+
+func (*multiDatabaseBackupFunc) Name() string {
+	return "MultiDatabaseBackup"
+}
+
+func (*multiDatabaseBackupFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
+	var namespace, pod, container, prefix, dumpCommand string
+	var databases []string
+	var maxConcurrency int
+	if err := Arg(args, MultiDatabaseBackupNamespaceArg, &namespace); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, MultiDatabaseBackupPodArg, &pod); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, MultiDatabaseBackupContainerArg, &container); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, MultiDatabaseBackupDatabasesArg, &databases); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, MultiDatabaseBackupArtifactPrefixArg, &prefix); err != nil {
+		return nil, err
+	}
+	if err := OptArg(args, MultiDatabaseBackupDumpCommandArg, &dumpCommand, defaultMultiDatabaseDumpCommand); err != nil {
+		return nil, err
+	}
+	if err := OptArg(args, MultiDatabaseBackupMaxConcurrencyArg, &maxConcurrency, defaultMultiDatabaseConcurrency); err != nil {
+		return nil, err
+	}
+	// Fail early, before any dump is started, if the manifest cannot be written
+	bucket, err := profileBucket(ctx, tp.Profile)
+	if err != nil {
+		return nil, err
+	}
+	profile, err := json.Marshal(tp.Profile)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode Profile")
+	}
+	cli, err := kube.NewClient()
+	if err != nil {
+		return nil, err
+	}
+	// kube.ExecWithOptions cannot be cancelled, so a dump that is running
+	// when ctx is done completes, and only the dumps that have not started
+	// are skipped
+	dump := func(ctx context.Context, database, artifact string) error {
+		opts := kube.ExecOptions{
+			Command:       []string{"bash", "-o", "errexit", "-o", "pipefail", "-c", dumpScript(dumpCommand, database, artifact)},
+			Namespace:     namespace,
+			PodName:       pod,
+			ContainerName: container,
+			// The Profile holds credentials, so it is passed on stdin
+			// rather than on the command line of the container
+			Stdin:         bytes.NewReader(profile),
+			CaptureStdout: true,
+			CaptureStderr: true,
+		}
+		stdout, stderr, err := kube.ExecWithOptions(cli, opts)
+		format.Log(pod, container, stdout)
+		format.Log(pod, container, stderr)
+		return err
+	}
+	m, dumpErr := multiDatabaseBackup(ctx, dump, databases, prefix, maxConcurrency)
+	if m == nil {
+		return nil, dumpErr
+	}
+	// The manifest is written even if some dumps failed, so that the
+	// successful ones can be restored
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode manifest")
+	}
+	manifest := path.Join(prefix, multiDatabaseBackupManifestName)
+	if err := bucket.PutBytes(ctx, profileArtifactPath(tp.Profile, manifest), data, nil); err != nil {
+		return nil, errors.Wrapf(err, "Failed to upload manifest to %s", manifest)
+	}
+	if dumpErr != nil {
+		return nil, dumpErr
+	}
+	out := make(map[string]interface{}, len(m.Databases))
+	for _, db := range m.Databases {
+		out[db.Name] = db.Artifact
+	}
+	return map[string]interface{}{
+		MultiDatabaseBackupManifestOutput:  manifest,
+		MultiDatabaseBackupDatabasesOutput: out,
+	}, nil
+}
+
+func (*multiDatabaseBackupFunc) RequiredArgs() []string {
+	return []string{
+		MultiDatabaseBackupNamespaceArg,
+		MultiDatabaseBackupPodArg,
+		MultiDatabaseBackupContainerArg,
+		MultiDatabaseBackupDatabasesArg,
+		MultiDatabaseBackupArtifactPrefixArg,
+	}
+}
+
+// multiDatabaseBackup dumps the databases, running at most maxConcurrency
+// dumps at once. A failed dump does not stop the others. The manifest lists
+// every database, and an error naming the failed databases is returned with
+// it. A nil manifest means that no dump was attempted.
+func multiDatabaseBackup(ctx context.Context, dump databaseDumper, databases []string, prefix string, maxConcurrency int) (*multiDatabaseManifest, error) {
+	if err := validateDatabaseNames(databases); err != nil {
+		return nil, err
+	}
+	if maxConcurrency < 1 {
+		return nil, errors.Errorf("Invalid maximum concurrency %d", maxConcurrency)
+	}
+	m := &multiDatabaseManifest{
+		Version:   multiDatabaseManifestVersion,
+		Databases: make([]multiDatabaseArtifact, len(databases)),
+	}
+	sem := make(chan struct{}, maxConcurrency)
+	var wg sync.WaitGroup
+	for i, db := range databases {
+		m.Databases[i].Name = db
+		wg.Add(1)
+		go func(a *multiDatabaseArtifact) {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				a.Error = ctx.Err().Error()
+				return
+			}
+			defer func() { <-sem }()
+			artifact := path.Join(prefix, a.Name, multiDatabaseBackupDumpName)
+			if err := dump(ctx, a.Name, artifact); err != nil {
+				log.WithError(err).Errorf("Failed to back up database %s", a.Name)
+				a.Error = err.Error()
+				return
+			}
+			log.Infof("Backed up database %s to %s", a.Name, artifact)
+			a.Artifact = artifact
+		}(&m.Databases[i])
+	}
+	wg.Wait()
+	var failed []string
+	for _, a := range m.Databases {
+		if a.Error != "" {
+			failed = append(failed, a.Name)
+		}
+	}
+	if len(failed) != 0 {
+		return m, errors.Errorf("Failed to back up %d of %d databases: %s", len(failed), len(databases), strings.Join(failed, ", "))
+	}
+	return m, nil
+}
+
+func validateDatabaseNames(databases []string) error {
+	if len(databases) == 0 {
+		return errors.New("No databases specified for backup")
+	}
+	seen := make(map[string]bool, len(databases))
+	for _, db := range databases {
+		if db == "" || strings.Contains(db, "/") || db == "." || db == ".." {
+			return errors.Errorf("Invalid database name %q", db)
+		}
+		if seen[db] {
+			return errors.Errorf("Database %s is listed more than once", db)
+		}
+		seen[db] = true
+	}
+	return nil
+}
+
+// dumpScript pipes the dump of the database to kando, which uploads it to
+// the object store. The Profile is read from stdin into $KANDO_PROFILE, from
+// which kando reads it.
+func dumpScript(dumpCommand, database, artifact string) string {
+	return fmt.Sprintf(`export KANDO_PROFILE="$(cat)"; %s %s | kando location push --path %s -`,
+		dumpCommand, shellQuote(database), shellQuote(artifact))
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,123 @@
+package function
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type MultiDatabaseBackupSuite struct{}
+
+var _ = Suite(&MultiDatabaseBackupSuite{})
+
+// fakeDumper records the dumps and the number of dumps running at once
+type fakeDumper struct {
+	mu         sync.Mutex
+	fail       map[string]bool
+	running    int
+	maxRunning int
+	artifacts  map[string]string
+}
+
+func (f *fakeDumper) dump(ctx context.Context, database, artifact string) error {
+	f.mu.Lock()
+	f.running++
+	if f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+	f.mu.Unlock()
+	time.Sleep(10 * time.Millisecond)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running--
+	if f.fail[database] {
+		return errors.New("pg_dump: connection refused")
+	}
+	if f.artifacts == nil {
+		f.artifacts = make(map[string]string)
+	}
+	f.artifacts[database] = artifact
+	return nil
+}
+
+func (s *MultiDatabaseBackupSuite) TestConcurrency(c *C) {
+	databases := []string{"db0", "db1", "db2", "db3", "db4", "db5", "db6", "db7", "db8", "db9"}
+	f := &fakeDumper{}
+	m, err := multiDatabaseBackup(context.Background(), f.dump, databases, "backups/pg", 3)
+	c.Assert(err, IsNil)
+	c.Assert(f.maxRunning, Equals, 3)
+	c.Assert(m.Version, Equals, multiDatabaseManifestVersion)
+	c.Assert(m.Databases, HasLen, len(databases))
+	for i, db := range m.Databases {
+		// The order of the manifest is the order of the argument
+		c.Assert(db.Name, Equals, databases[i])
+		c.Assert(db.Artifact, Equals, "backups/pg/"+db.Name+"/dump")
+		c.Assert(db.Error, Equals, "")
+		c.Assert(f.artifacts[db.Name], Equals, db.Artifact)
+	}
+}
+
+func (s *MultiDatabaseBackupSuite) TestPartialFailure(c *C) {
+	f := &fakeDumper{fail: map[string]bool{"orders": true, "users": true}}
+	m, err := multiDatabaseBackup(context.Background(), f.dump, []string{"users", "orders", "stock"}, "p", 1)
+	c.Assert(err, ErrorMatches, "Failed to back up 2 of 3 databases: users, orders")
+	c.Assert(f.maxRunning, Equals, 1)
+	// The manifest still records the successful dump
+	c.Assert(m, NotNil)
+	c.Assert(m.Databases, DeepEquals, []multiDatabaseArtifact{
+		{Name: "users", Error: "pg_dump: connection refused"},
+		{Name: "orders", Error: "pg_dump: connection refused"},
+		{Name: "stock", Artifact: "p/stock/dump"},
+	})
+}
+
+func (s *MultiDatabaseBackupSuite) TestCanceled(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dump := func(ctx context.Context, database, artifact string) error { return ctx.Err() }
+	m, err := multiDatabaseBackup(ctx, dump, []string{"a", "b"}, "p", 1)
+	c.Assert(err, ErrorMatches, "Failed to back up 2 of 2 databases: a, b")
+	for _, db := range m.Databases {
+		c.Assert(db.Error, Equals, "context canceled")
+	}
+}
+
+func (s *MultiDatabaseBackupSuite) TestInvalidArgs(c *C) {
+	ctx := context.Background()
+	f := &fakeDumper{}
+	for _, tc := range []struct {
+		databases   []string
+		concurrency int
+		err         string
+	}{
+		{nil, 1, "No databases specified for backup"},
+		{[]string{"a", ""}, 1, `Invalid database name ""`},
+		{[]string{"../a"}, 1, `Invalid database name "../a"`},
+		{[]string{".."}, 1, `Invalid database name ".."`},
+		{[]string{"a", "b", "a"}, 1, "Database a is listed more than once"},
+		{[]string{"a"}, 0, "Invalid maximum concurrency 0"},
+	} {
+		m, err := multiDatabaseBackup(ctx, f.dump, tc.databases, "p", tc.concurrency)
+		c.Assert(err, ErrorMatches, tc.err)
+		c.Assert(m, IsNil)
+	}
+	c.Assert(f.artifacts, HasLen, 0)
+}
+
+func (s *MultiDatabaseBackupSuite) TestDumpScript(c *C) {
+	script := dumpScript("echo", "it's", "p/it's/dump")
+	c.Assert(script, Equals, `export KANDO_PROFILE="$(cat)"; echo 'it'\''s' | kando location push --path 'p/it'\''s/dump' -`)
+	// The Profile on stdin reaches kando in the environment, and the quoted
+	// words reach the commands unchanged
+	kando := `kando() { printf '%s\n' "$KANDO_PROFILE" "$@"; cat; }; `
+	cmd := exec.Command("sh", "-c", kando+script)
+	cmd.Stdin = strings.NewReader(`{"a":"b'c"}`)
+	out, err := cmd.Output()
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "{\"a\":\"b'c\"}\nlocation\npush\n--path\np/it's/dump\n-\nit's\n")
+}
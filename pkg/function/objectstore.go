@@ -0,0 +1,35 @@
+package function
+
+import (
+	"context"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/kanisterio/kanister/pkg/location"
+	"github.com/kanisterio/kanister/pkg/objectstore"
+	"github.com/kanisterio/kanister/pkg/param"
+)
+
+// profileBucket returns a handle to the bucket referenced by the profile
+func profileBucket(ctx context.Context, profile *param.Profile) (objectstore.Bucket, error) {
+	if err := validateProfile(profile); err != nil {
+		return nil, errors.Wrapf(err, "Failed to validate Profile")
+	}
+	return location.Bucket(ctx, *profile)
+}
+
+// profileEncryptedBucket returns a handle to the bucket referenced by the
+// profile, which encrypts and decrypts objects with the keyring
+func profileEncryptedBucket(ctx context.Context, profile *param.Profile, keyring *objectstore.Keyring) (objectstore.Bucket, error) {
+	if err := validateProfile(profile); err != nil {
+		return nil, errors.Wrapf(err, "Failed to validate Profile")
+	}
+	return location.EncryptedBucket(ctx, *profile, keyring)
+}
+
+// profileArtifactPath returns the absolute path of an artifact in the
+// profile's bucket
+func profileArtifactPath(profile *param.Profile, artifact string) string {
+	return path.Join("/", profile.Location.S3Compliant.Prefix, artifact)
+}
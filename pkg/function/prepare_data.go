@@ -12,6 +12,7 @@ import (
 	kanister "github.com/kanisterio/kanister/pkg"
 	"github.com/kanisterio/kanister/pkg/format"
 	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
@@ -23,6 +24,10 @@ const (
 	PrepareDataCommandArg     = "command"
 	PrepareDataVolumes        = "volumes"
 	PrepareDataServiceAccount = "serviceaccount"
+	// PrepareDataVerifyOutputArg enables the verification of the outputs
+	// against the record written by `kando output --record`, like
+	// KubeTaskVerifyOutputArg
+	PrepareDataVerifyOutputArg = "verifyOutput"
 )
 
 func init() {
@@ -59,7 +64,7 @@ func getVolumes(tp param.TemplateParams) (map[string]string, error) {
 	return vols, nil
 }
 
-func prepareData(ctx context.Context, cli kubernetes.Interface, namespace, serviceAccount, image string, vols map[string]string, command ...string) (map[string]interface{}, error) {
+func prepareData(ctx context.Context, cli kubernetes.Interface, namespace, serviceAccount, image string, vols map[string]string, mode output.VerifyMode, command ...string) (map[string]interface{}, error) {
 	// Validate volumes
 	for pvc := range vols {
 		if _, err := cli.CoreV1().PersistentVolumeClaims(namespace).Get(pvc, metav1.GetOptions{}); err != nil {
@@ -73,6 +78,8 @@ func prepareData(ctx context.Context, cli kubernetes.Interface, namespace, servi
 		Command:            command,
 		Volumes:            vols,
 		ServiceAccountName: serviceAccount,
+
+		TerminationMessagePath: podRecordPath(mode),
 	})
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to create pod to run prepare data job")
@@ -95,7 +102,13 @@ func prepareData(ctx context.Context, cli kubernetes.Interface, namespace, servi
 	format.Log(pod.Name, pod.Spec.Containers[0].Name, logs)
 
 	out, err := parseLogAndCreateOutput(logs)
-	return out, errors.Wrap(err, "Failed to parse phase output")
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to parse phase output")
+	}
+	if err := verifyPodOutput(ctx, cli, mode, pod, out); err != nil {
+		return nil, err
+	}
+	return out, nil
 }
 
 func (*prepareDataFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
@@ -118,6 +131,10 @@ func (*prepareDataFunc) Exec(ctx context.Context, tp param.TemplateParams, args
 	if err = OptArg(args, PrepareDataServiceAccount, &serviceAccount, ""); err != nil {
 		return nil, err
 	}
+	mode, err := verifyModeArg(args, PrepareDataVerifyOutputArg)
+	if err != nil {
+		return nil, err
+	}
 	cli, err := kube.NewClient()
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to create Kubernetes client")
@@ -127,7 +144,7 @@ func (*prepareDataFunc) Exec(ctx context.Context, tp param.TemplateParams, args
 			return nil, err
 		}
 	}
-	return prepareData(ctx, cli, namespace, serviceAccount, image, vols, command...)
+	return prepareData(ctx, cli, namespace, serviceAccount, image, vols, mode, command...)
 }
 
 func (*prepareDataFunc) RequiredArgs() []string {
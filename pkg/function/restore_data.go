@@ -7,6 +7,7 @@ import (
 
 	kanister "github.com/kanisterio/kanister/pkg"
 	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 	"github.com/kanisterio/kanister/pkg/restic"
 )
@@ -115,7 +116,7 @@ func (*restoreDataFunc) Exec(ctx context.Context, tp param.TemplateParams, args
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to create Kubernetes client")
 	}
-	return prepareData(ctx, cli, namespace, "", image, vols, cmd...)
+	return prepareData(ctx, cli, namespace, "", image, vols, output.VerifyNone, cmd...)
 }
 
 func (*restoreDataFunc) RequiredArgs() []string {
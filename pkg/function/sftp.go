@@ -0,0 +1,349 @@
+package function
+
+import (
+	"context"
+	"io"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kanister "github.com/kanisterio/kanister/pkg"
+	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/objectstore"
+	"github.com/kanisterio/kanister/pkg/param"
+)
+
+const (
+	// SFTPNamespaceArg provides the namespace of the credentials Secret
+	SFTPNamespaceArg = "namespace"
+	// SFTPSecretArg provides the name of the Secret with the SFTP credentials
+	SFTPSecretArg = "secret"
+	// SFTPHostArg provides the address of the SFTP server as host[:port]
+	SFTPHostArg = "host"
+	// SFTPPathArg provides the path of the file or directory on the SFTP server
+	SFTPPathArg = "path"
+	// SFTPArtifactPrefixArg provides the path of the data in the object store
+	SFTPArtifactPrefixArg = "artifactPrefix"
+	// SFTPInsecureSkipHostKeyVerificationArg allows connecting to the SFTP
+	// server without verifying its identity when the credentials Secret has
+	// no host key
+	SFTPInsecureSkipHostKeyVerificationArg = "insecureSkipHostKeyVerification"
+	// SFTPOutputArtifactPrefix is the path of the data in the object store
+	SFTPOutputArtifactPrefix = "artifactPrefix"
+	// SFTPOutputFileCount is the number of files that were transferred
+	SFTPOutputFileCount = "fileCount"
+
+	// Keys in the credentials Secret
+	sftpSecretUsernameKey   = "username"
+	sftpSecretPasswordKey   = "password"
+	sftpSecretPrivateKeyKey = "privateKey"
+	sftpSecretHostKeyKey    = "hostKey"
+
+	sftpDefaultPort = "22"
+)
+
+func init() {
+	kanister.Register(&sftpToObjectStoreFunc{})
+	kanister.Register(&objectStoreToSFTPFunc{})
+}
+
+var (
+	_ kanister.Func = (*sftpToObjectStoreFunc)(nil)
+	_ kanister.Func = (*objectStoreToSFTPFunc)(nil)
+)
+
+type sftpToObjectStoreFunc struct{}
+
+func (*sftpToObjectStoreFunc) Name() string {
+	return "SFTPToObjectStore"
+}
+
+func (*sftpToObjectStoreFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
+	sa, err := parseSFTPArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := profileBucket(ctx, tp.Profile)
+	if err != nil {
+		return nil, err
+	}
+	cli, closeFn, err := newSFTPClient(ctx, sa)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	dir, err := bucket.CreateDirectory(ctx, profileArtifactPath(tp.Profile, sa.artifactPrefix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create directory %s in object store", sa.artifactPrefix)
+	}
+	n, err := sftpToDirectory(ctx, cli, sa.path, dir)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		SFTPOutputArtifactPrefix: sa.artifactPrefix,
+		SFTPOutputFileCount:      n,
+	}, nil
+}
+
+func (*sftpToObjectStoreFunc) RequiredArgs() []string {
+	return []string{SFTPNamespaceArg, SFTPSecretArg, SFTPHostArg, SFTPPathArg, SFTPArtifactPrefixArg}
+}
+
+type objectStoreToSFTPFunc struct{}
+
+func (*objectStoreToSFTPFunc) Name() string {
+	return "ObjectStoreToSFTP"
+}
+
+func (*objectStoreToSFTPFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
+	sa, err := parseSFTPArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := profileBucket(ctx, tp.Profile)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := bucket.GetDirectory(ctx, profileArtifactPath(tp.Profile, sa.artifactPrefix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to find %s in object store", sa.artifactPrefix)
+	}
+	cli, closeFn, err := newSFTPClient(ctx, sa)
+	if err != nil {
+		return nil, err
+	}
+	defer closeFn()
+	n, err := directoryToSFTP(ctx, dir, cli, sa.path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		SFTPOutputArtifactPrefix: sa.artifactPrefix,
+		SFTPOutputFileCount:      n,
+	}, nil
+}
+
+func (*objectStoreToSFTPFunc) RequiredArgs() []string {
+	return []string{SFTPNamespaceArg, SFTPSecretArg, SFTPHostArg, SFTPPathArg, SFTPArtifactPrefixArg}
+}
+
+type sftpArgs struct {
+	namespace      string
+	secret         string
+	host           string
+	path           string
+	artifactPrefix string
+	insecure       bool
+}
+
+func parseSFTPArgs(args map[string]interface{}) (*sftpArgs, error) {
+	sa := &sftpArgs{}
+	if err := Arg(args, SFTPNamespaceArg, &sa.namespace); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, SFTPSecretArg, &sa.secret); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, SFTPHostArg, &sa.host); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, SFTPPathArg, &sa.path); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, SFTPArtifactPrefixArg, &sa.artifactPrefix); err != nil {
+		return nil, err
+	}
+	if err := OptArg(args, SFTPInsecureSkipHostKeyVerificationArg, &sa.insecure, false); err != nil {
+		return nil, err
+	}
+	return sa, nil
+}
+
+// sftpClientConfig builds the SSH configuration from the credentials Secret.
+// Password and private key authentication are supported. The server is
+// verified with the public key in the Secret, which may only be omitted if
+// insecure is set.
+func sftpClientConfig(data map[string][]byte, insecure bool) (*ssh.ClientConfig, error) {
+	user, ok := data[sftpSecretUsernameKey]
+	if !ok {
+		return nil, errors.Errorf("Key '%s' not found in SFTP secret", sftpSecretUsernameKey)
+	}
+	var auth []ssh.AuthMethod
+	if key, ok := data[sftpSecretPrivateKeyKey]; ok {
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse SFTP private key")
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password, ok := data[sftpSecretPasswordKey]; ok {
+		auth = append(auth, ssh.Password(string(password)))
+	}
+	if len(auth) == 0 {
+		return nil, errors.Errorf("SFTP secret must include '%s' or '%s'", sftpSecretPasswordKey, sftpSecretPrivateKeyKey)
+	}
+	var hostKeyCallback ssh.HostKeyCallback
+	switch hk, ok := data[sftpSecretHostKeyKey]; {
+	case ok:
+		pk, _, _, _, err := ssh.ParseAuthorizedKey(hk)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to parse SFTP host key")
+		}
+		hostKeyCallback = ssh.FixedHostKey(pk)
+	case insecure:
+		log.Warnf("SFTP secret does not include '%s'. The server identity will not be verified", sftpSecretHostKeyKey)
+		hostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		return nil, errors.Errorf("Key '%s' not found in SFTP secret. Set '%s' to connect without verifying the server", sftpSecretHostKeyKey, SFTPInsecureSkipHostKeyVerificationArg)
+	}
+	return &ssh.ClientConfig{
+		User:            string(user),
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+func newSFTPClient(ctx context.Context, sa *sftpArgs) (*sftp.Client, func(), error) {
+	cli, err := kube.NewClient()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to create Kubernetes client")
+	}
+	s, err := cli.CoreV1().Secrets(sa.namespace).Get(sa.secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to get secret %s:%s", sa.namespace, sa.secret)
+	}
+	config, err := sftpClientConfig(s.Data, sa.insecure)
+	if err != nil {
+		return nil, nil, err
+	}
+	host := sa.host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		host = net.JoinHostPort(host, sftpDefaultPort)
+	}
+	conn, err := ssh.Dial("tcp", host, config)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to connect to %s", host)
+	}
+	sc, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, errors.Wrapf(err, "Failed to start SFTP session with %s", host)
+	}
+	return sc, func() {
+		_ = sc.Close()
+		_ = conn.Close()
+	}, nil
+}
+
+// sftpToDirectory copies the file or directory tree at remotePath into d,
+// preserving the directory structure. It returns the number of files copied.
+func sftpToDirectory(ctx context.Context, cli *sftp.Client, remotePath string, d objectstore.Directory) (int, error) {
+	remotePath = path.Clean(remotePath)
+	dirs := map[string]objectstore.Directory{".": d}
+	var n int
+	w := cli.Walk(remotePath)
+	for w.Step() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := w.Err(); err != nil {
+			return n, errors.Wrapf(err, "Failed to walk %s", w.Path())
+		}
+		fi := w.Stat()
+		rel := strings.TrimPrefix(strings.TrimPrefix(w.Path(), remotePath), "/")
+		if rel == "" {
+			if fi.IsDir() {
+				continue
+			}
+			// remotePath is a single file
+			rel = path.Base(remotePath)
+		}
+		parent, ok := dirs[path.Dir(rel)]
+		if !ok {
+			return n, errors.Errorf("Parent directory of %s not found", w.Path())
+		}
+		switch {
+		case fi.IsDir():
+			sd, err := parent.CreateDirectory(ctx, path.Base(rel))
+			if err != nil {
+				return n, errors.Wrapf(err, "Failed to create directory %s", rel)
+			}
+			dirs[rel] = sd
+		case fi.Mode().IsRegular():
+			if err := sftpUploadFile(ctx, cli, w.Path(), fi.Size(), parent, path.Base(rel)); err != nil {
+				return n, err
+			}
+			n++
+		default:
+			log.Warnf("Skipping %s, not a regular file or directory", w.Path())
+		}
+	}
+	return n, nil
+}
+
+func sftpUploadFile(ctx context.Context, cli *sftp.Client, remotePath string, size int64, d objectstore.Directory, name string) error {
+	f, err := cli.Open(remotePath)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to open %s", remotePath)
+	}
+	defer f.Close()
+	return errors.Wrapf(d.Put(ctx, name, f, size, nil), "Failed to upload %s", remotePath)
+}
+
+// directoryToSFTP copies all objects in d to remotePath, recreating the
+// directory structure. It returns the number of files copied.
+func directoryToSFTP(ctx context.Context, d objectstore.Directory, cli *sftp.Client, remotePath string) (int, error) {
+	if err := cli.MkdirAll(remotePath); err != nil {
+		return 0, errors.Wrapf(err, "Failed to create %s", remotePath)
+	}
+	objs, err := d.ListObjects(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, obj := range objs {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if err := sftpDownloadFile(ctx, d, obj, cli, path.Join(remotePath, obj)); err != nil {
+			return n, err
+		}
+		n++
+	}
+	dirs, err := d.ListDirectories(ctx)
+	if err != nil {
+		return n, err
+	}
+	for name, sd := range dirs {
+		sn, err := directoryToSFTP(ctx, sd, cli, path.Join(remotePath, name))
+		n += sn
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func sftpDownloadFile(ctx context.Context, d objectstore.Directory, name string, cli *sftp.Client, remotePath string) error {
+	r, _, err := d.Get(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to get %s", name)
+	}
+	defer r.Close()
+	f, err := cli.Create(remotePath)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create %s", remotePath)
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "Failed to write %s", remotePath)
+	}
+	return errors.Wrapf(f.Close(), "Failed to write %s", remotePath)
+}
@@ -0,0 +1,175 @@
+package function
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+	. "gopkg.in/check.v1"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+type SFTPSuite struct {
+	cli    *sftp.Client
+	server *sftp.Server
+}
+
+var _ = Suite(&SFTPSuite{})
+
+// SetUpTest connects a client to an in-process SFTP server over pipes. The
+// server serves the local filesystem.
+func (s *SFTPSuite) SetUpTest(c *C) {
+	cr, sw := io.Pipe()
+	sr, cw := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{sr, sw})
+	c.Assert(err, IsNil)
+	go server.Serve()
+	cli, err := sftp.NewClientPipe(cr, cw)
+	c.Assert(err, IsNil)
+	s.cli = cli
+	s.server = server
+}
+
+func (s *SFTPSuite) TearDownTest(c *C) {
+	// Closing the server ends the client's receive loop
+	if s.server != nil {
+		_ = s.server.Close()
+	}
+	if s.cli != nil {
+		_ = s.cli.Close()
+	}
+}
+
+func (s *SFTPSuite) TestSFTPRoundTrip(c *C) {
+	ctx := context.Background()
+	src := c.MkDir()
+	files := map[string]string{
+		"file1":             "content1",
+		"dir1/file2":        "content2",
+		"dir1/dir2/file3":   "content3",
+		"dir3/file4":        "",
+		"dir1/dir2/file5.x": "content5",
+	}
+	for name, content := range files {
+		p := filepath.Join(src, name)
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte(content), 0644), IsNil)
+	}
+	c.Assert(os.MkdirAll(filepath.Join(src, "empty"), 0755), IsNil)
+
+	d := newMemDirectory()
+	n, err := sftpToDirectory(ctx, s.cli, src, d)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(files))
+	c.Assert(d.dirs, HasLen, 3)
+	c.Assert(d.dirs["dir1"].dirs["dir2"].objects["file3"], DeepEquals, []byte("content3"))
+
+	dst := filepath.Join(c.MkDir(), "restore")
+	n, err = directoryToSFTP(ctx, d, s.cli, dst)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, len(files))
+	for name, content := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dst, name))
+		c.Assert(err, IsNil)
+		c.Check(string(data), Equals, content)
+	}
+	fi, err := os.Stat(filepath.Join(dst, "empty"))
+	c.Assert(err, IsNil)
+	c.Assert(fi.IsDir(), Equals, true)
+}
+
+func (s *SFTPSuite) TestSFTPSingleFile(c *C) {
+	ctx := context.Background()
+	p := filepath.Join(c.MkDir(), "single")
+	c.Assert(ioutil.WriteFile(p, []byte("content"), 0644), IsNil)
+	d := newMemDirectory()
+	n, err := sftpToDirectory(ctx, s.cli, p, d)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(d.objects["single"], DeepEquals, []byte("content"))
+}
+
+func (s *SFTPSuite) TestSFTPClientConfig(c *C) {
+	const hostKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIOMqqnkVzrm0SdG6UOoqKLsabgH5C9okWi0dh2l9GKJl"
+	for _, tc := range []struct {
+		data     map[string][]byte
+		insecure bool
+		checker  Checker
+	}{
+		{map[string][]byte{"username": []byte("u"), "password": []byte("p"), "hostKey": []byte(hostKey)}, false, IsNil},
+		{map[string][]byte{"username": []byte("u"), "password": []byte("p")}, true, IsNil},
+		// The server must be verified unless explicitly allowed not to be
+		{map[string][]byte{"username": []byte("u"), "password": []byte("p")}, false, NotNil},
+		{map[string][]byte{"username": []byte("u")}, true, NotNil},
+		{map[string][]byte{"password": []byte("p")}, true, NotNil},
+		{map[string][]byte{"username": []byte("u"), "privateKey": []byte("invalid")}, true, NotNil},
+		{map[string][]byte{"username": []byte("u"), "password": []byte("p"), "hostKey": []byte("invalid")}, true, NotNil},
+	} {
+		_, err := sftpClientConfig(tc.data, tc.insecure)
+		c.Check(err, tc.checker)
+	}
+}
+
+// memDirectory is a minimal in-memory objectstore.Directory
+type memDirectory struct {
+	objectstore.Directory
+	objects map[string][]byte
+	dirs    map[string]*memDirectory
+}
+
+func newMemDirectory() *memDirectory {
+	return &memDirectory{
+		objects: make(map[string][]byte),
+		dirs:    make(map[string]*memDirectory),
+	}
+}
+
+func (d *memDirectory) CreateDirectory(ctx context.Context, name string) (objectstore.Directory, error) {
+	sd, ok := d.dirs[name]
+	if !ok {
+		sd = newMemDirectory()
+		d.dirs[name] = sd
+	}
+	return sd, nil
+}
+
+func (d *memDirectory) ListDirectories(ctx context.Context) (map[string]objectstore.Directory, error) {
+	dirs := make(map[string]objectstore.Directory, len(d.dirs))
+	for name, sd := range d.dirs {
+		dirs[name] = sd
+	}
+	return dirs, nil
+}
+
+func (d *memDirectory) ListObjects(ctx context.Context) ([]string, error) {
+	objs := make([]string, 0, len(d.objects))
+	for name := range d.objects {
+		objs = append(objs, name)
+	}
+	return objs, nil
+}
+
+func (d *memDirectory) Get(ctx context.Context, name string) (io.ReadCloser, map[string]string, error) {
+	data, ok := d.objects[name]
+	if !ok {
+		return nil, nil, os.ErrNotExist
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil, nil
+}
+
+func (d *memDirectory) Put(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	d.objects[name] = data
+	return nil
+}
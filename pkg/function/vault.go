@@ -0,0 +1,334 @@
+package function
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	kanister "github.com/kanisterio/kanister/pkg"
+	"github.com/kanisterio/kanister/pkg/kube"
+	"github.com/kanisterio/kanister/pkg/objectstore"
+	"github.com/kanisterio/kanister/pkg/param"
+)
+
+const (
+	// VaultNamespaceArg provides the namespace of the credentials Secret
+	VaultNamespaceArg = "namespace"
+	// VaultSecretArg provides the name of the Secret with the Vault token
+	// and the encryption key
+	VaultSecretArg = "secret"
+	// VaultAddressArg provides the address of the Vault server
+	VaultAddressArg = "address"
+	// VaultMountPathArg provides the mount path of the KV secrets engine
+	VaultMountPathArg = "mountPath"
+	// VaultPathArg provides the path, under the mount path, of the secrets
+	VaultPathArg = "path"
+	// VaultKVVersionArg provides the version of the KV secrets engine
+	VaultKVVersionArg = "kvVersion"
+	// VaultArtifactArg provides the path of the backup in the object store
+	VaultArtifactArg = "artifact"
+	// VaultOutputArtifact is the path of the backup in the object store
+	VaultOutputArtifact = "artifact"
+	// VaultOutputSecretCount is the number of secrets backed up or restored
+	VaultOutputSecretCount = "secretCount"
+
+	// Keys in the credentials Secret
+	vaultSecretTokenKey         = "token"
+	vaultSecretEncryptionKeyKey = "encryptionKey"
+
+	// ID of the encryption key in the keyring of the backup bucket
+	vaultEncryptionKeyID = "vault"
+)
+
+func init() {
+	kanister.Register(&vaultSecretsBackupFunc{})
+	kanister.Register(&vaultSecretsRestoreFunc{})
+}
+
+var (
+	_ kanister.Func = (*vaultSecretsBackupFunc)(nil)
+	_ kanister.Func = (*vaultSecretsRestoreFunc)(nil)
+)
+
+// vaultBackup is the format of the secrets in a backup. Backups are
+// encrypted on the client by the keyring of the bucket.
+type vaultBackup struct {
+	MountPath string                            `json:"mountPath"`
+	Path      string                            `json:"path"`
+	Secrets   map[string]map[string]interface{} `json:"secrets"`
+}
+
+type vaultSecretsBackupFunc struct{}
+
+func (*vaultSecretsBackupFunc) Name() string {
+	return "VaultSecretsBackup"
+}
+
+func (*vaultSecretsBackupFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
+	va, err := parseVaultArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	cli, keyring, err := newVaultClient(va)
+	if err != nil {
+		return nil, err
+	}
+	secrets, err := readVaultSecrets(cli.Logical(), va.kv, va.path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(vaultBackup{
+		MountPath: va.kv.mountPath,
+		Path:      va.path,
+		Secrets:   secrets,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to encode secrets")
+	}
+	bucket, err := profileEncryptedBucket(ctx, tp.Profile, keyring)
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.PutBytes(ctx, profileArtifactPath(tp.Profile, va.artifact), data, nil); err != nil {
+		return nil, errors.Wrapf(err, "Failed to upload backup to %s", va.artifact)
+	}
+	log.Infof("Backed up %d Vault secrets from %s to %s", len(secrets), path.Join(va.kv.mountPath, va.path), va.artifact)
+	return map[string]interface{}{
+		VaultOutputArtifact:    va.artifact,
+		VaultOutputSecretCount: len(secrets),
+	}, nil
+}
+
+func (*vaultSecretsBackupFunc) RequiredArgs() []string {
+	return []string{VaultNamespaceArg, VaultSecretArg, VaultAddressArg, VaultMountPathArg, VaultArtifactArg}
+}
+
+type vaultSecretsRestoreFunc struct{}
+
+func (*vaultSecretsRestoreFunc) Name() string {
+	return "VaultSecretsRestore"
+}
+
+func (*vaultSecretsRestoreFunc) Exec(ctx context.Context, tp param.TemplateParams, args map[string]interface{}) (map[string]interface{}, error) {
+	va, err := parseVaultArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	cli, keyring, err := newVaultClient(va)
+	if err != nil {
+		return nil, err
+	}
+	bucket, err := profileEncryptedBucket(ctx, tp.Profile, keyring)
+	if err != nil {
+		return nil, err
+	}
+	data, tags, err := bucket.GetBytes(ctx, profileArtifactPath(tp.Profile, va.artifact))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to download backup from %s", va.artifact)
+	}
+	if tags[objectstore.EncryptionTag] == "" {
+		return nil, errors.Errorf("Backup %s is not encrypted", va.artifact)
+	}
+	var b vaultBackup
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, errors.Wrap(err, "Failed to decode secrets")
+	}
+	if err := writeVaultSecrets(cli.Logical(), va.kv, va.path, b.Secrets); err != nil {
+		return nil, err
+	}
+	log.Infof("Restored %d Vault secrets from %s to %s", len(b.Secrets), va.artifact, path.Join(va.kv.mountPath, va.path))
+	return map[string]interface{}{
+		VaultOutputArtifact:    va.artifact,
+		VaultOutputSecretCount: len(b.Secrets),
+	}, nil
+}
+
+func (*vaultSecretsRestoreFunc) RequiredArgs() []string {
+	return []string{VaultNamespaceArg, VaultSecretArg, VaultAddressArg, VaultMountPathArg, VaultArtifactArg}
+}
+
+type vaultArgs struct {
+	namespace string
+	secret    string
+	address   string
+	path      string
+	artifact  string
+	kv        vaultKV
+}
+
+func parseVaultArgs(args map[string]interface{}) (*vaultArgs, error) {
+	var va vaultArgs
+	var mountPath string
+	var kvVersion int
+	if err := Arg(args, VaultNamespaceArg, &va.namespace); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, VaultSecretArg, &va.secret); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, VaultAddressArg, &va.address); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, VaultMountPathArg, &mountPath); err != nil {
+		return nil, err
+	}
+	if err := OptArg(args, VaultPathArg, &va.path, ""); err != nil {
+		return nil, err
+	}
+	if err := OptArg(args, VaultKVVersionArg, &kvVersion, 1); err != nil {
+		return nil, err
+	}
+	if err := Arg(args, VaultArtifactArg, &va.artifact); err != nil {
+		return nil, err
+	}
+	if kvVersion != 1 && kvVersion != 2 {
+		return nil, errors.Errorf("Unsupported KV secrets engine version %d", kvVersion)
+	}
+	va.kv = vaultKV{mountPath: strings.Trim(mountPath, "/"), version: kvVersion}
+	va.path = strings.Trim(va.path, "/")
+	return &va, nil
+}
+
+// newVaultClient returns a Vault client authenticated with the token in the
+// credentials Secret, and a keyring with the encryption key from that Secret
+func newVaultClient(va *vaultArgs) (*api.Client, *objectstore.Keyring, error) {
+	kcli, err := kube.NewClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := kcli.CoreV1().Secrets(va.namespace).Get(va.secret, metav1.GetOptions{})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "Failed to get Secret %s/%s", va.namespace, va.secret)
+	}
+	token, ok := s.Data[vaultSecretTokenKey]
+	if !ok {
+		return nil, nil, errors.Errorf("Secret %s/%s does not contain %s", va.namespace, va.secret, vaultSecretTokenKey)
+	}
+	key, ok := s.Data[vaultSecretEncryptionKeyKey]
+	if !ok {
+		return nil, nil, errors.Errorf("Secret %s/%s does not contain %s", va.namespace, va.secret, vaultSecretEncryptionKeyKey)
+	}
+	keyring, err := newVaultKeyring(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	config := api.DefaultConfig()
+	config.Address = va.address
+	cli, err := api.NewClient(config)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "Failed to create Vault client")
+	}
+	cli.SetToken(string(token))
+	return cli, keyring, nil
+}
+
+// newVaultKeyring returns the keyring with which backups are encrypted
+func newVaultKeyring(key []byte) (*objectstore.Keyring, error) {
+	if len(key) != objectstore.EncryptionKeySize {
+		return nil, errors.Errorf("Encryption key must be %d bytes long", objectstore.EncryptionKeySize)
+	}
+	return objectstore.NewKeyring(vaultEncryptionKeyID, map[string][]byte{vaultEncryptionKeyID: key})
+}
+
+// vaultKV builds the API paths of a KV secrets engine
+type vaultKV struct {
+	mountPath string
+	version   int
+}
+
+func (kv vaultKV) listPath(p string) string {
+	if kv.version == 2 {
+		return path.Join(kv.mountPath, "metadata", p)
+	}
+	return path.Join(kv.mountPath, p)
+}
+
+func (kv vaultKV) dataPath(p string) string {
+	if kv.version == 2 {
+		return path.Join(kv.mountPath, "data", p)
+	}
+	return path.Join(kv.mountPath, p)
+}
+
+// vaultLogical is the subset of the Vault logical API used to back up and
+// restore secrets. All access goes through the API, so that it is recorded
+// by the audit devices enabled in Vault.
+type vaultLogical interface {
+	List(path string) (*api.Secret, error)
+	Read(path string) (*api.Secret, error)
+	Write(path string, data map[string]interface{}) (*api.Secret, error)
+}
+
+// readVaultSecrets reads all the secrets under p, recursively. The returned
+// map is indexed by the path of the secret relative to p.
+func readVaultSecrets(l vaultLogical, kv vaultKV, p string) (map[string]map[string]interface{}, error) {
+	secrets := make(map[string]map[string]interface{})
+	if err := readVaultSecretsRec(l, kv, p, "", secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+func readVaultSecretsRec(l vaultLogical, kv vaultKV, root, rel string, secrets map[string]map[string]interface{}) error {
+	s, err := l.List(kv.listPath(path.Join(root, rel)))
+	if err != nil {
+		return errors.Wrapf(err, "Failed to list secrets in %s", path.Join(root, rel))
+	}
+	if s == nil {
+		return nil
+	}
+	keys, ok := s.Data["keys"].([]interface{})
+	if !ok {
+		return errors.Errorf("Unexpected list response for %s", path.Join(root, rel))
+	}
+	for _, k := range keys {
+		key, ok := k.(string)
+		if !ok {
+			return errors.Errorf("Unexpected key %v in %s", k, path.Join(root, rel))
+		}
+		if strings.HasSuffix(key, "/") {
+			if err := readVaultSecretsRec(l, kv, root, rel+key, secrets); err != nil {
+				return err
+			}
+			continue
+		}
+		sp := rel + key
+		s, err := l.Read(kv.dataPath(path.Join(root, sp)))
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read secret %s", path.Join(root, sp))
+		}
+		if s == nil {
+			// Deleted since it was listed
+			continue
+		}
+		data := s.Data
+		if kv.version == 2 {
+			data, _ = s.Data["data"].(map[string]interface{})
+			if data == nil {
+				// Latest version is deleted
+				continue
+			}
+		}
+		secrets[sp] = data
+	}
+	return nil
+}
+
+// writeVaultSecrets writes the secrets under p
+func writeVaultSecrets(l vaultLogical, kv vaultKV, p string, secrets map[string]map[string]interface{}) error {
+	for sp, data := range secrets {
+		if kv.version == 2 {
+			data = map[string]interface{}{"data": data}
+		}
+		if _, err := l.Write(kv.dataPath(path.Join(p, sp)), data); err != nil {
+			return errors.Wrapf(err, "Failed to write secret %s", path.Join(p, sp))
+		}
+	}
+	return nil
+}
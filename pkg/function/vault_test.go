@@ -0,0 +1,232 @@
+package function
+
+import (
+	"bytes"
+	"net"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/vault/api"
+	vaulthttp "github.com/hashicorp/vault/http"
+	"github.com/hashicorp/vault/vault"
+	"github.com/mitchellh/go-testing-interface"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+type VaultSuite struct {
+	listener net.Listener
+	addr     string
+	token    string
+}
+
+var _ = Suite(&VaultSuite{})
+
+// SetUpSuite starts an in-process Vault server, with the KV secrets engine
+// mounted at kv1. The server only has version 1 of the engine, which is
+// built into Vault. Version 2 is a plugin, and is tested with
+// fakeVaultKV2.
+func (s *VaultSuite) SetUpSuite(c *C) {
+	t := &testing.RuntimeT{}
+	core, _, token := vault.TestCoreUnsealed(t)
+	s.listener, s.addr = vaulthttp.TestServer(t, core)
+	s.token = token
+
+	err := s.newClient(c).Sys().Mount(vaultTestMount, &api.MountInput{Type: "kv"})
+	c.Assert(err, IsNil)
+}
+
+func (s *VaultSuite) TearDownSuite(c *C) {
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+}
+
+const vaultTestMount = "kv1"
+
+func (s *VaultSuite) newClient(c *C) *api.Client {
+	config := api.DefaultConfig()
+	config.Address = s.addr
+	cli, err := api.NewClient(config)
+	c.Assert(err, IsNil)
+	cli.SetToken(s.token)
+	return cli
+}
+
+func (s *VaultSuite) TestVaultBackupRestore(c *C) {
+	cli := s.newClient(c)
+	checkVaultBackupRestore(c, cli.Logical(), vaultKV{mountPath: vaultTestMount, version: 1})
+
+	kv2 := newFakeVaultKV2("kv2")
+	checkVaultBackupRestore(c, kv2, vaultKV{mountPath: "kv2", version: 2})
+	for _, p := range kv2.paths {
+		c.Assert(strings.HasPrefix(p+"/", "kv2/metadata/") || strings.HasPrefix(p+"/", "kv2/data/"), Equals, true, Commentf("%s", p))
+	}
+}
+
+func checkVaultBackupRestore(c *C, l vaultLogical, kv vaultKV) {
+	secrets := map[string]map[string]interface{}{
+		"app/db":            {"username": "admin", "password": "s3cret"},
+		"app/api":           {"key": "abc"},
+		"app/nested/deeper": {"token": "xyz"},
+		"other/ignored":     {"key": "not backed up"},
+	}
+	c.Assert(writeVaultSecrets(l, kv, "", secrets), IsNil)
+	all, err := readVaultSecrets(l, kv, "")
+	c.Assert(err, IsNil)
+	c.Assert(all, HasLen, len(secrets))
+
+	got, err := readVaultSecrets(l, kv, "app")
+	c.Assert(err, IsNil)
+	keys := make([]string, 0, len(got))
+	for k := range got {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	c.Assert(keys, DeepEquals, []string{"api", "db", "nested/deeper"}, Commentf("KV version %d", kv.version))
+	c.Assert(got["db"], DeepEquals, secrets["app/db"])
+
+	c.Assert(writeVaultSecrets(l, kv, "restored", got), IsNil)
+	restored, err := readVaultSecrets(l, kv, "restored")
+	c.Assert(err, IsNil)
+	c.Assert(restored, HasLen, 3)
+	c.Assert(restored, DeepEquals, got)
+}
+
+func (s *VaultSuite) TestVaultEmptyAndDenied(c *C) {
+	cli := s.newClient(c)
+	got, err := readVaultSecrets(cli.Logical(), vaultKV{mountPath: vaultTestMount, version: 1}, "missing")
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 0)
+
+	cli.SetToken("invalid")
+	_, err = readVaultSecrets(cli.Logical(), vaultKV{mountPath: vaultTestMount, version: 1}, "")
+	c.Assert(err, NotNil)
+}
+
+func (s *VaultSuite) TestVaultKV2DeletedSecret(c *C) {
+	kv2 := newFakeVaultKV2("kv2")
+	kv := vaultKV{mountPath: "kv2", version: 2}
+	c.Assert(writeVaultSecrets(kv2, kv, "", map[string]map[string]interface{}{"a": {"k": "v"}, "b": {"k": "v"}}), IsNil)
+	// The latest version of a deleted secret has no data, but it is still
+	// listed in the metadata
+	kv2.secrets["b"] = nil
+	got, err := readVaultSecrets(kv2, kv, "")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, map[string]map[string]interface{}{"a": {"k": "v"}})
+}
+
+func (s *VaultSuite) TestVaultKeyring(c *C) {
+	_, err := newVaultKeyring(bytes.Repeat([]byte{7}, 16))
+	c.Assert(err, NotNil)
+	k, err := newVaultKeyring(bytes.Repeat([]byte{7}, objectstore.EncryptionKeySize))
+	c.Assert(err, IsNil)
+	c.Assert(k, NotNil)
+}
+
+func (s *VaultSuite) TestParseVaultArgs(c *C) {
+	args := map[string]interface{}{
+		VaultNamespaceArg: "ns",
+		VaultSecretArg:    "vault-creds",
+		VaultAddressArg:   "https://vault:8200",
+		VaultMountPathArg: "/secret/",
+		VaultPathArg:      "/app/",
+		VaultArtifactArg:  "vault/backup",
+	}
+	va, err := parseVaultArgs(args)
+	c.Assert(err, IsNil)
+	c.Assert(va.kv, Equals, vaultKV{mountPath: "secret", version: 1})
+	c.Assert(va.path, Equals, "app")
+
+	args[VaultKVVersionArg] = 3
+	_, err = parseVaultArgs(args)
+	c.Assert(err, NotNil)
+	delete(args, VaultKVVersionArg)
+	delete(args, VaultArtifactArg)
+	_, err = parseVaultArgs(args)
+	c.Assert(err, NotNil)
+}
+
+// fakeVaultKV2 serves the API of version 2 of the KV secrets engine, mounted
+// at mount, and records the paths of the requests
+type fakeVaultKV2 struct {
+	mount string
+	// Data of the latest version of the secrets, nil if it is deleted
+	secrets map[string]map[string]interface{}
+	paths   []string
+}
+
+func newFakeVaultKV2(mount string) *fakeVaultKV2 {
+	return &fakeVaultKV2{mount: mount, secrets: make(map[string]map[string]interface{})}
+}
+
+// trim returns the path of the secret in a request for the endpoint, and
+// whether the request is for the endpoint
+func (f *fakeVaultKV2) trim(p, endpoint string) (string, bool) {
+	f.paths = append(f.paths, p)
+	prefix := path.Join(f.mount, endpoint) + "/"
+	if !strings.HasPrefix(p+"/", prefix) {
+		return "", false
+	}
+	return strings.Trim(strings.TrimPrefix(p+"/", prefix), "/"), true
+}
+
+func (f *fakeVaultKV2) List(p string) (*api.Secret, error) {
+	dir, ok := f.trim(p, "metadata")
+	if !ok {
+		return nil, nil
+	}
+	if dir != "" {
+		dir += "/"
+	}
+	seen := make(map[string]bool)
+	var keys []interface{}
+	for sp := range f.secrets {
+		if !strings.HasPrefix(sp, dir) {
+			continue
+		}
+		key := strings.TrimPrefix(sp, dir)
+		if i := strings.Index(key, "/"); i >= 0 {
+			key = key[:i+1]
+		}
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &api.Secret{Data: map[string]interface{}{"keys": keys}}, nil
+}
+
+func (f *fakeVaultKV2) Read(p string) (*api.Secret, error) {
+	sp, ok := f.trim(p, "data")
+	if !ok {
+		return nil, nil
+	}
+	data, ok := f.secrets[sp]
+	if !ok {
+		return nil, nil
+	}
+	if data == nil {
+		return &api.Secret{Data: map[string]interface{}{"data": nil}}, nil
+	}
+	return &api.Secret{Data: map[string]interface{}{"data": data}}, nil
+}
+
+func (f *fakeVaultKV2) Write(p string, data map[string]interface{}) (*api.Secret, error) {
+	sp, ok := f.trim(p, "data")
+	if !ok {
+		return nil, errors.Errorf("unsupported path %s", p)
+	}
+	d, ok := data["data"].(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("no data in write to %s", p)
+	}
+	f.secrets[sp] = d
+	return nil, nil
+}
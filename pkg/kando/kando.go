@@ -1,32 +1,67 @@
 package kando
 
 import (
+	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
+	"github.com/kanisterio/kanister/pkg/objectstore"
 	"github.com/kanisterio/kanister/pkg/version"
 )
 
+// shutdownTimeout bounds the time spent draining object store operations
+// once a shutdown signal is received
+const shutdownTimeout = 30 * time.Second
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-	root := newRootCommand()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go handleSignals(cancel)
+	root := newRootCommand(ctx)
 	if err := root.Execute(); err != nil {
 		log.Errorf("%+v", err)
 		os.Exit(1)
 	}
 }
 
-func newRootCommand() *cobra.Command {
+func newRootCommand(ctx context.Context) *cobra.Command {
 	// RootCmd represents the base command when called without any subcommands
 	rootCmd := &cobra.Command{
 		Use:     "kando <command>",
 		Short:   "A set of tools used from Kanister Blueprints",
 		Version: version.VersionString(),
 	}
-	rootCmd.AddCommand(newLocationCommand())
-	rootCmd.AddCommand(newOutputCommand())
+	rootCmd.AddCommand(newLocationCommand(ctx))
+	rootCmd.AddCommand(newOutputCommand(ctx))
 	return rootCmd
 }
+
+// handleSignals handles SIGINT and SIGTERM. The object store operations in
+// flight, such as the writes of archived outputs and idempotency keys, are
+// drained, and new ones fail. The command is then cancelled, which stops
+// the transfers of the aws CLI, and kando exits with the status of the
+// command. A second signal exits immediately.
+func handleSignals(cancel context.CancelFunc) {
+	signalChan := make(chan os.Signal, 2)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	<-signalChan
+	log.Infof("shutdown signal received, draining object store operations...")
+	go func() {
+		<-signalChan
+		log.Infof("second shutdown signal received, exiting...")
+		os.Exit(1)
+	}()
+	ctx, stop := context.WithTimeout(context.Background(), shutdownTimeout)
+	if err := objectstore.Shutdown(ctx); err != nil {
+		log.Errorf("Failed to drain object store operations: %+v", err)
+	}
+	stop()
+	cancel()
+}
@@ -1,30 +1,35 @@
 package kando
 
 import (
+	"context"
 	"encoding/json"
+	"os"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
 const (
-	pathFlagName    = "path"
-	profileFlagName = "profile"
+	pathFlagName           = "path"
+	profileFlagName        = "profile"
+	spoolDirFlagName       = "spool-dir"
+	idempotencyKeyFlagName = "idempotency-key"
 )
 
-func newLocationCommand() *cobra.Command {
+func newLocationCommand(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "location <command>",
 		Short: "Push, pull and delete from object storage",
 	}
-	cmd.AddCommand(newLocationPushCommand())
-	cmd.AddCommand(newLocationPullCommand())
-	cmd.AddCommand(newLocationDeleteCommand())
+	cmd.AddCommand(newLocationPushCommand(ctx))
+	cmd.AddCommand(newLocationPullCommand(ctx))
+	cmd.AddCommand(newLocationDeleteCommand(ctx))
 	cmd.PersistentFlags().StringP(pathFlagName, "s", "", "Specify a path suffix (optional)")
-	cmd.PersistentFlags().StringP(profileFlagName, "p", "", "Pass a Profile as a JSON string (required)")
-	cmd.MarkFlagRequired(profileFlagName)
+	cmd.PersistentFlags().StringP(profileFlagName, "p", "", "Pass a Profile as a JSON string (required unless $"+profileEnvName+" is set)")
+	cmd.PersistentFlags().Bool(summaryFlagName, false, "Print the summary of the transfer as the "+output.TransferSummaryKey+" output and on stderr (optional)")
 	return cmd
 }
 
@@ -32,8 +37,20 @@ func pathFlag(cmd *cobra.Command) string {
 	return cmd.Flag(pathFlagName).Value.String()
 }
 
+// unmarshalProfileFlag returns the Profile passed with --profile or, so that
+// it can be kept off the command line, in $KANDO_PROFILE
 func unmarshalProfileFlag(cmd *cobra.Command) (*param.Profile, error) {
 	profileJSON := cmd.Flag(profileFlagName).Value.String()
+	if profileJSON == "" {
+		profileJSON = os.Getenv(profileEnvName)
+	}
+	if profileJSON == "" {
+		return nil, errors.Errorf("a Profile is required in --%s or $%s", profileFlagName, profileEnvName)
+	}
+	return unmarshalProfile(profileJSON)
+}
+
+func unmarshalProfile(profileJSON string) (*param.Profile, error) {
 	p := &param.Profile{}
 	err := json.Unmarshal([]byte(profileJSON), p)
 	return p, errors.Wrap(err, "failed to unmarshal profile")
@@ -3,36 +3,62 @@ package kando
 import (
 	"context"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/kanisterio/kanister/pkg/location"
+	"github.com/kanisterio/kanister/pkg/objectstore"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
-func newLocationDeleteCommand() *cobra.Command {
+func newLocationDeleteCommand(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "delete",
 		Short: "Delete artifacts from s3-compliant object storage",
 		// TODO: Example invocations
 		RunE: func(c *cobra.Command, args []string) error {
-			return runLocationDelete(c)
+			return runLocationDelete(ctx, c)
 		},
 	}
 	return cmd
 
 }
 
-func runLocationDelete(cmd *cobra.Command) error {
+func runLocationDelete(ctx context.Context, cmd *cobra.Command) error {
 	p, err := unmarshalProfileFlag(cmd)
 	if err != nil {
 		return err
 	}
 	cmd.SilenceUsage = true
 	s := pathFlag(cmd)
-	ctx := context.Background()
-	return locationDelete(ctx, p, s)
+	return newTransferSummarizer(cmd).summarize(ctx, "delete", s, false, func(ctx context.Context) (int64, error) {
+		n, err := locationDeleteCount(ctx, p, s)
+		return int64(n), err
+	})
 }
 
 func locationDelete(ctx context.Context, p *param.Profile, path string) error {
-	return location.Delete(ctx, *p, path)
+	_, err := locationDeleteCount(ctx, p, path)
+	return err
+}
+
+// locationDeleteCount deletes the artifact at the path in the prefix of the
+// profile, or every artifact under it if it is a directory, and returns the
+// number of objects deleted
+func locationDeleteCount(ctx context.Context, p *param.Profile, path string) (int, error) {
+	d, err := profileDirectory(ctx, p)
+	if err != nil {
+		return 0, err
+	}
+	sd, err := d.GetDirectory(ctx, path)
+	switch {
+	case err == nil:
+		n, err := sd.DeleteDirectoryCount(ctx)
+		return n, errors.Wrapf(err, "Failed to delete %s", path)
+	case !objectstore.IsObjectNotFound(err):
+		return 0, errors.Wrapf(err, "Failed to delete %s", path)
+	}
+	if err := d.Delete(ctx, path); err != nil {
+		return 0, errors.Wrapf(err, "Failed to delete %s", path)
+	}
+	return 1, nil
 }
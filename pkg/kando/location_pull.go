@@ -5,27 +5,29 @@ import (
 	"io"
 	"os"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
-	"github.com/kanisterio/kanister/pkg/location"
+	"github.com/kanisterio/kanister/pkg/objectstore"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
-func newLocationPullCommand() *cobra.Command {
+func newLocationPullCommand(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "pull <target>",
 		Short: "Pull from s3-compliant object storage to a file or stdout",
 		Args:  cobra.ExactArgs(1),
 		// TODO: Example invocations
 		RunE: func(c *cobra.Command, args []string) error {
-			return runLocationPull(c, args)
+			return runLocationPull(ctx, c, args)
 		},
 	}
+	cmd.Flags().String(spoolDirFlagName, "", "Spool the artifact to this local directory before writing it to the target (optional)")
 	return cmd
 
 }
 
-func runLocationPull(cmd *cobra.Command, args []string) error {
+func runLocationPull(ctx context.Context, cmd *cobra.Command, args []string) error {
 	target, err := targetWriter(args[0])
 	if err != nil {
 		return err
@@ -35,8 +37,12 @@ func runLocationPull(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	s := pathFlag(cmd)
-	ctx := context.Background()
-	return locationPull(ctx, p, s, target)
+	return newTransferSummarizer(cmd).summarize(ctx, "pull", s, target == os.Stdout, func(ctx context.Context) (int64, error) {
+		if spoolDir := cmd.Flag(spoolDirFlagName).Value.String(); spoolDir != "" {
+			return 0, locationPullSpooled(ctx, p, s, spoolDir, target)
+		}
+		return 0, locationPull(ctx, p, s, target)
+	})
 }
 
 func targetWriter(target string) (io.Writer, error) {
@@ -46,6 +52,33 @@ func targetWriter(target string) (io.Writer, error) {
 	return os.Stdout, nil
 }
 
+// locationPull downloads the artifact at the path in the prefix of the
+// profile to the target
 func locationPull(ctx context.Context, p *param.Profile, path string, target io.Writer) error {
-	return location.Read(ctx, target, *p, path)
+	return locationPullWith(ctx, p, path, target, func(d objectstore.Directory) (io.ReadCloser, map[string]string, error) {
+		return d.Get(ctx, path)
+	})
+}
+
+// locationPullSpooled reads the artifact into spoolDir before copying it to
+// the target, so that a slow target does not hold the download open. The
+// spooled data is verified against the ETag of the artifact.
+func locationPullSpooled(ctx context.Context, p *param.Profile, path, spoolDir string, target io.Writer) error {
+	return locationPullWith(ctx, p, path, target, func(d objectstore.Directory) (io.ReadCloser, map[string]string, error) {
+		return d.GetSpooled(ctx, path, objectstore.SpoolOptions{Dir: spoolDir})
+	})
+}
+
+func locationPullWith(ctx context.Context, p *param.Profile, path string, target io.Writer, get func(objectstore.Directory) (io.ReadCloser, map[string]string, error)) error {
+	d, err := profileDirectory(ctx, p)
+	if err != nil {
+		return err
+	}
+	r, _, err := get(d)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to pull %s", path)
+	}
+	defer r.Close()
+	_, err = io.Copy(target, r)
+	return errors.Wrapf(err, "Failed to pull %s", path)
 }
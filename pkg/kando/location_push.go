@@ -6,27 +6,30 @@ import (
 	"os"
 
 	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 
 	"github.com/kanisterio/kanister/pkg/location"
+	"github.com/kanisterio/kanister/pkg/objectstore"
 	"github.com/kanisterio/kanister/pkg/param"
 )
 
-func newLocationPushCommand() *cobra.Command {
+func newLocationPushCommand(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "push <source>",
 		Short: "Push a source file or stdin stream to s3-compliant object storage",
 		Args:  cobra.ExactArgs(1),
 		// TODO: Example invocations
 		RunE: func(c *cobra.Command, args []string) error {
-			return runLocationPush(c, args)
+			return runLocationPush(ctx, c, args)
 		},
 	}
+	cmd.Flags().String(idempotencyKeyFlagName, "", "Skip the push if a previous push with this key completed, and record the key once the push completes (optional)")
 	return cmd
 
 }
 
-func runLocationPush(cmd *cobra.Command, args []string) error {
+func runLocationPush(ctx context.Context, cmd *cobra.Command, args []string) error {
 	source, err := sourceReader(args[0])
 	if err != nil {
 		return err
@@ -36,8 +39,12 @@ func runLocationPush(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	s := pathFlag(cmd)
-	ctx := context.Background()
-	return locationPush(ctx, p, s, source)
+	return newTransferSummarizer(cmd).summarize(ctx, "push", s, false, func(ctx context.Context) (int64, error) {
+		if key := cmd.Flag(idempotencyKeyFlagName).Value.String(); key != "" {
+			return 0, locationPushIdempotent(ctx, p, s, source, key)
+		}
+		return 0, locationPush(ctx, p, s, source)
+	})
 }
 
 const usePipeParam = `-`
@@ -56,6 +63,45 @@ func sourceReader(source string) (io.Reader, error) {
 	return os.Stdin, nil
 }
 
+// locationPush uploads the source, whose size is not known, to the path in
+// the prefix of the profile
 func locationPush(ctx context.Context, p *param.Profile, path string, source io.Reader) error {
-	return location.Write(ctx, source, *p, path)
+	d, err := profileDirectory(ctx, p)
+	if err != nil {
+		return err
+	}
+	return errors.Wrapf(d.Put(ctx, path, source, -1, nil), "Failed to push to %s", path)
+}
+
+// locationPushIdempotent pushes the source unless a previous push with the
+// same key completed. Keys are recorded in the prefix of the profile.
+func locationPushIdempotent(ctx context.Context, p *param.Profile, path string, source io.Reader, key string) error {
+	d, err := profileDirectory(ctx, p)
+	if err != nil {
+		return err
+	}
+	applied, err := objectstore.AlreadyApplied(ctx, d, key)
+	if err != nil {
+		return err
+	}
+	if applied {
+		log.Infof("Skipping push to %s, it was completed by a previous push with the same key", path)
+		return nil
+	}
+	if err := locationPush(ctx, p, path, source); err != nil {
+		return err
+	}
+	return objectstore.MarkApplied(ctx, d, key, 0)
+}
+
+// profileDirectory returns the directory of the prefix of the profile
+func profileDirectory(ctx context.Context, p *param.Profile) (objectstore.Directory, error) {
+	b, err := location.Bucket(ctx, *p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Location.S3Compliant.Prefix == "" {
+		return b, nil
+	}
+	return b.CreateDirectory(ctx, p.Location.S3Compliant.Prefix)
 }
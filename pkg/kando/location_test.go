@@ -5,8 +5,10 @@ import (
 	"context"
 	"path/filepath"
 
+	"github.com/pkg/errors"
 	. "gopkg.in/check.v1"
 
+	"github.com/kanisterio/kanister/pkg/output"
 	"github.com/kanisterio/kanister/pkg/testutil"
 )
 
@@ -50,3 +52,51 @@ func (s *LocationSuite) TestLocationObjectStore(c *C) {
 	c.Assert(err, IsNil)
 
 }
+
+func (s *LocationSuite) TestTransferSummary(c *C) {
+	ctx := context.Background()
+	var stdout, stderr bytes.Buffer
+	ts := transferSummarizer{enabled: true, stdout: &stdout, stderr: &stderr}
+
+	// Deleted objects are counted as transferred
+	err := ts.summarize(ctx, "delete", "backups", false, func(context.Context) (int64, error) {
+		return 3, nil
+	})
+	c.Assert(err, IsNil)
+	outputs := make(map[string]interface{})
+	sc := output.NewScanner(&stdout)
+	for sc.Scan() {
+		outputs[sc.Output().Key] = sc.Output().Value
+	}
+	c.Assert(sc.Err(), IsNil)
+	summary, err := output.TransferSummaryFrom(outputs)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Bytes, Equals, int64(0))
+	c.Assert(summary.Objects, Equals, int64(3))
+	c.Assert(summary.Operation, Equals, "delete")
+	c.Assert(summary.Error, Equals, "")
+	c.Assert(stderr.String(), Matches, "delete backups: 0 bytes, 3 objects, 0 retries, 0s throttled in .*\n")
+
+	// Pulls to stdout only print the summary on stderr, and failures are
+	// summarized
+	stdout.Reset()
+	stderr.Reset()
+	err = ts.summarize(ctx, "pull", "backups/missing", true, func(context.Context) (int64, error) {
+		return 0, errors.New("NoSuchKey")
+	})
+	c.Assert(err, NotNil)
+	c.Assert(stdout.Len(), Equals, 0)
+	c.Assert(stderr.String(), Matches, "pull backups/missing: 0 bytes, 0 objects, .*, failed: NoSuchKey\n")
+
+	// Summaries are only printed if requested
+	stderr.Reset()
+	ts.enabled = false
+	called := false
+	err = ts.summarize(ctx, "delete", "backups", false, func(context.Context) (int64, error) {
+		called = true
+		return 1, nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(called, Equals, true)
+	c.Assert(stdout.Len()+stderr.Len(), Equals, 0)
+}
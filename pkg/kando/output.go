@@ -1,34 +1,125 @@
 package kando
 
 import (
+	"context"
+	"os"
+
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 
 	"github.com/kanisterio/kanister/pkg/output"
 )
 
-func newOutputCommand() *cobra.Command {
+const (
+	recordFlagName   = "record"
+	archiveFlagName  = "archive"
+	completeFlagName = "complete"
+	errorFlagName    = "error"
+	// profileEnvName is the environment variable that holds the Profile, as
+	// a JSON string, of the location in which outputs are archived and of
+	// the location commands when --profile is not set
+	profileEnvName = "KANDO_PROFILE"
+)
+
+func newOutputCommand(ctx context.Context) *cobra.Command {
 	cmd := &cobra.Command{
-		Use:   "output <key> <value>",
+		Use:   "output [<key> <value>]",
 		Short: "Create phase output with given key:value",
 		Args: func(c *cobra.Command, args []string) error {
 			return validateArguments(c, args)
 		},
 		// TODO: Example invocations
 		RunE: func(c *cobra.Command, args []string) error {
-			return runOutputCommand(c, args)
+			return runOutputCommand(ctx, c, args)
 		},
 	}
+	cmd.Flags().Bool(recordFlagName, false, "Also record the output in "+output.RecordPath+" so that lost log lines can be detected")
+	cmd.Flags().String(archiveFlagName, "", "Also archive the output under this prefix of the location of the Profile in $"+profileEnvName)
+	cmd.Flags().Bool(completeFlagName, false, "Mark the archive under the --"+archiveFlagName+" prefix complete instead of creating an output")
+	cmd.Flags().String(errorFlagName, "", "Error that the phase failed with, recorded in the archive by --"+completeFlagName+" (optional)")
 	return cmd
 }
 
 func validateArguments(c *cobra.Command, args []string) error {
+	if complete, _ := c.Flags().GetBool(completeFlagName); complete {
+		if prefix, _ := c.Flags().GetString(archiveFlagName); prefix == "" {
+			return errors.Errorf("--%s requires --%s", completeFlagName, archiveFlagName)
+		}
+		if len(args) != 0 {
+			return errors.Errorf("Command accepts no arguments with --%s, received %d arguments", completeFlagName, len(args))
+		}
+		return nil
+	}
 	if len(args) != 2 {
 		return errors.Errorf("Command accepts 2 arguments, received %d arguments", len(args))
 	}
+	if output.IsReservedKey(args[0]) {
+		return errors.Errorf("Key %s is reserved, keys must not start with %s", args[0], output.ReservedKeyPrefix)
+	}
 	return output.ValidateKey(args[0])
 }
 
-func runOutputCommand(c *cobra.Command, args []string) error {
-	return output.PrintOutput(args[0], args[1])
+func runOutputCommand(ctx context.Context, c *cobra.Command, args []string) error {
+	if complete, _ := c.Flags().GetBool(completeFlagName); complete {
+		prefix, _ := c.Flags().GetString(archiveFlagName)
+		phaseErr, _ := c.Flags().GetString(errorFlagName)
+		return completeArchive(ctx, prefix, phaseErr)
+	}
+	key, value := args[0], args[1]
+	if record, _ := c.Flags().GetBool(recordFlagName); record {
+		if err := output.RecordOutput(key, value, output.RecordPath); err != nil {
+			return err
+		}
+	}
+	if prefix, _ := c.Flags().GetString(archiveFlagName); prefix != "" {
+		return archiveOutput(ctx, prefix, key, value)
+	}
+	return output.PrintOutput(key, value)
+}
+
+// archiveOutput prints the output and appends it to the archive under
+// prefix in the location of the Profile in the environment. Outputs are
+// archived in objects of their own, so that concurrent invocations do not
+// overwrite each other's outputs.
+func archiveOutput(ctx context.Context, prefix, key, value string) error {
+	sink, err := envArchivingSink(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	return sink.Append(ctx, key, value)
+}
+
+// completeArchive marks the archive under prefix in the location of the
+// Profile in the environment complete, with phaseErr if it is not empty
+func completeArchive(ctx context.Context, prefix, phaseErr string) error {
+	sink, err := envArchivingSink(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	if err := sink.Load(ctx); err != nil {
+		return err
+	}
+	var perr error
+	if phaseErr != "" {
+		perr = errors.New(phaseErr)
+	}
+	return sink.Complete(ctx, perr)
+}
+
+// envArchivingSink returns a sink that archives outputs under prefix in the
+// location of the Profile in the environment
+func envArchivingSink(ctx context.Context, prefix string) (*output.ArchivingSink, error) {
+	profileJSON := os.Getenv(profileEnvName)
+	if profileJSON == "" {
+		return nil, errors.Errorf("--%s requires a Profile in $%s", archiveFlagName, profileEnvName)
+	}
+	p, err := unmarshalProfile(profileJSON)
+	if err != nil {
+		return nil, err
+	}
+	d, err := profileDirectory(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return output.NewArchivingSink(d, prefix), nil
 }
@@ -0,0 +1,66 @@
+package kando
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+	"github.com/kanisterio/kanister/pkg/output"
+)
+
+const summaryFlagName = "summary"
+
+// transferSummarizer prints the summary of a location command as an output
+// on stdout, and on one line on stderr. Summaries of commands that write
+// their data to stdout are only printed on stderr. Summaries are printed
+// only if --summary is set.
+type transferSummarizer struct {
+	enabled bool
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+func newTransferSummarizer(cmd *cobra.Command) transferSummarizer {
+	enabled, _ := cmd.Flags().GetBool(summaryFlagName)
+	return transferSummarizer{enabled: enabled, stdout: os.Stdout, stderr: os.Stderr}
+}
+
+// summarize runs the transfer f, and prints the summary of the objects that
+// f transferred and of their retries, as counted by the object store. f
+// returns the number of objects it deleted, which are counted as
+// transferred.
+func (ts transferSummarizer) summarize(ctx context.Context, op, path string, dataOnStdout bool, f func(context.Context) (int64, error)) error {
+	if !ts.enabled {
+		_, err := f(ctx)
+		return err
+	}
+	start := time.Now()
+	ctx, stats := objectstore.WithTransferStats(ctx)
+	deleted, err := f(ctx)
+	st := stats()
+	s := output.TransferSummary{
+		Operation:    op,
+		Path:         path,
+		Bytes:        st.Bytes,
+		Objects:      st.Objects + deleted,
+		Retries:      st.Retries,
+		ThrottleWait: st.RetryWait,
+		Elapsed:      time.Since(start),
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+	fmt.Fprintln(ts.stderr, s.String())
+	if dataOnStdout {
+		return err
+	}
+	if perr := output.PrintTransferSummary(ts.stdout, s); err == nil {
+		err = perr
+	}
+	return err
+}
@@ -42,7 +42,8 @@ func Exec(cli kubernetes.Interface, namespace, pod, container string, command []
 
 // ExecWithOptions executes a command in the specified container,
 // returning stdout, stderr and error. `options` allowed for
-// additional parameters to be passed.
+// additional parameters to be passed. The command cannot be cancelled: it
+// runs until it exits or the connection to the pod is lost.
 func ExecWithOptions(kubeCli kubernetes.Interface, options ExecOptions) (string, string, error) {
 	const tty = false
 	req := kubeCli.Core().RESTClient().Post().
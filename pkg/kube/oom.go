@@ -0,0 +1,104 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// OOMKilledAnnotation is set to "true" on ActionSets with a phase that
+	// failed because a pod was OOM killed
+	OOMKilledAnnotation = "kanister.io/oom-killed"
+
+	oomKilledReason = "OOMKilled"
+)
+
+// ErrOOMKilled is the cause of errors returned when a container was OOM
+// killed
+var ErrOOMKilled = errors.New("Container was OOM killed")
+
+// OOMEvent describes an OOM kill of a container in a pod
+type OOMEvent struct {
+	Namespace string
+	Pod       string
+	Container string
+	Message   string
+	Time      time.Time
+}
+
+func (e OOMEvent) String() string {
+	return fmt.Sprintf("Pod: %s/%s, Container: %s, Message: %s", e.Namespace, e.Pod, e.Container, e.Message)
+}
+
+// WatchForOOMKill watches the container statuses of the pod and sends an
+// OOMEvent for every container that is observed terminated by an OOM kill.
+// Containers that were OOM killed and restarted are not reported, since
+// they recovered. The OOMKilling events of the kubelet are not watched,
+// since they name the node rather than the pod. The channel is closed when
+// ctx is done or the watch ends.
+func WatchForOOMKill(ctx context.Context, cli kubernetes.Interface, namespace, podName string) (<-chan OOMEvent, error) {
+	pw, err := cli.Core().Pods(namespace).Watch(metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", podName).String(),
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to watch pod. Namespace: %s, Pod: %s", namespace, podName)
+	}
+	ch := make(chan OOMEvent)
+	go func() {
+		defer close(ch)
+		defer pw.Stop()
+		// Pod updates repeat the state of terminated containers
+		seen := make(map[OOMEvent]bool)
+		for {
+			var e *OOMEvent
+			select {
+			case <-ctx.Done():
+				return
+			case we, ok := <-pw.ResultChan():
+				if !ok {
+					return
+				}
+				e = oomFromPod(podName, we.Object)
+			}
+			if e == nil || seen[*e] {
+				continue
+			}
+			seen[*e] = true
+			select {
+			case ch <- *e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// oomFromPod returns the OOM kill of a container of the pod that is
+// currently terminated by it, or nil
+func oomFromPod(podName string, obj runtime.Object) *OOMEvent {
+	p, ok := obj.(*v1.Pod)
+	if !ok || p.Name != podName {
+		return nil
+	}
+	for _, cs := range p.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil && t.Reason == oomKilledReason {
+			return &OOMEvent{
+				Namespace: p.Namespace,
+				Pod:       podName,
+				Container: cs.Name,
+				Message:   t.Message,
+				Time:      t.FinishedAt.Time,
+			}
+		}
+	}
+	return nil
+}
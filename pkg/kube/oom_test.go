@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type OOMSuite struct{}
+
+var _ = Suite(&OOMSuite{})
+
+const oomTestTimeout = 5 * time.Second
+
+func (s *OOMSuite) TestWatchForOOMKill(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup-pod", Namespace: "ns"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	cli := fake.NewSimpleClientset(pod)
+	ch, err := WatchForOOMKill(ctx, cli, "ns", "backup-pod")
+	c.Assert(err, IsNil)
+
+	// The OOMKilling events of the kubelet name the node, and are ignored
+	_, err = cli.Core().Events("ns").Create(oomTestEvent("node-1", "OOMKilling", "Killed process 1234 (tar)"))
+	c.Assert(err, IsNil)
+	// A container that was OOM killed and restarted recovered
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{
+		oomTestRestartedStatus("restarted"),
+		{Name: "killed", State: v1.ContainerState{Running: &v1.ContainerStateRunning{}}},
+	}
+	pod, err = cli.Core().Pods("ns").Update(pod)
+	c.Assert(err, IsNil)
+	// A container that is terminated by an OOM kill is reported
+	pod.Status.ContainerStatuses[1] = oomTestKilledStatus("killed")
+	_, err = cli.Core().Pods("ns").Update(pod)
+	c.Assert(err, IsNil)
+	select {
+	case e := <-ch:
+		c.Assert(e.Pod, Equals, "backup-pod")
+		c.Assert(e.Container, Equals, "killed")
+		c.Assert(e.Message, Equals, "Memory cgroup out of memory")
+	case <-time.After(oomTestTimeout):
+		c.Fatal("Timed out waiting for OOM event")
+	}
+
+	// The channel is closed once the context is done
+	cancel()
+	select {
+	case _, ok := <-ch:
+		c.Assert(ok, Equals, false)
+	case <-time.After(oomTestTimeout):
+		c.Fatal("Channel was not closed")
+	}
+}
+
+func (s *OOMSuite) TestWaitForPodCompletionOOM(c *C) {
+	ctx := context.Background()
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup-pod", Namespace: "ns"},
+		Status:     v1.PodStatus{Phase: v1.PodRunning},
+	}
+	cli := fake.NewSimpleClientset(pod)
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		p := pod.DeepCopy()
+		p.Status.ContainerStatuses = []v1.ContainerStatus{oomTestKilledStatus("container")}
+		_, _ = cli.Core().Pods("ns").Update(p)
+	}()
+	ctx, cancel := context.WithTimeout(ctx, oomTestTimeout)
+	defer cancel()
+	err := WaitForPodCompletion(ctx, cli, "ns", "backup-pod")
+	c.Assert(err, NotNil)
+	c.Assert(errors.Cause(err), Equals, ErrOOMKilled)
+}
+
+func (s *OOMSuite) TestWaitForPodCompletionRecovered(c *C) {
+	// The container was OOM killed, restarted and completed
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup-pod", Namespace: "ns"},
+		Status: v1.PodStatus{
+			Phase:             v1.PodSucceeded,
+			ContainerStatuses: []v1.ContainerStatus{oomTestRestartedStatus("container")},
+		},
+	}
+	pod.Status.ContainerStatuses[0].State = v1.ContainerState{
+		Terminated: &v1.ContainerStateTerminated{Reason: "Completed"},
+	}
+	cli := fake.NewSimpleClientset(pod)
+	ctx, cancel := context.WithTimeout(context.Background(), oomTestTimeout)
+	defer cancel()
+	c.Assert(WaitForPodCompletion(ctx, cli, "ns", "backup-pod"), IsNil)
+}
+
+func (s *OOMSuite) TestWaitForPodCompletionOOMFailed(c *C) {
+	// The pod has already failed from the OOM kill
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "backup-pod", Namespace: "ns"},
+		Status: v1.PodStatus{
+			Phase:             v1.PodFailed,
+			ContainerStatuses: []v1.ContainerStatus{oomTestKilledStatus("container")},
+		},
+	}
+	cli := fake.NewSimpleClientset(pod)
+	ctx, cancel := context.WithTimeout(context.Background(), oomTestTimeout)
+	defer cancel()
+	err := WaitForPodCompletion(ctx, cli, "ns", "backup-pod")
+	c.Assert(err, NotNil)
+	c.Assert(errors.Cause(err), Equals, ErrOOMKilled)
+}
+
+func oomTestKilledStatus(container string) v1.ContainerStatus {
+	return v1.ContainerStatus{
+		Name: container,
+		State: v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137, Message: "Memory cgroup out of memory"},
+		},
+	}
+}
+
+// oomTestRestartedStatus is the status of a container that is running
+// again after it was OOM killed
+func oomTestRestartedStatus(container string) v1.ContainerStatus {
+	return v1.ContainerStatus{
+		Name:         container,
+		RestartCount: 1,
+		State:        v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		LastTerminationState: v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{Reason: "OOMKilled", ExitCode: 137},
+		},
+	}
+}
+
+func oomTestEvent(node, reason, message string) *v1.Event {
+	return &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{Name: node + "-" + reason, Namespace: "ns"},
+		InvolvedObject: v1.ObjectReference{
+			Kind: "Node",
+			Name: node,
+		},
+		Reason:  reason,
+		Message: message,
+	}
+}
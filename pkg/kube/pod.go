@@ -21,6 +21,11 @@ type PodOptions struct {
 	Command            []string
 	Volumes            map[string]string
 	ServiceAccountName string
+
+	// TerminationMessagePath is the file that the container reports, up to
+	// 4096 bytes, as its termination message. It defaults to
+	// /dev/termination-log.
+	TerminationMessagePath string
 }
 
 // CreatePod creates a pod with a single container based on the specified image
@@ -49,6 +54,9 @@ func CreatePod(ctx context.Context, cli kubernetes.Interface, opts *PodOptions)
 			ServiceAccountName: opts.ServiceAccountName,
 		},
 	}
+	if opts.TerminationMessagePath != "" {
+		pod.Spec.Containers[0].TerminationMessagePath = opts.TerminationMessagePath
+	}
 	pod, err := cli.Core().Pods(opts.Namespace).Create(pod)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Failed to create pod. Namespace: %s, NameFmt: %s", opts.Namespace, opts.GenerateName)
@@ -78,6 +86,21 @@ func GetPodLogs(ctx context.Context, cli kubernetes.Interface, namespace, name s
 	return string(bytes), nil
 }
 
+// GetPodTerminationMessage returns the termination message of the terminated
+// container of the given pod
+func GetPodTerminationMessage(ctx context.Context, cli kubernetes.Interface, namespace, name string) (string, error) {
+	p, err := cli.Core().Pods(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, cs := range p.Status.ContainerStatuses {
+		if t := cs.State.Terminated; t != nil {
+			return t.Message, nil
+		}
+	}
+	return "", errors.Errorf("Pod %s has no terminated container. Namespace: %s", name, namespace)
+}
+
 // WaitForPodReady waits for a pod to reach Running state
 func WaitForPodReady(ctx context.Context, cli kubernetes.Interface, namespace, name string) error {
 	err := poll.Wait(ctx, func(ctx context.Context) (bool, error) {
@@ -93,18 +116,49 @@ func WaitForPodReady(ctx context.Context, cli kubernetes.Interface, namespace, n
 	return errors.Wrapf(err, "Pod did not transition into running state. Namespace:%s, Name:%s", namespace, name)
 }
 
-// WaitForPodCompletion waits for a pod to reach a terminal state
+// WaitForPodCompletion waits for a pod to reach a terminal state. If a
+// container in the pod is OOM killed before then, the returned error's cause
+// is ErrOOMKilled.
 func WaitForPodCompletion(ctx context.Context, cli kubernetes.Interface, namespace, name string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	oom := make(chan OOMEvent, 1)
+	if oomCh, err := WatchForOOMKill(ctx, cli, namespace, name); err == nil {
+		go func() {
+			if e, ok := <-oomCh; ok {
+				select {
+				case oom <- e:
+				default:
+				}
+				cancel()
+			}
+		}()
+	} else {
+		log.WithError(err).Warn("Failed to watch for OOM kills")
+	}
 	err := poll.Wait(ctx, func(ctx context.Context) (bool, error) {
 		p, err := cli.Core().Pods(namespace).Get(name, metav1.GetOptions{})
 		if err != nil {
 			return true, err
 		}
+		// The pod may fail from an OOM kill before the watch reports it
+		if e := oomFromPod(name, p); e != nil {
+			select {
+			case oom <- *e:
+			default:
+			}
+			return true, nil
+		}
 		if p.Status.Phase == v1.PodFailed {
 			return false, errors.Errorf("Pod %s failed", name)
 		}
 		return (p.Status.Phase == v1.PodSucceeded), nil
 	})
+	select {
+	case e := <-oom:
+		return errors.Wrapf(ErrOOMKilled, "Pod did not transition into complete state. %s", e)
+	default:
+	}
 	if err == nil {
 		return nil
 	}
@@ -88,6 +88,38 @@ func (s *PodSuite) TestPodWithVolumes(c *C) {
 	c.Assert(pod.Spec.Containers[0].VolumeMounts[0].MountPath, Equals, "/mnt/data1")
 }
 
+func (s *PodSuite) TestGetPodTerminationMessage(c *C) {
+	ctx := context.Background()
+	cli := fake.NewSimpleClientset()
+	pod, err := CreatePod(ctx, cli, &PodOptions{
+		Namespace:              "ns",
+		GenerateName:           "test-",
+		Image:                  "kanisterio/kanister-tools:0.14.0",
+		Command:                []string{"sh", "-c", "echo hello > /tmp/message"},
+		TerminationMessagePath: "/tmp/message",
+	})
+	c.Assert(err, IsNil)
+	c.Assert(pod.Spec.Containers[0].TerminationMessagePath, Equals, "/tmp/message")
+	// The fake clientset does not generate names
+	pod.Name = "test-pod"
+	pod, err = cli.Core().Pods("ns").Create(pod)
+	c.Assert(err, IsNil)
+	_, err = GetPodTerminationMessage(ctx, cli, "ns", pod.Name)
+	c.Assert(err, ErrorMatches, "Pod test-pod has no terminated container.*")
+
+	pod.Status.ContainerStatuses = []v1.ContainerStatus{{
+		Name: "container",
+		State: v1.ContainerState{
+			Terminated: &v1.ContainerStateTerminated{Reason: "Completed", Message: "hello\n"},
+		},
+	}}
+	_, err = cli.Core().Pods("ns").Update(pod)
+	c.Assert(err, IsNil)
+	msg, err := GetPodTerminationMessage(ctx, cli, "ns", pod.Name)
+	c.Assert(err, IsNil)
+	c.Assert(msg, Equals, "hello\n")
+}
+
 func (s *PodSuite) TestGetPodLogs(c *C) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
@@ -0,0 +1,47 @@
+package location
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	crv1alpha1 "github.com/kanisterio/kanister/pkg/apis/cr/v1alpha1"
+	"github.com/kanisterio/kanister/pkg/objectstore"
+	"github.com/kanisterio/kanister/pkg/param"
+)
+
+// Bucket returns a handle to the bucket of the location specified by `profile`.
+func Bucket(ctx context.Context, profile param.Profile) (objectstore.Bucket, error) {
+	return EncryptedBucket(ctx, profile, nil)
+}
+
+// EncryptedBucket returns a handle to the bucket of the location specified by
+// `profile`, which encrypts the objects it writes with the current key of
+// `keyring` and decrypts those it reads. Objects are not encrypted if
+// `keyring` is nil.
+func EncryptedBucket(ctx context.Context, profile param.Profile, keyring *objectstore.Keyring) (objectstore.Bucket, error) {
+	if profile.Location.Type != crv1alpha1.LocationTypeS3Compliant || profile.Location.S3Compliant == nil {
+		return nil, errors.New("Profile location must be S3 compliant")
+	}
+	if profile.Credential.Type != param.CredentialTypeKeyPair || profile.Credential.KeyPair == nil {
+		return nil, errors.Errorf("Credential type not supported: %s", profile.Credential.Type)
+	}
+	pc := objectstore.ProviderConfig{
+		Type:          objectstore.ProviderTypeS3,
+		Endpoint:      profile.Location.S3Compliant.Endpoint,
+		SkipSSLVerify: profile.SkipSSLVerify,
+		Keyring:       keyring,
+	}
+	secret := &objectstore.Secret{
+		Type: objectstore.SecretTypeAwsAccessKey,
+		Aws: &objectstore.SecretAws{
+			AccessKeyID:     profile.Credential.KeyPair.ID,
+			SecretAccessKey: profile.Credential.KeyPair.Secret,
+		},
+	}
+	provider, err := objectstore.NewProvider(ctx, pc, secret)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to create object store provider")
+	}
+	return provider.GetBucket(ctx, profile.Location.S3Compliant.Bucket)
+}
@@ -0,0 +1,173 @@
+package objectstore
+
+// Handling of objects moved to archival storage by lifecycle rules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// ArchivePolicy decides how operations that walk a tree handle objects that
+// have been moved to archival storage, and cannot be read until they are
+// rehydrated
+type ArchivePolicy int
+
+const (
+	// ArchiveFail fails the operation before any object is processed, and
+	// reports all archived objects
+	ArchiveFail ArchivePolicy = iota
+	// ArchiveSkip skips archived objects and reports them in the stats of
+	// the operation
+	ArchiveSkip
+	// ArchiveRehydrate requests that archived objects are restored, and
+	// waits for them to become readable
+	ArchiveRehydrate
+)
+
+const (
+	defaultRehydrateTimeout      = 12 * time.Hour
+	defaultRehydratePollInterval = time.Minute
+	// storageClassMetadataKey is used by providers that report the storage
+	// class as object metadata
+	storageClassMetadataKey = "storage-class"
+)
+
+// Storage classes, across providers, whose objects must be rehydrated
+// before they can be read
+var archivedStorageClasses = map[string]bool{
+	"GLACIER":      true,
+	"DEEP_ARCHIVE": true,
+	"ARCHIVE":      true,
+}
+
+// ArchiveOptions control the handling of archived objects
+type ArchiveOptions struct {
+	Policy ArchivePolicy
+	// RehydrateTimeout bounds the time waited for rehydration. Defaults to
+	// 12 hours.
+	RehydrateTimeout time.Duration
+	// RehydratePollInterval is the interval at which rehydration is
+	// checked. Defaults to 1 minute.
+	RehydratePollInterval time.Duration
+}
+
+// ErrArchived is returned when objects cannot be read because they are in
+// archival storage
+type ErrArchived struct {
+	Keys []string
+	// redaction applies to the message only
+	redaction NameRedaction
+}
+
+func (e *ErrArchived) Error() string {
+	return fmt.Sprintf("%d objects are archived: %s", len(e.Keys), strings.Join(e.redaction.redactAll(e.Keys), ", "))
+}
+
+// IsArchivedError returns true if the cause of err is an ErrArchived
+func IsArchivedError(err error) bool {
+	_, ok := errors.Cause(err).(*ErrArchived)
+	return ok
+}
+
+// storageClasser is implemented by items that report their storage class
+type storageClasser interface {
+	StorageClass() (string, error)
+}
+
+// rehydrator is implemented by containers that can restore archived objects
+type rehydrator interface {
+	Rehydrate(name string) error
+}
+
+// itemStorageClass returns the storage class of the item, or "" if the
+// provider does not report it
+func itemStorageClass(item stow.Item) string {
+	if sc, ok := item.(storageClasser); ok {
+		if c, err := sc.StorageClass(); err == nil {
+			return c
+		}
+	}
+	md, err := item.Metadata()
+	if err != nil {
+		return ""
+	}
+	c, _ := md[storageClassMetadataKey].(string)
+	return c
+}
+
+func isArchived(item stow.Item) bool {
+	return archivedStorageClasses[strings.ToUpper(itemStorageClass(item))]
+}
+
+// handleArchived applies the policy to the archived objects, named by their
+// keys in the container. It returns the keys that must be skipped.
+func handleArchived(ctx context.Context, b *bucket, keys []string, opts ArchiveOptions) ([]string, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	sort.Strings(keys)
+	switch opts.Policy {
+	case ArchiveSkip:
+		return keys, nil
+	case ArchiveRehydrate:
+		return nil, rehydrate(ctx, b, keys, opts)
+	default:
+		return nil, &ErrArchived{Keys: keys, redaction: b.redaction}
+	}
+}
+
+// rehydrate requests the restore of the archived objects and waits until
+// they can all be read
+func rehydrate(ctx context.Context, b *bucket, keys []string, opts ArchiveOptions) error {
+	c := b.container
+	r, ok := c.(rehydrator)
+	if !ok {
+		return errors.Wrap(&ErrArchived{Keys: keys, redaction: b.redaction}, "provider does not support rehydration")
+	}
+	for _, k := range keys {
+		if err := r.Rehydrate(k); err != nil {
+			return errors.Wrapf(err, "failed to request rehydration of %s", b.redaction.Redact(k))
+		}
+	}
+	timeout := opts.RehydrateTimeout
+	if timeout <= 0 {
+		timeout = defaultRehydrateTimeout
+	}
+	interval := opts.RehydratePollInterval
+	if interval <= 0 {
+		interval = defaultRehydratePollInterval
+	}
+	clk := clockFor(ctx, b)
+	rnd := randFor(ctx, b)
+	deadline := clk.After(timeout)
+	pending := keys
+	for {
+		var still []string
+		for _, k := range pending {
+			item, err := c.Item(k)
+			if err != nil {
+				return err
+			}
+			if isArchived(item) {
+				still = append(still, k)
+			}
+		}
+		if len(still) == 0 {
+			return nil
+		}
+		pending = still
+		select {
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		case <-deadline:
+			return errors.Wrapf(&ErrArchived{Keys: pending, redaction: b.redaction}, "timed out after %s waiting for rehydration", timeout)
+		case <-clk.After(jitter(rnd, interval, 0.1)):
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+	. "gopkg.in/check.v1"
+)
+
+type ArchiveSuite struct{}
+
+var _ = Suite(&ArchiveSuite{})
+
+// rehydratingContainer restores archived items on request
+type rehydratingContainer struct {
+	*memContainer
+	requested []string
+}
+
+func (c *rehydratingContainer) Rehydrate(name string) error {
+	c.requested = append(c.requested, name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[name].metadata[storageClassMetadataKey] = "STANDARD"
+	return nil
+}
+
+func archiveTestTree(c *C, b *bucket, cont *memContainer) {
+	ctx := context.Background()
+	for _, name := range []string{"old/a", "old/b", "old/c", "old/d"} {
+		c.Assert(b.PutBytes(ctx, name, []byte(name), nil), IsNil)
+	}
+	for _, name := range []string{"old/b", "old/d"} {
+		cont.items[name].metadata[storageClassMetadataKey] = "GLACIER"
+	}
+}
+
+func archiveMapping(old string) (string, bool) {
+	return "new/" + old, false
+}
+
+func (s *ArchiveSuite) TestArchiveFail(c *C) {
+	ctx := context.Background()
+	b, cont := newMemBucket("archive")
+	archiveTestTree(c, b, cont)
+
+	stats, err := RewritePrefix(ctx, b, archiveMapping, RewriteOptions{DryRun: true})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Archived, DeepEquals, []string{"old/b", "old/d"})
+
+	_, err = RewritePrefix(ctx, b, archiveMapping, RewriteOptions{})
+	c.Assert(IsArchivedError(err), Equals, true)
+	c.Assert(err, ErrorMatches, "2 objects are archived: old/b, old/d")
+	// Nothing was moved
+	c.Assert(cont.items["new/old/a"], IsNil)
+	c.Assert(cont.items["old/a"], NotNil)
+}
+
+func (s *ArchiveSuite) TestArchiveSkip(c *C) {
+	ctx := context.Background()
+	b, cont := newMemBucket("archive")
+	archiveTestTree(c, b, cont)
+
+	stats, err := RewritePrefix(ctx, b, archiveMapping, RewriteOptions{Archive: ArchiveOptions{Policy: ArchiveSkip}})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Moved, Equals, 2)
+	c.Assert(stats.Archived, DeepEquals, []string{"old/b", "old/d"})
+	c.Assert(cont.items["new/old/a"], NotNil)
+	c.Assert(cont.items["old/b"], NotNil)
+	c.Assert(cont.items["new/old/b"], IsNil)
+}
+
+func (s *ArchiveSuite) TestArchiveRehydrate(c *C) {
+	ctx := context.Background()
+	rc := &rehydratingContainer{memContainer: newMemContainer("archive")}
+	b := newBucket(ProviderConfig{}, rc, nil, "mem://")
+	archiveTestTree(c, b, rc.memContainer)
+
+	opts := ArchiveOptions{Policy: ArchiveRehydrate}
+	stats, err := RewritePrefix(ctx, b, archiveMapping, RewriteOptions{Archive: opts})
+	c.Assert(err, IsNil)
+	c.Assert(rc.requested, DeepEquals, []string{"old/b", "old/d"})
+	c.Assert(stats.Moved, Equals, 4)
+	c.Assert(stats.Archived, HasLen, 0)
+
+	// Providers that cannot rehydrate fail cleanly
+	b, cont := newMemBucket("archive")
+	archiveTestTree(c, b, cont)
+	_, err = RewritePrefix(ctx, b, archiveMapping, RewriteOptions{Archive: opts})
+	c.Assert(IsArchivedError(err), Equals, true)
+}
+
+func (s *ArchiveSuite) TestRehydrateTimeout(c *C) {
+	cont := newMemContainer("archive")
+	_, err := cont.Put("obj", bytes.NewReader(nil), 0, map[string]interface{}{storageClassMetadataKey: "DEEP_ARCHIVE"})
+	c.Assert(err, IsNil)
+	nr := &neverRehydrates{memContainer: cont}
+	b := newBucket(ProviderConfig{}, nr, nil, "mem://")
+	clk := newFakeClock()
+	b.clock = clk
+	b.rand = rand.New(rand.NewSource(1))
+	start := clk.Now()
+
+	// Uses the default timeout and poll interval
+	errCh := make(chan error)
+	go func() { errCh <- rehydrate(context.Background(), b, []string{"obj"}, ArchiveOptions{}) }()
+	// Advance once the deadline and each poll timer are set
+	err = advanceUntilDone(clk, 1, errCh)
+	c.Assert(IsArchivedError(err), Equals, true)
+	c.Assert(err, ErrorMatches, "timed out after 12h0m0s .*: 1 objects are archived: obj")
+	elapsed := clk.Now().Sub(start)
+	c.Assert(elapsed >= defaultRehydrateTimeout && elapsed < defaultRehydrateTimeout+2*time.Minute, Equals, true, Commentf("%s", elapsed))
+	// Polls are jittered around the interval
+	polls := nr.polls()
+	c.Assert(polls > 600 && polls < 850, Equals, true, Commentf("%d polls", polls))
+}
+
+func (s *ArchiveSuite) TestRehydrateCanceled(c *C) {
+	cont := newMemContainer("archive")
+	_, err := cont.Put("obj", bytes.NewReader(nil), 0, map[string]interface{}{storageClassMetadataKey: "GLACIER"})
+	c.Assert(err, IsNil)
+	b := newBucket(ProviderConfig{}, &neverRehydrates{memContainer: cont}, nil, "mem://")
+	ctx, cancel := context.WithCancel(withClock(context.Background(), newFakeClock()))
+	cancel()
+	err = rehydrate(ctx, b, []string{"obj"}, ArchiveOptions{})
+	c.Assert(err, ErrorMatches, "context canceled")
+}
+
+// neverRehydrates counts the checks of archived items that never complete
+// their rehydration
+type neverRehydrates struct {
+	*memContainer
+	mu    sync.Mutex
+	items int
+}
+
+func (*neverRehydrates) Rehydrate(string) error { return nil }
+
+func (n *neverRehydrates) Item(id string) (stow.Item, error) {
+	n.mu.Lock()
+	n.items++
+	n.mu.Unlock()
+	return n.memContainer.Item(id)
+}
+
+func (n *neverRehydrates) polls() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.items
+}
@@ -0,0 +1,50 @@
+package objectstore
+
+// Attributes of objects that stow does not read, such as their content
+// type, which are read with the APIs of the providers
+
+import (
+	"context"
+	"mime"
+	"path"
+	"strings"
+)
+
+// attrsReader reads the attributes of objects. Keys are names in the
+// container.
+type attrsReader interface {
+	ObjectAttrs(ctx context.Context, key string) (objectAttrs, error)
+}
+
+// contentTypes are the content types of the extensions of the objects
+// written by Kanister, which are missing from the tables of some systems
+var contentTypes = map[string]string{
+	".gz":   "application/gzip",
+	".json": "application/json",
+	".tar":  "application/x-tar",
+	".txt":  "text/plain; charset=utf-8",
+	".yaml": "application/yaml",
+	".yml":  "application/yaml",
+}
+
+// ContentTypeByName returns the content type of the extension of the name,
+// e.g. application/json for manifest.json, or "" if it is unknown
+func ContentTypeByName(name string) string {
+	ext := strings.ToLower(path.Ext(name))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := contentTypes[ext]; ok {
+		return t
+	}
+	return mime.TypeByExtension(ext)
+}
+
+// putContentType returns the content type of an object written by Put with
+// the settings
+func (b *bucket) putContentType(name string, s putSettings) string {
+	if s.contentType != "" || !b.inferContentType || !b.supportsContentType() {
+		return s.contentType
+	}
+	return ContentTypeByName(name)
+}
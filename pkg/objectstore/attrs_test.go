@@ -0,0 +1,82 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+type AttrsSuite struct{}
+
+var _ = Suite(&AttrsSuite{})
+
+func (s *AttrsSuite) TestContentTypeByName(c *C) {
+	for name, want := range map[string]string{
+		"manifest.json":     "application/json",
+		"dir/MANIFEST.JSON": "application/json",
+		"config.yml":        "application/yaml",
+		"backup.tar.gz":     "application/gzip",
+		"index.html":        "text/html; charset=utf-8",
+		"data":              "",
+		"dir.d/data":        "",
+	} {
+		c.Check(ContentTypeByName(name), Equals, want, Commentf("%s", name))
+	}
+}
+
+func (s *AttrsSuite) TestInfer(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("infer")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+
+	// Content types are only inferred if the bucket is configured to
+	c.Assert(d.PutBytes(ctx, "before.json", []byte("{}"), nil), IsNil)
+	_, ok := m.contentTypes["dir/before.json"]
+	c.Assert(ok, Equals, false)
+
+	b.inferContentType = true
+	c.Assert(d.PutBytes(ctx, "manifest.json", []byte("{}"), nil), IsNil)
+	err = d.PutOpts(ctx, "explicit.json", bytes.NewReader([]byte("{}")), 2, nil, WithContentType("text/plain"))
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "data", []byte("data"), nil), IsNil)
+	for name, want := range map[string]string{
+		"before.json":   "",
+		"manifest.json": "application/json",
+		"explicit.json": "text/plain",
+		"data":          "",
+	} {
+		info, err := d.Stat(ctx, name)
+		c.Assert(err, IsNil)
+		c.Check(info.ContentType, Equals, want, Commentf("%s", name))
+	}
+
+	// Buckets that cannot set content types write objects without them,
+	// even if the options are strict
+	b, mc := newMemBucket("unsupported")
+	b.inferContentType = true
+	err = b.PutOpts(ctx, "manifest.json", bytes.NewReader([]byte("{}")), 2, nil, WithStrictOptions())
+	c.Assert(err, IsNil)
+	c.Assert(mc.items, HasLen, 1)
+	info, err := b.Stat(ctx, "manifest.json")
+	c.Assert(err, IsNil)
+	c.Assert(info.ContentType, Equals, "")
+}
+
+func (s *AttrsSuite) TestS3(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	b.attrsReader = newS3AttrsReader(b.versioner.(*s3Versioner).client, "versioned", NameRedaction{})
+
+	f.objects["manifest.json"] = []fakeS3Version{{id: "v0", data: []byte("{}"), contentType: "application/json"}}
+	mc := b.container.(*memContainer)
+	_, err := mc.Put("manifest.json", bytes.NewReader([]byte("{}")), 2, nil)
+	c.Assert(err, IsNil)
+	info, err := b.Stat(ctx, "manifest.json")
+	c.Assert(err, IsNil)
+	c.Assert(info.ContentType, Equals, "application/json")
+	c.Assert(info.Size, Equals, int64(2))
+}
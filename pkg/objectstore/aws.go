@@ -2,10 +2,13 @@ package objectstore
 
 import (
 	"context"
+	"crypto/tls"
+	"net/http"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
@@ -16,6 +19,9 @@ const (
 	bucketNotFound = "NotFound"
 	noSuchBucket   = s3.ErrCodeNoSuchBucket
 	gcsS3NotFound  = "not found"
+	// defaultS3Region is used to sign requests to S3 compatible object
+	// stores when no region is known
+	defaultS3Region = "us-east-1"
 )
 
 func config(region string) *aws.Config {
@@ -26,10 +32,45 @@ func config(region string) *aws.Config {
 	return c
 }
 
+// newS3Client returns a client for the S3 API, for the operations that stow
+// does not expose
+func newS3Client(pc ProviderConfig, secret *Secret, region string) (*s3.S3, error) {
+	if region == "" {
+		region = defaultS3Region
+	}
+	c := config(region)
+	if secret != nil {
+		if secret.Type != SecretTypeAwsAccessKey || secret.Aws == nil {
+			return nil, errors.Errorf("invalid secret type %s", secret.Type)
+		}
+		c = c.WithCredentials(credentials.NewStaticCredentials(secret.Aws.AccessKeyID, secret.Aws.SecretAccessKey, ""))
+	} else {
+		c = c.WithCredentials(credentials.NewEnvCredentials())
+	}
+	if pc.Endpoint != "" {
+		c = c.WithEndpoint(pc.Endpoint).WithS3ForcePathStyle(true)
+	}
+	if pc.SkipSSLVerify {
+		c = c.WithHTTPClient(&http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		})
+	}
+	s, err := session.NewSession(c)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create session, region = %s", region)
+	}
+	return s3.New(s), nil
+}
+
 func isBucketNotFoundError(err error) bool {
 	if err == nil {
 		return false
 	}
+	if IsBucketNotFound(err) {
+		return true
+	}
 	if awsErr, ok := errors.Cause(err).(awserr.Error); ok {
 		code := awsErr.Code()
 		return code == bucketNotFound || code == noSuchBucket
@@ -0,0 +1,77 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// azureIfAbsentExpiry is the validity of the shared access signatures of
+// conditional uploads
+const azureIfAbsentExpiry = 15 * time.Minute
+
+var _ ifAbsentWriter = (*azureIfAbsentWriter)(nil)
+
+// azureRequestError is the response of the blob service to a failed request
+type azureRequestError struct {
+	StatusCode int
+	// Code is the error code of the x-ms-error-code header
+	Code string
+}
+
+func (e *azureRequestError) Error() string {
+	return fmt.Sprintf("request failed with status %d: %s", e.StatusCode, e.Code)
+}
+
+// azureIfAbsentWriter writes blobs with If-None-Match: *, which Azure
+// rejects with 409 BlobAlreadyExists if the blob exists. Blobs are written
+// in one Put Blob request, authorized by a shared access signature of the
+// presigner, so they are limited to the 64MiB of the version of the SAS.
+type azureIfAbsentWriter struct {
+	presigner *azurePresigner
+	client    *http.Client
+	redaction NameRedaction
+}
+
+func newAzureIfAbsentWriter(presigner *azurePresigner, redaction NameRedaction) *azureIfAbsentWriter {
+	return &azureIfAbsentWriter{presigner: presigner, client: http.DefaultClient, redaction: redaction}
+}
+
+func (w *azureIfAbsentWriter) PutIfAbsent(ctx context.Context, key string, r io.ReadSeeker, size int64, metadata map[string]string, sse ServerSideEncryption) error {
+	if sse.Mode != "" {
+		return &ErrOptionNotSupported{Option: "server-side encryption"}
+	}
+	u, err := w.presigner.PresignPut(ctx, key, azureIfAbsentExpiry)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u, r)
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload object %s", w.redaction.Redact(key))
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	req.Header.Set("x-ms-version", azureSASVersion)
+	req.Header.Set("x-ms-blob-type", "BlockBlob")
+	req.Header.Set("If-None-Match", "*")
+	for k, v := range metadata {
+		req.Header.Set("x-ms-meta-"+k, v)
+	}
+	resp, err := w.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload object %s", w.redaction.Redact(key))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusCreated {
+		err = &azureRequestError{StatusCode: resp.StatusCode, Code: resp.Header.Get("x-ms-error-code")}
+	}
+	return errors.Wrapf(err, "failed to upload object %s", w.redaction.Redact(key))
+}
@@ -0,0 +1,82 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// azureSASVersion is the version of the storage service with which
+// shared access signatures are signed
+const azureSASVersion = "2015-04-05"
+
+var _ presigner = (*azurePresigner)(nil)
+
+// azurePresigner presigns URLs with service shared access signatures, which
+// are signed by the key of the storage account
+type azurePresigner struct {
+	endpoint  string
+	account   string
+	key       []byte
+	container string
+}
+
+// newAzurePresigner returns a presigner that signs with the storage account
+// key of the secret, or of the environment
+func newAzurePresigner(secret *Secret, container string) (*azurePresigner, error) {
+	account, key, err := azureCredentials(secret)
+	if err != nil {
+		return nil, err
+	}
+	k, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode Azure storage key")
+	}
+	return &azurePresigner{
+		endpoint:  fmt.Sprintf("https://%s.blob.core.windows.net", account),
+		account:   account,
+		key:       k,
+		container: container,
+	}, nil
+}
+
+func (p *azurePresigner) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return p.presign(ctx, "r", key, expiry)
+}
+
+func (p *azurePresigner) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	// Writes of new blobs need the create permission
+	return p.presign(ctx, "cw", key, expiry)
+}
+
+// presign signs the string of a blob service SAS of version
+// azureSASVersion, as described in
+// https://docs.microsoft.com/rest/api/storageservices/create-service-sas,
+// with the permissions and no start time, IP range or response headers
+func (p *azurePresigner) presign(ctx context.Context, permissions, key string, expiry time.Duration) (string, error) {
+	expires := clockFor(ctx, nil).Now().Add(expiry).UTC().Format("2006-01-02T15:04:05Z")
+	resource := "/blob/" + p.account + "/" + p.container + "/" + key
+	toSign := strings.Join([]string{
+		permissions, "", expires, resource, "", "", "https", azureSASVersion,
+		"", "", "", "", "",
+	}, "\n")
+	mac := hmac.New(sha256.New, p.key)
+	if _, err := mac.Write([]byte(toSign)); err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("sv", azureSASVersion)
+	q.Set("sr", "b")
+	q.Set("sp", permissions)
+	q.Set("se", expires)
+	q.Set("spr", "https")
+	q.Set("sig", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	return p.endpoint + "/" + p.container + "/" + escapeObjectPath(key) + "?" + q.Encode(), nil
+}
@@ -0,0 +1,178 @@
+package objectstore
+
+// Deletion of objects in batches. Stow removes objects one at a time, so
+// batches are handled by provider specific batch deleters.
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// batchDeleter deletes objects with one request per batch. Keys are names
+// in the container.
+type batchDeleter interface {
+	// MaxBatchSize returns the maximum number of keys in a batch
+	MaxBatchSize() int
+	// DeleteBatch deletes the keys. It returns the keys that could not be
+	// deleted with their errors, or an error if the batch failed as a
+	// whole.
+	DeleteBatch(ctx context.Context, keys []string) (map[string]error, error)
+}
+
+// batchSize returns the number of keys per batch
+func (b *bucket) batchSize() int {
+	max := b.batchDeleter.MaxBatchSize()
+	if b.deleteBatchSize <= 0 || b.deleteBatchSize > max {
+		return max
+	}
+	return b.deleteBatchSize
+}
+
+// objectDeleter deletes objects, in batches if the provider supports it,
+// and counts the objects that were deleted and that failed. Objects that
+// fail in a batch are retried one by one. Objects are deleted one by one by
+// a pool of workers.
+type objectDeleter struct {
+	b       *bucket
+	pending []string
+	keys    chan string
+	wg      sync.WaitGroup
+
+	mu      sync.Mutex
+	removed int
+	errs    *BatchError
+}
+
+// newObjectDeleter returns an objectDeleter whose workers stop deleting
+// objects once ctx is done. wait must be called to stop the workers.
+func newObjectDeleter(ctx context.Context, b *bucket) *objectDeleter {
+	od := &objectDeleter{
+		b:    b,
+		keys: make(chan string),
+		errs: newBatchError(),
+	}
+	for i := 0; i < b.deleteWorkers; i++ {
+		od.wg.Add(1)
+		go func() {
+			defer od.wg.Done()
+			for key := range od.keys {
+				if ctx.Err() == nil {
+					od.remove(key)
+				}
+			}
+		}()
+	}
+	return od
+}
+
+// add deletes key, or queues it for the next batch
+func (od *objectDeleter) add(ctx context.Context, key string) {
+	if od.b.batchDeleter == nil {
+		od.enqueue(ctx, key)
+		return
+	}
+	od.pending = append(od.pending, key)
+	if len(od.pending) >= od.b.batchSize() {
+		od.flush(ctx)
+	}
+}
+
+// enqueue hands key to a worker
+func (od *objectDeleter) enqueue(ctx context.Context, key string) {
+	select {
+	case od.keys <- key:
+	case <-ctx.Done():
+	}
+}
+
+// flush deletes the queued keys
+func (od *objectDeleter) flush(ctx context.Context) {
+	if len(od.pending) == 0 {
+		return
+	}
+	keys := od.pending
+	od.pending = nil
+	failed, err := od.b.batchDeleter.DeleteBatch(ctx, keys)
+	if err != nil {
+		if ctx.Err() != nil {
+			// The walk stops at the next object
+			return
+		}
+		log.WithError(err).Warnf("Failed to delete a batch of %d objects, deleting them one by one", len(keys))
+		for _, k := range keys {
+			od.enqueue(ctx, k)
+		}
+		return
+	}
+	od.mu.Lock()
+	od.removed += len(keys) - len(failed)
+	od.mu.Unlock()
+	for k := range failed {
+		od.enqueue(ctx, k)
+	}
+}
+
+// wait stops the workers once they deleted the keys that were handed to
+// them
+func (od *objectDeleter) wait() {
+	close(od.keys)
+	od.wg.Wait()
+}
+
+// remove deletes a single key. Keys that are not found, e.g. because a
+// failed batch was partially applied, are not counted.
+func (od *objectDeleter) remove(key string) {
+	err := od.b.container.RemoveItem(key)
+	od.mu.Lock()
+	defer od.mu.Unlock()
+	switch {
+	case err == nil:
+		od.removed++
+		return
+	case IsObjectNotFound(err):
+		return
+	}
+	od.errs.add(od.b.redaction.Redact(key), "delete", err)
+}
+
+// DeleteCursorName is the name of the object, at the root of the directory
+// deleted by DeleteDirectory, in which the progress of the deletion is
+// recorded
+const DeleteCursorName = ".kanister-delete.json"
+
+const deleteCursorInterval = 1000
+
+// deleteCursor is the content of the DeleteCursorName object
+type deleteCursor struct {
+	// After is the name, in the container, of the last object deleted
+	After string `json:"after"`
+	// Deleted is the number of objects deleted by all the runs
+	Deleted int `json:"deleted"`
+}
+
+func readDeleteCursor(ctx context.Context, d *directory) (deleteCursor, bool, error) {
+	var cursor deleteCursor
+	data, _, err := d.GetBytes(ctx, DeleteCursorName)
+	switch {
+	case IsObjectNotFound(err):
+		return cursor, false, nil
+	case err != nil:
+		return cursor, false, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, false, errors.Wrap(err, "failed to read the cursor of the deletion")
+	}
+	return cursor, true, nil
+}
+
+func writeDeleteCursor(ctx context.Context, d *directory, cursor deleteCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return errors.Wrap(err, "failed to write the cursor of the deletion")
+	}
+	return d.PutBytes(ctx, DeleteCursorName, data, nil)
+}
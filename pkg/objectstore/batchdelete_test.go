@@ -0,0 +1,245 @@
+package objectstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type BatchDeleteSuite struct{}
+
+var _ = Suite(&BatchDeleteSuite{})
+
+// memBatchDeleter deletes from a memContainer in batches
+type memBatchDeleter struct {
+	c       *memContainer
+	max     int
+	batches []int
+	// failures holds the keys that fail in a batch
+	failures map[string]bool
+	// fail makes the next batches fail as a whole
+	fail int
+}
+
+func (d *memBatchDeleter) MaxBatchSize() int {
+	return d.max
+}
+
+func (d *memBatchDeleter) DeleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	d.batches = append(d.batches, len(keys))
+	if d.fail > 0 {
+		d.fail--
+		return nil, errors.New("503 Service Unavailable")
+	}
+	failed := make(map[string]error)
+	for _, k := range keys {
+		if d.failures[k] {
+			failed[k] = errors.New("InternalError")
+			continue
+		}
+		d.c.RemoveItem(k)
+	}
+	return failed, nil
+}
+
+func (s *BatchDeleteSuite) TestDeleteDirectory(c *C) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		config  ProviderConfig
+		batches []int
+	}{
+		{config: ProviderConfig{}, batches: []int{10, 10, 5}},
+		{config: ProviderConfig{DeleteBatchSize: 4}, batches: []int{4, 4, 4, 4, 4, 4, 1}},
+		// The provider maximum applies
+		{config: ProviderConfig{DeleteBatchSize: 100}, batches: []int{10, 10, 5}},
+	} {
+		mc := newMemContainer("batch")
+		b := newBucket(tc.config, mc, nil, "mem://")
+		bd := &memBatchDeleter{c: mc, max: 10}
+		b.batchDeleter = bd
+		d, err := b.CreateDirectory(ctx, "dir")
+		c.Assert(err, IsNil)
+		for i := 0; i < 24; i++ {
+			c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%02d", i), nil, nil), IsNil)
+		}
+		deleted, err := d.DeleteDirectoryCount(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(deleted, Equals, 25)
+		c.Assert(bd.batches, DeepEquals, tc.batches)
+		c.Assert(mc.items, HasLen, 0)
+	}
+}
+
+func (s *BatchDeleteSuite) TestDeleteDirectoryFailures(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("batch")
+	fc := &failingContainer{memContainer: mc, failures: make(map[string]int)}
+	b := newBucket(ProviderConfig{}, fc, nil, "mem://")
+	bd := &memBatchDeleter{c: mc, max: 10, failures: make(map[string]bool), fail: 1}
+	b.batchDeleter = bd
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 24; i++ {
+		name := fmt.Sprintf("dir/obj%02d", i)
+		c.Assert(b.PutBytes(ctx, name, nil, nil), IsNil)
+		switch {
+		case i%5 == 0:
+			// Retried one by one
+			bd.failures[name] = true
+		case i == 23:
+			bd.failures[name] = true
+			fc.failures[name] = 100
+		}
+	}
+
+	// The first batch fails as a whole and is deleted one by one
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(IsDeleteIncomplete(err), Equals, true)
+	c.Assert(err, ErrorMatches, "failed to delete 1 objects in /dir/, deleted 24: failed to delete dir/obj23: 503 Service Unavailable")
+	c.Assert(deleted, Equals, 24)
+	c.Assert(bd.batches, DeepEquals, []int{10, 10, 5, 1})
+	c.Assert(mc.items, HasLen, 1)
+}
+
+func (s *BatchDeleteSuite) TestDeleteObjects(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("batch")
+	fc := &failingContainer{memContainer: mc, failures: make(map[string]int)}
+	b := newBucket(ProviderConfig{DeleteBatchSize: 4}, fc, nil, "mem://")
+	bd := &memBatchDeleter{c: mc, max: 10, failures: make(map[string]bool)}
+	b.batchDeleter = bd
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	var names []string
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("obj%02d", i)
+		c.Assert(d.PutBytes(ctx, name, nil, nil), IsNil)
+		names = append(names, name)
+	}
+	c.Assert(d.PutBytes(ctx, "kept", nil, nil), IsNil)
+	bd.failures["dir/obj03"] = true
+	fc.failures["dir/obj03"] = 100
+
+	// Missing objects are not errors
+	err = d.DeleteObjects(ctx, append(names, "missing"))
+	be, ok := AsBatchError(err)
+	c.Assert(ok, Equals, true, Commentf("%v", err))
+	c.Assert(be.Len(), Equals, 1)
+	c.Assert(be.Items("")[0].Name, Equals, "dir/obj03")
+	c.Assert(bd.batches, DeepEquals, []int{4, 4, 3})
+	objs, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"kept", "obj03"})
+
+	// Without a batch deleter, objects are deleted one by one
+	b.batchDeleter = nil
+	c.Assert(d.DeleteObjects(ctx, []string{"kept"}), IsNil)
+	objs, err = d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"obj03"})
+}
+
+func (s *BatchDeleteSuite) TestS3DeleteBatch(c *C) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/bucket" || !hasQuery(r, "delete") {
+			http.Error(w, "unsupported", http.StatusNotImplemented)
+			return
+		}
+		var req struct {
+			Quiet   bool
+			Objects []struct {
+				Key string
+			} `xml:"Object"`
+		}
+		c.Assert(xml.NewDecoder(r.Body).Decode(&req), IsNil)
+		c.Check(req.Quiet, Equals, true)
+		fmt.Fprint(w, `<DeleteResult>`)
+		for _, o := range req.Objects {
+			got = append(got, o.Key)
+			if strings.HasPrefix(o.Key, "locked") {
+				fmt.Fprintf(w, `<Error><Key>%s</Key><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`, o.Key)
+			}
+		}
+		fmt.Fprint(w, `</DeleteResult>`)
+	}))
+	defer srv.Close()
+	client, err := newS3Client(ProviderConfig{Endpoint: srv.URL}, &Secret{
+		Type: SecretTypeAwsAccessKey,
+		Aws:  &SecretAws{AccessKeyID: "id", SecretAccessKey: "secret"},
+	}, "")
+	c.Assert(err, IsNil)
+
+	d := newS3BatchDeleter(client, "bucket")
+	c.Assert(d.MaxBatchSize(), Equals, s3MaxDeleteBatch)
+	keys := []string{"a/b", "locked/c", "d e"}
+	failed, err := d.DeleteBatch(context.Background(), keys)
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, keys)
+	c.Assert(failed, HasLen, 1)
+	c.Assert(failed["locked/c"], ErrorMatches, "AccessDenied: Access Denied")
+}
+
+func (s *BatchDeleteSuite) TestGCSDeleteBatch(c *C) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		c.Assert(err, IsNil)
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		mw := multipart.NewWriter(w)
+		w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			c.Check(part.Header.Get("Content-Type"), Equals, "application/http")
+			req, err := http.ReadRequest(bufio.NewReader(part))
+			c.Assert(err, IsNil)
+			c.Check(req.Method, Equals, http.MethodDelete)
+			key, err := url.PathUnescape(strings.TrimPrefix(req.URL.EscapedPath(), "/storage/v1/b/bucket/o/"))
+			c.Assert(err, IsNil)
+			got = append(got, key)
+			status := "204 No Content"
+			switch key {
+			case "missing":
+				status = "404 Not Found"
+			case "locked":
+				status = "403 Forbidden"
+			case "lost":
+				// No response
+				continue
+			}
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Type", "application/http")
+			h.Set("Content-ID", "<response-"+strings.Trim(part.Header.Get("Content-ID"), "<>")+">")
+			pw, err := mw.CreatePart(h)
+			c.Assert(err, IsNil)
+			fmt.Fprintf(pw, "HTTP/1.1 %s\r\nContent-Length: 0\r\n\r\n", status)
+		}
+		mw.Close()
+	}))
+	defer srv.Close()
+
+	d := newGCSBatchDeleter(srv.Client(), "bucket")
+	d.endpoint = srv.URL
+	c.Assert(d.MaxBatchSize(), Equals, gcsMaxDeleteBatch)
+	keys := []string{"dir/a b", "missing", "locked", "lost"}
+	failed, err := d.DeleteBatch(context.Background(), keys)
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, keys)
+	c.Assert(failed, HasLen, 2)
+	c.Assert(failed["locked"], ErrorMatches, "403 Forbidden")
+	c.Assert(failed["lost"], ErrorMatches, "no response in batch")
+}
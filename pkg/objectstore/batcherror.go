@@ -0,0 +1,248 @@
+package objectstore
+
+// Errors of operations over many objects
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxBatchErrorItems is the number of failed items kept by a
+	// BatchError. The others are only counted.
+	maxBatchErrorItems = 1000
+	// maxBatchErrorSummary is the number of failed items listed in the
+	// message of a BatchError
+	maxBatchErrorSummary = 5
+)
+
+// ErrorClass groups the errors of a BatchError by their likely cause
+type ErrorClass string
+
+const (
+	// ClassNotFound is the class of errors about missing objects
+	ClassNotFound ErrorClass = "notFound"
+	// ClassAccessDenied is the class of errors about missing permissions
+	ClassAccessDenied ErrorClass = "accessDenied"
+	// ClassTransient is the class of errors that may succeed if retried,
+	// such as throttling and timeouts
+	ClassTransient ErrorClass = "transient"
+	// ClassCanceled is the class of errors of cancelled operations
+	ClassCanceled ErrorClass = "canceled"
+	// ClassOther is the class of all other errors
+	ClassOther ErrorClass = "other"
+)
+
+var (
+	accessDeniedCodes = map[string]bool{"AccessDenied": true, "Forbidden": true, "InvalidAccessKeyId": true, "SignatureDoesNotMatch": true}
+	notFoundCodes     = map[string]bool{"NoSuchKey": true, "NotFound": true, "NoSuchBucket": true, "NoSuchVersion": true}
+)
+
+// classifyError returns the ErrorClass of err
+func classifyError(err error) ErrorClass {
+	err = errors.Cause(err)
+	switch err {
+	case stow.ErrNotFound:
+		return ClassNotFound
+	case context.Canceled, context.DeadlineExceeded:
+		return ClassCanceled
+	}
+	if _, ok := err.(*ErrObjectNotFound); ok {
+		return ClassNotFound
+	}
+	if ae, ok := err.(awserr.Error); ok {
+		switch {
+		case accessDeniedCodes[ae.Code()]:
+			return ClassAccessDenied
+		case notFoundCodes[ae.Code()]:
+			return ClassNotFound
+		}
+	}
+	if rf, ok := err.(awserr.RequestFailure); ok {
+		switch rf.StatusCode() {
+		case 401, 403:
+			return ClassAccessDenied
+		case 404:
+			return ClassNotFound
+		}
+	}
+	if isRetryable(err) {
+		return ClassTransient
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "403") || strings.Contains(msg, "401") || strings.Contains(msg, "AccessDenied"):
+		return ClassAccessDenied
+	case strings.Contains(msg, "404") || strings.Contains(msg, "NoSuchKey") || strings.Contains(msg, "BlobNotFound"):
+		return ClassNotFound
+	}
+	return ClassOther
+}
+
+// BatchErrorItem is the failure of an operation on one object
+type BatchErrorItem struct {
+	// Name of the object, redacted like the names in logs
+	Name string
+	// Op is the operation that failed, e.g. "delete"
+	Op    string
+	Class ErrorClass
+	Err   error
+}
+
+func (i BatchErrorItem) Error() string {
+	return fmt.Sprintf("failed to %s %s: %v", i.Op, i.Name, i.Err)
+}
+
+// BatchError reports the objects that an operation over many objects
+// failed on. It keeps the items of the first objects by name and counts
+// all of them by class. Its message only lists a few items, however many
+// failed.
+type BatchError struct {
+	items  []BatchErrorItem
+	total  int
+	counts map[ErrorClass]int
+}
+
+// AsBatchError returns the BatchError that err was caused by, if any,
+// including the errors of an ErrDeleteIncomplete
+func AsBatchError(err error) (*BatchError, bool) {
+	switch e := errors.Cause(err).(type) {
+	case *BatchError:
+		return e, true
+	case *ErrDeleteIncomplete:
+		return e.Errors, e.Errors != nil
+	}
+	return nil, false
+}
+
+func newBatchError() *BatchError {
+	return &BatchError{counts: make(map[ErrorClass]int)}
+}
+
+// add records the failure of op on the object name, which must be
+// redacted. It is not safe for concurrent use.
+func (e *BatchError) add(name, op string, err error) {
+	class := classifyError(err)
+	e.total++
+	e.counts[class]++
+	// Keep the first names, so that the items do not depend on the order
+	// of concurrent operations
+	i := sort.Search(len(e.items), func(i int) bool { return e.items[i].Name >= name })
+	if i >= maxBatchErrorItems {
+		return
+	}
+	e.items = append(e.items, BatchErrorItem{})
+	copy(e.items[i+1:], e.items[i:])
+	e.items[i] = BatchErrorItem{Name: name, Op: op, Class: class, Err: err}
+	if len(e.items) > maxBatchErrorItems {
+		e.items = e.items[:maxBatchErrorItems]
+	}
+}
+
+// errOrNil returns e, or nil if nothing failed
+func (e *BatchError) errOrNil() error {
+	if e.total == 0 {
+		return nil
+	}
+	return e
+}
+
+// Len returns the number of failed items
+func (e *BatchError) Len() int {
+	return e.total
+}
+
+// Counts returns the number of failed items by class
+func (e *BatchError) Counts() map[ErrorClass]int {
+	counts := make(map[ErrorClass]int, len(e.counts))
+	for class, n := range e.counts {
+		counts[class] = n
+	}
+	return counts
+}
+
+// Items returns the kept items of the class, or all of them if class is
+// empty
+func (e *BatchError) Items(class ErrorClass) []BatchErrorItem {
+	var items []BatchErrorItem
+	for _, i := range e.items {
+		if class == "" || i.Class == class {
+			items = append(items, i)
+		}
+	}
+	return items
+}
+
+// Is returns true if the cause of the error of a kept item is target
+func (e *BatchError) Is(target error) bool {
+	for _, i := range e.items {
+		if i.Err == target || errors.Cause(i.Err) == target {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("%d operations failed (%s): %s", e.total, e.countSummary(), e.itemSummary())
+}
+
+// countSummary lists the counts by class
+func (e *BatchError) countSummary() string {
+	classes := make([]string, 0, len(e.counts))
+	for class, n := range e.counts {
+		classes = append(classes, fmt.Sprintf("%d %s", n, class))
+	}
+	sort.Strings(classes)
+	return strings.Join(classes, ", ")
+}
+
+// itemSummary lists the first items and the number of the others
+func (e *BatchError) itemSummary() string {
+	n := len(e.items)
+	if n > maxBatchErrorSummary {
+		n = maxBatchErrorSummary
+	}
+	msgs := make([]string, 0, n+1)
+	for _, i := range e.items[:n] {
+		msgs = append(msgs, i.Error())
+	}
+	if more := e.total - n; more > 0 {
+		msgs = append(msgs, fmt.Sprintf("and %d more", more))
+	}
+	return strings.Join(msgs, "; ")
+}
+
+type batchErrorItemJSON struct {
+	Name  string     `json:"name"`
+	Op    string     `json:"op"`
+	Class ErrorClass `json:"class"`
+	Error string     `json:"error"`
+}
+
+type batchErrorJSON struct {
+	Total  int                  `json:"total"`
+	Counts map[ErrorClass]int   `json:"counts"`
+	Items  []batchErrorItemJSON `json:"items"`
+}
+
+// MarshalJSON serializes the counts and the kept items, for reports and
+// phase outputs
+func (e *BatchError) MarshalJSON() ([]byte, error) {
+	out := batchErrorJSON{
+		Total:  e.total,
+		Counts: e.counts,
+		Items:  make([]batchErrorItemJSON, 0, len(e.items)),
+	}
+	for _, i := range e.items {
+		out.Items = append(out.Items, batchErrorItemJSON{Name: i.Name, Op: i.Op, Class: i.Class, Error: i.Err.Error()})
+	}
+	return json.Marshal(out)
+}
@@ -0,0 +1,135 @@
+package objectstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type BatchErrorSuite struct{}
+
+var _ = Suite(&BatchErrorSuite{})
+
+func (s *BatchErrorSuite) TestClassifyError(c *C) {
+	for _, tc := range []struct {
+		err   error
+		class ErrorClass
+	}{
+		{err: stow.ErrNotFound, class: ClassNotFound},
+		{err: errors.Wrap(stow.ErrNotFound, "failed to read"), class: ClassNotFound},
+		{err: awserr.New("NoSuchKey", "", nil), class: ClassNotFound},
+		{err: awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "id"), class: ClassAccessDenied},
+		{err: errors.New("googleapi: Error 403: Forbidden, forbidden"), class: ClassAccessDenied},
+		{err: errors.New("googleapi: Error 404: Not Found, notFound"), class: ClassNotFound},
+		{err: awserr.NewRequestFailure(awserr.New("SlowDown", "", nil), 503, "id"), class: ClassTransient},
+		{err: errors.New("503 Service Unavailable"), class: ClassTransient},
+		{err: context.Canceled, class: ClassCanceled},
+		{err: errors.New("invalid key"), class: ClassOther},
+	} {
+		c.Check(classifyError(tc.err), Equals, tc.class, Commentf("%v", tc.err))
+	}
+}
+
+func (s *BatchErrorSuite) TestBatchError(c *C) {
+	e := newBatchError()
+	c.Assert(e.errOrNil(), IsNil)
+	denied := awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "id")
+	e.add("c", "delete", denied)
+	e.add("a", "delete", errors.Wrap(stow.ErrNotFound, "failed to read"))
+	e.add("b", "copy", errors.New("503 Service Unavailable"))
+	e.add("d", "delete", denied)
+
+	var err error = e
+	c.Assert(e.errOrNil(), Equals, err)
+	c.Assert(e.Len(), Equals, 4)
+	c.Assert(e.Counts(), DeepEquals, map[ErrorClass]int{ClassAccessDenied: 2, ClassNotFound: 1, ClassTransient: 1})
+	items := e.Items(ClassAccessDenied)
+	c.Assert(items, HasLen, 2)
+	c.Assert(items[0].Name, Equals, "c")
+	c.Assert(items[1].Name, Equals, "d")
+	c.Assert(e.Items(ClassCanceled), HasLen, 0)
+	c.Assert(e.Items(""), HasLen, 4)
+	c.Assert(err, ErrorMatches, "(?s)4 operations failed \\(1 notFound, 1 transient, 2 accessDenied\\): "+
+		"failed to delete a: failed to read: not found; "+
+		"failed to copy b: 503 Service Unavailable; "+
+		"failed to delete c: AccessDenied: Access Denied\n.*")
+
+	// Matches the causes of the items
+	c.Assert(e.Is(stow.ErrNotFound), Equals, true)
+	c.Assert(e.Is(denied), Equals, true)
+	c.Assert(e.Is(context.Canceled), Equals, false)
+
+	be, ok := AsBatchError(errors.Wrap(err, "failed to clean up"))
+	c.Assert(ok, Equals, true)
+	c.Assert(be, Equals, e)
+	_, ok = AsBatchError(stow.ErrNotFound)
+	c.Assert(ok, Equals, false)
+	be, ok = AsBatchError(&ErrDeleteIncomplete{Path: "/dir/", Remaining: 4, Errors: e})
+	c.Assert(ok, Equals, true)
+	c.Assert(be, Equals, e)
+
+	data, err := json.Marshal(e)
+	c.Assert(err, IsNil)
+	var decoded struct {
+		Total  int
+		Counts map[string]int
+		Items  []map[string]string
+	}
+	c.Assert(json.Unmarshal(data, &decoded), IsNil)
+	c.Assert(decoded.Total, Equals, 4)
+	c.Assert(decoded.Counts, DeepEquals, map[string]int{"accessDenied": 2, "notFound": 1, "transient": 1})
+	c.Assert(decoded.Items, HasLen, 4)
+	c.Assert(decoded.Items[1], DeepEquals, map[string]string{
+		"name":  "b",
+		"op":    "copy",
+		"class": "transient",
+		"error": "503 Service Unavailable",
+	})
+}
+
+func (s *BatchErrorSuite) TestBounds(c *C) {
+	e := newBatchError()
+	failure := errors.New("503 Service Unavailable")
+	for i := 0; i < 1000000; i++ {
+		e.add(fmt.Sprintf("obj%07d", i), "delete", failure)
+	}
+	// Earlier names are kept
+	e.add("a", "delete", context.Canceled)
+	c.Assert(e.Len(), Equals, 1000001)
+	items := e.Items("")
+	c.Assert(items, HasLen, maxBatchErrorItems)
+	c.Assert(items[0].Name, Equals, "a")
+	c.Assert(items[maxBatchErrorItems-1].Name, Equals, fmt.Sprintf("obj%07d", maxBatchErrorItems-2))
+
+	msg := e.Error()
+	c.Assert(len(msg) < 500, Equals, true, Commentf("%d bytes", len(msg)))
+	c.Assert(msg, Matches, `1000001 operations failed \(1 canceled, 1000000 transient\): failed to delete a: context canceled; .*; and 999996 more`)
+	data, err := json.Marshal(e)
+	c.Assert(err, IsNil)
+	c.Assert(len(data) < 200*maxBatchErrorItems, Equals, true, Commentf("%d bytes", len(data)))
+}
+
+func (s *BatchErrorSuite) TestRewritePrefixErrors(c *C) {
+	ctx := context.Background()
+	fc := &failingContainer{memContainer: newMemContainer("rewrite"), failures: make(map[string]int)}
+	b := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	for i := 0; i < 4; i++ {
+		c.Assert(b.PutBytes(ctx, fmt.Sprintf("old/obj%d", i), []byte("data"), nil), IsNil)
+	}
+	fc.failures["old/obj1"] = 1
+	fc.failures["old/obj3"] = 1
+	mapping := func(p string) (string, bool) { return "new/" + p, false }
+	stats, err := RewritePrefix(ctx, b, mapping, RewriteOptions{})
+	c.Assert(stats.Moved, Equals, 2)
+	be, ok := AsBatchError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(be.Items(ClassTransient), HasLen, 2)
+	c.Assert(err, ErrorMatches, "2 operations failed \\(2 transient\\): "+
+		"failed to move old/obj1: failed to delete old/obj1: 503 Service Unavailable; "+
+		"failed to move old/obj3: failed to delete old/obj3: 503 Service Unavailable")
+}
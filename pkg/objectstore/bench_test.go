@@ -0,0 +1,179 @@
+package objectstore_test
+
+// Benchmarks of operations over large trees. Run them with
+//   go test ./pkg/objectstore -check.b -check.f BenchmarkSuite
+// The s3compat provider benchmarks an S3 compatible store, such as a local
+// Minio container, set with BENCH_S3_ENDPOINT, AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY.
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+	"github.com/kanisterio/kanister/pkg/objectstore/ostest"
+)
+
+const benchBucketName = "kio-store-bench"
+
+type BenchmarkSuite struct {
+	provider string
+	root     objectstore.Directory
+	dir      objectstore.Directory
+}
+
+var _ = Suite(&BenchmarkSuite{provider: "mem"})
+var _ = Suite(&BenchmarkSuite{provider: "s3compat"})
+
+func (s *BenchmarkSuite) SetUpSuite(c *C) {
+	ctx := context.Background()
+	switch s.provider {
+	case "mem":
+		s.root = objectstore.NewMemBucket(benchBucketName)
+	case "s3compat":
+		endpoint := os.Getenv("BENCH_S3_ENDPOINT")
+		if endpoint == "" {
+			c.Skip("BENCH_S3_ENDPOINT is not set")
+		}
+		p, err := objectstore.NewProvider(ctx, objectstore.ProviderConfig{
+			Type:          objectstore.ProviderTypeS3,
+			Endpoint:      endpoint,
+			SkipSSLVerify: true,
+		}, &objectstore.Secret{
+			Type: objectstore.SecretTypeAwsAccessKey,
+			Aws: &objectstore.SecretAws{
+				AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			},
+		})
+		c.Assert(err, IsNil)
+		s.root, err = objectstore.GetOrCreateBucket(ctx, p, benchBucketName, "us-east-1")
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *BenchmarkSuite) SetUpTest(c *C) {
+	var err error
+	name := fmt.Sprintf("%s-%s", time.Now().UTC().Format(time.RFC3339Nano), c.TestName())
+	s.dir, err = s.root.CreateDirectory(context.Background(), name)
+	c.Assert(err, IsNil)
+}
+
+func (s *BenchmarkSuite) TearDownTest(c *C) {
+	if s.dir != nil {
+		err := s.dir.DeleteDirectory(context.Background())
+		c.Check(err, IsNil)
+		s.dir = nil
+	}
+}
+
+// benchSpec is a tree of n small objects of various sizes
+func benchSpec(n int) ostest.PopulateSpec {
+	return ostest.PopulateSpec{
+		Objects:      n,
+		Distribution: ostest.SizeLogNormal,
+		Size:         256,
+		MaxSize:      64 * 1024,
+		FanOut:       10,
+		Depth:        2,
+		Seed:         1,
+		Concurrency:  32,
+	}
+}
+
+func (s *BenchmarkSuite) populate(c *C, d objectstore.Directory, n int) {
+	_, err := ostest.Populate(context.Background(), d, benchSpec(n))
+	c.Assert(err, IsNil)
+}
+
+func (s *BenchmarkSuite) benchmarkList(c *C, n int) {
+	s.populate(c, s.dir, n)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		objects, err := s.dir.ListObjectsRecursive(context.Background())
+		c.Assert(err, IsNil)
+		c.Assert(objects, HasLen, n)
+	}
+}
+
+func (s *BenchmarkSuite) benchmarkDelete(c *C, n int) {
+	for i := 0; i < c.N; i++ {
+		c.StopTimer()
+		d, err := s.dir.CreateDirectory(context.Background(), fmt.Sprintf("delete%d", i))
+		c.Assert(err, IsNil)
+		s.populate(c, d, n)
+		c.StartTimer()
+		err = d.DeleteDirectory(context.Background())
+		c.Assert(err, IsNil)
+	}
+}
+
+// benchmarkCopy moves the tree back and forth between two sub directories
+// with RewritePrefix, which copies and verifies every object
+func (s *BenchmarkSuite) benchmarkCopy(c *C, n int) {
+	src, err := s.dir.CreateDirectory(context.Background(), "a")
+	c.Assert(err, IsNil)
+	s.populate(c, src, n)
+	mapping := func(p string) (string, bool) {
+		if strings.HasPrefix(p, "a/") {
+			return "b/" + strings.TrimPrefix(p, "a/"), false
+		}
+		return "a/" + strings.TrimPrefix(p, "b/"), false
+	}
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		stats, err := objectstore.RewritePrefix(context.Background(), s.dir, mapping, objectstore.RewriteOptions{Concurrency: 32})
+		c.Assert(err, IsNil)
+		c.Assert(stats.Moved, Equals, n)
+	}
+}
+
+// benchmarkGet reads n small objects one at a time with GetBytes, or with
+// MultiGet if concurrency is positive
+func (s *BenchmarkSuite) benchmarkGet(c *C, n, concurrency int) {
+	ctx := context.Background()
+	s.populate(c, s.dir, n)
+	names, err := s.dir.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		if concurrency > 0 {
+			objects, err := s.dir.MultiGet(ctx, names, concurrency)
+			c.Assert(err, IsNil)
+			c.Assert(objects, HasLen, n)
+			continue
+		}
+		for _, name := range names {
+			_, _, err := s.dir.GetBytes(ctx, name)
+			c.Assert(err, IsNil)
+		}
+	}
+}
+
+func (s *BenchmarkSuite) BenchmarkList10k(c *C)    { s.benchmarkList(c, 10000) }
+func (s *BenchmarkSuite) BenchmarkList100k(c *C)   { s.benchmarkList(c, 100000) }
+func (s *BenchmarkSuite) BenchmarkDelete10k(c *C)  { s.benchmarkDelete(c, 10000) }
+func (s *BenchmarkSuite) BenchmarkDelete100k(c *C) { s.benchmarkDelete(c, 100000) }
+func (s *BenchmarkSuite) BenchmarkCopy10k(c *C)    { s.benchmarkCopy(c, 10000) }
+func (s *BenchmarkSuite) BenchmarkCopy100k(c *C)   { s.benchmarkCopy(c, 100000) }
+
+func (s *BenchmarkSuite) BenchmarkGetBytes100(c *C) { s.benchmarkGet(c, 100, 0) }
+func (s *BenchmarkSuite) BenchmarkMultiGet100(c *C) { s.benchmarkGet(c, 100, 32) }
+
+// TestPopulate checks that the trees of the benchmarks can be validated
+// against the provider
+func (s *BenchmarkSuite) TestPopulate(c *C) {
+	ctx := context.Background()
+	spec := benchSpec(100)
+	spec.Tags = map[string]string{"index": "{index}"}
+	summary, err := ostest.Populate(ctx, s.dir, spec)
+	c.Assert(err, IsNil)
+	validated, err := ostest.Validate(ctx, s.dir, spec)
+	c.Assert(err, IsNil)
+	c.Assert(validated, DeepEquals, summary)
+}
@@ -26,10 +26,168 @@ var _ Bucket = (*bucket)(nil)
 
 // bucket implements the Bucket functionality
 type bucket struct {
-	*directory                  // bucket is the root directory
-	container    stow.Container // stow bucket
-	location     stow.Location  // Authenticated stow handle
-	hostEndPoint string         // E.g., https://s3-us-west-2.amazonaws.com/bucket1
+	*directory                     // bucket is the root directory
+	container       stow.Container // stow bucket
+	location        stow.Location  // Authenticated stow handle
+	hostEndPoint    string         // E.g., https://s3-us-west-2.amazonaws.com/bucket1
+	limits          PathLimits     // Limits on object keys
+	clock           clock          // Overrides the real clock in tests
+	rand            randSource     // Overrides math/rand in tests
+	versioner       versioner      // Handles object versions, if supported
+	multipart       multipartStore // Uploads large objects in parts, if supported
+	putOptions      PutOptions     // Defaults for uploads
+	redaction       NameRedaction  // Redaction of names in logs and errors
+	pageSize        int            // Items per page when listing
+	batchDeleter    batchDeleter   // Deletes objects in batches, if supported
+	ranger          rangeReader    // Reads byte ranges of objects, if supported
+	copier          objectCopier   // Copies objects on the server, if supported
+	prefixLister    prefixLister   // Lists directories with a delimiter, if supported
+	presigner       presigner      // Presigns URLs of objects, if supported
+	ifAbsentWriter  ifAbsentWriter // Writes objects if they do not exist, if supported
+	attrsReader     attrsReader    // Reads the content types of objects, if supported
+	objectTagger    objectTagger   // Reads and writes object tags, if supported
+	deleteBatchSize int            // Objects per batch delete, if set
+	deleteWorkers   int            // Objects deleted concurrently
+	copyWorkers     int            // Objects copied concurrently
+	getBytesLimit   int64          // Largest object read by GetBytes
+	retry           RetryPolicy    // Retries of transient errors
+	quota           *quota         // Usage against the quota, if set
+	listingCache    *listingCache  // Caches listings of directories, if set
+	drain           *drain         // Operations in flight, for Shutdown
+	skipMarkers     bool           // CreateDirectory does not write markers
+	maxObjectSize   int64          // Largest object accepted, if limited
+	compression     string         // Codec of Put, if set
+
+	// Server-side encryption of Put, if set
+	sse ServerSideEncryption
+	// Encrypts and decrypts objects on the client, if set
+	keyring *Keyring
+	// Put gives objects the content type of their name, if set
+	inferContentType bool
+	// Updates the metadata of objects in place, if supported
+	metadataPatcher metadataPatcher
+	// Reads the tags written before tag keys were encoded
+	legacyTags LegacyTagsConfig
+	// Features registered in the layout marker by this process
+	layout *layoutFeatures
+}
+
+func newBucket(config ProviderConfig, c stow.Container, location stow.Location, hostEndPoint string) *bucket {
+	dir := &directory{
+		path: "/",
+	}
+	bucket := &bucket{
+		directory:       dir,
+		container:       c,
+		location:        location,
+		hostEndPoint:    path.Join(hostEndPoint, c.ID()),
+		limits:          effectiveLimits(config),
+		putOptions:      config.PutOptions,
+		redaction:       config.Redaction,
+		pageSize:        pageSize(config),
+		deleteBatchSize: config.DeleteBatchSize,
+		deleteWorkers:   deleteWorkers(config),
+		copyWorkers:     copyWorkers(config),
+		getBytesLimit:   getBytesLimitOf(config),
+		retry:           config.Retry,
+		quota:           newQuota(config.Quota),
+		listingCache:    newListingCache(config.ListingCache),
+		drain:           newDrain(),
+		layout:          newLayoutFeatures(),
+		skipMarkers:     config.SkipDirectoryMarkers,
+		maxObjectSize:   ProviderCapabilities(config.Type).MaxObjectSize,
+		compression:     config.Compression,
+		sse:             config.ServerSideEncryption,
+		keyring:         config.Keyring,
+
+		inferContentType: config.InferContentType,
+		legacyTags:       config.LegacyTags,
+	}
+	dir.bucket = bucket
+	return bucket
+}
+
+// pageSize returns the number of items requested per page when listing
+func pageSize(config ProviderConfig) int {
+	if config.PageSize <= 0 {
+		return listPageSize
+	}
+	return config.PageSize
+}
+
+// deleteWorkers returns the number of objects deleted concurrently
+func deleteWorkers(config ProviderConfig) int {
+	if config.DeleteConcurrency <= 0 {
+		return deleteConcurrency
+	}
+	return config.DeleteConcurrency
+}
+
+// copyWorkers returns the number of objects copied concurrently
+func copyWorkers(config ProviderConfig) int {
+	if config.CopyConcurrency <= 0 {
+		return copyConcurrency
+	}
+	return config.CopyConcurrency
+}
+
+// getBytesLimitOf returns the size of the largest object read by GetBytes
+func getBytesLimitOf(config ProviderConfig) int64 {
+	if config.GetBytesLimit <= 0 {
+		return getBytesLimit
+	}
+	return config.GetBytesLimit
+}
+
+// newBucket returns a bucket with the multipart store and batch deleter of
+// the provider, if it has them
+func (p *provider) newBucket(ctx context.Context, c stow.Container, location stow.Location) (*bucket, error) {
+	b := newBucket(p.config, c, location, p.hostEndPoint)
+	if p.config.Type == ProviderTypeAzure {
+		presigner, err := newAzurePresigner(p.secret, c.ID())
+		if err != nil {
+			return nil, err
+		}
+		b.presigner = presigner
+		b.ifAbsentWriter = newAzureIfAbsentWriter(presigner, p.config.Redaction)
+	}
+	if p.config.Type == ProviderTypeGCS {
+		client, err := newGCSClient(ctx, p.secret)
+		if err != nil {
+			return nil, err
+		}
+		if b.multipart, err = newGCSMultipart(client, c.ID(), p.config.Redaction); err != nil {
+			return nil, err
+		}
+		b.batchDeleter = newGCSBatchDeleter(client, c.ID())
+		if b.prefixLister, err = newGCSPrefixLister(client, c.ID()); err != nil {
+			return nil, err
+		}
+		if b.copier, err = newGCSCopier(client, c.ID(), p.config.Redaction); err != nil {
+			return nil, err
+		}
+		if b.presigner, err = newGCSPresigner(ctx, p.secret, c.ID()); err != nil {
+			return nil, err
+		}
+		if b.ifAbsentWriter, err = newGCSIfAbsentWriter(client, c.ID(), p.config.Redaction); err != nil {
+			return nil, err
+		}
+		if b.attrsReader, err = newGCSAttrsReader(client, c.ID(), p.config.Redaction); err != nil {
+			return nil, err
+		}
+		if b.metadataPatcher, err = newGCSMetadataPatcher(client, c.ID()); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Capabilities returns the capabilities of the provider, including the
+// limits in effect for object keys
+func (p *provider) Capabilities() Capabilities {
+	c := ProviderCapabilities(p.config.Type)
+	c.Limits = effectiveLimits(p.config)
+	return c
 }
 
 // CreateBucket creates the bucket. Bucket naming rules are provider dependent.
@@ -42,17 +200,7 @@ func (p *provider) CreateBucket(ctx context.Context, bucketName, region string)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create bucket %s", bucketName)
 	}
-	dir := &directory{
-		path: "/",
-	}
-	bucket := &bucket{
-		directory:    dir,
-		container:    c,
-		location:     location,
-		hostEndPoint: path.Join(p.hostEndPoint, c.ID()),
-	}
-	dir.bucket = bucket
-	return bucket, nil
+	return p.newBucket(ctx, c, location)
 }
 
 // GetBucket gets the handle for the specified bucket. Buckets are searched using prefix search;
@@ -64,23 +212,24 @@ func (p *provider) GetBucket(ctx context.Context, bucketName string) (Bucket, er
 	}
 	c, err := location.Container(bucketName)
 	if err != nil {
+		if isBucketNotFoundError(err) {
+			err = &ErrBucketNotFound{Bucket: bucketName}
+		}
 		return nil, errors.Wrapf(err, "failed to get bucket %s", bucketName)
 	}
-	dir := &directory{
-		path: "/",
-	}
-	bucket := &bucket{
-		directory:    dir,
-		container:    c,
-		location:     location,
-		hostEndPoint: path.Join(p.hostEndPoint, c.ID()),
-	}
-	dir.bucket = bucket
-	return bucket, nil
+	return p.newBucket(ctx, c, location)
 }
 
 // ListBuckets gets the handles of all the buckets.
 func (p *provider) ListBuckets(ctx context.Context) (map[string]Bucket, error) {
+	return p.listBuckets(ctx, func(c stow.Container, location stow.Location) (Bucket, error) {
+		return p.newBucket(ctx, c, location)
+	})
+}
+
+// listBuckets walks all the buckets, and returns the handles that open
+// returns for them
+func (p *provider) listBuckets(ctx context.Context, open func(c stow.Container, location stow.Location) (Bucket, error)) (map[string]Bucket, error) {
 	// Walk all the buckets
 	buckets := make(map[string]Bucket)
 
@@ -88,23 +237,20 @@ func (p *provider) ListBuckets(ctx context.Context) (map[string]Bucket, error) {
 	if err != nil {
 		return nil, err
 	}
-	err = stow.WalkContainers(location, stow.NoPrefix, 10000,
+	err = stow.WalkContainers(location, stow.NoPrefix, pageSize(p.config),
 		func(c stow.Container, err error) error {
 			if err != nil {
 				return err
 			}
-
-			dir := &directory{
-				path: "/",
+			if err := ctx.Err(); err != nil {
+				return err
 			}
-			bucket := &bucket{
-				directory:    dir,
-				container:    c,
-				location:     location,
-				hostEndPoint: path.Join(p.hostEndPoint, c.ID()),
+
+			b, err := open(c, location)
+			if err != nil {
+				return err
 			}
-			dir.bucket = bucket
-			buckets[c.ID()] = bucket
+			buckets[c.ID()] = b
 			return nil
 		})
 	if err != nil {
@@ -138,15 +284,13 @@ type s3Provider struct {
 }
 
 func (p *s3Provider) GetBucket(ctx context.Context, bucketName string) (Bucket, error) {
-	hostEndPoint := p.hostEndPoint
 	var region string
-	if hostEndPoint == "" {
+	if p.hostEndPoint == "" {
 		var err error
 		region, err = p.getRegionForBucket(ctx, bucketName)
 		if err != nil {
 			return nil, errors.Wrapf(err, "could not get region for bucket %s", bucketName)
 		}
-		hostEndPoint = awsS3Endpoint(region)
 	}
 	location, err := getStowLocation(ctx, p.config, p.secret, region)
 	if err != nil {
@@ -154,19 +298,59 @@ func (p *s3Provider) GetBucket(ctx context.Context, bucketName string) (Bucket,
 	}
 	c, err := location.Container(bucketName)
 	if err != nil {
+		if isBucketNotFoundError(err) {
+			err = &ErrBucketNotFound{Bucket: bucketName}
+		}
 		return nil, errors.Wrapf(err, "failed to get bucket %s", bucketName)
 	}
-	dir := &directory{
-		path: "/",
+	return p.newS3Bucket(c, location, region)
+}
+
+// CreateBucket creates the bucket in the region
+func (p *s3Provider) CreateBucket(ctx context.Context, bucketName, region string) (Bucket, error) {
+	location, err := getStowLocation(ctx, p.config, p.secret, region)
+	if err != nil {
+		return nil, err
 	}
-	bucket := &bucket{
-		directory:    dir,
-		container:    c,
-		location:     location,
-		hostEndPoint: path.Join(hostEndPoint, c.ID()),
+	c, err := location.CreateContainer(bucketName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create bucket %s", bucketName)
 	}
-	dir.bucket = bucket
-	return bucket, nil
+	return p.newS3Bucket(c, location, region)
+}
+
+// ListBuckets gets the handles of all the buckets, each in its own region
+func (p *s3Provider) ListBuckets(ctx context.Context) (map[string]Bucket, error) {
+	return p.listBuckets(ctx, func(c stow.Container, location stow.Location) (Bucket, error) {
+		return p.GetBucket(ctx, c.ID())
+	})
+}
+
+// newS3Bucket returns a bucket of the container c, in the region, with the
+// helpers that use the S3 API where stow falls short. All the S3 buckets of
+// the provider are made by it.
+func (p *s3Provider) newS3Bucket(c stow.Container, location stow.Location, region string) (*bucket, error) {
+	hostEndPoint := p.hostEndPoint
+	if hostEndPoint == "" {
+		hostEndPoint = awsS3Endpoint(region)
+	}
+	client, err := newS3Client(p.config, p.secret, region)
+	if err != nil {
+		return nil, err
+	}
+	bucketName := c.ID()
+	b := newBucket(p.config, c, location, hostEndPoint)
+	b.versioner = newS3Versioner(client, bucketName, p.config.Redaction)
+	b.multipart = newS3Multipart(client, bucketName)
+	b.batchDeleter = newS3BatchDeleter(client, bucketName)
+	b.ranger = newS3Ranger(client, bucketName, p.config.Redaction)
+	b.copier = newS3Copier(client, bucketName, p.config.Redaction)
+	b.prefixLister = newS3PrefixLister(client, bucketName)
+	b.presigner = newS3Presigner(client, bucketName)
+	b.ifAbsentWriter = newS3IfAbsentWriter(client, bucketName, p.config.Redaction)
+	b.attrsReader = newS3AttrsReader(client, bucketName, p.config.Redaction)
+	b.objectTagger = newS3ObjectTagger(client, bucketName)
+	return b, nil
 }
 
 func (p *s3Provider) DeleteBucket(ctx context.Context, bucketName string) error {
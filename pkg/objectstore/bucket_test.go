@@ -0,0 +1,56 @@
+package objectstore
+
+import (
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+type BucketSuite struct{}
+
+var _ = Suite(&BucketSuite{})
+
+// checkS3Helpers checks that b has the helpers that use the S3 API
+func checkS3Helpers(c *C, b Bucket) {
+	bu, ok := b.(*bucket)
+	c.Assert(ok, Equals, true)
+	c.Check(bu.versioner, NotNil)
+	c.Check(bu.multipart, NotNil)
+	c.Check(bu.batchDeleter, NotNil)
+	c.Check(bu.ranger, NotNil)
+	c.Check(bu.copier, NotNil)
+	c.Check(bu.prefixLister, NotNil)
+	c.Check(bu.presigner, NotNil)
+	c.Check(bu.ifAbsentWriter, NotNil)
+	c.Check(bu.attrsReader, NotNil)
+	c.Check(bu.objectTagger, NotNil)
+}
+
+func (s *BucketSuite) TestS3Buckets(c *C) {
+	ctx := context.Background()
+	l := newMemLocation()
+	defer l.dial()()
+	p, err := NewProvider(ctx, ProviderConfig{Type: ProviderTypeS3, Endpoint: "http://s3.local"}, &Secret{
+		Type: SecretTypeAwsAccessKey,
+		Aws:  &SecretAws{AccessKeyID: "id", SecretAccessKey: "secret"},
+	})
+	c.Assert(err, IsNil)
+
+	created, err := p.CreateBucket(ctx, "created", "us-west-2")
+	c.Assert(err, IsNil)
+	checkS3Helpers(c, created)
+	got, err := p.GetBucket(ctx, "created")
+	c.Assert(err, IsNil)
+	checkS3Helpers(c, got)
+	// Missing buckets are created with the helpers too
+	other, err := p.(*s3Provider).getOrCreateBucket(ctx, "other", "us-west-2")
+	c.Assert(err, IsNil)
+	checkS3Helpers(c, other)
+
+	buckets, err := p.ListBuckets(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(buckets, HasLen, 2)
+	for _, b := range buckets {
+		checkS3Helpers(c, b)
+	}
+}
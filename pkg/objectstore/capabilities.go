@@ -0,0 +1,184 @@
+package objectstore
+
+// Provider capabilities and limits
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// S3 and GCS limit keys to 1024 bytes, Azure limits blob names to
+	// 1024 characters.
+	maxKeyLength = 1024
+	// Azure limits blob names to 254 path segments.
+	azureMaxDepth = 254
+	// The strictest limits across all supported providers
+	defaultMaxKeyLength = maxKeyLength
+	defaultMaxDepth     = azureMaxDepth
+	// S3 and GCS limit objects to 5 TiB
+	maxObjectSize = 5 * 1024 * 1024 * 1024 * 1024
+)
+
+// ErrObjectTooLarge is returned by uploads of objects larger than the
+// provider allows, and by reads of objects larger than the limit of the
+// read. The upload is rejected before any data is sent. The Size of objects
+// that are read is unknown, and is -1.
+type ErrObjectTooLarge struct {
+	Name string
+	Size int64
+	Max  int64
+}
+
+func (e *ErrObjectTooLarge) Error() string {
+	if e.Size < 0 {
+		return fmt.Sprintf("object %s exceeds the limit of %d bytes", e.Name, e.Max)
+	}
+	return fmt.Sprintf("object %s of %d bytes exceeds the maximum object size of %d bytes", e.Name, e.Size, e.Max)
+}
+
+// IsObjectTooLarge returns true if the cause of err is an ErrObjectTooLarge
+func IsObjectTooLarge(err error) bool {
+	_, ok := errors.Cause(err).(*ErrObjectTooLarge)
+	return ok
+}
+
+// PathLimits bounds the object keys that can be created. Keys that exceed
+// the Max limits are rejected. Keys that exceed the Soft limits are accepted,
+// but a warning is logged. Zero values disable a limit.
+type PathLimits struct {
+	// MaxKeyLength is the maximum length of an object key in bytes
+	MaxKeyLength int
+	// MaxDepth is the maximum number of '/' separated components in a key
+	MaxDepth int
+	// SoftKeyLength is the key length in bytes above which a warning is logged
+	SoftKeyLength int
+	// SoftDepth is the key depth above which a warning is logged
+	SoftDepth int
+}
+
+// Capabilities describes the features and limits of an object store
+type Capabilities struct {
+	// Limits in effect for object keys
+	Limits PathLimits
+	// ContentType is true if objects can be given a content type
+	ContentType bool
+	// MaxObjectSize is the size of the largest object in bytes, or zero if
+	// the provider has no known limit
+	MaxObjectSize int64
+	// Presign is true if URLs of objects can be presigned. GCS presigns
+	// URLs only with service account keys.
+	Presign bool
+	// ServerCopy is true if objects are copied within a bucket by the
+	// provider, without transferring their data. Other copies are streamed.
+	ServerCopy bool
+	// AtomicPutIfNotExists is true if PutIfNotExists checks and writes
+	// objects in one conditional request, so that it can implement locks
+	AtomicPutIfNotExists bool
+	// ServerSideEncryption is true if objects can be encrypted by the
+	// provider as described by a ServerSideEncryption
+	ServerSideEncryption bool
+}
+
+// ProviderCapabilities returns the capabilities of the given provider type
+func ProviderCapabilities(t ProviderType) Capabilities {
+	switch t {
+	case ProviderTypeS3, ProviderTypeGCS:
+		return Capabilities{
+			Limits:               PathLimits{MaxKeyLength: maxKeyLength},
+			ContentType:          true,
+			MaxObjectSize:        maxObjectSize,
+			Presign:              true,
+			ServerCopy:           true,
+			AtomicPutIfNotExists: true,
+			ServerSideEncryption: true,
+		}
+	case ProviderTypeAzure:
+		return Capabilities{
+			Limits:               PathLimits{MaxKeyLength: maxKeyLength, MaxDepth: azureMaxDepth},
+			Presign:              true,
+			AtomicPutIfNotExists: true,
+		}
+	default:
+		return Capabilities{
+			Limits: PathLimits{MaxKeyLength: defaultMaxKeyLength, MaxDepth: defaultMaxDepth},
+		}
+	}
+}
+
+// effectiveLimits returns the limits configured in the ProviderConfig.
+// Unset hard limits default to the strictest supported provider, and can
+// never be relaxed beyond what the provider itself allows.
+func effectiveLimits(config ProviderConfig) PathLimits {
+	l := config.Limits
+	if l.MaxKeyLength <= 0 {
+		l.MaxKeyLength = defaultMaxKeyLength
+	}
+	if l.MaxDepth <= 0 {
+		l.MaxDepth = defaultMaxDepth
+	}
+	pl := ProviderCapabilities(config.Type).Limits
+	if pl.MaxKeyLength > 0 && pl.MaxKeyLength < l.MaxKeyLength {
+		l.MaxKeyLength = pl.MaxKeyLength
+	}
+	if pl.MaxDepth > 0 && pl.MaxDepth < l.MaxDepth {
+		l.MaxDepth = pl.MaxDepth
+	}
+	return l
+}
+
+// check checks that the absolute path p is within the hard limits. Errors
+// name the component at which the limit is exceeded, redacted by r.
+func (l PathLimits) check(p string, r NameRedaction) error {
+	key := cloudName(p)
+	components := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	if l.MaxDepth > 0 && len(components) > l.MaxDepth {
+		return errors.Errorf("path %s exceeds the maximum depth of %d at component %q", r.Redact(p), l.MaxDepth, r.Redact(components[l.MaxDepth]))
+	}
+	if l.MaxKeyLength > 0 && len(key) > l.MaxKeyLength {
+		return errors.Errorf("path %s exceeds the maximum key length of %d bytes at component %q", r.Redact(p), l.MaxKeyLength, r.Redact(componentAt(components, l.MaxKeyLength)))
+	}
+	return nil
+}
+
+// validate checks that the absolute path p is within the hard limits like
+// check, and warns if it is beyond the soft limits. It is run by writes.
+func (l PathLimits) validate(p string, r NameRedaction) error {
+	if err := l.check(p, r); err != nil {
+		return err
+	}
+	key := cloudName(p)
+	components := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	if l.SoftDepth > 0 && len(components) > l.SoftDepth {
+		log.Warnf("Path %s is %d levels deep, above the recommended depth of %d", r.Redact(p), len(components), l.SoftDepth)
+	}
+	if l.SoftKeyLength > 0 && len(key) > l.SoftKeyLength {
+		log.Warnf("Path %s is %d bytes long, above the recommended length of %d bytes", r.Redact(p), len(key), l.SoftKeyLength)
+	}
+	return nil
+}
+
+// validateSize checks that an object of the given size, or of unknown size
+// if it is negative, fits in the bucket
+func (b *bucket) validateSize(objName string, size int64) error {
+	if b.maxObjectSize > 0 && size > b.maxObjectSize {
+		return &ErrObjectTooLarge{Name: b.redaction.Redact(objName), Size: size, Max: b.maxObjectSize}
+	}
+	return nil
+}
+
+// componentAt returns the component that contains the byte at offset n of
+// the joined key
+func componentAt(components []string, n int) string {
+	var off int
+	for _, c := range components {
+		off += len(c) + 1
+		if off > n {
+			return c
+		}
+	}
+	return components[len(components)-1]
+}
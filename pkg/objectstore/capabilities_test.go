@@ -0,0 +1,117 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type CapabilitiesSuite struct{}
+
+var _ = Suite(&CapabilitiesSuite{})
+
+func (s *CapabilitiesSuite) TestKeyLengthBoundary(c *C) {
+	ctx := context.Background()
+	for _, pt := range []ProviderType{ProviderTypeS3, ProviderTypeGCS, ProviderTypeAzure} {
+		limit := ProviderCapabilities(pt).Limits.MaxKeyLength
+		c.Assert(limit, Equals, 1024)
+		b, _ := newMemBucket(string(pt))
+		b.limits = effectiveLimits(ProviderConfig{Type: pt})
+
+		d, err := b.CreateDirectory(ctx, "dir")
+		c.Assert(err, IsNil)
+		// "dir/" takes up 4 bytes of the key
+		err = d.PutBytes(ctx, strings.Repeat("a", limit-4), nil, nil)
+		c.Check(err, IsNil, Commentf("provider %s", pt))
+		err = d.PutBytes(ctx, strings.Repeat("b", limit-3), nil, nil)
+		c.Check(err, NotNil, Commentf("provider %s", pt))
+		c.Check(err, ErrorMatches, ".*maximum key length of 1024 bytes at component \"b+\".*")
+	}
+}
+
+func (s *CapabilitiesSuite) TestDepthLimit(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("depth")
+	b.limits = effectiveLimits(ProviderConfig{Limits: PathLimits{MaxDepth: 3}})
+
+	d, err := b.CreateDirectory(ctx, "l1/l2/l3")
+	c.Assert(err, IsNil)
+	_, err = d.CreateDirectory(ctx, "l4")
+	c.Assert(err, ErrorMatches, ".*maximum depth of 3 at component \"l4\".*")
+	err = d.PutBytes(ctx, "obj", nil, nil)
+	c.Assert(err, ErrorMatches, ".*maximum depth of 3 at component \"obj\".*")
+	err = b.PutBytes(ctx, "l1/obj", nil, nil)
+	c.Assert(err, IsNil)
+
+	// Every entry point rejects paths beyond the limits
+	_, err = d.GetDirectory(ctx, "l4")
+	c.Assert(err, ErrorMatches, ".*maximum depth of 3 at component \"l4\".*")
+	b.skipMarkers = true
+	_, err = d.CreateDirectory(ctx, "l4")
+	c.Assert(err, ErrorMatches, ".*maximum depth of 3 at component \"l4\".*")
+	_, _, err = d.GetBytes(ctx, "obj")
+	c.Assert(err, ErrorMatches, ".*maximum depth of 3 at component \"obj\".*")
+	_, err = d.Stat(ctx, "obj")
+	c.Assert(err, ErrorMatches, ".*maximum depth of 3 at component \"obj\".*")
+	c.Assert(d.Delete(ctx, "obj"), ErrorMatches, ".*maximum depth of 3 at component \"obj\".*")
+}
+
+func (s *CapabilitiesSuite) TestEffectiveLimits(c *C) {
+	for _, tc := range []struct {
+		config   ProviderConfig
+		expected PathLimits
+	}{
+		{
+			config:   ProviderConfig{},
+			expected: PathLimits{MaxKeyLength: 1024, MaxDepth: 254},
+		},
+		{
+			config:   ProviderConfig{Type: ProviderTypeS3, Limits: PathLimits{MaxDepth: 40, SoftKeyLength: 512}},
+			expected: PathLimits{MaxKeyLength: 1024, MaxDepth: 40, SoftKeyLength: 512},
+		},
+		{
+			// Cannot exceed the provider limits
+			config:   ProviderConfig{Type: ProviderTypeAzure, Limits: PathLimits{MaxKeyLength: 2048, MaxDepth: 1000}},
+			expected: PathLimits{MaxKeyLength: 1024, MaxDepth: 254},
+		},
+	} {
+		c.Check(effectiveLimits(tc.config), DeepEquals, tc.expected)
+		p, err := NewProvider(context.Background(), tc.config, nil)
+		c.Assert(err, IsNil)
+		c.Check(p.Capabilities().Limits, DeepEquals, tc.expected)
+	}
+}
+
+// unreadable fails the test if it is read
+type unreadable struct {
+	c *C
+}
+
+func (r unreadable) Read(p []byte) (int, error) {
+	r.c.Fatal("Data of a rejected upload was read")
+	return 0, io.EOF
+}
+
+func (s *CapabilitiesSuite) TestObjectSizeLimit(c *C) {
+	ctx := context.Background()
+	for _, pt := range []ProviderType{ProviderTypeS3, ProviderTypeGCS} {
+		c.Assert(ProviderCapabilities(pt).MaxObjectSize, Equals, int64(5*1024*1024*1024*1024))
+	}
+	c.Assert(ProviderCapabilities(ProviderTypeAzure).MaxObjectSize, Equals, int64(0))
+
+	b := newBucket(ProviderConfig{Type: ProviderTypeS3}, newMemContainer("s3"), nil, "mem://")
+	m := newMemMultipart(b.container.(*memContainer))
+	b.multipart = m
+	err := b.Put(ctx, "huge", unreadable{c}, maxObjectSize+1, nil)
+	c.Assert(IsObjectTooLarge(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object /huge of 5497558138881 bytes exceeds the maximum object size of 5497558138880 bytes")
+	c.Assert(m.next, Equals, 0)
+	c.Assert(b.validateSize("/max", maxObjectSize), IsNil)
+	c.Assert(b.validateSize("/unknown", -1), IsNil)
+
+	// Sizes are not limited for other providers
+	b = newBucket(ProviderConfig{Type: ProviderTypeAzure}, newMemContainer("azure"), nil, "mem://")
+	c.Assert(b.validateSize("/huge", maxObjectSize+1), IsNil)
+}
@@ -0,0 +1,141 @@
+package objectstore
+
+// SHA-256 checksums of the data of objects, recorded in their tags by Put
+// and verified by Get
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ChecksumTag is the tag that records the hex SHA-256 digest of the data of
+// an object, before compression
+const ChecksumTag = "kanister-sha256"
+
+// ErrChecksumMismatch is returned by verified reads of objects whose data
+// does not match the SHA-256 digest in their ChecksumTag, and by verified
+// transfers whose data does not match the MD5 digest in the ETag of the
+// object
+type ErrChecksumMismatch struct {
+	Name string
+	// Algorithm of the digests, SHA-256 or MD5
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *ErrChecksumMismatch) Error() string {
+	return fmt.Sprintf("%s checksum mismatch for object %s, expected %s, got %s", e.Algorithm, e.Name, e.Expected, e.Actual)
+}
+
+// IsChecksumMismatch returns true if the cause of err is an
+// ErrChecksumMismatch
+func IsChecksumMismatch(err error) bool {
+	_, ok := errors.Cause(err).(*ErrChecksumMismatch)
+	return ok
+}
+
+// WithChecksum records the SHA-256 digest of the data in the ChecksumTag of
+// the object, and sets sum to it once the object is written, if sum is not
+// nil. The digest is computed before the upload, so the data is read twice,
+// or spooled to a temporary file if r cannot seek.
+func WithChecksum(sum *string) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.checksum = true
+		s.checksumOut = sum
+	})
+}
+
+// WithChecksumVerification verifies the data read against the digest in the
+// ChecksumTag of the object once it is read to the end. Reads of objects
+// without the tag are not verified.
+func WithChecksumVerification() GetOption {
+	return getOptionFunc(func(s *getSettings) {
+		s.verifyChecksum = true
+	})
+}
+
+type verifyChecksumKey struct{}
+
+// WithVerifiedChecksums returns a context in which Get and GetBytes verify
+// data like the WithChecksumVerification option of GetOpts
+func WithVerifiedChecksums(ctx context.Context) context.Context {
+	return context.WithValue(ctx, verifyChecksumKey{}, true)
+}
+
+func verifiedChecksumsFromContext(ctx context.Context) bool {
+	v, _ := ctx.Value(verifyChecksumKey{}).(bool)
+	return v
+}
+
+// checksumData returns the SHA-256 digest of the data of r, and a reader
+// of the same data and its size. The reader is r rewound if r can seek, or
+// a spool file otherwise, which the returned function closes.
+func checksumData(r io.Reader, size int64) (io.Reader, int64, string, func(), error) {
+	h := sha256.New()
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if start, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			n, err := io.Copy(h, rs)
+			if err != nil {
+				return nil, 0, "", nil, errors.Wrap(err, "failed to compute checksum")
+			}
+			if _, err := rs.Seek(start, io.SeekStart); err != nil {
+				return nil, 0, "", nil, errors.Wrap(err, "failed to rewind data")
+			}
+			return rs, n, hex.EncodeToString(h.Sum(nil)), func() {}, nil
+		}
+	}
+	sr, n, err := spoolToFile(io.TeeReader(r, h), size, "")
+	if err != nil {
+		return nil, 0, "", nil, errors.Wrap(err, "failed to compute checksum")
+	}
+	return sr, n, hex.EncodeToString(h.Sum(nil)), func() { _ = sr.Close() }, nil
+}
+
+// withTag returns a copy of tags with the tag key set to value
+func withTag(tags map[string]string, key, value string) map[string]string {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[key] = value
+	return t
+}
+
+// checksumVerifyingReadCloser verifies the data read against a SHA-256
+// digest once it is read to the end
+type checksumVerifyingReadCloser struct {
+	io.ReadCloser
+	name     string
+	expected string
+	h        hash.Hash
+}
+
+// verifyChecksumTag returns a reader that verifies the data of r against
+// the ChecksumTag in tags, or r if the object has none
+func verifyChecksumTag(r io.ReadCloser, name string, tags map[string]string) io.ReadCloser {
+	expected := tags[ChecksumTag]
+	if expected == "" {
+		log.Debugf("Skipping verification of %s, it has no checksum", name)
+		return r
+	}
+	return &checksumVerifyingReadCloser{ReadCloser: r, name: name, expected: expected, h: sha256.New()}
+}
+
+func (r *checksumVerifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	_, _ = r.h.Write(p[:n])
+	if err == io.EOF {
+		if actual := hex.EncodeToString(r.h.Sum(nil)); actual != r.expected {
+			return n, &ErrChecksumMismatch{Name: r.name, Algorithm: "SHA-256", Expected: r.expected, Actual: actual}
+		}
+	}
+	return n, err
+}
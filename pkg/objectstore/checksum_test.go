@@ -0,0 +1,101 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type ChecksumSuite struct{}
+
+var _ = Suite(&ChecksumSuite{})
+
+func (s *ChecksumSuite) TestChecksum(c *C) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("manifest "), 1000)
+	digest := sha256.Sum256(data)
+	want := hex.EncodeToString(digest[:])
+	b, mc := newMemBucket("checksum")
+	for _, tc := range []struct {
+		name     string
+		seekable bool
+		opts     []PutOption
+	}{
+		{name: "seekable", seekable: true},
+		// Readers that cannot seek are spooled
+		{name: "stream", opts: []PutOption{WithPutOptions(PutOptions{MultipartThreshold: 10})}},
+		// The digest is of the data before compression
+		{name: "compressed", seekable: true, opts: []PutOption{WithCompression(CodecGzip)}},
+	} {
+		var sum string
+		r := io.Reader(bytes.NewReader(data))
+		if !tc.seekable {
+			r = ioutil.NopCloser(r)
+		}
+		opts := append(tc.opts, WithChecksum(&sum))
+		err := b.PutOpts(ctx, tc.name, r, -1, map[string]string{"type": "manifest"}, opts...)
+		c.Assert(err, IsNil)
+		c.Assert(sum, Equals, want)
+		c.Assert(mc.items[tc.name].metadata[ChecksumTag], Equals, want)
+
+		rc, tags, err := b.GetOpts(ctx, tc.name, WithChecksumVerification())
+		c.Assert(err, IsNil)
+		c.Assert(tags[ChecksumTag], Equals, want)
+		got, err := ioutil.ReadAll(rc)
+		c.Assert(err, IsNil)
+		c.Assert(rc.Close(), IsNil)
+		c.Assert(got, DeepEquals, data)
+		got, _, err = b.GetBytes(WithVerifiedChecksums(ctx), tc.name)
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, data)
+	}
+}
+
+func (s *ChecksumSuite) TestMismatch(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("mismatch")
+	var sum string
+	err := b.PutOpts(ctx, "manifest", bytes.NewReader([]byte(`{"version":1}`)), 13, nil, WithChecksum(&sum))
+	c.Assert(err, IsNil)
+	// The data was corrupted before the provider computed its ETag
+	corrupted := []byte(`{"version":2}`)
+	etag := md5.Sum(corrupted)
+	mc.items["manifest"].data = corrupted
+	mc.items["manifest"].etag = hex.EncodeToString(etag[:])
+
+	// Reads are only verified on request
+	data, _, err := b.GetBytes(ctx, "manifest")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"version":2}`)
+
+	_, _, err = b.GetBytes(WithVerifiedChecksums(ctx), "manifest")
+	c.Assert(IsChecksumMismatch(err), Equals, true)
+	c.Assert(err, ErrorMatches, "SHA-256 checksum mismatch for object /manifest, expected "+sum+", got [0-9a-f]{64}")
+	r, _, err := b.GetOpts(ctx, "manifest", WithSpool(SpoolOptions{}), WithChecksumVerification())
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(IsChecksumMismatch(err), Equals, true)
+	c.Assert(r.Close(), IsNil)
+
+	// Objects without checksums are read without verification
+	c.Assert(b.PutBytes(ctx, "unverified", []byte("data"), nil), IsNil)
+	data, _, err = b.GetBytes(WithVerifiedChecksums(ctx), "unverified")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+}
+
+func (s *ChecksumSuite) TestFailedPut(c *C) {
+	ctx := context.Background()
+	fc := &putFailingContainer{memContainer: newMemContainer("failed"), failures: map[string]int{"manifest": 1}}
+	b := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	sum := "unset"
+	err := b.PutOpts(ctx, "manifest", bytes.NewReader([]byte("data")), 4, nil, WithChecksum(&sum))
+	c.Assert(err, NotNil)
+	c.Assert(sum, Equals, "unset")
+}
@@ -0,0 +1,72 @@
+package objectstore
+
+// Time and randomness used by time dependent features. Tests replace them,
+// per bucket or per context, to avoid real sleeps and random behavior.
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type randSource interface {
+	Float64() float64
+	Int63n(n int64) int64
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// globalRand uses the shared source of math/rand, which is safe for
+// concurrent use
+type globalRand struct{}
+
+func (globalRand) Float64() float64     { return rand.Float64() }
+func (globalRand) Int63n(n int64) int64 { return rand.Int63n(n) }
+
+type clockKey struct{}
+type randKey struct{}
+
+func withClock(ctx context.Context, c clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, c)
+}
+
+func withRand(ctx context.Context, r randSource) context.Context {
+	return context.WithValue(ctx, randKey{}, r)
+}
+
+// clockFor returns the clock set in ctx, or else the clock of the bucket
+func clockFor(ctx context.Context, b *bucket) clock {
+	if c, ok := ctx.Value(clockKey{}).(clock); ok {
+		return c
+	}
+	if b != nil && b.clock != nil {
+		return b.clock
+	}
+	return realClock{}
+}
+
+// randFor returns the source of randomness set in ctx, or else the one of
+// the bucket
+func randFor(ctx context.Context, b *bucket) randSource {
+	if r, ok := ctx.Value(randKey{}).(randSource); ok {
+		return r
+	}
+	if b != nil && b.rand != nil {
+		return b.rand
+	}
+	return globalRand{}
+}
+
+// jitter returns d adjusted by a random amount of up to +/- fraction of d
+func jitter(r randSource, d time.Duration, fraction float64) time.Duration {
+	delta := fraction * float64(d)
+	return d + time.Duration((2*r.Float64()-1)*delta)
+}
@@ -0,0 +1,166 @@
+package objectstore
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ClockSuite struct{}
+
+var _ = Suite(&ClockSuite{})
+
+// fakeClock only moves when it is advanced
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+	calls   int
+}
+
+type fakeWaiter struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+var _ clock = (*fakeClock)(nil)
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, fakeWaiter{deadline: f.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward and fires the timers that expire
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	var pending []fakeWaiter
+	for _, w := range f.waiters {
+		if w.deadline.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+		w.ch <- f.now
+	}
+	f.waiters = pending
+}
+
+// AdvanceToNext moves the clock to the earliest pending timer and fires it
+func (f *fakeClock) AdvanceToNext() {
+	f.mu.Lock()
+	var next time.Duration
+	for i, w := range f.waiters {
+		if d := w.deadline.Sub(f.now); i == 0 || d < next {
+			next = d
+		}
+	}
+	f.mu.Unlock()
+	f.Advance(next)
+}
+
+// AfterCalls returns the number of timers that were requested
+func (f *fakeClock) AfterCalls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls
+}
+
+// advanceUntilDone advances the clock to the next timer every time a new
+// timer is requested, until done receives a value
+func advanceUntilDone(clk *fakeClock, seen int, done <-chan error) error {
+	for {
+		select {
+		case err := <-done:
+			return err
+		default:
+		}
+		if n := clk.AfterCalls(); n > seen {
+			seen = n
+			clk.AdvanceToNext()
+			continue
+		}
+		runtime.Gosched()
+	}
+}
+
+func (s *ClockSuite) TestFakeClock(c *C) {
+	clk := newFakeClock()
+	start := clk.Now()
+	ch := clk.After(time.Minute)
+	clk.Advance(59 * time.Second)
+	select {
+	case <-ch:
+		c.Fatal("Timer fired early")
+	default:
+	}
+	clk.Advance(time.Second)
+	c.Assert(<-ch, Equals, start.Add(time.Minute))
+	c.Assert(clk.Now(), Equals, start.Add(time.Minute))
+
+	ch1 := clk.After(time.Hour)
+	ch2 := clk.After(time.Second)
+	clk.AdvanceToNext()
+	c.Assert(<-ch2, Equals, start.Add(time.Minute+time.Second))
+	c.Assert(ch1, HasLen, 0)
+	c.Assert(clk.AfterCalls(), Equals, 3)
+}
+
+func (s *ClockSuite) TestClockFor(c *C) {
+	ctx := context.Background()
+	c.Assert(clockFor(ctx, nil), Equals, clock(realClock{}))
+	c.Assert(randFor(ctx, nil), Equals, randSource(globalRand{}))
+
+	b, _ := newMemBucket("clock")
+	c.Assert(clockFor(ctx, b), Equals, clock(realClock{}))
+
+	bc := newFakeClock()
+	br := rand.New(rand.NewSource(1))
+	b.clock = bc
+	b.rand = br
+	c.Assert(clockFor(ctx, b), Equals, clock(bc))
+	c.Assert(randFor(ctx, b), Equals, randSource(br))
+
+	// The context takes precedence over the bucket
+	cc := newFakeClock()
+	cr := rand.New(rand.NewSource(2))
+	ctx = withRand(withClock(ctx, cc), cr)
+	c.Assert(clockFor(ctx, b), Equals, clock(cc))
+	c.Assert(randFor(ctx, b), Equals, randSource(cr))
+}
+
+func (s *ClockSuite) TestJitter(c *C) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		d := jitter(r, time.Minute, 0.1)
+		c.Assert(d >= 54*time.Second && d <= 66*time.Second, Equals, true, Commentf("%s", d))
+	}
+	// The same seed gives the same sequence
+	r1 := rand.New(rand.NewSource(42))
+	r2 := rand.New(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		c.Assert(jitter(r1, time.Minute, 0.1), Equals, jitter(r2, time.Minute, 0.1))
+	}
+}
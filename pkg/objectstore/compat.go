@@ -0,0 +1,126 @@
+package objectstore
+
+// Directories of trees written by the CLIs of providers, which do not
+// create the directory markers that CreateDirectory creates:
+//
+//   - aws s3 sync, gsutil and az storage blob upload-batch only upload
+//     objects, so directories are implied by the names of their objects
+//   - EMR and Hadoop create an empty "<dir>_$folder$" object next to each
+//     directory
+//   - Azure Data Lake and some S3 tools create an empty "<dir>" object,
+//     without a trailing '/', next to each directory
+//
+// These markers are treated like "<dir>/": they are not listed as objects
+// and are deleted with their directory. An empty "<dir>" object is only a
+// marker if objects are named after it, so that empty files are still
+// listed.
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// emrFolderSuffix is appended to the name of a directory to name its EMR
+// marker
+const emrFolderSuffix = "_$folder$"
+
+// isMarkerName returns true if the item at the path name, relative to a
+// listed directory, is a directory marker by its name
+func isMarkerName(name string) bool {
+	return strings.HasSuffix(name, "/") || strings.HasSuffix(name, emrFolderSuffix)
+}
+
+// isEmpty returns true if the item has no data
+func isEmpty(item stow.Item) bool {
+	size, err := item.Size()
+	return err == nil && size == 0
+}
+
+// hasObjects returns true if an object has the prefix
+func (b *bucket) hasObjects(ctx context.Context, prefix string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	items, _, err := b.container.Items(prefix, stow.CursorStart, 1)
+	if err != nil {
+		return false, err
+	}
+	return len(items) > 0, nil
+}
+
+// withoutPlaceholders removes the empty objects that objects are named
+// after from the names found by a walk
+func withoutPlaceholders(names []string, empty map[string]bool) []string {
+	if len(empty) == 0 {
+		return names
+	}
+	dirs := make(map[string]bool)
+	for _, n := range names {
+		for p := path.Dir(n); p != "." && p != "/"; p = path.Dir(p) {
+			dirs[p] = true
+		}
+	}
+	kept := names[:0]
+	for _, n := range names {
+		if !empty[n] || !dirs[n] {
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+// siblingMarkers returns the keys of the EMR and placeholder markers of the
+// directory whose marker would be the key marker, which ends with '/'.
+// These markers do not have the prefix of the directory.
+func (b *bucket) siblingMarkers(ctx context.Context, marker string) ([]string, error) {
+	name := strings.TrimSuffix(marker, "/")
+	if name == "" {
+		return nil, nil
+	}
+	var keys []string
+	if _, err := b.container.Item(name + emrFolderSuffix); err == nil {
+		keys = append(keys, name+emrFolderSuffix)
+	}
+	if item, err := b.container.Item(name); err == nil && isEmpty(item) {
+		ok, err := b.hasObjects(ctx, marker)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, name)
+		}
+	}
+	return keys, nil
+}
+
+// isImplicitDirectory returns true if the directory whose marker would be
+// the key marker exists without that marker
+func (b *bucket) isImplicitDirectory(ctx context.Context, marker string) (bool, error) {
+	if ok, err := b.hasObjects(ctx, marker); ok || err != nil {
+		return ok, err
+	}
+	_, err := b.container.Item(strings.TrimSuffix(marker, "/") + emrFolderSuffix)
+	return err == nil, nil
+}
+
+// removeMarkers deletes the markers keys and returns the number of markers
+// deleted
+func removeMarkers(ctx context.Context, b *bucket, keys []string) (int, error) {
+	var removed int
+	for _, key := range keys {
+		err := retry(ctx, b, "delete of "+b.redaction.Redact(key), func() error {
+			return b.container.RemoveItem(key)
+		})
+		switch {
+		case err == nil:
+			removed++
+		case !IsObjectNotFound(err):
+			return removed, errors.Wrapf(err, "failed to delete directory marker %s", b.redaction.Redact(key))
+		}
+	}
+	return removed, nil
+}
@@ -0,0 +1,126 @@
+package objectstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type CompatSuite struct{}
+
+var _ = Suite(&CompatSuite{})
+
+// cliTree is the tree that the listings of testdata/cli were recorded
+// from, as a filesystem sees it
+var cliTree = struct {
+	files map[string]int64
+	dirs  []string
+}{
+	files: map[string]int64{
+		"README":                      20,
+		"backup/manifest.json":        42,
+		"backup/data.idx":             8,
+		"backup/data/part-0000":       100,
+		"backup/data/part-0001":       100,
+		"backup/data/empty":           0,
+		"backup/logs.txt":             10,
+		"backup/logs/2019/01/run.log": 64,
+	},
+	dirs: []string{"backup", "backup/data", "backup/logs", "backup/logs/2019", "backup/logs/2019/01"},
+}
+
+// replayListing puts the objects of a recorded listing in c
+func replayListing(c *C, mc *memContainer, file string) int {
+	f, err := os.Open(file)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	var n int
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if strings.HasPrefix(s.Text(), "#") {
+			continue
+		}
+		fields := strings.Fields(s.Text())
+		c.Assert(fields, HasLen, 2)
+		size, err := strconv.Atoi(fields[0])
+		c.Assert(err, IsNil)
+		_, err = mc.Put(fields[1], bytes.NewReader(bytes.Repeat([]byte("x"), size)), int64(size), nil)
+		c.Assert(err, IsNil)
+		n++
+	}
+	c.Assert(s.Err(), IsNil)
+	return n
+}
+
+// walkView returns the files with their sizes and the directories that d
+// shows
+func walkView(c *C, d Directory, p string, files map[string]int64, dirs *[]string) {
+	ctx := context.Background()
+	objs, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	for _, o := range objs {
+		info, err := d.Stat(ctx, o)
+		c.Assert(err, IsNil)
+		files[path.Join(p, o)] = info.Size
+	}
+	subs, err := d.ListDirectories(ctx)
+	c.Assert(err, IsNil)
+	for name, sub := range subs {
+		*dirs = append(*dirs, path.Join(p, name))
+		walkView(c, sub, path.Join(p, name), files, dirs)
+	}
+}
+
+func (s *CompatSuite) TestCLIListings(c *C) {
+	ctx := context.Background()
+	listings, err := filepath.Glob("testdata/cli/*.txt")
+	c.Assert(err, IsNil)
+	c.Assert(len(listings) > 0, Equals, true)
+	var expected []string
+	for name := range cliTree.files {
+		expected = append(expected, name)
+	}
+	sort.Strings(expected)
+
+	for _, l := range listings {
+		comment := Commentf(l)
+		b, mc := newMemBucket("cli")
+		n := replayListing(c, mc, l)
+
+		files := make(map[string]int64)
+		var dirs []string
+		walkView(c, b, "", files, &dirs)
+		sort.Strings(dirs)
+		c.Check(files, DeepEquals, cliTree.files, comment)
+		c.Check(dirs, DeepEquals, cliTree.dirs, comment)
+		objs, err := b.ListObjectsRecursive(ctx)
+		c.Assert(err, IsNil)
+		c.Check(objs, DeepEquals, expected, comment)
+
+		for _, dir := range cliTree.dirs {
+			_, err := b.GetDirectory(ctx, dir)
+			c.Check(err, IsNil, comment)
+		}
+		_, err = b.GetDirectory(ctx, "backup/data/empty")
+		c.Check(err, NotNil, comment)
+		_, err = b.GetDirectory(ctx, "missing")
+		c.Check(err, NotNil, comment)
+
+		// Only README is left, whichever markers the tree has
+		d, err := b.GetDirectory(ctx, "backup")
+		c.Assert(err, IsNil)
+		deleted, err := d.DeleteDirectoryCount(ctx)
+		c.Assert(err, IsNil)
+		c.Check(deleted, Equals, n-1, comment)
+		c.Check(mc.items, HasLen, 1, comment)
+		c.Check(mc.items["README"], NotNil, comment)
+	}
+}
@@ -0,0 +1,250 @@
+package objectstore
+
+// Compression of the data of objects. Objects are compressed by Put as a
+// single stream, whether they are uploaded in one request or in parts, and
+// decompressed by Get with the codec recorded in their tags.
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4"
+	"github.com/pkg/errors"
+)
+
+// ContentEncodingTag is the tag that records the name of the codec with
+// which the data of an object is compressed
+const ContentEncodingTag = "content-encoding"
+
+// Names of the codecs that are always registered
+const (
+	CodecGzip = "gzip"
+	CodecZstd = "zstd"
+	CodecLZ4  = "lz4"
+)
+
+// Codec compresses and decompresses streams. Codecs are registered by name
+// with RegisterCodec.
+type Codec interface {
+	// Name is recorded in the ContentEncodingTag of the objects compressed
+	// with the codec
+	Name() string
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// ErrUnknownCodec is returned for objects compressed, or to be compressed,
+// with a codec that is not registered
+type ErrUnknownCodec struct {
+	Name string
+}
+
+func (e *ErrUnknownCodec) Error() string {
+	return fmt.Sprintf("unknown compression codec %q, registered codecs are %v", e.Name, CodecNames())
+}
+
+// IsUnknownCodec returns true if the cause of err is an ErrUnknownCodec
+func IsUnknownCodec(err error) bool {
+	_, ok := errors.Cause(err).(*ErrUnknownCodec)
+	return ok
+}
+
+// ErrObjectCompressed is returned by operations that do not support
+// compressed objects, such as ranged reads
+type ErrObjectCompressed struct {
+	Name      string
+	Operation string
+}
+
+func (e *ErrObjectCompressed) Error() string {
+	return fmt.Sprintf("%s is not supported on compressed object %s", e.Operation, e.Name)
+}
+
+// IsObjectCompressed returns true if the cause of err is an
+// ErrObjectCompressed
+func IsObjectCompressed(err error) bool {
+	_, ok := errors.Cause(err).(*ErrObjectCompressed)
+	return ok
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		CodecGzip: gzipCodec{},
+		CodecZstd: zstdCodec{},
+		CodecLZ4:  lz4Codec{},
+	}
+)
+
+// RegisterCodec makes the codec available to Put and Get by its name,
+// replacing any codec with the same name. Codecs other than gzip, zstd and
+// lz4 are registered by the programs that use them.
+func RegisterCodec(c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// GetCodec returns the codec registered with the name
+func GetCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, &ErrUnknownCodec{Name: name}
+	}
+	return c, nil
+}
+
+// CodecNames returns the sorted names of the registered codecs
+func CodecNames() []string {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	names := make([]string, 0, len(codecs))
+	for name := range codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return CodecGzip }
+
+func (gzipCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+func (gzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return CodecZstd }
+
+func (zstdCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zstdReadCloser{zr}, nil
+}
+
+// zstdReadCloser releases the goroutines of the decoder when closed
+type zstdReadCloser struct {
+	*zstd.Decoder
+}
+
+func (r zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return nil
+}
+
+type lz4Codec struct{}
+
+func (lz4Codec) Name() string { return CodecLZ4 }
+
+func (lz4Codec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return lz4.NewWriter(w), nil
+}
+
+func (lz4Codec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return ioutil.NopCloser(lz4.NewReader(r)), nil
+}
+
+// compressingReader reads the data of r compressed by a codec. The data is
+// compressed by a goroutine, which Close waits for.
+type compressingReader struct {
+	*io.PipeReader
+	done chan struct{}
+}
+
+func newCompressingReader(c Codec, r io.Reader) *compressingReader {
+	pr, pw := io.Pipe()
+	cr := &compressingReader{PipeReader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(cr.done)
+		w, err := c.NewWriter(pw)
+		if err == nil {
+			if _, err = io.Copy(w, r); err == nil {
+				err = w.Close()
+			}
+		}
+		// Readers get io.EOF if err is nil
+		_ = pw.CloseWithError(err)
+	}()
+	return cr
+}
+
+func (r *compressingReader) Close() error {
+	err := r.PipeReader.Close()
+	<-r.done
+	return err
+}
+
+// decompressingReadCloser reads the data of an object decompressed by a
+// codec, and closes the object with the decompressor
+type decompressingReadCloser struct {
+	io.ReadCloser
+	object io.Closer
+}
+
+func (r *decompressingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	if cerr := r.object.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// decompress returns a reader of the data of the object read by r,
+// decompressed with the codec of its tags if it has one. r is closed if
+// the codec is unknown.
+func decompress(name string, r io.ReadCloser, tags map[string]string) (io.ReadCloser, error) {
+	encoding := tags[ContentEncodingTag]
+	if encoding == "" {
+		return r, nil
+	}
+	c, err := GetCodec(encoding)
+	if err == nil {
+		var dr io.ReadCloser
+		if dr, err = c.NewReader(r); err == nil {
+			return &decompressingReadCloser{ReadCloser: dr, object: r}, nil
+		}
+	}
+	_ = r.Close()
+	return nil, errors.Wrapf(err, "failed to decompress object %s", name)
+}
+
+// withContentEncoding returns a copy of tags that records the codec
+func withContentEncoding(tags map[string]string, codec string) map[string]string {
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[ContentEncodingTag] = codec
+	return t
+}
+
+// putCodec returns the codec with which Put compresses objects, or nil
+func (b *bucket) putCodec(s putSettings) (Codec, error) {
+	name := b.compression
+	if s.compression != nil {
+		name = *s.compression
+	}
+	if name == "" || s.stored {
+		return nil, nil
+	}
+	return GetCodec(name)
+}
@@ -0,0 +1,308 @@
+package objectstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+
+	. "gopkg.in/check.v1"
+)
+
+type CompressSuite struct{}
+
+var _ = Suite(&CompressSuite{})
+
+// reverseCodec "compresses" data by reversing its bytes
+type reverseCodec struct{}
+
+func (reverseCodec) Name() string { return "reverse" }
+
+func (reverseCodec) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return &reverseWriter{w: w}, nil
+}
+
+func (reverseCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(reverse(data))), nil
+}
+
+type reverseWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (w *reverseWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *reverseWriter) Close() error {
+	_, err := w.w.Write(reverse(w.buf.Bytes()))
+	return err
+}
+
+func reverse(data []byte) []byte {
+	r := make([]byte, len(data))
+	for i, b := range data {
+		r[len(data)-1-i] = b
+	}
+	return r
+}
+
+// gunzip decompresses the data stored in an object
+func gunzip(c *C, data []byte) []byte {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	c.Assert(err, IsNil)
+	d, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	return d
+}
+
+func (s *CompressSuite) TestCompression(c *C) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("kanister "), 1000)
+	single, sc := newMemBucket("single")
+	parts, m := newMemMultipartBucket("parts")
+	for _, tc := range []struct {
+		b *bucket
+		c *memContainer
+	}{{single, sc}, {parts, m.memContainer}} {
+		err := tc.b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), map[string]string{"type": "text"},
+			WithCompression(CodecGzip), WithPutOptions(PutOptions{PartSize: 100}))
+		c.Assert(err, IsNil)
+		// The stored object is a single compressed stream
+		stored := tc.c.items["obj"].data
+		c.Assert(len(stored) < len(data), Equals, true)
+		c.Assert(gunzip(c, stored), DeepEquals, data)
+
+		got, tags, err := tc.b.GetBytes(ctx, "obj")
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, data)
+		c.Assert(tags, DeepEquals, map[string]string{"type": "text", ContentEncodingTag: CodecGzip, OriginalSizeTag: fmt.Sprint(len(data))})
+		r, _, err := tc.b.GetOpts(ctx, "obj", WithSpool(SpoolOptions{}))
+		c.Assert(err, IsNil)
+		got, err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		c.Assert(got, DeepEquals, data)
+	}
+	// Compressed in parts
+	c.Assert(m.next, Equals, 1)
+}
+
+func (s *CompressSuite) TestBucketDefault(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("default")
+	b.compression = "reverse"
+	RegisterCodec(reverseCodec{})
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, "reverse")
+		codecsMu.Unlock()
+	}()
+	c.Assert(CodecNames(), DeepEquals, []string{CodecGzip, CodecLZ4, "reverse", CodecZstd})
+
+	c.Assert(b.PutBytes(ctx, "reversed", []byte("abc"), nil), IsNil)
+	c.Assert(string(mc.items["reversed"].data), Equals, "cba")
+	c.Assert(b.PutBytes(ctx, "gzipped", []byte("abc"), nil), IsNil)
+	err := b.PutOpts(ctx, "gzipped", bytes.NewReader([]byte("abc")), 3, nil, WithCompression(CodecGzip))
+	c.Assert(err, IsNil)
+	c.Assert(string(gunzip(c, mc.items["gzipped"].data)), Equals, "abc")
+	err = b.PutOpts(ctx, "plain", bytes.NewReader([]byte("abc")), 3, nil, WithCompression(""))
+	c.Assert(err, IsNil)
+	c.Assert(string(mc.items["plain"].data), Equals, "abc")
+
+	for _, name := range []string{"reversed", "gzipped", "plain"} {
+		data, _, err := b.GetBytes(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "abc")
+	}
+}
+
+func (s *CompressSuite) TestCodecs(c *C) {
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("kanister "), 1000)
+	b, mc := newMemBucket("codecs")
+	for _, name := range []string{CodecGzip, CodecZstd, CodecLZ4} {
+		err := b.PutOpts(ctx, name, bytes.NewReader(data), int64(len(data)), nil, WithCompression(name))
+		c.Assert(err, IsNil)
+		c.Assert(mc.items[name].data, Not(DeepEquals), data, Commentf("%s", name))
+		got, tags, err := b.GetBytes(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, data, Commentf("%s", name))
+		c.Assert(tags[ContentEncodingTag], Equals, name)
+	}
+}
+
+func (s *CompressSuite) TestRangeUnsupported(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("range")
+	b.compression = CodecGzip
+	c.Assert(b.PutBytes(ctx, "obj", []byte("0123456789"), nil), IsNil)
+	_, _, err := b.GetRange(ctx, "obj", 2, 3)
+	c.Assert(IsObjectCompressed(err), Equals, true)
+	c.Assert(err, ErrorMatches, "ranged read is not supported on compressed object /obj")
+}
+
+func (s *CompressSuite) TestUnknownCodec(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("unknown")
+	err := b.PutOpts(ctx, "obj", bytes.NewReader([]byte("abc")), 3, nil, WithCompression("brotli"))
+	c.Assert(IsUnknownCodec(err), Equals, true)
+	c.Assert(mc.items, HasLen, 0)
+
+	c.Assert(b.PutBytes(ctx, "obj", []byte("abc"), map[string]string{ContentEncodingTag: "brotli"}), IsNil)
+	_, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(IsUnknownCodec(err), Equals, true)
+	c.Assert(err, ErrorMatches, `failed to decompress object /obj: unknown compression codec "brotli", registered codecs are \[gzip lz4 zstd\]`)
+
+	// Data that is not compressed with the codec of the tag fails to read
+	c.Assert(b.PutBytes(ctx, "obj", []byte("abc"), map[string]string{ContentEncodingTag: CodecGzip}), IsNil)
+	_, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(err, ErrorMatches, "failed to decompress object /obj: unexpected EOF")
+}
+
+func (s *CompressSuite) TestOriginalSize(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("sizes")
+	data := bytes.Repeat([]byte("text "), 1000)
+	gz := WithCompression(CodecGzip)
+	c.Assert(b.PutOpts(ctx, "plain", bytes.NewReader(data), int64(len(data)), nil), IsNil)
+	c.Assert(b.PutOpts(ctx, "compressed", bytes.NewReader(data), int64(len(data)), nil, gz), IsNil)
+	// The size of streams is only known once they are uploaded
+	c.Assert(b.PutOpts(ctx, "stream", ioutil.NopCloser(bytes.NewReader(data)), -1, nil, gz), IsNil)
+	b.keyring = newTestKeyring(c, "a", "a")
+	c.Assert(b.PutOpts(ctx, "encrypted", bytes.NewReader(data), int64(len(data)), nil), IsNil)
+
+	for name, original := range map[string]int64{
+		"plain":      int64(len(data)),
+		"compressed": int64(len(data)),
+		"stream":     -1,
+		"encrypted":  int64(len(data)),
+	} {
+		info, err := b.Stat(ctx, name)
+		c.Assert(err, IsNil)
+		c.Check(info.Size, Equals, int64(len(m.items[name].data)), Commentf("%s", name))
+		c.Check(info.OriginalSize, Equals, original, Commentf("%s", name))
+	}
+	objects, err := b.ListObjectsInfo(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 4)
+	c.Assert(objects[0].OriginalSize, Equals, int64(-1))
+}
+
+func (s *CompressSuite) TestAbortedPut(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("aborted")
+	// Random data does not compress, and takes more than 100 parts
+	r := io.LimitReader(rand.New(rand.NewSource(1)), 2000)
+	lc := newLeakChecker()
+	err := b.PutOpts(ctx, "obj", r, 2000, nil, WithCompression(CodecGzip), WithPutOptions(PutOptions{PartSize: 10}))
+	c.Assert(err, ErrorMatches, "obj has more than the maximum of 100 parts of 10 bytes")
+	c.Assert(m.aborted, DeepEquals, []string{"upload-1"})
+	// The compression stops with the upload
+	lc.check(c)
+}
+
+// CompressBenchmarkSuite compares the codecs on representative payloads.
+// Run it with go test ./pkg/objectstore -check.b -check.f CompressBenchmark
+type CompressBenchmarkSuite struct {
+	payloads map[string][]byte
+}
+
+var _ = Suite(&CompressBenchmarkSuite{})
+
+// SetUpSuite makes payloads representative of the data of backups: logs
+// and manifests compress well, and encrypted or compressed data does not
+func (s *CompressBenchmarkSuite) SetUpSuite(c *C) {
+	var text bytes.Buffer
+	for i := 0; text.Len() < 4*1024*1024; i++ {
+		fmt.Fprintf(&text, `{"time":"2018-09-01T00:00:%02dZ","level":"info","msg":"Uploaded part %d of backup","bytes":%d}`+"\n", i%60, i, i*4096)
+	}
+	random := make([]byte, 4*1024*1024)
+	_, _ = rand.New(rand.NewSource(1)).Read(random)
+	s.payloads = map[string][]byte{"text": text.Bytes(), "random": random}
+}
+
+func (s *CompressBenchmarkSuite) benchmark(c *C, name, payload string, decompress bool) {
+	codec, err := GetCodec(name)
+	c.Assert(err, IsNil)
+	data := s.payloads[payload]
+	var compressed bytes.Buffer
+	w, err := codec.NewWriter(&compressed)
+	c.Assert(err, IsNil)
+	_, err = w.Write(data)
+	c.Assert(err, IsNil)
+	c.Assert(w.Close(), IsNil)
+	c.Logf("%s compresses %d bytes of %s to %d bytes", name, len(data), payload, compressed.Len())
+
+	c.SetBytes(int64(len(data)))
+	c.ResetTimer()
+	for i := 0; i < c.N; i++ {
+		if decompress {
+			r, err := codec.NewReader(bytes.NewReader(compressed.Bytes()))
+			c.Assert(err, IsNil)
+			_, err = io.Copy(ioutil.Discard, r)
+			c.Assert(err, IsNil)
+			c.Assert(r.Close(), IsNil)
+			continue
+		}
+		w, err := codec.NewWriter(ioutil.Discard)
+		c.Assert(err, IsNil)
+		_, err = w.Write(data)
+		c.Assert(err, IsNil)
+		c.Assert(w.Close(), IsNil)
+	}
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkGzipText(c *C) {
+	s.benchmark(c, CodecGzip, "text", false)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkGzipRandom(c *C) {
+	s.benchmark(c, CodecGzip, "random", false)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkGunzipText(c *C) {
+	s.benchmark(c, CodecGzip, "text", true)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkGunzipRandom(c *C) {
+	s.benchmark(c, CodecGzip, "random", true)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkZstdText(c *C) {
+	s.benchmark(c, CodecZstd, "text", false)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkZstdRandom(c *C) {
+	s.benchmark(c, CodecZstd, "random", false)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkUnzstdText(c *C) {
+	s.benchmark(c, CodecZstd, "text", true)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkUnzstdRandom(c *C) {
+	s.benchmark(c, CodecZstd, "random", true)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkLZ4Text(c *C) {
+	s.benchmark(c, CodecLZ4, "text", false)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkLZ4Random(c *C) {
+	s.benchmark(c, CodecLZ4, "random", false)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkUnLZ4Text(c *C) {
+	s.benchmark(c, CodecLZ4, "text", true)
+}
+
+func (s *CompressBenchmarkSuite) BenchmarkUnLZ4Random(c *C) {
+	s.benchmark(c, CodecLZ4, "random", true)
+}
@@ -3,6 +3,18 @@ package objectstore
 const (
 	awsS3HostFmt  = "https://s3-%s.amazonaws.com"
 	googleGCSHost = "https://storage.googleapis.com"
+	// listPageSize is the default number of items requested per page when
+	// listing. S3, GCS and most S3 compatible stores return at most 1000
+	// items per page.
+	listPageSize = 1000
+	// deleteConcurrency is the default number of objects deleted
+	// concurrently
+	deleteConcurrency = 16
+	// copyConcurrency is the default number of objects copied concurrently
+	copyConcurrency = 16
+	// getBytesLimit is the default size in bytes of the largest object read
+	// by GetBytes
+	getBytesLimit = 1024 * 1024 * 1024
 )
 
 // ProviderType enum for different providers
@@ -0,0 +1,289 @@
+package objectstore
+
+// Copies and moves of objects, made by the provider when it supports it
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// ErrMoveIncomplete is returned by moves that copied the object but could
+// not delete the source. The object exists at both paths until the source is
+// deleted.
+type ErrMoveIncomplete struct {
+	Src string
+	Dst string
+	Err error
+}
+
+func (e *ErrMoveIncomplete) Error() string {
+	return fmt.Sprintf("object %s was copied to %s but could not be deleted, it now exists at both paths: %v", e.Src, e.Dst, e.Err)
+}
+
+// IsMoveIncomplete returns true if the cause of err is an ErrMoveIncomplete
+func IsMoveIncomplete(err error) bool {
+	_, ok := errors.Cause(err).(*ErrMoveIncomplete)
+	return ok
+}
+
+// objectCopier copies objects within a bucket without transferring their
+// data, which stow does not expose. Keys are names in the container.
+type objectCopier interface {
+	CopyObject(ctx context.Context, srcKey, dstKey string, sse ServerSideEncryption) error
+}
+
+// Copy copies the object <bucket>/<d.path>/srcName, with its tags, to
+// dstName in dst. Objects are copied by the provider if dst is in the same
+// bucket and the provider supports it, and are read and uploaded again
+// otherwise. Copies are encrypted with the ServerSideEncryption of the
+// bucket of dst. The data of objects that are compressed or encrypted on the
+// client is copied as it is.
+func (d *directory) Copy(ctx context.Context, srcName string, dst Directory, dstName string) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+	dd, err := asDirectory(dst)
+	if err != nil {
+		return err
+	}
+	if dd.path == "" {
+		return errors.New("invalid entry")
+	}
+	srcObj, err := d.objectName(srcName)
+	if err != nil {
+		return err
+	}
+	dstObj, err := dd.objectName(dstName)
+	if err != nil {
+		return err
+	}
+	if err := dd.bucket.limits.validate(dstObj, dd.bucket.redaction); err != nil {
+		return err
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if c := d.bucket.copier; c != nil && dd.bucket == d.bucket {
+		item, err := d.item(ctx, srcObj)
+		if err != nil {
+			return err
+		}
+		size, err := item.Size()
+		if err != nil {
+			return err
+		}
+		sse, err := d.bucket.encryption(nil, true)
+		if err != nil {
+			return err
+		}
+		// Larger objects must be copied in parts
+		if size <= maxSinglePutSize {
+			if err := checkQuota(ctx, d.bucket, size); err != nil {
+				return err
+			}
+			err := retry(ctx, d.bucket, "copy of "+d.bucket.redaction.Redact(srcObj), func() error {
+				return c.CopyObject(ctx, cloudName(srcObj), cloudName(dstObj), sse)
+			})
+			d.bucket.listingCache.invalidate(dstObj)
+			if err != nil {
+				return err
+			}
+			addUsage(ctx, d.bucket, size)
+			return nil
+		}
+	}
+
+	item, r, tags, err := d.open(ctx, srcName)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	size, err := item.Size()
+	if err != nil {
+		size = -1
+	}
+	// Copies keep the data of the source, like copies by the provider
+	return dd.PutOpts(ctx, dstObj, r, size, tags, asStored())
+}
+
+// Move moves the object <bucket>/<d.path>/srcName, with its tags, to dstName
+// in dst by copying it and deleting the source. The source is only deleted
+// once the copy is found with the size of the source, and its ETag if both
+// are MD5 digests, so that a failed move never loses the object. Moving an
+// object to its own path leaves it unchanged. If the source cannot be
+// deleted, Move fails with an ErrMoveIncomplete and the delete can be
+// retried.
+func (d *directory) Move(ctx context.Context, srcName string, dst Directory, dstName string) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+	dd, err := asDirectory(dst)
+	if err != nil {
+		return err
+	}
+	srcObj, err := d.objectName(srcName)
+	if err != nil {
+		return err
+	}
+	dstObj, err := dd.objectName(dstName)
+	if err != nil {
+		return err
+	}
+	if dd.bucket == d.bucket && cloudName(srcObj) == cloudName(dstObj) {
+		// Deleting the copy would delete the object
+		_, err := d.item(ctx, srcObj)
+		return err
+	}
+	src, err := d.item(ctx, srcObj)
+	if err != nil {
+		return err
+	}
+	if err := d.Copy(ctx, srcName, dst, dstName); err != nil {
+		return err
+	}
+	if err := dd.verifyCopy(ctx, src, srcObj, dstObj); err != nil {
+		return err
+	}
+	if err := d.Delete(ctx, srcName); err != nil {
+		return &ErrMoveIncomplete{
+			Src: d.bucket.redaction.Redact(srcObj),
+			Dst: dd.bucket.redaction.Redact(dstObj),
+			Err: err,
+		}
+	}
+	return nil
+}
+
+// verifyCopy checks that the object at the absolute path dstObj of d is a
+// copy of the item src at srcObj
+func (d *directory) verifyCopy(ctx context.Context, src stow.Item, srcObj, dstObj string) error {
+	r := d.bucket.redaction
+	dst, err := d.item(ctx, dstObj)
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify the copy of %s at %s", r.Redact(srcObj), r.Redact(dstObj))
+	}
+	srcInfo, err := objectInfo(srcObj, src)
+	if err != nil {
+		return err
+	}
+	dstInfo, err := objectInfo(dstObj, dst)
+	if err != nil {
+		return err
+	}
+	if srcInfo.Size != dstInfo.Size {
+		return errors.Errorf("copy of %s at %s has %d bytes, expected %d", r.Redact(srcObj), r.Redact(dstObj), dstInfo.Size, srcInfo.Size)
+	}
+	// Copies of objects uploaded in parts, or to other providers, have
+	// other kinds of ETags
+	if isMD5ETag(srcInfo.ETag) && isMD5ETag(dstInfo.ETag) && srcInfo.ETag != dstInfo.ETag {
+		return errors.Errorf("copy of %s at %s has ETag %s, expected %s", r.Redact(srcObj), r.Redact(dstObj), dstInfo.ETag, srcInfo.ETag)
+	}
+	return nil
+}
+
+// CopyDirectory copies the objects and directory markers of the tree rooted
+// at d, with their tags, to the same paths under dst. The objects are copied
+// with Copy by CopyConcurrency workers. Objects that could not be copied are
+// reported by a BatchError. Shutdown stops the copy between objects with an
+// ErrShuttingDown, and copying the directory again completes it.
+func (d *directory) CopyDirectory(ctx context.Context, dst Directory) error {
+	return d.transferDirectory(ctx, dst, "copy", d.bucket.copyWorkers, func(ctx context.Context, _ stow.Item, srcName string, dst Directory, dstName string) error {
+		return d.Copy(ctx, srcName, dst, dstName)
+	})
+}
+
+// MoveDirectory moves the objects and directory markers of the tree rooted
+// at d, with their tags, to the same paths under dst. The objects are moved
+// with Move by CopyConcurrency workers, so that each source is only deleted
+// once its copy is verified. Objects that could not be moved are reported by
+// a BatchError, and remain in d or, if their move is incomplete, at both
+// paths. Moving the directory again completes the move.
+func (d *directory) MoveDirectory(ctx context.Context, dst Directory) error {
+	return d.transferDirectory(ctx, dst, "move", d.bucket.copyWorkers, func(ctx context.Context, _ stow.Item, srcName string, dst Directory, dstName string) error {
+		return d.Move(ctx, srcName, dst, dstName)
+	})
+}
+
+// transferFunc transfers the object at the absolute path srcName, listed as
+// item, to the absolute path dstName of dst
+type transferFunc func(ctx context.Context, item stow.Item, srcName string, dst Directory, dstName string) error
+
+// transferDirectory calls f from workers goroutines with the objects and
+// directory markers of the tree rooted at d, to transfer them to the same
+// paths under dst. op names the transfer in errors.
+func (d *directory) transferDirectory(ctx context.Context, dst Directory, op string, workers int, f transferFunc) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+	dd, err := asDirectory(dst)
+	if err != nil {
+		return err
+	}
+	if dd.path == "" {
+		return errors.New("invalid entry")
+	}
+	if dd.bucket == d.bucket && strings.HasPrefix(dd.path, d.path) {
+		// The walk would find the copies
+		return errors.Errorf("cannot %s directory %s into itself", op, d.bucket.redaction.Redact(d.path))
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	prefix := cloudName(d.path)
+	var mu sync.Mutex
+	errs := newBatchError()
+	work := make(chan stow.Item)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range work {
+				// Markers of directories are transferred like objects.
+				// The marker of d itself has an empty name.
+				name := strings.TrimPrefix(item.Name(), prefix)
+				err := f(ctx, item, d.path+name, dst, dd.path+name)
+				if err != nil {
+					mu.Lock()
+					errs.add(d.bucket.redaction.Redact(d.path+name), op, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	err = stow.Walk(d.bucket.container, prefix, d.bucket.pageSize,
+		func(item stow.Item, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := d.bucket.stopping(); err != nil {
+				return err
+			}
+			select {
+			case work <- item:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	close(work)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return errs.errOrNil()
+}
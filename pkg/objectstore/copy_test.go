@@ -0,0 +1,326 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type CopySuite struct{}
+
+var _ = Suite(&CopySuite{})
+
+// memCopier copies the items of a memContainer
+type memCopier struct {
+	c      *memContainer
+	mu     sync.Mutex
+	copies int
+}
+
+func (m *memCopier) CopyObject(ctx context.Context, srcKey, dstKey string, sse ServerSideEncryption) error {
+	item, err := m.c.Item(srcKey)
+	if err != nil {
+		return err
+	}
+	i := item.(*memItem)
+	_, err = m.c.Put(dstKey, bytes.NewReader(i.data), int64(len(i.data)), i.metadata)
+	m.mu.Lock()
+	m.copies++
+	m.mu.Unlock()
+	return err
+}
+
+func (s *CopySuite) TestCopy(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("copy")
+	src, err := b.CreateDirectory(ctx, "src")
+	c.Assert(err, IsNil)
+	dst, err := b.CreateDirectory(ctx, "dst")
+	c.Assert(err, IsNil)
+	tags := map[string]string{"kanister.io/owner": "test", "type": "text"}
+	c.Assert(src.PutBytes(ctx, "a.txt", []byte("some text"), tags), IsNil)
+
+	check := func(d Directory, name string) {
+		data, gotTags, err := d.GetBytes(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "some text")
+		c.Assert(gotTags, DeepEquals, map[string]string{"kanister.io/owner": "test", "type": "text"})
+	}
+
+	// Streamed, since the provider cannot copy
+	c.Assert(src.Copy(ctx, "a.txt", dst, "b.txt"), IsNil)
+	check(dst, "b.txt")
+
+	m := &memCopier{c: b.container.(*memContainer)}
+	b.copier = m
+	c.Assert(src.Copy(ctx, "a.txt", dst, "c.txt"), IsNil)
+	check(dst, "c.txt")
+	c.Assert(b.Copy(ctx, "src/a.txt", b, "/dst/d.txt"), IsNil)
+	check(dst, "d.txt")
+	c.Assert(m.copies, Equals, 2)
+
+	// Copies overwrite existing objects, like puts
+	c.Assert(dst.PutBytes(ctx, "old.txt", []byte("old"), map[string]string{"old": "tag"}), IsNil)
+	c.Assert(src.Copy(ctx, "a.txt", dst, "old.txt"), IsNil)
+	check(dst, "old.txt")
+	check(src, "a.txt")
+	b.copier = nil
+	c.Assert(dst.PutBytes(ctx, "old.txt", []byte("old"), map[string]string{"old": "tag"}), IsNil)
+	c.Assert(src.Copy(ctx, "a.txt", dst, "old.txt"), IsNil)
+	check(dst, "old.txt")
+	check(src, "a.txt")
+	b.copier = m
+
+	// Streamed across buckets
+	other, _ := newMemBucket("other")
+	c.Assert(src.Copy(ctx, "a.txt", other, "e.txt"), IsNil)
+	check(other, "e.txt")
+	c.Assert(m.copies, Equals, 3)
+
+	err = src.Copy(ctx, "missing", dst, "f.txt")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	b.copier = nil
+	err = src.Copy(ctx, "missing", dst, "f.txt")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+}
+
+func (s *CopySuite) TestMove(c *C) {
+	ctx := context.Background()
+	fc := &failingContainer{memContainer: newMemContainer("move"), failures: make(map[string]int)}
+	b := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	b.copier = &memCopier{c: fc.memContainer}
+	tmp, err := b.CreateDirectory(ctx, "tmp")
+	c.Assert(err, IsNil)
+	final, err := b.CreateDirectory(ctx, "final")
+	c.Assert(err, IsNil)
+	c.Assert(tmp.PutBytes(ctx, "x", []byte("some text"), map[string]string{"type": "text"}), IsNil)
+
+	c.Assert(tmp.Move(ctx, "x", final, "x"), IsNil)
+	data, tags, err := final.GetBytes(ctx, "x")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "some text")
+	c.Assert(tags, DeepEquals, map[string]string{"type": "text"})
+	exists, err := tmp.Exists(ctx, "x")
+	c.Assert(err, IsNil)
+	c.Assert(exists, Equals, false)
+
+	// Same path
+	c.Assert(final.Move(ctx, "x", final, "x"), IsNil)
+	c.Assert(b.Move(ctx, "/final/x", final, "x"), IsNil)
+	data, _, err = final.GetBytes(ctx, "x")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "some text")
+	err = final.Move(ctx, "missing", final, "missing")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	err = tmp.Move(ctx, "missing", final, "y")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+
+	// The source cannot be deleted
+	fc.failures["final/x"] = 1
+	err = final.Move(ctx, "x", tmp, "y")
+	c.Assert(IsMoveIncomplete(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object /final/x was copied to /tmp/y but could not be deleted, it now exists at both paths: 503 Service Unavailable")
+	for _, name := range []string{"/final/x", "/tmp/y"} {
+		exists, err := b.Exists(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(exists, Equals, true, Commentf(name))
+	}
+	c.Assert(final.Delete(ctx, "x"), IsNil)
+
+	// Sources are kept if their copies do not match
+	for _, copier := range []objectCopier{
+		&corruptingCopier{memCopier{c: fc.memContainer}, "truncated"},
+		&corruptingCopier{memCopier{c: fc.memContainer}, "some TEXT"},
+	} {
+		b.copier = copier
+		err = tmp.Move(ctx, "y", final, "z")
+		c.Assert(err, ErrorMatches, "copy of /tmp/y at /final/z has .*, expected .*")
+		data, _, err = tmp.GetBytes(ctx, "y")
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "some text")
+	}
+}
+
+// corruptingCopier replaces the data of the copies
+type corruptingCopier struct {
+	memCopier
+	data string
+}
+
+func (m *corruptingCopier) CopyObject(ctx context.Context, srcKey, dstKey string, sse ServerSideEncryption) error {
+	if err := m.memCopier.CopyObject(ctx, srcKey, dstKey, sse); err != nil {
+		return err
+	}
+	_, err := m.c.Put(dstKey, bytes.NewReader([]byte(m.data)), int64(len(m.data)), nil)
+	return err
+}
+
+// putFailingContainer fails the uploads of objects a number of times
+type putFailingContainer struct {
+	*memContainer
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func (c *putFailingContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	c.mu.Lock()
+	if c.failures[name] > 0 {
+		c.failures[name]--
+		c.mu.Unlock()
+		return nil, errors.New("503 Service Unavailable")
+	}
+	c.mu.Unlock()
+	return c.memContainer.Put(name, r, size, metadata)
+}
+
+func (s *CopySuite) TestCopyDirectory(c *C) {
+	ctx := context.Background()
+	b := newBucket(ProviderConfig{CopyConcurrency: 3}, newMemContainer("copydir"), nil, "mem://")
+	c.Assert(b.copyWorkers, Equals, 3)
+	m := &memCopier{c: b.container.(*memContainer)}
+	b.copier = m
+	src, err := b.CreateDirectory(ctx, "backups/2024")
+	c.Assert(err, IsNil)
+	c.Assert(src.SetDirectoryTags(ctx, map[string]string{"status": "complete"}, false), IsNil)
+	for i := 0; i < 10; i++ {
+		c.Assert(src.PutBytes(ctx, fmt.Sprintf("day%d/data", i), []byte(fmt.Sprint(i)), map[string]string{"day": fmt.Sprint(i)}), IsNil)
+	}
+	_, err = src.CreateDirectory(ctx, "empty")
+	c.Assert(err, IsNil)
+	c.Assert(src.PutBytes(ctx, "manifest", []byte("manifest"), nil), IsNil)
+
+	check := func(dst Directory) {
+		tags, err := dst.GetDirectoryTags(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(tags, DeepEquals, map[string]string{"status": "complete"})
+		dirs, err := dst.ListDirectories(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(dirs, HasLen, 11)
+		_, err = dst.GetDirectory(ctx, "empty")
+		c.Assert(err, IsNil)
+		for i := 0; i < 10; i++ {
+			data, tags, err := dst.GetBytes(ctx, fmt.Sprintf("day%d/data", i))
+			c.Assert(err, IsNil)
+			c.Assert(string(data), Equals, fmt.Sprint(i))
+			c.Assert(tags, DeepEquals, map[string]string{"day": fmt.Sprint(i)})
+		}
+		srcObjs, err := src.ListObjectsRecursive(ctx)
+		c.Assert(err, IsNil)
+		dstObjs, err := dst.ListObjectsRecursive(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(dstObjs, DeepEquals, srcObjs)
+	}
+
+	// Copied by the provider
+	archive, err := b.CreateDirectory(ctx, "archive/2024")
+	c.Assert(err, IsNil)
+	c.Assert(src.CopyDirectory(ctx, archive), IsNil)
+	check(archive)
+	// The objects, and the markers of empty/ and of src
+	c.Assert(m.copies, Equals, 11+2)
+
+	// Streamed across buckets
+	other, _ := newMemBucket("other")
+	dst, err := other.CreateDirectory(ctx, "2024")
+	c.Assert(err, IsNil)
+	c.Assert(src.CopyDirectory(ctx, dst), IsNil)
+	check(dst)
+
+	err = src.CopyDirectory(ctx, src)
+	c.Assert(err, ErrorMatches, "cannot copy directory /backups/2024/ into itself")
+	sub, err := src.GetDirectory(ctx, "day1")
+	c.Assert(err, IsNil)
+	c.Assert(src.CopyDirectory(ctx, sub), NotNil)
+
+	// Failures are reported per object
+	fc := &putFailingContainer{memContainer: newMemContainer("failing"), failures: map[string]int{"dst/day3/data": 1, "dst/manifest": 1}}
+	fb := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	dst, err = fb.CreateDirectory(ctx, "dst")
+	c.Assert(err, IsNil)
+	err = src.CopyDirectory(ctx, dst)
+	be, ok := AsBatchError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(be.Len(), Equals, 2)
+	c.Assert(be.Items("")[0].Name, Equals, "/backups/2024/day3/data")
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	c.Assert(src.CopyDirectory(cctx, archive), Equals, context.Canceled)
+}
+
+func (s *CopySuite) TestMoveDirectory(c *C) {
+	ctx := context.Background()
+	b := newBucket(ProviderConfig{CopyConcurrency: 8}, newMemContainer("movedir"), nil, "mem://")
+	b.copier = &memCopier{c: b.container.(*memContainer)}
+	tmp, err := b.CreateDirectory(ctx, "tmp/upload-1")
+	c.Assert(err, IsNil)
+	for i := 0; i < 1000; i++ {
+		c.Assert(tmp.PutBytes(ctx, fmt.Sprintf("part%d/data", i%10), nil, nil), IsNil)
+		c.Assert(tmp.PutBytes(ctx, fmt.Sprintf("obj%d", i), []byte(fmt.Sprint(i)), nil), IsNil)
+	}
+	names, err := tmp.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+
+	// Promote the upload to its final name
+	final, err := b.CreateDirectory(ctx, "backups/final")
+	c.Assert(err, IsNil)
+	c.Assert(tmp.MoveDirectory(ctx, final), IsNil)
+	moved, err := final.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(moved, DeepEquals, names)
+	data, _, err := final.GetBytes(ctx, "obj999")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "999")
+	empty, err := tmp.IsEmpty(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(empty, Equals, true)
+	_, err = b.GetDirectory(ctx, "tmp/upload-1")
+	c.Assert(err, NotNil)
+
+	err = final.MoveDirectory(ctx, final)
+	c.Assert(err, ErrorMatches, "cannot move directory /backups/final/ into itself")
+
+	// Objects that could not be copied stay in the source, and moving the
+	// directory again completes the move
+	fc := &putFailingContainer{memContainer: newMemContainer("failing"), failures: map[string]int{"dst/obj3": 1}}
+	fb := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	dst, err := fb.CreateDirectory(ctx, "dst")
+	c.Assert(err, IsNil)
+	err = final.MoveDirectory(ctx, dst)
+	be, ok := AsBatchError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(be.Len(), Equals, 1)
+	c.Assert(be.Items("")[0].Name, Equals, "/backups/final/obj3")
+	left, err := final.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(left, DeepEquals, []string{"obj3"})
+	c.Assert(final.MoveDirectory(ctx, dst), IsNil)
+	moved, err = dst.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(moved, DeepEquals, names)
+}
+
+func (s *CopySuite) TestS3Copy(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	copier := newS3Copier(b.versioner.(*s3Versioner).client, "versioned", NameRedaction{})
+	_, err := b.PutVersioned(ctx, "dir a/a.txt", bytes.NewReader([]byte("some text")), 9, map[string]string{"type": "text"})
+	c.Assert(err, IsNil)
+
+	c.Assert(copier.CopyObject(ctx, "dir a/a.txt", "dir b/a.txt", ServerSideEncryption{}), IsNil)
+	vs := f.objects["dir b/a.txt"]
+	c.Assert(vs, HasLen, 1)
+	c.Assert(string(vs[0].data), Equals, "some text")
+	c.Assert(vs[0].metadata.Get("x-amz-meta-type"), Equals, "text")
+
+	err = copier.CopyObject(ctx, "missing", "dir b/b.txt", ServerSideEncryption{})
+	c.Assert(err, ErrorMatches, "(?s)failed to copy object missing to dir b/b.txt: NoSuchKey.*")
+}
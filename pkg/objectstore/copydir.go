@@ -0,0 +1,111 @@
+package objectstore
+
+// Copies of directory trees across buckets and providers, e.g. to migrate
+// backups
+
+import (
+	"context"
+	"sync"
+
+	"github.com/graymeta/stow"
+)
+
+// CopyProgress is the progress of a CopyDirectory. Directory markers are
+// not counted.
+type CopyProgress struct {
+	// ObjectsDone counts the objects copied or skipped
+	ObjectsDone  int64
+	ObjectsTotal int64
+	// BytesDone counts the bytes of the objects copied or skipped
+	BytesDone  int64
+	BytesTotal int64
+	Skipped    int64
+	Failed     int64
+}
+
+// CopyDirectoryOptions configure CopyDirectory
+type CopyDirectoryOptions struct {
+	// Concurrency is the number of objects copied concurrently. Defaults to
+	// the CopyConcurrency of the source.
+	Concurrency int
+	// SkipExisting skips the objects that exist at the destination with the
+	// size of the source, so that an interrupted copy can be resumed
+	SkipExisting bool
+	// Progress is called once each object is copied, skipped or has failed.
+	// It is not called concurrently.
+	Progress func(CopyProgress)
+}
+
+// CopyDirectory copies the objects and directory markers of the tree rooted
+// at src, with their tags, to the same paths under dst. src and dst may be
+// in different buckets or providers, in which case the objects are streamed.
+// Objects that could not be copied are reported by a BatchError, and do not
+// stop the copy of the others. The totals of the progress are counted by a
+// walk of src before the copy starts.
+func CopyDirectory(ctx context.Context, src, dst Directory, opts CopyDirectoryOptions) error {
+	sd, err := asDirectory(src)
+	if err != nil {
+		return err
+	}
+	if err := resolveDestination(ctx, dst); err != nil {
+		return err
+	}
+	var (
+		mu       sync.Mutex
+		progress CopyProgress
+	)
+	if opts.Progress != nil {
+		stats, err := sd.Stats(ctx)
+		if err != nil {
+			return err
+		}
+		progress.ObjectsTotal, progress.BytesTotal = stats.Objects, stats.Bytes
+	}
+	workers := opts.Concurrency
+	if workers <= 0 {
+		workers = sd.bucket.copyWorkers
+	}
+	return sd.transferDirectory(ctx, dst, "copy", workers, func(ctx context.Context, item stow.Item, srcName string, dst Directory, dstName string) error {
+		size, err := item.Size()
+		if err != nil {
+			return err
+		}
+		var skipped bool
+		if opts.SkipExisting {
+			skipped, err = existsWithSize(ctx, dst, dstName, size)
+		}
+		if err == nil && !skipped {
+			err = sd.Copy(ctx, srcName, dst, dstName)
+		}
+		if opts.Progress != nil && !isMarkerName(item.Name()) {
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err != nil:
+				progress.Failed++
+			case skipped:
+				progress.Skipped++
+				progress.ObjectsDone++
+				progress.BytesDone += size
+			default:
+				progress.ObjectsDone++
+				progress.BytesDone += size
+			}
+			opts.Progress(progress)
+		}
+		return err
+	})
+}
+
+// existsWithSize returns true if the named object of d exists with the
+// given size
+func existsWithSize(ctx context.Context, d Directory, name string, size int64) (bool, error) {
+	info, err := d.Stat(ctx, name)
+	switch {
+	case IsObjectNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return info.Size == size, nil
+}
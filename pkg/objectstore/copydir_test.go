@@ -0,0 +1,90 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type CopyDirectorySuite struct{}
+
+var _ = Suite(&CopyDirectorySuite{})
+
+func (s *CopyDirectorySuite) TestCopyDirectory(c *C) {
+	ctx := context.Background()
+	src, _ := newMemBucket("onprem")
+	sd, err := src.CreateDirectory(ctx, "backups")
+	c.Assert(err, IsNil)
+	for i := 0; i < 20; i++ {
+		c.Assert(sd.PutBytes(ctx, fmt.Sprintf("app%d/data", i%4), []byte(fmt.Sprint(i)), nil), IsNil)
+		c.Assert(sd.PutBytes(ctx, fmt.Sprintf("obj%02d", i), []byte(fmt.Sprintf("%04d", i)), map[string]string{"index": fmt.Sprint(i)}), IsNil)
+	}
+	stats, err := sd.Stats(ctx)
+	c.Assert(err, IsNil)
+
+	fc := &putFailingContainer{memContainer: newMemContainer("gcs"), failures: map[string]int{"migrated/obj03": 1, "migrated/obj07": 1}}
+	dst := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	dd, err := dst.CreateDirectory(ctx, "migrated")
+	c.Assert(err, IsNil)
+
+	// Failed objects do not stop the copy of the others
+	var last CopyProgress
+	calls := 0
+	opts := CopyDirectoryOptions{
+		Concurrency: 4,
+		Progress: func(p CopyProgress) {
+			c.Check(p.ObjectsDone+p.Failed, Equals, last.ObjectsDone+last.Failed+1)
+			last = p
+			calls++
+		},
+	}
+	err = CopyDirectory(ctx, sd, dd, opts)
+	be, ok := AsBatchError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(be.Len(), Equals, 2)
+	c.Assert(calls, Equals, 24)
+	c.Assert(last, DeepEquals, CopyProgress{
+		ObjectsDone:  22,
+		ObjectsTotal: stats.Objects,
+		BytesDone:    stats.Bytes - 8,
+		BytesTotal:   stats.Bytes,
+		Failed:       2,
+	})
+	data, tags, err := dd.GetBytes(ctx, "obj05")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0005")
+	c.Assert(tags, DeepEquals, map[string]string{"index": "5"})
+
+	// Resumed copies skip the objects that were copied, and objects with a
+	// different size are copied again
+	c.Assert(dd.PutBytes(ctx, "obj08", []byte("old"), nil), IsNil)
+	last, calls = CopyProgress{}, 0
+	opts.SkipExisting = true
+	c.Assert(CopyDirectory(ctx, sd, dd, opts), IsNil)
+	c.Assert(last, DeepEquals, CopyProgress{
+		ObjectsDone:  24,
+		ObjectsTotal: stats.Objects,
+		BytesDone:    stats.Bytes,
+		BytesTotal:   stats.Bytes,
+		Skipped:      21,
+	})
+	srcObjs, err := sd.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	dstObjs, err := dd.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(dstObjs, DeepEquals, srcObjs)
+	data, _, err = dd.GetBytes(ctx, "obj08")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0008")
+
+	// Failover directories are copied from their primary
+	fd := NewFailoverDirectory(sd, dd, FailoverPolicy{})
+	other, _ := newMemBucket("other")
+	od, err := other.CreateDirectory(ctx, "copy")
+	c.Assert(err, IsNil)
+	c.Assert(CopyDirectory(ctx, fd, od, CopyDirectoryOptions{}), IsNil)
+	dstObjs, err = od.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(dstObjs, DeepEquals, srcObjs)
+}
@@ -5,11 +5,18 @@ package objectstore
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
-	"path/filepath"
+	"math"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/graymeta/stow"
 	"github.com/pkg/errors"
@@ -28,28 +35,84 @@ func (d *directory) String() string {
 	return fmt.Sprintf("%s%s", d.bucket.hostEndPoint, d.path)
 }
 
-// CreateDirectory creates the d.path/dir/ object.
+// CreateDirectory creates the d.path/dir/ object, unless the bucket skips
+// directory markers.
 func (d *directory) CreateDirectory(ctx context.Context, dir string) (Directory, error) {
-	dir = d.absDirName(dir)
-	// Create directory marker
-	if err := d.PutBytes(ctx, dir, nil, nil); err != nil {
+	dir, err := d.dirName(dir)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.createMarker(ctx, dir); err != nil {
+		return nil, err
+	}
+	return &directory{
+		bucket: d.bucket,
+		path:   dir,
+	}, nil
+}
+
+// CreateDirectoryAll creates the d.path/dir/ object and the objects of the
+// directories between d.path and it, like mkdir -p
+func (d *directory) CreateDirectoryAll(ctx context.Context, dir string) (Directory, error) {
+	dir, err := d.dirName(dir)
+	if err != nil {
 		return nil, err
 	}
+	// The markers of d.path and of its parents are not created
+	parent := d.path
+	for _, c := range strings.SplitAfter(strings.TrimPrefix(dir, d.path), "/") {
+		if c == "" {
+			continue
+		}
+		parent += c
+		if err := d.createMarker(ctx, parent); err != nil {
+			return nil, err
+		}
+	}
 	return &directory{
 		bucket: d.bucket,
 		path:   dir,
 	}, nil
 }
 
-// GetDirectory gets the directory object
+// createMarker creates the marker of the directory at the absolute path
+// dir, unless the bucket skips directory markers. Conflicts with concurrent
+// creations of the same directory are not errors if the directory exists
+// afterwards.
+func (d *directory) createMarker(ctx context.Context, dir string) error {
+	if d.bucket.skipMarkers {
+		return d.bucket.limits.validate(dir, d.bucket.redaction)
+	}
+	err := d.PutBytes(ctx, dir, nil, nil)
+	if err == nil || !isConflictError(err) {
+		return err
+	}
+	if _, gerr := d.GetDirectory(ctx, dir); gerr != nil {
+		return err
+	}
+	return nil
+}
+
+// GetDirectory gets the directory object. Directories without a marker
+// exist if objects are named after them, or if a CLI created its own kind
+// of marker for them.
 func (d *directory) GetDirectory(ctx context.Context, dir string) (Directory, error) {
 	if dir == "" {
 		return d, nil
 	}
-	dir = d.absDirName(dir)
-	_, err := d.bucket.container.Item(cloudName(dir))
+	dir, err := d.dirName(dir)
+	if err != nil {
+		return nil, err
+	}
+	_, err = d.bucket.container.Item(cloudName(dir))
+	err = providerError(d.bucket, err)
+	if IsObjectNotFound(err) {
+		if ok, ierr := d.bucket.isImplicitDirectory(ctx, cloudName(dir)); ok || ierr != nil {
+			err = ierr
+		}
+	}
 	if err != nil {
-		return nil, errors.Wrapf(err, "could not get directory marker %s", dir)
+		return nil, errors.Wrapf(err, "could not get directory marker %s", d.bucket.redaction.Redact(dir))
 	}
 	return &directory{
 		bucket: d.bucket,
@@ -57,154 +120,732 @@ func (d *directory) GetDirectory(ctx context.Context, dir string) (Directory, er
 	}, nil
 }
 
-// ListDirectories lists all the directories that have d.path as the prefix.
+// ListDirectories lists all the directories that have d.path as the prefix,
+// with or without markers.
 // the returned map is indexed by the relative directory name (without trailing '/')
 func (d *directory) ListDirectories(ctx context.Context) (map[string]Directory, error) {
+	names, err := d.ListPrefixes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	directories := make(map[string]Directory, len(names))
+	for _, name := range names {
+		directories[name] = &directory{
+			bucket: d.bucket,
+			path:   d.absDirName(name),
+		}
+	}
+	return directories, nil
+}
+
+// ListObjects lists all the files that have d.dirname as the prefix, in
+// lexicographic order. The layout marker is not listed.
+func (d *directory) ListObjects(ctx context.Context) ([]string, error) {
+	infos, err := d.ListObjectsInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]string, 0, len(infos))
+	for _, info := range infos {
+		objects = append(objects, info.Name)
+	}
+	return objects, nil
+}
+
+// ListObjectsRecursive lists all the files that have d.dirname as the
+// prefix, including those in sub directories, in lexicographic order. The
+// names are relative to d.path. Directory markers are skipped.
+func (d *directory) ListObjectsRecursive(ctx context.Context) ([]string, error) {
+	return d.ListObjectsWithPrefix(ctx, "")
+}
+
+// ListObjectsWithPrefix lists the files whose names relative to d.path
+// start with prefix, including those in sub directories, like
+// ListObjectsRecursive. The prefix is not limited to whole directory names.
+func (d *directory) ListObjectsWithPrefix(ctx context.Context, prefix string) ([]string, error) {
 	if d.path == "" {
 		return nil, errors.New("invalid entry")
 	}
 
-	directories := make(map[string]Directory, 0)
-
-	err := stow.Walk(d.bucket.container, cloudName(d.path), 10000,
+	dirPrefix := cloudName(d.path)
+	objects := make([]string, 0, 1)
+	// Empty objects may be placeholders of directories, which is known once
+	// all names are
+	empty := make(map[string]bool)
+	err := stow.Walk(d.bucket.container, dirPrefix+strings.TrimPrefix(prefix, "/"), d.bucket.pageSize,
 		func(item stow.Item, err error) error {
 			if err != nil {
 				return err
 			}
-
-			dir := strings.TrimPrefix(item.Name(), cloudName(d.path))
-			if dir == "" {
-				// e.g., /<d.path>/
-				return nil
+			if err := ctx.Err(); err != nil {
+				return err
 			}
-			// Directories will end with '/' in their names
-			if dirEnt, ok := isDirectoryObject(dir); ok {
-				// Use maps to uniqify
-				// e.g., /dir1/, /dir1/file1, /dir1/dir2/, /dir1/dir2/file2 will leave /dir
-				directories[dirEnt] = &directory{
-					bucket: d.bucket,
-					path:   d.absDirName(dirEnt),
+			objName := strings.TrimPrefix(item.Name(), dirPrefix)
+			if objName != "" && !isMarkerName(objName) {
+				objects = append(objects, objName)
+				if isEmpty(item) {
+					empty[objName] = true
 				}
 			}
-
 			return nil
 		})
 	if err != nil {
 		return nil, err
 	}
-	return directories, nil
+	objects = withoutPlaceholders(objects, empty)
+	sort.Strings(objects)
+	return objects, nil
 }
 
-// ListObjects lists all the files that have d.dirname as the prefix.
-func (d *directory) ListObjects(ctx context.Context) ([]string, error) {
+// ListObjectsPage lists up to limit files that have d.dirname as the
+// prefix, starting at cursor. The returned cursor continues the listing, and
+// is empty once all files have been listed.
+func (d *directory) ListObjectsPage(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	infos, next, err := d.listObjectsPage(ctx, cursor, limit)
+	if err != nil {
+		return nil, "", err
+	}
+	objects := make([]string, 0, len(infos))
+	for _, info := range infos {
+		objects = append(objects, info.Name)
+	}
+	return objects, next, nil
+}
+
+// ListObjectsInfo lists all the files that have d.dirname as the prefix
+// like ListObjects, with the size, modification time and ETag that the
+// listing returns. Tags are not listed.
+func (d *directory) ListObjectsInfo(ctx context.Context) ([]ObjectInfo, error) {
 	if d.path == "" {
 		return nil, errors.New("invalid entry")
 	}
+	infos, err := d.cachedObjects(ctx, func() ([]ObjectInfo, error) {
+		return d.listObjectsInfo(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withoutLayoutMarker(infos), nil
+}
 
-	objects := make([]string, 0, 1)
-	err := stow.Walk(d.bucket.container, cloudName(d.path), 10000,
-		func(item stow.Item, err error) error {
-			if err != nil {
-				return err
-			}
-			objName := strings.TrimPrefix(item.Name(), cloudName(d.path))
-			if objName != "" && strings.Index(objName, "/") == -1 {
-				objects = append(objects, objName)
-			}
-			return nil
-		})
+// ListObjectsModifiedSince lists the files that have d.dirname as the
+// prefix, like ListObjects, that were modified after since. The times are
+// those of the listing, which is filtered on the client. Files whose
+// modification time the provider does not list are included.
+func (d *directory) ListObjectsModifiedSince(ctx context.Context, since time.Time) ([]string, error) {
+	infos, err := d.ListObjectsInfo(ctx)
 	if err != nil {
 		return nil, err
 	}
+	objects := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.LastModified.IsZero() || info.LastModified.After(since) {
+			objects = append(objects, info.Name)
+		}
+	}
 	return objects, nil
 }
 
+func (d *directory) listObjectsInfo(ctx context.Context) ([]ObjectInfo, error) {
+	it, err := d.Objects(ctx, ObjectIteratorOptions{})
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]ObjectInfo, 0, 1)
+	for {
+		info, err := it.Next(ctx)
+		switch {
+		case err == ErrIteratorDone:
+			sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+			return objects, nil
+		case err != nil:
+			return nil, err
+		}
+		objects = append(objects, info)
+	}
+}
+
+// listObjectsPage lists a page of files as ListObjectsPage does, with the
+// information that the listing returns
+func (d *directory) listObjectsPage(ctx context.Context, cursor string, limit int) ([]ObjectInfo, string, error) {
+	if d.path == "" {
+		return nil, "", errors.New("invalid entry")
+	}
+	if limit <= 0 {
+		return nil, "", errors.Errorf("invalid page limit %d", limit)
+	}
+
+	prefix := cloudName(d.path)
+	objects := make([]ObjectInfo, 0, 1)
+	// Items also returns the objects of sub directories, which are skipped.
+	// Only as many items as are still needed are requested, so that the
+	// cursor returned by the container continues after the last item.
+	for len(objects) < limit {
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+		items, next, err := d.bucket.container.Items(prefix, cursor, limit-len(objects))
+		if err != nil {
+			return nil, "", err
+		}
+		for _, item := range items {
+			objName := strings.TrimPrefix(item.Name(), prefix)
+			if objName == "" || strings.Index(objName, "/") != -1 || isMarkerName(objName) || objName == LayoutObjectName {
+				continue
+			}
+			if isEmpty(item) {
+				// Placeholder of a directory
+				placeholder, err := d.bucket.hasObjects(ctx, item.Name()+"/")
+				if err != nil {
+					return nil, "", err
+				}
+				if placeholder {
+					continue
+				}
+			}
+			info, err := objectInfo(objName, item)
+			if err != nil {
+				return nil, "", err
+			}
+			objects = append(objects, info)
+		}
+		if stow.IsCursorEnd(next) {
+			return objects, "", nil
+		}
+		cursor = next
+	}
+	return objects, cursor, nil
+}
+
+// ErrDeleteIncomplete is returned when objects of a directory could not be
+// deleted
+type ErrDeleteIncomplete struct {
+	Path string
+	// Remaining is the number of objects that could not be deleted
+	Remaining int
+	// Deleted is the number of objects that were deleted
+	Deleted int
+	// Errors holds the errors of the objects
+	Errors *BatchError
+	// redaction applies to the message only
+	redaction NameRedaction
+}
+
+func (e *ErrDeleteIncomplete) Error() string {
+	return fmt.Sprintf("failed to delete %d objects in %s, deleted %d: %s", e.Remaining, e.redaction.Redact(e.Path), e.Deleted, e.Errors.itemSummary())
+}
+
+// IsDeleteIncomplete returns true if the cause of err is an
+// ErrDeleteIncomplete
+func IsDeleteIncomplete(err error) bool {
+	_, ok := errors.Cause(err).(*ErrDeleteIncomplete)
+	return ok
+}
+
 // DeleteDirectory deletes all objects that have d.path as the prefix
-// <bucket>/<d.path/<everything> including <bucket>/<d.path>/<some dir>/<objects>
+// <bucket>/<d.path/<everything> including <bucket>/<d.path>/<some dir>/<objects>.
+// DeleteDirectoryCount also returns the number of objects deleted.
+//
+// Objects are deleted in batches if the provider supports it, and
+// otherwise by DeleteConcurrency workers. Deleting objects while walking
+// them can make some providers skip objects, so the prefix is walked again
+// until a walk finds nothing. Objects that cannot be deleted are retried by
+// the next walk, until a walk deletes nothing; an ErrDeleteIncomplete then
+// reports the first errors. The markers that CLIs create next to the
+// directory are deleted last. Shutdown stops the deletion between objects
+// with an ErrShuttingDown.
+//
+// The progress of the deletion is recorded in the DeleteCursorName object
+// every 1000 objects, and when the deletion is interrupted, e.g. by
+// Shutdown, so that deleting the directory again resumes after the last
+// object deleted. The objects before it are checked by a final walk.
 func (d *directory) DeleteDirectory(ctx context.Context) error {
+	_, err := d.DeleteDirectoryCount(ctx)
+	return err
+}
+
+// DeleteDirectoryCount deletes the directory like DeleteDirectory, and
+// returns the number of objects deleted, over all the runs that resumed the
+// deletion
+func (d *directory) DeleteDirectoryCount(ctx context.Context) (int, error) {
 	if d.path == "" {
-		return errors.New("invalid entry")
+		return 0, errors.New("invalid entry")
 	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+	defer d.bucket.listingCache.invalidate(d.path)
 
-	// Walk to find all entries that match the d.path prefix.
-	err := stow.Walk(d.bucket.container, cloudName(d.path), 10000,
+	prefix := cloudName(d.path)
+	// Markers next to the directory must be found while it has objects
+	markers, err := d.bucket.siblingMarkers(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	cursor, saved, err := readDeleteCursor(ctx, d)
+	if err != nil {
+		return 0, err
+	}
+	cursorKey := cloudName(d.absPathName(DeleteCursorName))
+	save := func() error {
+		saved = true
+		return writeDeleteCursor(ctx, d, cursor)
+	}
+	// Only the first walk resumes after the cursor
+	after := cursor.After
+	for {
+		od := newObjectDeleter(ctx, d.bucket)
+		var added int
+		var skipped bool
+		// Walk to find all entries that match the d.path prefix.
+		err := stow.Walk(d.bucket.container, prefix, d.bucket.pageSize,
+			func(item stow.Item, err error) error {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				if err := d.bucket.stopping(); err != nil {
+					return err
+				}
+				switch name := item.Name(); {
+				case name == cursorKey:
+					return nil
+				case after != "" && name <= after:
+					skipped = true
+					return nil
+				}
+				od.add(ctx, item.Name())
+				cursor.After = item.Name()
+				if added++; added%deleteCursorInterval == 0 {
+					return save()
+				}
+				return nil
+			})
+		if err == nil {
+			od.flush(ctx)
+		}
+		od.wait()
+		if err == nil {
+			err = ctx.Err()
+		}
+		cursor.Deleted += od.removed
+		after = ""
+		switch {
+		case err != nil:
+			// The next deletion resumes from the cursor
+			if cursor.After != "" {
+				_ = save()
+			}
+			return cursor.Deleted, err
+		case skipped:
+			// Walk again over the objects before the cursor
+		case od.removed == 0 && od.errs.Len() == 0:
+			if saved {
+				if err := d.bucket.container.RemoveItem(cursorKey); err != nil && !IsObjectNotFound(err) {
+					return cursor.Deleted, providerError(d.bucket, err)
+				}
+			}
+			n, err := removeMarkers(ctx, d.bucket, markers)
+			return cursor.Deleted + n, err
+		case od.removed == 0:
+			return cursor.Deleted, &ErrDeleteIncomplete{
+				Path:      d.path,
+				Remaining: od.errs.Len(),
+				Deleted:   cursor.Deleted,
+				Errors:    od.errs,
+				redaction: d.bucket.redaction,
+			}
+		}
+	}
+}
+
+// DeleteDirectoryDryRun returns the keys of the objects in the bucket that
+// DeleteDirectory would delete, including the directory markers, without
+// deleting them
+func (d *directory) DeleteDirectoryDryRun(ctx context.Context) ([]string, error) {
+	if d.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+
+	prefix := cloudName(d.path)
+	markers, err := d.bucket.siblingMarkers(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, 1)
+	err = stow.Walk(d.bucket.container, prefix, d.bucket.pageSize,
 		func(item stow.Item, err error) error {
 			if err != nil {
 				return err
 			}
-
-			return d.bucket.container.RemoveItem(item.Name())
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			keys = append(keys, item.Name())
+			return nil
 		})
-
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return append(keys, markers...), nil
+}
 
-	return nil
+// DeleteDirectoryBestEffort deletes as many objects of the directory as it
+// can, like DeleteDirectory. Objects that cannot be deleted are reported by
+// an ErrDeleteIncomplete, which also counts the objects that were deleted.
+// Other errors, such as failures to list the objects, stop the deletion.
+func (d *directory) DeleteDirectoryBestEffort(ctx context.Context) error {
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	if err != nil && !IsDeleteIncomplete(err) {
+		return errors.Wrapf(err, "failed to delete directory %s after deleting %d objects", d.bucket.redaction.Redact(d.path), deleted)
+	}
+	return err
 }
 
-func (d *directory) Get(ctx context.Context, name string) (io.ReadCloser, map[string]string, error) {
+// Exists checks whether the object <bucket>/<d.path>/name exists. A missing
+// object is not an error.
+func (d *directory) Exists(ctx context.Context, name string) (bool, error) {
 	if d.path == "" {
-		return nil, nil, errors.New("invalid entry")
+		return false, errors.New("invalid entry")
 	}
 
-	objName := d.absPathName(name)
+	objName, err := d.objectName(name)
+	if err != nil {
+		return false, err
+	}
+	_, err = d.item(ctx, objName)
+	switch {
+	case err == nil:
+		return true, nil
+	case IsObjectNotFound(err):
+		return false, nil
+	}
+	return false, errors.Wrapf(err, "could not get object %s", d.bucket.redaction.Redact(objName))
+}
 
-	item, err := d.bucket.container.Item(cloudName(objName))
+func (d *directory) Get(ctx context.Context, name string) (io.ReadCloser, map[string]string, error) {
+	return d.GetOpts(ctx, name)
+}
+
+// GetSpooled reads the object <bucket>/<d.path>/name into local storage and
+// returns a reader over the local copy. The provider connection is closed
+// once the data has been spooled and verified.
+func (d *directory) GetSpooled(ctx context.Context, name string, opts SpoolOptions) (io.ReadCloser, map[string]string, error) {
+	return d.GetOpts(ctx, name, WithSpool(opts))
+}
+
+// GetOpts returns a reader of the object <bucket>/<d.path>/name and its
+// tags, as configured by opts. Encrypted objects are decrypted, and
+// compressed objects are decompressed.
+func (d *directory) GetOpts(ctx context.Context, name string, opts ...GetOption) (io.ReadCloser, map[string]string, error) {
+	s := newGetSettings(opts)
+	ctx = s.context(ctx)
+	item, r, tags, err := d.open(ctx, name)
 	if err != nil {
 		return nil, nil, err
 	}
-
-	// Open the object and read all data
-	r, err := item.Open()
+	// Not all providers report an ETag. Skip verification in that case.
+	etag, _ := item.ETag()
+	objName := d.bucket.redaction.Redact(d.absPathName(name))
+	verifyChecksum := s.verifyChecksum || verifiedChecksumsFromContext(ctx)
+	if s.spool == nil {
+		if s.verify {
+			r = newVerifyingReadCloser(r, objName, etag)
+		}
+		if r, err = decrypt(d.bucket, objName, r, tags); err != nil {
+			return nil, nil, err
+		}
+		if r, err = decompress(objName, r, tags); err != nil {
+			return nil, nil, err
+		}
+		if verifyChecksum {
+			r = verifyChecksumTag(r, objName, tags)
+		}
+		return r, tags, nil
+	}
+	size, err := item.Size()
 	if err != nil {
+		_ = r.Close()
 		return nil, nil, err
 	}
-	rTags, err := item.Metadata()
+	sr, err := spool(ctx, r, size, etag, *s.spool)
 	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to spool object %s", d.bucket.redaction.Redact(name))
+	}
+	if sr, err = decrypt(d.bucket, objName, sr, tags); err != nil {
+		return nil, nil, err
+	}
+	if sr, err = decompress(objName, sr, tags); err != nil {
 		return nil, nil, err
 	}
+	if verifyChecksum {
+		sr = verifyChecksumTag(sr, objName, tags)
+	}
+	return sr, tags, nil
+}
 
-	// Convert tags:map[string]interface{} into map[string]string
-	tags := make(map[string]string)
-	for key, val := range rTags {
-		if sVal, ok := val.(string); ok {
-			tags[key] = sVal
+func (d *directory) open(ctx context.Context, name string) (stow.Item, io.ReadCloser, map[string]string, error) {
+	if d.path == "" {
+		return nil, nil, nil, errors.New("invalid entry")
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	// The read is in flight until the reader is closed
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var item stow.Item
+	var rc io.ReadCloser
+	err = retry(ctx, d.bucket, "read of "+d.bucket.redaction.Redact(objName), func() error {
+		var err error
+		if item, err = d.bucket.container.Item(cloudName(objName)); err != nil {
+			return err
+		}
+		// Open the object and read all data
+		rc, err = item.Open()
+		return err
+	})
+	if err != nil {
+		release()
+		if IsObjectNotFound(err) {
+			return nil, nil, nil, &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
 		}
+		return nil, nil, nil, providerError(d.bucket, err)
 	}
+	size, err := item.Size()
+	if err != nil {
+		size = -1
+	}
+	etag, _ := item.ETag()
+	rc = newResumingReader(ctx, d.bucket, cloudName(objName), rc, size, etag)
+	// Cancelling ctx interrupts the transfer
+	var r io.ReadCloser = &releasingReadCloser{ReadCloser: newCtxReadCloser(ctx, rc), release: release}
+	rTags, err := item.Metadata()
+	if err != nil {
+		_ = r.Close()
+		return nil, nil, nil, err
+	}
+	if progressReporterFromContext(ctx) != nil {
+		r = progressReadCloser{
+			Reader: reportProgress(ctx, d.bucket.redaction.Redact(objName), r, size),
+			Closer: r,
+		}
+	}
+	r = countDownload(ctx, r)
 
-	return r, tags, nil
+	// Convert tags:map[string]interface{} into map[string]string
+	return item, r, d.bucket.itemTags(rTags), nil
 }
 
 // Get data and tags associated with an object <bucket>/<d.path>/name.
+// Objects larger than the GetBytesLimit of the bucket are not read, and fail
+// with an ErrObjectTooLarge.
 func (d *directory) GetBytes(ctx context.Context, name string) ([]byte, map[string]string, error) {
+	return d.GetBytesLimit(ctx, name, d.bucket.getBytesLimit)
+}
+
+// GetBytesLimit returns the data and tags of the object
+// <bucket>/<d.path>/name, or an ErrObjectTooLarge if the object has more than
+// maxBytes bytes of data. The read stops once the limit is exceeded.
+func (d *directory) GetBytesLimit(ctx context.Context, name string, maxBytes int64) ([]byte, map[string]string, error) {
+	if maxBytes < 0 {
+		return nil, nil, errors.Errorf("invalid limit %d", maxBytes)
+	}
 	r, tags, err := d.Get(ctx, name)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer r.Close()
 
-	data, err := ioutil.ReadAll(r)
+	// Read one more byte to find out whether the limit is exceeded
+	n := maxBytes
+	if n < math.MaxInt64 {
+		n++
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, n))
 	if err != nil {
 		return nil, nil, err
 	}
-
+	if int64(len(data)) > maxBytes {
+		return nil, nil, &ErrObjectTooLarge{Name: d.bucket.redaction.Redact(d.absPathName(name)), Size: -1, Max: maxBytes}
+	}
 	return data, tags, nil
 }
 
 func (d *directory) Put(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error {
+	return d.PutOpts(ctx, name, r, size, tags)
+}
+
+// PutOpts persists data from the Reader in the named object, as configured
+// by opts. Objects larger than the multipart threshold are uploaded in
+// parts, if the provider supports it. Objects with a content type or server-side encryption are always
+// uploaded in parts, since only multipart stores set them.
+func (d *directory) PutOpts(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string, opts ...PutOption) error {
 	if d.path == "" {
 		return errors.New("invalid entry")
 	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return err
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	s := newPutSettings(opts)
+	s.contentType = d.bucket.putContentType(name, s)
+	if s.objectTags != nil {
+		if err := validateObjectTags(s.objectTags); err != nil {
+			return err
+		}
+		if d.bucket.objectTagger == nil {
+			tags = withTag(tags, ObjectTagsTag, encodeObjectTags(s.objectTags))
+		}
+	}
+	if s.contentType != "" && !d.bucket.supportsContentType() {
+		if err := s.unsupported("content type", func() { s.contentType = "" }); err != nil {
+			return err
+		}
+	}
+	var v versioner
+	if s.versionID != nil {
+		v = d.bucket.enabledVersioner(ctx)
+	}
+	sse, err := d.bucket.encryption(s.sse, d.bucket.multipart != nil || v != nil)
+	if err != nil {
+		return err
+	}
+	ctx = s.context(ctx)
+	if s.idempotencyKey == "" {
+		s.idempotencyKey = idempotencyKeyFromContext(ctx)
+	}
+	var sum string
+	if s.checksum {
+		var done func()
+		if r, size, sum, done, err = checksumData(r, size); err != nil {
+			return errors.Wrapf(err, "failed to upload object %s", d.bucket.redaction.Redact(objName))
+		}
+		defer done()
+		tags = withTag(tags, ChecksumTag, sum)
+	}
+	codec, err := d.bucket.putCodec(s)
+	if err != nil {
+		return err
+	}
+	if codec != nil {
+		tags = withContentEncoding(tags, codec.Name())
+	}
+	sl, err := d.bucket.putSealer(s)
+	if err != nil {
+		return err
+	}
+	if sl != nil {
+		tags = sl.withTags(tags)
+	}
+	if codec != nil || sl != nil {
+		tags = withOriginalSize(tags, size)
+	}
 	// K10 tags include '/'. Remove them, at least for S3
-	sTags := sanitizeTags(tags)
+	sTags := sanitizeTags(withIdempotencyTag(tags, s.idempotencyKey))
 
-	objName := d.absPathName(name)
+	if err := d.bucket.limits.validate(objName, d.bucket.redaction); err != nil {
+		return err
+	}
+	if err := d.bucket.validateSize(objName, size); err != nil {
+		return err
+	}
 
-	// For versioned buckets, Put can return the new version name
-	// TODO: Support versioned buckets
-	_, err := d.bucket.container.Put(cloudName(objName), r, size, sTags)
-	return err
+	if err := checkQuota(ctx, d.bucket, size); err != nil {
+		return err
+	}
+	if err := d.bucket.registerLayoutFeatures(ctx, putLayoutFeatures(codec, sl, s.objectTags != nil && d.bucket.objectTagger == nil)...); err != nil {
+		return err
+	}
+
+	if codec != nil {
+		cr := newCompressingReader(codec, r)
+		defer cr.Close()
+		r, size = cr, -1
+		if d.bucket.multipart == nil {
+			// Objects of unknown size can only be uploaded in parts
+			sr, n, err := spoolToFile(cr, -1, "")
+			if err != nil {
+				return errors.Wrapf(err, "failed to compress object %s", d.bucket.redaction.Redact(objName))
+			}
+			defer sr.Close()
+			r, size = sr, n
+		}
+	}
+	if sl != nil {
+		r, size = sl.reader(r, size)
+	}
+
+	var h hash.Hash
+	if s.verify {
+		h = md5.New()
+		r = newHashingReader(r, h)
+	}
+	r, uploaded := countUpload(ctx, r)
+	popts := s.opts.merge(d.bucket.putOptions).withDefaults()
+	attrs := objectAttrs{contentType: s.contentType, encryption: sse}
+	var version string
+	m := d.bucket.multipart
+	switch {
+	case v != nil:
+		// Versions are uploaded by the versioner, which reports their ID
+		version, err = putVersion(ctx, d.bucket, v, objName, r, size, stringTags(sTags), attrs, popts)
+	case m != nil && (size < 0 || size > popts.MultipartThreshold || attrs != objectAttrs{}):
+		r = reportProgress(ctx, d.bucket.redaction.Redact(objName), &ctxReader{ctx: ctx, r: r}, size)
+		err = multipartPut(ctx, d.bucket, m, cloudName(objName), r, size, stringTags(sTags), attrs, popts)
+	default:
+		err = retryPut(ctx, d.bucket, objName, r, size, sTags)
+	}
+	// A failed upload may still have changed the object
+	d.bucket.listingCache.invalidate(objName)
+	if err != nil {
+		return err
+	}
+	uploaded()
+	addUsage(ctx, d.bucket, size)
+	if h != nil {
+		if err := d.verifyPut(objName, h); err != nil {
+			return err
+		}
+	}
+	if t := d.bucket.objectTagger; t != nil && s.objectTags != nil {
+		// The tagging API tags objects once they are written
+		if err := t.PutObjectTags(ctx, cloudName(objName), s.objectTags); err != nil {
+			return errors.Wrapf(err, "failed to set the object tags of %s", d.bucket.redaction.Redact(objName))
+		}
+	}
+	if s.checksumOut != nil {
+		*s.checksumOut = sum
+	}
+	if s.versionID != nil {
+		*s.versionID = version
+	}
+	return nil
+}
+
+// verifyPut compares the digest h of the data uploaded to the object
+// objName with its ETag
+func (d *directory) verifyPut(objName string, h hash.Hash) error {
+	item, err := d.bucket.container.Item(cloudName(objName))
+	if err != nil {
+		return errors.Wrapf(err, "failed to verify object %s", d.bucket.redaction.Redact(objName))
+	}
+	// Not all providers report an ETag. Skip verification in that case.
+	etag, _ := item.ETag()
+	return verifyChecksum(d.bucket.redaction.Redact(objName), h, etag)
 }
 
 // Put stores a blob in d.path/<name>
@@ -218,9 +859,126 @@ func (d *directory) Delete(ctx context.Context, name string) error {
 		return errors.New("invalid entry")
 	}
 
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	objName, err := d.objectName(name)
+	if err != nil {
+		return err
+	}
+	defer d.bucket.listingCache.invalidate(objName)
+
+	err = retry(ctx, d.bucket, "delete of "+d.bucket.redaction.Redact(objName), func() error {
+		return d.bucket.container.RemoveItem(cloudName(objName))
+	})
+	if IsObjectNotFound(err) {
+		return &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+	}
+	return providerError(d.bucket, err)
+}
+
+// DeleteObjects removes the named objects, in batches if the provider
+// supports it. Missing objects are not errors. The objects that could not
+// be deleted are reported by a BatchError.
+func (d *directory) DeleteObjects(ctx context.Context, names []string) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+
+	objNames := make([]string, len(names))
+	for i, name := range names {
+		objName, err := d.objectName(name)
+		if err != nil {
+			return err
+		}
+		objNames[i] = objName
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	defer func() {
+		for _, objName := range objNames {
+			d.bucket.listingCache.invalidate(objName)
+		}
+	}()
+
+	od := newObjectDeleter(ctx, d.bucket)
+	for _, objName := range objNames {
+		od.add(ctx, cloudName(objName))
+	}
+	od.flush(ctx)
+	od.wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return od.errs.errOrNil()
+}
+
+// item returns the item of the object at the absolute path objName
+func (d *directory) item(ctx context.Context, objName string) (stow.Item, error) {
+	var item stow.Item
+	err := retry(ctx, d.bucket, "read of "+d.bucket.redaction.Redact(objName), func() error {
+		var err error
+		item, err = d.bucket.container.Item(cloudName(objName))
+		return err
+	})
+	return item, providerError(d.bucket, err)
+}
+
+// ErrInvalidName is returned for names of objects and directories that
+// resolve outside of the directory they are relative to, through ".."
+// segments or as absolute names
+type ErrInvalidName struct {
+	Name string
+	Dir  string
+}
+
+func (e *ErrInvalidName) Error() string {
+	return fmt.Sprintf("name %s resolves outside of directory %s", e.Name, e.Dir)
+}
+
+// IsInvalidName returns true if the cause of err is an ErrInvalidName
+func IsInvalidName(err error) bool {
+	_, ok := errors.Cause(err).(*ErrInvalidName)
+	return ok
+}
+
+// objectName returns the absolute path of the object name, or an
+// ErrInvalidName if it is not in d.path. Paths beyond the limits of the
+// bucket are rejected by every operation.
+func (d *directory) objectName(name string) (string, error) {
 	objName := d.absPathName(name)
+	if name != "" && !strings.HasPrefix(objName, d.path) {
+		return "", d.invalidName(name)
+	}
+	if err := d.bucket.limits.check(objName, d.bucket.redaction); err != nil {
+		return "", err
+	}
+	return objName, nil
+}
 
-	return d.bucket.container.RemoveItem(cloudName(objName))
+// dirName returns the absolute path of the directory dir, or an
+// ErrInvalidName if it is not d.path or one of its subdirectories. Paths
+// beyond the limits of the bucket are rejected like in objectName.
+func (d *directory) dirName(dir string) (string, error) {
+	absDir := d.absDirName(dir)
+	if !strings.HasPrefix(absDir, d.path) {
+		return "", d.invalidName(dir)
+	}
+	if err := d.bucket.limits.check(absDir, d.bucket.redaction); err != nil {
+		return "", err
+	}
+	return absDir, nil
+}
+
+func (d *directory) invalidName(name string) error {
+	return &ErrInvalidName{Name: d.bucket.redaction.Redact(name), Dir: d.bucket.redaction.Redact(d.path)}
 }
 
 // If name does not start with '/', prefix with d.path. Add '/' as suffix
@@ -229,7 +987,7 @@ func (d *directory) absDirName(dir string) string {
 
 	// End with a '/'
 	if !strings.HasSuffix(dir, "/") {
-		dir = filepath.Clean(dir) + "/"
+		dir = path.Clean(dir) + "/"
 	}
 
 	return dir
@@ -241,47 +999,142 @@ func (d *directory) absDirName(dir string) string {
 // strips the initial '/' for stow operations. '/' still
 // implies root for objectstore.
 func cloudName(dir string) string {
-	return strings.TrimPrefix(dir, "/")
+	return strings.TrimLeft(dir, "/")
 }
 
-// If name does not start with '/', prefix with d.path.
+// If name does not start with '/', prefix with d.path. Repeated slashes,
+// which some providers store as distinct keys, are collapsed.
 func (d *directory) absPathName(name string) string {
 	if name == "" {
 		return ""
 	}
-	if !filepath.IsAbs(name) {
+	if !path.IsAbs(name) {
 		name = d.path + name
 	}
 
-	return name
+	return cleanName(name)
+}
+
+// cleanName cleans name like path.Clean, but keeps its trailing '/', which
+// distinguishes directories from objects
+func cleanName(name string) string {
+	cleaned := path.Clean(name)
+	if strings.HasSuffix(name, "/") && !strings.HasSuffix(cleaned, "/") {
+		cleaned += "/"
+	}
+	return cleaned
+}
+
+// Tag keys are percent-encoded, since S3 only accepts printable ASCII
+// characters other than '/' in metadata keys. Objects with tags are marked
+// with tagEncodingKey. Legacy tags, written before the encoding, replaced
+// '/' with "-", and are read as configured by the LegacyTagsConfig of the
+// bucket; see MigrateTags.
+func tagKeyEscaped(c byte) bool {
+	return c == '%' || c == '/' || c <= ' ' || c >= 0x7f
+}
+
+// encodeTagKey percent-encodes the characters of the key that S3 does not
+// accept
+func encodeTagKey(key string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; tagKeyEscaped(c) {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		} else {
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
+
+// decodeTagKey decodes the escapes of encodeTagKey, in upper or lower case
+// since S3 lowercases metadata keys
+func decodeTagKey(key string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(key); i++ {
+		if key[i] == '%' && i+3 <= len(key) {
+			if c, err := strconv.ParseUint(key[i+1:i+3], 16, 8); err == nil && tagKeyEscaped(byte(c)) {
+				buf.WriteByte(byte(c))
+				i += 2
+				continue
+			}
+		}
+		buf.WriteByte(key[i])
+	}
+	return buf.String()
+}
+
+// Tag values with characters other than printable ASCII, which S3 does not
+// accept in metadata values, are written as an RFC 2047 encoded word of
+// their base64 encoding, as S3 recommends. Other values are written as they
+// are, unless they could be read as an encoded word.
+const (
+	tagValuePrefix = "=?utf-8?b?"
+	tagValueSuffix = "?="
+)
+
+// encodeTagValue encodes the value if S3 does not accept it
+func encodeTagValue(val string) string {
+	encode := strings.Contains(val, "=?")
+	for i := 0; i < len(val) && !encode; i++ {
+		encode = val[i] < ' ' || val[i] > '~'
+	}
+	if !encode {
+		return val
+	}
+	return tagValuePrefix + base64.StdEncoding.EncodeToString([]byte(val)) + tagValueSuffix
 }
 
-// sanitizeTags replaces '/' with "-" in tag keys
+// decodeTagValue decodes the values encoded by encodeTagValue
+func decodeTagValue(val string) string {
+	if !strings.HasPrefix(val, tagValuePrefix) || !strings.HasSuffix(val, tagValueSuffix) {
+		return val
+	}
+	data, err := base64.StdEncoding.DecodeString(val[len(tagValuePrefix) : len(val)-len(tagValueSuffix)])
+	if err != nil {
+		return val
+	}
+	return string(data)
+}
+
+// tagEncodingKey marks the objects whose tags are encoded by sanitizeTags.
+// Its key needs no escapes.
+const (
+	tagEncodingKey   = "kanister-tag-encoding"
+	tagEncodingValue = "1"
+)
+
+// sanitizeTags encodes the tag keys and values of an object, and marks
+// their encoding if it has any
 func sanitizeTags(tags map[string]string) map[string]interface{} {
 	cTags := make(map[string]interface{})
 	for key, val := range tags {
-		cKey := strings.Replace(key, "/", "-", -1)
-		cTags[cKey] = val
+		cTags[encodeTagKey(key)] = encodeTagValue(val)
+	}
+	if len(cTags) != 0 {
+		cTags[tagEncodingKey] = tagEncodingValue
 	}
 	return cTags
 }
 
-// isDirectoryObject checks if path includes one '/'.
-// If so, returns value until first '/'
-// path is of the form elem1/elem2/, returns elem1
-func isDirectoryObject(path string) (string, bool) {
-	s := strings.SplitN(path, "/", 3)
-	switch len(s) {
-	case 1:
-		// No '/'
-		return "", false
-	case 2:
-		return s[0], true
-	case 3:
-		// dir1/dir2/elem will return false
-		return "", false
-	}
-
-	// Not reached
-	return "", false
+// itemTags returns the tags of an object from its metadata, with their
+// keys and values decoded
+func (b *bucket) itemTags(metadata map[string]interface{}) map[string]string {
+	return b.decodeTags(stringTags(metadata))
+}
+
+// decodeTags returns the tags of sanitized tags. Legacy tags are read as
+// configured by the bucket.
+func (b *bucket) decodeTags(sTags map[string]string) map[string]string {
+	if isLegacyTags(sTags) {
+		return b.legacyTags.read(sTags)
+	}
+	tags := make(map[string]string, len(sTags))
+	for key, val := range sTags {
+		if key != tagEncodingKey {
+			tags[decodeTagKey(key)] = decodeTagValue(val)
+		}
+	}
+	return tags
 }
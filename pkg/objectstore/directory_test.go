@@ -0,0 +1,1117 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type DirectorySuite struct{}
+
+var _ = Suite(&DirectorySuite{})
+
+func (s *DirectorySuite) TestListObjectsPage(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("list")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	var expected []string
+	for i := 0; i < 25; i++ {
+		name := fmt.Sprintf("obj%02d", i)
+		expected = append(expected, name)
+		c.Assert(d.PutBytes(ctx, name, nil, nil), IsNil)
+		// Objects in sub directories and siblings are not listed
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("sub/%s", name), nil, nil), IsNil)
+		c.Assert(b.PutBytes(ctx, fmt.Sprintf("dir2/%s", name), nil, nil), IsNil)
+	}
+
+	for _, limit := range []int{1, 7, 25, 100} {
+		var objs []string
+		cursor := ""
+		pages := 0
+		for {
+			page, next, err := d.ListObjectsPage(ctx, cursor, limit)
+			c.Assert(err, IsNil)
+			pages++
+			if next != "" {
+				c.Assert(page, HasLen, limit)
+			}
+			objs = append(objs, page...)
+			if next == "" {
+				break
+			}
+			cursor = next
+		}
+		c.Assert(objs, DeepEquals, expected, Commentf("limit %d", limit))
+		c.Assert(pages <= len(expected)/limit+1, Equals, true, Commentf("limit %d: %d pages", limit, pages))
+	}
+
+	// ListObjects returns the same objects
+	objs, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, expected)
+
+	_, _, err = d.ListObjectsPage(ctx, "", 0)
+	c.Assert(err, ErrorMatches, "invalid page limit 0")
+}
+
+func (s *DirectorySuite) TestListObjectsPageEmpty(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("list")
+	d, err := b.CreateDirectory(ctx, "empty")
+	c.Assert(err, IsNil)
+	objs, next, err := d.ListObjectsPage(ctx, "", 10)
+	c.Assert(err, IsNil)
+	c.Assert(objs, HasLen, 0)
+	c.Assert(next, Equals, "")
+}
+
+func (s *DirectorySuite) TestListObjectsRecursive(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("list")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	a, err := d.CreateDirectory(ctx, "a")
+	c.Assert(err, IsNil)
+	_, err = a.CreateDirectory(ctx, "b")
+	c.Assert(err, IsNil)
+	c.Assert(a.PutBytes(ctx, "b/c.txt", []byte("c"), nil), IsNil)
+	c.Assert(a.PutBytes(ctx, "d.txt", []byte("d"), nil), IsNil)
+	c.Assert(d.PutBytes(ctx, "e.txt", []byte("e"), nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "dir2/f.txt", []byte("f"), nil), IsNil)
+
+	objs, err := d.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"a/b/c.txt", "a/d.txt", "e.txt"})
+
+	objs, err = a.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"b/c.txt", "d.txt"})
+
+	// Prefixes need not be whole directory names
+	objs, err = d.ListObjectsWithPrefix(ctx, "a/b/")
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"a/b/c.txt"})
+	objs, err = d.ListObjectsWithPrefix(ctx, "a/")
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"a/b/c.txt", "a/d.txt"})
+	objs, err = d.ListObjectsWithPrefix(ctx, "e")
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"e.txt"})
+	objs, err = d.ListObjectsWithPrefix(ctx, "missing/")
+	c.Assert(err, IsNil)
+	c.Assert(objs, HasLen, 0)
+
+	// ListObjects only returns the immediate objects
+	objs, err = d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"e.txt"})
+}
+
+// offsetContainer pages items by offset, so that items are skipped when
+// earlier items are removed during a walk
+type offsetContainer struct {
+	*memContainer
+}
+
+func (c *offsetContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	var offset int
+	if cursor != stow.CursorStart {
+		offset, _ = strconv.Atoi(cursor)
+	}
+	items, _, err := c.memContainer.Items(prefix, "", offset+count)
+	if err != nil || len(items) <= offset {
+		return nil, "", err
+	}
+	items = items[offset:]
+	if len(items) < count {
+		return items, "", nil
+	}
+	return items, strconv.Itoa(offset + count), nil
+}
+
+// failingContainer fails to remove items a number of times
+type failingContainer struct {
+	*memContainer
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func (c *failingContainer) RemoveItem(id string) error {
+	c.mu.Lock()
+	if c.failures[id] > 0 {
+		c.failures[id]--
+		c.mu.Unlock()
+		return errors.New("503 Service Unavailable")
+	}
+	c.mu.Unlock()
+	return c.memContainer.RemoveItem(id)
+}
+
+func (s *DirectorySuite) TestDeleteDirectory(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("delete")
+	b := newBucket(ProviderConfig{PageSize: 7}, &offsetContainer{mc}, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "big")
+	c.Assert(err, IsNil)
+	const n = 2*7 + 1
+	for i := 0; i < n; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("chunk%06d", i), nil, nil), IsNil)
+	}
+	c.Assert(b.PutBytes(ctx, "big2/other", nil, nil), IsNil)
+
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(err, IsNil)
+	// The objects and the directory marker
+	c.Assert(deleted, Equals, n+1)
+	c.Assert(mc.items, HasLen, 1)
+	c.Assert(mc.items["big2/other"], NotNil)
+}
+
+func (s *DirectorySuite) TestDeleteDirectoryDryRun(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("dryrun")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	c.Assert(d.PutBytes(ctx, "sub/obj", []byte("data"), nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "dir_$folder$", nil, nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "dir2/obj", nil, nil), IsNil)
+
+	keys, err := d.DeleteDirectoryDryRun(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(keys, DeepEquals, []string{"dir/", "dir/obj", "dir/sub/obj", "dir_$folder$"})
+	c.Assert(mc.items, HasLen, 5)
+
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, len(keys))
+	keys, err = d.DeleteDirectoryDryRun(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(keys, HasLen, 0)
+}
+
+func (s *DirectorySuite) TestDeleteDirectoryErrors(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("delete")
+	fc := &failingContainer{memContainer: mc, failures: make(map[string]int)}
+	b := newBucket(ProviderConfig{}, fc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("dir/obj%02d", i)
+		c.Assert(b.PutBytes(ctx, name, nil, nil), IsNil)
+		switch {
+		case i < 3:
+			// Transient errors are retried
+			fc.failures[name] = 1
+		case i >= 13:
+			fc.failures[name] = 100
+		}
+	}
+
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(IsDeleteIncomplete(err), Equals, true)
+	// The directory marker and 13 objects
+	c.Assert(deleted, Equals, 14)
+	ed := err.(*ErrDeleteIncomplete)
+	c.Assert(ed.Remaining, Equals, 7)
+	c.Assert(ed.Deleted, Equals, 14)
+	c.Assert(ed.Errors.Len(), Equals, 7)
+	c.Assert(ed.Errors.Items(ClassTransient), HasLen, 7)
+	c.Assert(err, ErrorMatches, "failed to delete 7 objects in /dir/, deleted 14: failed to delete dir/obj13: 503 Service Unavailable; .*; and 2 more")
+	c.Assert(strings.Count(err.Error(), "503"), Equals, maxBatchErrorSummary)
+	c.Assert(mc.items, HasLen, 7)
+
+	err = d.DeleteDirectoryBestEffort(ctx)
+	c.Assert(IsDeleteIncomplete(err), Equals, true)
+	c.Assert(err, ErrorMatches, "failed to delete 7 objects in /dir/, deleted 0: .*")
+	c.Assert(mc.items, HasLen, 7)
+
+	// Later calls delete the rest
+	for name := range fc.failures {
+		fc.failures[name] = 0
+	}
+	deleted, err = d.DeleteDirectoryCount(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 7)
+	c.Assert(mc.items, HasLen, 0)
+}
+
+// pageSizeContainer records the page sizes that items are listed with
+type pageSizeContainer struct {
+	*memContainer
+	counts map[int]int
+}
+
+func (c *pageSizeContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	c.counts[count]++
+	return c.memContainer.Items(prefix, cursor, count)
+}
+
+func (s *DirectorySuite) TestPageSize(c *C) {
+	ctx := context.Background()
+	pc := &pageSizeContainer{memContainer: newMemContainer("pages"), counts: make(map[int]int)}
+	b := newBucket(ProviderConfig{}, pc, nil, "mem://")
+	c.Assert(b.pageSize, Equals, listPageSize)
+
+	b = newBucket(ProviderConfig{PageSize: 3}, pc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("sub%d/obj", i), nil, nil), IsNil)
+	}
+	dirs, err := d.ListDirectories(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(dirs, HasLen, 10)
+	_, err = d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	objs, err := d.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, HasLen, 10)
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 11)
+	// Every listing used the configured page size
+	c.Assert(pc.counts, HasLen, 1)
+	c.Assert(pc.counts[3] > 0, Equals, true)
+}
+
+// cappingContainer returns at most max items per page, like stores that cap
+// the page size below the requested one
+type cappingContainer struct {
+	*memContainer
+	max int
+}
+
+func (c *cappingContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	if count > c.max {
+		count = c.max
+	}
+	return c.memContainer.Items(prefix, cursor, count)
+}
+
+func (s *DirectorySuite) TestCappedPageSize(c *C) {
+	ctx := context.Background()
+	cc := &cappingContainer{memContainer: newMemContainer("capped"), max: 2}
+	b := newBucket(ProviderConfig{PageSize: 5}, cc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 7; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%d", i), nil, nil), IsNil)
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("sub%d/obj", i), nil, nil), IsNil)
+	}
+	dirs, err := d.ListDirectories(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(dirs, HasLen, 7)
+	objs, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, HasLen, 7)
+	page, next, err := d.ListObjectsPage(ctx, "", 5)
+	c.Assert(err, IsNil)
+	c.Assert(page, HasLen, 5)
+	c.Assert(next, Not(Equals), "")
+	objs, err = d.ListObjectsRecursive(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, HasLen, 14)
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, 15)
+}
+
+// cancelContainer cancels a context once a number of pages were listed
+type cancelContainer struct {
+	*memContainer
+	pages  int
+	cancel context.CancelFunc
+}
+
+func (c *cancelContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	if c.pages--; c.pages == 0 {
+		c.cancel()
+	}
+	return c.memContainer.Items(prefix, cursor, count)
+}
+
+func (s *DirectorySuite) TestCancel(c *C) {
+	mc := newMemContainer("cancel")
+	b := newBucket(ProviderConfig{PageSize: 2}, mc, nil, "mem://")
+	d, err := b.CreateDirectory(context.Background(), "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 10; i++ {
+		c.Assert(d.PutBytes(context.Background(), fmt.Sprintf("sub%d/obj", i), nil, nil), IsNil)
+		c.Assert(d.PutBytes(context.Background(), fmt.Sprintf("obj%d", i), nil, nil), IsNil)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = d.ListObjects(ctx)
+	c.Assert(err, Equals, context.Canceled)
+	_, err = d.ListDirectories(ctx)
+	c.Assert(err, Equals, context.Canceled)
+	_, err = d.ListObjectsRecursive(ctx)
+	c.Assert(err, Equals, context.Canceled)
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(deleted, Equals, 0)
+	c.Assert(mc.items, HasLen, 21)
+
+	// Walks stop at the page that is listed after the cancellation
+	ctx, cancel = context.WithCancel(context.Background())
+	cc := &cancelContainer{memContainer: mc, pages: 3, cancel: cancel}
+	b = newBucket(ProviderConfig{PageSize: 2}, cc, nil, "mem://")
+	d, err = b.GetDirectory(context.Background(), "dir")
+	c.Assert(err, IsNil)
+	deleted, err = d.DeleteDirectoryCount(ctx)
+	c.Assert(err, Equals, context.Canceled)
+	// Objects that were listed before may still be deleted
+	c.Assert(deleted <= 4, Equals, true)
+	c.Assert(mc.items, HasLen, 21-deleted)
+}
+
+func (s *DirectorySuite) TestSkipDirectoryMarkers(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("markers")
+	b := newBucket(ProviderConfig{SkipDirectoryMarkers: true}, mc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir/sub")
+	c.Assert(err, IsNil)
+	c.Assert(mc.items, HasLen, 0)
+	_, err = b.GetDirectory(ctx, "dir/sub")
+	c.Assert(err, NotNil)
+
+	// The directory exists once it has objects, with or without markers
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	for _, b := range []*bucket{b, newBucket(ProviderConfig{}, mc, nil, "mem://")} {
+		sub, err := b.GetDirectory(ctx, "dir/sub")
+		c.Assert(err, IsNil)
+		objs, err := sub.ListObjects(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(objs, DeepEquals, []string{"obj"})
+		dirs, err := b.ListDirectories(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(dirs, HasLen, 1)
+		c.Assert(dirs["dir"], NotNil)
+	}
+	c.Assert(mc.items, HasLen, 1)
+}
+
+// conflictContainer fails to create markers that exist, as providers fail
+// concurrent creations of an object, or that are listed in conflicts
+type conflictContainer struct {
+	*memContainer
+	conflicts map[string]bool
+}
+
+func (c *conflictContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	c.mu.Lock()
+	_, ok := c.items[name]
+	c.mu.Unlock()
+	if strings.HasSuffix(name, "/") && (ok || c.conflicts[name]) {
+		return nil, awserr.NewRequestFailure(awserr.New("OperationAborted", "A conflicting conditional operation is currently in progress", nil), 409, "id")
+	}
+	return c.memContainer.Put(name, r, size, metadata)
+}
+
+func (s *DirectorySuite) TestCreateDirectoryConflicts(c *C) {
+	defer newLeakChecker().check(c)
+	ctx := context.Background()
+	cc := &conflictContainer{memContainer: newMemContainer("conflicts"), conflicts: map[string]bool{"missing/": true}}
+	b := newBucket(ProviderConfig{}, cc, nil, "mem://")
+	paths := []string{"a", "a/b", "a/b/c", "a/b/d", "a/e"}
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p := paths[i%len(paths)]
+			var d Directory
+			var err error
+			if i%2 == 0 {
+				d, err = b.CreateDirectoryAll(ctx, p)
+			} else {
+				d, err = b.CreateDirectory(ctx, p)
+			}
+			if err == nil {
+				// The directory is usable immediately
+				err = d.PutBytes(ctx, fmt.Sprintf("obj%d", i), nil, nil)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+	for _, m := range []string{"a/", "a/b/", "a/b/c/", "a/b/d/", "a/e/"} {
+		c.Assert(cc.items[m], NotNil, Commentf(m))
+	}
+
+	// Conflicts are errors if the directory does not exist afterwards
+	_, err := b.CreateDirectory(ctx, "missing")
+	c.Assert(isConflictError(err), Equals, true)
+	_, err = b.CreateDirectoryAll(ctx, "missing/x")
+	c.Assert(isConflictError(err), Equals, true)
+}
+
+func (s *DirectorySuite) TestCreateDirectoryAll(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("mkdir")
+	d, err := b.CreateDirectory(ctx, "root")
+	c.Assert(err, IsNil)
+	sub, err := d.CreateDirectoryAll(ctx, "a/b/c")
+	c.Assert(err, IsNil)
+	c.Assert(sub.String(), Equals, "mem:/mkdir/root/a/b/c/")
+	var names []string
+	for n := range mc.items {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	c.Assert(names, DeepEquals, []string{"root/", "root/a/", "root/a/b/", "root/a/b/c/"})
+
+	// Absolute paths are in the root of the bucket, outside of d
+	_, err = d.CreateDirectoryAll(ctx, "/x/y")
+	c.Assert(IsInvalidName(err), Equals, true)
+	c.Assert(mc.items["x/"], IsNil)
+	_, err = b.CreateDirectoryAll(ctx, "/x/y")
+	c.Assert(err, IsNil)
+	c.Assert(mc.items["x/"], NotNil)
+	c.Assert(mc.items["x/y/"], NotNil)
+}
+
+// itemErrContainer fails to get items
+type itemErrContainer struct {
+	*memContainer
+}
+
+func (c *itemErrContainer) Item(id string) (stow.Item, error) {
+	return nil, errors.New("403 Forbidden")
+}
+
+func (s *DirectorySuite) TestExists(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("exists")
+	b := newBucket(ProviderConfig{}, mc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+
+	for name, exists := range map[string]bool{
+		"obj":      true,
+		"/dir/obj": true,
+		"missing":  false,
+	} {
+		ok, err := d.Exists(ctx, name)
+		c.Assert(err, IsNil)
+		c.Check(ok, Equals, exists, Commentf("%s", name))
+	}
+	_, err = d.Exists(ctx, "/obj")
+	c.Assert(IsInvalidName(err), Equals, true)
+
+	b = newBucket(ProviderConfig{}, &itemErrContainer{mc}, nil, "mem://")
+	ok, err := b.Exists(ctx, "dir/obj")
+	c.Assert(err, ErrorMatches, "could not get object /dir/obj: access denied: 403 Forbidden")
+	c.Assert(IsAccessDenied(err), Equals, true)
+	c.Assert(ok, Equals, false)
+}
+
+// slowContainer removes items with a delay, and records the number of
+// concurrent removals
+type slowContainer struct {
+	*memContainer
+	delay    time.Duration
+	mu       sync.Mutex
+	inFlight int
+	max      int
+}
+
+func (c *slowContainer) RemoveItem(id string) error {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.max {
+		c.max = c.inFlight
+	}
+	c.mu.Unlock()
+	time.Sleep(c.delay)
+	c.mu.Lock()
+	c.inFlight--
+	c.mu.Unlock()
+	return c.memContainer.RemoveItem(id)
+}
+
+func (s *DirectorySuite) TestDeleteDirectoryConcurrency(c *C) {
+	ctx := context.Background()
+	const n = 64
+	elapsed := make(map[int]time.Duration)
+	for _, workers := range []int{1, 4, deleteConcurrency} {
+		sc := &slowContainer{memContainer: newMemContainer("slow"), delay: 5 * time.Millisecond}
+		b := newBucket(ProviderConfig{DeleteConcurrency: workers}, sc, nil, "mem://")
+		d, err := b.CreateDirectory(ctx, "dir")
+		c.Assert(err, IsNil)
+		for i := 0; i < n-1; i++ {
+			c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%02d", i), nil, nil), IsNil)
+		}
+		start := time.Now()
+		deleted, err := d.DeleteDirectoryCount(ctx)
+		elapsed[workers] = time.Since(start)
+		c.Assert(err, IsNil)
+		c.Assert(deleted, Equals, n)
+		c.Assert(sc.items, HasLen, 0)
+		c.Assert(sc.max <= workers, Equals, true)
+		c.Logf("Deleted %d objects with %d workers in %s", n, workers, elapsed[workers])
+	}
+	c.Assert(elapsed[deleteConcurrency] < elapsed[1]/4, Equals, true)
+}
+
+// itemCountingContainer counts the items that are read one by one
+type itemCountingContainer struct {
+	*memContainer
+	items int
+}
+
+func (c *itemCountingContainer) Item(id string) (stow.Item, error) {
+	c.items++
+	return c.memContainer.Item(id)
+}
+
+func (s *DirectorySuite) TestListObjectsInfo(c *C) {
+	ctx := context.Background()
+	ic := &itemCountingContainer{memContainer: newMemContainer("info")}
+	b := newBucket(ProviderConfig{PageSize: 2}, ic, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%d", i), bytes.Repeat([]byte("a"), i), nil), IsNil)
+	}
+	c.Assert(d.PutBytes(ctx, "sub/obj", []byte("data"), nil), IsNil)
+
+	ic.items = 0
+	infos, err := d.ListObjectsInfo(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(ic.items, Equals, 0)
+	names, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, len(names))
+	for i, info := range infos {
+		c.Assert(info.Name, Equals, names[i])
+		stat, err := d.Stat(ctx, info.Name)
+		c.Assert(err, IsNil)
+		// Listings do not read the tags of objects
+		stat.Tags, stat.OriginalSize = nil, -1
+		c.Assert(info, DeepEquals, stat)
+	}
+	c.Assert(infos[3].Size, Equals, int64(3))
+
+	_, err = b.ListObjectsInfo(ctx)
+	c.Assert(err, IsNil)
+	empty, err := d.CreateDirectory(ctx, "empty")
+	c.Assert(err, IsNil)
+	infos, err = empty.ListObjectsInfo(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(infos, HasLen, 0)
+}
+
+func (s *DirectorySuite) TestListObjectsModifiedSince(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("since")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	since := time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)
+	for name, lastMod := range map[string]time.Time{
+		"old":     since.Add(-time.Hour),
+		"same":    since,
+		"new":     since.Add(time.Second),
+		"newer":   since.Add(time.Hour),
+		"unknown": {},
+	} {
+		c.Assert(d.PutBytes(ctx, name, []byte("data"), nil), IsNil)
+		mc.items["dir/"+name].lastMod = lastMod
+	}
+	c.Assert(d.PutBytes(ctx, "sub/obj", []byte("data"), nil), IsNil)
+
+	names, err := d.ListObjectsModifiedSince(ctx, since)
+	c.Assert(err, IsNil)
+	// Objects without a modification time may have changed
+	c.Assert(names, DeepEquals, []string{"new", "newer", "unknown"})
+	names, err = d.ListObjectsModifiedSince(ctx, since.Add(time.Hour))
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"unknown"})
+}
+
+func (s *DirectorySuite) TestStat(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("stat")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), map[string]string{"kanister.io/tag": "value"}), IsNil)
+	// Tags that are not strings are formatted
+	mc.items["dir/obj"].metadata["count"] = 1
+
+	info, err := d.Stat(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(info.Name, Equals, "obj")
+	c.Assert(info.Size, Equals, int64(4))
+	c.Assert(info.Tags, DeepEquals, map[string]string{"kanister.io/tag": "value", "count": "1"})
+	c.Assert(info.LastModified, Equals, mc.items["dir/obj"].lastMod)
+	c.Assert(info.ETag, Equals, fmt.Sprintf("%x", md5.Sum([]byte("data"))))
+	_, getTags, err := d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(getTags, DeepEquals, info.Tags)
+
+	_, err = d.Stat(ctx, "missing")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object /dir/missing not found")
+	c.Assert(IsObjectNotFound(stow.ErrNotFound), Equals, true)
+	c.Assert(IsObjectNotFound(awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), 403, "id")), Equals, false)
+}
+
+// syntheticContainer lists a large number of generated items, counts the
+// pages that were listed and cancels a context when the first page is
+// listed
+type syntheticContainer struct {
+	*memContainer
+	n      int
+	pages  int
+	cancel context.CancelFunc
+}
+
+func (c *syntheticContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	c.pages++
+	c.cancel()
+	start := 0
+	if cursor != stow.CursorStart {
+		start, _ = strconv.Atoi(cursor)
+	}
+	var items []stow.Item
+	for i := start; i < c.n && len(items) < count; i++ {
+		items = append(items, &memItem{name: fmt.Sprintf("%sobj%07d", prefix, i)})
+	}
+	if start+count >= c.n {
+		return items, "", nil
+	}
+	return items, strconv.Itoa(start + count), nil
+}
+
+func (s *DirectorySuite) TestCancelLargeListing(c *C) {
+	sc := &syntheticContainer{memContainer: newMemContainer("large"), n: 1000000}
+	b := newBucket(ProviderConfig{PageSize: 1000}, sc, nil, "mem://")
+	for _, op := range []func(context.Context) error{
+		func(ctx context.Context) error { _, err := b.ListObjects(ctx); return err },
+		func(ctx context.Context) error { _, err := b.ListObjectsRecursive(ctx); return err },
+		func(ctx context.Context) error { _, err := b.ListDirectories(ctx); return err },
+		func(ctx context.Context) error { err := b.DeleteDirectory(ctx); return err },
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		sc.pages, sc.cancel = 0, cancel
+		c.Assert(op(ctx), Equals, context.Canceled)
+		// The walk stops within the first page
+		c.Assert(sc.pages, Equals, 1)
+	}
+}
+
+// blockingItem is an item whose data never arrives
+type blockingItem struct {
+	*memItem
+	r *io.PipeReader
+}
+
+func (i *blockingItem) Open() (io.ReadCloser, error) {
+	return i.r, nil
+}
+
+type blockingContainer struct {
+	*memContainer
+	item *blockingItem
+}
+
+func (c *blockingContainer) Item(id string) (stow.Item, error) {
+	return c.item, nil
+}
+
+func (s *DirectorySuite) TestCancelTransfer(c *C) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	bc := &blockingContainer{
+		memContainer: newMemContainer("transfer"),
+		item:         &blockingItem{memItem: &memItem{name: "obj"}, r: pr},
+	}
+	b := newBucket(ProviderConfig{}, bc, nil, "mem://")
+
+	// Cancelling the context interrupts a read in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	r, _, err := b.Get(ctx, "obj")
+	c.Assert(err, IsNil)
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, Equals, context.Canceled)
+	c.Assert(r.Close(), IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	// Uploads stop reading once the context is cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	data := &cancelReader{r: bytes.NewReader(make([]byte, 100)), cancel: cancel}
+	err = b.Put(ctx, "put", data, 100, nil)
+	c.Assert(errors.Cause(err), Equals, context.Canceled)
+	c.Assert(bc.items, HasLen, 0)
+}
+
+// cancelReader cancels a context after the first read
+type cancelReader struct {
+	r      io.Reader
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	defer r.cancel()
+	if len(p) > 10 {
+		p = p[:10]
+	}
+	return r.r.Read(p)
+}
+
+func (s *DirectorySuite) TestNonStringTags(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("tags")
+	_, err := mc.Put("obj", bytes.NewReader(nil), 0, map[string]interface{}{
+		"content-length": 42,
+		"archived":       true,
+		"ratio":          0.5,
+		"name":           "value",
+		"unset":          nil,
+	})
+	c.Assert(err, IsNil)
+	_, tags, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{
+		"content-length": "42",
+		"archived":       "true",
+		"ratio":          "0.5",
+		"name":           "value",
+	})
+}
+
+func (s *DirectorySuite) TestGetRange(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("range")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("0123456789"), nil), IsNil)
+
+	for _, tc := range []struct {
+		offset, length int64
+		expected       string
+	}{
+		{offset: 0, length: -1, expected: "0123456789"},
+		{offset: 6, length: -1, expected: "6789"},
+		{offset: 2, length: 3, expected: "234"},
+		{offset: 8, length: 5, expected: "89"},
+		{offset: 4, length: 0, expected: ""},
+		{offset: 10, length: -1, expected: ""},
+	} {
+		r, size, err := d.GetRange(ctx, "obj", tc.offset, tc.length)
+		c.Assert(err, IsNil, Commentf("%+v", tc))
+		c.Assert(size, Equals, int64(10))
+		data, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		c.Check(string(data), Equals, tc.expected, Commentf("%+v", tc))
+	}
+
+	// Footer of the object
+	data, size, err := d.GetBytesRange(ctx, "obj", 6, -1)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(10))
+	c.Assert(string(data), Equals, "6789")
+
+	_, size, err = d.GetRange(ctx, "obj", 20, 5)
+	c.Assert(IsRangeNotSatisfiable(err), Equals, true)
+	c.Assert(err, ErrorMatches, "offset 20 is past the end of object dir/obj of 10 bytes")
+	c.Assert(size, Equals, int64(10))
+	_, _, err = d.GetRange(ctx, "obj", -1, 2)
+	c.Assert(err, ErrorMatches, "invalid offset -1")
+	_, _, err = d.GetBytesRange(ctx, "missing", 0, 2)
+	c.Assert(err, ErrorMatches, "object /dir/missing not found")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+}
+
+func (s *DirectorySuite) TestGetBytesLimit(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("limit")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("0123456789"), map[string]string{"key": "value"}), IsNil)
+
+	data, tags, err := d.GetBytesLimit(ctx, "obj", 10)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0123456789")
+	c.Assert(tags, DeepEquals, map[string]string{"key": "value"})
+	_, _, err = d.GetBytesLimit(ctx, "obj", 9)
+	c.Assert(IsObjectTooLarge(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object /dir/obj exceeds the limit of 9 bytes")
+	_, _, err = d.GetBytesLimit(ctx, "obj", math.MaxInt64)
+	c.Assert(err, IsNil)
+	_, _, err = d.GetBytesLimit(ctx, "obj", -1)
+	c.Assert(err, ErrorMatches, "invalid limit -1")
+
+	// GetBytes is limited by the bucket
+	c.Assert(b.getBytesLimit, Equals, int64(getBytesLimit))
+	b.getBytesLimit = 9
+	_, _, err = d.GetBytes(ctx, "obj")
+	c.Assert(IsObjectTooLarge(err), Equals, true)
+}
+
+func (s *DirectorySuite) TestZeroLengthObjects(c *C) {
+	ctx := context.Background()
+	tags := map[string]string{"key": "value"}
+	b, m := newMemMultipartBucket("empty")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "empty", nil, tags), IsNil)
+	// Content types are set by multipart uploads
+	err = d.PutOpts(ctx, "typed", bytes.NewReader(nil), 0, tags, WithContentType("text/plain"), WithVerification())
+	c.Assert(err, IsNil)
+	c.Assert(m.contentTypes["dir/typed"], Equals, "text/plain")
+	err = d.PutOpts(ctx, "unknown", bytes.NewReader(nil), -1, tags, WithVerification())
+	c.Assert(err, IsNil)
+
+	for _, name := range []string{"empty", "typed", "unknown"} {
+		comment := Commentf(name)
+		data, rTags, err := d.GetBytes(ctx, name)
+		c.Assert(err, IsNil, comment)
+		c.Assert(data, HasLen, 0, comment)
+		c.Assert(rTags, DeepEquals, tags, comment)
+		info, err := d.Stat(ctx, name)
+		c.Assert(err, IsNil, comment)
+		c.Assert(info.Size, Equals, int64(0), comment)
+		c.Assert(info.Tags, DeepEquals, tags, comment)
+
+		r, _, err := d.GetOpts(ctx, name, WithVerification())
+		c.Assert(err, IsNil, comment)
+		_, err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil, comment)
+		c.Assert(r.Close(), IsNil, comment)
+		r, _, err = d.GetSpooled(ctx, name, SpoolOptions{Dir: c.MkDir()})
+		c.Assert(err, IsNil, comment)
+		c.Assert(r.Close(), IsNil, comment)
+
+		data, size, err := d.GetBytesRange(ctx, name, 0, -1)
+		c.Assert(err, IsNil, comment)
+		c.Assert(data, HasLen, 0, comment)
+		c.Assert(size, Equals, int64(0), comment)
+		_, _, err = d.GetBytesRange(ctx, name, 1, -1)
+		c.Assert(IsRangeNotSatisfiable(err), Equals, true, comment)
+
+		c.Assert(d.Copy(ctx, name, d, "copy-"+name), IsNil, comment)
+		data, rTags, err = d.GetBytes(ctx, "copy-"+name)
+		c.Assert(err, IsNil, comment)
+		c.Assert(data, HasLen, 0, comment)
+		c.Assert(rTags, DeepEquals, tags, comment)
+	}
+
+	// Empty files are listed, unlike empty placeholders of directories
+	objs, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, HasLen, 6)
+}
+
+func (s *DirectorySuite) TestNameNormalization(c *C) {
+	d := &directory{path: "/dir/"}
+	c.Check(d.absPathName(""), Equals, "")
+	for _, tc := range []struct {
+		name string
+		path string
+		dir  string
+	}{
+		{name: "obj", path: "/dir/obj", dir: "/dir/obj/"},
+		{name: "a//b", path: "/dir/a/b", dir: "/dir/a/b/"},
+		{name: "a/b/", path: "/dir/a/b/", dir: "/dir/a/b/"},
+		{name: "a//b//", path: "/dir/a/b/", dir: "/dir/a/b/"},
+		{name: "./a/./b", path: "/dir/a/b", dir: "/dir/a/b/"},
+		{name: "/obj", path: "/obj", dir: "/obj/"},
+		{name: "//obj", path: "/obj", dir: "/obj/"},
+		{name: "/a//b/", path: "/a/b/", dir: "/a/b/"},
+		{name: "/", path: "/", dir: "/"},
+		{name: "//", path: "/", dir: "/"},
+	} {
+		c.Check(d.absPathName(tc.name), Equals, tc.path, Commentf("%s", tc.name))
+		c.Check(d.absDirName(tc.name), Equals, tc.dir, Commentf("%s", tc.name))
+	}
+
+	for _, tc := range []struct {
+		name  string
+		cloud string
+	}{
+		{name: "/", cloud: ""},
+		{name: "/dir/obj", cloud: "dir/obj"},
+		{name: "//dir/obj", cloud: "dir/obj"},
+		{name: "dir/", cloud: "dir/"},
+	} {
+		c.Check(cloudName(tc.name), Equals, tc.cloud, Commentf("%s", tc.name))
+	}
+}
+
+func (s *DirectorySuite) TestNamesAreOSIndependent(c *C) {
+	d := &directory{path: "/dir/"}
+	// Backslashes and drive letters are part of names on every OS, e.g. on
+	// Windows clients, where filepath would treat them as separators and
+	// absolute paths
+	for _, tc := range []struct {
+		name string
+		path string
+	}{
+		{name: `a\b`, path: `/dir/a\b`},
+		{name: `\obj`, path: `/dir/\obj`},
+		{name: `C:\obj`, path: `/dir/C:\obj`},
+		{name: `C:/obj`, path: `/dir/C:/obj`},
+		{name: `a\..\b`, path: `/dir/a\..\b`},
+	} {
+		c.Check(d.absPathName(tc.name), Equals, tc.path, Commentf("%s", tc.name))
+		c.Check(d.absDirName(tc.name), Equals, tc.path+"/", Commentf("%s", tc.name))
+	}
+}
+
+func (s *DirectorySuite) TestNoPhantomKeys(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("phantom")
+	d, err := b.CreateDirectory(ctx, "dir/")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "/dir//obj", []byte("abc"), nil), IsNil)
+	data, _, err := d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abc")
+	for name := range mc.items {
+		c.Assert(strings.Contains(name, "//"), Equals, false, Commentf("%s", name))
+	}
+}
+
+func (s *DirectorySuite) TestInvalidNames(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("names")
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+	c.Assert(b.PutBytes(ctx, "other-backup/manifest", []byte("other"), nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "backupx", []byte("other"), nil), IsNil)
+
+	for _, name := range []string{
+		"../other-backup/manifest",
+		"a/../../other-backup/manifest",
+		"a/./b/../../../other-backup/manifest",
+		"/other-backup/manifest",
+		"/backupx",
+		"..",
+		".",
+	} {
+		comment := Commentf("%s", name)
+		err := d.PutBytes(ctx, name, []byte("data"), nil)
+		c.Check(IsInvalidName(err), Equals, true, comment)
+		_, _, err = d.GetBytes(ctx, name)
+		c.Check(IsInvalidName(err), Equals, true, comment)
+		c.Check(IsInvalidName(d.Delete(ctx, name)), Equals, true, comment)
+		if name != "." {
+			_, err = d.CreateDirectory(ctx, name)
+			c.Check(IsInvalidName(err), Equals, true, comment)
+			_, err = d.GetDirectory(ctx, name)
+			c.Check(IsInvalidName(err), Equals, true, comment)
+		}
+	}
+	_, err = d.GetDirectory(ctx, "../other-backup")
+	c.Assert(err, ErrorMatches, "name ../other-backup resolves outside of directory /backup/")
+	data, _, err := b.GetBytes(ctx, "other-backup/manifest")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "other")
+	_, ok := mc.items["backupx"]
+	c.Assert(ok, Equals, true)
+
+	// Names that stay in the directory are normalized
+	c.Assert(d.PutBytes(ctx, "a/../b//c/./obj", []byte("data"), nil), IsNil)
+	data, _, err = d.GetBytes(ctx, "/backup/b/c/obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+	sub, err := d.CreateDirectory(ctx, "./b/../b/")
+	c.Assert(err, IsNil)
+	c.Assert(sub.String(), Equals, "mem:/names/backup/b/")
+	sub, err = d.GetDirectory(ctx, ".")
+	c.Assert(err, IsNil)
+	c.Assert(sub.String(), Equals, "mem:/names/backup/")
+	c.Assert(d.Delete(ctx, "b/c/../c/obj"), IsNil)
+	_, ok = mc.items["backup/b/c/obj"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *DirectorySuite) TestTagKeysRoundTrip(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("tags")
+	tags := map[string]string{
+		"kanister.io/owner": "a",
+		"kanister.io-owner": "b",
+		"100%":              "c",
+		"a%2Fb":             "d",
+		"a/b%/c-d":          "e",
+	}
+	c.Assert(b.PutBytes(ctx, "obj", nil, tags), IsNil)
+	_, got, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, tags)
+	info, err := b.Stat(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(info.Tags, DeepEquals, tags)
+	for key := range mc.items["obj"].metadata {
+		c.Assert(strings.Contains(key, "/"), Equals, false, Commentf("%s", key))
+	}
+
+	// Tags written before the encoding are read as they are, and the
+	// lowercase escapes of S3 are decoded
+	_, err = mc.Put("old", bytes.NewReader(nil), 0, map[string]interface{}{"kanister.io-owner": "a", "kanister.io%2fstatus": "done"})
+	c.Assert(err, IsNil)
+	_, got, err = b.GetBytes(ctx, "old")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, map[string]string{"kanister.io-owner": "a", "kanister.io/status": "done"})
+}
+
+func (s *DirectorySuite) TestTagValuesRoundTrip(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("tags")
+	tags := map[string]string{
+		"app/name":       "backup",
+		"app-name":       "",
+		"clé":            "été",
+		"description":    "line 1\nline 2",
+		"encoded":        "=?utf-8?b?YQ==?=",
+		"kanister.io/日本": "バックアップ",
+		"with space":     "100% done",
+	}
+	c.Assert(b.PutBytes(ctx, "obj", nil, tags), IsNil)
+	_, got, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, tags)
+	info, err := b.Stat(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(info.Tags, DeepEquals, tags)
+
+	// Only printable ASCII is written, and ASCII values as they are
+	md := mc.items["obj"].metadata
+	c.Assert(md["app%2Fname"], Equals, "backup")
+	c.Assert(md["with%20space"], Equals, "100% done")
+	for key, val := range md {
+		for _, s := range []string{key, val.(string)} {
+			for i := 0; i < len(s); i++ {
+				c.Assert(s[i] >= ' ' && s[i] <= '~', Equals, true, Commentf("%q: %q", key, val))
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package objectstore
+
+// Emptiness and sizes of directories
+
+import (
+	"context"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// DirectoryStats counts the objects of a directory and of its sub
+// directories. Directory markers are not counted.
+type DirectoryStats struct {
+	Objects int64
+	Bytes   int64
+}
+
+// IsEmpty returns true if neither d nor its sub directories have objects
+// other than directory markers. It stops listing at the first object.
+func (d *directory) IsEmpty(ctx context.Context) (bool, error) {
+	if d.path == "" {
+		return false, errors.New("invalid entry")
+	}
+
+	prefix := cloudName(d.path)
+	cursor := stow.CursorStart
+	for {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		items, next, err := d.bucket.container.Items(prefix, cursor, d.bucket.pageSize)
+		if err != nil {
+			return false, providerError(d.bucket, err)
+		}
+		for _, item := range items {
+			if !isMarkerName(item.Name()) {
+				return false, nil
+			}
+		}
+		if stow.IsCursorEnd(next) {
+			return true, nil
+		}
+		cursor = next
+	}
+}
+
+// Stats counts the objects of d and of its sub directories, and their
+// bytes, with a single walk
+func (d *directory) Stats(ctx context.Context) (DirectoryStats, error) {
+	if d.path == "" {
+		return DirectoryStats{}, errors.New("invalid entry")
+	}
+
+	var stats DirectoryStats
+	err := stow.Walk(d.bucket.container, cloudName(d.path), d.bucket.pageSize,
+		func(item stow.Item, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if isMarkerName(item.Name()) {
+				return nil
+			}
+			size, err := item.Size()
+			if err != nil {
+				return err
+			}
+			stats.Objects++
+			stats.Bytes += size
+			return nil
+		})
+	if err != nil {
+		return DirectoryStats{}, providerError(d.bucket, err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,72 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type DirStatsSuite struct{}
+
+var _ = Suite(&DirStatsSuite{})
+
+func (s *DirStatsSuite) TestIsEmpty(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("dirstats")
+	pc := &pageSizeContainer{memContainer: mc, counts: make(map[int]int)}
+	b.container = pc
+	b.pageSize = 2
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		_, err = d.CreateDirectory(ctx, fmt.Sprintf("dir%d", i))
+		c.Assert(err, IsNil)
+	}
+	c.Assert(b.PutBytes(ctx, "backup_$folder$", nil, nil), IsNil)
+
+	// Markers do not count
+	empty, err := d.IsEmpty(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(empty, Equals, true)
+
+	// Listing stops at the page of the first object
+	for i := 0; i < 10; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("dir0/obj%d", i), []byte("data"), nil), IsNil)
+	}
+	pc.counts = make(map[int]int)
+	empty, err = d.IsEmpty(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(empty, Equals, false)
+	c.Assert(pc.counts, DeepEquals, map[int]int{2: 2})
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = d.IsEmpty(cctx)
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *DirStatsSuite) TestStats(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("dirstats")
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+	stats, err := d.Stats(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, DirectoryStats{})
+
+	sub, err := d.CreateDirectory(ctx, "sub")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	c.Assert(sub.PutBytes(ctx, "obj", []byte("more data"), nil), IsNil)
+	c.Assert(sub.PutBytes(ctx, "empty", nil, nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "other", []byte("data"), nil), IsNil)
+	stats, err = d.Stats(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, DirectoryStats{Objects: 3, Bytes: 13})
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = d.Stats(cctx)
+	c.Assert(err, Equals, context.Canceled)
+}
@@ -0,0 +1,128 @@
+package objectstore
+
+// Tags of directories, held by their markers
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const defaultListTagsConcurrency = 8
+
+// ListDirectoriesOptions describes how ListDirectoriesWithOptions lists
+// directories
+type ListDirectoriesOptions struct {
+	// Tags fetches the tags of the directories along with them
+	Tags bool
+	// Concurrency bounds the number of directories whose tags are fetched
+	// at once. Defaults to 8.
+	Concurrency int
+}
+
+// DirectoryEntry is a directory listed by ListDirectoriesWithOptions
+type DirectoryEntry struct {
+	Directory Directory
+	// Tags of the directory, if they were requested
+	Tags map[string]string
+}
+
+// GetDirectoryTags returns the tags of the marker of d. Directories without
+// a marker have no tags.
+func (d *directory) GetDirectoryTags(ctx context.Context) (map[string]string, error) {
+	if d.path == "" || d.path == "/" {
+		return nil, errors.New("invalid entry")
+	}
+	item, err := d.item(ctx, d.path)
+	switch {
+	case IsObjectNotFound(err):
+		return map[string]string{}, nil
+	case err != nil:
+		return nil, err
+	}
+	tags, err := item.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	return d.bucket.itemTags(tags), nil
+}
+
+// SetDirectoryTags sets the tags of the marker of d, creating the marker if
+// d does not have one. The tags are added to the current tags if merge is
+// true, and replace them otherwise. Tag keys are encoded like those of
+// objects.
+func (d *directory) SetDirectoryTags(ctx context.Context, tags map[string]string, merge bool) error {
+	if d.path == "" || d.path == "/" {
+		return errors.New("invalid entry")
+	}
+	if merge {
+		current, err := d.GetDirectoryTags(ctx)
+		if err != nil {
+			return err
+		}
+		for k, v := range tags {
+			current[k] = v
+		}
+		tags = current
+	}
+	return d.PutBytes(ctx, d.path, nil, tags)
+}
+
+// ListDirectoriesWithOptions lists the directories like ListDirectories,
+// and fetches their tags as described by opts
+func (d *directory) ListDirectoriesWithOptions(ctx context.Context, opts ListDirectoriesOptions) (map[string]DirectoryEntry, error) {
+	dirs, err := d.ListDirectories(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]DirectoryEntry, len(dirs))
+	for name, dir := range dirs {
+		entries[name] = DirectoryEntry{Directory: dir}
+	}
+	if !opts.Tags {
+		return entries, nil
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultListTagsConcurrency
+	}
+	var mu sync.Mutex
+	errs := newBatchError()
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				tags, err := dirs[name].(*directory).GetDirectoryTags(ctx)
+				mu.Lock()
+				if err != nil {
+					errs.add(d.bucket.redaction.Redact(d.absDirName(name)), "read the tags of", err)
+				} else {
+					entries[name] = DirectoryEntry{Directory: dirs[name], Tags: tags}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+send:
+	for name := range dirs {
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := errs.errOrNil(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,103 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type DirTagsSuite struct{}
+
+var _ = Suite(&DirTagsSuite{})
+
+func (s *DirTagsSuite) TestDirectoryTags(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("dirtags")
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+
+	tags, err := d.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{})
+
+	// Keys are encoded like the keys of tags of objects
+	c.Assert(d.SetDirectoryTags(ctx, map[string]string{"kanister.io/status": "running", "blueprint": "v1"}, false), IsNil)
+	c.Assert(d.SetDirectoryTags(ctx, map[string]string{"kanister.io/status": "complete", "created": "2019-01-01"}, true), IsNil)
+	tags, err = d.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"kanister.io/status": "complete", "blueprint": "v1", "created": "2019-01-01"})
+	c.Assert(mc.items["backup/"].data, HasLen, 0)
+
+	// Without merge, the tags are replaced
+	c.Assert(d.SetDirectoryTags(ctx, map[string]string{"blueprint": "v2"}, false), IsNil)
+	tags, err = d.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"blueprint": "v2"})
+
+	// Objects of the directory are not affected
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), map[string]string{"type": "data"}), IsNil)
+	c.Assert(d.SetDirectoryTags(ctx, map[string]string{"status": "complete"}, true), IsNil)
+	_, objTags, err := d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(objTags, DeepEquals, map[string]string{"type": "data"})
+
+	_, err = b.GetDirectoryTags(ctx)
+	c.Assert(err, ErrorMatches, "invalid entry")
+	c.Assert(b.SetDirectoryTags(ctx, nil, false), ErrorMatches, "invalid entry")
+}
+
+func (s *DirTagsSuite) TestImplicitDirectory(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("dirtags")
+	c.Assert(b.PutBytes(ctx, "implicit/obj", []byte("data"), nil), IsNil)
+	d, err := b.GetDirectory(ctx, "implicit")
+	c.Assert(err, IsNil)
+
+	tags, err := d.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, HasLen, 0)
+	c.Assert(mc.items["implicit/"], IsNil)
+	c.Assert(d.SetDirectoryTags(ctx, map[string]string{"status": "complete"}, true), IsNil)
+	c.Assert(mc.items["implicit/"], NotNil)
+	tags, err = d.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"status": "complete"})
+	objs, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"obj"})
+}
+
+func (s *DirTagsSuite) TestListDirectoriesWithTags(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("dirtags")
+	backups, err := b.CreateDirectory(ctx, "backups")
+	c.Assert(err, IsNil)
+	for i := 0; i < 20; i++ {
+		d, err := backups.CreateDirectory(ctx, fmt.Sprintf("backup%d", i))
+		c.Assert(err, IsNil)
+		c.Assert(d.SetDirectoryTags(ctx, map[string]string{"status": fmt.Sprint(i%2 == 0)}, false), IsNil)
+	}
+	c.Assert(backups.PutBytes(ctx, "implicit/obj", []byte("data"), nil), IsNil)
+
+	entries, err := backups.ListDirectoriesWithOptions(ctx, ListDirectoriesOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 21)
+	c.Assert(entries["backup0"].Directory, NotNil)
+	c.Assert(entries["backup0"].Tags, IsNil)
+
+	entries, err = backups.ListDirectoriesWithOptions(ctx, ListDirectoriesOptions{Tags: true, Concurrency: 3})
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 21)
+	for i := 0; i < 20; i++ {
+		e := entries[fmt.Sprintf("backup%d", i)]
+		c.Assert(e.Tags, DeepEquals, map[string]string{"status": fmt.Sprint(i%2 == 0)})
+		c.Assert(e.Directory.String(), Equals, fmt.Sprintf("mem:/dirtags/backups/backup%d/", i))
+	}
+	c.Assert(entries["implicit"].Tags, DeepEquals, map[string]string{})
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = backups.ListDirectoriesWithOptions(cctx, ListDirectoriesOptions{Tags: true})
+	c.Assert(err, Equals, context.Canceled)
+}
@@ -0,0 +1,378 @@
+package objectstore
+
+// Encryption of the data of objects on the client, for providers that are
+// not trusted with it. Objects are encrypted by Put with the current key of
+// the keyring of the bucket, after compression, and decrypted by Get with
+// the key recorded in their tags.
+//
+// The data is split in chunks of encryptionChunkSize bytes, each sealed
+// with AES-256-GCM. The nonce of a chunk is the random nonce prefix of the
+// object, the index of the chunk, and whether it is the last chunk, so that
+// chunks cannot be reordered, dropped or truncated without failing
+// authentication.
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// EncryptionTag is the tag that records the format with which the data
+	// of an object is encrypted
+	EncryptionTag = "kanister-encryption"
+	// EncryptionKeyTag is the tag that records the ID of the key with which
+	// the data of an object is encrypted
+	EncryptionKeyTag = "kanister-encryption-key"
+	// EncryptionNonceTag is the tag that records the hex nonce prefix of the
+	// chunks of an object
+	EncryptionNonceTag = "kanister-encryption-nonce"
+	// EncryptionAESGCM is the format of data encrypted in chunks with
+	// AES-256-GCM
+	EncryptionAESGCM = "aes-256-gcm-v1"
+	// EncryptionKeySize is the size of keys in bytes
+	EncryptionKeySize = 32
+)
+
+const (
+	encryptionChunkSize = 64 * 1024
+	encryptionOverhead  = 16
+	// The nonce of a chunk is the prefix, a 4 byte index and a last byte
+	// that is 1 for the last chunk
+	encryptionPrefixSize = 7
+)
+
+// ErrDecryptionFailed is returned by reads of encrypted objects that cannot
+// be decrypted, e.g. because their key is not in the keyring or their data
+// was modified
+type ErrDecryptionFailed struct {
+	Name   string
+	Reason string
+}
+
+func (e *ErrDecryptionFailed) Error() string {
+	return fmt.Sprintf("failed to decrypt object %s: %s", e.Name, e.Reason)
+}
+
+// IsDecryptionFailed returns true if the cause of err is an
+// ErrDecryptionFailed
+func IsDecryptionFailed(err error) bool {
+	_, ok := errors.Cause(err).(*ErrDecryptionFailed)
+	return ok
+}
+
+// ErrObjectEncrypted is returned by operations that do not support
+// encrypted objects, such as ranged reads
+type ErrObjectEncrypted struct {
+	Name      string
+	Operation string
+}
+
+func (e *ErrObjectEncrypted) Error() string {
+	return fmt.Sprintf("%s is not supported on encrypted object %s", e.Operation, e.Name)
+}
+
+// IsObjectEncrypted returns true if the cause of err is an
+// ErrObjectEncrypted
+func IsObjectEncrypted(err error) bool {
+	_, ok := errors.Cause(err).(*ErrObjectEncrypted)
+	return ok
+}
+
+// keyIDRe matches the IDs of keys, which are recorded in tags
+var keyIDRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Keyring holds the keys with which objects are encrypted and decrypted, by
+// ID. Objects are encrypted with the current key, and decrypted with the
+// key whose ID is recorded in their EncryptionKeyTag, so that keys can be
+// rotated by making a new key current and keeping the others.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring returns a keyring of keys by ID, which are EncryptionKeySize
+// bytes long, with the current key currentID. IDs are made of letters,
+// digits, '.', '_' and '-'.
+func NewKeyring(currentID string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[currentID]; !ok {
+		return nil, errors.Errorf("current key %q is not in the keyring", currentID)
+	}
+	k := &Keyring{current: currentID, keys: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if !keyIDRe.MatchString(id) {
+			return nil, errors.Errorf("invalid key ID %q", id)
+		}
+		if len(key) != EncryptionKeySize {
+			return nil, errors.Errorf("key %s has %d bytes, expected %d", id, len(key), EncryptionKeySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", id)
+		}
+		if k.keys[id], err = cipher.NewGCM(block); err != nil {
+			return nil, errors.Wrapf(err, "invalid key %s", id)
+		}
+	}
+	return k, nil
+}
+
+// KeyFromPassphrase derives a key from a passphrase and a salt with scrypt.
+// The same passphrase and salt always derive the same key, so the salt must
+// be kept with the ID of the key.
+func KeyFromPassphrase(passphrase string, salt []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	if len(salt) < 8 {
+		return nil, errors.New("salt must be at least 8 bytes long")
+	}
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, EncryptionKeySize)
+	return key, errors.Wrap(err, "failed to derive key")
+}
+
+// sealer encrypts the data of an object
+type sealer struct {
+	keyID  string
+	aead   cipher.AEAD
+	prefix []byte
+}
+
+// newSealer returns a sealer with the current key and a random nonce prefix
+func (k *Keyring) newSealer() (*sealer, error) {
+	prefix := make([]byte, encryptionPrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, errors.Wrap(err, "failed to generate nonce")
+	}
+	return &sealer{keyID: k.current, aead: k.keys[k.current], prefix: prefix}, nil
+}
+
+// putSealer returns the sealer with which Put encrypts an object, or nil
+func (b *bucket) putSealer(s putSettings) (*sealer, error) {
+	if b.keyring == nil || s.stored {
+		return nil, nil
+	}
+	return b.keyring.newSealer()
+}
+
+// withTags returns a copy of tags that records the encryption
+func (s *sealer) withTags(tags map[string]string) map[string]string {
+	t := make(map[string]string, len(tags)+3)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[EncryptionTag] = EncryptionAESGCM
+	t[EncryptionKeyTag] = s.keyID
+	t[EncryptionNonceTag] = hex.EncodeToString(s.prefix)
+	return t
+}
+
+// reader returns a reader of the data of r encrypted, and its size if the
+// size of r is known
+func (s *sealer) reader(r io.Reader, size int64) (io.Reader, int64) {
+	if size >= 0 {
+		chunks := ceilDiv(size, encryptionChunkSize)
+		if chunks == 0 {
+			// Empty data is one empty chunk
+			chunks = 1
+		}
+		size += chunks * encryptionOverhead
+	}
+	return &encryptingReader{
+		chunkStream: newChunkStream(s.aead, s.prefix, encryptionChunkSize),
+		r:           r,
+	}, size
+}
+
+// chunkStream reads the chunks of a stream. Chunks are read with the first
+// byte of the next chunk, so that the last chunk is known.
+type chunkStream struct {
+	aead   cipher.AEAD
+	prefix []byte
+	index  uint32
+	size   int
+	in     []byte
+	carry  bool
+	out    []byte
+	buf    []byte
+	done   bool
+}
+
+func newChunkStream(aead cipher.AEAD, prefix []byte, size int) chunkStream {
+	return chunkStream{
+		aead:   aead,
+		prefix: prefix,
+		size:   size,
+		in:     make([]byte, size+1),
+		buf:    make([]byte, 0, encryptionChunkSize+encryptionOverhead),
+	}
+}
+
+// next reads the next chunk of r, and returns it and whether it is the last
+func (c *chunkStream) next(r io.Reader) ([]byte, bool, error) {
+	start := 0
+	if c.carry {
+		c.in[0] = c.in[c.size]
+		start = 1
+	}
+	n, err := io.ReadFull(r, c.in[start:])
+	n += start
+	switch err {
+	case nil:
+		c.carry = true
+		n = c.size
+	case io.EOF, io.ErrUnexpectedEOF:
+		c.carry = false
+		c.done = true
+	default:
+		return nil, false, err
+	}
+	if c.index == math.MaxUint32 && !c.done {
+		return nil, false, errors.New("too many chunks")
+	}
+	return c.in[:n], c.done, nil
+}
+
+// nonce returns the nonce of the current chunk
+func (c *chunkStream) nonce(last bool) []byte {
+	nonce := make([]byte, 0, encryptionPrefixSize+5)
+	nonce = append(nonce, c.prefix...)
+	nonce = append(nonce, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(nonce[encryptionPrefixSize:], c.index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// read copies the pending output of the stream to p, calling fill while
+// there is none
+func (c *chunkStream) read(p []byte, fill func() error) (int, error) {
+	for len(c.out) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// encryptingReader reads the data of r encrypted in chunks
+type encryptingReader struct {
+	chunkStream
+	r io.Reader
+}
+
+func (e *encryptingReader) Read(p []byte) (int, error) {
+	return e.read(p, func() error {
+		chunk, last, err := e.next(e.r)
+		if err != nil {
+			return err
+		}
+		e.out = e.aead.Seal(e.buf[:0], e.nonce(last), chunk, nil)
+		e.index++
+		return nil
+	})
+}
+
+// decryptingReadCloser reads the data of an encrypted object decrypted, and
+// closes the object
+type decryptingReadCloser struct {
+	chunkStream
+	io.ReadCloser
+	name string
+}
+
+func (d *decryptingReadCloser) Read(p []byte) (int, error) {
+	return d.read(p, func() error {
+		chunk, last, err := d.next(d.ReadCloser)
+		if err != nil {
+			return err
+		}
+		if d.out, err = d.aead.Open(d.buf[:0], d.nonce(last), chunk, nil); err != nil {
+			return &ErrDecryptionFailed{Name: d.name, Reason: "the data was modified or truncated"}
+		}
+		d.index++
+		return nil
+	})
+}
+
+// checkStoredAsIs fails with an ErrObjectEncrypted or an
+// ErrObjectCompressed if the object at the absolute path objName is
+// encrypted or compressed, since op reads its data as stored
+func (d *directory) checkStoredAsIs(ctx context.Context, objName, op string) error {
+	item, err := d.item(ctx, objName)
+	if err != nil {
+		if IsObjectNotFound(err) {
+			return &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return err
+	}
+	tags, err := item.Metadata()
+	if err != nil {
+		return err
+	}
+	t := d.bucket.itemTags(tags)
+	if t[EncryptionTag] != "" {
+		return &ErrObjectEncrypted{Name: d.bucket.redaction.Redact(objName), Operation: op}
+	}
+	if t[ContentEncodingTag] != "" {
+		return &ErrObjectCompressed{Name: d.bucket.redaction.Redact(objName), Operation: op}
+	}
+	return nil
+}
+
+// decrypt returns a reader of the data of the object read by r, decrypted
+// with the keyring of b if its tags record an encryption. r is closed if
+// it cannot be decrypted.
+func decrypt(b *bucket, name string, r io.ReadCloser, tags map[string]string) (io.ReadCloser, error) {
+	format := tags[EncryptionTag]
+	if format == "" {
+		return r, nil
+	}
+	aead, prefix, err := b.keyring.opener(format, tags)
+	if err != nil {
+		_ = r.Close()
+		return nil, &ErrDecryptionFailed{Name: name, Reason: err.Error()}
+	}
+	return &decryptingReadCloser{
+		chunkStream: newChunkStream(aead, prefix, encryptionChunkSize+encryptionOverhead),
+		ReadCloser:  r,
+		name:        name,
+	}, nil
+}
+
+// opener returns the cipher and nonce prefix of an object encrypted in the
+// format with the key and nonce recorded in its tags
+func (k *Keyring) opener(format string, tags map[string]string) (cipher.AEAD, []byte, error) {
+	if format != EncryptionAESGCM {
+		return nil, nil, errors.Errorf("unknown format %q", format)
+	}
+	if k == nil {
+		return nil, nil, errors.New("the bucket has no keyring")
+	}
+	id := tags[EncryptionKeyTag]
+	aead, ok := k.keys[id]
+	if !ok {
+		return nil, nil, errors.Errorf("key %q is not in the keyring", id)
+	}
+	prefix, err := hex.DecodeString(tags[EncryptionNonceTag])
+	if err != nil || len(prefix) != encryptionPrefixSize {
+		return nil, nil, errors.New("invalid nonce")
+	}
+	return aead, prefix, nil
+}
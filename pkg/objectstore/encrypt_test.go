@@ -0,0 +1,152 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type EncryptSuite struct{}
+
+var _ = Suite(&EncryptSuite{})
+
+func newTestKeyring(c *C, current string, ids ...string) *Keyring {
+	keys := make(map[string][]byte, len(ids))
+	for _, id := range ids {
+		keys[id] = bytes.Repeat([]byte(id[:1]), EncryptionKeySize)
+	}
+	k, err := NewKeyring(current, keys)
+	c.Assert(err, IsNil)
+	return k
+}
+
+func (s *EncryptSuite) TestRoundTrip(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("encrypted")
+	b.keyring = newTestKeyring(c, "a", "a")
+	for _, size := range []int{0, 1, encryptionChunkSize - 1, encryptionChunkSize, encryptionChunkSize + 1, 3*encryptionChunkSize + 5} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		c.Assert(b.PutBytes(ctx, "obj", data, map[string]string{"type": "data"}), IsNil)
+		item := mc.items["obj"]
+		c.Assert(item.metadata[EncryptionTag], Equals, EncryptionAESGCM)
+		c.Assert(item.metadata[EncryptionKeyTag], Equals, "a")
+		chunks := (size + encryptionChunkSize - 1) / encryptionChunkSize
+		if chunks == 0 {
+			chunks = 1
+		}
+		c.Assert(item.data, HasLen, size+chunks*encryptionOverhead, Commentf("%d bytes", size))
+		c.Assert(bytes.Contains(item.data, []byte("xxxxxxxx")), Equals, false)
+
+		got, tags, err := b.GetBytes(ctx, "obj")
+		c.Assert(err, IsNil)
+		c.Assert(got, DeepEquals, data, Commentf("%d bytes", size))
+		c.Assert(tags["type"], Equals, "data")
+		r, _, err := b.GetSpooled(ctx, "obj", SpoolOptions{})
+		c.Assert(err, IsNil)
+		got, err = ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		c.Assert(got, DeepEquals, data, Commentf("%d bytes", size))
+	}
+
+	// Data is compressed before it is encrypted, and checksums are of the
+	// data before both
+	data := bytes.Repeat([]byte("manifest "), 1000)
+	var sum string
+	err := b.PutOpts(ctx, "compressed", bytes.NewReader(data), int64(len(data)), nil, WithCompression(CodecGzip), WithChecksum(&sum))
+	c.Assert(err, IsNil)
+	c.Assert(len(mc.items["compressed"].data) < len(data), Equals, true)
+	got, _, err := b.GetBytes(WithVerifiedChecksums(ctx), "compressed")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+}
+
+func (s *EncryptSuite) TestKeyRotation(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("rotation")
+	b.keyring = newTestKeyring(c, "old", "old")
+	c.Assert(b.PutBytes(ctx, "before", []byte("before"), nil), IsNil)
+
+	b.keyring = newTestKeyring(c, "new", "old", "new")
+	c.Assert(b.PutBytes(ctx, "after", []byte("after"), nil), IsNil)
+	c.Assert(mc.items["after"].metadata[EncryptionKeyTag], Equals, "new")
+	for _, name := range []string{"before", "after"} {
+		data, _, err := b.GetBytes(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, name)
+	}
+
+	b.keyring = newTestKeyring(c, "new", "new")
+	_, _, err := b.GetBytes(ctx, "before")
+	c.Assert(IsDecryptionFailed(err), Equals, true)
+	c.Assert(err, ErrorMatches, `failed to decrypt object /before: key "old" is not in the keyring`)
+	b.keyring = nil
+	_, _, err = b.GetBytes(ctx, "after")
+	c.Assert(err, ErrorMatches, "failed to decrypt object /after: the bucket has no keyring")
+}
+
+func (s *EncryptSuite) TestModifiedData(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("modified")
+	b.keyring = newTestKeyring(c, "a", "a")
+	data := bytes.Repeat([]byte{'x'}, 2*encryptionChunkSize)
+	c.Assert(b.PutBytes(ctx, "obj", data, nil), IsNil)
+	sealed := mc.items["obj"].data
+	first := sealed[:encryptionChunkSize+encryptionOverhead]
+	second := sealed[encryptionChunkSize+encryptionOverhead:]
+
+	flipped := append([]byte(nil), sealed...)
+	flipped[10] ^= 1
+	for name, d := range map[string][]byte{
+		"flipped":   flipped,
+		"truncated": first,
+		"reordered": append(append([]byte(nil), second...), first...),
+		"empty":     nil,
+	} {
+		mc.items["obj"].data = d
+		_, _, err := b.GetBytes(ctx, "obj")
+		c.Check(IsDecryptionFailed(err), Equals, true, Commentf("%s", name))
+	}
+}
+
+func (s *EncryptSuite) TestUnsupported(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("range")
+	b.keyring = newTestKeyring(c, "a", "a")
+	c.Assert(b.PutBytes(ctx, "obj", []byte("0123456789"), nil), IsNil)
+	_, _, err := b.GetRange(ctx, "obj", 2, 3)
+	c.Assert(IsObjectEncrypted(err), Equals, true)
+	c.Assert(err, ErrorMatches, "ranged read is not supported on encrypted object /obj")
+
+	// Copies keep the encrypted data
+	c.Assert(b.Copy(ctx, "obj", b, "copy"), IsNil)
+	c.Assert(mc.items["copy"].data, DeepEquals, mc.items["obj"].data)
+	data, _, err := b.GetBytes(ctx, "copy")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "0123456789")
+}
+
+func (s *EncryptSuite) TestKeys(c *C) {
+	key := bytes.Repeat([]byte{1}, EncryptionKeySize)
+	_, err := NewKeyring("missing", map[string][]byte{"a": key})
+	c.Assert(err, ErrorMatches, `current key "missing" is not in the keyring`)
+	_, err = NewKeyring("a b", map[string][]byte{"a b": key})
+	c.Assert(err, ErrorMatches, `invalid key ID "a b"`)
+	_, err = NewKeyring("a", map[string][]byte{"a": key[:16]})
+	c.Assert(err, ErrorMatches, "key a has 16 bytes, expected 32")
+
+	salt := []byte("kanister-salt")
+	k1, err := KeyFromPassphrase("passphrase", salt)
+	c.Assert(err, IsNil)
+	c.Assert(k1, HasLen, EncryptionKeySize)
+	k2, err := KeyFromPassphrase("passphrase", salt)
+	c.Assert(err, IsNil)
+	c.Assert(k2, DeepEquals, k1)
+	k3, err := KeyFromPassphrase("passphrase", []byte("other-salt"))
+	c.Assert(err, IsNil)
+	c.Assert(k3, Not(DeepEquals), k1)
+	_, err = KeyFromPassphrase("", salt)
+	c.Assert(err, NotNil)
+}
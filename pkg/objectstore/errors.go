@@ -0,0 +1,102 @@
+package objectstore
+
+// Errors of providers mapped onto errors that do not depend on the provider
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// ErrBucketNotFound is returned for buckets that do not exist
+type ErrBucketNotFound struct {
+	Bucket string
+}
+
+func (e *ErrBucketNotFound) Error() string {
+	return fmt.Sprintf("bucket %s not found", e.Bucket)
+}
+
+// IsBucketNotFound returns true if the cause of err is an
+// ErrBucketNotFound, or the missing bucket error of a provider
+func IsBucketNotFound(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return false
+	case *ErrBucketNotFound:
+		return true
+	case awserr.Error:
+		return e.Code() == s3.ErrCodeNoSuchBucket
+	}
+	return strings.Contains(err.Error(), "ContainerNotFound")
+}
+
+// ErrAccessDenied is returned for operations that the credentials do not
+// allow, or if the credentials are invalid
+type ErrAccessDenied struct {
+	Err error
+}
+
+func (e *ErrAccessDenied) Error() string {
+	return fmt.Sprintf("access denied: %s", e.Err)
+}
+
+// IsAccessDenied returns true if the cause of err is an ErrAccessDenied, or
+// the permission error of a provider
+func IsAccessDenied(err error) bool {
+	if _, ok := errors.Cause(err).(*ErrAccessDenied); ok {
+		return true
+	}
+	return err != nil && classifyError(err) == ClassAccessDenied
+}
+
+// conflictCodes are the error codes of S3 for requests that conflict with
+// concurrent requests on the same object
+var conflictCodes = map[string]bool{
+	"OperationAborted":           true,
+	"ConditionalRequestConflict": true,
+	"PreconditionFailed":         true,
+}
+
+// conflictStatusRe matches the conflict status codes in the messages of
+// providers other than S3, e.g. "googleapi: Error 412: Precondition Failed"
+// or "storage: service returned error: StatusCode=409"
+var conflictStatusRe = regexp.MustCompile(`\b(409|412)\b`)
+
+// isConflictError returns true if err reports a conflict with a concurrent
+// request on the same object
+func isConflictError(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return false
+	case awserr.RequestFailure:
+		return e.StatusCode() == 409 || e.StatusCode() == 412 || conflictCodes[e.Code()]
+	case awserr.Error:
+		return conflictCodes[e.Code()]
+	}
+	return conflictStatusRe.MatchString(errors.Cause(err).Error())
+}
+
+// providerError maps the missing bucket and permission errors of the
+// providers of b onto ErrBucketNotFound and ErrAccessDenied. Missing
+// objects are reported by stow.ErrNotFound, or by errors that
+// IsObjectNotFound recognizes, and are returned as is like other errors
+// unless the caller maps them onto ErrObjectNotFound.
+func providerError(b *bucket, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case IsBucketNotFound(err):
+		return &ErrBucketNotFound{Bucket: b.container.ID()}
+	case IsAccessDenied(err):
+		if _, ok := errors.Cause(err).(*ErrAccessDenied); ok {
+			return err
+		}
+		return &ErrAccessDenied{Err: err}
+	}
+	return err
+}
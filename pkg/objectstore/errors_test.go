@@ -0,0 +1,95 @@
+package objectstore
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type ErrorsSuite struct{}
+
+var _ = Suite(&ErrorsSuite{})
+
+// errContainer fails reads and deletes of items with err
+type errContainer struct {
+	*memContainer
+	err error
+}
+
+func (c *errContainer) Item(id string) (stow.Item, error) {
+	return nil, c.err
+}
+
+func (c *errContainer) RemoveItem(id string) error {
+	return c.err
+}
+
+func (s *ErrorsSuite) TestProviderErrors(c *C) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		err            error
+		objectNotFound bool
+		bucketNotFound bool
+		accessDenied   bool
+	}{
+		{err: stow.ErrNotFound, objectNotFound: true},
+		{err: awserr.New("NoSuchKey", "The specified key does not exist.", nil), objectNotFound: true},
+		{err: awserr.NewRequestFailure(awserr.New("NotFound", "", nil), 404, "id"), objectNotFound: true},
+		{err: errors.New("googleapi: Error 404: No such object: bucket/dir/obj, notFound"), objectNotFound: true},
+		{err: errors.New("storage: service returned error: StatusCode=404, ErrorCode=BlobNotFound"), objectNotFound: true},
+		{err: awserr.New("NoSuchBucket", "The specified bucket does not exist", nil), bucketNotFound: true},
+		{err: errors.New("storage: service returned error: StatusCode=404, ErrorCode=ContainerNotFound"), bucketNotFound: true},
+		{err: awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "id"), accessDenied: true},
+		{err: errors.New("googleapi: Error 403: Forbidden, forbidden"), accessDenied: true},
+		{err: errors.New("unexpected EOF")},
+	} {
+		cmt := Commentf("%v", tc.err)
+		b, mc := newMemBucket("errors")
+		c.Assert(b.PutBytes(ctx, "dir/obj", []byte("data"), nil), IsNil)
+		b.container = &errContainer{memContainer: mc, err: tc.err}
+		b.retry = RetryPolicy{MaxAttempts: 1}
+
+		_, _, err := b.Get(ctx, "dir/obj")
+		errs := []error{err}
+		errs = append(errs, b.Delete(ctx, "dir/obj"))
+		_, err = b.GetDirectory(ctx, "missing")
+		errs = append(errs, err)
+		_, err = b.Stat(ctx, "dir/obj")
+		errs = append(errs, err)
+		for _, err := range errs {
+			c.Assert(err, NotNil, cmt)
+			c.Check(IsObjectNotFound(err), Equals, tc.objectNotFound, cmt)
+			c.Check(IsBucketNotFound(err), Equals, tc.bucketNotFound, cmt)
+			c.Check(IsAccessDenied(err), Equals, tc.accessDenied, cmt)
+			// Wrapping keeps the cause
+			err = errors.Wrap(err, "failed to collect garbage")
+			c.Check(IsObjectNotFound(err), Equals, tc.objectNotFound, cmt)
+			c.Check(IsBucketNotFound(err), Equals, tc.bucketNotFound, cmt)
+			c.Check(IsAccessDenied(err), Equals, tc.accessDenied, cmt)
+		}
+	}
+	c.Assert(IsObjectNotFound(nil), Equals, false)
+	c.Assert(IsBucketNotFound(nil), Equals, false)
+	c.Assert(IsAccessDenied(nil), Equals, false)
+}
+
+func (s *ErrorsSuite) TestConflictErrors(c *C) {
+	for _, tc := range []struct {
+		err      error
+		conflict bool
+	}{
+		{err: awserr.NewRequestFailure(awserr.New("OperationAborted", "", nil), 409, "id"), conflict: true},
+		{err: awserr.NewRequestFailure(awserr.New("PreconditionFailed", "", nil), 412, "id"), conflict: true},
+		{err: awserr.New("ConditionalRequestConflict", "", nil), conflict: true},
+		{err: errors.New("googleapi: Error 412: Precondition Failed, conditionNotMet"), conflict: true},
+		{err: errors.New("storage: service returned error: StatusCode=409, ErrorCode=LeaseIdMissing"), conflict: true},
+		{err: awserr.NewRequestFailure(awserr.New("AccessDenied", "", nil), 403, "id")},
+		{err: errors.New("unexpected EOF")},
+		{err: nil},
+	} {
+		c.Check(isConflictError(errors.Wrap(tc.err, "failed to put")), Equals, tc.conflict, Commentf("%v", tc.err))
+	}
+}
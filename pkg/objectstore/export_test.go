@@ -0,0 +1,9 @@
+package objectstore
+
+// Helpers for the tests of the objectstore_test package
+
+// NewMemBucket returns a bucket backed by an in-memory container
+func NewMemBucket(id string) Bucket {
+	b, _ := newMemBucket(id)
+	return b
+}
@@ -0,0 +1,833 @@
+package objectstore
+
+// Reads that fail over from a primary directory to a replica of it in
+// another bucket, e.g. in another region
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultFailoverThreshold = 3
+	defaultFailoverCoolDown  = 30 * time.Second
+)
+
+// Replica identifies the directory of a failover directory that served an
+// operation
+type Replica string
+
+const (
+	// ReplicaPrimary is the directory that is read first and written to
+	ReplicaPrimary Replica = "primary"
+	// ReplicaSecondary is the directory that reads fail over to
+	ReplicaSecondary Replica = "secondary"
+)
+
+// FailoverPolicy configures the directories returned by
+// NewFailoverDirectory. Reads that fail on the primary with transport
+// errors, such as timeouts, refused connections and 5xx responses, are
+// always retried on the secondary.
+type FailoverPolicy struct {
+	// FailOverOnNotFound retries reads of objects that the primary does not
+	// have on the secondary, e.g. because they were not written yet
+	FailOverOnNotFound bool
+	// FailureThreshold is the number of consecutive transport errors of the
+	// primary after which reads go directly to the secondary. Defaults to 3.
+	FailureThreshold int
+	// CoolDown is how long reads go directly to the secondary once the
+	// threshold is reached. The primary is tried again afterwards. Defaults
+	// to 30s.
+	CoolDown time.Duration
+	// RejectWritesOnFailover fails writes with an ErrPrimaryUnavailable
+	// while reads go directly to the secondary, instead of trying the
+	// primary
+	RejectWritesOnFailover bool
+	// OnRead, if set, is called with the replica that served each read
+	OnRead func(op, name string, replica Replica)
+}
+
+func (p FailoverPolicy) withDefaults() FailoverPolicy {
+	if p.FailureThreshold <= 0 {
+		p.FailureThreshold = defaultFailoverThreshold
+	}
+	if p.CoolDown <= 0 {
+		p.CoolDown = defaultFailoverCoolDown
+	}
+	return p
+}
+
+// FailoverStats counts the reads of a failover directory and its sub
+// directories by the replica that served them
+type FailoverStats struct {
+	PrimaryReads   int64
+	SecondaryReads int64
+	// Failovers is the number of reads that failed on the primary and
+	// were retried on the secondary
+	Failovers int64
+	// Trips is the number of times reads started going directly to the
+	// secondary
+	Trips int64
+	// FailedOver is true while reads go directly to the secondary
+	FailedOver bool
+}
+
+// ErrPrimaryUnavailable is returned by writes to a failover directory whose
+// reads go directly to the secondary, if its policy rejects them
+type ErrPrimaryUnavailable struct{}
+
+func (e *ErrPrimaryUnavailable) Error() string {
+	return "primary replica is unavailable"
+}
+
+// IsPrimaryUnavailable returns true if the cause of err is an
+// ErrPrimaryUnavailable
+func IsPrimaryUnavailable(err error) bool {
+	_, ok := errors.Cause(err).(*ErrPrimaryUnavailable)
+	return ok
+}
+
+// failover tracks the health of the primary, and is shared by a failover
+// directory and its sub directories
+type failover struct {
+	policy FailoverPolicy
+	clock  clock
+
+	mu       sync.Mutex
+	failures int       // Consecutive transport errors of the primary
+	until    time.Time // Reads go to the secondary until then
+	stats    FailoverStats
+}
+
+// failedOver returns true if reads must go directly to the secondary
+func (f *failover) failedOver(ctx context.Context) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now(ctx).Before(f.until)
+}
+
+// now returns the time of the clock set in ctx, or else of the clock of f
+func (f *failover) now(ctx context.Context) time.Time {
+	if c, ok := ctx.Value(clockKey{}).(clock); ok {
+		return c.Now()
+	}
+	return f.clock.Now()
+}
+
+// record updates the health of the primary with the outcome of an operation
+// on it
+func (f *failover) record(ctx context.Context, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case err == nil:
+		f.failures = 0
+		f.until = time.Time{}
+	case isTransportError(ctx, err):
+		f.failures++
+		now := f.now(ctx)
+		if f.failures >= f.policy.FailureThreshold && !now.Before(f.until) {
+			f.until = now.Add(f.policy.CoolDown)
+			f.stats.Trips++
+		}
+	}
+}
+
+// served counts a read and reports it to the policy
+func (f *failover) served(op, name string, replica Replica, failedOver bool) {
+	f.mu.Lock()
+	if replica == ReplicaPrimary {
+		f.stats.PrimaryReads++
+	} else {
+		f.stats.SecondaryReads++
+	}
+	if failedOver {
+		f.stats.Failovers++
+	}
+	f.mu.Unlock()
+	if f.policy.OnRead != nil {
+		f.policy.OnRead(op, name, replica)
+	}
+}
+
+// failsOver returns true if a read that failed on the primary with err must
+// be retried on the secondary
+func (f *failover) failsOver(ctx context.Context, err error) bool {
+	if IsObjectNotFound(err) {
+		return f.policy.FailOverOnNotFound
+	}
+	return isTransportError(ctx, err)
+}
+
+// isTransportError returns true if err is likely caused by the object store
+// being unreachable or unhealthy, rather than by the request
+func isTransportError(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if isRetryable(err) {
+		return true
+	}
+	cause := errors.Cause(err)
+	if ae, ok := cause.(awserr.Error); ok && ae.OrigErr() != nil {
+		cause = errors.Cause(ae.OrigErr())
+	}
+	switch cause {
+	case context.Canceled, context.DeadlineExceeded:
+		return false
+	}
+	if _, ok := cause.(net.Error); ok {
+		return true
+	}
+	msg := cause.Error()
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "no such host")
+}
+
+var _ Directory = (*failoverDirectory)(nil)
+
+// failoverDirectory reads from the primary, or from the secondary if the
+// primary fails, and writes to the primary. The handles of sub directories
+// that one replica served are resolved on the other replica when they are
+// first needed.
+type failoverDirectory struct {
+	f *failover
+
+	mu        sync.Mutex
+	primary   Directory
+	secondary Directory
+	// resolve gets the handle of a replica that is not known yet
+	resolve func(ctx context.Context, replica Replica) (Directory, error)
+}
+
+// NewFailoverDirectory returns a directory that reads from primary, and
+// from secondary, a replica of primary, if primary fails as described by
+// policy. After repeated failures of primary, reads go directly to
+// secondary for a cool down period. Writes only go to primary, so that the
+// replicas do not diverge. Readers and iterators do not fail over once
+// they have been returned. Which replica served the reads is reported by
+// GetFailoverStats and by the callback of the policy.
+func NewFailoverDirectory(primary, secondary Directory, policy FailoverPolicy) Directory {
+	return &failoverDirectory{
+		f:         &failover{policy: policy.withDefaults(), clock: realClock{}},
+		primary:   primary,
+		secondary: secondary,
+	}
+}
+
+// GetFailoverStats returns the statistics of the failover directory d,
+// which are shared with its sub directories
+func GetFailoverStats(d Directory) (FailoverStats, error) {
+	fd, ok := d.(*failoverDirectory)
+	if !ok {
+		return FailoverStats{}, errors.Errorf("unsupported directory type %T", d)
+	}
+	fd.f.mu.Lock()
+	defer fd.f.mu.Unlock()
+	stats := fd.f.stats
+	stats.FailedOver = fd.f.clock.Now().Before(fd.f.until)
+	return stats, nil
+}
+
+// replica returns the handle of d on the replica, resolving it if needed
+func (d *failoverDirectory) replica(ctx context.Context, replica Replica) (Directory, error) {
+	d.mu.Lock()
+	dir := d.primary
+	if replica == ReplicaSecondary {
+		dir = d.secondary
+	}
+	d.mu.Unlock()
+	if dir != nil {
+		return dir, nil
+	}
+	dir, err := d.resolve(ctx, replica)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get the directory on the %s replica", replica)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if replica == ReplicaPrimary {
+		d.primary = dir
+	} else {
+		d.secondary = dir
+	}
+	return dir, nil
+}
+
+// sub returns the failover directory of the sub directory name of d, whose
+// handle on the replica is dir
+func (d *failoverDirectory) sub(name string, replica Replica, dir Directory) *failoverDirectory {
+	sd := &failoverDirectory{
+		f: d.f,
+		resolve: func(ctx context.Context, replica Replica) (Directory, error) {
+			parent, err := d.replica(ctx, replica)
+			if err != nil {
+				return nil, err
+			}
+			return parent.GetDirectory(ctx, name)
+		},
+	}
+	if replica == ReplicaPrimary {
+		sd.primary = dir
+	} else {
+		sd.secondary = dir
+	}
+	return sd
+}
+
+// read calls f with the primary, and with the secondary if the primary
+// fails with an error that the policy fails over on, or if reads go
+// directly to the secondary. It returns the replica that served the read.
+// op and name describe the read.
+func (d *failoverDirectory) read(ctx context.Context, op, name string, f func(Directory) error) (Replica, error) {
+	var perr error
+	if !d.f.failedOver(ctx) {
+		dir, err := d.replica(ctx, ReplicaPrimary)
+		if err == nil {
+			err = f(dir)
+		}
+		if IsObjectNotFound(err) {
+			// The primary is healthy
+			d.f.record(ctx, nil)
+		} else {
+			d.f.record(ctx, err)
+		}
+		if err == nil {
+			d.f.served(op, name, ReplicaPrimary, false)
+			return ReplicaPrimary, nil
+		}
+		if !d.f.failsOver(ctx, err) {
+			return "", err
+		}
+		perr = err
+	}
+
+	dir, err := d.replica(ctx, ReplicaSecondary)
+	if err == nil {
+		err = f(dir)
+	}
+	switch {
+	case err == nil:
+		d.f.served(op, name, ReplicaSecondary, perr != nil)
+		return ReplicaSecondary, nil
+	case IsObjectNotFound(perr) && IsObjectNotFound(err):
+		return "", &ErrObjectNotFound{Name: name}
+	case perr != nil:
+		return "", errors.Wrapf(err, "failed to %s %s on the primary replica (%v) and on the secondary replica", op, name, perr)
+	}
+	return "", errors.Wrapf(err, "failed to %s %s on the secondary replica", op, name)
+}
+
+// write calls f with the primary, unless the policy rejects writes while
+// reads go directly to the secondary
+func (d *failoverDirectory) write(ctx context.Context, f func(Directory) error) error {
+	if d.f.policy.RejectWritesOnFailover && d.f.failedOver(ctx) {
+		return &ErrPrimaryUnavailable{}
+	}
+	dir, err := d.replica(ctx, ReplicaPrimary)
+	if err != nil {
+		return err
+	}
+	err = f(dir)
+	if !IsObjectNotFound(err) {
+		d.f.record(ctx, err)
+	}
+	return err
+}
+
+// primaryDirectory returns the handle of d on the primary if it is known,
+// for operations of the primary that d is passed to
+func (d *failoverDirectory) primaryDirectory() (Directory, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.primary == nil {
+		return nil, errors.New("directory is not known on the primary replica")
+	}
+	return d.primary, nil
+}
+
+// resolveDestination resolves the primary of dst if it is a failover
+// directory, so that the primary can write to it
+func resolveDestination(ctx context.Context, dst Directory) error {
+	if fd, ok := dst.(*failoverDirectory); ok {
+		_, err := fd.replica(ctx, ReplicaPrimary)
+		return err
+	}
+	return nil
+}
+
+func (d *failoverDirectory) String() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.primary == nil {
+		return d.secondary.String()
+	}
+	return d.primary.String()
+}
+
+func (d *failoverDirectory) CreateDirectory(ctx context.Context, dir string) (Directory, error) {
+	var sd Directory
+	err := d.write(ctx, func(p Directory) (err error) {
+		sd, err = p.CreateDirectory(ctx, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.sub(dir, ReplicaPrimary, sd), nil
+}
+
+func (d *failoverDirectory) CreateDirectoryAll(ctx context.Context, dir string) (Directory, error) {
+	var sd Directory
+	err := d.write(ctx, func(p Directory) (err error) {
+		sd, err = p.CreateDirectoryAll(ctx, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.sub(dir, ReplicaPrimary, sd), nil
+}
+
+func (d *failoverDirectory) GetDirectory(ctx context.Context, dir string) (Directory, error) {
+	if dir == "" {
+		return d, nil
+	}
+	var sd Directory
+	replica, err := d.read(ctx, "get directory", dir, func(r Directory) (err error) {
+		sd, err = r.GetDirectory(ctx, dir)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d.sub(dir, replica, sd), nil
+}
+
+func (d *failoverDirectory) DeleteDirectory(ctx context.Context) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.DeleteDirectory(ctx)
+	})
+}
+
+func (d *failoverDirectory) DeleteDirectoryCount(ctx context.Context) (int, error) {
+	var deleted int
+	err := d.write(ctx, func(p Directory) (err error) {
+		deleted, err = p.DeleteDirectoryCount(ctx)
+		return err
+	})
+	return deleted, err
+}
+
+func (d *failoverDirectory) DeleteDirectoryDryRun(ctx context.Context) ([]string, error) {
+	var keys []string
+	_, err := d.read(ctx, "list", "directory", func(r Directory) (err error) {
+		keys, err = r.DeleteDirectoryDryRun(ctx)
+		return err
+	})
+	return keys, err
+}
+
+func (d *failoverDirectory) DeleteDirectoryBestEffort(ctx context.Context) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.DeleteDirectoryBestEffort(ctx)
+	})
+}
+
+func (d *failoverDirectory) ListDirectories(ctx context.Context) (map[string]Directory, error) {
+	var dirs map[string]Directory
+	replica, err := d.read(ctx, "list", "directories", func(r Directory) (err error) {
+		dirs, err = r.ListDirectories(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, dir := range dirs {
+		dirs[name] = d.sub(name, replica, dir)
+	}
+	return dirs, nil
+}
+
+func (d *failoverDirectory) ListDirectoriesWithOptions(ctx context.Context, opts ListDirectoriesOptions) (map[string]DirectoryEntry, error) {
+	var entries map[string]DirectoryEntry
+	replica, err := d.read(ctx, "list", "directories", func(r Directory) (err error) {
+		entries, err = r.ListDirectoriesWithOptions(ctx, opts)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for name, e := range entries {
+		e.Directory = d.sub(name, replica, e.Directory)
+		entries[name] = e
+	}
+	return entries, nil
+}
+
+func (d *failoverDirectory) GetDirectoryTags(ctx context.Context) (map[string]string, error) {
+	var tags map[string]string
+	_, err := d.read(ctx, "get tags of", "directory", func(r Directory) (err error) {
+		tags, err = r.GetDirectoryTags(ctx)
+		return err
+	})
+	return tags, err
+}
+
+func (d *failoverDirectory) SetDirectoryTags(ctx context.Context, tags map[string]string, merge bool) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.SetDirectoryTags(ctx, tags, merge)
+	})
+}
+
+func (d *failoverDirectory) GetObjectTags(ctx context.Context, name string) (map[string]string, error) {
+	var tags map[string]string
+	_, err := d.read(ctx, "read", name, func(r Directory) (err error) {
+		tags, err = r.GetObjectTags(ctx, name)
+		return err
+	})
+	return tags, err
+}
+
+func (d *failoverDirectory) SetObjectTags(ctx context.Context, name string, tags map[string]string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.SetObjectTags(ctx, name, tags)
+	})
+}
+
+func (d *failoverDirectory) ListPrefixes(ctx context.Context) ([]string, error) {
+	var names []string
+	_, err := d.read(ctx, "list", "directories", func(r Directory) (err error) {
+		names, err = r.ListPrefixes(ctx)
+		return err
+	})
+	return names, err
+}
+
+func (d *failoverDirectory) ListObjects(ctx context.Context) ([]string, error) {
+	var names []string
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		names, err = r.ListObjects(ctx)
+		return err
+	})
+	return names, err
+}
+
+func (d *failoverDirectory) ListObjectsInfo(ctx context.Context) ([]ObjectInfo, error) {
+	var infos []ObjectInfo
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		infos, err = r.ListObjectsInfo(ctx)
+		return err
+	})
+	return infos, err
+}
+
+func (d *failoverDirectory) ListObjectsModifiedSince(ctx context.Context, since time.Time) ([]string, error) {
+	var names []string
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		names, err = r.ListObjectsModifiedSince(ctx, since)
+		return err
+	})
+	return names, err
+}
+
+func (d *failoverDirectory) Objects(ctx context.Context, opts ObjectIteratorOptions) (ObjectIterator, error) {
+	var it ObjectIterator
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		it, err = r.Objects(ctx, opts)
+		return err
+	})
+	return it, err
+}
+
+func (d *failoverDirectory) ListObjectsRecursive(ctx context.Context) ([]string, error) {
+	var names []string
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		names, err = r.ListObjectsRecursive(ctx)
+		return err
+	})
+	return names, err
+}
+
+func (d *failoverDirectory) ListObjectsWithPrefix(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	_, err := d.read(ctx, "list", prefix, func(r Directory) (err error) {
+		names, err = r.ListObjectsWithPrefix(ctx, prefix)
+		return err
+	})
+	return names, err
+}
+
+func (d *failoverDirectory) IsEmpty(ctx context.Context) (bool, error) {
+	var empty bool
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		empty, err = r.IsEmpty(ctx)
+		return err
+	})
+	return empty, err
+}
+
+func (d *failoverDirectory) Stats(ctx context.Context) (DirectoryStats, error) {
+	var stats DirectoryStats
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		stats, err = r.Stats(ctx)
+		return err
+	})
+	return stats, err
+}
+
+// ListObjectsPage lists a page of objects of the replica that serves it.
+// The cursors of the replicas may differ, so listings that fail over midway
+// may repeat or skip objects.
+func (d *failoverDirectory) ListObjectsPage(ctx context.Context, cursor string, limit int) ([]string, string, error) {
+	var names []string
+	var next string
+	_, err := d.read(ctx, "list", "objects", func(r Directory) (err error) {
+		names, next, err = r.ListObjectsPage(ctx, cursor, limit)
+		return err
+	})
+	return names, next, err
+}
+
+// Exists checks whether the named object exists on the replica that serves
+// the read. Objects that the primary does not have are looked up on the
+// secondary if the policy fails over on missing objects.
+func (d *failoverDirectory) Exists(ctx context.Context, name string) (bool, error) {
+	_, err := d.read(ctx, "read", name, func(r Directory) error {
+		ok, err := r.Exists(ctx, name)
+		if err == nil && !ok {
+			return &ErrObjectNotFound{Name: name}
+		}
+		return err
+	})
+	switch {
+	case err == nil:
+		return true, nil
+	case IsObjectNotFound(err):
+		return false, nil
+	}
+	return false, err
+}
+
+func (d *failoverDirectory) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	var info ObjectInfo
+	_, err := d.read(ctx, "read", name, func(r Directory) (err error) {
+		info, err = r.Stat(ctx, name)
+		return err
+	})
+	return info, err
+}
+
+func (d *failoverDirectory) Get(ctx context.Context, name string) (io.ReadCloser, map[string]string, error) {
+	return d.GetOpts(ctx, name)
+}
+
+func (d *failoverDirectory) GetSpooled(ctx context.Context, name string, opts SpoolOptions) (io.ReadCloser, map[string]string, error) {
+	return d.GetOpts(ctx, name, WithSpool(opts))
+}
+
+func (d *failoverDirectory) GetOpts(ctx context.Context, name string, opts ...GetOption) (io.ReadCloser, map[string]string, error) {
+	var r io.ReadCloser
+	var tags map[string]string
+	_, err := d.read(ctx, "read", name, func(dir Directory) (err error) {
+		r, tags, err = dir.GetOpts(ctx, name, opts...)
+		return err
+	})
+	return r, tags, err
+}
+
+func (d *failoverDirectory) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, int64, error) {
+	var r io.ReadCloser
+	var size int64
+	_, err := d.read(ctx, "read", name, func(dir Directory) (err error) {
+		r, size, err = dir.GetRange(ctx, name, offset, length)
+		return err
+	})
+	return r, size, err
+}
+
+func (d *failoverDirectory) GetBytesRange(ctx context.Context, name string, offset, length int64) ([]byte, int64, error) {
+	var data []byte
+	var size int64
+	_, err := d.read(ctx, "read", name, func(dir Directory) (err error) {
+		data, size, err = dir.GetBytesRange(ctx, name, offset, length)
+		return err
+	})
+	return data, size, err
+}
+
+func (d *failoverDirectory) GetBytes(ctx context.Context, name string) ([]byte, map[string]string, error) {
+	var data []byte
+	var tags map[string]string
+	_, err := d.read(ctx, "read", name, func(dir Directory) (err error) {
+		data, tags, err = dir.GetBytes(ctx, name)
+		return err
+	})
+	return data, tags, err
+}
+
+func (d *failoverDirectory) GetBytesLimit(ctx context.Context, name string, maxBytes int64) ([]byte, map[string]string, error) {
+	var data []byte
+	var tags map[string]string
+	_, err := d.read(ctx, "read", name, func(dir Directory) (err error) {
+		data, tags, err = dir.GetBytesLimit(ctx, name, maxBytes)
+		return err
+	})
+	return data, tags, err
+}
+
+func (d *failoverDirectory) MultiGet(ctx context.Context, names []string, concurrency int) (map[string][]byte, error) {
+	var objects map[string][]byte
+	_, err := d.read(ctx, "read", "objects", func(dir Directory) (err error) {
+		objects, err = dir.MultiGet(ctx, names, concurrency)
+		return err
+	})
+	return objects, err
+}
+
+func (d *failoverDirectory) GetToFile(ctx context.Context, name, localPath string) error {
+	_, err := d.read(ctx, "read", name, func(dir Directory) error {
+		return dir.GetToFile(ctx, name, localPath)
+	})
+	return err
+}
+
+// PresignGet presigns URLs of the primary only, since the URLs would not
+// fail over
+func (d *failoverDirectory) PresignGet(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	p, err := d.replica(ctx, ReplicaPrimary)
+	if err != nil {
+		return "", err
+	}
+	return p.PresignGet(ctx, name, expiry)
+}
+
+func (d *failoverDirectory) PresignPut(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	var u string
+	err := d.write(ctx, func(p Directory) (err error) {
+		u, err = p.PresignPut(ctx, name, expiry)
+		return err
+	})
+	return u, err
+}
+
+func (d *failoverDirectory) Put(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.Put(ctx, name, r, size, tags)
+	})
+}
+
+func (d *failoverDirectory) PutOpts(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string, opts ...PutOption) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.PutOpts(ctx, name, r, size, tags, opts...)
+	})
+}
+
+func (d *failoverDirectory) PutBytes(ctx context.Context, name string, data []byte, tags map[string]string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.PutBytes(ctx, name, data, tags)
+	})
+}
+
+func (d *failoverDirectory) PutIfNotExists(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.PutIfNotExists(ctx, name, r, size, tags)
+	})
+}
+
+func (d *failoverDirectory) PutFile(ctx context.Context, name, localPath string, tags map[string]string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.PutFile(ctx, name, localPath, tags)
+	})
+}
+
+func (d *failoverDirectory) PutVersioned(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) (string, error) {
+	var version string
+	err := d.write(ctx, func(p Directory) (err error) {
+		version, err = p.PutVersioned(ctx, name, r, size, tags)
+		return err
+	})
+	return version, err
+}
+
+// GetVersion reads a version of the object from the primary only, since
+// the replicas do not share version IDs
+func (d *failoverDirectory) GetVersion(ctx context.Context, name, version string) (io.ReadCloser, map[string]string, error) {
+	p, err := d.replica(ctx, ReplicaPrimary)
+	if err != nil {
+		return nil, nil, err
+	}
+	return p.GetVersion(ctx, name, version)
+}
+
+// ListVersions lists the versions of the object on the primary only, since
+// the replicas do not share version IDs
+func (d *failoverDirectory) ListVersions(ctx context.Context, name string) ([]ObjectVersion, error) {
+	p, err := d.replica(ctx, ReplicaPrimary)
+	if err != nil {
+		return nil, err
+	}
+	return p.ListVersions(ctx, name)
+}
+
+func (d *failoverDirectory) Copy(ctx context.Context, srcName string, dst Directory, dstName string) error {
+	if err := resolveDestination(ctx, dst); err != nil {
+		return err
+	}
+	_, err := d.read(ctx, "copy", srcName, func(r Directory) error {
+		return r.Copy(ctx, srcName, dst, dstName)
+	})
+	return err
+}
+
+func (d *failoverDirectory) CopyDirectory(ctx context.Context, dst Directory) error {
+	if err := resolveDestination(ctx, dst); err != nil {
+		return err
+	}
+	_, err := d.read(ctx, "copy", "directory", func(r Directory) error {
+		return r.CopyDirectory(ctx, dst)
+	})
+	return err
+}
+
+func (d *failoverDirectory) Move(ctx context.Context, srcName string, dst Directory, dstName string) error {
+	if err := resolveDestination(ctx, dst); err != nil {
+		return err
+	}
+	return d.write(ctx, func(p Directory) error {
+		return p.Move(ctx, srcName, dst, dstName)
+	})
+}
+
+func (d *failoverDirectory) MoveDirectory(ctx context.Context, dst Directory) error {
+	if err := resolveDestination(ctx, dst); err != nil {
+		return err
+	}
+	return d.write(ctx, func(p Directory) error {
+		return p.MoveDirectory(ctx, dst)
+	})
+}
+
+func (d *failoverDirectory) Delete(ctx context.Context, name string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.Delete(ctx, name)
+	})
+}
+
+func (d *failoverDirectory) DeleteObjects(ctx context.Context, names []string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.DeleteObjects(ctx, names)
+	})
+}
+
+func (d *failoverDirectory) DeleteVersion(ctx context.Context, name, version string) error {
+	return d.write(ctx, func(p Directory) error {
+		return p.DeleteVersion(ctx, name, version)
+	})
+}
@@ -0,0 +1,230 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type FailoverSuite struct{}
+
+var _ = Suite(&FailoverSuite{})
+
+// downContainer fails all requests with a connection error while it is down,
+// and counts the requests
+type downContainer struct {
+	*memContainer
+	mu       sync.Mutex
+	down     bool
+	requests int
+}
+
+func (c *downContainer) setDown(down bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.down = down
+}
+
+func (c *downContainer) request() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests++
+	if c.down {
+		return errors.New("dial tcp 10.0.0.1:443: connect: connection refused")
+	}
+	return nil
+}
+
+func (c *downContainer) Item(id string) (stow.Item, error) {
+	if err := c.request(); err != nil {
+		return nil, err
+	}
+	return c.memContainer.Item(id)
+}
+
+func (c *downContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	if err := c.request(); err != nil {
+		return nil, "", err
+	}
+	return c.memContainer.Items(prefix, cursor, count)
+}
+
+func (c *downContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	if err := c.request(); err != nil {
+		return nil, err
+	}
+	return c.memContainer.Put(name, r, size, metadata)
+}
+
+// newFailoverBuckets returns the directory dir of a primary bucket, whose
+// container can be taken down, and of a secondary bucket
+func newFailoverBuckets(c *C, dir string) (Directory, *downContainer, Directory) {
+	ctx := context.Background()
+	dc := &downContainer{memContainer: newMemContainer("primary")}
+	primary := newBucket(ProviderConfig{}, dc, nil, "mem://")
+	primary.retry = RetryPolicy{MaxAttempts: 1}
+	secondary, _ := newMemBucket("secondary")
+	pd, err := primary.CreateDirectory(ctx, dir)
+	c.Assert(err, IsNil)
+	sd, err := secondary.CreateDirectory(ctx, dir)
+	c.Assert(err, IsNil)
+	return pd, dc, sd
+}
+
+func (s *FailoverSuite) TestNotFoundHelpers(c *C) {
+	ctx := context.Background()
+	pd, _, sd := newFailoverBuckets(c, "backups")
+	d := NewFailoverDirectory(pd, sd, FailoverPolicy{FailOverOnNotFound: true})
+
+	// Objects missing on both replicas are ErrObjectNotFound, which the
+	// helpers read as missing markers and records
+	l, err := ReadLayout(ctx, d)
+	c.Assert(err, IsNil)
+	c.Assert(l, DeepEquals, Layout{Version: PlainLayoutVersion})
+	ok, err := AlreadyApplied(ctx, d, "key")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+	c.Assert(MarkApplied(ctx, d, "key", 0), IsNil)
+	ok, err = AlreadyApplied(ctx, d, "key")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+	err = d.Delete(ctx, "missing")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+}
+
+func (s *FailoverSuite) TestFailover(c *C) {
+	ctx := context.Background()
+	pd, dc, sd := newFailoverBuckets(c, "backups")
+	c.Assert(pd.PutBytes(ctx, "obj", []byte("primary"), nil), IsNil)
+	c.Assert(sd.PutBytes(ctx, "obj", []byte("secondary"), nil), IsNil)
+	var served []Replica
+	d := NewFailoverDirectory(pd, sd, FailoverPolicy{
+		FailureThreshold:       2,
+		CoolDown:               time.Minute,
+		RejectWritesOnFailover: true,
+		OnRead: func(op, name string, replica Replica) {
+			served = append(served, replica)
+		},
+	})
+	clk := newFakeClock()
+	d.(*failoverDirectory).f.clock = clk
+
+	data, _, err := d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "primary")
+
+	// Reads fail over while the primary is down
+	dc.setDown(true)
+	for i := 0; i < 2; i++ {
+		data, _, err = d.GetBytes(ctx, "obj")
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "secondary")
+	}
+	stats, err := GetFailoverStats(d)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, FailoverStats{PrimaryReads: 1, SecondaryReads: 2, Failovers: 2, Trips: 1, FailedOver: true})
+
+	// Once failed over, reads go directly to the secondary, and writes are
+	// rejected
+	requests := dc.requests
+	names, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, []string{"obj"})
+	c.Assert(IsPrimaryUnavailable(d.PutBytes(ctx, "new", nil, nil)), Equals, true)
+	c.Assert(dc.requests, Equals, requests)
+
+	// Reads fail back once the primary recovers and the cool down ends
+	dc.setDown(false)
+	clk.Advance(time.Minute)
+	data, _, err = d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "primary")
+	c.Assert(d.PutBytes(ctx, "new", nil, nil), IsNil)
+	stats, err = GetFailoverStats(d)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, FailoverStats{PrimaryReads: 2, SecondaryReads: 3, Failovers: 2, Trips: 1})
+	c.Assert(served, DeepEquals, []Replica{ReplicaPrimary, ReplicaSecondary, ReplicaSecondary, ReplicaSecondary, ReplicaPrimary})
+}
+
+func (s *FailoverSuite) TestNotFound(c *C) {
+	ctx := context.Background()
+	pd, dc, sd := newFailoverBuckets(c, "backups")
+	c.Assert(sd.PutBytes(ctx, "replicated", []byte("secondary"), nil), IsNil)
+
+	// Missing objects are only read from the secondary if the policy says so
+	d := NewFailoverDirectory(pd, sd, FailoverPolicy{})
+	_, _, err := d.GetBytes(ctx, "replicated")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	d = NewFailoverDirectory(pd, sd, FailoverPolicy{FailOverOnNotFound: true})
+	data, _, err := d.GetBytes(ctx, "replicated")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "secondary")
+	ok, err := d.Exists(ctx, "replicated")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	// Objects missing on both replicas are reported once
+	_, _, err = d.GetBytes(ctx, "missing")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object missing not found")
+	_, err = d.Stat(ctx, "missing")
+	c.Assert(err, ErrorMatches, "object missing not found")
+	ok, err = d.Exists(ctx, "missing")
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, false)
+
+	// Missing objects do not count as failures of the primary
+	stats, err := GetFailoverStats(d)
+	c.Assert(err, IsNil)
+	c.Assert(stats.Trips, Equals, int64(0))
+
+	// Other errors of the secondary are reported with those of the primary
+	dc.setDown(true)
+	_, _, err = d.GetBytes(ctx, "missing")
+	c.Assert(err, ErrorMatches, "failed to read missing on the primary replica .*connection refused.* and on the secondary replica: object /backups/missing not found")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+}
+
+func (s *FailoverSuite) TestSubDirectories(c *C) {
+	ctx := context.Background()
+	pd, dc, sd := newFailoverBuckets(c, "backups")
+	c.Assert(pd.PutBytes(ctx, "dir/obj", []byte("primary"), nil), IsNil)
+	c.Assert(sd.PutBytes(ctx, "dir/obj", []byte("secondary"), nil), IsNil)
+	d := NewFailoverDirectory(pd, sd, FailoverPolicy{FailureThreshold: 1, CoolDown: time.Minute})
+	clk := newFakeClock()
+	d.(*failoverDirectory).f.clock = clk
+
+	// The sub directory is found on the secondary while the primary is down,
+	// and on the primary once it recovers
+	dc.setDown(true)
+	sub, err := d.GetDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	data, _, err := sub.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "secondary")
+	dc.setDown(false)
+	clk.Advance(time.Minute)
+	data, _, err = sub.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "primary")
+
+	// Writes go to the primary, including those of copies
+	c.Assert(sub.PutBytes(ctx, "new", []byte("data"), nil), IsNil)
+	c.Assert(sub.Copy(ctx, "new", d, "copy"), IsNil)
+	_, err = pd.Stat(ctx, "copy")
+	c.Assert(err, IsNil)
+	_, err = sd.Stat(ctx, "dir/new")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+
+	dirs, err := d.ListDirectories(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(dirs, HasLen, 1)
+	data, _, err = dirs["dir"].GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "primary")
+}
@@ -0,0 +1,15 @@
+// +build linux darwin
+
+package objectstore
+
+import "syscall"
+
+// freeSpace returns the number of bytes available to unprivileged users in
+// the filesystem containing dir.
+func freeSpace(dir string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
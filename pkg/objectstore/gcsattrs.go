@@ -0,0 +1,39 @@
+package objectstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/storage/v1"
+)
+
+var _ attrsReader = (*gcsAttrsReader)(nil)
+
+// gcsAttrsReader reads the attributes of objects with the GCS objects.get
+// API
+type gcsAttrsReader struct {
+	service   *storage.Service
+	bucket    string
+	redaction NameRedaction
+}
+
+func newGCSAttrsReader(client *http.Client, bucket string, redaction NameRedaction) (*gcsAttrsReader, error) {
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCS client")
+	}
+	return &gcsAttrsReader{service: service, bucket: bucket, redaction: redaction}, nil
+}
+
+func (r *gcsAttrsReader) ObjectAttrs(ctx context.Context, key string) (objectAttrs, error) {
+	obj, err := r.service.Objects.Get(r.bucket, key).Context(ctx).Do()
+	if err != nil {
+		return objectAttrs{}, errors.Wrapf(err, "failed to read attributes of object %s", r.redaction.Redact(key))
+	}
+	attrs := objectAttrs{contentType: obj.ContentType}
+	if obj.KmsKeyName != "" {
+		attrs.encryption = ServerSideEncryption{Mode: SSEKMS, KMSKeyID: obj.KmsKeyName}
+	}
+	return attrs, nil
+}
@@ -0,0 +1,132 @@
+package objectstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	gcsBatchEndpoint = "https://storage.googleapis.com/batch/storage/v1"
+	// gcsMaxDeleteBatch is the maximum number of calls of a batch request
+	gcsMaxDeleteBatch = 100
+)
+
+// newGCSClient returns an HTTP client authorized for the GCS JSON API, for
+// the operations that stow does not expose
+func newGCSClient(ctx context.Context, secret *Secret) (*http.Client, error) {
+	// The client outlives ctx
+	if secret != nil {
+		if secret.Type != SecretTypeGcpServiceAccountKey || secret.Gcp == nil {
+			return nil, errors.Errorf("invalid secret type %s", secret.Type)
+		}
+		jwt, err := google.JWTConfigFromJSON([]byte(secret.Gcp.ServiceKey), storage.DevstorageReadWriteScope)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse GCP service account key")
+		}
+		return jwt.Client(context.Background()), nil
+	}
+	creds, err := google.FindDefaultCredentials(ctx, storage.DevstorageReadWriteScope)
+	if err != nil {
+		return nil, err
+	}
+	return oauth2.NewClient(context.Background(), creds.TokenSource), nil
+}
+
+var _ batchDeleter = (*gcsBatchDeleter)(nil)
+
+// gcsBatchDeleter deletes objects with batch requests of the GCS JSON API
+type gcsBatchDeleter struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+}
+
+func newGCSBatchDeleter(client *http.Client, bucket string) *gcsBatchDeleter {
+	return &gcsBatchDeleter{client: client, endpoint: gcsBatchEndpoint, bucket: bucket}
+}
+
+func (d *gcsBatchDeleter) MaxBatchSize() int {
+	return gcsMaxDeleteBatch
+}
+
+// DeleteBatch sends a multipart/mixed request with a DELETE call per key.
+// The response holds the responses of the calls, matched by Content-ID.
+func (d *gcsBatchDeleter) DeleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, k := range keys {
+		h := textproto.MIMEHeader{}
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-ID", fmt.Sprintf("<%d>", i))
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(pw, "DELETE /storage/v1/b/%s/o/%s HTTP/1.1\r\n\r\n", url.PathEscape(d.bucket), url.PathEscape(k))
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.endpoint, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	resp, err := d.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send batch request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("batch request failed: %s", resp.Status)
+	}
+	_, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse batch response")
+	}
+
+	statuses := make(map[int]*http.Response, len(keys))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		id := strings.TrimSuffix(strings.TrimPrefix(part.Header.Get("Content-ID"), "<response-"), ">")
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			continue
+		}
+		if r, err := http.ReadResponse(bufio.NewReader(part), req); err == nil {
+			r.Body.Close()
+			statuses[i] = r
+		}
+	}
+	failed := make(map[string]error)
+	for i, k := range keys {
+		r, ok := statuses[i]
+		switch {
+		case !ok:
+			failed[k] = errors.New("no response in batch")
+		case r.StatusCode == http.StatusNotFound:
+			// Already deleted
+		case r.StatusCode >= 300:
+			failed[k] = errors.New(r.Status)
+		}
+	}
+	return failed, nil
+}
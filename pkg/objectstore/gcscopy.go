@@ -0,0 +1,54 @@
+package objectstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/storage/v1"
+)
+
+var _ objectCopier = (*gcsCopier)(nil)
+
+// gcsCopier copies objects with the GCS rewrite API, which keeps the
+// metadata of the source
+type gcsCopier struct {
+	service   *storage.Service
+	bucket    string
+	redaction NameRedaction
+}
+
+func newGCSCopier(client *http.Client, bucket string, redaction NameRedaction) (*gcsCopier, error) {
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCS client")
+	}
+	return &gcsCopier{service: service, bucket: bucket, redaction: redaction}, nil
+}
+
+func (c *gcsCopier) CopyObject(ctx context.Context, srcKey, dstKey string, sse ServerSideEncryption) error {
+	kmsKeyName, err := gcsKMSKeyName(sse)
+	if err != nil {
+		return err
+	}
+	// Rewrites of large objects or across storage classes take several
+	// calls, each continuing from the token of the previous one
+	var token string
+	for {
+		call := c.service.Objects.Rewrite(c.bucket, srcKey, c.bucket, dstKey, &storage.Object{})
+		if token != "" {
+			call = call.RewriteToken(token)
+		}
+		if kmsKeyName != "" {
+			call = call.DestinationKmsKeyName(kmsKeyName)
+		}
+		resp, err := call.Context(ctx).Do()
+		if err != nil {
+			return errors.Wrapf(err, "failed to copy object %s to %s", c.redaction.Redact(srcKey), c.redaction.Redact(dstKey))
+		}
+		if resp.Done {
+			return nil
+		}
+		token = resp.RewriteToken
+	}
+}
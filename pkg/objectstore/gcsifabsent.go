@@ -0,0 +1,42 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/storage/v1"
+)
+
+var _ ifAbsentWriter = (*gcsIfAbsentWriter)(nil)
+
+// gcsIfAbsentWriter writes objects with ifGenerationMatch=0, which GCS
+// rejects with 412 Precondition Failed if the object exists
+type gcsIfAbsentWriter struct {
+	service   *storage.Service
+	bucket    string
+	redaction NameRedaction
+}
+
+func newGCSIfAbsentWriter(client *http.Client, bucket string, redaction NameRedaction) (*gcsIfAbsentWriter, error) {
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCS client")
+	}
+	return &gcsIfAbsentWriter{service: service, bucket: bucket, redaction: redaction}, nil
+}
+
+func (w *gcsIfAbsentWriter) PutIfAbsent(ctx context.Context, key string, r io.ReadSeeker, size int64, metadata map[string]string, sse ServerSideEncryption) error {
+	kmsKeyName, err := gcsKMSKeyName(sse)
+	if err != nil {
+		return err
+	}
+	call := w.service.Objects.Insert(w.bucket, &storage.Object{Name: key, Metadata: metadata}).
+		Media(r).IfGenerationMatch(0)
+	if kmsKeyName != "" {
+		call = call.KmsKeyName(kmsKeyName)
+	}
+	_, err = call.Context(ctx).Do()
+	return errors.Wrapf(err, "failed to upload object %s", w.redaction.Redact(key))
+}
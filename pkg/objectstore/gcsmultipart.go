@@ -0,0 +1,201 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	// gcsMaxComposeSources is the maximum number of objects composed at once
+	gcsMaxComposeSources = 32
+	// GCS has no limit on the number of parts, since composed objects can be
+	// composed again. Use the limit of S3.
+	gcsMaxParts = 10000
+)
+
+var _ multipartStore = (*gcsMultipart)(nil)
+
+// gcsMultipart uploads objects in parts with the GCS JSON API. GCS has no
+// multipart uploads; parts are uploaded as temporary objects that are
+// composed into the object, then deleted.
+type gcsMultipart struct {
+	service   *storage.Service
+	bucket    string
+	redaction NameRedaction
+
+	mu sync.Mutex
+	// uploads holds the uploads in progress by ID
+	uploads map[string]*gcsUpload
+}
+
+type gcsUpload struct {
+	metadata    map[string]string
+	contentType string
+	// kmsKeyName encrypts the parts and the object, if set
+	kmsKeyName string
+	parts      map[int]bool
+}
+
+func newGCSMultipart(client *http.Client, bucket string, redaction NameRedaction) (*gcsMultipart, error) {
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCS client")
+	}
+	return &gcsMultipart{
+		service:   service,
+		bucket:    bucket,
+		redaction: redaction,
+		uploads:   make(map[string]*gcsUpload),
+	}, nil
+}
+
+func (m *gcsMultipart) PartLimits() (int64, int) {
+	return 0, gcsMaxParts
+}
+
+func (m *gcsMultipart) CreateMultipart(ctx context.Context, key string, metadata map[string]string, attrs objectAttrs) (string, error) {
+	kmsKeyName, err := gcsKMSKeyName(attrs.encryption)
+	if err != nil {
+		return "", err
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	uploadID := hex.EncodeToString(id)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[uploadID] = &gcsUpload{
+		metadata:    metadata,
+		contentType: attrs.contentType,
+		kmsKeyName:  kmsKeyName,
+		parts:       make(map[int]bool),
+	}
+	return uploadID, nil
+}
+
+// partName returns the name of a temporary object of the upload. The
+// objects are stored next to the object, under a prefix unique to the
+// upload.
+func partName(key, uploadID, name string) string {
+	return fmt.Sprintf("%s.multipart-%s/%s", key, uploadID, name)
+}
+
+func (m *gcsMultipart) upload(uploadID string) (*gcsUpload, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.uploads[uploadID]
+	if !ok {
+		return nil, errors.Errorf("unknown multipart upload %s", uploadID)
+	}
+	return u, nil
+}
+
+func (m *gcsMultipart) UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	u, err := m.upload(uploadID)
+	if err != nil {
+		return "", err
+	}
+	name := partName(key, uploadID, fmt.Sprintf("%05d", number))
+	call := m.service.Objects.Insert(m.bucket, &storage.Object{Name: name}).Media(bytes.NewReader(data))
+	if u.kmsKeyName != "" {
+		call = call.KmsKeyName(u.kmsKeyName)
+	}
+	if _, err := call.Context(ctx).Do(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	u.parts[number] = true
+	m.mu.Unlock()
+	return name, nil
+}
+
+// CompleteMultipart composes the parts, at most gcsMaxComposeSources at a
+// time, and then deletes them
+func (m *gcsMultipart) CompleteMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	u, err := m.upload(uploadID)
+	if err != nil {
+		return err
+	}
+	sources := make([]string, 0, len(parts))
+	for _, p := range parts {
+		sources = append(sources, p.ETag)
+	}
+	var temps []string
+	defer func() { m.deleteObjects(temps) }()
+	for level := 0; len(sources) > gcsMaxComposeSources; level++ {
+		var composed []string
+		for i := 0; i < len(sources); i += gcsMaxComposeSources {
+			end := i + gcsMaxComposeSources
+			if end > len(sources) {
+				end = len(sources)
+			}
+			name := partName(key, uploadID, fmt.Sprintf("compose-%d-%05d", level, i/gcsMaxComposeSources))
+			temps = append(temps, name)
+			if err := m.compose(ctx, sources[i:end], &storage.Object{Name: name}, u.kmsKeyName); err != nil {
+				return err
+			}
+			composed = append(composed, name)
+		}
+		sources = composed
+	}
+	if err := m.compose(ctx, sources, &storage.Object{Name: key, Metadata: u.metadata, ContentType: u.contentType}, u.kmsKeyName); err != nil {
+		return err
+	}
+	// The parts are deleted once the object exists
+	for _, p := range parts {
+		temps = append(temps, p.ETag)
+	}
+	m.mu.Lock()
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *gcsMultipart) compose(ctx context.Context, sources []string, dest *storage.Object, kmsKeyName string) error {
+	req := &storage.ComposeRequest{Destination: dest}
+	for _, s := range sources {
+		req.SourceObjects = append(req.SourceObjects, &storage.ComposeRequestSourceObjects{Name: s})
+	}
+	call := m.service.Objects.Compose(m.bucket, dest.Name, req)
+	if kmsKeyName != "" {
+		call = call.KmsKeyName(kmsKeyName)
+	}
+	_, err := call.Context(ctx).Do()
+	return errors.Wrapf(err, "failed to compose %s", m.redaction.Redact(dest.Name))
+}
+
+func (m *gcsMultipart) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	u, err := m.upload(uploadID)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	var names []string
+	for n := range u.parts {
+		names = append(names, partName(key, uploadID, fmt.Sprintf("%05d", n)))
+	}
+	m.deleteObjects(names)
+	return nil
+}
+
+// deleteObjects deletes temporary objects. Failures are logged, since they
+// leave garbage but do not affect the object.
+func (m *gcsMultipart) deleteObjects(names []string) {
+	for _, n := range names {
+		if err := m.service.Objects.Delete(m.bucket, n).Context(context.Background()).Do(); err != nil {
+			log.WithError(err).Warnf("Failed to delete temporary object %s", m.redaction.Redact(n))
+		}
+	}
+}
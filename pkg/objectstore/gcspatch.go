@@ -0,0 +1,31 @@
+package objectstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/storage/v1"
+)
+
+var _ metadataPatcher = (*gcsMetadataPatcher)(nil)
+
+// gcsMetadataPatcher updates the metadata of objects with the GCS
+// objects.patch API, which merges the metadata entries
+type gcsMetadataPatcher struct {
+	service *storage.Service
+	bucket  string
+}
+
+func newGCSMetadataPatcher(client *http.Client, bucket string) (*gcsMetadataPatcher, error) {
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCS client")
+	}
+	return &gcsMetadataPatcher{service: service, bucket: bucket}, nil
+}
+
+func (p *gcsMetadataPatcher) PatchMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	_, err := p.service.Objects.Patch(p.bucket, key, &storage.Object{Metadata: metadata}).Context(ctx).Do()
+	return err
+}
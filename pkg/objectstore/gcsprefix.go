@@ -0,0 +1,40 @@
+package objectstore
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"google.golang.org/api/storage/v1"
+)
+
+var _ prefixLister = (*gcsPrefixLister)(nil)
+
+// gcsPrefixLister lists common prefixes with the GCS JSON API
+type gcsPrefixLister struct {
+	service *storage.Service
+	bucket  string
+}
+
+func newGCSPrefixLister(client *http.Client, bucket string) (*gcsPrefixLister, error) {
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create GCS client")
+	}
+	return &gcsPrefixLister{service: service, bucket: bucket}, nil
+}
+
+func (l *gcsPrefixLister) ListPrefixes(ctx context.Context, prefix string) ([]string, []string, error) {
+	var prefixes, keys []string
+	err := l.service.Objects.List(l.bucket).Prefix(prefix).Delimiter("/").Pages(ctx, func(objs *storage.Objects) error {
+		prefixes = append(prefixes, objs.Prefixes...)
+		for _, o := range objs.Items {
+			keys = append(keys, o.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return prefixes, keys, nil
+}
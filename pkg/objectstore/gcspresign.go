@@ -0,0 +1,114 @@
+package objectstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/storage/v1"
+)
+
+const gcsSignedURLEndpoint = "https://storage.googleapis.com"
+
+var _ presigner = (*gcsPresigner)(nil)
+
+// gcsPresigner presigns URLs with V2 signatures, which are signed by the
+// private key of a service account
+type gcsPresigner struct {
+	endpoint string
+	bucket   string
+	email    string
+	key      *rsa.PrivateKey
+}
+
+// newGCSPresigner returns a presigner that signs with the service account
+// key of the secret, or of the default credentials. It returns nil if the
+// credentials have no private key, e.g. user credentials.
+func newGCSPresigner(ctx context.Context, secret *Secret, bucket string) (presigner, error) {
+	var keyJSON []byte
+	if secret != nil {
+		if secret.Type != SecretTypeGcpServiceAccountKey || secret.Gcp == nil {
+			return nil, errors.Errorf("invalid secret type %s", secret.Type)
+		}
+		keyJSON = []byte(secret.Gcp.ServiceKey)
+	} else {
+		creds, err := google.FindDefaultCredentials(ctx, storage.DevstorageReadWriteScope)
+		if err != nil {
+			return nil, err
+		}
+		keyJSON = creds.JSON
+	}
+	jwt, err := google.JWTConfigFromJSON(keyJSON, storage.DevstorageReadOnlyScope)
+	if err != nil || len(jwt.PrivateKey) == 0 {
+		return nil, nil
+	}
+	key, err := parseRSAPrivateKey(jwt.PrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse GCP service account key")
+	}
+	return &gcsPresigner{endpoint: gcsSignedURLEndpoint, bucket: bucket, email: jwt.Email, key: key}, nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS #8 or PKCS #1 key
+func parseRSAPrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block != nil {
+		data = block.Bytes
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(data); err == nil {
+		rk, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("private key is not an RSA key")
+		}
+		return rk, nil
+	}
+	return x509.ParsePKCS1PrivateKey(data)
+}
+
+func (p *gcsPresigner) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return p.presign(ctx, "GET", key, expiry)
+}
+
+func (p *gcsPresigner) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	return p.presign(ctx, "PUT", key, expiry)
+}
+
+// presign signs the string described in
+// https://cloud.google.com/storage/docs/access-control/signed-urls-v2, with
+// an empty Content-MD5 and Content-Type
+func (p *gcsPresigner) presign(ctx context.Context, method, key string, expiry time.Duration) (string, error) {
+	expires := clockFor(ctx, nil).Now().Add(expiry).Unix()
+	resource := "/" + p.bucket + "/" + escapeObjectPath(key)
+	toSign := fmt.Sprintf("%s\n\n\n%d\n%s", method, expires, resource)
+	h := sha256.Sum256([]byte(toSign))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, h[:])
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set("GoogleAccessId", p.email)
+	q.Set("Expires", fmt.Sprintf("%d", expires))
+	q.Set("Signature", base64.StdEncoding.EncodeToString(sig))
+	return p.endpoint + resource + "?" + q.Encode(), nil
+}
+
+// escapeObjectPath escapes the segments of the object key, keeping the
+// slashes between them
+func escapeObjectPath(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = url.PathEscape(s)
+	}
+	return strings.Join(segments, "/")
+}
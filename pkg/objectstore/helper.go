@@ -3,7 +3,7 @@ package objectstore
 import "context"
 
 // GetOrCreateBucket is a helper function to access the package level getOrCreateBucket
-func GetOrCreateBucket(ctx context.Context, p Provider, bucketName string, region string) (Directory, error) {
+func GetOrCreateBucket(ctx context.Context, p Provider, bucketName string, region string) (Bucket, error) {
 	return p.getOrCreateBucket(ctx, bucketName, region)
 }
 
@@ -0,0 +1,175 @@
+package objectstore
+
+// Idempotency keys, which let a retried phase tell whether a previous
+// attempt already completed its writes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// IdempotencyKeyTag is the tag in which writes record their idempotency
+	// key
+	IdempotencyKeyTag = "kanister-idempotency-key"
+	// IdempotencyRecordDir is the directory, relative to the directory
+	// passed to MarkApplied, that holds the records of applied keys
+	IdempotencyRecordDir = ".kanister-idempotency"
+	// DefaultIdempotencyTTL is the time for which keys are recorded if
+	// MarkApplied is not given a TTL
+	DefaultIdempotencyTTL = 7 * 24 * time.Hour
+)
+
+// IdempotencyRecord records that the writes of an idempotency key were
+// applied
+type IdempotencyRecord struct {
+	Key       string    `json:"key"`
+	AppliedAt time.Time `json:"appliedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type idempotencyKeyKey struct{}
+
+// WithIdempotencyKey returns a context in which puts record key in the
+// IdempotencyKeyTag of the objects they write. The WithIdempotency option
+// takes precedence.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyKey{}, key)
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyKey{}).(string)
+	return key
+}
+
+// withIdempotencyTag returns tags with the idempotency key, if set
+func withIdempotencyTag(tags map[string]string, key string) map[string]string {
+	if key == "" {
+		return tags
+	}
+	t := make(map[string]string, len(tags)+1)
+	for k, v := range tags {
+		t[k] = v
+	}
+	t[IdempotencyKeyTag] = key
+	return t
+}
+
+// idempotencyRecordName returns the name of the record of key, relative to
+// IdempotencyRecordDir. Keys are hashed, since they may contain characters
+// that are not valid in object names.
+func idempotencyRecordName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
+
+func idempotencyRecordDir(d Directory) (*directory, error) {
+	dir, err := asDirectory(d)
+	if err != nil {
+		return nil, err
+	}
+	if dir.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+	return &directory{bucket: dir.bucket, path: dir.absDirName(IdempotencyRecordDir)}, nil
+}
+
+// readIdempotencyRecord reads the record name in rd, and returns nil if it
+// does not exist
+func readIdempotencyRecord(ctx context.Context, rd *directory, name string) (*IdempotencyRecord, error) {
+	data, _, err := rd.GetBytes(ctx, name)
+	if IsObjectNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read idempotency record in %s", describe(rd))
+	}
+	var r IdempotencyRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse idempotency record in %s", describe(rd))
+	}
+	return &r, nil
+}
+
+// AlreadyApplied returns true if MarkApplied recorded key in d, and the
+// record has not expired
+func AlreadyApplied(ctx context.Context, d Directory, key string) (bool, error) {
+	rd, err := idempotencyRecordDir(d)
+	if err != nil {
+		return false, err
+	}
+	r, err := readIdempotencyRecord(ctx, rd, idempotencyRecordName(key))
+	if err != nil || r == nil {
+		return false, err
+	}
+	return r.Key == key && clockFor(ctx, rd.bucket).Now().Before(r.ExpiresAt), nil
+}
+
+// MarkApplied records in d that the writes of key were applied, for ttl or
+// DefaultIdempotencyTTL if ttl is 0. It is called once all the writes
+// succeeded. A record that has not expired is kept, so that the first
+// attempt to complete is the one recorded. The record is written by a
+// single put, so readers never see a partial record; providers do not
+// offer conditional puts, so concurrent attempts may both write a record.
+func MarkApplied(ctx context.Context, d Directory, key string, ttl time.Duration) error {
+	if ttl < 0 {
+		return errors.Errorf("invalid idempotency TTL %s", ttl)
+	}
+	if ttl == 0 {
+		ttl = DefaultIdempotencyTTL
+	}
+	rd, err := idempotencyRecordDir(d)
+	if err != nil {
+		return err
+	}
+	name := idempotencyRecordName(key)
+	now := clockFor(ctx, rd.bucket).Now()
+	r, err := readIdempotencyRecord(ctx, rd, name)
+	if err != nil {
+		return err
+	}
+	if r != nil && r.Key == key && now.Before(r.ExpiresAt) {
+		return nil
+	}
+	data, err := json.Marshal(IdempotencyRecord{Key: key, AppliedAt: now, ExpiresAt: now.Add(ttl)})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode idempotency record")
+	}
+	return rd.PutBytes(ctx, name, data, nil)
+}
+
+// SweepIdempotencyRecords deletes the expired records of d and returns the
+// number of records deleted. Records that cannot be parsed are kept.
+func SweepIdempotencyRecords(ctx context.Context, d Directory) (int, error) {
+	rd, err := idempotencyRecordDir(d)
+	if err != nil {
+		return 0, err
+	}
+	names, err := rd.ListObjects(ctx)
+	if err != nil {
+		return 0, err
+	}
+	now := clockFor(ctx, rd.bucket).Now()
+	var deleted int
+	for _, name := range names {
+		r, err := readIdempotencyRecord(ctx, rd, name)
+		switch {
+		case err != nil:
+			log.WithError(err).Warn("Skipping idempotency record")
+			continue
+		case r == nil || now.Before(r.ExpiresAt):
+			continue
+		}
+		if err := rd.Delete(ctx, name); err != nil && !IsObjectNotFound(err) {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
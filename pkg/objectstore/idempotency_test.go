@@ -0,0 +1,96 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type IdempotencySuite struct{}
+
+var _ = Suite(&IdempotencySuite{})
+
+func (s *IdempotencySuite) TestTags(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("idempotency")
+	data := []byte("artifact")
+	err := b.PutOpts(ctx, "opt", bytes.NewReader(data), int64(len(data)), map[string]string{"key": "value"}, WithIdempotency("phase-1"))
+	c.Assert(err, IsNil)
+	_, tags, err := b.GetBytes(ctx, "opt")
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"key": "value", IdempotencyKeyTag: "phase-1"})
+
+	kctx := WithIdempotencyKey(ctx, "phase-2")
+	c.Assert(b.PutBytes(kctx, "ctx", data, nil), IsNil)
+	_, tags, err = b.GetBytes(ctx, "ctx")
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{IdempotencyKeyTag: "phase-2"})
+
+	// The option takes precedence over the context
+	err = b.PutOpts(kctx, "both", bytes.NewReader(data), int64(len(data)), nil, WithIdempotency("phase-1"))
+	c.Assert(err, IsNil)
+	_, tags, err = b.GetBytes(ctx, "both")
+	c.Assert(err, IsNil)
+	c.Assert(tags[IdempotencyKeyTag], Equals, "phase-1")
+}
+
+func (s *IdempotencySuite) TestApplied(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("idempotency")
+	clk := newFakeClock()
+	b.clock = clk
+	d, err := b.CreateDirectory(ctx, "artifacts")
+	c.Assert(err, IsNil)
+
+	applied, err := AlreadyApplied(ctx, d, "phase/1")
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, false)
+	c.Assert(MarkApplied(ctx, d, "phase/1", time.Hour), IsNil)
+	applied, err = AlreadyApplied(ctx, d, "phase/1")
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, true)
+	// Keys are recorded per directory
+	applied, err = AlreadyApplied(ctx, b, "phase/1")
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, false)
+
+	// The first record is kept until it expires
+	clk.Advance(30 * time.Minute)
+	c.Assert(MarkApplied(ctx, d, "phase/1", time.Hour), IsNil)
+	clk.Advance(30 * time.Minute)
+	applied, err = AlreadyApplied(ctx, d, "phase/1")
+	c.Assert(err, IsNil)
+	c.Assert(applied, Equals, false)
+
+	c.Assert(MarkApplied(ctx, d, "phase/1", -time.Hour), ErrorMatches, "invalid idempotency TTL .*")
+}
+
+func (s *IdempotencySuite) TestSweep(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("idempotency")
+	clk := newFakeClock()
+	b.clock = clk
+	c.Assert(MarkApplied(ctx, b, "old", time.Hour), IsNil)
+	c.Assert(MarkApplied(ctx, b, "new", 0), IsNil)
+	c.Assert(b.PutBytes(ctx, IdempotencyRecordDir+"/corrupt.json", []byte("{"), nil), IsNil)
+
+	n, err := SweepIdempotencyRecords(ctx, b)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+	clk.Advance(2 * time.Hour)
+	n, err = SweepIdempotencyRecords(ctx, b)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(mc.items[IdempotencyRecordDir+"/"+idempotencyRecordName("old")], IsNil)
+	c.Assert(mc.items[IdempotencyRecordDir+"/"+idempotencyRecordName("new")], NotNil)
+	c.Assert(mc.items[IdempotencyRecordDir+"/corrupt.json"], NotNil)
+
+	// Directories without records have nothing to sweep
+	d, err := b.CreateDirectory(ctx, "empty")
+	c.Assert(err, IsNil)
+	n, err = SweepIdempotencyRecords(ctx, d)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+}
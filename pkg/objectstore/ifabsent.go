@@ -0,0 +1,157 @@
+package objectstore
+
+// Conditional writes, which create objects only if they do not exist, e.g.
+// for the lease and lock objects of controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+)
+
+// ErrObjectExists is returned by PutIfNotExists for objects that exist
+type ErrObjectExists struct {
+	Name string
+}
+
+func (e *ErrObjectExists) Error() string {
+	return fmt.Sprintf("object %s already exists", e.Name)
+}
+
+// IsObjectExists returns true if the cause of err is an ErrObjectExists
+func IsObjectExists(err error) bool {
+	_, ok := errors.Cause(err).(*ErrObjectExists)
+	return ok
+}
+
+// ifAbsentWriter writes objects only if they do not exist, with the
+// conditional requests of the provider, which stow does not expose. Keys
+// are names in the container.
+type ifAbsentWriter interface {
+	// PutIfAbsent fails with a 412 Precondition Failed error if the
+	// object exists
+	PutIfAbsent(ctx context.Context, key string, r io.ReadSeeker, size int64, metadata map[string]string, sse ServerSideEncryption) error
+}
+
+// preconditionStatusRe matches the status of failed preconditions in the
+// messages of providers other than S3, e.g. "googleapi: Error 412:
+// Precondition Failed"
+var preconditionStatusRe = regexp.MustCompile(`\b412\b`)
+
+// isPreconditionFailed returns true if err reports that the precondition
+// of a conditional request did not hold
+func isPreconditionFailed(err error) bool {
+	switch e := errors.Cause(err).(type) {
+	case nil:
+		return false
+	case awserr.RequestFailure:
+		return e.StatusCode() == 412 || e.Code() == "PreconditionFailed"
+	case *azureRequestError:
+		return e.StatusCode == 412 || e.Code == "BlobAlreadyExists"
+	}
+	return preconditionStatusRe.MatchString(errors.Cause(err).Error())
+}
+
+// PutIfNotExists writes the object <bucket>/<d.path>/name unless it exists,
+// in which case it returns an ErrObjectExists. S3, GCS and Azure check and
+// write the object in one conditional request, so only one of several
+// concurrent writers succeeds. Other providers check whether the object
+// exists before writing it, and concurrent writers may all succeed. The
+// object is written in one request, without compression, and encrypted with
+// the ServerSideEncryption and the Keyring of the bucket.
+func (d *directory) PutIfNotExists(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	sse, err := d.bucket.encryption(nil, d.bucket.ifAbsentWriter != nil)
+	if err != nil {
+		return err
+	}
+	sl, err := d.bucket.putSealer(putSettings{})
+	if err != nil {
+		return err
+	}
+	if sl != nil {
+		tags = withOriginalSize(sl.withTags(tags), size)
+	}
+	sTags := sanitizeTags(withIdempotencyTag(tags, idempotencyKeyFromContext(ctx)))
+
+	objName, err := d.objectName(name)
+	if err != nil {
+		return err
+	}
+	if err := d.bucket.limits.validate(objName, d.bucket.redaction); err != nil {
+		return err
+	}
+	if err := d.bucket.validateSize(objName, size); err != nil {
+		return err
+	}
+	if err := checkQuota(ctx, d.bucket, size); err != nil {
+		return err
+	}
+	if err := d.bucket.registerLayoutFeatures(ctx, putLayoutFeatures(nil, sl, false)...); err != nil {
+		return err
+	}
+
+	if sl != nil {
+		r, size = sl.reader(r, size)
+	}
+	// Conditional requests are retried from the start of the data
+	rs, ok := r.(io.ReadSeeker)
+	if !ok {
+		sr, n, err := spoolToFile(r, size, "")
+		if err != nil {
+			return errors.Wrapf(err, "failed to spool object %s", d.bucket.redaction.Redact(objName))
+		}
+		defer sr.Close()
+		rs, size = sr.(io.ReadSeeker), n
+	}
+	err = d.putIfNotExists(ctx, objName, rs, size, sTags, sse)
+	d.bucket.listingCache.invalidate(objName)
+	if err != nil {
+		return err
+	}
+	addUsage(ctx, d.bucket, size)
+	return nil
+}
+
+func (d *directory) putIfNotExists(ctx context.Context, objName string, r io.ReadSeeker, size int64, tags map[string]interface{}, sse ServerSideEncryption) error {
+	name := d.bucket.redaction.Redact(objName)
+	cw := d.bucket.ifAbsentWriter
+	if cw == nil {
+		// The object may be created between the check and the write
+		exists, err := d.Exists(ctx, objName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return &ErrObjectExists{Name: name}
+		}
+		return retryPut(ctx, d.bucket, objName, r, size, tags)
+	}
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read the data of %s", name)
+	}
+	// A retry of a write that succeeded without a response reports that
+	// the object exists
+	return retry(ctx, d.bucket, "conditional upload of "+name, func() error {
+		if _, err := r.Seek(start, io.SeekStart); err != nil {
+			return errors.Wrapf(err, "failed to rewind the data of %s", name)
+		}
+		err := cw.PutIfAbsent(ctx, cloudName(objName), r, size, stringTags(tags), sse)
+		if isPreconditionFailed(err) {
+			return &ErrObjectExists{Name: name}
+		}
+		return err
+	})
+}
@@ -0,0 +1,195 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type IfAbsentSuite struct{}
+
+var _ = Suite(&IfAbsentSuite{})
+
+// memIfAbsentWriter writes the items of a memContainer if they do not
+// exist, after failing the first writes with transient errors
+type memIfAbsentWriter struct {
+	c        *memContainer
+	failures int
+	writes   int
+}
+
+func (w *memIfAbsentWriter) PutIfAbsent(ctx context.Context, key string, r io.ReadSeeker, size int64, metadata map[string]string, sse ServerSideEncryption) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	md := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		md[k] = v
+	}
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.writes++
+	if w.failures > 0 {
+		w.failures--
+		return errors.New("googleapi: Error 503: Backend Error")
+	}
+	if _, ok := w.c.items[key]; ok {
+		return errors.New("googleapi: Error 412: Precondition Failed")
+	}
+	w.c.items[key] = &memItem{name: key, data: data, metadata: md, lastMod: time.Now()}
+	return nil
+}
+
+func (s *IfAbsentSuite) TestPutIfNotExists(c *C) {
+	ctx := context.Background()
+	for _, conditional := range []bool{false, true} {
+		b, mc := newMemBucket("ifabsent")
+		if conditional {
+			b.ifAbsentWriter = &memIfAbsentWriter{c: mc}
+		}
+		d, err := b.CreateDirectory(ctx, "leases")
+		c.Assert(err, IsNil)
+		tags := map[string]string{"kanister.io/owner": "a"}
+		c.Assert(d.PutIfNotExists(ctx, "lock", bytes.NewReader([]byte("a")), 1, tags), IsNil)
+
+		err = d.PutIfNotExists(ctx, "lock", bytes.NewReader([]byte("b")), 1, nil)
+		c.Assert(IsObjectExists(err), Equals, true)
+		c.Assert(err, ErrorMatches, "object /leases/lock already exists")
+		data, gotTags, err := d.GetBytes(ctx, "lock")
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "a")
+		c.Assert(gotTags, DeepEquals, map[string]string{"kanister.io/owner": "a"})
+		ok, err := d.Exists(ctx, "lock")
+		c.Assert(err, IsNil)
+		c.Assert(ok, Equals, true)
+	}
+}
+
+func (s *IfAbsentSuite) TestConcurrentWriters(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("concurrent")
+	b.ifAbsentWriter = &memIfAbsentWriter{c: mc}
+	const writers = 10
+	errs := make(chan error, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- b.PutIfNotExists(ctx, "lease", bytes.NewReader([]byte{byte(i)}), 1, nil)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	var won int
+	for err := range errs {
+		if err == nil {
+			won++
+			continue
+		}
+		c.Assert(IsObjectExists(err), Equals, true, Commentf("%v", err))
+	}
+	c.Assert(won, Equals, 1)
+}
+
+func (s *IfAbsentSuite) TestRetry(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("retry")
+	b.retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	w := &memIfAbsentWriter{c: mc, failures: 2}
+	b.ifAbsentWriter = w
+	// Data that cannot be rewound is spooled, and retried from its start
+	data := bytes.Repeat([]byte("kanister"), 100)
+	c.Assert(b.PutIfNotExists(ctx, "obj", ioutil.NopCloser(bytes.NewReader(data)), -1, nil), IsNil)
+	c.Assert(w.writes, Equals, 3)
+	c.Assert(mc.items["obj"].data, DeepEquals, data)
+
+	// Readers are rewound to where they were
+	r := bytes.NewReader(data)
+	_, err := r.Seek(8, io.SeekStart)
+	c.Assert(err, IsNil)
+	w.failures = 1
+	c.Assert(b.PutIfNotExists(ctx, "rest", r, int64(len(data)-8), nil), IsNil)
+	c.Assert(mc.items["rest"].data, DeepEquals, data[8:])
+
+	w.failures = 3
+	err = b.PutIfNotExists(ctx, "failed", bytes.NewReader(data), int64(len(data)), nil)
+	c.Assert(err, ErrorMatches, ".*Error 503.*")
+	c.Assert(IsObjectExists(err), Equals, false)
+}
+
+func (s *IfAbsentSuite) TestPreconditionFailed(c *C) {
+	for _, tc := range []struct {
+		err    error
+		failed bool
+	}{
+		{err: nil, failed: false},
+		{err: awserr.NewRequestFailure(awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil), 412, "id"), failed: true},
+		{err: errors.Wrap(awserr.NewRequestFailure(awserr.New("PreconditionFailed", "", nil), 412, "id"), "failed to upload object"), failed: true},
+		{err: awserr.NewRequestFailure(awserr.New("ConditionalRequestConflict", "", nil), 409, "id"), failed: false},
+		{err: errors.New("googleapi: Error 412: Precondition Failed, conditionNotMet"), failed: true},
+		{err: errors.New("googleapi: Error 503: Backend Error"), failed: false},
+		{err: errors.Wrap(&azureRequestError{StatusCode: 409, Code: "BlobAlreadyExists"}, "failed to upload object"), failed: true},
+		{err: &azureRequestError{StatusCode: 412, Code: "ConditionNotMet"}, failed: true},
+		{err: &azureRequestError{StatusCode: 409, Code: "LeaseIdMissing"}, failed: false},
+	} {
+		c.Check(isPreconditionFailed(tc.err), Equals, tc.failed, Commentf("%v", tc.err))
+	}
+}
+
+func (s *IfAbsentSuite) TestAzureIfAbsentWriter(c *C) {
+	ctx := context.Background()
+	// The blob service creates blobs with If-None-Match: * only if they do
+	// not exist
+	var mu sync.Mutex
+	blobs := make(map[string][]byte)
+	metadata := make(map[string]string)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, http.MethodPut)
+		c.Check(r.Header.Get("If-None-Match"), Equals, "*")
+		c.Check(r.Header.Get("x-ms-blob-type"), Equals, "BlockBlob")
+		c.Check(r.URL.Query().Get("sp"), Equals, "cw")
+		data, err := ioutil.ReadAll(r.Body)
+		c.Check(err, IsNil)
+		mu.Lock()
+		defer mu.Unlock()
+		if _, ok := blobs[r.URL.Path]; ok {
+			w.Header().Set("x-ms-error-code", "BlobAlreadyExists")
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		blobs[r.URL.Path] = data
+		metadata[r.URL.Path] = r.Header.Get("x-ms-meta-owner")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	b, _ := newMemBucket("azure")
+	b.ifAbsentWriter = newAzureIfAbsentWriter(&azurePresigner{
+		endpoint:  srv.URL,
+		account:   "account",
+		key:       []byte("account key"),
+		container: "container",
+	}, b.redaction)
+	c.Assert(b.PutIfNotExists(ctx, "lock", bytes.NewReader([]byte("a")), 1, map[string]string{"owner": "a"}), IsNil)
+	err := b.PutIfNotExists(ctx, "lock", bytes.NewReader([]byte("b")), 1, nil)
+	c.Assert(IsObjectExists(err), Equals, true)
+	c.Assert(b.PutIfNotExists(ctx, "empty", bytes.NewReader(nil), 0, nil), IsNil)
+	c.Assert(blobs, DeepEquals, map[string][]byte{"/container/lock": []byte("a"), "/container/empty": {}})
+	c.Assert(metadata["/container/lock"], Equals, "a")
+
+	// Encryption by the provider is not supported
+	b.sse = ServerSideEncryption{Mode: SSEAES256}
+	err = b.PutIfNotExists(ctx, "encrypted", bytes.NewReader([]byte("a")), 1, nil)
+	c.Assert(IsOptionNotSupported(err), Equals, true)
+}
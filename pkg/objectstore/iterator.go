@@ -0,0 +1,75 @@
+package objectstore
+
+// Iteration over the objects of directories, a page at a time
+
+import (
+	"context"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// ErrIteratorDone is returned by ObjectIterator.Next once all objects have
+// been returned
+var ErrIteratorDone = errors.New("no more objects")
+
+// ObjectIterator returns the objects of a directory one by one. Only the
+// current page of objects is held, so an iterator can be abandoned at any
+// time.
+type ObjectIterator interface {
+	// Next returns the next object, or ErrIteratorDone once all objects
+	// have been returned. The next page is listed with ctx, and a failed
+	// listing is retried by the next call.
+	Next(ctx context.Context) (ObjectInfo, error)
+}
+
+// ObjectIteratorOptions describes how Objects iterates over objects
+type ObjectIteratorOptions struct {
+	// PageSize is the number of objects listed at once. Defaults to the
+	// page size of the provider.
+	PageSize int
+}
+
+var _ ObjectIterator = (*objectIterator)(nil)
+
+type objectIterator struct {
+	d        *directory
+	pageSize int
+	cursor   string
+	page     []ObjectInfo
+	done     bool
+}
+
+// Objects returns an iterator over the files that have d.dirname as the
+// prefix, in the order of ListObjects
+func (d *directory) Objects(ctx context.Context, opts ObjectIteratorOptions) (ObjectIterator, error) {
+	if d.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+	if opts.PageSize < 0 {
+		return nil, errors.Errorf("invalid page size %d", opts.PageSize)
+	}
+	if opts.PageSize == 0 {
+		opts.PageSize = d.bucket.pageSize
+	}
+	return &objectIterator{d: d, pageSize: opts.PageSize, cursor: stow.CursorStart}, nil
+}
+
+func (it *objectIterator) Next(ctx context.Context) (ObjectInfo, error) {
+	for len(it.page) == 0 {
+		if it.done {
+			return ObjectInfo{}, ErrIteratorDone
+		}
+		if err := ctx.Err(); err != nil {
+			return ObjectInfo{}, err
+		}
+		page, next, err := it.d.listObjectsPage(ctx, it.cursor, it.pageSize)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		it.page, it.cursor, it.done = page, next, next == ""
+	}
+	info := it.page[0]
+	it.page = it.page[1:]
+	return info, nil
+}
@@ -0,0 +1,70 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type IteratorSuite struct{}
+
+var _ = Suite(&IteratorSuite{})
+
+func (s *IteratorSuite) TestObjects(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("iter")
+	pc := &pageSizeContainer{memContainer: mc, counts: make(map[int]int)}
+	b := newBucket(ProviderConfig{}, pc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 7; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%d", i), []byte("data"), nil), IsNil)
+	}
+	c.Assert(d.PutBytes(ctx, "sub/obj", []byte("data"), nil), IsNil)
+
+	it, err := d.Objects(ctx, ObjectIteratorOptions{PageSize: 3})
+	c.Assert(err, IsNil)
+	var names []string
+	for {
+		info, err := it.Next(ctx)
+		if err == ErrIteratorDone {
+			break
+		}
+		c.Assert(err, IsNil)
+		c.Assert(info.Size, Equals, int64(4))
+		names = append(names, info.Name)
+	}
+	expected, err := d.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(names, DeepEquals, expected)
+	c.Assert(names, HasLen, 7)
+	c.Assert(pc.counts[3] > 0, Equals, true)
+	_, err = it.Next(ctx)
+	c.Assert(err, Equals, ErrIteratorDone)
+
+	// Iterators can be abandoned, and stop at the next page once ctx is
+	// cancelled
+	it, err = d.Objects(ctx, ObjectIteratorOptions{PageSize: 2})
+	c.Assert(err, IsNil)
+	_, err = it.Next(ctx)
+	c.Assert(err, IsNil)
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = it.Next(cctx)
+	c.Assert(err, IsNil)
+	_, err = it.Next(cctx)
+	c.Assert(err, Equals, context.Canceled)
+	// Failed pages are listed again
+	_, err = it.Next(ctx)
+	c.Assert(err, IsNil)
+
+	_, err = d.Objects(ctx, ObjectIteratorOptions{PageSize: -1})
+	c.Assert(err, ErrorMatches, "invalid page size -1")
+	empty, err := d.CreateDirectory(ctx, "empty")
+	c.Assert(err, IsNil)
+	it, err = empty.Objects(ctx, ObjectIteratorOptions{})
+	c.Assert(err, IsNil)
+	_, err = it.Next(ctx)
+	c.Assert(err, Equals, ErrIteratorDone)
+}
@@ -0,0 +1,188 @@
+package objectstore
+
+// Layout markers for Kanister managed directory trees
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// LayoutObjectName is the name of the object that describes the layout
+	// of a Kanister managed directory tree. It is stored at the root of the
+	// tree.
+	LayoutObjectName = ".kanister-layout.json"
+	// LayoutVersion is the newest layout format version understood by this
+	// version of Kanister
+	LayoutVersion = 1
+	// PlainLayoutVersion is reported for trees without a layout marker
+	PlainLayoutVersion = 0
+)
+
+// Features registered in the layout marker at the root of a bucket by the
+// writes that use them
+const (
+	// LayoutFeatureCompression is used by objects compressed with a Codec
+	LayoutFeatureCompression = "compression"
+	// LayoutFeatureEncryption is used by objects encrypted with a Keyring
+	LayoutFeatureEncryption = "encryption"
+	// LayoutFeatureObjectTags is used by objects whose object tags are
+	// kept in their metadata, on providers without object tags
+	LayoutFeatureObjectTags = "object-tags"
+)
+
+// Layout describes the physical layout of a Kanister managed directory tree
+type Layout struct {
+	// Version of the layout format
+	Version int `json:"version"`
+	// Features that change the layout of the tree, e.g. packing or sharding
+	Features []string `json:"features,omitempty"`
+}
+
+// HasFeature returns true if the layout uses the named feature
+func (l Layout) HasFeature(feature string) bool {
+	for _, f := range l.Features {
+		if f == feature {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrLayoutTooNew is returned when a tree was written with a layout format
+// version newer than this version of Kanister understands
+type ErrLayoutTooNew struct {
+	Version   int
+	Supported int
+}
+
+func (e *ErrLayoutTooNew) Error() string {
+	return fmt.Sprintf("layout version %d is newer than the supported version %d", e.Version, e.Supported)
+}
+
+// IsLayoutTooNew returns true if the cause of err is an ErrLayoutTooNew
+func IsLayoutTooNew(err error) bool {
+	_, ok := errors.Cause(err).(*ErrLayoutTooNew)
+	return ok
+}
+
+// ReadLayout reads the layout marker at the root of the tree d. Trees
+// without a marker have a plain layout, with version PlainLayoutVersion and
+// no features.
+func ReadLayout(ctx context.Context, d Directory) (Layout, error) {
+	data, _, err := d.GetBytes(ctx, LayoutObjectName)
+	if IsObjectNotFound(err) {
+		return Layout{Version: PlainLayoutVersion}, nil
+	}
+	if err != nil {
+		return Layout{}, errors.Wrapf(err, "failed to read layout marker in %s", describe(d))
+	}
+	var l Layout
+	if err := json.Unmarshal(data, &l); err != nil {
+		return Layout{}, errors.Wrapf(err, "failed to parse layout marker in %s", describe(d))
+	}
+	if l.Version > LayoutVersion {
+		return Layout{}, errors.Wrapf(&ErrLayoutTooNew{Version: l.Version, Supported: LayoutVersion}, "unsupported layout in %s", describe(d))
+	}
+	return l, nil
+}
+
+// WriteLayout writes the layout marker at the root of the tree d. A zero
+// version is written as LayoutVersion.
+func WriteLayout(ctx context.Context, d Directory, l Layout) error {
+	if l.Version == PlainLayoutVersion {
+		l.Version = LayoutVersion
+	}
+	if l.Version > LayoutVersion {
+		return &ErrLayoutTooNew{Version: l.Version, Supported: LayoutVersion}
+	}
+	sort.Strings(l.Features)
+	data, err := json.Marshal(l)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode layout marker")
+	}
+	// The marker is neither compressed nor encrypted, so that any reader
+	// can read it
+	return d.PutOpts(ctx, LayoutObjectName, bytes.NewReader(data), int64(len(data)), nil, asStored())
+}
+
+// RegisterLayoutFeature records that feature is used in the tree d. Features
+// that change the physical layout of a tree must register themselves before
+// writing, so that older readers fail cleanly instead of misreading the tree.
+func RegisterLayoutFeature(ctx context.Context, d Directory, feature string) error {
+	l, err := ReadLayout(ctx, d)
+	if err != nil {
+		return err
+	}
+	if l.HasFeature(feature) {
+		return nil
+	}
+	l.Features = append(l.Features, feature)
+	return WriteLayout(ctx, d, l)
+}
+
+// layoutFeatures are the features registered in the layout marker at the
+// root of a bucket by this process
+type layoutFeatures struct {
+	mu         sync.Mutex
+	registered map[string]bool
+}
+
+func newLayoutFeatures() *layoutFeatures {
+	return &layoutFeatures{registered: make(map[string]bool)}
+}
+
+// registerLayoutFeatures registers the features in the layout marker at the
+// root of the bucket, before the first write that uses them. The marker
+// itself uses no feature.
+func (b *bucket) registerLayoutFeatures(ctx context.Context, features ...string) error {
+	if len(features) == 0 {
+		return nil
+	}
+	b.layout.mu.Lock()
+	defer b.layout.mu.Unlock()
+	for _, f := range features {
+		if b.layout.registered[f] {
+			continue
+		}
+		if err := RegisterLayoutFeature(ctx, b.directory, f); err != nil {
+			return errors.Wrapf(err, "failed to register layout feature %s", f)
+		}
+		b.layout.registered[f] = true
+	}
+	return nil
+}
+
+// putLayoutFeatures returns the features used by a write with the codec and
+// the sealer, and with object tags in the metadata if objectTags is true
+func putLayoutFeatures(codec Codec, sl *sealer, objectTags bool) []string {
+	var features []string
+	if codec != nil {
+		features = append(features, LayoutFeatureCompression)
+	}
+	if sl != nil {
+		features = append(features, LayoutFeatureEncryption)
+	}
+	if objectTags {
+		features = append(features, LayoutFeatureObjectTags)
+	}
+	return features
+}
+
+// withoutLayoutMarker returns the listed objects other than the layout
+// marker
+func withoutLayoutMarker(infos []ObjectInfo) []ObjectInfo {
+	objects := make([]ObjectInfo, 0, len(infos))
+	for _, info := range infos {
+		if info.Name != LayoutObjectName {
+			objects = append(objects, info)
+		}
+	}
+	return objects
+}
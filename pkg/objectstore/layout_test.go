@@ -0,0 +1,96 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+type LayoutSuite struct{}
+
+var _ = Suite(&LayoutSuite{})
+
+func (s *LayoutSuite) TestPlainLayout(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("layout")
+	d, err := b.CreateDirectory(ctx, "tree")
+	c.Assert(err, IsNil)
+	err = d.PutBytes(ctx, "obj", []byte("data"), nil)
+	c.Assert(err, IsNil)
+
+	l, err := ReadLayout(ctx, d)
+	c.Assert(err, IsNil)
+	c.Assert(l, DeepEquals, Layout{Version: PlainLayoutVersion})
+	data, _, err := d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+}
+
+func (s *LayoutSuite) TestRegisterFeature(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("layout")
+	d, err := b.CreateDirectory(ctx, "tree")
+	c.Assert(err, IsNil)
+
+	c.Assert(RegisterLayoutFeature(ctx, d, "sharding"), IsNil)
+	c.Assert(RegisterLayoutFeature(ctx, d, "packing"), IsNil)
+	c.Assert(RegisterLayoutFeature(ctx, d, "sharding"), IsNil)
+	l, err := ReadLayout(ctx, d)
+	c.Assert(err, IsNil)
+	c.Assert(l, DeepEquals, Layout{Version: LayoutVersion, Features: []string{"packing", "sharding"}})
+	c.Assert(l.HasFeature("packing"), Equals, true)
+	c.Assert(l.HasFeature("catalog"), Equals, false)
+}
+
+func (s *LayoutSuite) TestFutureLayout(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("layout")
+	err := b.PutBytes(ctx, LayoutObjectName, []byte(`{"version": 99, "features": ["hologram"]}`), nil)
+	c.Assert(err, IsNil)
+
+	_, err = ReadLayout(ctx, b)
+	c.Assert(err, NotNil)
+	c.Assert(IsLayoutTooNew(err), Equals, true)
+	c.Assert(err, ErrorMatches, ".*layout version 99 is newer than the supported version 1")
+	// Must not be overwritten by an older writer
+	c.Assert(IsLayoutTooNew(RegisterLayoutFeature(ctx, b, "packing")), Equals, true)
+	c.Assert(IsLayoutTooNew(WriteLayout(ctx, b, Layout{Version: 99})), Equals, true)
+
+	err = b.PutBytes(ctx, LayoutObjectName, []byte("not json"), nil)
+	c.Assert(err, IsNil)
+	_, err = ReadLayout(ctx, b)
+	c.Assert(err, NotNil)
+	c.Assert(IsLayoutTooNew(err), Equals, false)
+}
+
+func (s *LayoutSuite) TestPutRegistersFeatures(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("layout")
+	d, err := b.CreateDirectory(ctx, "tree")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "plain", []byte("data"), nil), IsNil)
+	_, ok := mc.items[LayoutObjectName]
+	c.Assert(ok, Equals, false)
+
+	b.compression = CodecGzip
+	c.Assert(d.PutBytes(ctx, "compressed", []byte("data"), nil), IsNil)
+	b.keyring = newTestKeyring(c, "a", "a")
+	c.Assert(d.PutBytes(ctx, "encrypted", []byte("data"), nil), IsNil)
+	err = d.PutOpts(ctx, "tagged", bytes.NewReader([]byte("data")), 4, nil, WithObjectTags(map[string]string{"type": "manifest"}))
+	c.Assert(err, IsNil)
+	l, err := ReadLayout(ctx, b)
+	c.Assert(err, IsNil)
+	c.Assert(l.Features, DeepEquals, []string{LayoutFeatureCompression, LayoutFeatureEncryption, LayoutFeatureObjectTags})
+	// The marker is readable without the codec and the keyring
+	c.Assert(mc.items[LayoutObjectName].metadata, HasLen, 0)
+
+	// The marker is not listed
+	c.Assert(b.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	objects, err := b.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objects, DeepEquals, []string{"obj"})
+	page, _, err := b.ListObjectsPage(ctx, "", 10)
+	c.Assert(err, IsNil)
+	c.Assert(page, DeepEquals, []string{"obj"})
+}
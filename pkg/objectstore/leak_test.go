@@ -0,0 +1,178 @@
+package objectstore
+
+// Checks that operations do not leave goroutines behind. Run with -race
+// through `make test-leaks`.
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type LeakSuite struct{}
+
+var _ = Suite(&LeakSuite{})
+
+var goroutineHeader = regexp.MustCompile(`^goroutine (\d+) \[`)
+
+// goroutines returns the stacks of the running goroutines by ID
+func goroutines() map[string]string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	stacks := make(map[string]string)
+	for _, g := range strings.Split(string(buf), "\n\n") {
+		if m := goroutineHeader.FindStringSubmatch(g); m != nil {
+			stacks[m[1]] = g
+		}
+	}
+	return stacks
+}
+
+// leakChecker fails a test if goroutines that were started after it was
+// created are still running when check is called
+type leakChecker map[string]string
+
+func newLeakChecker() leakChecker {
+	return goroutines()
+}
+
+func (l leakChecker) check(c *C) {
+	var leaked []string
+	// Goroutines may take a moment to exit after they are done
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(10 * time.Millisecond) {
+		leaked = leaked[:0]
+		for id, stack := range goroutines() {
+			if _, ok := l[id]; !ok {
+				leaked = append(leaked, stack)
+			}
+		}
+		if len(leaked) == 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	c.Assert(leaked, HasLen, 0, Commentf("leaked goroutines:\n%s", strings.Join(leaked, "\n\n")))
+}
+
+func newLeakBucket(c *C, n int) (*bucket, *failingContainer) {
+	fc := &failingContainer{memContainer: newMemContainer("leak"), failures: make(map[string]int)}
+	b := newBucket(ProviderConfig{PageSize: 3}, fc, nil, "mem://")
+	_, err := b.CreateDirectory(context.Background(), "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < n; i++ {
+		c.Assert(b.PutBytes(context.Background(), fmt.Sprintf("dir/obj%02d", i), []byte("data"), nil), IsNil)
+	}
+	return b, fc
+}
+
+func (s *LeakSuite) TestDeleteDirectory(c *C) {
+	defer newLeakChecker().check(c)
+
+	b, fc := newLeakBucket(c, 50)
+	fc.failures["dir/obj07"] = 100
+	d, err := b.GetDirectory(context.Background(), "dir")
+	c.Assert(err, IsNil)
+	err = d.DeleteDirectory(context.Background())
+	c.Assert(IsDeleteIncomplete(err), Equals, true)
+
+	b.batchDeleter = &memBatchDeleter{c: fc.memContainer, max: 4, fail: 1}
+	err = d.DeleteDirectory(context.Background())
+	c.Assert(IsDeleteIncomplete(err), Equals, true)
+}
+
+func (s *LeakSuite) TestDeleteDirectoryCancel(c *C) {
+	defer newLeakChecker().check(c)
+
+	mc := newMemContainer("leak")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cc := &cancelContainer{memContainer: mc, pages: 2, cancel: cancel}
+	b := newBucket(ProviderConfig{PageSize: 3, DeleteConcurrency: 2}, cc, nil, "mem://")
+	for i := 0; i < 50; i++ {
+		c.Assert(b.PutBytes(context.Background(), fmt.Sprintf("obj%02d", i), nil, nil), IsNil)
+	}
+	err := b.DeleteDirectory(ctx)
+	c.Assert(err, Equals, context.Canceled)
+}
+
+func (s *LeakSuite) TestPutOpts(c *C) {
+	defer newLeakChecker().check(c)
+
+	b, m := newMemMultipartBucket("leak")
+	opts := PutOptions{PartSize: 2, Concurrency: 3}
+	data := bytes.Repeat([]byte("x"), 21)
+	c.Assert(b.PutOpts(context.Background(), "ok", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts)), IsNil)
+
+	// A part that keeps failing aborts the upload
+	clk := newFakeClock()
+	b.clock = clk
+	m.failures[4] = 100
+	done := make(chan error, 1)
+	go func() {
+		done <- b.PutOpts(context.Background(), "failed", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts))
+	}()
+	err := advanceUntilDone(clk, 0, done)
+	c.Assert(err, NotNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = b.PutOpts(ctx, "canceled", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts))
+	c.Assert(err, NotNil)
+}
+
+func (s *LeakSuite) TestRewritePrefix(c *C) {
+	defer newLeakChecker().check(c)
+
+	b, _ := newLeakBucket(c, 30)
+	mapping := func(p string) (string, bool) { return "new/" + p, false }
+	_, err := RewritePrefix(context.Background(), b, mapping, RewriteOptions{Concurrency: 4})
+	c.Assert(err, IsNil)
+
+	b, _ = newLeakBucket(c, 30)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = RewritePrefix(ctx, b, mapping, RewriteOptions{Concurrency: 4})
+	c.Assert(err, NotNil)
+}
+
+func (s *LeakSuite) TestSpoolClose(c *C) {
+	defer newLeakChecker().check(c)
+
+	b, _ := newLeakBucket(c, 1)
+	for _, opts := range []SpoolOptions{{}, {Dir: c.MkDir()}} {
+		r, _, err := b.GetSpooled(context.Background(), "dir/obj00", opts)
+		c.Assert(err, IsNil)
+		data, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, "data")
+		c.Assert(r.Close(), IsNil)
+		c.Assert(r.Close(), IsNil)
+	}
+}
+
+func (s *LeakSuite) TestGet(c *C) {
+	defer newLeakChecker().check(c)
+
+	b, _ := newLeakBucket(c, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The reader stops watching the context when it is closed
+	r, _, err := b.Get(ctx, "dir/obj00")
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	_, _, err = b.GetBytes(ctx, "dir/obj00")
+	c.Assert(err, IsNil)
+}
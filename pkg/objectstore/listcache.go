@@ -0,0 +1,265 @@
+package objectstore
+
+// Caching of listings of directories that are listed again and again
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+)
+
+const (
+	defaultListingCacheMaxBytes     = 16 << 20
+	defaultListingCacheMaxStaleness = time.Minute
+	// listingProbeSize is the number of items listed to check whether a
+	// directory changed
+	listingProbeSize = 100
+	// listingEntryOverhead approximates the memory used by a listed name
+	// besides the name itself
+	listingEntryOverhead = 64
+)
+
+// ListingCacheConfig caches the listings of objects and sub directories of
+// directories. Before a cached listing is returned, the first items of the
+// directory are listed, and the listing is refreshed if their number, last
+// modification time or last name changed. Changes past these items are
+// only seen once the listing is older than MaxStaleness. Changes made
+// through the bucket invalidate the listings they affect right away.
+type ListingCacheConfig struct {
+	// MaxEntries is the number of listings cached. The cache is disabled if
+	// it is 0.
+	MaxEntries int
+	// MaxBytes bounds the approximate memory used by the cached listings.
+	// Defaults to 16 MiB.
+	MaxBytes int64
+	// MaxStaleness is the age after which a listing is refreshed, even if
+	// the directory does not seem to have changed. Defaults to 1 minute.
+	MaxStaleness time.Duration
+}
+
+// ListingCacheStats counts the use of the listing cache of a bucket
+type ListingCacheStats struct {
+	// Hits is the number of listings returned from the cache
+	Hits int64
+	// Misses is the number of listings that were not cached, or were
+	// refreshed
+	Misses int64
+	// Evictions is the number of listings dropped to stay within the
+	// limits
+	Evictions int64
+	// Entries is the number of cached listings
+	Entries int
+	// Bytes is the approximate memory used by the cached listings
+	Bytes int64
+}
+
+// listingKind distinguishes the listings of a directory
+type listingKind int
+
+const (
+	listingObjects listingKind = iota
+	listingPrefixes
+)
+
+type listingKey struct {
+	kind listingKind
+	path string
+}
+
+type listingEntry struct {
+	key       listingKey
+	objects   []ObjectInfo
+	prefixes  []string
+	indicator string
+	listedAt  time.Time
+	bytes     int64
+	// generation is the generation of the cache when the listing started
+	generation uint64
+}
+
+type listingCache struct {
+	config  ListingCacheConfig
+	mu      sync.Mutex
+	lru     *list.List // of *listingEntry, most recently used first
+	entries map[listingKey]*list.Element
+	stats   ListingCacheStats
+	// generation counts invalidations, so that listings that started
+	// before a change are not cached
+	generation uint64
+}
+
+// newListingCache returns nil if config does not enable the cache
+func newListingCache(config ListingCacheConfig) *listingCache {
+	if config.MaxEntries <= 0 {
+		return nil
+	}
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultListingCacheMaxBytes
+	}
+	if config.MaxStaleness <= 0 {
+		config.MaxStaleness = defaultListingCacheMaxStaleness
+	}
+	return &listingCache{config: config, lru: list.New(), entries: make(map[listingKey]*list.Element)}
+}
+
+// GetListingCacheStats returns the statistics of the listing cache of the
+// bucket of d, which are zero if the cache is disabled
+func GetListingCacheStats(d Directory) (ListingCacheStats, error) {
+	dir, err := asDirectory(d)
+	if err != nil {
+		return ListingCacheStats{}, err
+	}
+	c := dir.bucket.listingCache
+	if c == nil {
+		return ListingCacheStats{}, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats, nil
+}
+
+// listingIndicator lists the first items of the directory whose key prefix
+// is prefix, and summarizes them to tell whether the directory changed
+func listingIndicator(b *bucket, prefix string) (string, error) {
+	items, next, err := b.container.Items(prefix, stow.CursorStart, listingProbeSize)
+	if err != nil {
+		return "", err
+	}
+	var last time.Time
+	for _, item := range items {
+		if t, err := item.LastMod(); err == nil && t.After(last) {
+			last = t
+		}
+	}
+	return fmt.Sprintf("%d %d %s", len(items), last.UnixNano(), next), nil
+}
+
+// cached returns the entry of key and true if it is fresh, and otherwise a
+// new entry to fill with the listing and store
+func (c *listingCache) cached(ctx context.Context, b *bucket, key listingKey) (*listingEntry, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+	indicator, err := listingIndicator(b, cloudName(key.path))
+	if err != nil {
+		return nil, false, err
+	}
+	now := clockFor(ctx, b).Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*listingEntry)
+		if e.indicator == indicator && now.Sub(e.listedAt) < c.config.MaxStaleness {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			return e, true, nil
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	return &listingEntry{key: key, indicator: indicator, listedAt: now, generation: generation}, false, nil
+}
+
+// store caches the entry, unless it is larger than the cache or the
+// directory was changed through the bucket since the listing started
+func (c *listingCache) store(e *listingEntry) {
+	for _, o := range e.objects {
+		e.bytes += int64(len(o.Name)) + listingEntryOverhead
+	}
+	for _, p := range e.prefixes {
+		e.bytes += int64(len(p)) + listingEntryOverhead
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.bytes > c.config.MaxBytes || e.generation != c.generation {
+		return
+	}
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.stats.Entries++
+	c.stats.Bytes += e.bytes
+	for c.stats.Entries > c.config.MaxEntries || c.stats.Bytes > c.config.MaxBytes {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *listingCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*listingEntry)
+	delete(c.entries, e.key)
+	c.stats.Entries--
+	c.stats.Bytes -= e.bytes
+}
+
+// invalidate drops the listings of the directories that contain the
+// absolute path p, and of those that p contains if it is a directory. It
+// is called once p was changed through the bucket.
+func (c *listingCache) invalidate(p string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	for key, el := range c.entries {
+		if strings.HasPrefix(p, key.path) || strings.HasPrefix(key.path, p) {
+			c.remove(el)
+		}
+	}
+}
+
+// cachedObjects returns the listing of the objects of d with the cache of
+// its bucket, if it has one
+func (d *directory) cachedObjects(ctx context.Context, fetch func() ([]ObjectInfo, error)) ([]ObjectInfo, error) {
+	c := d.bucket.listingCache
+	if c == nil {
+		return fetch()
+	}
+	e, ok, err := c.cached(ctx, d.bucket, listingKey{kind: listingObjects, path: d.path})
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return append([]ObjectInfo(nil), e.objects...), nil
+	}
+	objects, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	e.objects = append([]ObjectInfo(nil), objects...)
+	c.store(e)
+	return objects, nil
+}
+
+// cachedPrefixes returns the listing of the sub directories of d with the
+// cache of its bucket, if it has one
+func (d *directory) cachedPrefixes(ctx context.Context, fetch func() ([]string, error)) ([]string, error) {
+	c := d.bucket.listingCache
+	if c == nil {
+		return fetch()
+	}
+	e, ok, err := c.cached(ctx, d.bucket, listingKey{kind: listingPrefixes, path: d.path})
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return append([]string(nil), e.prefixes...), nil
+	}
+	prefixes, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	e.prefixes = append([]string(nil), prefixes...)
+	c.store(e)
+	return prefixes, nil
+}
@@ -0,0 +1,141 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type ListingCacheSuite struct{}
+
+var _ = Suite(&ListingCacheSuite{})
+
+// newCachingBucket returns a bucket with a listing cache of config, whose
+// listings of pages are counted
+func newCachingBucket(config ListingCacheConfig) (*bucket, *pageSizeContainer, *fakeClock) {
+	pc := &pageSizeContainer{memContainer: newMemContainer("cache"), counts: make(map[int]int)}
+	b := newBucket(ProviderConfig{PageSize: 50, ListingCache: config}, pc, nil, "mem://")
+	clk := newFakeClock()
+	b.clock = clk
+	return b, pc, clk
+}
+
+func listingStats(c *C, b *bucket) ListingCacheStats {
+	stats, err := GetListingCacheStats(b)
+	c.Assert(err, IsNil)
+	return stats
+}
+
+func (s *ListingCacheSuite) TestDisabled(c *C) {
+	ctx := context.Background()
+	b, pc, _ := newCachingBucket(ListingCacheConfig{})
+	c.Assert(b.listingCache, IsNil)
+	c.Assert(b.PutBytes(ctx, "obj", nil, nil), IsNil)
+	for i := 0; i < 2; i++ {
+		objs, err := b.ListObjects(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(objs, DeepEquals, []string{"obj"})
+	}
+	c.Assert(pc.counts[50], Equals, 2)
+	c.Assert(listingStats(c, b), Equals, ListingCacheStats{})
+}
+
+func (s *ListingCacheSuite) TestChanges(c *C) {
+	ctx := context.Background()
+	b, pc, clk := newCachingBucket(ListingCacheConfig{MaxEntries: 10})
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i <= listingProbeSize; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%03d", i), nil, nil), IsNil)
+	}
+	list := func() []string {
+		objs, err := d.ListObjects(ctx)
+		c.Assert(err, IsNil)
+		return objs
+	}
+	c.Assert(list(), HasLen, listingProbeSize+1)
+	c.Assert(list(), HasLen, listingProbeSize+1)
+	c.Assert(pc.counts[50], Equals, 3)
+	c.Assert(listingStats(c, b).Hits, Equals, int64(1))
+	c.Assert(listingStats(c, b).Misses, Equals, int64(1))
+
+	// Writes through the bucket are seen right away, even past the probe
+	c.Assert(d.PutBytes(ctx, "obj999", nil, nil), IsNil)
+	c.Assert(list(), HasLen, listingProbeSize+2)
+	c.Assert(d.Delete(ctx, "obj999"), IsNil)
+	c.Assert(list(), HasLen, listingProbeSize+1)
+
+	// Other writers are seen if they change the first items
+	_, err = pc.memContainer.Put("dir/obj000a", strings.NewReader(""), 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(list(), HasLen, listingProbeSize+2)
+
+	// and otherwise once the listing is stale
+	_, err = pc.memContainer.Put("dir/obj999", strings.NewReader(""), 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(list(), HasLen, listingProbeSize+2)
+	clk.Advance(time.Minute)
+	c.Assert(list(), HasLen, listingProbeSize+3)
+
+	// Listings returned by the cache are copies
+	objs := list()
+	objs[0] = "changed"
+	c.Assert(list()[0], Equals, "obj000")
+}
+
+func (s *ListingCacheSuite) TestPrefixes(c *C) {
+	ctx := context.Background()
+	b, pc, _ := newCachingBucket(ListingCacheConfig{MaxEntries: 10})
+	_, err := b.CreateDirectory(ctx, "a")
+	c.Assert(err, IsNil)
+	prefixes, err := b.ListPrefixes(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(prefixes, DeepEquals, []string{"a"})
+	walks := pc.counts[50]
+	_, err = b.ListPrefixes(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(pc.counts[50], Equals, walks)
+
+	// Changes in sub directories invalidate the listings of their parents
+	sub, err := b.CreateDirectory(ctx, "b/c")
+	c.Assert(err, IsNil)
+	prefixes, err = b.ListPrefixes(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(prefixes, DeepEquals, []string{"a", "b"})
+	err = sub.DeleteDirectory(ctx)
+	c.Assert(err, IsNil)
+	prefixes, err = b.ListPrefixes(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(prefixes, DeepEquals, []string{"a"})
+}
+
+func (s *ListingCacheSuite) TestEviction(c *C) {
+	ctx := context.Background()
+	b, _, _ := newCachingBucket(ListingCacheConfig{MaxEntries: 1})
+	for _, dir := range []string{"a", "b"} {
+		d, err := b.CreateDirectory(ctx, dir)
+		c.Assert(err, IsNil)
+		c.Assert(d.PutBytes(ctx, "obj", nil, nil), IsNil)
+		_, err = d.ListObjects(ctx)
+		c.Assert(err, IsNil)
+	}
+	stats := listingStats(c, b)
+	c.Assert(stats.Entries, Equals, 1)
+	c.Assert(stats.Evictions, Equals, int64(1))
+	c.Assert(stats.Bytes, Equals, int64(listingEntryOverhead+len("obj")))
+
+	// Listings larger than the cache are not cached
+	b, _, _ = newCachingBucket(ListingCacheConfig{MaxEntries: 10, MaxBytes: listingEntryOverhead})
+	c.Assert(b.PutBytes(ctx, "obj1", nil, nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "obj2", nil, nil), IsNil)
+	for i := 0; i < 2; i++ {
+		_, err := b.ListObjects(ctx)
+		c.Assert(err, IsNil)
+	}
+	stats = listingStats(c, b)
+	c.Assert(stats.Entries, Equals, 0)
+	c.Assert(stats.Misses, Equals, int64(2))
+}
@@ -0,0 +1,260 @@
+package objectstore
+
+// Transfers of files and directory trees between the local filesystem and
+// directories
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// SyncSummary accounts for the files of a SyncToDirectory or
+// SyncFromDirectory
+type SyncSummary struct {
+	// Files counts the files transferred, without directories
+	Files int64
+	Bytes int64
+	// Skipped counts the symlinks and other files that are not transferred
+	Skipped int64
+}
+
+// syncer transfers files from a bounded number of goroutines, and accounts
+// for them
+type syncer struct {
+	work      chan func()
+	wg        sync.WaitGroup
+	mu        sync.Mutex
+	summary   SyncSummary
+	errs      *BatchError
+	redaction NameRedaction
+}
+
+func newSyncer(workers int, redaction NameRedaction) *syncer {
+	s := &syncer{work: make(chan func()), errs: newBatchError(), redaction: redaction}
+	for i := 0; i < workers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			for f := range s.work {
+				f()
+			}
+		}()
+	}
+	return s
+}
+
+// transfer calls f, which transfers the file name and returns the number
+// of bytes it transferred, from one of the goroutines of the syncer
+func (s *syncer) transfer(ctx context.Context, name, op string, f func() (int64, error)) error {
+	t := func() {
+		n, err := f()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if err != nil {
+			s.errs.add(s.redaction.Redact(name), op, err)
+			return
+		}
+		s.summary.Files++
+		s.summary.Bytes += n
+	}
+	select {
+	case s.work <- t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *syncer) skip(name, reason string) {
+	log.Warnf("Skipping %s, which is %s", s.redaction.Redact(name), reason)
+	s.mu.Lock()
+	s.summary.Skipped++
+	s.mu.Unlock()
+}
+
+// wait waits for the transfers, and returns the summary and the error that
+// stopped the walk, if any, or the errors of the transfers
+func (s *syncer) wait(ctx context.Context, err error) (SyncSummary, error) {
+	close(s.work)
+	s.wg.Wait()
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = s.errs.errOrNil()
+	}
+	return s.summary, err
+}
+
+// SyncToDirectory uploads the files of the tree rooted at localPath to the
+// same relative paths under d, from up to the CopyConcurrency of the bucket
+// of d at a time. Empty directories are created as directory markers.
+// Symlinks and other files that are not regular files are skipped with a
+// warning. Files that could not be uploaded are reported by a BatchError,
+// and do not stop the upload of the others.
+func SyncToDirectory(ctx context.Context, localPath string, d Directory) (SyncSummary, error) {
+	dd, err := asDirectory(d)
+	if err != nil {
+		return SyncSummary{}, err
+	}
+	if dd.path == "" {
+		return SyncSummary{}, errors.New("invalid entry")
+	}
+	s := newSyncer(dd.bucket.copyWorkers, dd.bucket.redaction)
+	// Directories are empty unless files or directories are found in them
+	empty := make(map[string]bool)
+	err = filepath.Walk(localPath, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(localPath, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		delete(empty, filepath.Dir(p))
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			empty[p] = true
+		case mode&os.ModeSymlink != 0:
+			s.skip(name, "a symlink")
+		case !mode.IsRegular():
+			s.skip(name, "not a regular file")
+		default:
+			return s.transfer(ctx, name, "upload", func() (int64, error) {
+				return info.Size(), dd.PutFile(ctx, name, p, nil)
+			})
+		}
+		return nil
+	})
+	for p := range empty {
+		if err != nil {
+			break
+		}
+		rel, _ := filepath.Rel(localPath, p)
+		_, err = dd.CreateDirectory(ctx, filepath.ToSlash(rel))
+	}
+	return s.wait(ctx, err)
+}
+
+// SyncFromDirectory downloads the objects of the tree rooted at d to the
+// same relative paths under localPath, from up to the CopyConcurrency of the
+// bucket of d at a time. Directory markers are created as directories.
+// Objects whose names would leave localPath are skipped with a warning.
+// Objects that could not be downloaded are reported by a BatchError, and do
+// not stop the download of the others.
+func SyncFromDirectory(ctx context.Context, d Directory, localPath string) (SyncSummary, error) {
+	dd, err := asDirectory(d)
+	if err != nil {
+		return SyncSummary{}, err
+	}
+	if dd.path == "" {
+		return SyncSummary{}, errors.New("invalid entry")
+	}
+	root, err := filepath.Abs(localPath)
+	if err != nil {
+		return SyncSummary{}, err
+	}
+	s := newSyncer(dd.bucket.copyWorkers, dd.bucket.redaction)
+	prefix := cloudName(dd.path)
+	err = stow.Walk(dd.bucket.container, prefix, dd.bucket.pageSize, func(item stow.Item, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := dd.bucket.stopping(); err != nil {
+			return err
+		}
+		// The marker of d itself has an empty name
+		name := strings.TrimPrefix(item.Name(), prefix)
+		if name == "" {
+			return nil
+		}
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+			s.skip(dd.path+name, "outside of "+localPath)
+			return nil
+		}
+		if isMarkerName(name) {
+			return os.MkdirAll(strings.TrimSuffix(p, emrFolderSuffix), 0755)
+		}
+		return s.transfer(ctx, dd.path+name, "download", func() (int64, error) {
+			return downloadFile(ctx, dd, dd.path+name, p)
+		})
+	})
+	return s.wait(ctx, err)
+}
+
+// downloadFile writes the object name of d to the file p, creating the
+// directories of p
+func downloadFile(ctx context.Context, d Directory, name, p string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return 0, err
+	}
+	if err := d.GetToFile(ctx, name, p); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// PutFile writes the object <bucket>/<d.path>/name with the data of the
+// local file at localPath, streamed with the size of the file
+func (d *directory) PutFile(ctx context.Context, name, localPath string, tags map[string]string) error {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open %s", localPath)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat %s", localPath)
+	}
+	if !info.Mode().IsRegular() {
+		return errors.Errorf("%s is not a regular file", localPath)
+	}
+	return d.Put(ctx, name, f, info.Size(), tags)
+}
+
+// GetToFile writes the data of the object <bucket>/<d.path>/name to the
+// local file at localPath, replacing it if it exists. The data is streamed
+// to a temporary file in the same directory, which is renamed to localPath
+// once the object is read, and removed if the read fails.
+func (d *directory) GetToFile(ctx context.Context, name, localPath string) (err error) {
+	r, _, err := d.Get(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	f, err := ioutil.TempFile(filepath.Dir(localPath), "."+filepath.Base(localPath)+".kanister-")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create a temporary file for %s", localPath)
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err = io.Copy(f, r); err != nil {
+		return errors.Wrapf(err, "failed to download object %s", d.bucket.redaction.Redact(d.absPathName(name)))
+	}
+	if err = f.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write %s", f.Name())
+	}
+	return errors.Wrapf(os.Rename(f.Name(), localPath), "failed to rename %s", f.Name())
+}
@@ -0,0 +1,168 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type LocalSyncSuite struct{}
+
+var _ = Suite(&LocalSyncSuite{})
+
+// writeTree creates the files of the tree rooted at root, and returns their
+// total size
+func writeTree(c *C, root string, files map[string]string) int64 {
+	var size int64
+	for name, data := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		c.Assert(os.MkdirAll(filepath.Dir(p), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(p, []byte(data), 0644), IsNil)
+		size += int64(len(data))
+	}
+	return size
+}
+
+func (s *LocalSyncSuite) TestRoundTrip(c *C) {
+	ctx := context.Background()
+	src := c.MkDir()
+	files := map[string]string{
+		"manifest.json":        `{"version":1}`,
+		"data/part-0":          "0123456789",
+		"data/nested/part-1":   "abcdef",
+		"logs/2018/09/app.log": "started",
+	}
+	size := writeTree(c, src, files)
+	c.Assert(os.MkdirAll(filepath.Join(src, "empty", "nested"), 0755), IsNil)
+	c.Assert(os.Symlink(filepath.Join(src, "manifest.json"), filepath.Join(src, "link")), IsNil)
+
+	b, mc := newMemBucket("sync")
+	b.copyWorkers = 2
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+	summary, err := SyncToDirectory(ctx, src, d)
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, SyncSummary{Files: 4, Bytes: size, Skipped: 1})
+	_, ok := mc.items["backup/link"]
+	c.Assert(ok, Equals, false)
+	// Only the markers of empty directories are created
+	_, ok = mc.items["backup/empty/nested/"]
+	c.Assert(ok, Equals, true)
+	_, ok = mc.items["backup/data/"]
+	c.Assert(ok, Equals, false)
+	data, _, err := d.GetBytes(ctx, "data/nested/part-1")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "abcdef")
+
+	dst := c.MkDir()
+	summary, err = SyncFromDirectory(ctx, d, dst)
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, SyncSummary{Files: 4, Bytes: size})
+	for name, want := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		c.Assert(err, IsNil)
+		c.Assert(string(got), Equals, want)
+	}
+	info, err := os.Stat(filepath.Join(dst, "empty", "nested"))
+	c.Assert(err, IsNil)
+	c.Assert(info.IsDir(), Equals, true)
+}
+
+func (s *LocalSyncSuite) TestOutsideLocalPath(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("escape")
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("obj"), nil), IsNil)
+	// Keys are not cleaned by providers
+	_, err = mc.Put("backup/../../escaped", bytes.NewReader([]byte("escaped")), 7, nil)
+	c.Assert(err, IsNil)
+
+	parent := c.MkDir()
+	dst := filepath.Join(parent, "dst")
+	summary, err := SyncFromDirectory(ctx, d, dst)
+	c.Assert(err, IsNil)
+	c.Assert(summary, DeepEquals, SyncSummary{Files: 1, Bytes: 3, Skipped: 1})
+	_, err = os.Stat(filepath.Join(parent, "escaped"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *LocalSyncSuite) TestFailures(c *C) {
+	ctx := context.Background()
+	src := c.MkDir()
+	writeTree(c, src, map[string]string{"a": "a", "b": "b", "c": "c"})
+	fc := &putFailingContainer{memContainer: newMemContainer("failing"), failures: map[string]int{"backup/b": 1}}
+	b := newBucket(ProviderConfig{Retry: RetryPolicy{MaxAttempts: 1}}, fc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+
+	// Failed files do not stop the upload of the others
+	summary, err := SyncToDirectory(ctx, src, d)
+	be, ok := AsBatchError(err)
+	c.Assert(ok, Equals, true)
+	c.Assert(be.Len(), Equals, 1)
+	c.Assert(err, ErrorMatches, "(?s).*upload b.*")
+	c.Assert(summary, DeepEquals, SyncSummary{Files: 2, Bytes: 2})
+
+	// Cancelled syncs stop before downloading
+	dst := c.MkDir()
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = SyncFromDirectory(ctx, d, dst)
+	c.Assert(err, Equals, context.Canceled)
+	_, err = os.Stat(filepath.Join(dst, "a"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
+
+func (s *LocalSyncSuite) TestPutFile(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("putfile")
+	dir := c.MkDir()
+	p := filepath.Join(dir, "manifest.json")
+	c.Assert(ioutil.WriteFile(p, []byte(`{"version":1}`), 0644), IsNil)
+	c.Assert(b.PutFile(ctx, "manifest", p, map[string]string{"type": "manifest"}), IsNil)
+	info, err := b.Stat(ctx, "manifest")
+	c.Assert(err, IsNil)
+	c.Assert(info.Size, Equals, int64(13))
+	c.Assert(info.Tags, DeepEquals, map[string]string{"type": "manifest"})
+
+	c.Assert(b.PutFile(ctx, "dir", dir, nil), ErrorMatches, ".* is not a regular file")
+	c.Assert(b.PutFile(ctx, "missing", filepath.Join(dir, "missing"), nil), ErrorMatches, "failed to open .*")
+	c.Assert(mc.items, HasLen, 1)
+}
+
+func (s *LocalSyncSuite) TestGetToFile(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("gettofile")
+	var sum string
+	err := b.PutOpts(ctx, "manifest", bytes.NewReader([]byte(`{"version":2}`)), 13, nil, WithChecksum(&sum))
+	c.Assert(err, IsNil)
+	dir := c.MkDir()
+	p := filepath.Join(dir, "manifest.json")
+	c.Assert(ioutil.WriteFile(p, []byte(`{"version":1}`), 0644), IsNil)
+
+	// Existing files are replaced
+	c.Assert(b.GetToFile(ctx, "manifest", p), IsNil)
+	data, err := ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"version":2}`)
+
+	// Failed reads leave neither partial files nor temporary files
+	mc.items["manifest"].data = []byte(`{"version":3}`)
+	err = b.GetToFile(WithVerifiedChecksums(ctx), "manifest", p)
+	c.Assert(IsChecksumMismatch(err), Equals, true)
+	data, err = ioutil.ReadFile(p)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, `{"version":2}`)
+	err = b.GetToFile(WithVerifiedChecksums(ctx), "manifest", filepath.Join(dir, "new.json"))
+	c.Assert(IsChecksumMismatch(err), Equals, true)
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+
+	c.Assert(IsObjectNotFound(b.GetToFile(ctx, "missing", p)), Equals, true)
+}
@@ -0,0 +1,198 @@
+package objectstore
+
+// In-memory stow container used by the unit tests in this package
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+)
+
+var _ stow.Container = (*memContainer)(nil)
+
+type memContainer struct {
+	mu    sync.Mutex
+	id    string
+	items map[string]*memItem
+}
+
+func newMemContainer(id string) *memContainer {
+	return &memContainer{
+		id:    id,
+		items: make(map[string]*memItem),
+	}
+}
+
+// newMemBucket returns a bucket backed by an in-memory container
+func newMemBucket(id string) (*bucket, *memContainer) {
+	c := newMemContainer(id)
+	return newBucket(ProviderConfig{}, c, nil, "mem://"), c
+}
+
+func (c *memContainer) ID() string   { return c.id }
+func (c *memContainer) Name() string { return c.id }
+
+func (c *memContainer) Item(id string) (stow.Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	item, ok := c.items[id]
+	if !ok {
+		return nil, stow.ErrNotFound
+	}
+	return item, nil
+}
+
+// Items returns up to count items, sorted by name, that come after cursor
+func (c *memContainer) Items(prefix, cursor string, count int) ([]stow.Item, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.items))
+	for name := range c.items {
+		if strings.HasPrefix(name, prefix) && name > cursor {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	next := ""
+	if len(names) > count {
+		names = names[:count]
+		next = names[count-1]
+	}
+	items := make([]stow.Item, 0, len(names))
+	for _, name := range names {
+		items = append(items, c.items[name])
+	}
+	return items, next, nil
+}
+
+func (c *memContainer) RemoveItem(id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[id]; !ok {
+		return stow.ErrNotFound
+	}
+	delete(c.items, id)
+	return nil
+}
+
+func (c *memContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	md := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		md[k] = v
+	}
+	sum := md5.Sum(data)
+	item := &memItem{
+		name:     name,
+		data:     data,
+		metadata: md,
+		etag:     hex.EncodeToString(sum[:]),
+		lastMod:  time.Now(),
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[name] = item
+	return item, nil
+}
+
+var _ stow.Item = (*memItem)(nil)
+
+type memItem struct {
+	name     string
+	data     []byte
+	metadata map[string]interface{}
+	etag     string
+	lastMod  time.Time
+}
+
+func (i *memItem) ID() string                                { return i.name }
+func (i *memItem) Name() string                              { return i.name }
+func (i *memItem) URL() *url.URL                             { return &url.URL{Scheme: "mem", Path: i.name} }
+func (i *memItem) Size() (int64, error)                      { return int64(len(i.data)), nil }
+func (i *memItem) ETag() (string, error)                     { return i.etag, nil }
+func (i *memItem) LastMod() (time.Time, error)               { return i.lastMod, nil }
+func (i *memItem) Metadata() (map[string]interface{}, error) { return i.metadata, nil }
+
+func (i *memItem) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewReader(i.data)), nil
+}
+
+var _ stow.Location = (*memLocation)(nil)
+
+// memLocation is an in-memory stow location of memContainers
+type memLocation struct {
+	mu         sync.Mutex
+	containers map[string]*memContainer
+}
+
+func newMemLocation() *memLocation {
+	return &memLocation{containers: make(map[string]*memContainer)}
+}
+
+// dial replaces stowDial with a dialer of l, and returns a function that
+// restores it
+func (l *memLocation) dial() func() {
+	dial := stowDial
+	stowDial = func(string, stow.Config) (stow.Location, error) { return l, nil }
+	return func() { stowDial = dial }
+}
+
+func (l *memLocation) Close() error { return nil }
+
+func (l *memLocation) CreateContainer(name string) (stow.Container, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c := newMemContainer(name)
+	l.containers[name] = c
+	return c, nil
+}
+
+func (l *memLocation) Containers(prefix string, cursor string, count int) ([]stow.Container, string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var ids []string
+	for id := range l.containers {
+		if strings.HasPrefix(id, prefix) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	var cs []stow.Container
+	for _, id := range ids {
+		cs = append(cs, l.containers[id])
+	}
+	return cs, "", nil
+}
+
+func (l *memLocation) Container(id string) (stow.Container, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	c, ok := l.containers[id]
+	if !ok {
+		return nil, stow.ErrNotFound
+	}
+	return c, nil
+}
+
+func (l *memLocation) RemoveContainer(id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.containers, id)
+	return nil
+}
+
+func (l *memLocation) ItemByURL(url *url.URL) (stow.Item, error) {
+	return nil, stow.ErrNotFound
+}
@@ -0,0 +1,299 @@
+package objectstore
+
+// Migration of legacy tags, which were written before tag keys were encoded
+// reversibly. Legacy tags replaced '/' with "-" in keys, so that a legacy key
+// with a '-' may stand for several keys. Such keys are translated with the
+// keys known to the caller, and are ambiguous if none or several of them
+// match.
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LegacyTagsMode controls how the keys of legacy tags are read
+type LegacyTagsMode int
+
+const (
+	// LegacyTagsAsIs reads legacy keys as they are stored. It is the
+	// default.
+	LegacyTagsAsIs LegacyTagsMode = iota
+	// LegacyTagsTranslate reads legacy keys as the known keys they were
+	// written from, and ambiguous keys as they are stored
+	LegacyTagsTranslate
+	// LegacyTagsFlag reads legacy keys as they are stored, and lists the
+	// ambiguous keys in the LegacyTagKeysTag
+	LegacyTagsFlag
+)
+
+// LegacyTagKeysTag lists the ambiguous keys of the legacy tags of an
+// object, comma separated, when they are read with LegacyTagsFlag
+const LegacyTagKeysTag = "kanister-legacy-tag-keys"
+
+// LegacyTagsConfig controls how the legacy tags of objects are read
+type LegacyTagsConfig struct {
+	Mode LegacyTagsMode
+	// KnownKeys are the keys that legacy keys are translated to, such as
+	// "app/name" for the legacy key "app-name". The tags of Kanister are
+	// always known.
+	KnownKeys []string
+}
+
+// kanisterTagKeys are the keys of the tags and metadata that Kanister
+// writes and reads
+var kanisterTagKeys = []string{
+	ChecksumTag,
+	ContentEncodingTag,
+	EncryptionTag,
+	EncryptionKeyTag,
+	EncryptionNonceTag,
+	IdempotencyKeyTag,
+	ObjectTagsTag,
+	OriginalSizeTag,
+	storageClassMetadataKey,
+}
+
+// isLegacyTags returns true if the stored tags of an object were written
+// before their encoding was marked. Metadata reported by providers is not
+// a tag.
+func isLegacyTags(sTags map[string]string) bool {
+	if _, ok := sTags[tagEncodingKey]; ok {
+		return false
+	}
+	for key := range sTags {
+		if key != storageClassMetadataKey {
+			return true
+		}
+	}
+	return false
+}
+
+// read returns the tags of stored legacy tags
+func (c LegacyTagsConfig) read(sTags map[string]string) map[string]string {
+	if c.Mode == LegacyTagsTranslate {
+		tags, _ := translateLegacyTags(sTags, c.KnownKeys)
+		return tags
+	}
+	// Legacy keys were read like encoded keys before they were marked
+	tags := make(map[string]string, len(sTags))
+	for key, val := range sTags {
+		tags[decodeTagKey(key)] = decodeTagValue(val)
+	}
+	if c.Mode == LegacyTagsFlag {
+		if _, ambiguous := translateLegacyTags(sTags, c.KnownKeys); len(ambiguous) != 0 {
+			tags[LegacyTagKeysTag] = strings.Join(ambiguous, ",")
+		}
+	}
+	return tags
+}
+
+// translateLegacyTags returns the tags of stored legacy tags, with their
+// keys translated, and the sorted keys that cannot be translated safely,
+// which are kept as they are stored
+func translateLegacyTags(sTags map[string]string, known []string) (map[string]string, []string) {
+	tags := make(map[string]string, len(sTags))
+	var ambiguous []string
+	for key, val := range sTags {
+		k, ok := translateLegacyKey(key, known)
+		if !ok {
+			ambiguous = append(ambiguous, key)
+		}
+		tags[k] = val
+	}
+	sort.Strings(ambiguous)
+	return tags, ambiguous
+}
+
+// translateLegacyKey returns the key that the legacy key was written from,
+// and false if it could be one of several keys. Providers may change the
+// case of keys.
+func translateLegacyKey(key string, known []string) (string, bool) {
+	if !strings.Contains(key, "-") {
+		return key, true
+	}
+	matches := make(map[string]bool)
+	for _, keys := range [][]string{kanisterTagKeys, known} {
+		for _, k := range keys {
+			if strings.EqualFold(strings.Replace(k, "/", "-", -1), key) {
+				matches[k] = true
+			}
+		}
+	}
+	if len(matches) != 1 {
+		return key, false
+	}
+	for k := range matches {
+		key = k
+	}
+	return key, true
+}
+
+// TagMigrationCursorName is the name of the object, at the root of the tree
+// migrated by MigrateTags, in which the progress of the migration is
+// recorded
+const TagMigrationCursorName = ".kanister-tag-migration.json"
+
+const defaultTagMigrationCursorInterval = 100
+
+// MigrateTagsOptions control the behavior of MigrateTags
+type MigrateTagsOptions struct {
+	// DryRun counts the objects to migrate without rewriting them or
+	// recording the progress of the migration
+	DryRun bool
+	// KnownKeys are the keys that legacy keys are translated to, as in
+	// LegacyTagsConfig
+	KnownKeys []string
+	// Rate is the maximum number of objects rewritten per second. Rewrites
+	// are not throttled by default.
+	Rate float64
+	// CursorInterval is the number of objects examined between records of
+	// the progress of the migration. Defaults to 100.
+	CursorInterval int
+}
+
+// MigrateTagsStats summarizes the result of MigrateTags, over all the runs
+// of a resumed migration
+type MigrateTagsStats struct {
+	// Scanned is the number of objects examined
+	Scanned int `json:"scanned"`
+	// Migrated is the number of objects that are, or in a dry run would
+	// be, rewritten with their tags encoded
+	Migrated int `json:"migrated"`
+	// Current is the number of objects whose tags are already encoded, or
+	// that have none
+	Current int `json:"current"`
+	// Ambiguous is the number of objects that are left as they are, since
+	// keys of their tags cannot be translated safely
+	Ambiguous int `json:"ambiguous"`
+	// Archived is the number of objects that are left as they are, since
+	// they are in archival storage and cannot be read
+	Archived int `json:"archived"`
+}
+
+// tagMigrationCursor is the content of the TagMigrationCursorName object
+type tagMigrationCursor struct {
+	// After is the name of the last object examined
+	After string           `json:"after"`
+	Stats MigrateTagsStats `json:"stats"`
+}
+
+// MigrateTags rewrites the objects in the tree rooted at d whose tags are
+// legacy tags, with their keys translated and encoded. Objects are examined
+// in the order of their names. Every CursorInterval objects, and when an
+// object fails to migrate, the last one examined is recorded with the stats
+// in the TagMigrationCursorName object, so that an interrupted migration
+// resumes from there when run again. The cursor is removed once the whole
+// tree is examined. Objects with ambiguous keys are left as they are, and
+// are read as configured by the LegacyTagsConfig of the bucket.
+func MigrateTags(ctx context.Context, d Directory, opts MigrateTagsOptions) (MigrateTagsStats, error) {
+	root, err := asDirectory(d)
+	if err != nil {
+		return MigrateTagsStats{}, err
+	}
+	cursor, err := readTagMigrationCursor(ctx, root)
+	if err != nil {
+		return MigrateTagsStats{}, err
+	}
+	objects, _, archived, err := listTree(ctx, root)
+	if err != nil {
+		return cursor.Stats, err
+	}
+	sort.Strings(objects)
+	interval := opts.CursorInterval
+	if interval <= 0 {
+		interval = defaultTagMigrationCursorInterval
+	}
+	save := func() error {
+		if opts.DryRun {
+			return nil
+		}
+		return writeTagMigrationCursor(ctx, root, cursor)
+	}
+	examined := 0
+	for _, o := range objects {
+		if o <= cursor.After || o == TagMigrationCursorName {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return cursor.Stats, err
+		}
+		stats := cursor.Stats
+		rewritten, err := migrateObjectTags(ctx, root, o, archived[o], opts, &stats)
+		if err != nil {
+			// The migration resumes with the object
+			_ = save()
+			return cursor.Stats, errors.Wrapf(err, "failed to migrate the tags of %s", root.bucket.redaction.Redact(o))
+		}
+		cursor.After, cursor.Stats = o, stats
+		if examined++; examined%interval == 0 {
+			if err := save(); err != nil {
+				return cursor.Stats, err
+			}
+		}
+		if rewritten && opts.Rate > 0 {
+			select {
+			case <-clockFor(ctx, root.bucket).After(time.Duration(float64(time.Second) / opts.Rate)):
+			case <-ctx.Done():
+				return cursor.Stats, ctx.Err()
+			}
+		}
+	}
+	if opts.DryRun {
+		return cursor.Stats, nil
+	}
+	if err := root.Delete(ctx, TagMigrationCursorName); err != nil && !IsObjectNotFound(err) {
+		return cursor.Stats, err
+	}
+	return cursor.Stats, nil
+}
+
+// migrateObjectTags rewrites the object o of root if its tags are legacy
+// tags, and counts it in stats. It returns true if the object is rewritten.
+func migrateObjectTags(ctx context.Context, root *directory, o string, archived bool, opts MigrateTagsOptions, stats *MigrateTagsStats) (bool, error) {
+	stats.Scanned++
+	if archived {
+		stats.Archived++
+		return false, nil
+	}
+	return root.rewriteTags(ctx, root.absPathName(o), func(sTags map[string]string) (map[string]string, bool) {
+		if !isLegacyTags(sTags) {
+			stats.Current++
+			return nil, false
+		}
+		tags, ambiguous := translateLegacyTags(sTags, opts.KnownKeys)
+		if len(ambiguous) != 0 {
+			stats.Ambiguous++
+			return nil, false
+		}
+		stats.Migrated++
+		return tags, !opts.DryRun
+	})
+}
+
+func readTagMigrationCursor(ctx context.Context, root *directory) (tagMigrationCursor, error) {
+	var cursor tagMigrationCursor
+	data, _, err := root.GetBytes(ctx, TagMigrationCursorName)
+	switch {
+	case IsObjectNotFound(err):
+		return cursor, nil
+	case err != nil:
+		return cursor, err
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return cursor, errors.Wrap(err, "failed to read the cursor of the tag migration")
+	}
+	return cursor, nil
+}
+
+func writeTagMigrationCursor(ctx context.Context, root *directory, cursor tagMigrationCursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return errors.Wrap(err, "failed to write the cursor of the tag migration")
+	}
+	return root.PutBytes(ctx, TagMigrationCursorName, data, nil)
+}
@@ -0,0 +1,169 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type MigrateTagsSuite struct{}
+
+var _ = Suite(&MigrateTagsSuite{})
+
+// putLegacy writes an object with tags sanitized the way they were before
+// tag keys were encoded
+func putLegacy(c *C, mc *memContainer, name string, metadata map[string]interface{}) {
+	_, err := mc.Put(name, bytes.NewReader([]byte(name)), int64(len(name)), metadata)
+	c.Assert(err, IsNil)
+}
+
+func (s *MigrateTagsSuite) TestReadLegacyTags(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("legacy")
+	putLegacy(c, mc, "obj", map[string]interface{}{"app-name": "mysql", "backup-id": "1", "type": "text", ContentEncodingTag: CodecGzip})
+	known := []string{"app/name", "backup/id", "backup-id"}
+
+	for _, tc := range []struct {
+		mode LegacyTagsMode
+		tags map[string]string
+	}{
+		{LegacyTagsAsIs, map[string]string{"app-name": "mysql", "backup-id": "1", "type": "text", ContentEncodingTag: CodecGzip}},
+		{LegacyTagsTranslate, map[string]string{"app/name": "mysql", "backup-id": "1", "type": "text", ContentEncodingTag: CodecGzip}},
+		{LegacyTagsFlag, map[string]string{"app-name": "mysql", "backup-id": "1", "type": "text", ContentEncodingTag: CodecGzip, LegacyTagKeysTag: "backup-id"}},
+	} {
+		b.legacyTags = LegacyTagsConfig{Mode: tc.mode, KnownKeys: known}
+		info, err := b.Stat(ctx, "obj")
+		c.Assert(err, IsNil)
+		c.Check(info.Tags, DeepEquals, tc.tags, Commentf("mode %d", tc.mode))
+	}
+
+	// Encoded tags are marked, and read as they are written
+	b.legacyTags = LegacyTagsConfig{Mode: LegacyTagsFlag}
+	c.Assert(b.PutBytes(ctx, "new", nil, map[string]string{"app-name": "mysql"}), IsNil)
+	c.Assert(mc.items["new"].metadata[tagEncodingKey], Equals, tagEncodingValue)
+	_, tags, err := b.GetBytes(ctx, "new")
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"app-name": "mysql"})
+	// Objects without tags are not marked
+	c.Assert(b.PutBytes(ctx, "untagged", nil, nil), IsNil)
+	c.Assert(mc.items["untagged"].metadata, HasLen, 0)
+}
+
+// waitRecorder is a clock whose timers fire at once, and that records the
+// durations waited for
+type waitRecorder struct {
+	mu    sync.Mutex
+	waits []time.Duration
+}
+
+func (r *waitRecorder) Now() time.Time { return time.Time{} }
+
+func (r *waitRecorder) After(d time.Duration) <-chan time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waits = append(r.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+func (s *MigrateTagsSuite) TestMigrateTags(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("migrate")
+	d, err := b.CreateDirectory(ctx, "tree")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "current", []byte("current"), map[string]string{"app/name": "mysql"}), IsNil)
+	c.Assert(d.PutBytes(ctx, "untagged", []byte("untagged"), nil), IsNil)
+	putLegacy(c, mc, "tree/legacy1", map[string]interface{}{"app-name": "mysql", ChecksumTag: "sum"})
+	putLegacy(c, mc, "tree/sub/legacy2", map[string]interface{}{"type": "text"})
+	putLegacy(c, mc, "tree/ambiguous", map[string]interface{}{"backup-id": "1"})
+	putLegacy(c, mc, "tree/archived", map[string]interface{}{"app-name": "mysql", storageClassMetadataKey: "GLACIER"})
+	putLegacy(c, mc, "other/legacy", map[string]interface{}{"app-name": "mysql"})
+	opts := MigrateTagsOptions{KnownKeys: []string{"app/name"}, Rate: 4}
+
+	dry := opts
+	dry.DryRun = true
+	stats, err := MigrateTags(ctx, d, dry)
+	c.Assert(err, IsNil)
+	expected := MigrateTagsStats{Scanned: 6, Migrated: 2, Current: 2, Ambiguous: 1, Archived: 1}
+	c.Assert(stats, DeepEquals, expected)
+	_, ok := mc.items["tree/legacy1"].metadata[tagEncodingKey]
+	c.Assert(ok, Equals, false)
+	_, ok = mc.items["tree/"+TagMigrationCursorName]
+	c.Assert(ok, Equals, false)
+
+	clock := &waitRecorder{}
+	stats, err = MigrateTags(withClock(ctx, clock), d, opts)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, expected)
+	// Rewrites are throttled
+	c.Assert(clock.waits, DeepEquals, []time.Duration{250 * time.Millisecond, 250 * time.Millisecond})
+	_, ok = mc.items["tree/"+TagMigrationCursorName]
+	c.Assert(ok, Equals, false)
+
+	info, err := d.Stat(ctx, "legacy1")
+	c.Assert(err, IsNil)
+	c.Assert(info.Tags, DeepEquals, map[string]string{"app/name": "mysql", ChecksumTag: "sum"})
+	data, tags, err := d.GetBytes(ctx, "sub/legacy2")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "tree/sub/legacy2")
+	c.Assert(tags, DeepEquals, map[string]string{"type": "text"})
+	for _, name := range []string{"tree/ambiguous", "tree/archived", "other/legacy"} {
+		_, ok = mc.items[name].metadata[tagEncodingKey]
+		c.Check(ok, Equals, false, Commentf("%s", name))
+	}
+
+	// Migrated trees have nothing left to migrate
+	stats, err = MigrateTags(ctx, d, opts)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, MigrateTagsStats{Scanned: 6, Current: 4, Ambiguous: 1, Archived: 1})
+}
+
+// deniedPutContainer fails the uploads of an object
+type deniedPutContainer struct {
+	*memContainer
+	denied string
+}
+
+func (c *deniedPutContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	if name == c.denied {
+		return nil, errors.New("access denied")
+	}
+	return c.memContainer.Put(name, r, size, metadata)
+}
+
+func (s *MigrateTagsSuite) TestResume(c *C) {
+	ctx := context.Background()
+	mc := &deniedPutContainer{memContainer: newMemContainer("resume"), denied: "c"}
+	b := newBucket(ProviderConfig{}, mc, nil, "mem://")
+	for _, name := range []string{"a", "b", "c", "d"} {
+		putLegacy(c, mc.memContainer, name, map[string]interface{}{"type": name})
+	}
+	opts := MigrateTagsOptions{CursorInterval: 10}
+
+	stats, err := MigrateTags(ctx, b, opts)
+	c.Assert(err, ErrorMatches, "failed to migrate the tags of c: access denied")
+	c.Assert(stats, DeepEquals, MigrateTagsStats{Scanned: 2, Migrated: 2})
+	cursor, err := readTagMigrationCursor(ctx, b.directory)
+	c.Assert(err, IsNil)
+	c.Assert(cursor, DeepEquals, tagMigrationCursor{After: "b", Stats: stats})
+
+	// The migration resumes after the objects already examined
+	mc.denied = ""
+	delete(mc.items["a"].metadata, tagEncodingKey)
+	stats, err = MigrateTags(ctx, b, opts)
+	c.Assert(err, IsNil)
+	c.Assert(stats, DeepEquals, MigrateTagsStats{Scanned: 4, Migrated: 4})
+	_, ok := mc.items["a"].metadata[tagEncodingKey]
+	c.Assert(ok, Equals, false)
+	_, ok = mc.items["d"].metadata[tagEncodingKey]
+	c.Assert(ok, Equals, true)
+	_, ok = mc.items[TagMigrationCursorName]
+	c.Assert(ok, Equals, false)
+}
@@ -11,6 +11,70 @@ type ProviderConfig struct {
 	// If true, disable SSL verification. If false (the default), SSL
 	// verification is enabled.
 	SkipSSLVerify bool
+	// Limits on the object keys that can be created. Unset limits default
+	// to those of the strictest supported provider.
+	Limits PathLimits
+	// Default options for uploads, such as the threshold above which large
+	// objects are uploaded in parts. They can be overridden by Put calls.
+	PutOptions PutOptions
+	// Redaction of object names in logs, progress events and error
+	// messages. Names are not redacted by default.
+	Redaction NameRedaction
+	// PageSize is the number of items requested per page when listing.
+	// Providers may return fewer items per page, and listings continue
+	// with the next page. Defaults to 1000.
+	PageSize int
+	// DeleteBatchSize is the number of objects deleted per request by
+	// providers that support batch deletes. Defaults to, and is at most,
+	// the maximum of the provider.
+	DeleteBatchSize int
+	// DeleteConcurrency is the number of objects deleted concurrently,
+	// e.g. when the provider does not support batch deletes. Defaults to
+	// 16.
+	DeleteConcurrency int
+	// CopyConcurrency is the number of objects copied concurrently by
+	// CopyDirectory. Defaults to 16.
+	CopyConcurrency int
+	// Retry controls the retries of requests that fail with transient
+	// errors
+	Retry RetryPolicy
+	// Quota is a soft budget on the storage used by the bucket. It is
+	// disabled by default.
+	Quota QuotaConfig
+	// ListingCache caches the listings of directories. It is disabled by
+	// default.
+	ListingCache ListingCacheConfig
+	// SkipDirectoryMarkers stops CreateDirectory from writing the markers
+	// of directories. Directories then exist once objects are written in
+	// them, like the prefixes written by other tools. Markers are written
+	// by default, for older versions of Kanister.
+	SkipDirectoryMarkers bool
+	// Compression is the name of the codec with which Put compresses
+	// objects, such as CodecGzip. It can be overridden by Put calls.
+	// Objects are not compressed by default.
+	Compression string
+	// ServerSideEncryption is the encryption of the objects written by
+	// Put. It can be overridden by Put calls. Objects are encrypted as
+	// configured on the bucket by default.
+	ServerSideEncryption ServerSideEncryption
+	// Keyring encrypts the objects written by Put on the client, with its
+	// current key, and decrypts the encrypted objects read by Get. Objects
+	// are not encrypted by default.
+	Keyring *Keyring
+	// GetBytesLimit is the size in bytes of the largest object read by
+	// GetBytes, which holds the data in memory. Larger objects can be read
+	// with GetBytesLimit or Get. Defaults to 1 GiB.
+	GetBytesLimit int64
+	// InferContentType gives the objects written by Put without a content
+	// type the type of the extension of their name, e.g. application/json
+	// for manifest.json, if the provider supports content types. Since
+	// only multipart stores set them, such objects are uploaded in parts.
+	// Content types are not inferred by default.
+	InferContentType bool
+	// LegacyTags controls how the tags written before tag keys were
+	// encoded are read. Their keys are read as they are stored by default.
+	// See MigrateTags.
+	LegacyTags LegacyTagsConfig
 }
 
 // SecretAws AWS keys
@@ -0,0 +1,64 @@
+package objectstore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// MultiGet returns the data of the named objects by name, read by up to
+// concurrency goroutines at a time, or by CopyConcurrency goroutines if
+// concurrency is not positive. The first object that cannot be read cancels
+// the reads of the others, and its error is returned.
+func (d *directory) MultiGet(ctx context.Context, names []string, concurrency int) (map[string][]byte, error) {
+	if d.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+	if concurrency <= 0 {
+		concurrency = d.bucket.copyWorkers
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	objects := make(map[string][]byte, len(names))
+	work := make(chan string)
+	for i := 0; i < concurrency && i < len(names); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range work {
+				data, _, err := d.GetBytes(ctx, name)
+				mu.Lock()
+				if err == nil {
+					objects[name] = data
+				} else if firstErr == nil {
+					firstErr = errors.Wrapf(err, "failed to get object %s", d.bucket.redaction.Redact(d.absPathName(name)))
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+send:
+	for _, name := range names {
+		select {
+		case work <- name:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
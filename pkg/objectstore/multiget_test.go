@@ -0,0 +1,45 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+
+	. "gopkg.in/check.v1"
+)
+
+type MultiGetSuite struct{}
+
+var _ = Suite(&MultiGetSuite{})
+
+func (s *MultiGetSuite) TestMultiGet(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("multiget")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	var names []string
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("obj%d", i)
+		c.Assert(d.PutBytes(ctx, name, []byte(name), nil), IsNil)
+		names = append(names, name)
+	}
+	for _, concurrency := range []int{0, 1, 4, 100} {
+		objects, err := d.MultiGet(ctx, names, concurrency)
+		c.Assert(err, IsNil)
+		c.Assert(objects, HasLen, len(names))
+		for _, name := range names {
+			c.Assert(string(objects[name]), Equals, name)
+		}
+	}
+	objects, err := d.MultiGet(ctx, nil, 4)
+	c.Assert(err, IsNil)
+	c.Assert(objects, HasLen, 0)
+
+	_, err = d.MultiGet(ctx, append(names, "missing"), 4)
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	c.Assert(err, ErrorMatches, "failed to get object /dir/missing: .*")
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = d.MultiGet(ctx, names, 4)
+	c.Assert(err, NotNil)
+}
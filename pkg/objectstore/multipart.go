@@ -0,0 +1,236 @@
+package objectstore
+
+// Multipart uploads of large objects. Stow streams objects in a single
+// request, so they are handled by provider specific multipart stores.
+
+import (
+	"context"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultPartSize        = 64 * 1024 * 1024
+	defaultPartConcurrency = 4
+	defaultPartRetries     = 3
+	partRetryBackoff       = time.Second
+)
+
+// PutOptions control how objects are uploaded. Zero values use the options
+// of the bucket, or else the defaults.
+type PutOptions struct {
+	// MultipartThreshold is the size above which objects are uploaded in
+	// parts, if the provider supports it. Defaults to PartSize. Objects of
+	// unknown size, i.e. negative, are always uploaded in parts.
+	MultipartThreshold int64
+	// PartSize is the size of the parts. Defaults to 64 MiB. It is raised
+	// to the minimum part size of the provider, or as needed to stay within
+	// the maximum number of parts.
+	PartSize int64
+	// Concurrency bounds the number of parts uploaded at once. Defaults to
+	// 4. A buffer of PartSize bytes is held for each part in flight.
+	Concurrency int
+	// PartRetries is the number of times a failed part is retried before
+	// the upload fails. Defaults to 3.
+	PartRetries int
+}
+
+// merge returns the options with zero values taken from defaults
+func (o PutOptions) merge(defaults PutOptions) PutOptions {
+	if o.MultipartThreshold == 0 {
+		o.MultipartThreshold = defaults.MultipartThreshold
+	}
+	if o.PartSize == 0 {
+		o.PartSize = defaults.PartSize
+	}
+	if o.Concurrency == 0 {
+		o.Concurrency = defaults.Concurrency
+	}
+	if o.PartRetries == 0 {
+		o.PartRetries = defaults.PartRetries
+	}
+	return o
+}
+
+// withDefaults fills the options that are still unset
+func (o PutOptions) withDefaults() PutOptions {
+	o = o.merge(PutOptions{
+		PartSize:    defaultPartSize,
+		Concurrency: defaultPartConcurrency,
+		PartRetries: defaultPartRetries,
+	})
+	if o.MultipartThreshold == 0 {
+		o.MultipartThreshold = o.PartSize
+	}
+	return o
+}
+
+// completedPart is a part of a multipart upload
+type completedPart struct {
+	Number int
+	ETag   string
+}
+
+// multipartStore uploads objects in parts. Keys are names in the container.
+type multipartStore interface {
+	// PartLimits returns the minimum size of all but the last part, and the
+	// maximum number of parts
+	PartLimits() (minSize int64, maxParts int)
+	// CreateMultipart starts an upload of an object with the metadata and
+	// attributes
+	CreateMultipart(ctx context.Context, key string, metadata map[string]string, attrs objectAttrs) (uploadID string, err error)
+	UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (etag string, err error)
+	CompleteMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error
+	AbortMultipart(ctx context.Context, key, uploadID string) error
+}
+
+// partSize returns the size of the parts of an object of the given size
+func partSize(size int64, opts PutOptions, store multipartStore) int64 {
+	minSize, maxParts := store.PartLimits()
+	ps := opts.PartSize
+	if ps < minSize {
+		ps = minSize
+	}
+	// Sizes near the provider limits overflow ps*maxParts
+	if n := ceilDiv(size, int64(maxParts)); ps < n {
+		ps = n
+	}
+	return ps
+}
+
+// ceilDiv returns n/d rounded up, for n >= 0 and d > 0, without overflowing
+func ceilDiv(n, d int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	return (n-1)/d + 1
+}
+
+// multipartPut uploads r in parts, with at most opts.Concurrency parts in
+// flight. The upload is aborted if any part fails after its retries, so
+// that no incomplete parts are left behind.
+func multipartPut(ctx context.Context, b *bucket, store multipartStore, key string, r io.Reader, size int64, metadata map[string]string, attrs objectAttrs, opts PutOptions) error {
+	name := b.redaction.Redact(key)
+	ps := partSize(size, opts, store)
+	_, maxParts := store.PartLimits()
+	uploadID, err := store.CreateMultipart(ctx, key, metadata, attrs)
+	if err != nil {
+		return errors.Wrapf(err, "failed to start multipart upload of %s", name)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		parts    []completedPart
+		firstErr error
+		total    int64
+	)
+	setErr := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	sem := make(chan struct{}, opts.Concurrency)
+	for n := 1; ; n++ {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			setErr(ctx.Err())
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		buf := make([]byte, ps)
+		k, err := io.ReadFull(r, buf)
+		if err == io.EOF && n > 1 {
+			<-sem
+			break
+		}
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			<-sem
+			setErr(errors.Wrapf(err, "failed to read part %d of %s", n, name))
+			break
+		}
+		if n > maxParts {
+			<-sem
+			setErr(errors.Errorf("%s has more than the maximum of %d parts of %d bytes", name, maxParts, ps))
+			break
+		}
+		total += int64(k)
+		if err := b.validateSize(key, total); err != nil {
+			// Only reached by uploads of unknown size
+			<-sem
+			setErr(err)
+			break
+		}
+		wg.Add(1)
+		go func(n int, data []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			etag, err := uploadPart(ctx, b, store, key, uploadID, n, data, opts.PartRetries)
+			if err != nil {
+				setErr(err)
+				return
+			}
+			mu.Lock()
+			parts = append(parts, completedPart{Number: n, ETag: etag})
+			mu.Unlock()
+		}(n, buf[:k])
+		if err != nil {
+			// Short or empty last part
+			break
+		}
+	}
+	wg.Wait()
+	if firstErr == nil && size >= 0 && total != size {
+		firstErr = errors.Errorf("read %d bytes of %s, expected %d", total, name, size)
+	}
+	if firstErr == nil {
+		sort.Slice(parts, func(i, j int) bool { return parts[i].Number < parts[j].Number })
+		if firstErr = store.CompleteMultipart(ctx, key, uploadID, parts); firstErr == nil {
+			return nil
+		}
+		firstErr = errors.Wrapf(firstErr, "failed to complete multipart upload of %s", name)
+	}
+	// ctx may be canceled
+	if err := store.AbortMultipart(context.Background(), key, uploadID); err != nil {
+		log.WithError(err).Errorf("Failed to abort multipart upload %s of %s", uploadID, name)
+	}
+	return firstErr
+}
+
+// uploadPart uploads a part, retrying with exponential backoff
+func uploadPart(ctx context.Context, b *bucket, store multipartStore, key, uploadID string, n int, data []byte, retries int) (string, error) {
+	clk := clockFor(ctx, b)
+	rnd := randFor(ctx, b)
+	name := b.redaction.Redact(key)
+	backoff := partRetryBackoff
+	for attempt := 0; ; attempt++ {
+		etag, err := store.UploadPart(ctx, key, uploadID, n, data)
+		if err == nil {
+			return etag, nil
+		}
+		if attempt == retries || ctx.Err() != nil {
+			return "", errors.Wrapf(err, "failed to upload part %d of %s after %d attempts", n, name, attempt+1)
+		}
+		log.WithError(err).Warnf("Retrying upload of part %d of %s", n, name)
+		wait := jitter(rnd, backoff, 0.2)
+		countRetry(ctx, wait)
+		select {
+		case <-ctx.Done():
+			return "", errors.Wrapf(err, "failed to upload part %d of %s", n, name)
+		case <-clk.After(wait):
+		}
+		backoff *= 2
+	}
+}
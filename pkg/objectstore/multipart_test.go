@@ -0,0 +1,349 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type MultipartSuite struct{}
+
+var _ = Suite(&MultipartSuite{})
+
+var _ multipartStore = (*memMultipart)(nil)
+
+// memMultipart uploads objects in parts to a memContainer
+type memMultipart struct {
+	*memContainer
+	minSize int64
+
+	mu       sync.Mutex
+	next     int
+	uploads  map[string]map[int][]byte
+	metadata map[string]map[string]string
+	// contentTypes are the content types of the uploads by key
+	contentTypes map[string]string
+	// encryptions are the server-side encryptions of the uploads by key
+	encryptions map[string]ServerSideEncryption
+	// failures is the number of times each part fails before it succeeds
+	failures    map[int]int
+	attempts    map[int]int
+	inFlight    int
+	maxInFlight int
+	aborted     []string
+}
+
+func newMemMultipart(c *memContainer) *memMultipart {
+	return &memMultipart{
+		memContainer: c,
+		minSize:      1,
+		uploads:      make(map[string]map[int][]byte),
+		metadata:     make(map[string]map[string]string),
+		contentTypes: make(map[string]string),
+		encryptions:  make(map[string]ServerSideEncryption),
+		failures:     make(map[int]int),
+		attempts:     make(map[int]int),
+	}
+}
+
+func newMemMultipartBucket(id string) (*bucket, *memMultipart) {
+	b, c := newMemBucket(id)
+	m := newMemMultipart(c)
+	b.multipart = m
+	b.attrsReader = m
+	return b, m
+}
+
+func (m *memMultipart) PartLimits() (int64, int) {
+	return m.minSize, 100
+}
+
+func (m *memMultipart) CreateMultipart(ctx context.Context, key string, metadata map[string]string, attrs objectAttrs) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.next++
+	id := fmt.Sprintf("upload-%d", m.next)
+	m.uploads[id] = make(map[int][]byte)
+	m.metadata[id] = metadata
+	m.contentTypes[key] = attrs.contentType
+	m.encryptions[key] = attrs.encryption
+	return id, nil
+}
+
+func (m *memMultipart) ObjectAttrs(ctx context.Context, key string) (objectAttrs, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return objectAttrs{contentType: m.contentTypes[key], encryption: m.encryptions[key]}, nil
+}
+
+func (m *memMultipart) UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	m.mu.Lock()
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.attempts[number]++
+	fail := m.attempts[number] <= m.failures[number]
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}()
+	if fail {
+		return "", errors.Errorf("part %d failed", number)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[uploadID][number] = append([]byte(nil), data...)
+	return fmt.Sprintf("etag-%d", number), nil
+}
+
+func (m *memMultipart) CompleteMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	m.mu.Lock()
+	uploaded := m.uploads[uploadID]
+	metadata := m.metadata[uploadID]
+	delete(m.uploads, uploadID)
+	m.mu.Unlock()
+	var data []byte
+	for i, p := range parts {
+		if p.Number != i+1 || p.ETag != fmt.Sprintf("etag-%d", p.Number) {
+			return errors.Errorf("unexpected part %+v", p)
+		}
+		data = append(data, uploaded[p.Number]...)
+	}
+	md := make(map[string]interface{}, len(metadata))
+	for k, v := range metadata {
+		md[k] = v
+	}
+	_, err := m.memContainer.Put(key, bytes.NewReader(data), int64(len(data)), md)
+	return err
+}
+
+func (m *memMultipart) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.uploads, uploadID)
+	m.aborted = append(m.aborted, uploadID)
+	return nil
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return data
+}
+
+func (s *MultipartSuite) TestMultipartPut(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	data := testData(95)
+	opts := PutOptions{PartSize: 10, Concurrency: 2}
+	err := b.PutOpts(ctx, "dir/large", bytes.NewReader(data), int64(len(data)), map[string]string{"owner": "test"}, WithPutOptions(opts))
+	c.Assert(err, IsNil)
+
+	got, tags, err := b.GetBytes(ctx, "dir/large")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+	c.Assert(tags["owner"], Equals, "test")
+	c.Assert(m.attempts, HasLen, 10)
+	c.Assert(m.maxInFlight <= 2, Equals, true)
+	c.Assert(m.uploads, HasLen, 0)
+	c.Assert(m.aborted, HasLen, 0)
+}
+
+func (s *MultipartSuite) TestSingleStream(c *C) {
+	ctx := context.Background()
+	data := testData(95)
+
+	// Objects up to the threshold are not uploaded in parts
+	b, m := newMemMultipartBucket("multipart")
+	opts := PutOptions{PartSize: 10, MultipartThreshold: 95}
+	c.Assert(b.PutOpts(ctx, "small", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts)), IsNil)
+	c.Assert(m.next, Equals, 0)
+	got, _, err := b.GetBytes(ctx, "small")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+
+	// Providers without a multipart store upload all objects at once
+	b, _ = newMemBucket("single")
+	opts = PutOptions{PartSize: 10}
+	c.Assert(b.PutOpts(ctx, "large", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts)), IsNil)
+	got, _, err = b.GetBytes(ctx, "large")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+}
+
+func (s *MultipartSuite) TestBucketOptions(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	b.putOptions = PutOptions{PartSize: 10, MultipartThreshold: 50}
+	data := testData(60)
+
+	c.Assert(b.Put(ctx, "bucket-defaults", bytes.NewReader(data), int64(len(data)), nil), IsNil)
+	c.Assert(m.next, Equals, 1)
+	c.Assert(m.attempts, HasLen, 6)
+
+	// Options of the call take precedence
+	opts := PutOptions{MultipartThreshold: 100}
+	c.Assert(b.PutOpts(ctx, "call-options", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts)), IsNil)
+	c.Assert(m.next, Equals, 1)
+}
+
+func (s *MultipartSuite) TestUnknownSize(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	for _, n := range []int{0, 10, 25} {
+		data := testData(n)
+		name := fmt.Sprintf("unknown-%d", n)
+		opts := PutOptions{PartSize: 10}
+		c.Assert(b.PutOpts(ctx, name, bytes.NewReader(data), -1, nil, WithPutOptions(opts)), IsNil)
+		got, _, err := b.GetBytes(ctx, name)
+		c.Assert(err, IsNil)
+		c.Assert(got, HasLen, n)
+	}
+	c.Assert(m.next, Equals, 3)
+}
+
+func (s *MultipartSuite) TestPartRetry(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	clk := newFakeClock()
+	b.clock = clk
+	m.failures[3] = 2
+	data := testData(50)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.PutOpts(ctx, "flaky", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(PutOptions{PartSize: 10}))
+	}()
+	c.Assert(advanceUntilDone(clk, 0, done), IsNil)
+	c.Assert(m.attempts[3], Equals, 3)
+	c.Assert(clk.AfterCalls(), Equals, 2)
+	got, _, err := b.GetBytes(ctx, "flaky")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+}
+
+func (s *MultipartSuite) TestAbort(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	clk := newFakeClock()
+	b.clock = clk
+	m.failures[2] = 10
+	data := testData(50)
+	done := make(chan error, 1)
+	go func() {
+		opts := PutOptions{PartSize: 10, PartRetries: 1}
+		done <- b.PutOpts(ctx, "failed", bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts))
+	}()
+	err := advanceUntilDone(clk, 0, done)
+	c.Assert(err, ErrorMatches, "failed to upload part 2 of failed after 2 attempts: part 2 failed")
+	c.Assert(m.attempts[2], Equals, 2)
+	c.Assert(m.aborted, DeepEquals, []string{"upload-1"})
+	c.Assert(m.uploads, HasLen, 0)
+	_, _, err = b.GetBytes(ctx, "failed")
+	c.Assert(err, NotNil)
+}
+
+func (s *MultipartSuite) TestShortRead(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	data := testData(40)
+	err := b.PutOpts(ctx, "short", bytes.NewReader(data), 50, nil, WithPutOptions(PutOptions{PartSize: 10}))
+	c.Assert(err, ErrorMatches, "read 40 bytes of short, expected 50")
+	c.Assert(m.aborted, DeepEquals, []string{"upload-1"})
+	_, _, err = b.GetBytes(ctx, "short")
+	c.Assert(err, NotNil)
+}
+
+func (s *MultipartSuite) TestPartSize(c *C) {
+	m := newMemMultipart(newMemContainer("sizes"))
+	m.minSize = 5
+	for _, tc := range []struct {
+		size     int64
+		partSize int64
+		expected int64
+	}{
+		{size: 100, partSize: 10, expected: 10},
+		// Raised to the minimum part size
+		{size: 100, partSize: 2, expected: 5},
+		// Raised to stay within 100 parts
+		{size: 1001, partSize: 10, expected: 11},
+		{size: -1, partSize: 10, expected: 10},
+	} {
+		c.Check(partSize(tc.size, PutOptions{PartSize: tc.partSize}, m), Equals, tc.expected, Commentf("%+v", tc))
+	}
+}
+
+// s3LimitsStore has the part limits of S3
+type s3LimitsStore struct {
+	*memMultipart
+}
+
+func (s3LimitsStore) PartLimits() (int64, int) {
+	return s3MinPartSize, s3MaxParts
+}
+
+func (s *MultipartSuite) TestPartSizeBoundaries(c *C) {
+	m := s3LimitsStore{newMemMultipart(newMemContainer("sizes"))}
+	for _, tc := range []struct {
+		size     int64
+		partSize int64
+	}{
+		{size: maxObjectSize - 1},
+		{size: maxObjectSize},
+		{size: maxObjectSize + 1},
+		{size: maxObjectSize, partSize: maxObjectSize / 2},
+		// Sizes and part sizes whose products with the number of parts
+		// overflow
+		{size: math.MaxInt64},
+		{size: maxObjectSize, partSize: math.MaxInt64 / 2},
+	} {
+		ps := partSize(tc.size, PutOptions{PartSize: tc.partSize}, m)
+		comment := Commentf("%+v, part size %d", tc, ps)
+		c.Check(ps >= s3MinPartSize && ps >= tc.partSize, Equals, true, comment)
+		c.Check(ceilDiv(tc.size, ps) <= s3MaxParts, Equals, true, comment)
+	}
+	// The largest objects take all the parts
+	ps := partSize(maxObjectSize, PutOptions{}, m)
+	c.Assert(ps, Equals, int64(549755814))
+	c.Assert(ceilDiv(maxObjectSize, ps), Equals, int64(s3MaxParts))
+	c.Assert(ps <= maxPartSize, Equals, true)
+
+	c.Assert(ceilDiv(0, 10), Equals, int64(0))
+	c.Assert(ceilDiv(-1, 10), Equals, int64(0))
+	c.Assert(ceilDiv(10, 10), Equals, int64(1))
+	c.Assert(ceilDiv(11, 10), Equals, int64(2))
+	c.Assert(ceilDiv(math.MaxInt64, 1), Equals, int64(math.MaxInt64))
+}
+
+func (s *MultipartSuite) TestUnknownSizeLimit(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("multipart")
+	b.maxObjectSize = 25
+	err := b.PutOpts(ctx, "large", bytes.NewReader(testData(30)), -1, nil, WithPutOptions(PutOptions{PartSize: 10}))
+	c.Assert(IsObjectTooLarge(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object large of 30 bytes exceeds the maximum object size of 25 bytes")
+	c.Assert(m.aborted, DeepEquals, []string{"upload-1"})
+	_, _, err = b.GetBytes(ctx, "large")
+	c.Assert(err, NotNil)
+
+	c.Assert(b.PutOpts(ctx, "max", bytes.NewReader(testData(25)), -1, nil, WithPutOptions(PutOptions{PartSize: 10})), IsNil)
+}
+
+func (s *MultipartSuite) TestOptions(c *C) {
+	opts := PutOptions{Concurrency: 8}.merge(PutOptions{PartSize: 10, Concurrency: 2}).withDefaults()
+	c.Assert(opts, DeepEquals, PutOptions{
+		MultipartThreshold: 10,
+		PartSize:           10,
+		Concurrency:        8,
+		PartRetries:        defaultPartRetries,
+	})
+}
@@ -1,3 +1,10 @@
+// Package objectstore abstracts the buckets and objects of cloud providers.
+//
+// Operations that run goroutines, such as DeleteDirectory, PutOpts and
+// RewritePrefix, return only once their goroutines have stopped, and
+// stop them promptly when the context is cancelled. Readers that are
+// returned to callers, such as those of Spool and GetSpooled, own no
+// goroutines and can be closed more than once.
 package objectstore
 
 import (
@@ -5,6 +12,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"time"
 
 	"github.com/graymeta/stow"
 	stowaz "github.com/graymeta/stow/azure"
@@ -30,6 +38,9 @@ type Provider interface {
 	// ListBuckets returns all buckets and their Directory handle
 	ListBuckets(context.Context) (map[string]Bucket, error)
 
+	// Capabilities returns the features and limits of the object store
+	Capabilities() Capabilities
+
 	// getOrCreateBucket creates bucket if it does not already exist
 	getOrCreateBucket(ctx context.Context, bucketName, region string) (Bucket, error)
 }
@@ -37,41 +48,234 @@ type Provider interface {
 // Bucket abstracts the object store of different cloud providers
 type Bucket interface {
 	Directory
+
+	// Shutdown stops admitting operations, with ErrShuttingDown, asks
+	// long-running operations to stop at a safe boundary, and waits for
+	// the operations in flight until the context is done. Reads are in
+	// flight until their readers are closed.
+	Shutdown(context.Context) error
 }
 
-// Directory operations
+// Directory operations. Names of objects and directories are relative to
+// the directory, or absolute in the bucket if they start with '/'. They are
+// cleaned like paths, and fail with an ErrInvalidName if they resolve
+// outside of the directory.
 type Directory interface {
 	// CreateDirectory creates a sub directory
 	CreateDirectory(context.Context, string) (Directory, error)
 
+	// CreateDirectoryAll creates a sub directory and the directories
+	// between the current directory and it, like mkdir -p
+	CreateDirectoryAll(context.Context, string) (Directory, error)
+
 	// GetDirectory gets the sub directory
 	GetDirectory(context.Context, string) (Directory, error)
 
 	// DeleteDirectory deletes the current directory
 	DeleteDirectory(context.Context) error
 
+	// DeleteDirectoryCount deletes the current directory like
+	// DeleteDirectory, and returns the number of objects deleted
+	DeleteDirectoryCount(context.Context) (int, error)
+
+	// DeleteDirectoryDryRun returns the keys of the objects that
+	// DeleteDirectory would delete, without deleting them
+	DeleteDirectoryDryRun(context.Context) ([]string, error)
+
+	// DeleteDirectoryBestEffort deletes as much of the current directory
+	// as it can, and reports the objects that could not be deleted
+	DeleteDirectoryBestEffort(context.Context) error
+
 	// ListDirectories lists all the directories rooted in
-	// the current directory and their handle
+	// the current directory and their handle. ListPrefixes lists their
+	// names in order.
 	ListDirectories(context.Context) (map[string]Directory, error)
 
-	// ListObjects lists all the objects rooted in the current directory
+	// ListDirectoriesWithOptions lists all the directories rooted in the
+	// current directory, with their tags if requested by
+	// ListDirectoriesOptions
+	ListDirectoriesWithOptions(context.Context, ListDirectoriesOptions) (map[string]DirectoryEntry, error)
+
+	// GetDirectoryTags returns the tags of the current directory
+	GetDirectoryTags(context.Context) (map[string]string, error)
+
+	// SetDirectoryTags sets the tags of the current directory, adding them
+	// to its tags if merge is true and replacing its tags otherwise
+	SetDirectoryTags(ctx context.Context, tags map[string]string, merge bool) error
+
+	// GetObjectTags returns the object tags of the named object, which are
+	// the tags of the S3 tagging API, as opposed to its tags
+	GetObjectTags(ctx context.Context, name string) (map[string]string, error)
+
+	// SetObjectTags replaces the object tags of the named object
+	SetObjectTags(ctx context.Context, name string, tags map[string]string) error
+
+	// ListPrefixes lists the names of the sub directories of the current
+	// directory, in order
+	ListPrefixes(context.Context) ([]string, error)
+
+	// ListObjects lists all the objects rooted in the current directory,
+	// in lexicographic order
 	ListObjects(context.Context) ([]string, error)
 
-	// Get returns the io interface to read object data
+	// ListObjectsInfo lists all the objects rooted in the current directory
+	// with their size, modification time and ETag, but not their tags, in
+	// lexicographic order
+	ListObjectsInfo(context.Context) ([]ObjectInfo, error)
+
+	// ListObjectsModifiedSince lists the objects rooted in the current
+	// directory that were modified after since, in lexicographic order
+	ListObjectsModifiedSince(ctx context.Context, since time.Time) ([]string, error)
+
+	// Objects returns an iterator over the objects in the current
+	// directory, which lists them a page at a time
+	Objects(context.Context, ObjectIteratorOptions) (ObjectIterator, error)
+
+	// ListObjectsRecursive lists all the objects rooted in the current
+	// directory and its sub directories, by their path relative to it, in
+	// lexicographic order
+	ListObjectsRecursive(context.Context) ([]string, error)
+
+	// ListObjectsWithPrefix lists the objects rooted in the current
+	// directory whose relative path starts with the prefix, in
+	// lexicographic order
+	ListObjectsWithPrefix(ctx context.Context, prefix string) ([]string, error)
+
+	// IsEmpty returns true if the current directory and its sub
+	// directories have no objects other than directory markers
+	IsEmpty(context.Context) (bool, error)
+
+	// Stats returns the number of objects in the current directory and its
+	// sub directories, and their total size, without directory markers
+	Stats(context.Context) (DirectoryStats, error)
+
+	// ListObjectsPage lists up to limit objects rooted in the current
+	// directory, starting at an opaque cursor, in lexicographic order. The
+	// cursor of the first page is empty. The returned cursor is empty once
+	// all objects are listed.
+	ListObjectsPage(ctx context.Context, cursor string, limit int) ([]string, string, error)
+
+	// Exists returns true if the named object exists, without reading its
+	// data
+	Exists(context.Context, string) (bool, error)
+
+	// Stat returns the size, modification time, ETag and tags of the named
+	// object, without reading its data. It fails with an ErrObjectNotFound
+	// if the object does not exist.
+	Stat(context.Context, string) (ObjectInfo, error)
+
+	// Get returns the io interface to read object data. Cancelling the
+	// context interrupts reads. The reader must be closed.
 	Get(context.Context, string) (io.ReadCloser, map[string]string, error)
 
+	// GetSpooled returns the io interface to read object data that has
+	// been buffered locally as described by SpoolOptions
+	GetSpooled(context.Context, string, SpoolOptions) (io.ReadCloser, map[string]string, error)
+
+	// GetOpts returns the io interface to read object data, as configured
+	// by the options
+	GetOpts(context.Context, string, ...GetOption) (io.ReadCloser, map[string]string, error)
+
+	// GetRange returns the io interface to read length bytes of the named
+	// object from offset, or the rest of the object if length is negative,
+	// and the size of the whole object. It fails with an
+	// ErrRangeNotSatisfiable if offset is past the end of the object.
+	GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, int64, error)
+
+	// GetBytesRange returns length bytes of the named object from offset,
+	// or the rest of the object if length is negative, and the size of the
+	// whole object
+	GetBytesRange(ctx context.Context, name string, offset, length int64) ([]byte, int64, error)
+
 	// Get returns bytes in the named object
 	GetBytes(context.Context, string) ([]byte, map[string]string, error)
 
+	// GetBytesLimit returns the data of the named object, or an
+	// ErrObjectTooLarge if it has more than maxBytes bytes
+	GetBytesLimit(ctx context.Context, name string, maxBytes int64) ([]byte, map[string]string, error)
+
+	// MultiGet returns the data of the named objects by name, read by up
+	// to concurrency goroutines at a time. The first failed read cancels
+	// the others, and its error is returned.
+	MultiGet(ctx context.Context, names []string, concurrency int) (map[string][]byte, error)
+
+	// GetToFile writes the data of the named object to the local file at
+	// localPath, which is not left partially written if the read fails
+	GetToFile(ctx context.Context, name, localPath string) error
+
+	// PresignGet returns a URL that reads the named object without
+	// credentials until the expiry has passed. It fails with an
+	// ErrPresignUnsupported if the provider cannot presign URLs.
+	PresignGet(ctx context.Context, name string, expiry time.Duration) (string, error)
+
+	// PresignPut returns a URL that writes the named object without
+	// credentials until the expiry has passed. It fails with an
+	// ErrPresignUnsupported if the provider cannot presign URLs.
+	PresignPut(ctx context.Context, name string, expiry time.Duration) (string, error)
+
 	// Put persists data from the Reader interface in the named object
 	Put(context.Context, string, io.Reader, int64, map[string]string) error
 
+	// PutOpts persists data from the Reader interface in the named object,
+	// as configured by the options
+	PutOpts(context.Context, string, io.Reader, int64, map[string]string, ...PutOption) error
+
 	// Put persists bytes in the named object
 	PutBytes(context.Context, string, []byte, map[string]string) error
 
-	// Delete removes the object
+	// PutFile persists the data of the local file at localPath in the named
+	// object
+	PutFile(ctx context.Context, name, localPath string, tags map[string]string) error
+
+	// PutIfNotExists persists data in the named object unless it exists,
+	// in which case it fails with an ErrObjectExists. The check and the
+	// write are atomic on S3, GCS and Azure only.
+	PutIfNotExists(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error
+
+	// PutVersioned persists data in the named object and returns the ID of
+	// the new version, or an empty ID if the bucket is not versioned
+	PutVersioned(context.Context, string, io.Reader, int64, map[string]string) (string, error)
+
+	// GetVersion returns the io interface to read a version of the object.
+	// It fails with an ErrVersioningNotSupported if the bucket is not
+	// versioned.
+	GetVersion(ctx context.Context, name, version string) (io.ReadCloser, map[string]string, error)
+
+	// ListVersions lists the versions of the object, latest first. It fails
+	// with an ErrVersioningNotSupported if the bucket is not versioned.
+	ListVersions(context.Context, string) ([]ObjectVersion, error)
+
+	// Copy copies the named object, with its tags, to the object dstName
+	// of dst, which is overwritten if it exists. The source is unchanged.
+	Copy(ctx context.Context, srcName string, dst Directory, dstName string) error
+
+	// CopyDirectory copies the tree rooted in the current directory, with
+	// its directory markers and tags, to the same paths under dst
+	CopyDirectory(ctx context.Context, dst Directory) error
+
+	// Move moves the named object, with its tags, to the object dstName of
+	// dst. The source is deleted once the copy is verified.
+	Move(ctx context.Context, srcName string, dst Directory, dstName string) error
+
+	// MoveDirectory moves the tree rooted in the current directory, with
+	// its directory markers and tags, to the same paths under dst
+	MoveDirectory(ctx context.Context, dst Directory) error
+
+	// Delete removes the object. Like GetDirectory and Get, it reports
+	// missing buckets and permission errors as ErrBucketNotFound and
+	// ErrAccessDenied, and missing objects as errors that IsObjectNotFound
+	// recognizes.
 	Delete(context.Context, string) error
 
+	// DeleteObjects removes the named objects, with one request per batch
+	// of objects if the provider supports it. Missing objects are not
+	// errors. The objects that could not be deleted are reported by a
+	// BatchError.
+	DeleteObjects(ctx context.Context, names []string) error
+
+	// DeleteVersion removes a version of the object
+	DeleteVersion(ctx context.Context, name, version string) error
+
 	// Serialize directory
 	String() string
 }
@@ -152,24 +356,9 @@ func gcsConfig(ctx context.Context, secret *Secret) (stowKind string, stowConfig
 }
 
 func azureConfig(ctx context.Context, secret *Secret) (stowKind string, stowConfig stow.Config, err error) {
-	var azAccount, azStorageKey string
-	if secret != nil {
-		if secret.Type != SecretTypeAzStorageAccount {
-			return "", nil, errors.Errorf("invalid secret type %s", secret.Type)
-		}
-		azAccount = secret.Azure.StorageAccount
-		azStorageKey = secret.Azure.StorageKey
-	} else {
-		var ok bool
-		azAccount, ok = os.LookupEnv("AZURE_STORAGE_ACCOUNT")
-		if !ok {
-			return "", nil, errors.New("AZURE_STORAGE_ACCOUNT environment not set")
-		}
-
-		azStorageKey, ok = os.LookupEnv("AZURE_STORAGE_KEY")
-		if !ok {
-			return "", nil, errors.New("AZURE_STORAGE_KEY environment not set")
-		}
+	azAccount, azStorageKey, err := azureCredentials(secret)
+	if err != nil {
+		return "", nil, err
 	}
 	return stowaz.Kind, stow.ConfigMap{
 		stowaz.ConfigAccount: azAccount,
@@ -177,6 +366,28 @@ func azureConfig(ctx context.Context, secret *Secret) (stowKind string, stowConf
 	}, nil
 }
 
+// azureCredentials returns the storage account and key of the secret, or of
+// the environment if secret is nil
+func azureCredentials(secret *Secret) (azAccount, azStorageKey string, err error) {
+	if secret != nil {
+		if secret.Type != SecretTypeAzStorageAccount || secret.Azure == nil {
+			return "", "", errors.Errorf("invalid secret type %s", secret.Type)
+		}
+		return secret.Azure.StorageAccount, secret.Azure.StorageKey, nil
+	}
+	var ok bool
+	azAccount, ok = os.LookupEnv("AZURE_STORAGE_ACCOUNT")
+	if !ok {
+		return "", "", errors.New("AZURE_STORAGE_ACCOUNT environment not set")
+	}
+
+	azStorageKey, ok = os.LookupEnv("AZURE_STORAGE_KEY")
+	if !ok {
+		return "", "", errors.New("AZURE_STORAGE_KEY environment not set")
+	}
+	return azAccount, azStorageKey, nil
+}
+
 func getConfig(ctx context.Context, config ProviderConfig, secret *Secret, region string) (stowKind string, stowConfig stow.Config, err error) {
 	switch config.Type {
 	case ProviderTypeS3:
@@ -190,12 +401,15 @@ func getConfig(ctx context.Context, config ProviderConfig, secret *Secret, regio
 	}
 }
 
+// stowDial is replaced in tests with a dialer of in-memory locations
+var stowDial = stow.Dial
+
 func getStowLocation(ctx context.Context, config ProviderConfig, secret *Secret, region string) (stow.Location, error) {
 	kind, stowConfig, err := getConfig(ctx, config, secret, region)
 	if err != nil {
 		return nil, err
 	}
-	location, err := stow.Dial(kind, stowConfig)
+	location, err := stowDial(kind, stowConfig)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not create store provider %+v", config)
 	}
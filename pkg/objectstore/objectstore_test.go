@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"path"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -297,6 +299,131 @@ func (s *ObjectStoreProviderSuite) TestObjects(c *C) {
 	c.Check(err, IsNil)
 }
 
+// TestDirectoryTags verifies that tags of directories are set and merged
+func (s *ObjectStoreProviderSuite) TestDirectoryTags(c *C) {
+	ctx := context.Background()
+	rootDirectory, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+	backup, err := rootDirectory.CreateDirectory(ctx, "backup")
+	c.Assert(err, IsNil)
+
+	tags, err := backup.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, HasLen, 0)
+	c.Assert(backup.SetDirectoryTags(ctx, map[string]string{"status": "running", "blueprint": "v1"}, false), IsNil)
+	c.Assert(backup.SetDirectoryTags(ctx, map[string]string{"status": "complete"}, true), IsNil)
+	tags, err = backup.GetDirectoryTags(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"status": "complete", "blueprint": "v1"})
+
+	// The marker of an implicit directory is created
+	c.Assert(rootDirectory.PutBytes(ctx, "implicit/object", []byte("data"), nil), IsNil)
+	implicit, err := rootDirectory.GetDirectory(ctx, "implicit")
+	c.Assert(err, IsNil)
+	c.Assert(implicit.SetDirectoryTags(ctx, map[string]string{"status": "complete"}, true), IsNil)
+
+	entries, err := rootDirectory.ListDirectoriesWithOptions(ctx, ListDirectoriesOptions{Tags: true})
+	c.Assert(err, IsNil)
+	c.Assert(entries, HasLen, 2)
+	c.Assert(entries["backup"].Tags, DeepEquals, map[string]string{"status": "complete", "blueprint": "v1"})
+	c.Assert(entries["implicit"].Tags, DeepEquals, map[string]string{"status": "complete"})
+	objs, err := implicit.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"object"})
+}
+
+// TestTransferOptions verifies that the options of PutOpts and GetOpts are
+// passed through, or rejected as described by the capabilities
+func (s *ObjectStoreProviderSuite) TestTransferOptions(c *C) {
+	ctx := context.Background()
+	rootDirectory, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+	data := bytes.Repeat([]byte("0123456789"), 100)
+
+	pr := newRecordingReporter()
+	err = rootDirectory.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), map[string]string{"key": "value"},
+		WithContentType("text/plain"), WithProgress(pr), WithVerification())
+	c.Assert(err, IsNil)
+	name := path.Join("/", s.testDir, "obj")
+	c.Assert(pr.last(name), Equals, int64(len(data)))
+
+	pr = newRecordingReporter()
+	r, tags, err := rootDirectory.GetOpts(ctx, "obj", WithProgress(pr), WithVerification())
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"key": "value"})
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	c.Assert(out, DeepEquals, data)
+	c.Assert(pr.last(name), Equals, int64(len(data)))
+
+	err = rootDirectory.PutOpts(ctx, "strict", bytes.NewReader(data), int64(len(data)), nil,
+		WithContentType("text/plain"), WithStrictOptions())
+	if s.provider.Capabilities().ContentType {
+		c.Assert(err, IsNil)
+	} else {
+		c.Assert(IsOptionNotSupported(err), Equals, true)
+	}
+}
+
+// TestZeroLengthObjects verifies that empty objects are transferred with
+// their tags
+func (s *ObjectStoreProviderSuite) TestZeroLengthObjects(c *C) {
+	ctx := context.Background()
+	rootDirectory, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+	tags := map[string]string{"key": "value"}
+	err = rootDirectory.PutOpts(ctx, "empty", bytes.NewReader(nil), 0, tags, WithVerification())
+	c.Assert(err, IsNil)
+
+	r, rTags, err := rootDirectory.GetOpts(ctx, "empty", WithVerification())
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	c.Assert(data, HasLen, 0)
+	c.Assert(rTags, DeepEquals, tags)
+	info, err := rootDirectory.Stat(ctx, "empty")
+	c.Assert(err, IsNil)
+	c.Assert(info.Size, Equals, int64(0))
+	data, _, err = rootDirectory.GetBytesRange(ctx, "empty", 0, -1)
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+
+	c.Assert(rootDirectory.Copy(ctx, "empty", rootDirectory, "copy"), IsNil)
+	data, rTags, err = rootDirectory.GetBytes(ctx, "copy")
+	c.Assert(err, IsNil)
+	c.Assert(data, HasLen, 0)
+	c.Assert(rTags, DeepEquals, tags)
+	objs, err := rootDirectory.ListObjects(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(objs, DeepEquals, []string{"copy", "empty"})
+}
+
+// TestListObjectsPage verifies that pages of objects cover all objects
+func (s *ObjectStoreProviderSuite) TestListObjectsPage(c *C) {
+	ctx := context.Background()
+	rootDirectory, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+	for i := 0; i < 5; i++ {
+		err = rootDirectory.PutBytes(ctx, fmt.Sprintf("object%d", i), []byte("data"), nil)
+		c.Assert(err, IsNil)
+	}
+	var objs []string
+	cursor := ""
+	for {
+		page, next, err := rootDirectory.ListObjectsPage(ctx, cursor, 2)
+		c.Assert(err, IsNil)
+		c.Assert(len(page) <= 2, Equals, true)
+		objs = append(objs, page...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	c.Assert(objs, DeepEquals, []string{"object0", "object1", "object2", "object3", "object4"})
+}
+
 // TestObjectsStreaming verifies object operations: Get and Put
 func (s *ObjectStoreProviderSuite) TestObjectsStreaming(c *C) {
 	ctx := context.Background()
@@ -351,6 +478,76 @@ func (s *ObjectStoreProviderSuite) TestObjectsStreaming(c *C) {
 	c.Check(err, IsNil)
 }
 
+func (s *ObjectStoreProviderSuite) TestPresignGet(c *C) {
+	ctx := context.Background()
+	d, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("presigned"), nil), IsNil)
+
+	u, err := d.PresignGet(ctx, "obj", time.Minute)
+	if !s.provider.Capabilities().Presign {
+		c.Assert(IsPresignUnsupported(err), Equals, true)
+		return
+	}
+	c.Assert(err, IsNil)
+	// The URL works without credentials within the expiry
+	resp, err := http.Get(u)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "presigned")
+}
+
+func (s *ObjectStoreProviderSuite) TestPresignPut(c *C) {
+	ctx := context.Background()
+	d, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+
+	u, err := d.PresignPut(ctx, "uploaded obj", time.Minute)
+	if !s.provider.Capabilities().Presign {
+		c.Assert(IsPresignUnsupported(err), Equals, true)
+		return
+	}
+	c.Assert(err, IsNil)
+	// The URL uploads without credentials within the expiry
+	req, err := http.NewRequest(http.MethodPut, u, strings.NewReader("uploaded"))
+	c.Assert(err, IsNil)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	data, _, err := d.GetBytes(ctx, "uploaded obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "uploaded")
+}
+
+func (s *ObjectStoreProviderSuite) TestCreateDirectoryConcurrently(c *C) {
+	ctx := context.Background()
+	d, err := s.root.CreateDirectory(ctx, s.testDir)
+	c.Assert(err, IsNil)
+	paths := []string{"a", "a/b", "a/b/c", "a/b/d", "a/e"}
+	var wg sync.WaitGroup
+	errs := make(chan error, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sub, err := d.CreateDirectoryAll(ctx, paths[i%len(paths)])
+			if err == nil {
+				err = sub.PutBytes(ctx, fmt.Sprintf("obj%d", i), nil, nil)
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		c.Assert(err, IsNil)
+	}
+}
+
 func (s *ObjectStoreProviderSuite) createBucketName(c *C) string {
 	// Generate a bucket name
 	bucketName := fmt.Sprintf("kio-io-tests-%v-%d", strings.ToLower(c.TestName()), s.rand.Uint32())
@@ -0,0 +1,219 @@
+package objectstore
+
+// Object tags, which are the tags of the tagging API of S3 that lifecycle
+// rules and cost allocation reports use, as opposed to the tags of Put,
+// which are the metadata of objects. Providers without a tagging API hold
+// the object tags of an object in its ObjectTagsTag.
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ObjectTagsTag is the tag that records the URL encoded object tags of
+// objects of providers without a tagging API, such as GCS and Azure
+const ObjectTagsTag = "kanister-object-tags"
+
+const (
+	// maxObjectTags is the maximum number of object tags of an object
+	maxObjectTags = 10
+	// maxObjectTagKeyLength and maxObjectTagValueLength are the maximum
+	// lengths of the keys and values of object tags in characters
+	maxObjectTagKeyLength   = 128
+	maxObjectTagValueLength = 256
+)
+
+// objectTagRe matches the characters that S3 accepts in the keys and values
+// of object tags
+var objectTagRe = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// objectTagger reads and writes the object tags of objects. Keys are names
+// in the container.
+type objectTagger interface {
+	GetObjectTags(ctx context.Context, key string) (map[string]string, error)
+	// PutObjectTags replaces the object tags of the object
+	PutObjectTags(ctx context.Context, key string, tags map[string]string) error
+}
+
+// metadataPatcher updates the metadata of objects without writing their
+// data. Keys are names in the container.
+type metadataPatcher interface {
+	// PatchMetadata sets the metadata entries of the object, keeping the
+	// others
+	PatchMetadata(ctx context.Context, key string, metadata map[string]string) error
+}
+
+// WithObjectTags sets the object tags of the object, which must be valid
+// S3 object tags: at most 10, with keys of 1 to 128 characters and values
+// of up to 256 characters.
+func WithObjectTags(tags map[string]string) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.objectTags = tags
+	})
+}
+
+// validateObjectTags checks tags against the limits of S3 object tags
+func validateObjectTags(tags map[string]string) error {
+	if len(tags) > maxObjectTags {
+		return errors.Errorf("an object has at most %d object tags, got %d", maxObjectTags, len(tags))
+	}
+	for k, v := range tags {
+		switch {
+		case k == "":
+			return errors.New("object tag keys cannot be empty")
+		case utf8.RuneCountInString(k) > maxObjectTagKeyLength:
+			return errors.Errorf("object tag key %q is longer than %d characters", k, maxObjectTagKeyLength)
+		case utf8.RuneCountInString(v) > maxObjectTagValueLength:
+			return errors.Errorf("value of object tag %s is longer than %d characters", k, maxObjectTagValueLength)
+		case strings.HasPrefix(strings.ToLower(k), "aws:"):
+			return errors.Errorf("object tag key %q has the reserved prefix aws:", k)
+		case !objectTagRe.MatchString(k):
+			return errors.Errorf("object tag key %q has characters other than letters, digits, spaces and _.:/=+-@", k)
+		case !objectTagRe.MatchString(v):
+			return errors.Errorf("value of object tag %s has characters other than letters, digits, spaces and _.:/=+-@", k)
+		}
+	}
+	return nil
+}
+
+// encodeObjectTags returns the URL encoding of the object tags
+func encodeObjectTags(tags map[string]string) string {
+	q := url.Values{}
+	for k, v := range tags {
+		q.Set(k, v)
+	}
+	return q.Encode()
+}
+
+// decodeObjectTags returns the object tags of their URL encoding
+func decodeObjectTags(s string) (map[string]string, error) {
+	q, err := url.ParseQuery(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid object tags")
+	}
+	tags := make(map[string]string, len(q))
+	for k := range q {
+		tags[k] = q.Get(k)
+	}
+	return tags, nil
+}
+
+// GetObjectTags returns the object tags of the object <bucket>/<d.path>/name
+func (d *directory) GetObjectTags(ctx context.Context, name string) (map[string]string, error) {
+	if d.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return nil, err
+	}
+	if t := d.bucket.objectTagger; t != nil {
+		tags, err := t.GetObjectTags(ctx, cloudName(objName))
+		if IsObjectNotFound(err) {
+			return nil, &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return tags, errors.Wrapf(err, "failed to get the object tags of %s", d.bucket.redaction.Redact(objName))
+	}
+	item, err := d.item(ctx, objName)
+	if err != nil {
+		if IsObjectNotFound(err) {
+			return nil, &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return nil, err
+	}
+	metadata, err := item.Metadata()
+	if err != nil {
+		return nil, err
+	}
+	return decodeObjectTags(d.bucket.itemTags(metadata)[ObjectTagsTag])
+}
+
+// SetObjectTags replaces the object tags of the object
+// <bucket>/<d.path>/name. Providers without a tagging API update the
+// metadata of the object if they can, like GCS, and otherwise, like Azure,
+// write the object again with its data, which changes its ETag and
+// modification time.
+func (d *directory) SetObjectTags(ctx context.Context, name string, tags map[string]string) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+	if err := validateObjectTags(tags); err != nil {
+		return err
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	objName, err := d.objectName(name)
+	if err != nil {
+		return err
+	}
+	if t := d.bucket.objectTagger; t != nil {
+		err := t.PutObjectTags(ctx, cloudName(objName), tags)
+		if IsObjectNotFound(err) {
+			return &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return errors.Wrapf(err, "failed to set the object tags of %s", d.bucket.redaction.Redact(objName))
+	}
+	if p := d.bucket.metadataPatcher; p != nil {
+		err := p.PatchMetadata(ctx, cloudName(objName), map[string]string{ObjectTagsTag: encodeObjectTags(tags)})
+		if IsObjectNotFound(err) {
+			return &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return errors.Wrapf(err, "failed to set the object tags of %s", d.bucket.redaction.Redact(objName))
+	}
+	return d.rewriteObjectTags(ctx, objName, tags)
+}
+
+// rewriteObjectTags writes the object at the absolute path objName again,
+// with the object tags in its ObjectTagsTag
+func (d *directory) rewriteObjectTags(ctx context.Context, objName string, objectTags map[string]string) error {
+	_, err := d.rewriteTags(ctx, objName, func(sTags map[string]string) (map[string]string, bool) {
+		return withTag(d.bucket.decodeTags(sTags), ObjectTagsTag, encodeObjectTags(objectTags)), true
+	})
+	return err
+}
+
+// rewriteTags writes the object at the absolute path objName again, with
+// the tags that update returns for its stored tags. The object is left as
+// it is if update returns false. It returns true if the object is written.
+func (d *directory) rewriteTags(ctx context.Context, objName string, update func(sTags map[string]string) (map[string]string, bool)) (bool, error) {
+	item, err := d.item(ctx, objName)
+	if err != nil {
+		if IsObjectNotFound(err) {
+			return false, &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return false, err
+	}
+	metadata, err := item.Metadata()
+	if err != nil {
+		return false, err
+	}
+	tags, ok := update(stringTags(metadata))
+	if !ok {
+		return false, nil
+	}
+	size, err := item.Size()
+	if err != nil {
+		return false, err
+	}
+	r, err := item.Open()
+	if err != nil {
+		return false, err
+	}
+	sr, n, err := spoolToFile(r, size, "")
+	_ = r.Close()
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read object %s", d.bucket.redaction.Redact(objName))
+	}
+	defer sr.Close()
+	err = retryPut(ctx, d.bucket, objName, sr, n, sanitizeTags(tags))
+	d.bucket.listingCache.invalidate(objName)
+	return err == nil, err
+}
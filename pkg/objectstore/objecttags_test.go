@@ -0,0 +1,140 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ObjectTagsSuite struct{}
+
+var _ = Suite(&ObjectTagsSuite{})
+
+// memPatcher updates the metadata of the items of a memContainer
+type memPatcher struct {
+	*memContainer
+	patches int
+}
+
+func (p *memPatcher) PatchMetadata(ctx context.Context, key string, metadata map[string]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	item, ok := p.items[key]
+	if !ok {
+		return &ErrObjectNotFound{Name: key}
+	}
+	for k, v := range metadata {
+		item.metadata[k] = v
+	}
+	p.patches++
+	return nil
+}
+
+func (s *ObjectTagsSuite) TestValidate(c *C) {
+	for _, tc := range []struct {
+		tags map[string]string
+		err  string
+	}{
+		{tags: nil},
+		{tags: map[string]string{"expiry": "30d", "team": "data platform", "path": "a/b:c=d+e@f.g_h-i"}},
+		{tags: map[string]string{"clé": "été"}},
+		{tags: map[string]string{"": "value"}, err: "object tag keys cannot be empty"},
+		{tags: map[string]string{strings.Repeat("k", 129): ""}, err: "object tag key .* is longer than 128 characters"},
+		{tags: map[string]string{"key": strings.Repeat("v", 257)}, err: "value of object tag key is longer than 256 characters"},
+		{tags: map[string]string{"AWS:key": "value"}, err: `object tag key "AWS:key" has the reserved prefix aws:`},
+		{tags: map[string]string{"key?": "value"}, err: `object tag key "key\?" has characters other .*`},
+		{tags: map[string]string{"key": "a&b"}, err: "value of object tag key has characters other .*"},
+	} {
+		err := validateObjectTags(tc.tags)
+		if tc.err == "" {
+			c.Check(err, IsNil, Commentf("%v", tc.tags))
+		} else {
+			c.Check(err, ErrorMatches, tc.err, Commentf("%v", tc.tags))
+		}
+	}
+	tooMany := make(map[string]string)
+	for _, k := range strings.Split("abcdefghijk", "") {
+		tooMany[k] = k
+	}
+	c.Assert(validateObjectTags(tooMany), ErrorMatches, "an object has at most 10 object tags, got 11")
+	// Values can be 256 characters, not bytes, long
+	c.Assert(validateObjectTags(map[string]string{"key": strings.Repeat("é", 256)}), IsNil)
+}
+
+func (s *ObjectTagsSuite) TestMetadata(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("tags")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	objectTags := map[string]string{"expiry": "30d", "team": "data platform"}
+	err = d.PutOpts(ctx, "obj", bytes.NewReader([]byte("data")), 4, map[string]string{"type": "manifest"}, WithObjectTags(objectTags))
+	c.Assert(err, IsNil)
+	got, err := d.GetObjectTags(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, objectTags)
+	c.Assert(d.PutBytes(ctx, "untagged", []byte("data"), nil), IsNil)
+	got, err = d.GetObjectTags(ctx, "untagged")
+	c.Assert(err, IsNil)
+	c.Assert(got, HasLen, 0)
+
+	// Objects are written again with their data and tags
+	c.Assert(d.SetObjectTags(ctx, "obj", map[string]string{"expiry": "7d"}), IsNil)
+	got, err = d.GetObjectTags(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, map[string]string{"expiry": "7d"})
+	data, tags, err := d.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+	c.Assert(tags["type"], Equals, "manifest")
+
+	// GCS updates the metadata in place
+	p := &memPatcher{memContainer: mc}
+	b.metadataPatcher = p
+	c.Assert(d.SetObjectTags(ctx, "obj", map[string]string{"expiry": "1d"}), IsNil)
+	c.Assert(p.patches, Equals, 1)
+	got, err = d.GetObjectTags(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, map[string]string{"expiry": "1d"})
+
+	err = d.SetObjectTags(ctx, "missing", map[string]string{"expiry": "1d"})
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	_, err = d.GetObjectTags(ctx, "missing")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	err = d.SetObjectTags(ctx, "obj", map[string]string{"": "1d"})
+	c.Assert(err, ErrorMatches, "object tag keys cannot be empty")
+	err = d.PutOpts(ctx, "invalid", bytes.NewReader([]byte("data")), 4, nil, WithObjectTags(map[string]string{"": "1d"}))
+	c.Assert(err, ErrorMatches, "object tag keys cannot be empty")
+	_, ok := mc.items["dir/invalid"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *ObjectTagsSuite) TestS3(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	b.objectTagger = newS3ObjectTagger(b.versioner.(*s3Versioner).client, "versioned")
+	f.objects["obj"] = []fakeS3Version{{id: "v0", data: []byte("data")}}
+
+	// The tagging API tags objects once they are written, instead of their
+	// metadata
+	objectTags := map[string]string{"expiry": "30d", "team": "data platform"}
+	err := b.PutOpts(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil, WithObjectTags(objectTags))
+	c.Assert(err, IsNil)
+	c.Assert(f.objects["obj"][0].tagging, DeepEquals, objectTags)
+	_, tags, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	_, ok := tags[ObjectTagsTag]
+	c.Assert(ok, Equals, false)
+
+	c.Assert(b.SetObjectTags(ctx, "obj", map[string]string{"expiry": "7d"}), IsNil)
+	got, err := b.GetObjectTags(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, map[string]string{"expiry": "7d"})
+
+	err = b.SetObjectTags(ctx, "missing", map[string]string{"expiry": "7d"})
+	c.Assert(IsObjectNotFound(err), Equals, true)
+	c.Assert(err, ErrorMatches, "object /missing not found")
+}
@@ -0,0 +1,292 @@
+package objectstore
+
+// Options of PutOpts and GetOpts. New features are added as options, so
+// that the signatures of these methods do not change.
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrOptionNotSupported is returned by PutOpts and GetOpts in strict mode if
+// the object store does not support an option
+type ErrOptionNotSupported struct {
+	Option string
+}
+
+func (e *ErrOptionNotSupported) Error() string {
+	return fmt.Sprintf("option %s is not supported by the object store", e.Option)
+}
+
+// IsOptionNotSupported returns true if the cause of err is an
+// ErrOptionNotSupported
+func IsOptionNotSupported(err error) bool {
+	_, ok := errors.Cause(err).(*ErrOptionNotSupported)
+	return ok
+}
+
+// PutOption configures PutOpts
+type PutOption interface {
+	applyPut(*putSettings)
+}
+
+// GetOption configures GetOpts
+type GetOption interface {
+	applyGet(*getSettings)
+}
+
+// TransferOption configures both PutOpts and GetOpts
+type TransferOption interface {
+	PutOption
+	GetOption
+}
+
+// transferSettings are the settings shared by puts and gets
+type transferSettings struct {
+	progress ProgressReporter
+	verify   bool
+	strict   bool
+}
+
+type putSettings struct {
+	transferSettings
+	opts           PutOptions
+	contentType    string
+	idempotencyKey string
+	compression    *string
+	checksum       bool
+	checksumOut    *string
+	sse            *ServerSideEncryption
+	objectTags     map[string]string
+	// stored is true for the data and tags of stored objects, which are
+	// written as they are
+	stored bool
+	// versionID receives the ID of the version written, if set
+	versionID *string
+}
+
+type getSettings struct {
+	transferSettings
+	spool          *SpoolOptions
+	verifyChecksum bool
+}
+
+type putOptionFunc func(*putSettings)
+
+func (f putOptionFunc) applyPut(s *putSettings) { f(s) }
+
+type getOptionFunc func(*getSettings)
+
+func (f getOptionFunc) applyGet(s *getSettings) { f(s) }
+
+type transferOptionFunc func(*transferSettings)
+
+func (f transferOptionFunc) applyPut(s *putSettings) { f(&s.transferSettings) }
+func (f transferOptionFunc) applyGet(s *getSettings) { f(&s.transferSettings) }
+
+// WithPutOptions uploads large objects in parts as described by opts
+func WithPutOptions(opts PutOptions) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.opts = opts
+	})
+}
+
+// WithContentType sets the content type of the object. It requires the
+// ContentType capability.
+func WithContentType(contentType string) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.contentType = contentType
+	})
+}
+
+// WithIdempotency records key in the IdempotencyKeyTag of the object, in
+// place of the key of the context
+func WithIdempotency(key string) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.idempotencyKey = key
+	})
+}
+
+// WithCompression compresses the object with the registered codec of the
+// name, in place of the Compression of the bucket. An empty name disables
+// compression.
+func WithCompression(codec string) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.compression = &codec
+	})
+}
+
+// asStored writes the data and tags of a stored object as they are, without
+// compressing or encrypting the data again
+func asStored() PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.stored = true
+	})
+}
+
+// withVersionID writes the object as a new version if the bucket is
+// versioned, and sets id to the ID of the version
+func withVersionID(id *string) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.versionID = id
+	})
+}
+
+// WithSpool reads the object into local storage as described by opts, like
+// GetSpooled
+func WithSpool(opts SpoolOptions) GetOption {
+	return getOptionFunc(func(s *getSettings) {
+		s.spool = &opts
+	})
+}
+
+// WithProgress reports the progress of the transfer to pr, in place of the
+// ProgressReporter of the context
+func WithProgress(pr ProgressReporter) TransferOption {
+	return transferOptionFunc(func(s *transferSettings) {
+		s.progress = pr
+	})
+}
+
+// WithVerification compares the MD5 digest of the transferred data with the
+// ETag of the object, and fails with an ErrChecksumMismatch if they differ.
+// Objects whose ETag is not an MD5 digest, such as those uploaded in parts,
+// are not verified, but the parts uploaded to S3 are verified by S3 with
+// their Content-MD5. WithChecksum returns a digest of the data to record.
+func WithVerification() TransferOption {
+	return transferOptionFunc(func(s *transferSettings) {
+		s.verify = true
+	})
+}
+
+// WithStrictOptions fails transfers with an ErrOptionNotSupported if the
+// object store does not support one of the options. Unsupported options are
+// ignored with a warning otherwise.
+func WithStrictOptions() TransferOption {
+	return transferOptionFunc(func(s *transferSettings) {
+		s.strict = true
+	})
+}
+
+func newPutSettings(opts []PutOption) putSettings {
+	var s putSettings
+	for _, o := range opts {
+		o.applyPut(&s)
+	}
+	return s
+}
+
+func newGetSettings(opts []GetOption) getSettings {
+	var s getSettings
+	for _, o := range opts {
+		o.applyGet(&s)
+	}
+	return s
+}
+
+// unsupported handles an option that the object store does not support. It
+// fails in strict mode, and clears the option otherwise.
+func (s transferSettings) unsupported(option string, clear func()) error {
+	if s.strict {
+		return &ErrOptionNotSupported{Option: option}
+	}
+	log.Warnf("Ignoring option %s, which is not supported by the object store", option)
+	clear()
+	return nil
+}
+
+// context returns ctx with the ProgressReporter of the settings, if set
+func (s transferSettings) context(ctx context.Context) context.Context {
+	if s.progress == nil {
+		return ctx
+	}
+	return WithProgressReporter(ctx, s.progress)
+}
+
+// supportsContentType returns true if objects of b can be given a content
+// type. Only multipart stores set content types.
+func (b *bucket) supportsContentType() bool {
+	return b.multipart != nil
+}
+
+// verifyChecksum compares the digest h with the ETag of an object, unless
+// the ETag is not an MD5 digest. It fails with an ErrChecksumMismatch if
+// they differ.
+func verifyChecksum(name string, h hash.Hash, etag string) error {
+	if !isMD5ETag(etag) {
+		log.Debugf("Skipping verification of %s, its ETag is not an MD5 digest", name)
+		return nil
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != strings.Trim(etag, `"`) {
+		return &ErrChecksumMismatch{Name: name, Algorithm: "MD5", Expected: strings.Trim(etag, `"`), Actual: sum}
+	}
+	return nil
+}
+
+// hashingReader writes the data read to a hash
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+func (r *hashingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	_, _ = r.h.Write(p[:n])
+	return n, err
+}
+
+// hashingReadSeeker is a hashingReader whose reader can be read again from
+// the start, as retryPut does. Seeking restarts the hash, so only seeks back
+// to the position at which hashing started are supported.
+type hashingReadSeeker struct {
+	hashingReader
+}
+
+func (r *hashingReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	n, err := r.r.(io.Seeker).Seek(offset, whence)
+	if err == nil && !(offset == 0 && whence == io.SeekCurrent) {
+		r.h.Reset()
+	}
+	return n, err
+}
+
+// newHashingReader returns a reader that writes the data read from r to h,
+// and that is an io.Seeker if r is
+func newHashingReader(r io.Reader, h hash.Hash) io.Reader {
+	if _, ok := r.(io.Seeker); ok {
+		return &hashingReadSeeker{hashingReader{r: r, h: h}}
+	}
+	return &hashingReader{r: r, h: h}
+}
+
+// verifyingReadCloser verifies the data read against the ETag of the object
+// once it is read to the end
+type verifyingReadCloser struct {
+	io.ReadCloser
+	name string
+	etag string
+	h    hash.Hash
+}
+
+func newVerifyingReadCloser(rc io.ReadCloser, name, etag string) *verifyingReadCloser {
+	return &verifyingReadCloser{ReadCloser: rc, name: name, etag: etag, h: md5.New()}
+}
+
+func (r *verifyingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	_, _ = r.h.Write(p[:n])
+	if err == io.EOF {
+		if verr := verifyChecksum(r.name, r.h, r.etag); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,156 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type OptionsSuite struct{}
+
+var _ = Suite(&OptionsSuite{})
+
+// corruptingContainer stores the data of objects with its first byte
+// changed
+type corruptingContainer struct {
+	*memContainer
+}
+
+func (c *corruptingContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 {
+		data[0]++
+	}
+	return c.memContainer.Put(name, bytes.NewReader(data), size, metadata)
+}
+
+func (s *OptionsSuite) TestContentType(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("options")
+	data := []byte("small object")
+	err := b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil, WithContentType("text/plain"), WithStrictOptions())
+	c.Assert(err, IsNil)
+	c.Assert(m.contentTypes["obj"], Equals, "text/plain")
+	out, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, data)
+	info, err := b.Stat(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(info.ContentType, Equals, "text/plain")
+
+	// Objects without a content type are uploaded as before
+	c.Assert(b.PutBytes(ctx, "untyped", data, nil), IsNil)
+	_, ok := m.contentTypes["untyped"]
+	c.Assert(ok, Equals, false)
+	info, err = b.Stat(ctx, "untyped")
+	c.Assert(err, IsNil)
+	c.Assert(info.ContentType, Equals, "")
+
+	// Without a multipart store, the content type is ignored unless the
+	// options are strict
+	b, mc := newMemBucket("options")
+	err = b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil, WithContentType("text/plain"))
+	c.Assert(err, IsNil)
+	c.Assert(mc.items["obj"], NotNil)
+	err = b.PutOpts(ctx, "strict", bytes.NewReader(data), int64(len(data)), nil, WithContentType("text/plain"), WithStrictOptions())
+	c.Assert(IsOptionNotSupported(err), Equals, true)
+	c.Assert(err, ErrorMatches, "option content type is not supported by the object store")
+	c.Assert(mc.items["strict"], IsNil)
+}
+
+func (s *OptionsSuite) TestProgress(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("options")
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	pr := newRecordingReporter()
+	err := b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil, WithProgress(pr))
+	c.Assert(err, IsNil)
+	c.Assert(pr.last("/obj"), Equals, int64(len(data)))
+
+	pr = newRecordingReporter()
+	r, _, err := b.GetOpts(ctx, "obj", WithProgress(pr))
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	c.Assert(pr.last("/obj"), Equals, int64(len(data)))
+	c.Assert(pr.totals["/obj"], Equals, int64(len(data)))
+}
+
+func (s *OptionsSuite) TestVerification(c *C) {
+	ctx := context.Background()
+	data := []byte("verified data")
+
+	// Retried uploads are verified against the data of the last attempt
+	b, fc := newFlakyBucket(errors.New("503 Service Unavailable"))
+	fc.failures["put"] = 1
+	err := b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil, WithVerification())
+	c.Assert(err, IsNil)
+	c.Assert(fc.attempts["put"], Equals, 2)
+
+	b, mc := newMemBucket("options")
+	b.container = &corruptingContainer{memContainer: mc}
+	err = b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil, WithVerification())
+	c.Assert(IsChecksumMismatch(err), Equals, true)
+	c.Assert(err, ErrorMatches, "MD5 checksum mismatch for object /obj, expected [0-9a-f]{32}, got [0-9a-f]{32}")
+
+	// Reads fail at the end of a corrupted object
+	b, mc = newMemBucket("options")
+	c.Assert(b.PutBytes(ctx, "obj", data, nil), IsNil)
+	r, _, err := b.GetOpts(ctx, "obj", WithVerification())
+	c.Assert(err, IsNil)
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, data)
+	c.Assert(r.Close(), IsNil)
+
+	sum := md5.Sum([]byte("other data"))
+	mc.items["obj"].etag = hex.EncodeToString(sum[:])
+	r, _, err = b.GetOpts(ctx, "obj", WithVerification())
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(IsChecksumMismatch(err), Equals, true)
+	c.Assert(err, ErrorMatches, "MD5 checksum mismatch for object /obj, expected "+hex.EncodeToString(sum[:])+", got .*")
+	c.Assert(r.Close(), IsNil)
+
+	// Without verification, and with ETags that are not MD5 digests, the
+	// data is not checked
+	r, _, err = b.GetOpts(ctx, "obj")
+	c.Assert(err, IsNil)
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	mc.items["obj"].etag = "abc-2"
+	out, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, data)
+}
+
+func (s *OptionsSuite) TestSpool(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("options")
+	data := []byte("spooled data")
+	c.Assert(b.PutBytes(ctx, "obj", data, map[string]string{"key": "value"}), IsNil)
+	r, tags, err := b.GetOpts(ctx, "obj", WithSpool(SpoolOptions{}))
+	c.Assert(err, IsNil)
+	c.Assert(tags, DeepEquals, map[string]string{"key": "value"})
+	out, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, data)
+	c.Assert(r.Close(), IsNil)
+
+	sum := md5.Sum([]byte("other data"))
+	mc.items["obj"].etag = hex.EncodeToString(sum[:])
+	_, _, err = b.GetOpts(ctx, "obj", WithSpool(SpoolOptions{}))
+	c.Assert(err, ErrorMatches, "failed to spool object obj: checksum mismatch.*")
+}
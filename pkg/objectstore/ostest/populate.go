@@ -0,0 +1,309 @@
+// Package ostest generates reproducible trees of objects for benchmarks and
+// soak tests of object stores.
+package ostest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+const (
+	defaultConcurrency = 8
+	defaultSigma       = 1.0
+	// maxValidateErrors is the number of mismatches reported by Validate
+	maxValidateErrors = 10
+)
+
+// SizeDistribution is the distribution of the sizes of generated objects
+type SizeDistribution string
+
+const (
+	// SizeFixed makes every object Size bytes long
+	SizeFixed SizeDistribution = "fixed"
+	// SizeLogNormal draws sizes from a log-normal distribution with a
+	// median of Size bytes
+	SizeLogNormal SizeDistribution = "lognormal"
+)
+
+// PopulateSpec describes a tree of objects. The same spec always generates
+// the same names, sizes, content and tags.
+type PopulateSpec struct {
+	// Objects is the number of objects
+	Objects int
+	// Distribution of the sizes of the objects. Defaults to SizeFixed.
+	Distribution SizeDistribution
+	// Size is the size of objects for SizeFixed, and the median size for
+	// SizeLogNormal
+	Size int64
+	// Sigma is the standard deviation of the logarithm of the sizes for
+	// SizeLogNormal. Defaults to 1.
+	Sigma float64
+	// MaxSize caps the sizes of SizeLogNormal. No cap applies if it is 0.
+	MaxSize int64
+	// FanOut is the number of sub directories per directory. Objects are
+	// spread evenly over the directories at Depth levels. All objects are
+	// at the root if either is 0.
+	FanOut int
+	Depth  int
+	// Tags are set on every object. "{index}" in a value is replaced by the
+	// index of the object.
+	Tags map[string]string
+	// Seed of the generated sizes and content
+	Seed int64
+	// Concurrency is the number of objects uploaded or read at the same
+	// time. Defaults to 8.
+	Concurrency int
+}
+
+// Summary describes a generated tree
+type Summary struct {
+	Objects int
+	Bytes   int64
+	// Checksum is the SHA-256 of the names and content of all objects. It
+	// identifies the tree generated by a spec.
+	Checksum string
+}
+
+// object is a generated object
+type object struct {
+	name string
+	data []byte
+	tags map[string]string
+}
+
+// Name returns the path of object i of the tree described by spec, relative
+// to the root of the tree
+func (spec PopulateSpec) Name(i int) string {
+	var dirs []string
+	if spec.FanOut > 0 {
+		n := i
+		for l := 0; l < spec.Depth; l++ {
+			dirs = append(dirs, fmt.Sprintf("d%d", n%spec.FanOut))
+			n /= spec.FanOut
+		}
+	}
+	return path.Join(append(dirs, fmt.Sprintf("obj%08d", i))...)
+}
+
+// object generates object i, independently of the others
+func (spec PopulateSpec) object(i int) object {
+	r := rand.New(rand.NewSource(spec.Seed*1000003 + int64(i)))
+	size := spec.Size
+	if spec.Distribution == SizeLogNormal {
+		sigma := spec.Sigma
+		if sigma <= 0 {
+			sigma = defaultSigma
+		}
+		size = int64(float64(spec.Size) * math.Exp(sigma*r.NormFloat64()))
+		if spec.MaxSize > 0 && size > spec.MaxSize {
+			size = spec.MaxSize
+		}
+	}
+	data := make([]byte, size)
+	_, _ = r.Read(data)
+	var tags map[string]string
+	if len(spec.Tags) > 0 {
+		tags = make(map[string]string, len(spec.Tags))
+		for k, v := range spec.Tags {
+			tags[k] = strings.Replace(v, "{index}", strconv.Itoa(i), -1)
+		}
+	}
+	return object{name: spec.Name(i), data: data, tags: tags}
+}
+
+func (spec PopulateSpec) validate() error {
+	if spec.Objects < 0 || spec.Size < 0 {
+		return errors.New("the number and size of objects cannot be negative")
+	}
+	switch spec.Distribution {
+	case "", SizeFixed, SizeLogNormal:
+	default:
+		return errors.Errorf("unsupported size distribution %q", spec.Distribution)
+	}
+	return nil
+}
+
+func (spec PopulateSpec) concurrency() int {
+	if spec.Concurrency <= 0 {
+		return defaultConcurrency
+	}
+	return spec.Concurrency
+}
+
+// summarize computes the Summary of the tree from the SHA-256 of each
+// object
+func summarize(spec PopulateSpec, sizes []int64, sums [][]byte) Summary {
+	s := Summary{Objects: spec.Objects}
+	h := sha256.New()
+	for i, sum := range sums {
+		s.Bytes += sizes[i]
+		fmt.Fprintf(h, "%s\n", spec.Name(i))
+		h.Write(sum)
+	}
+	s.Checksum = hex.EncodeToString(h.Sum(nil))
+	return s
+}
+
+// forEach calls f for each object index, with the concurrency of spec. It
+// returns the first error, after all calls have returned.
+func forEach(ctx context.Context, spec PopulateSpec, f func(ctx context.Context, i int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	for w := 0; w < spec.concurrency(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if err := f(ctx, i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					cancel()
+				}
+			}
+		}()
+	}
+send:
+	for i := 0; i < spec.Objects; i++ {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// Populate uploads the tree described by spec to d
+func Populate(ctx context.Context, d objectstore.Directory, spec PopulateSpec) (Summary, error) {
+	if err := spec.validate(); err != nil {
+		return Summary{}, err
+	}
+	sizes := make([]int64, spec.Objects)
+	sums := make([][]byte, spec.Objects)
+	err := forEach(ctx, spec, func(ctx context.Context, i int) error {
+		o := spec.object(i)
+		if err := d.PutBytes(ctx, o.name, o.data, o.tags); err != nil {
+			return errors.Wrapf(err, "failed to upload %s", o.name)
+		}
+		sum := sha256.Sum256(o.data)
+		sizes[i], sums[i] = int64(len(o.data)), sum[:]
+		return nil
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	return summarize(spec, sizes, sums), nil
+}
+
+// Validate checks that d holds exactly the tree described by spec, with the
+// expected content and tags
+func Validate(ctx context.Context, d objectstore.Directory, spec PopulateSpec) (Summary, error) {
+	if err := spec.validate(); err != nil {
+		return Summary{}, err
+	}
+	names, err := d.ListObjectsRecursive(ctx)
+	if err != nil {
+		return Summary{}, errors.Wrap(err, "failed to list the tree")
+	}
+	expected := make(map[string]bool, spec.Objects)
+	for i := 0; i < spec.Objects; i++ {
+		expected[spec.Name(i)] = true
+	}
+	var problems []string
+	for _, n := range names {
+		if !expected[n] {
+			problems = append(problems, fmt.Sprintf("unexpected object %s", n))
+		}
+	}
+
+	var mu sync.Mutex
+	sizes := make([]int64, spec.Objects)
+	sums := make([][]byte, spec.Objects)
+	err = forEach(ctx, spec, func(ctx context.Context, i int) error {
+		o := spec.object(i)
+		data, tags, err := d.GetBytes(ctx, o.name)
+		problem := ""
+		switch {
+		case err != nil:
+			problem = fmt.Sprintf("failed to read %s: %v", o.name, err)
+		case !bytes.Equal(data, o.data):
+			problem = fmt.Sprintf("content of %s does not match: %d bytes instead of %d", o.name, len(data), len(o.data))
+		default:
+			problem = checkTags(o, tags)
+		}
+		if problem != "" {
+			mu.Lock()
+			problems = append(problems, problem)
+			mu.Unlock()
+			return nil
+		}
+		sum := sha256.Sum256(data)
+		sizes[i], sums[i] = int64(len(data)), sum[:]
+		return nil
+	})
+	if err != nil {
+		return Summary{}, err
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		msg := strings.Join(problems[:minInt(len(problems), maxValidateErrors)], "; ")
+		if len(problems) > maxValidateErrors {
+			msg += fmt.Sprintf("; and %d more", len(problems)-maxValidateErrors)
+		}
+		return Summary{}, errors.Errorf("tree does not match the spec: %s", msg)
+	}
+	return summarize(spec, sizes, sums), nil
+}
+
+// checkTags compares the tags read back with those of o. Providers may
+// change the case of tag keys, and '/' in keys is replaced with '-'.
+func checkTags(o object, tags map[string]string) string {
+	read := make(map[string]string, len(tags))
+	for k, v := range tags {
+		read[tagKey(k)] = v
+	}
+	for k, v := range o.tags {
+		k = tagKey(k)
+		if got, ok := read[k]; !ok || got != v {
+			return fmt.Sprintf("tag %s of %s is %q instead of %q", k, o.name, got, v)
+		}
+	}
+	return ""
+}
+
+func tagKey(k string) string {
+	return strings.ToLower(strings.Replace(k, "/", "-", -1))
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,132 @@
+package ostest
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type PopulateSuite struct{}
+
+var _ = Suite(&PopulateSuite{})
+
+// memDirectory implements the parts of objectstore.Directory used by
+// Populate and Validate
+type memDirectory struct {
+	objectstore.Directory
+	mu      sync.Mutex
+	objects map[string][]byte
+	tags    map[string]map[string]string
+}
+
+func newMemDirectory() *memDirectory {
+	return &memDirectory{objects: make(map[string][]byte), tags: make(map[string]map[string]string)}
+}
+
+func (d *memDirectory) PutBytes(ctx context.Context, name string, data []byte, tags map[string]string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.objects[name] = append([]byte(nil), data...)
+	d.tags[name] = tags
+	return nil
+}
+
+func (d *memDirectory) GetBytes(ctx context.Context, name string) ([]byte, map[string]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, ok := d.objects[name]
+	if !ok {
+		return nil, nil, errors.New("not found")
+	}
+	return data, d.tags[name], nil
+}
+
+func (d *memDirectory) ListObjectsRecursive(ctx context.Context) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var names []string
+	for n := range d.objects {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *PopulateSuite) TestPopulate(c *C) {
+	ctx := context.Background()
+	spec := PopulateSpec{
+		Objects:      50,
+		Distribution: SizeLogNormal,
+		Size:         100,
+		MaxSize:      1000,
+		FanOut:       3,
+		Depth:        2,
+		Tags:         map[string]string{"kanister.io/index": "obj-{index}"},
+		Seed:         42,
+		Concurrency:  4,
+	}
+	d := newMemDirectory()
+	summary, err := Populate(ctx, d, spec)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Objects, Equals, 50)
+	c.Assert(d.objects, HasLen, 50)
+	c.Assert(d.objects["d1/d2/obj00000007"], NotNil)
+	c.Assert(d.tags["obj00000007"], IsNil)
+	c.Assert(d.tags["d1/d2/obj00000007"], DeepEquals, map[string]string{"kanister.io/index": "obj-7"})
+	sizes := make(map[int]bool)
+	var total int64
+	for _, data := range d.objects {
+		c.Assert(len(data) <= 1000, Equals, true)
+		sizes[len(data)] = true
+		total += int64(len(data))
+	}
+	c.Assert(summary.Bytes, Equals, total)
+	c.Assert(len(sizes) > 10, Equals, true)
+
+	// The tree is reproducible
+	other := newMemDirectory()
+	spec.Concurrency = 1
+	again, err := Populate(ctx, other, spec)
+	c.Assert(err, IsNil)
+	c.Assert(again, DeepEquals, summary)
+	c.Assert(other.objects, DeepEquals, d.objects)
+	validated, err := Validate(ctx, d, spec)
+	c.Assert(err, IsNil)
+	c.Assert(validated, DeepEquals, summary)
+
+	spec.Seed = 43
+	seeded, err := Populate(ctx, newMemDirectory(), spec)
+	c.Assert(err, IsNil)
+	c.Assert(seeded.Checksum, Not(Equals), summary.Checksum)
+}
+
+func (s *PopulateSuite) TestValidate(c *C) {
+	ctx := context.Background()
+	spec := PopulateSpec{Objects: 20, Size: 10, Tags: map[string]string{"index": "{index}"}}
+	d := newMemDirectory()
+	summary, err := Populate(ctx, d, spec)
+	c.Assert(err, IsNil)
+	c.Assert(summary.Bytes, Equals, int64(200))
+
+	d.objects["obj00000003"][0]++
+	delete(d.objects, "obj00000005")
+	d.objects["extra"] = nil
+	d.tags["obj00000009"] = map[string]string{"index": "8"}
+	_, err = Validate(ctx, d, spec)
+	c.Assert(err, ErrorMatches, "tree does not match the spec: "+
+		"content of obj00000003 does not match: 10 bytes instead of 10; "+
+		"failed to read obj00000005: not found; "+
+		`tag index of obj00000009 is "8" instead of "9"; `+
+		"unexpected object extra")
+
+	_, err = Populate(ctx, d, PopulateSpec{Objects: 1, Distribution: "uniform"})
+	c.Assert(err, ErrorMatches, `unsupported size distribution "uniform"`)
+}
@@ -0,0 +1,355 @@
+package objectstore
+
+// Estimates of the work done by operations over a directory tree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+const (
+	// defaultPlanThroughput is the transfer rate assumed in bytes per second
+	defaultPlanThroughput = 100 * 1024 * 1024
+	// defaultPlanLatency is the duration assumed per request
+	defaultPlanLatency = 50 * time.Millisecond
+	// planProbeBytes is the most data read to measure the throughput
+	planProbeBytes = 8 * 1024 * 1024
+	// maxPlanWarnings is the number of warnings in a plan
+	maxPlanWarnings = 20
+	// maxSinglePutSize is the largest object uploaded in a single request
+	maxSinglePutSize = 5 * 1024 * 1024 * 1024
+	// maxPartSize is the largest part of a multipart upload
+	maxPartSize = 5 * 1024 * 1024 * 1024
+)
+
+// OperationType is the operation that a Plan is made for
+type OperationType string
+
+const (
+	// OperationCopy copies the objects of the tree to another directory
+	OperationCopy OperationType = "copy"
+	// OperationDelete deletes the tree
+	OperationDelete OperationType = "delete"
+	// OperationDownload reads the objects of the tree
+	OperationDownload OperationType = "download"
+	// OperationVerify reads the objects of the tree to verify their data
+	OperationVerify OperationType = "verify"
+)
+
+// RequestType is a kind of request made to the object store
+type RequestType string
+
+const (
+	// RequestList counts the pages listed
+	RequestList RequestType = "list"
+	// RequestGet counts the objects read
+	RequestGet RequestType = "get"
+	// RequestPut counts the objects, or parts of objects, uploaded
+	RequestPut RequestType = "put"
+	// RequestDelete counts the delete requests, each of which may delete a
+	// batch of objects
+	RequestDelete RequestType = "delete"
+	// RequestMultipart counts the requests that start and complete
+	// multipart uploads. Parts are counted as puts.
+	RequestMultipart RequestType = "multipart"
+)
+
+// WarningKind is the kind of problem reported by a PlanWarning
+type WarningKind string
+
+const (
+	// WarningArchived reports objects in archival storage, which cannot be
+	// read without being restored
+	WarningArchived WarningKind = "archived"
+	// WarningKeyLimit reports objects whose key would exceed the limits of
+	// the destination
+	WarningKeyLimit WarningKind = "keyLimit"
+	// WarningObjectSize reports objects that exceed the size limits of the
+	// provider
+	WarningObjectSize WarningKind = "objectSize"
+)
+
+// OperationSpec describes the operation to plan
+type OperationSpec struct {
+	Type OperationType
+	// Source is the root of the tree that the operation applies to
+	Source Directory
+	// Destination is the directory that a tree is copied to. The limits of
+	// the source bucket apply if it is not set.
+	Destination Directory
+	// MaxObjects bounds the number of objects that are walked. The plan
+	// then only covers a sample of the tree. All objects are walked if it
+	// is 0.
+	MaxObjects int
+	// Throughput is the transfer rate assumed in bytes per second.
+	// Defaults to 100 MiB/s.
+	Throughput int64
+	// Probe measures the throughput by reading part of the largest object
+	// of the tree, instead of assuming it
+	Probe bool
+	// RequestLatency is the duration assumed per request. Defaults to 50ms.
+	RequestLatency time.Duration
+	// Concurrency is the number of requests assumed in flight. Defaults to
+	// the DeleteConcurrency of the bucket for deletes, and to the default
+	// concurrency of RewritePrefix otherwise.
+	Concurrency int
+}
+
+// PlanWarning is a problem that the operation is likely to run into
+type PlanWarning struct {
+	Kind WarningKind `json:"kind"`
+	// Object is the path of the object relative to the source, redacted
+	// like the names in logs
+	Object  string `json:"object,omitempty"`
+	Message string `json:"message"`
+}
+
+// Plan is the estimated cost of an operation. It serializes to JSON.
+type Plan struct {
+	Operation OperationType `json:"operation"`
+	// Objects is the number of objects, not counting directory markers
+	Objects int64 `json:"objects"`
+	// Bytes is the total size of the objects
+	Bytes int64 `json:"bytes"`
+	// Sampled is true if the walk stopped at MaxObjects. Counts and
+	// estimates are then lower bounds.
+	Sampled bool `json:"sampled"`
+	// Archived is the number of objects in archival storage
+	Archived int64 `json:"archived"`
+	// Requests is the estimated number of requests by type
+	Requests map[RequestType]int64 `json:"requests"`
+	// Throughput is the transfer rate used for the estimate, in bytes per
+	// second
+	Throughput int64 `json:"throughput"`
+	// Measured is true if Throughput was measured by a probe
+	Measured bool `json:"measured"`
+	// Duration is the estimated duration of the operation
+	Duration time.Duration `json:"duration"`
+	// Warnings lists the first problems found
+	Warnings []PlanWarning `json:"warnings,omitempty"`
+	// MoreWarnings is the number of problems that are not listed
+	MoreWarnings int `json:"moreWarnings,omitempty"`
+}
+
+// PlanOperation walks the tree rooted at op.Source, or a sample of it, and
+// estimates the number of requests and the duration of the operation.
+// Nothing is modified.
+func PlanOperation(ctx context.Context, op OperationSpec) (Plan, error) {
+	switch op.Type {
+	case OperationCopy, OperationDelete, OperationDownload, OperationVerify:
+	default:
+		return Plan{}, errors.Errorf("unsupported operation %q", op.Type)
+	}
+	src, err := asDirectory(op.Source)
+	if err != nil {
+		return Plan{}, err
+	}
+	dst := src
+	if op.Destination != nil {
+		if dst, err = asDirectory(op.Destination); err != nil {
+			return Plan{}, err
+		}
+	}
+
+	p := &planner{
+		op:   op,
+		src:  src,
+		dst:  dst,
+		opts: dst.bucket.putOptions.withDefaults(),
+		plan: Plan{
+			Operation: op.Type,
+			Requests:  make(map[RequestType]int64),
+		},
+	}
+	if err := p.walk(ctx); err != nil {
+		return Plan{}, err
+	}
+	if err := p.estimate(ctx); err != nil {
+		return Plan{}, err
+	}
+	return p.plan, nil
+}
+
+type planner struct {
+	op       OperationSpec
+	src      *directory
+	dst      *directory
+	opts     PutOptions
+	plan     Plan
+	items    int64
+	largest  string
+	largestN int64
+}
+
+// errPlanSampled stops the walk once enough objects were seen
+var errPlanSampled = errors.New("sampled")
+
+func (p *planner) walk(ctx context.Context) error {
+	prefix := cloudName(p.src.path)
+	err := stow.Walk(p.src.bucket.container, prefix, p.src.bucket.pageSize,
+		func(item stow.Item, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if p.op.MaxObjects > 0 && p.plan.Objects >= int64(p.op.MaxObjects) {
+				p.plan.Sampled = true
+				return errPlanSampled
+			}
+			p.items++
+			name := strings.TrimPrefix(item.Name(), prefix)
+			if name == "" || strings.HasSuffix(name, "/") {
+				return nil
+			}
+			size, err := item.Size()
+			if err != nil {
+				return err
+			}
+			p.plan.Objects++
+			p.plan.Bytes += size
+			if size > p.largestN || p.largest == "" {
+				p.largest, p.largestN = name, size
+			}
+			p.addObject(item, name, size)
+			return nil
+		})
+	if err == errPlanSampled {
+		return nil
+	}
+	return err
+}
+
+// addObject counts the requests for an object and checks it for problems
+func (p *planner) addObject(item stow.Item, name string, size int64) {
+	readsData := p.op.Type != OperationDelete
+	if readsData && isArchived(item) {
+		p.plan.Archived++
+		p.warn(WarningArchived, name, "object is in archival storage and must be restored first")
+	}
+	switch p.op.Type {
+	case OperationDownload, OperationVerify:
+		p.plan.Requests[RequestGet]++
+	case OperationCopy:
+		p.plan.Requests[RequestGet]++
+		p.addPut(name, size)
+	}
+}
+
+// addPut counts the requests that upload an object to the destination
+func (p *planner) addPut(name string, size int64) {
+	if err := p.dst.bucket.limits.validate(p.dst.path+name, p.dst.bucket.redaction); err != nil {
+		p.warn(WarningKeyLimit, name, err.Error())
+	}
+	if err := p.dst.bucket.validateSize(p.dst.path+name, size); err != nil {
+		p.warn(WarningObjectSize, name, err.Error())
+	}
+	m := p.dst.bucket.multipart
+	if m == nil || size <= p.opts.MultipartThreshold {
+		p.plan.Requests[RequestPut]++
+		if size > maxSinglePutSize {
+			p.warn(WarningObjectSize, name, fmt.Sprintf("object of %d bytes exceeds the maximum of %d bytes of a single upload", size, int64(maxSinglePutSize)))
+		}
+		return
+	}
+	ps := partSize(size, p.opts, m)
+	p.plan.Requests[RequestPut] += ceilDiv(size, ps)
+	p.plan.Requests[RequestMultipart] += 2
+	if ps > maxPartSize {
+		p.warn(WarningObjectSize, name, fmt.Sprintf("object of %d bytes needs parts of %d bytes, above the maximum of %d bytes", size, ps, int64(maxPartSize)))
+	}
+}
+
+func (p *planner) warn(kind WarningKind, name, msg string) {
+	if len(p.plan.Warnings) >= maxPlanWarnings {
+		p.plan.MoreWarnings++
+		return
+	}
+	p.plan.Warnings = append(p.plan.Warnings, PlanWarning{
+		Kind:    kind,
+		Object:  p.src.bucket.redaction.Redact(name),
+		Message: msg,
+	})
+}
+
+// estimate counts the listing and delete requests, and the duration
+func (p *planner) estimate(ctx context.Context) error {
+	b := p.src.bucket
+	p.plan.Requests[RequestList] = (p.items + int64(b.pageSize) - 1) / int64(b.pageSize)
+	if p.plan.Requests[RequestList] == 0 {
+		p.plan.Requests[RequestList] = 1
+	}
+	if p.op.Type == OperationDelete {
+		// Directory markers are deleted too
+		if b.batchDeleter != nil {
+			bs := int64(b.batchSize())
+			p.plan.Requests[RequestDelete] = (p.items + bs - 1) / bs
+		} else {
+			p.plan.Requests[RequestDelete] = p.items
+		}
+	}
+
+	p.plan.Throughput = p.op.Throughput
+	if p.plan.Throughput <= 0 {
+		p.plan.Throughput = defaultPlanThroughput
+	}
+	if p.op.Probe && p.op.Type != OperationDelete && p.largestN > 0 {
+		t, err := p.probe(ctx)
+		if err != nil {
+			return errors.Wrap(err, "failed to measure the throughput")
+		}
+		if t > 0 {
+			p.plan.Throughput, p.plan.Measured = t, true
+		}
+	}
+
+	latency := p.op.RequestLatency
+	if latency <= 0 {
+		latency = defaultPlanLatency
+	}
+	concurrency := p.op.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultRewriteConcurrency
+		if p.op.Type == OperationDelete {
+			concurrency = b.deleteWorkers
+		}
+	}
+	var requests int64
+	for _, n := range p.plan.Requests {
+		requests += n
+	}
+	p.plan.Duration = time.Duration(requests) * latency / time.Duration(concurrency)
+	if p.op.Type != OperationDelete {
+		p.plan.Duration += time.Duration(float64(p.plan.Bytes) / float64(p.plan.Throughput) * float64(time.Second))
+	}
+	return nil
+}
+
+// probe reads the start of the largest object and returns the measured
+// throughput in bytes per second, or 0 if too little time passed to tell
+func (p *planner) probe(ctx context.Context) (int64, error) {
+	clk := clockFor(ctx, p.src.bucket)
+	start := clk.Now()
+	r, _, err := p.src.Get(ctx, p.largest)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	n, err := io.Copy(ioutil.Discard, io.LimitReader(r, planProbeBytes))
+	if err != nil {
+		return 0, err
+	}
+	elapsed := clk.Now().Sub(start)
+	if elapsed <= 0 {
+		return 0, nil
+	}
+	return int64(float64(n) / elapsed.Seconds()), nil
+}
@@ -0,0 +1,186 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/graymeta/stow"
+	. "gopkg.in/check.v1"
+)
+
+type PlanSuite struct{}
+
+var _ = Suite(&PlanSuite{})
+
+func (s *PlanSuite) TestPlanDelete(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("plan")
+	b := newBucket(ProviderConfig{PageSize: 10}, mc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < 24; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%02d", i), []byte("data"), nil), IsNil)
+	}
+
+	plan, err := PlanOperation(ctx, OperationSpec{Type: OperationDelete, Source: d})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Objects, Equals, int64(24))
+	c.Assert(plan.Bytes, Equals, int64(96))
+	c.Assert(plan.Sampled, Equals, false)
+	// The directory marker is deleted too
+	c.Assert(plan.Requests, DeepEquals, map[RequestType]int64{RequestList: 3, RequestDelete: 25})
+	c.Assert(plan.Duration, Equals, 28*defaultPlanLatency/deleteConcurrency)
+
+	b.batchDeleter = &memBatchDeleter{c: mc, max: 10}
+	plan, err = PlanOperation(ctx, OperationSpec{Type: OperationDelete, Source: d, Concurrency: 1, RequestLatency: time.Second})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Requests, DeepEquals, map[RequestType]int64{RequestList: 3, RequestDelete: 3})
+	c.Assert(plan.Duration, Equals, 6*time.Second)
+	// Nothing was deleted
+	c.Assert(mc.items, HasLen, 25)
+}
+
+func (s *PlanSuite) TestPlanCopy(c *C) {
+	ctx := context.Background()
+	src, mc := newMemBucket("source")
+	sd, err := src.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for _, name := range []string{"small", "archived", "big", strings.Repeat("long", 10)} {
+		size := 10
+		if name == "big" {
+			size = 25
+		}
+		c.Assert(sd.PutBytes(ctx, name, bytes.Repeat([]byte("x"), size), nil), IsNil)
+	}
+	mc.items["dir/archived"].metadata[storageClassMetadataKey] = "GLACIER"
+
+	dc := newMemContainer("destination")
+	dst := newBucket(ProviderConfig{
+		Limits:     PathLimits{MaxKeyLength: 30},
+		PutOptions: PutOptions{MultipartThreshold: 20, PartSize: 10},
+	}, dc, nil, "mem://")
+	dst.multipart = newMemMultipart(dc)
+	dd, err := dst.CreateDirectory(ctx, "copy")
+	c.Assert(err, IsNil)
+
+	plan, err := PlanOperation(ctx, OperationSpec{
+		Type:        OperationCopy,
+		Source:      sd,
+		Destination: dd,
+		Throughput:  10,
+		Concurrency: 2,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Objects, Equals, int64(4))
+	c.Assert(plan.Bytes, Equals, int64(55))
+	c.Assert(plan.Archived, Equals, int64(1))
+	c.Assert(plan.Requests, DeepEquals, map[RequestType]int64{
+		RequestList:      1,
+		RequestGet:       4,
+		RequestPut:       6,
+		RequestMultipart: 2,
+	})
+	c.Assert(plan.Throughput, Equals, int64(10))
+	c.Assert(plan.Measured, Equals, false)
+	c.Assert(plan.Duration, Equals, 13*defaultPlanLatency/2+5500*time.Millisecond)
+	c.Assert(plan.Warnings, HasLen, 2)
+	c.Assert(plan.Warnings[0], DeepEquals, PlanWarning{
+		Kind:    WarningArchived,
+		Object:  "archived",
+		Message: "object is in archival storage and must be restored first",
+	})
+	c.Assert(plan.Warnings[1].Kind, Equals, WarningKeyLimit)
+	c.Assert(plan.Warnings[1].Object, Equals, strings.Repeat("long", 10))
+
+	// Plans serialize
+	data, err := json.Marshal(plan)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Matches, `\{"operation":"copy","objects":4,"bytes":55,.*"requests":\{"get":4,"list":1,"multipart":2,"put":6\}.*`)
+	var decoded Plan
+	c.Assert(json.Unmarshal(data, &decoded), IsNil)
+	c.Assert(decoded, DeepEquals, plan)
+}
+
+func (s *PlanSuite) TestPlanObjectSize(c *C) {
+	ctx := context.Background()
+	src, _ := newMemBucket("source")
+	c.Assert(src.PutBytes(ctx, "dir/small", bytes.Repeat([]byte("x"), 20), nil), IsNil)
+	c.Assert(src.PutBytes(ctx, "dir/large", bytes.Repeat([]byte("x"), 21), nil), IsNil)
+	sd, err := src.GetDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	dst, _ := newMemBucket("destination")
+	dst.maxObjectSize = 20
+	dd, err := dst.CreateDirectory(ctx, "copy")
+	c.Assert(err, IsNil)
+
+	plan, err := PlanOperation(ctx, OperationSpec{Type: OperationCopy, Source: sd, Destination: dd})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Warnings, DeepEquals, []PlanWarning{{
+		Kind:    WarningObjectSize,
+		Object:  "large",
+		Message: "object /copy/large of 21 bytes exceeds the maximum object size of 20 bytes",
+	}})
+}
+
+func (s *PlanSuite) TestPlanSampled(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("plan")
+	for i := 0; i < 10; i++ {
+		c.Assert(b.PutBytes(ctx, fmt.Sprintf("obj%d", i), []byte("data"), nil), IsNil)
+	}
+	plan, err := PlanOperation(ctx, OperationSpec{Type: OperationDownload, Source: b, MaxObjects: 4})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Sampled, Equals, true)
+	c.Assert(plan.Objects, Equals, int64(4))
+	c.Assert(plan.Requests[RequestGet], Equals, int64(4))
+
+	_, err = PlanOperation(ctx, OperationSpec{Type: "move", Source: b})
+	c.Assert(err, ErrorMatches, `unsupported operation "move"`)
+}
+
+// clockContainer returns items whose data takes time to read on a fake
+// clock
+type clockContainer struct {
+	*memContainer
+	clk         *fakeClock
+	bytesPerSec int64
+}
+
+func (c *clockContainer) Item(id string) (stow.Item, error) {
+	item, err := c.memContainer.Item(id)
+	if err != nil {
+		return nil, err
+	}
+	return &clockItem{memItem: item.(*memItem), c: c}, nil
+}
+
+type clockItem struct {
+	*memItem
+	c *clockContainer
+}
+
+func (i *clockItem) Open() (io.ReadCloser, error) {
+	i.c.clk.Advance(time.Duration(int64(len(i.data)) * int64(time.Second) / i.c.bytesPerSec))
+	return ioutil.NopCloser(bytes.NewReader(i.data)), nil
+}
+
+func (s *PlanSuite) TestPlanProbe(c *C) {
+	ctx := context.Background()
+	cc := &clockContainer{memContainer: newMemContainer("probe"), clk: newFakeClock(), bytesPerSec: 1000}
+	b := newBucket(ProviderConfig{}, cc, nil, "mem://")
+	b.clock = cc.clk
+	c.Assert(b.PutBytes(ctx, "small", make([]byte, 10), nil), IsNil)
+	c.Assert(b.PutBytes(ctx, "large", make([]byte, 4000), nil), IsNil)
+
+	plan, err := PlanOperation(ctx, OperationSpec{Type: OperationVerify, Source: b, Probe: true})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Measured, Equals, true)
+	c.Assert(plan.Throughput, Equals, int64(1000))
+	c.Assert(plan.Duration, Equals, 3*defaultPlanLatency/defaultRewriteConcurrency+4010*time.Millisecond)
+}
@@ -0,0 +1,83 @@
+package objectstore
+
+// Listings of the sub directories of directories
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// prefixLister lists the common prefixes of keys with a '/' delimiter,
+// which stow does not expose. Keys are names in the container.
+type prefixLister interface {
+	// ListPrefixes returns the prefixes, up to and including the next '/',
+	// of the keys that have prefix and a '/' after it, and the keys that
+	// have prefix and no '/' after it
+	ListPrefixes(ctx context.Context, prefix string) (prefixes []string, keys []string, err error)
+}
+
+// ListPrefixes returns the sorted names, relative to d.path and without a
+// trailing '/', of the directories in d. Directories are listed with a
+// delimiter if the provider supports it, and otherwise by walking all
+// objects in d.
+func (d *directory) ListPrefixes(ctx context.Context) ([]string, error) {
+	if d.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+	return d.cachedPrefixes(ctx, func() ([]string, error) {
+		return d.listPrefixes(ctx)
+	})
+}
+
+func (d *directory) listPrefixes(ctx context.Context) ([]string, error) {
+	prefix := cloudName(d.path)
+	dirs := make(map[string]bool)
+	// Directories are the first element of the names that have a '/',
+	// whether they are markers or objects, and the names of EMR markers
+	// e.g., /dir1/, /dir1/file1, /dir1/dir2/, /dir1/dir2/file2 will leave /dir1
+	add := func(name string) {
+		switch i := strings.Index(name, "/"); {
+		case i > 0:
+			dirs[name[:i]] = true
+		case i < 0 && strings.HasSuffix(name, emrFolderSuffix):
+			dirs[strings.TrimSuffix(name, emrFolderSuffix)] = true
+		}
+	}
+	if pl := d.bucket.prefixLister; pl != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		prefixes, keys, err := pl.ListPrefixes(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range append(prefixes, keys...) {
+			add(strings.TrimPrefix(key, prefix))
+		}
+	} else {
+		err := stow.Walk(d.bucket.container, prefix, d.bucket.pageSize,
+			func(item stow.Item, err error) error {
+				if err != nil {
+					return err
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				add(strings.TrimPrefix(item.Name(), prefix))
+				return nil
+			})
+		if err != nil {
+			return nil, err
+		}
+	}
+	names := make([]string, 0, len(dirs))
+	for name := range dirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
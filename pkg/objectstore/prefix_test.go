@@ -0,0 +1,109 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type PrefixSuite struct{}
+
+var _ = Suite(&PrefixSuite{})
+
+var _ prefixLister = (*memPrefixLister)(nil)
+
+// memPrefixLister lists the common prefixes of the keys of a memContainer
+type memPrefixLister struct {
+	*memContainer
+	calls int
+}
+
+func (l *memPrefixLister) ListPrefixes(ctx context.Context, prefix string) ([]string, []string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.calls++
+	seen := make(map[string]bool)
+	var prefixes, keys []string
+	for name := range l.items {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		i := strings.Index(name[len(prefix):], "/")
+		if i < 0 {
+			keys = append(keys, name)
+			continue
+		}
+		p := name[:len(prefix)+i+1]
+		if !seen[p] {
+			seen[p] = true
+			prefixes = append(prefixes, p)
+		}
+	}
+	return prefixes, keys, nil
+}
+
+// listedDirectories returns the names of the directories in the directory
+// dir of b
+func listedDirectories(c *C, b *bucket, dir string) []string {
+	ctx := context.Background()
+	d, err := b.GetDirectory(ctx, dir)
+	c.Assert(err, IsNil)
+	dirs, err := d.ListDirectories(ctx)
+	c.Assert(err, IsNil)
+	names, err := d.ListPrefixes(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(names, HasLen, len(dirs))
+	for _, name := range names {
+		c.Assert(dirs[name], NotNil)
+		c.Assert(dirs[name].(*directory).path, Equals, path.Join("/", dir, name)+"/")
+	}
+	return names
+}
+
+func (s *PrefixSuite) TestListPrefixes(c *C) {
+	listings, err := filepath.Glob("testdata/cli/*.txt")
+	c.Assert(err, IsNil)
+	for _, l := range listings {
+		comment := Commentf(l)
+		walked, mc := newMemBucket("prefix")
+		replayListing(c, mc, l)
+		// Directories are listed with a delimiter instead of a walk
+		pc := &pageSizeContainer{memContainer: mc, counts: make(map[int]int)}
+		delimited := newBucket(ProviderConfig{}, pc, nil, "mem://")
+		pl := &memPrefixLister{memContainer: mc}
+		delimited.prefixLister = pl
+
+		for _, dir := range append([]string{""}, cliTree.dirs...) {
+			expected := listedDirectories(c, walked, dir)
+			pc.counts = make(map[int]int)
+			c.Check(listedDirectories(c, delimited, dir), DeepEquals, expected, comment)
+			// GetDirectory lists single items, but nothing is walked
+			c.Check(pc.counts[delimited.pageSize], Equals, 0, comment)
+		}
+		c.Check(pl.calls > 0, Equals, true, comment)
+	}
+}
+
+func (s *PrefixSuite) TestDeeplyNestedPrefixes(c *C) {
+	ctx := context.Background()
+	walked, mc := newMemBucket("nested")
+	delimited := newBucket(ProviderConfig{}, mc, nil, "mem://")
+	delimited.prefixLister = &memPrefixLister{memContainer: mc}
+	// Without markers, a and a/b are only known from the key of d
+	_, err := mc.Put("a/b/c/d", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	for _, b := range []*bucket{walked, delimited} {
+		dirs, err := b.ListDirectories(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(dirs, HasLen, 1)
+		c.Assert(dirs["a"], NotNil)
+		sub, err := dirs["a"].ListDirectories(ctx)
+		c.Assert(err, IsNil)
+		c.Assert(sub, HasLen, 1)
+		c.Assert(sub["b"].(*directory).path, Equals, "/a/b/")
+	}
+}
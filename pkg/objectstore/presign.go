@@ -0,0 +1,96 @@
+package objectstore
+
+// Presigned URLs, which grant time limited access to objects without
+// credentials
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// maxPresignExpiry is the longest expiry that S3 accepts for presigned URLs,
+// which applies to all providers
+const maxPresignExpiry = 7 * 24 * time.Hour
+
+// ErrPresignUnsupported is returned by PresignGet if the provider, or the
+// credentials of the provider, cannot presign URLs
+type ErrPresignUnsupported struct{}
+
+func (e *ErrPresignUnsupported) Error() string {
+	return "presigned URLs are not supported by the object store"
+}
+
+// IsPresignUnsupported returns true if the cause of err is an
+// ErrPresignUnsupported
+func IsPresignUnsupported(err error) bool {
+	_, ok := errors.Cause(err).(*ErrPresignUnsupported)
+	return ok
+}
+
+// presigner presigns URLs of objects. Keys are names in the container.
+type presigner interface {
+	// PresignGet returns a URL that reads the object key until expiry has
+	// passed
+	PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error)
+	// PresignPut returns a URL that writes the object key until expiry has
+	// passed
+	PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error)
+}
+
+// PresignGet returns a URL that reads the object <bucket>/<d.path>/name
+// without credentials until expiry has passed. The object does not need to
+// exist yet. It fails with an ErrPresignUnsupported if the provider cannot
+// presign URLs.
+func (d *directory) PresignGet(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	if d.path == "" {
+		return "", errors.New("invalid entry")
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return "", err
+	}
+	return d.presign(ctx, objName, expiry, http.MethodGet)
+}
+
+// PresignPut returns a URL that writes the object <bucket>/<d.path>/name
+// without credentials until expiry has passed, with a PUT request of the
+// data. Requests must not set a Content-Type, and on Azure must set the
+// x-ms-blob-type header to BlockBlob. It fails with an
+// ErrPresignUnsupported if the provider cannot presign URLs.
+func (d *directory) PresignPut(ctx context.Context, name string, expiry time.Duration) (string, error) {
+	if d.path == "" {
+		return "", errors.New("invalid entry")
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return "", err
+	}
+	if err := d.bucket.limits.validate(objName, d.bucket.redaction); err != nil {
+		return "", err
+	}
+	return d.presign(ctx, objName, expiry, http.MethodPut)
+}
+
+// presign presigns the URL of the object at the absolute path objName for
+// the method
+func (d *directory) presign(ctx context.Context, objName string, expiry time.Duration, method string) (string, error) {
+	if expiry <= 0 || expiry > maxPresignExpiry {
+		return "", errors.Errorf("invalid presign expiry %s, must be positive and at most %s", expiry, maxPresignExpiry)
+	}
+	p := d.bucket.presigner
+	if p == nil {
+		return "", &ErrPresignUnsupported{}
+	}
+	sign := p.PresignGet
+	if method == http.MethodPut {
+		sign = p.PresignPut
+	}
+	u, err := sign(ctx, cloudName(objName), expiry)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to presign object %s", d.bucket.redaction.Redact(objName))
+	}
+	return u, nil
+}
@@ -0,0 +1,219 @@
+package objectstore
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type PresignSuite struct{}
+
+var _ = Suite(&PresignSuite{})
+
+func (s *PresignSuite) TestUnsupported(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("presign")
+	_, err := b.PresignGet(ctx, "obj", time.Minute)
+	c.Assert(IsPresignUnsupported(err), Equals, true)
+	_, err = b.PresignGet(ctx, "obj", 8*24*time.Hour)
+	c.Assert(err, ErrorMatches, "invalid presign expiry 192h0m0s, .*")
+	_, err = b.PresignGet(ctx, "obj", 0)
+	c.Assert(err, ErrorMatches, "invalid presign expiry 0s, .*")
+	_, err = b.PresignPut(ctx, "obj", time.Minute)
+	c.Assert(IsPresignUnsupported(err), Equals, true)
+}
+
+func (s *PresignSuite) TestS3PresignGet(c *C) {
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/bucket/dir/obj" || r.URL.Query().Get("X-Amz-Signature") == "" {
+			http.Error(w, "unsupported", http.StatusNotImplemented)
+			return
+		}
+		fmt.Fprint(w, "data")
+	}))
+	defer srv.Close()
+	client, err := newS3Client(ProviderConfig{Endpoint: srv.URL}, &Secret{
+		Type: SecretTypeAwsAccessKey,
+		Aws:  &SecretAws{AccessKeyID: "id", SecretAccessKey: "secret"},
+	}, "")
+	c.Assert(err, IsNil)
+	b, _ := newMemBucket("bucket")
+	b.presigner = newS3Presigner(client, "bucket")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+
+	u, err := d.PresignGet(ctx, "obj", time.Hour)
+	c.Assert(err, IsNil)
+	pu, err := url.Parse(u)
+	c.Assert(err, IsNil)
+	c.Assert(pu.Query().Get("X-Amz-Expires"), Equals, "3600")
+	c.Assert(pu.Query().Get("X-Amz-Credential"), Matches, "id/.*")
+	resp, err := http.Get(u)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+}
+
+func (s *PresignSuite) TestS3PresignPut(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("bucket")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || !strings.HasPrefix(r.URL.Path, "/bucket/") || r.URL.Query().Get("X-Amz-Signature") == "" {
+			http.Error(w, "unsupported", http.StatusNotImplemented)
+			return
+		}
+		if _, err := mc.Put(strings.TrimPrefix(r.URL.Path, "/bucket/"), r.Body, r.ContentLength, nil); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+	client, err := newS3Client(ProviderConfig{Endpoint: srv.URL}, &Secret{
+		Type: SecretTypeAwsAccessKey,
+		Aws:  &SecretAws{AccessKeyID: "id", SecretAccessKey: "secret"},
+	}, "")
+	c.Assert(err, IsNil)
+	b.presigner = newS3Presigner(client, "bucket")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+
+	// The URL writes the object that Get reads
+	u, err := d.PresignPut(ctx, "new obj", time.Hour)
+	c.Assert(err, IsNil)
+	req, err := http.NewRequest(http.MethodPut, u, strings.NewReader("uploaded"))
+	c.Assert(err, IsNil)
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, Equals, http.StatusOK)
+	data, _, err := d.GetBytes(ctx, "new obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "uploaded")
+
+	_, err = d.PresignPut(ctx, strings.Repeat("a", 2000), time.Hour)
+	c.Assert(err, ErrorMatches, ".* exceeds the maximum key length .*")
+}
+
+func (s *PresignSuite) TestGCSPresignGet(c *C) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, IsNil)
+	p := &gcsPresigner{endpoint: gcsSignedURLEndpoint, bucket: "bucket", email: "sa@project.iam.gserviceaccount.com", key: key}
+	clk := newFakeClock()
+	ctx := withClock(context.Background(), clk)
+
+	u, err := p.PresignGet(ctx, "dir/a b", time.Hour)
+	c.Assert(err, IsNil)
+	pu, err := url.Parse(u)
+	c.Assert(err, IsNil)
+	c.Assert(pu.Scheme+"://"+pu.Host, Equals, gcsSignedURLEndpoint)
+	c.Assert(pu.EscapedPath(), Equals, "/bucket/dir/a%20b")
+	q := pu.Query()
+	c.Assert(q.Get("GoogleAccessId"), Equals, "sa@project.iam.gserviceaccount.com")
+	expires := clk.Now().Add(time.Hour).Unix()
+	c.Assert(q.Get("Expires"), Equals, fmt.Sprintf("%d", expires))
+
+	sig, err := base64.StdEncoding.DecodeString(q.Get("Signature"))
+	c.Assert(err, IsNil)
+	h := sha256.Sum256([]byte(fmt.Sprintf("GET\n\n\n%d\n/bucket/dir/a%%20b", expires)))
+	c.Assert(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, h[:], sig), IsNil)
+
+	// Uploads sign the method
+	u, err = p.PresignPut(ctx, "dir/a b", time.Hour)
+	c.Assert(err, IsNil)
+	pu, err = url.Parse(u)
+	c.Assert(err, IsNil)
+	sig, err = base64.StdEncoding.DecodeString(pu.Query().Get("Signature"))
+	c.Assert(err, IsNil)
+	h = sha256.Sum256([]byte(fmt.Sprintf("PUT\n\n\n%d\n/bucket/dir/a%%20b", expires)))
+	c.Assert(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, h[:], sig), IsNil)
+}
+
+func (s *PresignSuite) TestGCSPresignerCredentials(c *C) {
+	ctx := context.Background()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	c.Assert(err, IsNil)
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	serviceKey, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "sa@project.iam.gserviceaccount.com",
+		"private_key":  string(pemKey),
+	})
+	c.Assert(err, IsNil)
+	p, err := newGCSPresigner(ctx, &Secret{
+		Type: SecretTypeGcpServiceAccountKey,
+		Gcp:  &SecretGcp{ServiceKey: string(serviceKey)},
+	}, "bucket")
+	c.Assert(err, IsNil)
+	gp, ok := p.(*gcsPresigner)
+	c.Assert(ok, Equals, true)
+	c.Assert(gp.email, Equals, "sa@project.iam.gserviceaccount.com")
+	c.Assert(gp.key.N, DeepEquals, key.N)
+
+	// User credentials have no key to sign with
+	p, err = newGCSPresigner(ctx, &Secret{
+		Type: SecretTypeGcpServiceAccountKey,
+		Gcp:  &SecretGcp{ServiceKey: `{"type": "authorized_user", "client_id": "id", "client_secret": "secret", "refresh_token": "token"}`},
+	}, "bucket")
+	c.Assert(err, IsNil)
+	c.Assert(p, IsNil)
+	_, err = newGCSPresigner(ctx, &Secret{Type: SecretTypeAwsAccessKey}, "bucket")
+	c.Assert(err, ErrorMatches, "invalid secret type .*")
+}
+
+func (s *PresignSuite) TestAzurePresign(c *C) {
+	key := []byte("account key")
+	p, err := newAzurePresigner(&Secret{
+		Type:  SecretTypeAzStorageAccount,
+		Azure: &SecretAzure{StorageAccount: "account", StorageKey: base64.StdEncoding.EncodeToString(key)},
+	}, "container")
+	c.Assert(err, IsNil)
+	clk := newFakeClock()
+	ctx := withClock(context.Background(), clk)
+
+	for permissions, presign := range map[string]func(context.Context, string, time.Duration) (string, error){
+		"r":  p.PresignGet,
+		"cw": p.PresignPut,
+	} {
+		u, err := presign(ctx, "dir/a b", time.Hour)
+		c.Assert(err, IsNil)
+		pu, err := url.Parse(u)
+		c.Assert(err, IsNil)
+		c.Assert(pu.Scheme+"://"+pu.Host, Equals, "https://account.blob.core.windows.net")
+		c.Assert(pu.EscapedPath(), Equals, "/container/dir/a%20b")
+		q := pu.Query()
+		expires := clk.Now().Add(time.Hour).UTC().Format("2006-01-02T15:04:05Z")
+		c.Assert(q.Get("sp"), Equals, permissions)
+		c.Assert(q.Get("se"), Equals, expires)
+		c.Assert(q.Get("sr"), Equals, "b")
+
+		mac := hmac.New(sha256.New, key)
+		fmt.Fprintf(mac, "%s\n\n%s\n/blob/account/container/dir/a b\n\n\nhttps\n2015-04-05\n\n\n\n\n", permissions, expires)
+		c.Assert(q.Get("sig"), Equals, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+
+	_, err = newAzurePresigner(&Secret{
+		Type:  SecretTypeAzStorageAccount,
+		Azure: &SecretAzure{StorageAccount: "account", StorageKey: "not base64"},
+	}, "container")
+	c.Assert(err, ErrorMatches, "failed to decode Azure storage key: .*")
+	_, err = newAzurePresigner(&Secret{Type: SecretTypeAwsAccessKey}, "container")
+	c.Assert(err, ErrorMatches, "invalid secret type .*")
+}
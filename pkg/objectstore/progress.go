@@ -0,0 +1,118 @@
+package objectstore
+
+// Byte level progress reporting for object transfers
+
+import (
+	"context"
+	"io"
+)
+
+// ProgressReporter receives progress updates for object transfers.
+// transferred is the cumulative number of bytes transferred so far and
+// total is the size of the object, or -1 if it is not known.
+type ProgressReporter interface {
+	Progress(name string, transferred, total int64)
+}
+
+type progressReporterKey struct{}
+
+// WithProgressReporter returns a context that makes Directory.Put and
+// Directory.Get report their progress to pr
+func WithProgressReporter(ctx context.Context, pr ProgressReporter) context.Context {
+	return context.WithValue(ctx, progressReporterKey{}, pr)
+}
+
+func progressReporterFromContext(ctx context.Context) ProgressReporter {
+	pr, _ := ctx.Value(progressReporterKey{}).(ProgressReporter)
+	return pr
+}
+
+type progressReader struct {
+	r        io.Reader
+	total    int64
+	read     int64
+	callback func(int64)
+}
+
+// NewProgressReader wraps r and calls callback after each Read with the
+// cumulative number of bytes read. If totalBytes is not negative, reaching
+// the end of r before totalBytes have been read is reported as
+// io.ErrUnexpectedEOF.
+func NewProgressReader(r io.Reader, totalBytes int64, callback func(bytesRead int64)) io.Reader {
+	return &progressReader{r: r, total: totalBytes, callback: callback}
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.read += int64(n)
+	p.callback(p.read)
+	if err == io.EOF && p.total >= 0 && p.read < p.total {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+type progressWriter struct {
+	w        io.Writer
+	written  int64
+	callback func(int64)
+}
+
+// NewProgressWriter wraps w and calls callback after each Write with the
+// cumulative number of bytes written. totalBytes is the expected number of
+// bytes; writes beyond it are rejected with io.ErrShortWrite unless it is
+// negative.
+func NewProgressWriter(w io.Writer, totalBytes int64, callback func(bytesWritten int64)) io.Writer {
+	if totalBytes >= 0 {
+		w = &limitedWriter{w: w, remaining: totalBytes}
+	}
+	return &progressWriter{w: w, callback: callback}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.written += int64(n)
+	p.callback(p.written)
+	return n, err
+}
+
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+}
+
+func (l *limitedWriter) Write(b []byte) (int, error) {
+	if int64(len(b)) > l.remaining {
+		n, err := l.w.Write(b[:l.remaining])
+		l.remaining -= int64(n)
+		if err == nil {
+			err = io.ErrShortWrite
+		}
+		return n, err
+	}
+	n, err := l.w.Write(b)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+type progressReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// reportProgress wraps r to report progress to the ProgressReporter in ctx,
+// if there is one. Empty objects are reported as complete right away, since
+// uploads of empty objects need not read r.
+func reportProgress(ctx context.Context, name string, r io.Reader, size int64) io.Reader {
+	pr := progressReporterFromContext(ctx)
+	if pr == nil {
+		return r
+	}
+	if size == 0 {
+		pr.Progress(name, 0, 0)
+		return r
+	}
+	return NewProgressReader(r, size, func(n int64) {
+		pr.Progress(name, n, size)
+	})
+}
@@ -0,0 +1,143 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"sync"
+	"testing/iotest"
+
+	"github.com/graymeta/stow"
+	. "gopkg.in/check.v1"
+)
+
+type ProgressSuite struct{}
+
+var _ = Suite(&ProgressSuite{})
+
+type recordingReporter struct {
+	mu      sync.Mutex
+	updates map[string][]int64
+	totals  map[string]int64
+}
+
+func newRecordingReporter() *recordingReporter {
+	return &recordingReporter{
+		updates: make(map[string][]int64),
+		totals:  make(map[string]int64),
+	}
+}
+
+func (r *recordingReporter) Progress(name string, transferred, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.updates[name] = append(r.updates[name], transferred)
+	r.totals[name] = total
+}
+
+func (r *recordingReporter) last(name string) int64 {
+	u := r.updates[name]
+	if len(u) == 0 {
+		return -1
+	}
+	return u[len(u)-1]
+}
+
+func (s *ProgressSuite) TestProgressReader(c *C) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	var calls int
+	var last int64
+	r := NewProgressReader(bytes.NewReader(data), int64(len(data)), func(n int64) {
+		c.Assert(n >= last, Equals, true)
+		calls++
+		last = n
+	})
+	out, err := ioutil.ReadAll(iotest.HalfReader(r))
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, data)
+	c.Assert(calls > 1, Equals, true)
+	c.Assert(last, Equals, int64(len(data)))
+
+	// Truncated source
+	r = NewProgressReader(bytes.NewReader(data), int64(len(data)+1), func(int64) {})
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, Equals, io.ErrUnexpectedEOF)
+
+	// Unknown size
+	r = NewProgressReader(bytes.NewReader(data), -1, func(n int64) { last = n })
+	_, err = ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(last, Equals, int64(len(data)))
+}
+
+func (s *ProgressSuite) TestProgressWriter(c *C) {
+	data := bytes.Repeat([]byte("0123456789"), 100)
+	var buf bytes.Buffer
+	var last int64
+	w := NewProgressWriter(&buf, int64(len(data)), func(n int64) { last = n })
+	n, err := io.Copy(w, iotest.HalfReader(bytes.NewReader(data)))
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(len(data)))
+	c.Assert(last, Equals, int64(len(data)))
+	c.Assert(buf.Bytes(), DeepEquals, data)
+
+	buf.Reset()
+	w = NewProgressWriter(&buf, 5, func(n int64) { last = n })
+	_, err = w.Write(data)
+	c.Assert(err, Equals, io.ErrShortWrite)
+	c.Assert(last, Equals, int64(5))
+}
+
+func (s *ProgressSuite) TestDirectoryProgress(c *C) {
+	b, _ := newMemBucket("progress")
+	pr := newRecordingReporter()
+	ctx := WithProgressReporter(context.Background(), pr)
+	data := bytes.Repeat([]byte("x"), 4096)
+
+	err := b.PutBytes(ctx, "obj", data, nil)
+	c.Assert(err, IsNil)
+	c.Assert(pr.last("/obj"), Equals, int64(len(data)))
+	c.Assert(pr.totals["/obj"], Equals, int64(len(data)))
+
+	pr = newRecordingReporter()
+	ctx = WithProgressReporter(context.Background(), pr)
+	out, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, data)
+	c.Assert(pr.last("/obj"), Equals, int64(len(data)))
+	c.Assert(pr.totals["/obj"], Equals, int64(len(data)))
+
+	// No reporter in the context
+	_, _, err = b.GetBytes(context.Background(), "obj")
+	c.Assert(err, IsNil)
+}
+
+// lazyContainer does not read the data of empty objects, like some provider
+// SDKs
+type lazyContainer struct {
+	*memContainer
+}
+
+func (c *lazyContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	if size == 0 {
+		r = bytes.NewReader(nil)
+	}
+	return c.memContainer.Put(name, r, size, metadata)
+}
+
+func (s *ProgressSuite) TestEmptyProgress(c *C) {
+	b := newBucket(ProviderConfig{}, &lazyContainer{memContainer: newMemContainer("progress")}, nil, "mem://")
+	pr := newRecordingReporter()
+	ctx := WithProgressReporter(context.Background(), pr)
+	c.Assert(b.PutBytes(ctx, "empty", nil, nil), IsNil)
+	c.Assert(pr.updates["/empty"], DeepEquals, []int64{0})
+	c.Assert(pr.totals["/empty"], Equals, int64(0))
+
+	pr = newRecordingReporter()
+	ctx = WithProgressReporter(context.Background(), pr)
+	out, _, err := b.GetBytes(ctx, "empty")
+	c.Assert(err, IsNil)
+	c.Assert(out, HasLen, 0)
+	c.Assert(pr.updates["/empty"], DeepEquals, []int64{0})
+}
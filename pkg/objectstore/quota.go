@@ -0,0 +1,222 @@
+package objectstore
+
+// Soft quotas on the storage used by a bucket
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const defaultQuotaUsageTTL = 5 * time.Minute
+
+var defaultQuotaThresholds = []float64{0.8, 0.95, 1}
+
+// QuotaConfig is a storage budget for a bucket. Crossing a threshold of the
+// budget is logged and reported to the QuotaReporter of the context of the
+// upload. Uploads only fail in strict mode.
+type QuotaConfig struct {
+	// CapacityBytes is the budget of the bucket. Quotas are disabled if it
+	// is 0.
+	CapacityBytes int64
+	// Thresholds are the fractions of CapacityBytes at which usage is
+	// reported. Defaults to 0.8, 0.95 and 1.
+	Thresholds []float64
+	// Strict fails uploads with an ErrSoftQuotaExceeded once the usage is
+	// over CapacityBytes
+	Strict bool
+	// UsageTTL is how long a measured usage is trusted before the bucket is
+	// walked again. Uploads made in between are added to it. Defaults to 5
+	// minutes.
+	UsageTTL time.Duration
+}
+
+// QuotaEvent reports that the usage of a bucket crossed a threshold of its
+// capacity
+type QuotaEvent struct {
+	// Bucket is the URL of the bucket
+	Bucket string
+	// Usage is the number of bytes used
+	Usage int64
+	// Capacity is the budget of the bucket in bytes
+	Capacity int64
+	// Threshold is the fraction of Capacity that was crossed
+	Threshold float64
+}
+
+// QuotaReporter receives the QuotaEvents of uploads
+type QuotaReporter interface {
+	QuotaThreshold(e QuotaEvent)
+}
+
+type quotaReporterKey struct{}
+
+// WithQuotaReporter returns a context that makes uploads report crossed
+// quota thresholds to qr
+func WithQuotaReporter(ctx context.Context, qr QuotaReporter) context.Context {
+	return context.WithValue(ctx, quotaReporterKey{}, qr)
+}
+
+func quotaReporterFromContext(ctx context.Context) QuotaReporter {
+	qr, _ := ctx.Value(quotaReporterKey{}).(QuotaReporter)
+	return qr
+}
+
+// ErrSoftQuotaExceeded is returned by uploads to a bucket that is over its
+// capacity in strict mode
+type ErrSoftQuotaExceeded struct {
+	Usage    int64
+	Capacity int64
+}
+
+func (e *ErrSoftQuotaExceeded) Error() string {
+	return fmt.Sprintf("bucket uses %d bytes, over its quota of %d bytes", e.Usage, e.Capacity)
+}
+
+// IsSoftQuotaExceeded returns true if the cause of err is an
+// ErrSoftQuotaExceeded
+func IsSoftQuotaExceeded(err error) bool {
+	_, ok := errors.Cause(err).(*ErrSoftQuotaExceeded)
+	return ok
+}
+
+// quota tracks the usage of a bucket against its QuotaConfig
+type quota struct {
+	config     QuotaConfig
+	mu         sync.Mutex
+	usage      int64
+	measuredAt time.Time
+	valid      bool
+	// reported is the highest threshold reported since usage was last
+	// below it
+	reported float64
+}
+
+// newQuota returns nil if config does not set a capacity
+func newQuota(config QuotaConfig) *quota {
+	if config.CapacityBytes <= 0 {
+		return nil
+	}
+	if len(config.Thresholds) == 0 {
+		config.Thresholds = defaultQuotaThresholds
+	}
+	config.Thresholds = append([]float64(nil), config.Thresholds...)
+	sort.Float64s(config.Thresholds)
+	if config.UsageTTL <= 0 {
+		config.UsageTTL = defaultQuotaUsageTTL
+	}
+	return &quota{config: config}
+}
+
+// checkQuota returns an ErrSoftQuotaExceeded if b is over its capacity in
+// strict mode, unless the upload of size bytes is empty, like directory
+// markers. Usage is measured if the last measure expired.
+func checkQuota(ctx context.Context, b *bucket, size int64) error {
+	q := b.quota
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err := q.measure(ctx, b); err != nil {
+		return err
+	}
+	if q.config.Strict && size != 0 && q.usage > q.config.CapacityBytes {
+		return &ErrSoftQuotaExceeded{Usage: q.usage, Capacity: q.config.CapacityBytes}
+	}
+	return nil
+}
+
+// addUsage accounts for an upload of size bytes, or of an unknown size if
+// size is negative
+func addUsage(ctx context.Context, b *bucket, size int64) {
+	q := b.quota
+	if q == nil {
+		return
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if size < 0 {
+		// Measure again before the next upload
+		q.valid = false
+		return
+	}
+	if q.valid {
+		q.usage += size
+		q.report(ctx, b)
+	}
+}
+
+// measure walks b to sum the sizes of its objects, unless the last measure
+// is recent enough. q.mu must be held.
+func (q *quota) measure(ctx context.Context, b *bucket) error {
+	now := clockFor(ctx, b).Now()
+	if q.valid && now.Sub(q.measuredAt) < q.config.UsageTTL {
+		return nil
+	}
+	var usage int64
+	err := stow.Walk(b.container, "", b.pageSize, func(item stow.Item, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size, err := item.Size()
+		if err != nil {
+			return err
+		}
+		usage += size
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to measure the usage of the bucket")
+	}
+	q.usage, q.measuredAt, q.valid = usage, now, true
+	q.report(ctx, b)
+	return nil
+}
+
+// report logs and reports the thresholds crossed since the last report.
+// q.mu must be held.
+func (q *quota) report(ctx context.Context, b *bucket) {
+	var crossed []float64
+	for _, t := range q.config.Thresholds {
+		if float64(q.usage) >= t*float64(q.config.CapacityBytes) {
+			crossed = append(crossed, t)
+		}
+	}
+	// Thresholds are reported again once usage drops below them
+	highest := 0.0
+	if len(crossed) > 0 {
+		highest = crossed[len(crossed)-1]
+	}
+	qr := quotaReporterFromContext(ctx)
+	for _, t := range crossed {
+		if t <= q.reported {
+			continue
+		}
+		e := QuotaEvent{
+			Bucket:    b.hostEndPoint,
+			Usage:     q.usage,
+			Capacity:  q.config.CapacityBytes,
+			Threshold: t,
+		}
+		log.WithFields(log.Fields{
+			"bucket":    e.Bucket,
+			"usage":     e.Usage,
+			"capacity":  e.Capacity,
+			"threshold": e.Threshold,
+		}).Warnf("Bucket uses %.0f%% of its quota", 100*float64(e.Usage)/float64(e.Capacity))
+		if qr != nil {
+			qr.QuotaThreshold(e)
+		}
+	}
+	q.reported = highest
+}
@@ -0,0 +1,90 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type QuotaSuite struct{}
+
+var _ = Suite(&QuotaSuite{})
+
+type quotaEvents []QuotaEvent
+
+func (q *quotaEvents) QuotaThreshold(e QuotaEvent) {
+	*q = append(*q, e)
+}
+
+func (q *quotaEvents) thresholds() []float64 {
+	var ts []float64
+	for _, e := range *q {
+		ts = append(ts, e.Threshold)
+	}
+	*q = nil
+	return ts
+}
+
+func (s *QuotaSuite) TestQuota(c *C) {
+	mc := newMemContainer("quota")
+	b := newBucket(ProviderConfig{Quota: QuotaConfig{CapacityBytes: 100, UsageTTL: time.Minute}}, mc, nil, "mem://")
+	clk := newFakeClock()
+	b.clock = clk
+	events := &quotaEvents{}
+	ctx := WithQuotaReporter(context.Background(), events)
+	put := func(name string, size int) error {
+		return b.PutBytes(ctx, name, bytes.Repeat([]byte("x"), size), nil)
+	}
+
+	c.Assert(put("a", 70), IsNil)
+	c.Assert(events.thresholds(), HasLen, 0)
+	c.Assert(put("b", 15), IsNil)
+	c.Assert(events.thresholds(), DeepEquals, []float64{0.8})
+	c.Assert(put("c", 30), IsNil)
+	c.Assert(*events, DeepEquals, quotaEvents{
+		{Bucket: "mem:/quota", Usage: 115, Capacity: 100, Threshold: 0.95},
+		{Bucket: "mem:/quota", Usage: 115, Capacity: 100, Threshold: 1},
+	})
+	events.thresholds()
+
+	// Uploads are only reported once over the quota
+	c.Assert(put("d", 1), IsNil)
+	c.Assert(events.thresholds(), HasLen, 0)
+
+	// Changes made elsewhere are seen once the usage expires
+	c.Assert(mc.RemoveItem("c"), IsNil)
+	c.Assert(mc.RemoveItem("d"), IsNil)
+	c.Assert(put("e", 1), IsNil)
+	c.Assert(b.quota.usage, Equals, int64(117))
+	clk.Advance(time.Minute)
+	c.Assert(put("f", 10), IsNil)
+	c.Assert(b.quota.usage, Equals, int64(96))
+	// Thresholds are reported again after the usage dropped below them
+	c.Assert(events.thresholds(), DeepEquals, []float64{0.95})
+	c.Assert(put("g", 5), IsNil)
+	c.Assert(events.thresholds(), DeepEquals, []float64{1})
+}
+
+func (s *QuotaSuite) TestStrictQuota(c *C) {
+	ctx := context.Background()
+	mc := newMemContainer("quota")
+	b := newBucket(ProviderConfig{Quota: QuotaConfig{CapacityBytes: 10, Strict: true}}, mc, nil, "mem://")
+	c.Assert(b.PutBytes(ctx, "a", make([]byte, 10), nil), IsNil)
+	// The usage is at the quota, not over it
+	c.Assert(b.PutBytes(ctx, "b", make([]byte, 1), nil), IsNil)
+
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	err = d.PutBytes(ctx, "c", make([]byte, 1), nil)
+	c.Assert(IsSoftQuotaExceeded(err), Equals, true)
+	c.Assert(err, ErrorMatches, "bucket uses 11 bytes, over its quota of 10 bytes")
+	_, err = d.Stat(ctx, "c")
+	c.Assert(IsObjectNotFound(err), Equals, true)
+
+	// Quotas are disabled by default
+	b = newBucket(ProviderConfig{}, mc, nil, "mem://")
+	c.Assert(b.quota, IsNil)
+	c.Assert(b.PutBytes(ctx, "c", make([]byte, 1), nil), IsNil)
+}
@@ -0,0 +1,148 @@
+package objectstore
+
+// Reads of byte ranges of objects
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ErrRangeNotSatisfiable is returned by ranged reads that start past the end
+// of the object
+type ErrRangeNotSatisfiable struct {
+	Name   string
+	Offset int64
+	// Size of the object, or -1 if it is not known
+	Size int64
+}
+
+func (e *ErrRangeNotSatisfiable) Error() string {
+	return fmt.Sprintf("offset %d is past the end of object %s of %d bytes", e.Offset, e.Name, e.Size)
+}
+
+// IsRangeNotSatisfiable returns true if the cause of err is an
+// ErrRangeNotSatisfiable
+func IsRangeNotSatisfiable(err error) bool {
+	_, ok := errors.Cause(err).(*ErrRangeNotSatisfiable)
+	return ok
+}
+
+// rangeReader opens byte ranges of objects, which stow does not expose.
+// Keys are names in the container.
+type rangeReader interface {
+	// OpenRange opens length bytes of the object from offset, or the rest
+	// of the object if length is negative, and returns the size of the
+	// whole object. Length is never 0. It fails with an ErrObjectChanged if
+	// etag is set and the object no longer has it, and with an
+	// ErrRangeNotSatisfiable if offset is not before the end of the object.
+	OpenRange(ctx context.Context, key string, offset, length int64, etag string) (io.ReadCloser, int64, error)
+}
+
+// openRange opens a range of the object with the rangeReader of b and
+// returns the size of the whole object. Without a rangeReader, the object is
+// opened from the start and the data before offset is discarded. The reader
+// is empty if offset is the size of the object.
+func openRange(ctx context.Context, b *bucket, key string, offset, length int64, etag string) (io.ReadCloser, int64, error) {
+	if b.ranger != nil && length != 0 {
+		rc, size, err := b.ranger.OpenRange(ctx, key, offset, length, etag)
+		if e, ok := errors.Cause(err).(*ErrRangeNotSatisfiable); ok && e.Size < 0 {
+			// Find out whether the range is empty or past the end
+			return openRangeFallback(ctx, b, key, offset, 0, etag)
+		}
+		return rc, size, err
+	}
+	return openRangeFallback(ctx, b, key, offset, length, etag)
+}
+
+func openRangeFallback(ctx context.Context, b *bucket, key string, offset, length int64, etag string) (io.ReadCloser, int64, error) {
+	item, err := b.container.Item(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if etag != "" {
+		if current, err := item.ETag(); err == nil && current != etag {
+			return nil, 0, &ErrObjectChanged{Name: b.redaction.Redact(key)}
+		}
+	}
+	size, err := item.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case offset > size:
+		return nil, size, &ErrRangeNotSatisfiable{Name: b.redaction.Redact(key), Offset: offset, Size: size}
+	case offset == size, length == 0:
+		return ioutil.NopCloser(strings.NewReader("")), size, nil
+	}
+	rc, err := item.Open()
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := io.CopyN(ioutil.Discard, rc, offset); err != nil && err != io.EOF {
+		_ = rc.Close()
+		return nil, 0, err
+	}
+	if length < 0 {
+		return rc, size, nil
+	}
+	return progressReadCloser{Reader: io.LimitReader(rc, length), Closer: rc}, size, nil
+}
+
+// GetRange returns a reader of length bytes of the object
+// <bucket>/<d.path>/name from offset, or of the rest of the object if length
+// is negative, and the size of the whole object. It fails with an
+// ErrRangeNotSatisfiable if offset is past the end of the object, with an
+// ErrObjectEncrypted if the object is encrypted on the client, and with an
+// ErrObjectCompressed if it is compressed.
+func (d *directory) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, int64, error) {
+	if d.path == "" {
+		return nil, 0, errors.New("invalid entry")
+	}
+	if offset < 0 {
+		return nil, 0, errors.Errorf("invalid offset %d", offset)
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return nil, 0, err
+	}
+	if err := d.checkStoredAsIs(ctx, objName, "ranged read"); err != nil {
+		return nil, 0, err
+	}
+	var rc io.ReadCloser
+	var size int64
+	err = retry(ctx, d.bucket, "read of "+d.bucket.redaction.Redact(objName), func() error {
+		var err error
+		rc, size, err = openRange(ctx, d.bucket, cloudName(objName), offset, length, "")
+		return err
+	})
+	if IsObjectNotFound(err) {
+		return nil, size, &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+	}
+	if err != nil {
+		return nil, size, err
+	}
+	// Cancelling ctx interrupts the transfer
+	return newCtxReadCloser(ctx, rc), size, nil
+}
+
+// GetBytesRange returns length bytes of the named object from offset, or
+// the rest of the object if length is negative, and the size of the whole
+// object
+func (d *directory) GetBytesRange(ctx context.Context, name string, offset, length int64) ([]byte, int64, error) {
+	r, size, err := d.GetRange(ctx, name, offset, length)
+	if err != nil {
+		return nil, size, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, size, err
+	}
+	return data, size, nil
+}
@@ -0,0 +1,93 @@
+package objectstore
+
+// Redaction of object names in logs, progress events and error messages
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"unicode/utf8"
+)
+
+// RedactionMode selects how object names are redacted
+type RedactionMode string
+
+const (
+	// RedactNone leaves object names as they are
+	RedactNone RedactionMode = ""
+	// RedactTruncate keeps a short prefix of object names
+	RedactTruncate RedactionMode = "truncate"
+	// RedactHash keeps a short prefix of object names followed by a hash of
+	// the full name, so that the same name can be recognized across messages
+	RedactHash RedactionMode = "hash"
+)
+
+const (
+	defaultRedactionPrefix = 8
+	redactionHashLength    = 8
+)
+
+// NameRedaction is a policy for the object names that appear in logs,
+// progress events and error messages, for deployments where the names
+// themselves are sensitive. The fields of errors, such as ErrArchived.Keys,
+// are not redacted so that callers can still act on them.
+type NameRedaction struct {
+	// Mode is the redaction mode. Unknown modes hash names.
+	Mode RedactionMode
+	// PrefixLength is the number of leading bytes of names that are kept,
+	// and at most half of the name. Defaults to 8.
+	PrefixLength int
+}
+
+// Redact returns the redacted form of name
+func (r NameRedaction) Redact(name string) string {
+	if r.Mode == RedactNone || name == "" {
+		return name
+	}
+	n := r.PrefixLength
+	if n <= 0 {
+		n = defaultRedactionPrefix
+	}
+	if n > len(name)/2 {
+		n = len(name) / 2
+	}
+	// Do not split a multi byte character
+	for n > 0 && !utf8.RuneStart(name[n]) {
+		n--
+	}
+	prefix := name[:n]
+	if r.Mode == RedactTruncate {
+		return prefix + "..."
+	}
+	sum := sha256.Sum256([]byte(name))
+	return prefix + "...#" + hex.EncodeToString(sum[:])[:redactionHashLength]
+}
+
+// redactAll returns the redacted forms of names
+func (r NameRedaction) redactAll(names []string) []string {
+	if r.Mode == RedactNone {
+		return names
+	}
+	redacted := make([]string, 0, len(names))
+	for _, n := range names {
+		redacted = append(redacted, r.Redact(n))
+	}
+	return redacted
+}
+
+// redactionOf returns the redaction policy of the bucket of d
+func redactionOf(d Directory) NameRedaction {
+	dir, err := asDirectory(d)
+	if err != nil {
+		return NameRedaction{}
+	}
+	return dir.bucket.redaction
+}
+
+// describe returns the string form of d, with its path redacted
+func describe(d Directory) string {
+	dir, err := asDirectory(d)
+	if err != nil || dir.bucket.redaction.Mode == RedactNone {
+		return d.String()
+	}
+	return dir.bucket.hostEndPoint + dir.bucket.redaction.Redact(dir.path)
+}
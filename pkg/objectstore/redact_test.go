@@ -0,0 +1,111 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	. "gopkg.in/check.v1"
+)
+
+type RedactSuite struct{}
+
+var _ = Suite(&RedactSuite{})
+
+func (s *RedactSuite) TestRedact(c *C) {
+	const name = "customers/acme-corp/payroll.db"
+	for _, tc := range []struct {
+		redaction NameRedaction
+		name      string
+		expected  string
+	}{
+		{NameRedaction{}, name, name},
+		{NameRedaction{Mode: RedactTruncate}, name, "customer..."},
+		{NameRedaction{Mode: RedactTruncate, PrefixLength: 3}, name, "cus..."},
+		// At most half of the name is kept
+		{NameRedaction{Mode: RedactTruncate}, "acme", "ac..."},
+		{NameRedaction{Mode: RedactTruncate}, "a", "..."},
+		// Multi byte characters are not split
+		{NameRedaction{Mode: RedactTruncate, PrefixLength: 1}, "éabc", "..."},
+		{NameRedaction{Mode: RedactTruncate, PrefixLength: 2}, "éabc", "é..."},
+		{NameRedaction{Mode: RedactTruncate}, "", ""},
+	} {
+		c.Check(tc.redaction.Redact(tc.name), Equals, tc.expected, Commentf("%+v", tc))
+	}
+
+	hash := NameRedaction{Mode: RedactHash}
+	r := hash.Redact(name)
+	c.Assert(strings.HasPrefix(r, "customer...#"), Equals, true, Commentf(r))
+	c.Assert(r, HasLen, len("customer...#")+redactionHashLength)
+	// Hashes are stable, and differ for names with the same prefix
+	c.Assert(hash.Redact(name), Equals, r)
+	c.Assert(hash.Redact("customers/other"), Not(Equals), r)
+	c.Assert(NameRedaction{Mode: "unknown"}.Redact(name), Equals, r)
+}
+
+func (s *RedactSuite) TestRedactedOutput(c *C) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	b, m := newMemMultipartBucket("redact")
+	b.redaction = NameRedaction{Mode: RedactHash}
+	b.limits = PathLimits{MaxDepth: 4, SoftDepth: 2}
+	clk := newFakeClock()
+	b.clock = clk
+	pr := newRecordingReporter()
+	ctx := WithProgressReporter(context.Background(), pr)
+	const key = "customers/acme-corp/payroll.db"
+	var errs []error
+
+	// Multipart upload with a part that fails after a retry
+	m.failures[2] = 10
+	data := testData(30)
+	done := make(chan error, 1)
+	go func() {
+		opts := PutOptions{PartSize: 10, PartRetries: 1}
+		done <- b.PutOpts(ctx, key, bytes.NewReader(data), int64(len(data)), nil, WithPutOptions(opts))
+	}()
+	errs = append(errs, advanceUntilDone(clk, 0, done))
+
+	// Path limits, with a warning for the soft limit
+	errs = append(errs, b.PutBytes(ctx, key+"/too/deep", nil, nil))
+	c.Assert(b.PutBytes(ctx, key, data, nil), IsNil)
+	_, _, err := b.GetBytes(ctx, key)
+	c.Assert(err, IsNil)
+
+	// Missing directories and invalid layout markers
+	_, err = b.GetDirectory(ctx, "customers/acme-corp/missing")
+	errs = append(errs, err)
+	d, err := b.CreateDirectory(ctx, "customers/acme-corp")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, LayoutObjectName, []byte("{"), nil), IsNil)
+	_, err = ReadLayout(ctx, d)
+	errs = append(errs, err)
+
+	// Archived objects
+	const archived = "customers/acme-corp/archived.db"
+	c.Assert(b.PutBytes(ctx, archived, data, nil), IsNil)
+	m.items[archived].metadata[storageClassMetadataKey] = "GLACIER"
+	_, err = RewritePrefix(ctx, b, archiveMapping, RewriteOptions{})
+	c.Assert(IsArchivedError(err), Equals, true)
+	errs = append(errs, err)
+	// Fields of errors are not redacted
+	c.Assert(err.(*ErrArchived).Keys, DeepEquals, []string{archived})
+
+	output := []string{logs.String()}
+	for _, err := range errs {
+		c.Assert(err, NotNil)
+		output = append(output, err.Error())
+	}
+	for name := range pr.updates {
+		output = append(output, name)
+	}
+	all := strings.Join(output, "\n")
+	c.Assert(strings.Contains(all, "acme"), Equals, false, Commentf(all))
+	for _, name := range []string{key, archived, "/" + key} {
+		c.Assert(strings.Contains(all, b.redaction.Redact(name)), Equals, true, Commentf("%s in %s", name, all))
+	}
+}
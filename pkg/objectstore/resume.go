@@ -0,0 +1,141 @@
+package objectstore
+
+// Reads of objects that resume where they stopped after transient errors
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const defaultMaxResumes = 5
+
+// ErrObjectChanged is returned by reads that cannot resume because the
+// object was overwritten since the read started
+type ErrObjectChanged struct {
+	Name string
+}
+
+func (e *ErrObjectChanged) Error() string {
+	return fmt.Sprintf("object %s changed while it was read", e.Name)
+}
+
+// IsObjectChanged returns true if the cause of err is an ErrObjectChanged
+func IsObjectChanged(err error) bool {
+	_, ok := errors.Cause(err).(*ErrObjectChanged)
+	return ok
+}
+
+// maxResumes returns the number of times a read is resumed
+func (p RetryPolicy) maxResumes() int {
+	switch {
+	case p.MaxResumes < 0:
+		return 0
+	case p.MaxResumes == 0:
+		return defaultMaxResumes
+	}
+	return p.MaxResumes
+}
+
+// resumingReader reads an object and reopens it at the offset reached when
+// a read fails with a transient error. The ETag of the object must not
+// change between reopens, so that data of different versions is never
+// mixed.
+type resumingReader struct {
+	ctx     context.Context
+	b       *bucket
+	key     string
+	etag    string
+	size    int64
+	offset  int64
+	resumes int
+	// pending is an error to handle on the next Read
+	pending error
+
+	mu     sync.Mutex
+	rc     io.ReadCloser
+	closed bool
+}
+
+// newResumingReader wraps rc, the reader of the object key, which has the
+// given size and ETag. The size is -1 if it is not known. Reads of objects
+// without an ETag are not resumed, since changes could not be detected.
+func newResumingReader(ctx context.Context, b *bucket, key string, rc io.ReadCloser, size int64, etag string) io.ReadCloser {
+	if b.retry.maxResumes() == 0 || etag == "" {
+		return rc
+	}
+	return &resumingReader{ctx: ctx, b: b, key: key, rc: rc, size: size, etag: etag}
+}
+
+func (r *resumingReader) Read(p []byte) (int, error) {
+	for {
+		err := r.pending
+		r.pending = nil
+		n := 0
+		if err == nil {
+			r.mu.Lock()
+			rc := r.rc
+			r.mu.Unlock()
+			n, err = rc.Read(p)
+			r.offset += int64(n)
+			if err == io.EOF && r.size >= 0 && r.offset < r.size {
+				err = io.ErrUnexpectedEOF
+			}
+		}
+		switch {
+		case err == nil, err == io.EOF:
+			return n, err
+		case n > 0:
+			// Callers may stop at the error, so handle it on the next Read
+			r.pending = err
+			return n, nil
+		}
+		if err := r.resume(err); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// resume reopens the object at the current offset if err is transient
+func (r *resumingReader) resume(err error) error {
+	name := r.b.redaction.Redact(r.key)
+	if r.ctx.Err() != nil || !retryPolicyFor(r.ctx, r.b).retryable(err) {
+		return err
+	}
+	if r.resumes >= r.b.retry.maxResumes() {
+		return errors.Wrapf(err, "read of %s failed after %d resumes", name, r.resumes)
+	}
+	r.resumes++
+	countRetry(r.ctx, 0)
+	var rc io.ReadCloser
+	rerr := retry(r.ctx, r.b, fmt.Sprintf("resume of %s at offset %d", name, r.offset), func() error {
+		var err error
+		rc, _, err = openRange(r.ctx, r.b, r.key, r.offset, -1, r.etag)
+		return err
+	})
+	if rerr != nil {
+		return errors.Wrapf(rerr, "failed to resume the read of %s", name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		_ = rc.Close()
+		return err
+	}
+	_ = r.rc.Close()
+	r.rc = rc
+	return nil
+}
+
+func (r *resumingReader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.rc.Close()
+}
@@ -0,0 +1,191 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type ResumeSuite struct{}
+
+var _ = Suite(&ResumeSuite{})
+
+// resetContainer returns items whose readers fail after a number of bytes
+type resetContainer struct {
+	*memContainer
+	// failAfter is the number of bytes read before a failure
+	failAfter int
+	// failures is the number of readers that fail
+	failures int
+	err      error
+	opens    int
+	// onFailure is called when a reader fails
+	onFailure func()
+}
+
+func (c *resetContainer) Item(id string) (stow.Item, error) {
+	item, err := c.memContainer.Item(id)
+	if err != nil {
+		return nil, err
+	}
+	return &resetItem{memItem: item.(*memItem), c: c}, nil
+}
+
+type resetItem struct {
+	*memItem
+	c *resetContainer
+}
+
+func (i *resetItem) Open() (io.ReadCloser, error) {
+	i.c.opens++
+	rc, _ := i.memItem.Open()
+	if i.c.failures == 0 {
+		return rc, nil
+	}
+	i.c.failures--
+	return ioutil.NopCloser(io.MultiReader(
+		io.LimitReader(rc, int64(i.c.failAfter)),
+		&resetReader{c: i.c},
+	)), nil
+}
+
+type resetReader struct {
+	c *resetContainer
+}
+
+func (r *resetReader) Read(p []byte) (int, error) {
+	if r.c.onFailure != nil {
+		r.c.onFailure()
+	}
+	return 0, r.c.err
+}
+
+func newResetBucket(failAfter, failures int) (*bucket, *resetContainer) {
+	rc := &resetContainer{
+		memContainer: newMemContainer("reset"),
+		failAfter:    failAfter,
+		failures:     failures,
+		err:          errors.New("read tcp 10.0.0.1:443: connection reset by peer"),
+	}
+	b := newBucket(ProviderConfig{Retry: RetryPolicy{Backoff: 1}}, rc, nil, "mem://")
+	return b, rc
+}
+
+func (s *ResumeSuite) TestResume(c *C) {
+	ctx := context.Background()
+	b, rc := newResetBucket(100, 0)
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	c.Assert(b.PutBytes(ctx, "obj", data, nil), IsNil)
+
+	// Each reader fails after 100 bytes, so the read resumes 4 times from
+	// the offset it reached
+	rc.failures = 4
+	got, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+	c.Assert(rc.opens, Equals, 5)
+
+	r, _, err := b.GetSpooled(ctx, "obj", SpoolOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	// Resumes are limited
+	rc.failures, rc.opens = 10, 0
+	_, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(err, ErrorMatches, "read of obj failed after 5 resumes: .*connection reset by peer")
+	c.Assert(rc.opens, Equals, 6)
+
+	b.retry.MaxResumes = -1
+	rc.failures, rc.opens = 1, 0
+	_, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(err, ErrorMatches, ".*connection reset by peer")
+	c.Assert(rc.opens, Equals, 1)
+
+	// Errors that are not transient are returned
+	b.retry.MaxResumes = 0
+	rc.failures, rc.opens = 1, 0
+	rc.err = errors.New("checksum error")
+	_, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(err, ErrorMatches, "checksum error")
+	c.Assert(rc.opens, Equals, 1)
+}
+
+func (s *ResumeSuite) TestObjectChanged(c *C) {
+	ctx := context.Background()
+	b, rc := newResetBucket(10, 1)
+	c.Assert(b.PutBytes(ctx, "obj", bytes.Repeat([]byte("a"), 100), nil), IsNil)
+	rc.onFailure = func() {
+		_, err := rc.memContainer.Put("obj", strings.NewReader(strings.Repeat("b", 100)), 100, nil)
+		c.Assert(err, IsNil)
+	}
+	r, _, err := b.Get(ctx, "obj")
+	c.Assert(err, IsNil)
+	defer r.Close()
+	data, err := ioutil.ReadAll(r)
+	c.Assert(IsObjectChanged(err), Equals, true)
+	c.Assert(err, ErrorMatches, "failed to resume the read of obj: object obj changed while it was read")
+	c.Assert(string(data), Equals, strings.Repeat("a", 10))
+}
+
+func (s *ResumeSuite) TestS3Range(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	ranger := newS3Ranger(b.versioner.(*s3Versioner).client, "versioned", NameRedaction{})
+	data := []byte("0123456789")
+	_, err := b.PutVersioned(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil)
+	c.Assert(err, IsNil)
+	etag := fmt.Sprintf("%x", md5.Sum(data))
+
+	for _, tc := range []struct {
+		offset, length int64
+		etag           string
+		expected       string
+	}{
+		{offset: 0, length: -1, expected: "0123456789"},
+		{offset: 4, length: -1, etag: etag, expected: "456789"},
+		{offset: 4, length: 3, etag: `"` + etag + `"`, expected: "456"},
+		{offset: 8, length: 10, expected: "89"},
+	} {
+		r, size, err := ranger.OpenRange(ctx, "obj", tc.offset, tc.length, tc.etag)
+		c.Assert(err, IsNil, Commentf("%+v", tc))
+		c.Assert(size, Equals, int64(10))
+		got, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		c.Check(string(got), Equals, tc.expected, Commentf("%+v", tc))
+	}
+
+	_, _, err = ranger.OpenRange(ctx, "obj", 4, -1, "0123")
+	c.Assert(IsObjectChanged(err), Equals, true)
+	_, _, err = ranger.OpenRange(ctx, "obj", 10, -1, "")
+	c.Assert(IsRangeNotSatisfiable(err), Equals, true)
+
+	// The bucket tells an empty range from one past the end with the
+	// object in its container
+	b.ranger = ranger
+	c.Assert(b.PutOpts(ctx, "obj", bytes.NewReader(data), int64(len(data)), nil), IsNil)
+	r, size, err := b.GetRange(ctx, "obj", 10, -1)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(10))
+	c.Assert(r.Close(), IsNil)
+	got, size, err := b.GetBytesRange(ctx, "obj", 7, 2)
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(10))
+	c.Assert(string(got), Equals, "78")
+	_, size, err = b.GetRange(ctx, "obj", 11, -1)
+	c.Assert(IsRangeNotSatisfiable(err), Equals, true)
+	c.Assert(size, Equals, int64(10))
+}
@@ -0,0 +1,238 @@
+package objectstore
+
+// Retries of requests that fail with transient errors
+
+import (
+	"context"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRetryAttempts   = 3
+	defaultRetryBackoff    = 100 * time.Millisecond
+	defaultRetryMaxBackoff = 10 * time.Second
+)
+
+// RetryPolicy controls the retries of requests that fail with transient
+// errors, such as 5xx responses, throttling, timeouts and connection
+// resets. Errors such as 403 or 404 are not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts of a request, including the
+	// first. Defaults to 3. Set it to 1 to disable retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles with every
+	// retry, with jitter. Defaults to 100ms.
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries. Defaults to 10s.
+	MaxBackoff time.Duration
+	// MaxResumes is the number of times a read that fails with a transient
+	// error is resumed from the offset it reached. Defaults to 5. Set it to
+	// -1 to disable resumes.
+	MaxResumes int
+	// Retryable classifies the errors that are retried, so that providers
+	// with errors of their own can be plugged in. Defaults to IsRetryable.
+	// Requests are never retried once their context is done.
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultRetryAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = defaultRetryBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultRetryMaxBackoff
+	}
+	return p
+}
+
+// retryable returns true if err is retried by the policy
+func (p RetryPolicy) retryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return isRetryable(err)
+}
+
+type retryKey struct {
+	attempts int
+	backoff  time.Duration
+}
+
+// WithRetry returns a context that overrides the number of attempts and the
+// initial backoff of the retry policy of the bucket for the requests made
+// with it. Zero values keep those of the bucket.
+func WithRetry(ctx context.Context, maxAttempts int, baseDelay time.Duration) context.Context {
+	return context.WithValue(ctx, retryKey{}, retryKey{attempts: maxAttempts, backoff: baseDelay})
+}
+
+// retryPolicyFor returns the retry policy of the bucket, with the overrides
+// set in ctx
+func retryPolicyFor(ctx context.Context, b *bucket) RetryPolicy {
+	p := b.retry
+	if o, ok := ctx.Value(retryKey{}).(retryKey); ok {
+		if o.attempts > 0 {
+			p.MaxAttempts = o.attempts
+		}
+		if o.backoff > 0 {
+			p.Backoff = o.backoff
+		}
+	}
+	return p.withDefaults()
+}
+
+// IsRetryable returns true if err is likely transient, such as a 5xx
+// response, throttling, a timeout or a connection reset. It is the default
+// classification of RetryPolicy, which custom classifications can extend.
+func IsRetryable(err error) bool {
+	return isRetryable(err)
+}
+
+// throttlingCodes are the error codes of S3 and other AWS services that
+// ask clients to slow down
+var throttlingCodes = map[string]bool{
+	"SlowDown":                true,
+	"Throttling":              true,
+	"ThrottlingException":     true,
+	"RequestLimitExceeded":    true,
+	"RequestThrottled":        true,
+	"TooManyRequests":         true,
+	"RequestTimeout":          true,
+	"InternalError":           true,
+	"ServiceUnavailable":      true,
+	"RequestTimeoutException": true,
+}
+
+var (
+	// Status codes in the messages of providers other than S3, e.g.
+	// "googleapi: Error 503: Backend Error" or "storage: service returned
+	// error: StatusCode=500"
+	retryableStatusRe = regexp.MustCompile(`\b(429|5\d\d)\b`)
+	terminalStatusRe  = regexp.MustCompile(`\b(400|401|403|404|409|412)\b`)
+)
+
+// isRetryable returns true if err is likely transient
+func isRetryable(err error) bool {
+	err = errors.Cause(err)
+	switch err {
+	case nil, stow.ErrNotFound, context.Canceled, context.DeadlineExceeded:
+		return false
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true
+	}
+	if rf, ok := err.(awserr.RequestFailure); ok {
+		return retryableStatus(rf.StatusCode()) || throttlingCodes[rf.Code()]
+	}
+	if ae, ok := err.(awserr.Error); ok {
+		if throttlingCodes[ae.Code()] {
+			return true
+		}
+		if ae.OrigErr() != nil {
+			return isRetryable(ae.OrigErr())
+		}
+	}
+	if ne, ok := err.(net.Error); ok && (ne.Timeout() || ne.Temporary()) {
+		return true
+	}
+	msg := err.Error()
+	switch {
+	case terminalStatusRe.MatchString(msg):
+		return false
+	case retryableStatusRe.MatchString(msg),
+		strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "timeout"),
+		strings.Contains(msg, "unexpected EOF"):
+		return true
+	}
+	return false
+}
+
+func retryableStatus(code int) bool {
+	return code == 429 || code >= 500
+}
+
+// retry calls f until it succeeds, fails with an error that is not
+// retryable, or the attempts of the policy of the bucket are used up. op
+// describes the request in logs.
+func retry(ctx context.Context, b *bucket, op string, f func() error) error {
+	policy := retryPolicyFor(ctx, b)
+	clk := clockFor(ctx, b)
+	rnd := randFor(ctx, b)
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.retryable(err) || ctx.Err() != nil {
+			if err != nil && attempt > 1 {
+				err = errors.Wrapf(err, "%s failed after %d attempts", op, attempt)
+			}
+			return err
+		}
+		log.WithError(err).Warnf("Retrying %s", op)
+		wait := jitter(rnd, backoff, 0.2)
+		countRetry(ctx, wait)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-clk.After(wait):
+		}
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// retryPut uploads r to the object at the absolute path objName, retrying
+// the upload if r can be read again from the start. Uploads of other readers
+// are not retried, so that a partially consumed reader is never uploaded.
+func retryPut(ctx context.Context, b *bucket, objName string, r io.Reader, size int64, tags map[string]interface{}) error {
+	name := b.redaction.Redact(objName)
+	return retryUpload(ctx, b, objName, r, func(r io.Reader) error {
+		_, err := b.container.Put(cloudName(objName), reportProgress(ctx, name, &ctxReader{ctx: ctx, r: r}, size), size, tags)
+		return err
+	})
+}
+
+// retryUpload calls put with r, and retries it as the retry policy allows if
+// r can be rewound to where it started
+func retryUpload(ctx context.Context, b *bucket, objName string, r io.Reader, put func(io.Reader) error) error {
+	name := b.redaction.Redact(objName)
+	s, ok := r.(io.Seeker)
+	var start int64
+	if ok {
+		var err error
+		if start, err = s.Seek(0, io.SeekCurrent); err != nil {
+			ok = false
+		}
+	}
+	if !ok {
+		err := put(r)
+		if policy := retryPolicyFor(ctx, b); policy.retryable(err) && policy.MaxAttempts > 1 {
+			return errors.Wrapf(err, "upload of %s was not retried since its data cannot be read again", name)
+		}
+		return err
+	}
+	first := true
+	return retry(ctx, b, "upload of "+name, func() error {
+		if !first {
+			if _, err := s.Seek(start, io.SeekStart); err != nil {
+				return errors.Wrapf(err, "failed to rewind the data of %s", name)
+			}
+		}
+		first = false
+		return put(r)
+	})
+}
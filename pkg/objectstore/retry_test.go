@@ -0,0 +1,242 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+)
+
+type RetrySuite struct{}
+
+var _ = Suite(&RetrySuite{})
+
+func (s *RetrySuite) TestIsRetryable(c *C) {
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{err: awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "id"), retryable: true},
+		{err: awserr.NewRequestFailure(awserr.New("InternalError", "", nil), 500, "id"), retryable: true},
+		{err: awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "id"), retryable: false},
+		{err: awserr.NewRequestFailure(awserr.New("NoSuchBucket", "", nil), 404, "id"), retryable: false},
+		{err: awserr.New("RequestError", "send request failed", reset), retryable: true},
+		{err: awserr.New("InvalidBucketName", "", nil), retryable: false},
+		{err: errors.Wrap(awserr.New("SlowDown", "", nil), "failed to put"), retryable: true},
+		{err: reset, retryable: true},
+		{err: io.ErrUnexpectedEOF, retryable: true},
+		{err: errors.New("googleapi: Error 503: Backend Error, backendError"), retryable: true},
+		{err: errors.New("googleapi: Error 403: Forbidden, forbidden"), retryable: false},
+		{err: errors.New("storage: service returned error: StatusCode=500, ErrorCode=InternalError"), retryable: true},
+		{err: errors.New("read tcp 10.0.0.1:443: connection reset by peer"), retryable: true},
+		{err: stow.ErrNotFound, retryable: false},
+		{err: context.Canceled, retryable: false},
+		{err: errors.New("invalid bucket name"), retryable: false},
+		{err: nil, retryable: false},
+	} {
+		c.Check(isRetryable(tc.err), Equals, tc.retryable, Commentf("%v", tc.err))
+	}
+}
+
+// flakyContainer fails requests a number of times
+type flakyContainer struct {
+	*memContainer
+	err      error
+	failures map[string]int
+	attempts map[string]int
+}
+
+func newFlakyBucket(err error) (*bucket, *flakyContainer) {
+	fc := &flakyContainer{
+		memContainer: newMemContainer("flaky"),
+		err:          err,
+		failures:     make(map[string]int),
+		attempts:     make(map[string]int),
+	}
+	b := newBucket(ProviderConfig{Retry: RetryPolicy{Backoff: time.Nanosecond}}, fc, nil, "mem://")
+	return b, fc
+}
+
+func (c *flakyContainer) fail(op string) bool {
+	c.attempts[op]++
+	if c.failures[op] > 0 {
+		c.failures[op]--
+		return true
+	}
+	return false
+}
+
+func (c *flakyContainer) Put(name string, r io.Reader, size int64, metadata map[string]interface{}) (stow.Item, error) {
+	if c.fail("put") {
+		// The data is consumed before the request fails
+		_, _ = io.Copy(ioutil.Discard, io.LimitReader(r, size/2))
+		return nil, c.err
+	}
+	return c.memContainer.Put(name, r, size, metadata)
+}
+
+func (c *flakyContainer) Item(id string) (stow.Item, error) {
+	if c.fail("item") {
+		return nil, c.err
+	}
+	return c.memContainer.Item(id)
+}
+
+func (c *flakyContainer) RemoveItem(id string) error {
+	if c.fail("remove") {
+		return c.err
+	}
+	return c.memContainer.RemoveItem(id)
+}
+
+func (s *RetrySuite) TestRetry(c *C) {
+	ctx := context.Background()
+	b, fc := newFlakyBucket(awserr.NewRequestFailure(awserr.New("SlowDown", "Please reduce your request rate.", nil), 503, "id"))
+	data := bytes.Repeat([]byte("data"), 100)
+
+	fc.failures["put"] = 2
+	c.Assert(b.PutBytes(ctx, "obj", data, nil), IsNil)
+	c.Assert(fc.attempts["put"], Equals, 3)
+	// The data was uploaded from the start
+	c.Assert(fc.items["obj"].data, DeepEquals, data)
+
+	fc.failures["item"] = 2
+	got, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+
+	fc.failures["item"] = 1
+	info, err := b.Stat(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(info.Size, Equals, int64(len(data)))
+
+	fc.failures["remove"] = 1
+	c.Assert(b.Delete(ctx, "obj"), IsNil)
+
+	// Attempts are capped
+	fc.failures["item"] = 3
+	fc.attempts["item"] = 0
+	_, _, err = b.GetBytes(ctx, "obj")
+	c.Assert(err, ErrorMatches, "(?s)read of /obj failed after 3 attempts: SlowDown: .*")
+	c.Assert(fc.attempts["item"], Equals, 3)
+}
+
+func (s *RetrySuite) TestNoRetry(c *C) {
+	ctx := context.Background()
+	b, fc := newFlakyBucket(errors.New("503 Service Unavailable"))
+
+	// Readers that cannot be rewound are not uploaded again
+	fc.failures["put"] = 1
+	r := io.MultiReader(bytes.NewReader([]byte("data")))
+	err := b.Put(ctx, "obj", r, 4, nil)
+	c.Assert(err, ErrorMatches, "upload of /obj was not retried since its data cannot be read again: 503 Service Unavailable")
+	c.Assert(fc.attempts["put"], Equals, 1)
+	c.Assert(fc.items, HasLen, 0)
+
+	// Terminal errors are not retried
+	b, fc = newFlakyBucket(awserr.NewRequestFailure(awserr.New("AccessDenied", "Access Denied", nil), 403, "id"))
+	fc.failures["put"] = 1
+	err = b.PutBytes(ctx, "obj", []byte("data"), nil)
+	c.Assert(err, ErrorMatches, "(?s)AccessDenied: Access Denied.*")
+	c.Assert(fc.attempts["put"], Equals, 1)
+
+	// Retries can be disabled
+	b, fc = newFlakyBucket(io.ErrUnexpectedEOF)
+	b.retry.MaxAttempts = 1
+	fc.failures["item"] = 1
+	_, err = b.Exists(ctx, "obj")
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	c.Assert(fc.attempts["item"], Equals, 1)
+}
+
+func (s *RetrySuite) TestBackoff(c *C) {
+	ctx := context.Background()
+	b, fc := newFlakyBucket(io.ErrUnexpectedEOF)
+	clk := newFakeClock()
+	b.clock = clk
+	b.retry = RetryPolicy{MaxAttempts: 5, Backoff: time.Second, MaxBackoff: 3 * time.Second}
+	fc.failures["item"] = 4
+	start := clk.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Exists(ctx, "obj")
+		done <- err
+	}()
+	c.Assert(advanceUntilDone(clk, 0, done), IsNil)
+	c.Assert(fc.attempts["item"], Equals, 5)
+	// 1s, 2s, 3s and 3s, with up to 20% of jitter
+	elapsed := clk.Now().Sub(start)
+	c.Assert(elapsed >= 7200*time.Millisecond && elapsed <= 10800*time.Millisecond, Equals, true, Commentf("%s", elapsed))
+}
+
+func (s *RetrySuite) TestClassifier(c *C) {
+	ctx := context.Background()
+	quota := errors.New("quota exceeded, try again later")
+	b, fc := newFlakyBucket(quota)
+	c.Assert(IsRetryable(quota), Equals, false)
+
+	// Errors of other providers can be classified as retryable
+	b.retry.Retryable = func(err error) bool {
+		return errors.Cause(err) == quota || IsRetryable(err)
+	}
+	fc.failures["item"] = 2
+	_, err := b.Exists(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(fc.attempts["item"], Equals, 3)
+
+	// and the default classification can be narrowed
+	b, fc = newFlakyBucket(io.ErrUnexpectedEOF)
+	b.retry.Retryable = func(err error) bool { return false }
+	fc.failures["item"] = 1
+	_, err = b.Exists(ctx, "obj")
+	c.Assert(errors.Cause(err), Equals, io.ErrUnexpectedEOF)
+	c.Assert(fc.attempts["item"], Equals, 1)
+}
+
+func (s *RetrySuite) TestWithRetry(c *C) {
+	b, fc := newFlakyBucket(io.ErrUnexpectedEOF)
+	clk := newFakeClock()
+	b.clock = clk
+	b.retry = RetryPolicy{MaxAttempts: 2, Backoff: time.Second, MaxBackoff: time.Hour}
+
+	// The context overrides the attempts and backoff of the bucket
+	ctx := WithRetry(context.Background(), 4, time.Minute)
+	fc.failures["item"] = 3
+	start := clk.Now()
+	done := make(chan error, 1)
+	go func() {
+		_, err := b.Exists(ctx, "obj")
+		done <- err
+	}()
+	c.Assert(advanceUntilDone(clk, 0, done), IsNil)
+	c.Assert(fc.attempts["item"], Equals, 4)
+	// 1m, 2m and 4m, with up to 20% of jitter
+	elapsed := clk.Now().Sub(start)
+	c.Assert(elapsed >= 336*time.Second && elapsed <= 504*time.Second, Equals, true, Commentf("%s", elapsed))
+
+	// Retries stop when the context is canceled during a backoff
+	cctx, cancel := context.WithCancel(ctx)
+	fc.failures["item"] = 3
+	fc.attempts["item"] = 0
+	seen := clk.AfterCalls()
+	go func() {
+		_, err := b.Exists(cctx, "obj")
+		done <- err
+	}()
+	for clk.AfterCalls() == seen {
+		runtime.Gosched()
+	}
+	cancel()
+	c.Assert(errors.Cause(<-done), Equals, io.ErrUnexpectedEOF)
+	c.Assert(fc.attempts["item"], Equals, 1)
+}
@@ -0,0 +1,347 @@
+package objectstore
+
+// Rewrite the paths of existing objects in a directory tree
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+const defaultRewriteConcurrency = 8
+
+// RewriteMapping maps the path of an object, relative to the root of the
+// rewritten tree, to its new path. Objects are left in place if skip is true
+// or if the new path is the same as the old one.
+type RewriteMapping func(oldPath string) (newPath string, skip bool)
+
+// RewriteOptions control the behavior of RewritePrefix
+type RewriteOptions struct {
+	// DryRun reports the mapping without copying or deleting anything
+	DryRun bool
+	// Concurrency is the maximum number of objects moved at the same time.
+	// Defaults to 8.
+	Concurrency int
+	// Archive controls the handling of objects in archival storage
+	Archive ArchiveOptions
+}
+
+// RewriteStats summarizes the result of RewritePrefix
+type RewriteStats struct {
+	// Moved is the number of objects copied to, and removed from, their
+	// old location
+	Moved int
+	// Resumed is the number of objects that were already present at their
+	// new location and were only removed from their old location
+	Resumed int
+	// Skipped is the number of objects left in place
+	Skipped int
+	// Archived lists the objects that were left in place because they are
+	// in archival storage. The rewrite is incomplete if it is not empty.
+	Archived []string
+	// Bytes is the number of bytes copied
+	Bytes int64
+	// Mapping of old paths to new paths of all the objects that are, or
+	// in a dry run would be, moved
+	Mapping map[string]string
+}
+
+// RewritePrefix moves every object in the tree rooted at d to the path
+// returned by mapping. Each object is copied, verified against the source
+// size and checksum, and then deleted from its old location. Objects that
+// already exist at their new location with a matching size and checksum are
+// not copied again, so an interrupted rewrite can be resumed by running it
+// again. Directory markers are created for the new paths and removed for
+// old directories that are left empty. Objects that could not be moved are
+// reported by a BatchError.
+func RewritePrefix(ctx context.Context, d Directory, mapping RewriteMapping, opts RewriteOptions) (RewriteStats, error) {
+	stats := RewriteStats{Mapping: make(map[string]string)}
+	root, err := asDirectory(d)
+	if err != nil {
+		return stats, err
+	}
+	objects, dirs, archived, err := listTree(ctx, d)
+	if err != nil {
+		return stats, err
+	}
+	var moves, archivedMoves []string
+	dstDirs := make(map[string]bool)
+	for _, o := range objects {
+		np, skip := mapping(o)
+		np = strings.TrimPrefix(path.Clean("/"+np), "/")
+		if skip || np == o || np == "" {
+			stats.Skipped++
+			continue
+		}
+		stats.Mapping[o] = np
+		moves = append(moves, o)
+		if archived[o] {
+			archivedMoves = append(archivedMoves, cloudName(root.absPathName(o)))
+		}
+		for dir := path.Dir(np); dir != "."; dir = path.Dir(dir) {
+			dstDirs[dir] = true
+		}
+	}
+	if opts.DryRun {
+		for _, k := range archivedMoves {
+			stats.Archived = append(stats.Archived, strings.TrimPrefix(k, cloudName(root.path)))
+		}
+		sort.Strings(stats.Archived)
+		return stats, nil
+	}
+	skipped, err := handleArchived(ctx, root.bucket, archivedMoves, opts.Archive)
+	if err != nil {
+		return stats, err
+	}
+	if len(skipped) != 0 {
+		skip := make(map[string]bool, len(skipped))
+		for _, k := range skipped {
+			o := strings.TrimPrefix(k, cloudName(root.path))
+			skip[o] = true
+			stats.Archived = append(stats.Archived, o)
+		}
+		remaining := moves[:0]
+		for _, o := range moves {
+			if !skip[o] {
+				remaining = append(remaining, o)
+			}
+		}
+		moves = remaining
+	}
+
+	if err := createDirectories(ctx, d, dstDirs); err != nil {
+		return stats, err
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultRewriteConcurrency
+	}
+	var mu sync.Mutex
+	errs := newBatchError()
+	work := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for o := range work {
+				copied, n, err := moveObject(ctx, d, o, stats.Mapping[o])
+				mu.Lock()
+				switch {
+				case err != nil:
+					errs.add(root.bucket.redaction.Redact(o), "move", err)
+				case copied:
+					stats.Moved++
+					stats.Bytes += n
+				default:
+					stats.Resumed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+send:
+	for _, o := range moves {
+		select {
+		case work <- o:
+		case <-ctx.Done():
+			break send
+		}
+	}
+	close(work)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return stats, err
+	}
+	if err := errs.errOrNil(); err != nil {
+		return stats, err
+	}
+	return stats, removeEmptyDirectories(ctx, d, dirs, dstDirs)
+}
+
+// listTree returns the relative paths of all the objects and directories
+// in the tree rooted at d, and the set of objects that are archived.
+// Directories are reported whether or not they have a marker.
+func listTree(ctx context.Context, d Directory) ([]string, []string, map[string]bool, error) {
+	dir, err := asDirectory(d)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	prefix := cloudName(dir.path)
+	var objects []string
+	dirs := make(map[string]bool)
+	archived := make(map[string]bool)
+	err = stow.Walk(dir.bucket.container, prefix, dir.bucket.pageSize,
+		func(item stow.Item, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			name := strings.TrimPrefix(item.Name(), prefix)
+			if name == "" {
+				return nil
+			}
+			if !strings.HasSuffix(name, "/") {
+				objects = append(objects, name)
+				if isArchived(item) {
+					archived[name] = true
+				}
+			}
+			for p := path.Dir(name); p != "." && p != "/"; p = path.Dir(p) {
+				dirs[p] = true
+			}
+			return nil
+		})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dl := make([]string, 0, len(dirs))
+	for p := range dirs {
+		dl = append(dl, p)
+	}
+	return objects, dl, archived, nil
+}
+
+// createDirectories creates the markers of the directories dirs, relative
+// to d
+func createDirectories(ctx context.Context, d Directory, dirs map[string]bool) error {
+	for dir := range dirs {
+		if _, err := d.CreateDirectory(ctx, dir); err != nil {
+			return errors.Wrapf(err, "failed to create directory %s", redactionOf(d).Redact(dir))
+		}
+	}
+	return nil
+}
+
+// moveObject copies src to dst and deletes src. If dst already matches src,
+// it is not copied again and copied is false.
+func moveObject(ctx context.Context, d Directory, src, dst string) (copied bool, n int64, err error) {
+	r := redactionOf(d)
+	srcSize, srcETag, err := statObject(ctx, d, src)
+	if err != nil {
+		return false, 0, errors.Wrapf(err, "failed to read %s", r.Redact(src))
+	}
+	dstSize, dstETag, err := statObject(ctx, d, dst)
+	switch {
+	case err == nil && objectsMatch(srcSize, srcETag, dstSize, dstETag):
+	case err == nil || IsObjectNotFound(err):
+		if err := copyObject(ctx, d, src, dst); err != nil {
+			return false, 0, err
+		}
+		dstSize, dstETag, err = statObject(ctx, d, dst)
+		if err != nil {
+			return false, 0, errors.Wrapf(err, "failed to read %s", r.Redact(dst))
+		}
+		if !objectsMatch(srcSize, srcETag, dstSize, dstETag) {
+			return false, 0, errors.Errorf("copy of %s to %s does not match the source", r.Redact(src), r.Redact(dst))
+		}
+		copied, n = true, srcSize
+	default:
+		return false, 0, errors.Wrapf(err, "failed to read %s", r.Redact(dst))
+	}
+	if err := d.Delete(ctx, src); err != nil {
+		return copied, n, errors.Wrapf(err, "failed to delete %s", r.Redact(src))
+	}
+	return copied, n, nil
+}
+
+// copyObject copies src to dst with Copy, by the provider if it can, and
+// otherwise by streaming the data as it is stored, so that compressed and
+// encrypted objects keep their data and tags
+func copyObject(ctx context.Context, d Directory, src, dst string) error {
+	red := redactionOf(d)
+	if err := d.Copy(ctx, src, d, dst); err != nil {
+		return errors.Wrapf(err, "failed to copy %s to %s", red.Redact(src), red.Redact(dst))
+	}
+	return nil
+}
+
+// objectsMatch compares the sizes, and if both are plain MD5 checksums,
+// the ETags of two objects
+func objectsMatch(size1 int64, etag1 string, size2 int64, etag2 string) bool {
+	if size1 != size2 {
+		return false
+	}
+	if isMD5ETag(etag1) && isMD5ETag(etag2) {
+		return strings.Trim(etag1, `"`) == strings.Trim(etag2, `"`)
+	}
+	return true
+}
+
+// statObject returns the size and ETag of the object name in d
+func statObject(ctx context.Context, d Directory, name string) (int64, string, error) {
+	dir, err := asDirectory(d)
+	if err != nil {
+		return 0, "", err
+	}
+	item, err := dir.bucket.container.Item(cloudName(dir.absPathName(name)))
+	if err != nil {
+		return 0, "", err
+	}
+	size, err := item.Size()
+	if err != nil {
+		return 0, "", err
+	}
+	// Not all providers report an ETag
+	etag, _ := item.ETag()
+	return size, etag, nil
+}
+
+// removeEmptyDirectories removes the markers of the directories dirs,
+// relative to d, that are no longer in use. Directories are processed
+// deepest first, so that a directory whose sub directories were all removed
+// is removed as well.
+func removeEmptyDirectories(ctx context.Context, d Directory, dirs []string, keep map[string]bool) error {
+	dir, err := asDirectory(d)
+	if err != nil {
+		return err
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return strings.Count(dirs[i], "/") > strings.Count(dirs[j], "/")
+	})
+	for _, sd := range dirs {
+		if keep[sd] {
+			continue
+		}
+		marker := cloudName(dir.absDirName(sd))
+		items, _, err := dir.bucket.container.Items(marker, stow.CursorStart, 2)
+		if err != nil {
+			return err
+		}
+		if len(items) != 1 || items[0].Name() != marker {
+			// Not empty, or no marker
+			continue
+		}
+		err = dir.bucket.container.RemoveItem(marker)
+		dir.bucket.listingCache.invalidate(dir.absDirName(sd))
+		if err != nil {
+			return errors.Wrapf(err, "failed to remove directory %s", dir.bucket.redaction.Redact(sd))
+		}
+	}
+	return nil
+}
+
+func asDirectory(d Directory) (*directory, error) {
+	switch v := d.(type) {
+	case *directory:
+		return v, nil
+	case *bucket:
+		return v.directory, nil
+	case *failoverDirectory:
+		// Failover directories are written to on their primary
+		p, err := v.primaryDirectory()
+		if err != nil {
+			return nil, err
+		}
+		return asDirectory(p)
+	}
+	return nil, errors.Errorf("unsupported directory type %T", d)
+}
@@ -0,0 +1,157 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type RewriteSuite struct{}
+
+var _ = Suite(&RewriteSuite{})
+
+// teamMapping maps <ns>-<app>/<date>/<file> to teams/<ns>/<app>/<date>/<file>
+func teamMapping(old string) (string, bool) {
+	parts := strings.SplitN(old, "/", 2)
+	nsApp := strings.SplitN(parts[0], "-", 2)
+	if len(parts) != 2 || len(nsApp) != 2 {
+		return "", true
+	}
+	return fmt.Sprintf("teams/%s/%s/%s", nsApp[0], nsApp[1], parts[1]), false
+}
+
+func populateTree(c *C, d Directory, apps, dates, files int) map[string]string {
+	ctx := context.Background()
+	expected := make(map[string]string)
+	for a := 0; a < apps; a++ {
+		for t := 0; t < dates; t++ {
+			dir := fmt.Sprintf("ns%d-app%d/2018-08-%02d", a%3, a, t+1)
+			sd, err := d.CreateDirectory(ctx, dir)
+			c.Assert(err, IsNil)
+			for f := 0; f < files; f++ {
+				name := fmt.Sprintf("file%d", f)
+				content := dir + "/" + name
+				c.Assert(sd.PutBytes(ctx, name, []byte(content), map[string]string{"app": "test"}), IsNil)
+				np, _ := teamMapping(content)
+				expected[np] = content
+			}
+		}
+	}
+	return expected
+}
+
+func (s *RewriteSuite) TestRewritePrefix(c *C) {
+	ctx := context.Background()
+	b, cont := newMemBucket("rewrite")
+	expected := populateTree(c, b, 10, 20, 12)
+	c.Assert(expected, HasLen, 2400)
+	c.Assert(b.PutBytes(ctx, "README", []byte("unmapped"), nil), IsNil)
+
+	// Dry run reports the full mapping and changes nothing
+	before := len(cont.items)
+	stats, err := RewritePrefix(ctx, b, teamMapping, RewriteOptions{DryRun: true})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Mapping, HasLen, len(expected))
+	for np, old := range expected {
+		c.Assert(stats.Mapping[old], Equals, np)
+	}
+	c.Assert(stats.Skipped, Equals, 1)
+	c.Assert(stats.Moved, Equals, 0)
+	c.Assert(cont.items, HasLen, before)
+
+	stats, err = RewritePrefix(ctx, b, teamMapping, RewriteOptions{Concurrency: 4})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Moved, Equals, len(expected))
+	c.Assert(stats.Skipped, Equals, 1)
+
+	// Every object, and the marker of every directory, is at its new
+	// location
+	for np, content := range expected {
+		data, tags, err := b.GetBytes(ctx, np)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+		c.Assert(tags, DeepEquals, map[string]string{"app": "test"})
+		for dir := np[:strings.LastIndex(np, "/")]; dir != "teams"; dir = dir[:strings.LastIndex(dir, "/")] {
+			c.Assert(cont.items[dir+"/"], NotNil, Commentf("missing marker %s", dir))
+		}
+	}
+	c.Assert(cont.items["teams/"], NotNil)
+	// Nothing is left at the old locations
+	for name := range cont.items {
+		c.Assert(strings.HasPrefix(name, "teams/") || name == "README", Equals, true, Commentf("left over %s", name))
+	}
+	top, err := b.ListDirectories(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(top, HasLen, 1)
+	c.Assert(top["teams"], NotNil)
+}
+
+func (s *RewriteSuite) TestRewritePrefixResume(c *C) {
+	ctx := context.Background()
+	b, cont := newMemBucket("rewrite")
+	expected := populateTree(c, b, 2, 2, 5)
+
+	// Simulate an interrupted run that copied one object but did not
+	// delete its source, and copied another one partially
+	var done, partial string
+	for np, old := range expected {
+		if done == "" {
+			done = old
+			c.Assert(b.PutBytes(ctx, np, []byte(old), nil), IsNil)
+		} else if partial == "" {
+			partial = old
+			c.Assert(b.PutBytes(ctx, np, []byte("trunc"), nil), IsNil)
+		}
+	}
+	stats, err := RewritePrefix(ctx, b, teamMapping, RewriteOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Resumed, Equals, 1)
+	c.Assert(stats.Moved, Equals, len(expected)-1)
+	for np, content := range expected {
+		data, _, err := b.GetBytes(ctx, np)
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, content)
+		c.Assert(cont.items[content], IsNil)
+	}
+
+	// Running again is a no-op
+	stats, err = RewritePrefix(ctx, b, teamMapping, RewriteOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(stats.Moved+stats.Resumed, Equals, 0)
+	c.Assert(stats.Skipped, Equals, len(expected))
+}
+
+func (s *RewriteSuite) TestRewritePrefixCodecs(c *C) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		name  string
+		setup func(b *bucket)
+		tag   string
+	}{
+		{name: "compressed", setup: func(b *bucket) { b.compression = CodecGzip }, tag: ContentEncodingTag},
+		{name: "encrypted", setup: func(b *bucket) { b.keyring = newTestKeyring(c, "a", "a") }, tag: EncryptionTag},
+	} {
+		for _, copier := range []bool{false, true} {
+			comment := Commentf("%s, copier %v", tc.name, copier)
+			b, cont := newMemBucket("rewrite")
+			tc.setup(b)
+			if copier {
+				b.copier = &memCopier{c: cont}
+			}
+			expected := populateTree(c, b, 2, 2, 3)
+			stats, err := RewritePrefix(ctx, b, teamMapping, RewriteOptions{})
+			c.Assert(err, IsNil, comment)
+			c.Assert(stats.Moved, Equals, len(expected), comment)
+			// Objects are moved as they are stored, and are read back
+			for np, content := range expected {
+				data, tags, err := b.GetBytes(ctx, np)
+				c.Assert(err, IsNil, comment)
+				c.Assert(string(data), Equals, content, comment)
+				c.Assert(tags["app"], Equals, "test", comment)
+				c.Assert(cont.items[np].metadata[tc.tag], Not(Equals), "", comment)
+			}
+		}
+	}
+}
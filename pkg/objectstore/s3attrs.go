@@ -0,0 +1,39 @@
+package objectstore
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+var _ attrsReader = (*s3AttrsReader)(nil)
+
+// s3AttrsReader reads the attributes of objects with the S3 HeadObject API
+type s3AttrsReader struct {
+	client    *s3.S3
+	bucket    string
+	redaction NameRedaction
+}
+
+func newS3AttrsReader(client *s3.S3, bucket string, redaction NameRedaction) *s3AttrsReader {
+	return &s3AttrsReader{client: client, bucket: bucket, redaction: redaction}
+}
+
+func (r *s3AttrsReader) ObjectAttrs(ctx context.Context, key string) (objectAttrs, error) {
+	out, err := r.client.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return objectAttrs{}, errors.Wrapf(err, "failed to read attributes of object %s", r.redaction.Redact(key))
+	}
+	return objectAttrs{
+		contentType: aws.StringValue(out.ContentType),
+		encryption: ServerSideEncryption{
+			Mode:     SSEMode(aws.StringValue(out.ServerSideEncryption)),
+			KMSKeyID: aws.StringValue(out.SSEKMSKeyId),
+		},
+	}, nil
+}
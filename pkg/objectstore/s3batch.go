@@ -0,0 +1,51 @@
+package objectstore
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+// s3MaxDeleteBatch is the maximum number of keys of a DeleteObjects request
+const s3MaxDeleteBatch = 1000
+
+var _ batchDeleter = (*s3BatchDeleter)(nil)
+
+// s3BatchDeleter deletes objects with the S3 DeleteObjects API
+type s3BatchDeleter struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3BatchDeleter(client *s3.S3, bucket string) *s3BatchDeleter {
+	return &s3BatchDeleter{client: client, bucket: bucket}
+}
+
+func (d *s3BatchDeleter) MaxBatchSize() int {
+	return s3MaxDeleteBatch
+}
+
+func (d *s3BatchDeleter) DeleteBatch(ctx context.Context, keys []string) (map[string]error, error) {
+	objects := make([]*s3.ObjectIdentifier, 0, len(keys))
+	for _, k := range keys {
+		objects = append(objects, &s3.ObjectIdentifier{Key: aws.String(k)})
+	}
+	out, err := d.client.DeleteObjectsWithContext(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(d.bucket),
+		Delete: &s3.Delete{
+			Objects: objects,
+			// Only report the errors
+			Quiet: aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error, len(out.Errors))
+	for _, e := range out.Errors {
+		failed[aws.StringValue(e.Key)] = errors.Errorf("%s: %s", aws.StringValue(e.Code), aws.StringValue(e.Message))
+	}
+	return failed, nil
+}
@@ -0,0 +1,38 @@
+package objectstore
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+var _ objectCopier = (*s3Copier)(nil)
+
+// s3Copier copies objects with the S3 CopyObject API, which keeps the
+// metadata of the source
+type s3Copier struct {
+	client    *s3.S3
+	bucket    string
+	redaction NameRedaction
+}
+
+func newS3Copier(client *s3.S3, bucket string, redaction NameRedaction) *s3Copier {
+	return &s3Copier{client: client, bucket: bucket, redaction: redaction}
+}
+
+func (c *s3Copier) CopyObject(ctx context.Context, srcKey, dstKey string, sse ServerSideEncryption) error {
+	// The source is the URL encoded bucket and key
+	source := (&url.URL{Path: c.bucket + "/" + srcKey}).EscapedPath()
+	input := &s3.CopyObjectInput{
+		Bucket:     aws.String(c.bucket),
+		Key:        aws.String(dstKey),
+		CopySource: aws.String(source),
+	}
+	// Copies are not encrypted like their sources
+	input.ServerSideEncryption, input.SSEKMSKeyId = s3Encryption(sse)
+	_, err := c.client.CopyObjectWithContext(ctx, input)
+	return errors.Wrapf(err, "failed to copy object %s to %s", c.redaction.Redact(srcKey), c.redaction.Redact(dstKey))
+}
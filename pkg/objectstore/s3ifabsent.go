@@ -0,0 +1,40 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+var _ ifAbsentWriter = (*s3IfAbsentWriter)(nil)
+
+// s3IfAbsentWriter writes objects with If-None-Match: *, which S3 rejects
+// with 412 Precondition Failed if the object exists
+type s3IfAbsentWriter struct {
+	client    *s3.S3
+	bucket    string
+	redaction NameRedaction
+}
+
+func newS3IfAbsentWriter(client *s3.S3, bucket string, redaction NameRedaction) *s3IfAbsentWriter {
+	return &s3IfAbsentWriter{client: client, bucket: bucket, redaction: redaction}
+}
+
+func (w *s3IfAbsentWriter) PutIfAbsent(ctx context.Context, key string, r io.ReadSeeker, size int64, metadata map[string]string, sse ServerSideEncryption) error {
+	input := &s3.PutObjectInput{
+		Bucket:        aws.String(w.bucket),
+		Key:           aws.String(key),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+		Metadata:      aws.StringMap(metadata),
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s3Encryption(sse)
+	req, _ := w.client.PutObjectRequest(input)
+	// The SDK does not model If-None-Match for uploads
+	req.HTTPRequest.Header.Set("If-None-Match", "*")
+	req.SetContext(ctx)
+	return errors.Wrapf(req.Send(), "failed to upload object %s", w.redaction.Redact(key))
+}
@@ -0,0 +1,93 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	s3MinPartSize = 5 * 1024 * 1024
+	s3MaxParts    = 10000
+)
+
+var _ multipartStore = (*s3Multipart)(nil)
+
+// s3Multipart uploads objects with the S3 multipart upload API
+type s3Multipart struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3Multipart(client *s3.S3, bucket string) *s3Multipart {
+	return &s3Multipart{client: client, bucket: bucket}
+}
+
+func (m *s3Multipart) PartLimits() (int64, int) {
+	return s3MinPartSize, s3MaxParts
+}
+
+func (m *s3Multipart) CreateMultipart(ctx context.Context, key string, metadata map[string]string, attrs objectAttrs) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(key),
+		Metadata: aws.StringMap(metadata),
+	}
+	if attrs.contentType != "" {
+		input.ContentType = aws.String(attrs.contentType)
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s3Encryption(attrs.encryption)
+	out, err := m.client.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.UploadId), nil
+}
+
+func (m *s3Multipart) UploadPart(ctx context.Context, key, uploadID string, number int, data []byte) (string, error) {
+	// S3 rejects parts corrupted in transit with a BadDigest error
+	sum := md5.Sum(data)
+	out, err := m.client.UploadPartWithContext(ctx, &s3.UploadPartInput{
+		Bucket:        aws.String(m.bucket),
+		Key:           aws.String(key),
+		UploadId:      aws.String(uploadID),
+		PartNumber:    aws.Int64(int64(number)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum[:])),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.ETag), nil
+}
+
+func (m *s3Multipart) CompleteMultipart(ctx context.Context, key, uploadID string, parts []completedPart) error {
+	cps := make([]*s3.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		cps = append(cps, &s3.CompletedPart{
+			ETag:       aws.String(p.ETag),
+			PartNumber: aws.Int64(int64(p.Number)),
+		})
+	}
+	_, err := m.client.CompleteMultipartUploadWithContext(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(m.bucket),
+		Key:             aws.String(key),
+		UploadId:        aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: cps},
+	})
+	return err
+}
+
+func (m *s3Multipart) AbortMultipart(ctx context.Context, key, uploadID string) error {
+	_, err := m.client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(m.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
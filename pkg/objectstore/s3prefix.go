@@ -0,0 +1,41 @@
+package objectstore
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ prefixLister = (*s3PrefixLister)(nil)
+
+// s3PrefixLister lists common prefixes with the S3 API
+type s3PrefixLister struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3PrefixLister(client *s3.S3, bucket string) *s3PrefixLister {
+	return &s3PrefixLister{client: client, bucket: bucket}
+}
+
+func (l *s3PrefixLister) ListPrefixes(ctx context.Context, prefix string) ([]string, []string, error) {
+	var prefixes, keys []string
+	err := l.client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(l.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	}, func(out *s3.ListObjectsV2Output, last bool) bool {
+		for _, p := range out.CommonPrefixes {
+			prefixes = append(prefixes, aws.StringValue(p.Prefix))
+		}
+		for _, o := range out.Contents {
+			keys = append(keys, aws.StringValue(o.Key))
+		}
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return prefixes, keys, nil
+}
@@ -0,0 +1,37 @@
+package objectstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ presigner = (*s3Presigner)(nil)
+
+// s3Presigner presigns URLs with signature version 4
+type s3Presigner struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3Presigner(client *s3.S3, bucket string) *s3Presigner {
+	return &s3Presigner{client: client, bucket: bucket}
+}
+
+func (p *s3Presigner) PresignGet(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, _ := p.client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(expiry)
+}
+
+func (p *s3Presigner) PresignPut(ctx context.Context, key string, expiry time.Duration) (string, error) {
+	req, _ := p.client.PutObjectRequest(&s3.PutObjectInput{
+		Bucket: aws.String(p.bucket),
+		Key:    aws.String(key),
+	})
+	return req.Presign(expiry)
+}
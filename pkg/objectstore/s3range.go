@@ -0,0 +1,68 @@
+package objectstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/pkg/errors"
+)
+
+var _ rangeReader = (*s3Ranger)(nil)
+
+// s3Ranger reads byte ranges of objects with the S3 API
+type s3Ranger struct {
+	client    *s3.S3
+	bucket    string
+	redaction NameRedaction
+}
+
+func newS3Ranger(client *s3.S3, bucket string, redaction NameRedaction) *s3Ranger {
+	return &s3Ranger{client: client, bucket: bucket, redaction: redaction}
+}
+
+func (r *s3Ranger) OpenRange(ctx context.Context, key string, offset, length int64, etag string) (io.ReadCloser, int64, error) {
+	rng := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		rng = fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+	}
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(rng),
+	}
+	if etag != "" {
+		in.IfMatch = aws.String(`"` + strings.Trim(etag, `"`) + `"`)
+	}
+	out, err := r.client.GetObjectWithContext(ctx, in)
+	if err != nil {
+		if rf, ok := err.(awserr.RequestFailure); ok {
+			switch rf.StatusCode() {
+			case 412:
+				return nil, 0, &ErrObjectChanged{Name: r.redaction.Redact(key)}
+			case 416:
+				// The size is in a header that the SDK does not expose
+				return nil, 0, &ErrRangeNotSatisfiable{Name: r.redaction.Redact(key), Offset: offset, Size: -1}
+			}
+		}
+		return nil, 0, errors.Wrapf(err, "failed to read object %s", r.redaction.Redact(key))
+	}
+	return out.Body, contentRangeSize(out, offset), nil
+}
+
+// contentRangeSize returns the size of the whole object from the
+// Content-Range of a ranged GET, e.g. "bytes 0-99/1234"
+func contentRangeSize(out *s3.GetObjectOutput, offset int64) int64 {
+	cr := aws.StringValue(out.ContentRange)
+	if i := strings.LastIndex(cr, "/"); i >= 0 {
+		if size, err := strconv.ParseInt(cr[i+1:], 10, 64); err == nil {
+			return size
+		}
+	}
+	return offset + aws.Int64Value(out.ContentLength)
+}
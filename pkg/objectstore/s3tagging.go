@@ -0,0 +1,54 @@
+package objectstore
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+var _ objectTagger = (*s3ObjectTagger)(nil)
+
+// s3ObjectTagger reads and writes object tags with the S3 tagging API
+type s3ObjectTagger struct {
+	client *s3.S3
+	bucket string
+}
+
+func newS3ObjectTagger(client *s3.S3, bucket string) *s3ObjectTagger {
+	return &s3ObjectTagger{client: client, bucket: bucket}
+}
+
+func (t *s3ObjectTagger) GetObjectTags(ctx context.Context, key string) (map[string]string, error) {
+	out, err := t.client.GetObjectTaggingWithContext(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string, len(out.TagSet))
+	for _, tag := range out.TagSet {
+		tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+	}
+	return tags, nil
+}
+
+func (t *s3ObjectTagger) PutObjectTags(ctx context.Context, key string, tags map[string]string) error {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	tagSet := make([]*s3.Tag, 0, len(keys))
+	for _, k := range keys {
+		tagSet = append(tagSet, &s3.Tag{Key: aws.String(k), Value: aws.String(tags[k])})
+	}
+	_, err := t.client.PutObjectTaggingWithContext(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(t.bucket),
+		Key:     aws.String(key),
+		Tagging: &s3.Tagging{TagSet: tagSet},
+	})
+	return err
+}
@@ -0,0 +1,173 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/pkg/errors"
+)
+
+var _ versioner = (*s3Versioner)(nil)
+
+// s3Versioner handles object versions with the S3 API, which stow does not
+// expose
+type s3Versioner struct {
+	client    *s3.S3
+	bucket    string
+	redaction NameRedaction
+
+	mu sync.Mutex
+	// checked is when the versioning status was last read
+	checked time.Time
+	status  error
+}
+
+// versioningStatusTTL is how long the versioning status of a bucket is
+// cached. Versioning can be enabled or suspended at any time.
+const versioningStatusTTL = time.Minute
+
+func newS3Versioner(client *s3.S3, bucket string, redaction NameRedaction) *s3Versioner {
+	return &s3Versioner{client: client, bucket: bucket, redaction: redaction}
+}
+
+// CheckEnabled checks that versioning is enabled on the bucket. The status
+// is cached for versioningStatusTTL.
+func (v *s3Versioner) CheckEnabled(ctx context.Context) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	now := clockFor(ctx, nil).Now()
+	if !v.checked.IsZero() && now.Before(v.checked.Add(versioningStatusTTL)) {
+		return v.status
+	}
+	out, err := v.client.GetBucketVersioningWithContext(ctx, &s3.GetBucketVersioningInput{
+		Bucket: aws.String(v.bucket),
+	})
+	if ae, ok := errors.Cause(err).(awserr.Error); ok && ae.Code() == "AccessDenied" {
+		// Buckets are written to without versions by credentials that
+		// cannot read their versioning status
+		v.status = &ErrVersioningNotSupported{Bucket: v.bucket, Reason: "versioning status is not readable"}
+		v.checked = now
+		return v.status
+	}
+	if err != nil {
+		// Not cached, the error may be transient
+		return errors.Wrapf(err, "failed to get versioning status of bucket %s", v.bucket)
+	}
+	v.status = nil
+	if aws.StringValue(out.Status) != s3.BucketVersioningStatusEnabled {
+		v.status = &ErrVersioningNotSupported{Bucket: v.bucket, Reason: "versioning is not enabled"}
+	}
+	v.checked = now
+	return v.status
+}
+
+func (v *s3Versioner) PutVersion(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string, attrs objectAttrs, opts PutOptions) (string, error) {
+	input := &s3manager.UploadInput{
+		Bucket:   aws.String(v.bucket),
+		Key:      aws.String(key),
+		Body:     r,
+		Metadata: aws.StringMap(metadata),
+	}
+	if attrs.contentType != "" {
+		input.ContentType = aws.String(attrs.contentType)
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId = s3Encryption(attrs.encryption)
+	// The uploader reads the data in parts, so that r need not be seekable
+	u := s3manager.NewUploaderWithClient(v.client, func(u *s3manager.Uploader) {
+		if opts.PartSize >= s3manager.MinUploadPartSize {
+			u.PartSize = opts.PartSize
+		}
+		if opts.Concurrency > 0 {
+			u.Concurrency = opts.Concurrency
+		}
+	})
+	out, err := u.UploadWithContext(ctx, input)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to put object %s", v.redaction.Redact(key))
+	}
+	// Objects written while versioning is suspended have the null version
+	if id := aws.StringValue(out.VersionID); id != "null" {
+		return id, nil
+	}
+	return "", nil
+}
+
+func (v *s3Versioner) OpenVersion(ctx context.Context, key, version string) (io.ReadCloser, map[string]string, error) {
+	if err := v.CheckEnabled(ctx); err != nil {
+		return nil, nil, err
+	}
+	out, err := v.client.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket:    aws.String(v.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to get version %s of object %s", version, v.redaction.Redact(key))
+	}
+	// Match the tags returned by stow, which lower cases the keys
+	tags := make(map[string]string, len(out.Metadata))
+	for k, val := range out.Metadata {
+		tags[strings.ToLower(k)] = aws.StringValue(val)
+	}
+	return out.Body, tags, nil
+}
+
+func (v *s3Versioner) ListVersions(ctx context.Context, key string) ([]ObjectVersion, error) {
+	if err := v.CheckEnabled(ctx); err != nil {
+		return nil, err
+	}
+	var versions []ObjectVersion
+	err := v.client.ListObjectVersionsPagesWithContext(ctx, &s3.ListObjectVersionsInput{
+		Bucket: aws.String(v.bucket),
+		Prefix: aws.String(key),
+	}, func(out *s3.ListObjectVersionsOutput, last bool) bool {
+		for _, ov := range out.Versions {
+			// Other objects can have key as a prefix
+			if aws.StringValue(ov.Key) != key {
+				continue
+			}
+			versions = append(versions, ObjectVersion{
+				ID:           aws.StringValue(ov.VersionId),
+				IsLatest:     aws.BoolValue(ov.IsLatest),
+				LastModified: aws.TimeValue(ov.LastModified),
+				Size:         aws.Int64Value(ov.Size),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list versions of object %s", v.redaction.Redact(key))
+	}
+	sortVersions(versions)
+	return versions, nil
+}
+
+func (v *s3Versioner) DeleteVersion(ctx context.Context, key, version string) error {
+	if err := v.CheckEnabled(ctx); err != nil {
+		return err
+	}
+	_, err := v.client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket:    aws.String(v.bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(version),
+	})
+	return errors.Wrapf(err, "failed to delete version %s of object %s", version, v.redaction.Redact(key))
+}
+
+// sortVersions sorts versions latest first
+func sortVersions(versions []ObjectVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		if versions[i].IsLatest != versions[j].IsLatest {
+			return versions[i].IsLatest
+		}
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+}
@@ -0,0 +1,153 @@
+package objectstore
+
+// Coordinated shutdown, which drains the operations in flight instead of
+// killing them midway
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrShuttingDown is returned by operations that are started after
+// Shutdown, and by long-running operations that Shutdown stopped at a safe
+// boundary. Running the operation again after a restart completes it.
+type ErrShuttingDown struct{}
+
+func (e *ErrShuttingDown) Error() string {
+	return "object store is shutting down"
+}
+
+// IsShuttingDown returns true if the cause of err is an ErrShuttingDown
+func IsShuttingDown(err error) bool {
+	_, ok := errors.Cause(err).(*ErrShuttingDown)
+	return ok
+}
+
+// drain counts the operations in flight, and stops admitting operations
+// once shut down
+type drain struct {
+	mu       sync.Mutex
+	inFlight int
+	stopping chan struct{} // Closed by shutdown
+	idle     chan struct{} // Closed once stopping with no operations in flight
+}
+
+func newDrain() *drain {
+	return &drain{stopping: make(chan struct{}), idle: make(chan struct{})}
+}
+
+// processDrain drains the operations of all buckets, for Shutdown
+var processDrain = newDrain()
+
+func (dr *drain) stopped() bool {
+	select {
+	case <-dr.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+func (dr *drain) admit() error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if dr.stopped() {
+		return &ErrShuttingDown{}
+	}
+	dr.inFlight++
+	return nil
+}
+
+func (dr *drain) release() {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.inFlight--
+	if dr.inFlight == 0 && dr.stopped() {
+		close(dr.idle)
+	}
+}
+
+// shutdown stops admitting operations and waits for the operations in
+// flight until ctx is done
+func (dr *drain) shutdown(ctx context.Context) error {
+	dr.mu.Lock()
+	if !dr.stopped() {
+		close(dr.stopping)
+		if dr.inFlight == 0 {
+			close(dr.idle)
+		}
+	}
+	dr.mu.Unlock()
+	select {
+	case <-dr.idle:
+		return nil
+	case <-ctx.Done():
+		dr.mu.Lock()
+		defer dr.mu.Unlock()
+		return errors.Wrapf(ctx.Err(), "%d object store operations still in flight", dr.inFlight)
+	}
+}
+
+// Shutdown stops admitting operations on b, asks long-running operations to
+// stop at a safe boundary, and waits for the operations in flight until ctx
+// is done
+func (b *bucket) Shutdown(ctx context.Context) error {
+	return b.drain.shutdown(ctx)
+}
+
+// Shutdown shuts down all the buckets of the process like Bucket.Shutdown.
+// It is called by signal handlers before the process exits.
+func Shutdown(ctx context.Context) error {
+	return processDrain.shutdown(ctx)
+}
+
+type admittedKey struct{}
+
+// admit admits an operation on b, unless b or the process is shutting down.
+// The operations that the operation calls with the returned context are
+// admitted as part of it. release must be called once the operation
+// completes.
+func (b *bucket) admit(ctx context.Context) (context.Context, func(), error) {
+	if admitted, _ := ctx.Value(admittedKey{}).(*bucket); admitted == b {
+		return ctx, func() {}, nil
+	}
+	if err := processDrain.admit(); err != nil {
+		return nil, nil, err
+	}
+	if err := b.drain.admit(); err != nil {
+		processDrain.release()
+		return nil, nil, err
+	}
+	release := func() {
+		b.drain.release()
+		processDrain.release()
+	}
+	return context.WithValue(ctx, admittedKey{}, b), release, nil
+}
+
+// releasingReadCloser releases the admission of a read once the reader is
+// closed, so that Shutdown waits for the reads in progress. Close is safe
+// to call more than once.
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (r *releasingReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// stopping returns an ErrShuttingDown if long-running operations on b must
+// stop at the next safe boundary
+func (b *bucket) stopping() error {
+	if b.drain.stopped() || processDrain.stopped() {
+		return &ErrShuttingDown{}
+	}
+	return nil
+}
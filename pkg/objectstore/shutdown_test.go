@@ -0,0 +1,203 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/graymeta/stow"
+	. "gopkg.in/check.v1"
+)
+
+type ShutdownSuite struct{}
+
+var _ = Suite(&ShutdownSuite{})
+
+// blockingRemoveContainer blocks removals until unblock is closed, and signals
+// each removal that starts on started
+type blockingRemoveContainer struct {
+	*memContainer
+	started chan string
+	unblock chan struct{}
+}
+
+func (c *blockingRemoveContainer) RemoveItem(id string) error {
+	c.started <- id
+	<-c.unblock
+	return c.memContainer.RemoveItem(id)
+}
+
+func (s *ShutdownSuite) TestShutdownDuringDelete(c *C) {
+	defer newLeakChecker().check(c)
+	ctx := context.Background()
+	const n = 50
+	sc := &slowContainer{memContainer: newMemContainer("shutdown"), delay: time.Millisecond}
+	b := newBucket(ProviderConfig{PageSize: 5, DeleteConcurrency: 4}, sc, nil, "mem://")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	for i := 0; i < n; i++ {
+		c.Assert(d.PutBytes(ctx, fmt.Sprintf("obj%02d", i), []byte("data"), nil), IsNil)
+	}
+
+	type result struct {
+		deleted int
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		deleted, err := d.DeleteDirectoryCount(ctx)
+		done <- result{deleted, err}
+	}()
+	for {
+		sc.memContainer.mu.Lock()
+		remaining := len(sc.items)
+		sc.memContainer.mu.Unlock()
+		if remaining < n {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	sctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	c.Assert(b.Shutdown(sctx), IsNil)
+
+	// The delete stopped at a safe boundary, completed the removals that it
+	// had started, and recorded its progress
+	r := <-done
+	c.Assert(IsShuttingDown(r.err), Equals, true, Commentf("%v", r.err))
+	c.Assert(r.deleted > 0, Equals, true)
+	sc.memContainer.mu.Lock()
+	remaining := len(sc.items)
+	cursorItem := sc.items["dir/"+DeleteCursorName]
+	sc.memContainer.mu.Unlock()
+	c.Assert(remaining, Equals, n+2-r.deleted)
+	c.Assert(cursorItem, NotNil)
+	var cursor deleteCursor
+	c.Assert(json.Unmarshal(cursorItem.data, &cursor), IsNil)
+	c.Assert(cursor.Deleted, Equals, r.deleted)
+	c.Assert(cursor.After, Not(Equals), "")
+
+	// New operations are not admitted
+	err = d.DeleteDirectory(ctx)
+	c.Assert(IsShuttingDown(err), Equals, true)
+	c.Assert(d.PutBytes(ctx, "obj", nil, nil), ErrorMatches, "object store is shutting down")
+	_, _, err = d.GetBytes(ctx, "obj00")
+	c.Assert(IsShuttingDown(err), Equals, true)
+
+	// After a restart, deleting the directory again resumes after the
+	// cursor, and completes it
+	rc := &recordingRemoveContainer{memContainer: sc.memContainer}
+	b = newBucket(ProviderConfig{PageSize: 5}, rc, nil, "mem://")
+	d, err = b.GetDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	deleted, err := d.DeleteDirectoryCount(ctx)
+	c.Assert(err, IsNil)
+	c.Assert(deleted, Equals, n+1)
+	c.Assert(sc.memContainer.items, HasLen, 0)
+	for _, key := range rc.removed {
+		c.Assert(key > cursor.After || key == "dir/"+DeleteCursorName, Equals, true, Commentf("%s", key))
+	}
+}
+
+// recordingRemoveContainer records the keys that are removed
+type recordingRemoveContainer struct {
+	*memContainer
+	mu      sync.Mutex
+	removed []string
+}
+
+func (c *recordingRemoveContainer) RemoveItem(id string) error {
+	c.mu.Lock()
+	c.removed = append(c.removed, id)
+	c.mu.Unlock()
+	return c.memContainer.RemoveItem(id)
+}
+
+func (s *ShutdownSuite) TestShutdownDeadline(c *C) {
+	defer newLeakChecker().check(c)
+	ctx := context.Background()
+	bc := &blockingRemoveContainer{memContainer: newMemContainer("shutdown"), started: make(chan string, 1), unblock: make(chan struct{})}
+	b := newBucket(ProviderConfig{}, bc, nil, "mem://")
+	c.Assert(b.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Delete(ctx, "obj")
+	}()
+	<-bc.started
+
+	// Operations in flight are waited for until the deadline
+	sctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	err := b.Shutdown(sctx)
+	c.Assert(err, ErrorMatches, "1 object store operations still in flight: context deadline exceeded")
+
+	close(bc.unblock)
+	c.Assert(<-done, IsNil)
+	c.Assert(b.Shutdown(ctx), IsNil)
+	_, err = b.container.Item("obj")
+	c.Assert(err, Equals, stow.ErrNotFound)
+}
+
+func (s *ShutdownSuite) TestShutdownWaitsForReaders(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	c.Assert(d.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	id, err := d.PutVersioned(ctx, "versioned", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+
+	// Reads are in flight until their readers are closed
+	r, _, err := d.Get(ctx, "obj")
+	c.Assert(err, IsNil)
+	vr, _, err := d.GetVersion(ctx, "versioned", id)
+	c.Assert(err, IsNil)
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	c.Assert(b.Shutdown(tctx), ErrorMatches, "2 object store operations still in flight: context deadline exceeded")
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+	c.Assert(r.Close(), IsNil)
+	c.Assert(r.Close(), IsNil)
+	c.Assert(vr.Close(), IsNil)
+	c.Assert(b.Shutdown(ctx), IsNil)
+
+	// Versions are not read, listed or deleted once shut down
+	_, _, err = d.GetVersion(ctx, "versioned", id)
+	c.Assert(IsShuttingDown(err), Equals, true)
+	_, err = d.ListVersions(ctx, "versioned")
+	c.Assert(IsShuttingDown(err), Equals, true)
+	c.Assert(IsShuttingDown(d.DeleteVersion(ctx, "versioned", id)), Equals, true)
+}
+
+func (s *ShutdownSuite) TestProcessShutdown(c *C) {
+	defer func(dr *drain) { processDrain = dr }(processDrain)
+	processDrain = newDrain()
+	ctx := context.Background()
+	b, _ := newMemBucket("shutdown")
+	c.Assert(b.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+
+	// Operations admitted before the shutdown complete the operations they
+	// call
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	actx, release, err := b.admit(ctx)
+	c.Assert(err, IsNil)
+	tctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	c.Assert(Shutdown(tctx), ErrorMatches, "1 object store operations still in flight: context deadline exceeded")
+	c.Assert(b.Copy(actx, "obj", d, "copy"), IsNil)
+	release()
+
+	c.Assert(Shutdown(ctx), IsNil)
+	c.Assert(IsShuttingDown(b.Delete(ctx, "obj")), Equals, true)
+	other, _ := newMemBucket("other")
+	c.Assert(IsShuttingDown(other.PutBytes(ctx, "obj", nil, nil)), Equals, true)
+}
@@ -0,0 +1,232 @@
+package objectstore
+
+// Read-ahead spooling of object data to local storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const defaultSpoolMaxMemory = 64 * 1024 * 1024
+
+// SpoolOptions configures how object data is buffered locally before it is
+// handed to the consumer.
+type SpoolOptions struct {
+	// Dir is the local directory spool files are created in. If empty,
+	// data is spooled to memory.
+	Dir string
+	// MaxMemory bounds the size of an in-memory spool. Defaults to 64MiB.
+	MaxMemory int64
+	// If true, the data is not verified against the object checksum
+	// reported by the provider.
+	SkipChecksum bool
+	// ETag of the object read by Spool, against which the data is
+	// verified if it is an MD5 digest. GetSpooled uses the ETag reported
+	// by the provider.
+	ETag string
+}
+
+// Spool reads r to completion into local storage as described by opts and
+// returns a reader over the spooled data. r is closed before Spool returns,
+// so the lifetime of the underlying connection does not depend on how fast
+// the returned reader is consumed. size is the expected number of bytes, or
+// -1 if unknown. The returned reader must be closed to release the spool;
+// it is safe to close it more than once.
+func Spool(ctx context.Context, r io.ReadCloser, size int64, opts SpoolOptions) (io.ReadCloser, error) {
+	return spool(ctx, r, size, opts.ETag, opts)
+}
+
+func spool(ctx context.Context, r io.ReadCloser, size int64, etag string, opts SpoolOptions) (io.ReadCloser, error) {
+	// Release the provider connection as soon as the data is local
+	defer r.Close()
+
+	h := md5.New()
+	src := io.TeeReader(&ctxReader{ctx: ctx, r: r}, h)
+	var sr io.ReadCloser
+	var n int64
+	var err error
+	if opts.Dir == "" {
+		sr, n, err = spoolToMemory(src, size, opts.MaxMemory)
+	} else {
+		sr, n, err = spoolToFile(src, size, opts.Dir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySpool(n, size, h, etag, opts.SkipChecksum); err != nil {
+		_ = sr.Close()
+		return nil, err
+	}
+	return sr, nil
+}
+
+func spoolToMemory(r io.Reader, size, max int64) (io.ReadCloser, int64, error) {
+	if max <= 0 {
+		max = defaultSpoolMaxMemory
+	}
+	if size > max {
+		return nil, 0, errors.Errorf("object size %d exceeds the spool memory limit %d", size, max)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to spool data to memory")
+	}
+	if int64(len(data)) > max {
+		return nil, 0, errors.Errorf("data exceeds the spool memory limit %d", max)
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func spoolToFile(r io.Reader, size int64, dir string) (io.ReadCloser, int64, error) {
+	if dir == "" {
+		// The directory of ioutil.TempFile
+		dir = os.TempDir()
+	}
+	if size > 0 {
+		avail, err := freeSpace(dir)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to check available space in %s", dir)
+		}
+		if avail >= 0 && avail < size {
+			return nil, 0, errors.Errorf("insufficient space in %s to spool %d bytes, %d bytes available", dir, size, avail)
+		}
+	}
+	f, err := ioutil.TempFile(dir, "kanister-spool-")
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to create spool file")
+	}
+	// Unlink the file right away where the platform allows it, so that it is
+	// reclaimed even if the process exits without closing the reader.
+	sf := &spoolFile{File: f}
+	if err = os.Remove(f.Name()); err != nil {
+		sf.remove = true
+	}
+	n, err := io.Copy(f, r)
+	if err != nil {
+		_ = sf.Close()
+		return nil, 0, errors.Wrapf(err, "failed to spool data to %s", dir)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		_ = sf.Close()
+		return nil, 0, errors.Wrap(err, "failed to rewind spool file")
+	}
+	return sf, n, nil
+}
+
+func verifySpool(n, size int64, h hash.Hash, etag string, skipChecksum bool) error {
+	if size >= 0 && n != size {
+		return errors.Errorf("spooled %d bytes, expected %d", n, size)
+	}
+	if skipChecksum || !isMD5ETag(etag) {
+		return nil
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != strings.Trim(etag, `"`) {
+		return errors.Errorf("checksum mismatch for spooled data, expected %s, got %s", etag, sum)
+	}
+	return nil
+}
+
+// isMD5ETag returns true if the ETag is a plain MD5 digest of the object.
+// Multipart uploads and some providers use other ETag formats.
+func isMD5ETag(etag string) bool {
+	etag = strings.Trim(etag, `"`)
+	if len(etag) != 2*md5.Size {
+		return false
+	}
+	_, err := hex.DecodeString(etag)
+	return err == nil
+}
+
+// spoolFile removes the underlying file on Close if it could not be
+// unlinked when it was created. It can be closed more than once.
+type spoolFile struct {
+	*os.File
+	remove bool
+	closed bool
+}
+
+func (f *spoolFile) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	err := f.File.Close()
+	if f.remove {
+		if rerr := os.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// ctxReader fails reads once the context is done
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ctxReadCloser fails reads once the context is done, and closes the
+// underlying reader to interrupt a read that is in progress. Close stops
+// watching the context; it is safe to call more than once.
+type ctxReadCloser struct {
+	ctxReader
+	c        io.Closer
+	done     chan struct{}
+	stopOnce sync.Once
+	once     sync.Once
+	err      error
+}
+
+func newCtxReadCloser(ctx context.Context, rc io.ReadCloser) *ctxReadCloser {
+	r := &ctxReadCloser{
+		ctxReader: ctxReader{ctx: ctx, r: rc},
+		c:         rc,
+		done:      make(chan struct{}),
+	}
+	if ctx.Done() != nil {
+		go func() {
+			select {
+			case <-ctx.Done():
+				r.close()
+			case <-r.done:
+			}
+		}()
+	}
+	return r
+}
+
+func (r *ctxReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ctxReader.Read(p)
+	if err != nil && r.ctx.Err() != nil {
+		// The error of the closed reader is not meaningful
+		err = r.ctx.Err()
+	}
+	return n, err
+}
+
+func (r *ctxReadCloser) close() {
+	r.once.Do(func() { r.err = r.c.Close() })
+}
+
+func (r *ctxReadCloser) Close() error {
+	r.stopOnce.Do(func() { close(r.done) })
+	r.close()
+	return r.err
+}
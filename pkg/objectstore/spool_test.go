@@ -0,0 +1,134 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SpoolSuite struct{}
+
+var _ = Suite(&SpoolSuite{})
+
+// trackingReadCloser records when the provider side reader is closed
+type trackingReadCloser struct {
+	io.Reader
+	closed bool
+}
+
+func (r *trackingReadCloser) Close() error {
+	r.closed = true
+	return nil
+}
+
+// slowRead consumes r a few bytes at a time, as a slow restore process would
+func slowRead(c *C, r io.Reader) []byte {
+	var out bytes.Buffer
+	buf := make([]byte, 3)
+	for {
+		n, err := r.Read(buf)
+		out.Write(buf[:n])
+		if err == io.EOF {
+			return out.Bytes()
+		}
+		c.Assert(err, IsNil)
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func (s *SpoolSuite) TestSpoolReleasesSource(c *C) {
+	ctx := context.Background()
+	data := []byte("some data that is consumed slowly")
+	for _, opts := range []SpoolOptions{
+		{},
+		{Dir: c.MkDir()},
+	} {
+		src := &trackingReadCloser{Reader: bytes.NewReader(data)}
+		r, err := Spool(ctx, src, int64(len(data)), opts)
+		c.Assert(err, IsNil)
+		// The source must be closed before the consumer reads anything
+		c.Assert(src.closed, Equals, true)
+		c.Check(slowRead(c, r), DeepEquals, data)
+		c.Assert(r.Close(), IsNil)
+	}
+}
+
+func (s *SpoolSuite) TestSpoolFileCleanup(c *C) {
+	ctx := context.Background()
+	dir := c.MkDir()
+	data := []byte("spooled to disk")
+
+	r, err := Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), SpoolOptions{Dir: dir})
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	checkEmptyDir(c, dir)
+
+	// Checksum mismatch
+	_, err = Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), SpoolOptions{Dir: dir, ETag: `"0123456789abcdef0123456789abcdef"`})
+	c.Assert(err, ErrorMatches, "checksum mismatch .*")
+	checkEmptyDir(c, dir)
+	sum := md5.Sum(data)
+	r, err = Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), SpoolOptions{Dir: dir, ETag: hex.EncodeToString(sum[:])})
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+
+	// Size mismatch
+	_, err = Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)+1), SpoolOptions{Dir: dir})
+	c.Assert(err, NotNil)
+	checkEmptyDir(c, dir)
+
+	// Cancelled context
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = Spool(cctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), SpoolOptions{Dir: dir})
+	c.Assert(err, NotNil)
+	checkEmptyDir(c, dir)
+}
+
+func (s *SpoolSuite) TestSpoolMemoryLimit(c *C) {
+	ctx := context.Background()
+	data := []byte("0123456789")
+	_, err := Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), int64(len(data)), SpoolOptions{MaxMemory: 5})
+	c.Assert(err, NotNil)
+	// Unknown size is caught while reading
+	_, err = Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), -1, SpoolOptions{MaxMemory: 5})
+	c.Assert(err, NotNil)
+	r, err := Spool(ctx, ioutil.NopCloser(bytes.NewReader(data)), -1, SpoolOptions{MaxMemory: 10})
+	c.Assert(err, IsNil)
+	c.Check(slowRead(c, r), DeepEquals, data)
+}
+
+func (s *SpoolSuite) TestGetSpooled(c *C) {
+	ctx := context.Background()
+	b, cont := newMemBucket("spool")
+	data := []byte("object content")
+	tags := map[string]string{"key": "value"}
+	err := b.PutBytes(ctx, "obj", data, tags)
+	c.Assert(err, IsNil)
+
+	r, ntags, err := b.GetSpooled(ctx, "obj", SpoolOptions{Dir: c.MkDir()})
+	c.Assert(err, IsNil)
+	c.Check(ntags, DeepEquals, tags)
+	c.Check(slowRead(c, r), DeepEquals, data)
+	c.Assert(r.Close(), IsNil)
+
+	// Corrupt the stored checksum
+	cont.items["obj"].etag = "0123456789abcdef0123456789abcdef"
+	_, _, err = b.GetSpooled(ctx, "obj", SpoolOptions{})
+	c.Assert(err, NotNil)
+	r, _, err = b.GetSpooled(ctx, "obj", SpoolOptions{SkipChecksum: true})
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+}
+
+func checkEmptyDir(c *C, dir string) {
+	fis, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(fis, HasLen, 0)
+}
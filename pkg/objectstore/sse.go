@@ -0,0 +1,105 @@
+package objectstore
+
+// Server-side encryption of objects by the provider. Stow cannot set the
+// encryption of objects, so encrypted objects are written with the APIs of
+// the providers, like objects with a content type.
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/pkg/errors"
+)
+
+// SSEMode is a mode of server-side encryption
+type SSEMode string
+
+const (
+	// SSEAES256 encrypts objects with keys managed by the provider. GCS
+	// encrypts every object this way.
+	SSEAES256 SSEMode = "AES256"
+	// SSEKMS encrypts objects with a key of the KMS of the provider. On S3,
+	// the key is the ID or ARN of an AWS KMS key, and defaults to the AWS
+	// managed key. On GCS, the key is the resource name of a Cloud KMS key,
+	// which is required.
+	SSEKMS SSEMode = "aws:kms"
+)
+
+// ServerSideEncryption configures the encryption of objects by the
+// provider. The zero value leaves the encryption to the defaults of the
+// bucket. Encrypted objects are read like other objects.
+type ServerSideEncryption struct {
+	Mode SSEMode
+	// KMSKeyID is the key of the SSEKMS mode
+	KMSKeyID string
+}
+
+func (e ServerSideEncryption) validate() error {
+	switch e.Mode {
+	case "":
+		if e.KMSKeyID != "" {
+			return errors.New("a KMS key requires the server-side encryption mode aws:kms")
+		}
+	case SSEAES256:
+		if e.KMSKeyID != "" {
+			return errors.Errorf("a KMS key cannot be used with the server-side encryption mode %s", e.Mode)
+		}
+	case SSEKMS:
+	default:
+		return errors.Errorf("unknown server-side encryption mode %s", e.Mode)
+	}
+	return nil
+}
+
+// WithServerSideEncryption encrypts the object as described by sse, in
+// place of the ServerSideEncryption of the bucket. It requires the
+// ServerSideEncryption capability. Puts fail with an ErrOptionNotSupported
+// otherwise, even outside of strict mode, so that objects are never written
+// unencrypted.
+func WithServerSideEncryption(sse ServerSideEncryption) PutOption {
+	return putOptionFunc(func(s *putSettings) {
+		s.sse = &sse
+	})
+}
+
+// objectAttrs are the attributes of objects that stow cannot set, and that
+// the APIs of the providers set when they write objects
+type objectAttrs struct {
+	contentType string
+	encryption  ServerSideEncryption
+}
+
+// encryption returns the server-side encryption of a write, which is sse
+// if set, or that of b otherwise. It fails if the encryption is invalid, or
+// if it is set and the write is not supported, i.e. cannot be encrypted.
+func (b *bucket) encryption(sse *ServerSideEncryption, supported bool) (ServerSideEncryption, error) {
+	e := b.sse
+	if sse != nil {
+		e = *sse
+	}
+	if err := e.validate(); err != nil {
+		return ServerSideEncryption{}, err
+	}
+	if e.Mode != "" && !supported {
+		return ServerSideEncryption{}, &ErrOptionNotSupported{Option: "server-side encryption"}
+	}
+	return e, nil
+}
+
+// s3Encryption returns the S3 parameters of the encryption
+func s3Encryption(e ServerSideEncryption) (mode, keyID *string) {
+	if e.Mode != "" {
+		mode = aws.String(string(e.Mode))
+	}
+	if e.KMSKeyID != "" {
+		keyID = aws.String(e.KMSKeyID)
+	}
+	return mode, keyID
+}
+
+// gcsKMSKeyName returns the name of the Cloud KMS key of the encryption, or
+// "" for the keys managed by Google
+func gcsKMSKeyName(e ServerSideEncryption) (string, error) {
+	if e.Mode == SSEKMS && e.KMSKeyID == "" {
+		return "", errors.New("server-side encryption with aws:kms on GCS requires the name of a Cloud KMS key")
+	}
+	return e.KMSKeyID, nil
+}
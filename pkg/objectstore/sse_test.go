@@ -0,0 +1,112 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+
+	. "gopkg.in/check.v1"
+)
+
+type SSESuite struct{}
+
+var _ = Suite(&SSESuite{})
+
+func (s *SSESuite) TestValidate(c *C) {
+	for _, tc := range []struct {
+		sse ServerSideEncryption
+		err string
+	}{
+		{sse: ServerSideEncryption{}},
+		{sse: ServerSideEncryption{Mode: SSEAES256}},
+		{sse: ServerSideEncryption{Mode: SSEKMS}},
+		{sse: ServerSideEncryption{Mode: SSEKMS, KMSKeyID: "alias/backups"}},
+		{sse: ServerSideEncryption{Mode: "aws:kms:dsse"}, err: "unknown server-side encryption mode aws:kms:dsse"},
+		{sse: ServerSideEncryption{Mode: SSEAES256, KMSKeyID: "alias/backups"}, err: "a KMS key cannot be used .*"},
+		{sse: ServerSideEncryption{KMSKeyID: "alias/backups"}, err: "a KMS key requires .*"},
+	} {
+		err := tc.sse.validate()
+		if tc.err == "" {
+			c.Check(err, IsNil, Commentf("%+v", tc.sse))
+		} else {
+			c.Check(err, ErrorMatches, tc.err, Commentf("%+v", tc.sse))
+		}
+	}
+}
+
+func (s *SSESuite) TestPut(c *C) {
+	ctx := context.Background()
+	b, m := newMemMultipartBucket("sse")
+	kms := ServerSideEncryption{Mode: SSEKMS, KMSKeyID: "alias/backups"}
+	b.sse = kms
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+
+	// Small objects are uploaded in parts to be encrypted
+	c.Assert(d.PutBytes(ctx, "default", []byte("data"), nil), IsNil)
+	c.Assert(m.encryptions["dir/default"], Equals, kms)
+	aes := ServerSideEncryption{Mode: SSEAES256}
+	err = d.PutOpts(ctx, "aes", bytes.NewReader([]byte("data")), 4, nil, WithServerSideEncryption(aes))
+	c.Assert(err, IsNil)
+	c.Assert(m.encryptions["dir/aes"], Equals, aes)
+	data, _, err := d.GetBytes(ctx, "aes")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+
+	err = d.PutOpts(ctx, "invalid", bytes.NewReader([]byte("data")), 4, nil, WithServerSideEncryption(ServerSideEncryption{Mode: "none"}))
+	c.Assert(err, ErrorMatches, "unknown server-side encryption mode none")
+	_, ok := m.items["dir/invalid"]
+	c.Assert(ok, Equals, false)
+}
+
+func (s *SSESuite) TestNotSupported(c *C) {
+	ctx := context.Background()
+	b, mc := newMemBucket("unencrypted")
+	d, err := b.CreateDirectory(ctx, "dir")
+	c.Assert(err, IsNil)
+	aes := WithServerSideEncryption(ServerSideEncryption{Mode: SSEAES256})
+	err = d.PutOpts(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil, aes)
+	c.Assert(IsOptionNotSupported(err), Equals, true)
+
+	// Objects of buckets with an encryption are never written unencrypted
+	b.sse = ServerSideEncryption{Mode: SSEAES256}
+	c.Assert(IsOptionNotSupported(d.PutBytes(ctx, "obj", []byte("data"), nil)), Equals, true)
+	err = d.PutIfNotExists(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(IsOptionNotSupported(err), Equals, true)
+	c.Assert(mc.items, HasLen, 1)
+	_, ok := mc.items["dir/"]
+	c.Assert(ok, Equals, true)
+}
+
+func (s *SSESuite) TestS3(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	client := b.versioner.(*s3Versioner).client
+	b.sse = ServerSideEncryption{Mode: SSEKMS, KMSKeyID: "alias/backups"}
+	b.ifAbsentWriter = newS3IfAbsentWriter(client, "versioned", NameRedaction{})
+	b.copier = newS3Copier(client, "versioned", NameRedaction{})
+
+	_, err := b.PutVersioned(ctx, "versioned", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(b.PutIfNotExists(ctx, "lease", bytes.NewReader([]byte("data")), 4, nil), IsNil)
+	// Copies are encrypted with the encryption of the bucket, not that of
+	// the source
+	b.sse = ServerSideEncryption{Mode: SSEAES256}
+	f.objects["source"] = []fakeS3Version{{id: "v0", data: []byte("data")}}
+	mc := b.container.(*memContainer)
+	_, err = mc.Put("source", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(b.Copy(ctx, "source", b, "copy"), IsNil)
+
+	for key, want := range map[string][2]string{
+		"versioned": {"aws:kms", "alias/backups"},
+		"lease":     {"aws:kms", "alias/backups"},
+		"copy":      {"AES256", ""},
+	} {
+		vs := f.objects[key]
+		c.Assert(vs, HasLen, 1, Commentf("%s", key))
+		c.Check(vs[0].encryption.Get("x-amz-server-side-encryption"), Equals, want[0], Commentf("%s", key))
+		c.Check(vs[0].encryption.Get("x-amz-server-side-encryption-aws-kms-key-id"), Equals, want[1], Commentf("%s", key))
+	}
+}
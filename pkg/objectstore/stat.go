@@ -0,0 +1,149 @@
+package objectstore
+
+// Metadata of objects, read without their data
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/graymeta/stow"
+	"github.com/pkg/errors"
+)
+
+// OriginalSizeTag is the tag that records the size of the data of objects
+// that are compressed or encrypted, before they are, if it is known when
+// they are written
+const OriginalSizeTag = "kanister-original-size"
+
+// ObjectInfo describes an object
+type ObjectInfo struct {
+	// Name of the object, as passed to Stat, or relative to the listed
+	// directory
+	Name string
+	// Size of the object as stored by the provider
+	Size int64
+	// OriginalSize is the size of the data of the object, as read by Get.
+	// It differs from Size for objects that are compressed or encrypted,
+	// and is -1 if it is unknown, e.g. in listings, which do not read the
+	// tags of objects.
+	OriginalSize int64
+	LastModified time.Time
+	// ETag of the object, without quotes. It is the MD5 of the data of
+	// objects uploaded in one part.
+	ETag string
+	// ContentType of the object, if the provider reports it. It is empty
+	// in listings.
+	ContentType string
+	Tags        map[string]string
+}
+
+// ErrObjectNotFound is returned by Stat for objects that do not exist
+type ErrObjectNotFound struct {
+	Name string
+}
+
+func (e *ErrObjectNotFound) Error() string {
+	return fmt.Sprintf("object %s not found", e.Name)
+}
+
+// IsObjectNotFound returns true if the cause of err is an ErrObjectNotFound,
+// or the not found error of stow or a provider. Missing buckets are not
+// missing objects.
+func IsObjectNotFound(err error) bool {
+	if err == nil || IsBucketNotFound(err) {
+		return false
+	}
+	switch e := errors.Cause(err).(type) {
+	case *ErrObjectNotFound:
+		return true
+	case awserr.RequestFailure:
+		return e.StatusCode() == 404
+	case awserr.Error:
+		return notFoundCodes[e.Code()]
+	}
+	cause := errors.Cause(err)
+	return cause == stow.ErrNotFound || strings.Contains(cause.Error(), "googleapi: Error 404") || strings.Contains(cause.Error(), "BlobNotFound")
+}
+
+// Stat returns the size, original size, modification time, ETag, content
+// type and tags of the object <bucket>/<d.path>/name without reading its
+// data
+func (d *directory) Stat(ctx context.Context, name string) (ObjectInfo, error) {
+	if d.path == "" {
+		return ObjectInfo{}, errors.New("invalid entry")
+	}
+
+	objName, err := d.objectName(name)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	item, err := d.item(ctx, objName)
+	if err != nil {
+		if IsObjectNotFound(err) {
+			return ObjectInfo{}, &ErrObjectNotFound{Name: d.bucket.redaction.Redact(objName)}
+		}
+		return ObjectInfo{}, err
+	}
+	info, err := objectInfo(name, item)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	rTags, err := item.Metadata()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info.Tags = d.bucket.itemTags(rTags)
+	info.OriginalSize = originalSize(info.Size, info.Tags)
+	if r := d.bucket.attrsReader; r != nil {
+		attrs, err := r.ObjectAttrs(ctx, cloudName(objName))
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		info.ContentType = attrs.contentType
+	}
+	return info, nil
+}
+
+// originalSize returns the size of the data of an object of the stored size
+// and tags, or -1 if it is unknown
+func originalSize(size int64, tags map[string]string) int64 {
+	if tags[ContentEncodingTag] == "" && tags[EncryptionTag] == "" {
+		return size
+	}
+	if n, err := strconv.ParseInt(tags[OriginalSizeTag], 10, 64); err == nil && n >= 0 {
+		return n
+	}
+	return -1
+}
+
+// withOriginalSize returns a copy of tags that records the size of the data
+// of an object that is compressed or encrypted, or tags if the size is
+// unknown
+func withOriginalSize(tags map[string]string, size int64) map[string]string {
+	if size < 0 {
+		return tags
+	}
+	return withTag(tags, OriginalSizeTag, strconv.FormatInt(size, 10))
+}
+
+// objectInfo returns the ObjectInfo of the item, without its tags
+func objectInfo(name string, item stow.Item) (ObjectInfo, error) {
+	size, err := item.Size()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	// Providers that do not return these are not an error
+	modified, _ := item.LastMod()
+	etag, _ := item.ETag()
+	return ObjectInfo{
+		Name:         name,
+		Size:         size,
+		OriginalSize: -1,
+		LastModified: modified,
+		ETag:         strings.Trim(etag, `"`),
+	}, nil
+}
@@ -0,0 +1,137 @@
+package objectstore
+
+// Statistics of the transfers made with a context, such as the transfers of
+// a command
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// TransferStats counts the objects transferred with a context returned by
+// WithTransferStats, and the retries of their requests
+type TransferStats struct {
+	// Objects is the number of objects written, and of objects read to the
+	// end
+	Objects int64
+	// Bytes is the number of bytes of stored data written and read. Bytes
+	// of failed writes are not counted.
+	Bytes int64
+	// Retries is the number of requests retried, and of reads resumed,
+	// after transient errors such as throttling
+	Retries int64
+	// RetryWait is the time spent waiting before retries
+	RetryWait time.Duration
+}
+
+type transferStatsKey struct{}
+
+type transferCounter struct {
+	objects   int64
+	bytes     int64
+	retries   int64
+	retryWait int64
+}
+
+// WithTransferStats returns a context that counts the transfers made with
+// it, and a function that returns the counts so far
+func WithTransferStats(ctx context.Context) (context.Context, func() TransferStats) {
+	c := &transferCounter{}
+	return context.WithValue(ctx, transferStatsKey{}, c), c.stats
+}
+
+func (c *transferCounter) stats() TransferStats {
+	return TransferStats{
+		Objects:   atomic.LoadInt64(&c.objects),
+		Bytes:     atomic.LoadInt64(&c.bytes),
+		Retries:   atomic.LoadInt64(&c.retries),
+		RetryWait: time.Duration(atomic.LoadInt64(&c.retryWait)),
+	}
+}
+
+func transferCounterFromContext(ctx context.Context) *transferCounter {
+	c, _ := ctx.Value(transferStatsKey{}).(*transferCounter)
+	return c
+}
+
+// countRetry accounts for a retry that waits for wait
+func countRetry(ctx context.Context, wait time.Duration) {
+	if c := transferCounterFromContext(ctx); c != nil {
+		atomic.AddInt64(&c.retries, 1)
+		atomic.AddInt64(&c.retryWait, int64(wait))
+	}
+}
+
+// countUpload wraps r, the data of an upload, and returns a function that
+// accounts for the upload once it succeeded. Rewinding r for a retry
+// discounts the bytes read again, so r stays seekable if it was.
+func countUpload(ctx context.Context, r io.Reader) (io.Reader, func()) {
+	c := transferCounterFromContext(ctx)
+	if c == nil {
+		return r, func() {}
+	}
+	cr := &uploadCounter{r: r}
+	done := func() {
+		atomic.AddInt64(&c.objects, 1)
+		atomic.AddInt64(&c.bytes, cr.n)
+	}
+	s, ok := r.(io.Seeker)
+	if !ok {
+		return cr, done
+	}
+	start, _ := s.Seek(0, io.SeekCurrent)
+	return &seekingUploadCounter{uploadCounter: cr, s: s, start: start}, done
+}
+
+type uploadCounter struct {
+	r io.Reader
+	n int64
+}
+
+func (c *uploadCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type seekingUploadCounter struct {
+	*uploadCounter
+	s     io.Seeker
+	start int64
+}
+
+func (c *seekingUploadCounter) Seek(offset int64, whence int) (int64, error) {
+	pos, err := c.s.Seek(offset, whence)
+	if err == nil {
+		c.n = pos - c.start
+	}
+	return pos, err
+}
+
+// countDownload wraps rc, the reader of stored data, to account for the
+// bytes read and for the object once it is read to the end
+func countDownload(ctx context.Context, rc io.ReadCloser) io.ReadCloser {
+	c := transferCounterFromContext(ctx)
+	if c == nil {
+		return rc
+	}
+	return &downloadCounter{ReadCloser: rc, c: c}
+}
+
+type downloadCounter struct {
+	io.ReadCloser
+	c    *transferCounter
+	done bool
+}
+
+func (d *downloadCounter) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	atomic.AddInt64(&d.c.bytes, int64(n))
+	if err == io.EOF && !d.done {
+		d.done = true
+		atomic.AddInt64(&d.c.objects, 1)
+	}
+	return n, err
+}
@@ -0,0 +1,59 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type TransferStatsSuite struct{}
+
+var _ = Suite(&TransferStatsSuite{})
+
+func (s *TransferStatsSuite) TestTransferStats(c *C) {
+	b, fc := newFlakyBucket(io.ErrUnexpectedEOF)
+	ctx, stats := WithTransferStats(context.Background())
+	data := bytes.Repeat([]byte("data"), 100)
+
+	// The bytes of failed attempts are not counted
+	fc.failures["put"] = 2
+	c.Assert(b.PutBytes(ctx, "obj", data, nil), IsNil)
+	st := stats()
+	c.Assert(st.Objects, Equals, int64(1))
+	c.Assert(st.Bytes, Equals, int64(len(data)))
+	c.Assert(st.Retries, Equals, int64(2))
+	c.Assert(st.RetryWait > 0, Equals, true)
+
+	// Readers that cannot be rewound
+	c.Assert(b.Put(ctx, "stream", io.MultiReader(bytes.NewReader(data)), int64(len(data)), nil), IsNil)
+	fc.failures["item"] = 1
+	got, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(got, DeepEquals, data)
+	st = stats()
+	c.Assert(st.Objects, Equals, int64(3))
+	c.Assert(st.Bytes, Equals, int64(3*len(data)))
+	c.Assert(st.Retries, Equals, int64(3))
+
+	// Objects that are not read to the end only count their bytes
+	r, _, err := b.Get(ctx, "obj")
+	c.Assert(err, IsNil)
+	_, err = io.CopyN(ioutil.Discard, r, 10)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	st = stats()
+	c.Assert(st.Objects, Equals, int64(3))
+	c.Assert(st.Bytes, Equals, int64(3*len(data)+10))
+
+	// Failed uploads are not counted
+	fc.failures["put"] = 3
+	c.Assert(b.PutBytes(ctx, "failed", data, nil), NotNil)
+	c.Assert(stats().Objects, Equals, int64(3))
+
+	// Transfers made without the context are not counted
+	c.Assert(b.PutBytes(context.Background(), "other", data, nil), IsNil)
+	c.Assert(stats().Objects, Equals, int64(3))
+}
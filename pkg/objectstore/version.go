@@ -0,0 +1,193 @@
+package objectstore
+
+// Versions of objects in buckets with versioning enabled. Stow does not
+// expose versions, so they are handled by provider specific versioners.
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ObjectVersion describes a version of an object
+type ObjectVersion struct {
+	ID           string
+	IsLatest     bool
+	LastModified time.Time
+	Size         int64
+}
+
+// ErrVersioningNotSupported is returned by the operations on versions of
+// buckets that do not have versioning enabled, or whose provider does not
+// support versions
+type ErrVersioningNotSupported struct {
+	Bucket string
+	Reason string
+}
+
+func (e *ErrVersioningNotSupported) Error() string {
+	return fmt.Sprintf("versioning is not supported by bucket %s: %s", e.Bucket, e.Reason)
+}
+
+// IsVersioningNotSupported returns true if the cause of err is an
+// ErrVersioningNotSupported
+func IsVersioningNotSupported(err error) bool {
+	_, ok := errors.Cause(err).(*ErrVersioningNotSupported)
+	return ok
+}
+
+// versioner handles the versions of the objects of a bucket. Keys are names
+// in the container.
+type versioner interface {
+	// CheckEnabled fails with an ErrVersioningNotSupported if versioning
+	// is not enabled on the bucket
+	CheckEnabled(ctx context.Context) error
+	// PutVersion writes the object and returns the ID of its new version,
+	// or "" if the bucket does not version it
+	PutVersion(ctx context.Context, key string, r io.Reader, size int64, metadata map[string]string, attrs objectAttrs, opts PutOptions) (string, error)
+	OpenVersion(ctx context.Context, key, version string) (io.ReadCloser, map[string]string, error)
+	ListVersions(ctx context.Context, key string) ([]ObjectVersion, error)
+	DeleteVersion(ctx context.Context, key, version string) error
+}
+
+// versionerFor returns the versioner of the bucket, or an
+// ErrVersioningNotSupported if it has none
+func (b *bucket) versionerFor() (versioner, error) {
+	if b.versioner == nil {
+		return nil, &ErrVersioningNotSupported{Bucket: b.container.ID(), Reason: "provider does not expose object versions"}
+	}
+	return b.versioner, nil
+}
+
+// PutVersioned persists data from the Reader in the named object like
+// PutOpts does, and returns the ID of the new version. Objects of buckets
+// that are not known to be versioned have no ID.
+func (d *directory) PutVersioned(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) (string, error) {
+	var version string
+	err := d.PutOpts(ctx, name, r, size, tags, withVersionID(&version))
+	return version, err
+}
+
+// enabledVersioner returns the versioner of the bucket if versioning is
+// enabled on it. Buckets whose versioning status cannot be read, e.g. since
+// the provider does not implement it, are written to without versions.
+func (b *bucket) enabledVersioner(ctx context.Context) versioner {
+	if b.versioner == nil || b.versioner.CheckEnabled(ctx) != nil {
+		return nil
+	}
+	return b.versioner
+}
+
+// putVersion uploads the data of the object objName as a new version, and
+// returns its ID. Uploads of seekable data are retried like retryPut does.
+func putVersion(ctx context.Context, b *bucket, v versioner, objName string, r io.Reader, size int64, metadata map[string]string, attrs objectAttrs, opts PutOptions) (string, error) {
+	var version string
+	err := retryUpload(ctx, b, objName, r, func(r io.Reader) error {
+		var err error
+		r = reportProgress(ctx, b.redaction.Redact(objName), &ctxReader{ctx: ctx, r: r}, size)
+		version, err = v.PutVersion(ctx, cloudName(objName), r, size, metadata, attrs, opts)
+		return err
+	})
+	return version, err
+}
+
+// GetVersion returns the data and tags of a version of the named object.
+// Encrypted versions are decrypted, and compressed versions are
+// decompressed.
+func (d *directory) GetVersion(ctx context.Context, name, version string) (io.ReadCloser, map[string]string, error) {
+	if d.path == "" {
+		return nil, nil, errors.New("invalid entry")
+	}
+	v, err := d.bucket.versionerFor()
+	if err != nil {
+		return nil, nil, err
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The read is in flight until the reader is closed
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	r, sTags, err := v.OpenVersion(ctx, cloudName(objName), version)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	r = &releasingReadCloser{ReadCloser: r, release: release}
+	tags := d.bucket.decodeTags(sTags)
+	name = d.bucket.redaction.Redact(objName)
+	if r, err = decrypt(d.bucket, name, r, tags); err != nil {
+		return nil, nil, err
+	}
+	if r, err = decompress(name, r, tags); err != nil {
+		return nil, nil, err
+	}
+	return r, tags, nil
+}
+
+// ListVersions lists the versions of the named object, latest first
+func (d *directory) ListVersions(ctx context.Context, name string) ([]ObjectVersion, error) {
+	if d.path == "" {
+		return nil, errors.New("invalid entry")
+	}
+	v, err := d.bucket.versionerFor()
+	if err != nil {
+		return nil, err
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return v.ListVersions(ctx, cloudName(objName))
+}
+
+// DeleteVersion removes a version of the named object. Other versions are
+// kept.
+func (d *directory) DeleteVersion(ctx context.Context, name, version string) error {
+	if d.path == "" {
+		return errors.New("invalid entry")
+	}
+	v, err := d.bucket.versionerFor()
+	if err != nil {
+		return err
+	}
+	objName, err := d.objectName(name)
+	if err != nil {
+		return err
+	}
+	ctx, release, err := d.bucket.admit(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	defer d.bucket.listingCache.invalidate(objName)
+	return v.DeleteVersion(ctx, cloudName(objName), version)
+}
+
+// stringTags converts metadata to tags. Values that are not strings are
+// formatted with %v; nil values are dropped.
+func stringTags(tags map[string]interface{}) map[string]string {
+	s := make(map[string]string, len(tags))
+	for k, v := range tags {
+		switch sv := v.(type) {
+		case string:
+			s[k] = sv
+		case nil:
+		default:
+			// Some providers report numeric or boolean metadata
+			s[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return s
+}
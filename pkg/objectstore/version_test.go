@@ -0,0 +1,414 @@
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type VersionSuite struct{}
+
+var _ = Suite(&VersionSuite{})
+
+// fakeS3 implements the parts of the S3 API used by s3Versioner, s3Ranger
+// and s3Copier
+type fakeS3 struct {
+	mu         sync.Mutex
+	versioning string
+	// versioningReads counts the reads of the versioning status
+	versioningReads int
+	next            int
+	now             time.Time
+	objects         map[string][]fakeS3Version // Latest last
+}
+
+type fakeS3Version struct {
+	id       string
+	data     []byte
+	metadata http.Header
+	modified time.Time
+	// encryption holds the server-side encryption headers of the request
+	encryption  http.Header
+	contentType string
+	// tagging holds the object tags set with the tagging API
+	tagging map[string]string
+}
+
+const (
+	// versioningDenied denies the reads of the versioning status of a
+	// fakeS3
+	versioningDenied = "denied"
+	// versioningNotImplemented fails the reads of the versioning status
+	// of a fakeS3 like S3 compatible stores without versioning do
+	versioningNotImplemented = "notimplemented"
+)
+
+func newFakeS3(versioning string) *fakeS3 {
+	return &fakeS3{
+		versioning: versioning,
+		now:        time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC),
+		objects:    make(map[string][]fakeS3Version),
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// Path style requests: /<bucket>[/<key>]
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	q := r.URL.Query()
+	if len(parts) == 1 {
+		if hasQuery(r, "versioning") {
+			f.versioningReads++
+		}
+		switch {
+		case hasQuery(r, "versioning") && f.versioning == versioningDenied:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+		case hasQuery(r, "versioning") && f.versioning == versioningNotImplemented:
+			w.WriteHeader(http.StatusNotImplemented)
+			fmt.Fprint(w, `<Error><Code>NotImplemented</Code><Message>A header you provided implies functionality that is not implemented</Message></Error>`)
+		case hasQuery(r, "versioning"):
+			fmt.Fprintf(w, `<VersioningConfiguration><Status>%s</Status></VersioningConfiguration>`, f.versioning)
+		case hasQuery(r, "versions"):
+			f.listVersions(w, q.Get("prefix"))
+		default:
+			http.Error(w, "unsupported", http.StatusNotImplemented)
+		}
+		return
+	}
+	key := parts[1]
+	if hasQuery(r, "tagging") {
+		f.serveTagging(w, r, key)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		f.next++
+		f.now = f.now.Add(time.Minute)
+		v := fakeS3Version{id: fmt.Sprintf("v%d", f.next), data: data, metadata: http.Header{}, modified: f.now, encryption: http.Header{}, contentType: r.Header.Get("Content-Type")}
+		for k, val := range r.Header {
+			switch lk := strings.ToLower(k); {
+			case strings.HasPrefix(lk, "x-amz-meta-"):
+				v.metadata[k] = val
+			case strings.HasPrefix(lk, "x-amz-server-side-encryption"):
+				v.encryption[k] = val
+			}
+		}
+		if source := r.Header.Get("x-amz-copy-source"); source != "" {
+			// Copies keep the data and metadata of the source
+			source, _ = url.PathUnescape(source)
+			vs := f.objects[strings.SplitN(strings.TrimPrefix(source, "/"), "/", 2)[1]]
+			if len(vs) == 0 {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+				return
+			}
+			v.data, v.metadata = vs[len(vs)-1].data, vs[len(vs)-1].metadata
+			defer fmt.Fprint(w, `<CopyObjectResult><ETag>"etag"</ETag></CopyObjectResult>`)
+		}
+		f.objects[key] = append(f.objects[key], v)
+		if f.versioning != "Enabled" {
+			v.id = "null"
+		}
+		w.Header().Set("x-amz-version-id", v.id)
+		w.Header().Set("ETag", `"etag"`)
+	case http.MethodGet, http.MethodHead:
+		if vs := f.objects[key]; len(vs) > 0 && !hasQuery(r, "versionId") {
+			// The latest version, with support for ranges and conditions
+			v := vs[len(vs)-1]
+			data := v.data
+			for k, val := range v.encryption {
+				w.Header()[k] = val
+			}
+			if v.contentType != "" {
+				w.Header().Set("Content-Type", v.contentType)
+			}
+			w.Header().Set("ETag", fmt.Sprintf(`"%x"`, md5.Sum(data)))
+			http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(data))
+			return
+		}
+		for _, v := range f.objects[key] {
+			if v.id == q.Get("versionId") {
+				for k, val := range v.metadata {
+					w.Header()[k] = val
+				}
+				w.Write(v.data)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchVersion</Code></Error>`)
+	case http.MethodDelete:
+		vs := f.objects[key]
+		for i, v := range vs {
+			if v.id == q.Get("versionId") {
+				f.objects[key] = append(vs[:i:i], vs[i+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type fakeS3Tag struct {
+	Key   string
+	Value string
+}
+
+// fakeS3Tagging is the body of the tagging requests
+type fakeS3Tagging struct {
+	XMLName xml.Name    `xml:"Tagging"`
+	Tags    []fakeS3Tag `xml:"TagSet>Tag"`
+}
+
+// serveTagging serves the tagging requests of the latest version of key
+func (f *fakeS3) serveTagging(w http.ResponseWriter, r *http.Request, key string) {
+	vs := f.objects[key]
+	if len(vs) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `<Error><Code>NoSuchKey</Code></Error>`)
+		return
+	}
+	v := &vs[len(vs)-1]
+	var t fakeS3Tagging
+	if r.Method == http.MethodPut {
+		if err := xml.NewDecoder(r.Body).Decode(&t); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		v.tagging = make(map[string]string, len(t.Tags))
+		for _, tag := range t.Tags {
+			v.tagging[tag.Key] = tag.Value
+		}
+		return
+	}
+	for k, val := range v.tagging {
+		t.Tags = append(t.Tags, fakeS3Tag{Key: k, Value: val})
+	}
+	_ = xml.NewEncoder(w).Encode(t)
+}
+
+func hasQuery(r *http.Request, name string) bool {
+	_, ok := r.URL.Query()[name]
+	return ok
+}
+
+func (f *fakeS3) listVersions(w http.ResponseWriter, prefix string) {
+	type version struct {
+		Key          string
+		VersionId    string
+		IsLatest     bool
+		LastModified time.Time
+		Size         int64
+	}
+	res := struct {
+		XMLName  xml.Name  `xml:"ListVersionsResult"`
+		Versions []version `xml:"Version"`
+	}{}
+	for key, vs := range f.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for i, v := range vs {
+			res.Versions = append(res.Versions, version{key, v.id, i == len(vs)-1, v.modified, int64(len(v.data))})
+		}
+	}
+	xml.NewEncoder(w).Encode(res)
+}
+
+func newFakeS3Bucket(c *C, f *fakeS3) (*bucket, func()) {
+	srv := httptest.NewServer(f)
+	client, err := newS3Client(ProviderConfig{Endpoint: srv.URL}, &Secret{
+		Type: SecretTypeAwsAccessKey,
+		Aws:  &SecretAws{AccessKeyID: "id", SecretAccessKey: "secret"},
+	}, "")
+	c.Assert(err, IsNil)
+	b, _ := newMemBucket("versioned")
+	b.versioner = newS3Versioner(client, "versioned", NameRedaction{})
+	return b, srv.Close
+}
+
+func (s *VersionSuite) TestVersions(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	d, err := b.CreateDirectory(ctx, "manifests")
+	c.Assert(err, IsNil)
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		data := []byte(fmt.Sprintf("generation %d", i))
+		id, err := d.PutVersioned(ctx, "manifest", bytes.NewReader(data), int64(len(data)), map[string]string{"Generation": fmt.Sprint(i), "app/name": "été"})
+		c.Assert(err, IsNil)
+		ids = append(ids, id)
+	}
+	c.Assert(ids, DeepEquals, []string{"v1", "v2", "v3"})
+	// The directory marker is written with Put
+	c.Assert(f.objects["manifests/"], HasLen, 0)
+	// Objects with the name as a prefix are not versions
+	_, err = d.PutVersioned(ctx, "manifest2", bytes.NewReader(nil), 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(f.objects["manifests/manifest"], HasLen, 3)
+
+	versions, err := d.ListVersions(ctx, "manifest")
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 3)
+	c.Assert(versions[0].ID, Equals, "v3")
+	c.Assert(versions[0].IsLatest, Equals, true)
+	c.Assert(versions[1].ID, Equals, "v2")
+	c.Assert(versions[2].ID, Equals, "v1")
+	c.Assert(versions[2].Size, Equals, int64(len("generation 0")))
+
+	r, tags, err := d.GetVersion(ctx, "manifest", "v2")
+	c.Assert(err, IsNil)
+	data, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(r.Close(), IsNil)
+	c.Assert(string(data), Equals, "generation 1")
+	c.Assert(tags, DeepEquals, map[string]string{"generation": "1", "app/name": "été"})
+
+	c.Assert(d.DeleteVersion(ctx, "manifest", "v1"), IsNil)
+	versions, err = d.ListVersions(ctx, "manifest")
+	c.Assert(err, IsNil)
+	c.Assert(versions, HasLen, 2)
+	_, _, err = d.GetVersion(ctx, "manifest", "v1")
+	c.Assert(err, NotNil)
+
+	// Put does not read the versioning status, the provider versions the
+	// objects it writes
+	reads := f.versioningReads
+	c.Assert(d.PutBytes(ctx, "plain", []byte("data"), nil), IsNil)
+	c.Assert(f.versioningReads, Equals, reads)
+}
+
+func (s *VersionSuite) TestVersioningStatusExpires(c *C) {
+	clk := newFakeClock()
+	ctx := withClock(context.Background(), clk)
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	id, err := b.PutVersioned(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "v1")
+
+	// The status is cached until it expires. Versions written while
+	// versioning is suspended have no ID.
+	f.versioning = "Suspended"
+	id, err = b.PutVersioned(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "")
+	c.Assert(f.versioningReads, Equals, 1)
+	clk.Advance(versioningStatusTTL)
+	id, err = b.PutVersioned(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "")
+	c.Assert(f.versioningReads, Equals, 2)
+	c.Assert(f.objects["obj"], HasLen, 2)
+}
+
+func (s *VersionSuite) TestVersioningNotImplemented(c *C) {
+	ctx := context.Background()
+	f := newFakeS3(versioningNotImplemented)
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	c.Assert(b.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	c.Assert(f.versioningReads, Equals, 0)
+	// Versioned writes fall back to plain writes
+	id, err := b.PutVersioned(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "")
+	c.Assert(f.objects, HasLen, 0)
+	data, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+}
+
+func (s *VersionSuite) TestVersionCodecs(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Enabled")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	b.compression = CodecGzip
+	data := bytes.Repeat([]byte("manifest "), 100)
+	ids := make(map[string]string)
+	id, err := b.PutVersioned(ctx, "compressed", bytes.NewReader(data), int64(len(data)), nil)
+	c.Assert(err, IsNil)
+	ids["compressed"] = id
+	c.Assert(gunzip(c, f.objects["compressed"][0].data), DeepEquals, data)
+	b.keyring = newTestKeyring(c, "a", "a")
+	id, err = b.PutVersioned(ctx, "encrypted", bytes.NewReader(data), int64(len(data)), nil)
+	c.Assert(err, IsNil)
+	ids["encrypted"] = id
+	c.Assert(bytes.Contains(f.objects["encrypted"][0].data, []byte("manifest")), Equals, false)
+
+	for name, id := range ids {
+		r, tags, err := b.GetVersion(ctx, name, id)
+		c.Assert(err, IsNil)
+		got, err := ioutil.ReadAll(r)
+		c.Assert(err, IsNil)
+		c.Assert(r.Close(), IsNil)
+		c.Assert(got, DeepEquals, data, Commentf("%s", name))
+		c.Assert(tags[ContentEncodingTag], Equals, CodecGzip)
+		c.Assert(tags[OriginalSizeTag], Equals, fmt.Sprint(len(data)))
+	}
+}
+
+func (s *VersionSuite) TestVersioningNotEnabled(c *C) {
+	ctx := context.Background()
+	f := newFakeS3("Suspended")
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	// Objects are written without versions
+	id, err := b.PutVersioned(ctx, "obj", bytes.NewReader([]byte("data")), 4, nil)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "")
+	c.Assert(f.objects, HasLen, 0)
+	data, _, err := b.GetBytes(ctx, "obj")
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "data")
+	_, err = b.ListVersions(ctx, "obj")
+	c.Assert(IsVersioningNotSupported(err), Equals, true)
+	c.Assert(err, ErrorMatches, "versioning is not supported by bucket versioned: versioning is not enabled")
+}
+
+func (s *VersionSuite) TestVersioningNotReadable(c *C) {
+	ctx := context.Background()
+	f := newFakeS3(versioningDenied)
+	b, done := newFakeS3Bucket(c, f)
+	defer done()
+	c.Assert(b.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+	c.Assert(f.objects, HasLen, 0)
+	_, err := b.ListVersions(ctx, "obj")
+	c.Assert(IsVersioningNotSupported(err), Equals, true)
+	c.Assert(err, ErrorMatches, "versioning is not supported by bucket versioned: versioning status is not readable")
+}
+
+func (s *VersionSuite) TestVersioningNotSupported(c *C) {
+	ctx := context.Background()
+	b, _ := newMemBucket("plain")
+	id, err := b.PutVersioned(ctx, "obj", bytes.NewReader(nil), 0, nil)
+	c.Assert(err, IsNil)
+	c.Assert(id, Equals, "")
+	_, _, err = b.GetVersion(ctx, "obj", "v1")
+	c.Assert(IsVersioningNotSupported(err), Equals, true)
+	_, err = b.ListVersions(ctx, "obj")
+	c.Assert(IsVersioningNotSupported(err), Equals, true)
+	err = b.DeleteVersion(ctx, "obj", "v1")
+	c.Assert(IsVersioningNotSupported(err), Equals, true)
+	// Unversioned operations still work
+	c.Assert(b.PutBytes(ctx, "obj", []byte("data"), nil), IsNil)
+}
@@ -0,0 +1,298 @@
+package output
+
+// Outputs can also be archived in object storage, so that they can be
+// retrieved after the logs of the phase have aged out.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+// ArchiveName is the name of the object in which the outputs are archived,
+// under the prefix of the archive
+const ArchiveName = "outputs.json"
+
+// archivePartsDir is the directory, under the prefix of the archive, in
+// which outputs appended by separate processes are archived, one object per
+// output
+const archivePartsDir = "outputs"
+
+// Archive is the record of the outputs of a phase written by an
+// ArchivingSink
+type Archive struct {
+	// Outputs in the order in which they were printed
+	Outputs []Output `json:"outputs"`
+	// Complete is true once the phase has completed
+	Complete bool `json:"complete"`
+	// Error is the error that the phase failed with, if any
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Values returns the outputs by key, as the log parser of the phase
+// returns them. Later outputs replace earlier outputs with the same key.
+func (a *Archive) Values() map[string]interface{} {
+	if len(a.Outputs) == 0 {
+		return nil
+	}
+	values := make(map[string]interface{}, len(a.Outputs))
+	for _, o := range a.Outputs {
+		values[o.Key] = o.Value
+	}
+	return values
+}
+
+// ArchivingSink prints outputs like PrintOutput, and archives them in the
+// object ArchiveName under a prefix of a directory when flushed, or each in
+// an object of its own when appended
+type ArchivingSink struct {
+	d       objectstore.Directory
+	prefix  string
+	w       io.Writer
+	seqPath string
+	now     func() time.Time
+
+	mu      sync.Mutex
+	archive Archive
+	dirty   bool
+	// nextPart is the index of the object of the next appended output, or
+	// zero until the appended outputs have been listed
+	nextPart int
+}
+
+// NewArchivingSink returns a sink that archives outputs in d under prefix
+func NewArchivingSink(d objectstore.Directory, prefix string) *ArchivingSink {
+	return &ArchivingSink{d: d, prefix: prefix, w: os.Stdout, seqPath: seqPath(), now: time.Now}
+}
+
+// ArchiveRef returns the reference of the archive under prefix, which is
+// the path of its object
+func ArchiveRef(prefix string) string {
+	return path.Join(prefix, ArchiveName)
+}
+
+// archivePartRef returns the path of the object of the nth appended output
+// of the archive under prefix
+func archivePartRef(prefix string, n int) string {
+	return path.Join(prefix, archivePartsDir, fmt.Sprintf("%06d.json", n))
+}
+
+// listArchiveParts returns the indexes of the appended outputs of the
+// archive under prefix, in order
+func listArchiveParts(ctx context.Context, d objectstore.Directory, prefix string) ([]int, error) {
+	dir := path.Join(prefix, archivePartsDir) + "/"
+	names, err := d.ListObjectsWithPrefix(ctx, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to list output archive %s", dir)
+	}
+	parts := make([]int, 0, len(names))
+	for _, name := range names {
+		// Names may be listed with or without a leading slash
+		base := strings.TrimPrefix(strings.TrimPrefix(name, "/"), strings.TrimPrefix(dir, "/"))
+		n, err := strconv.Atoi(strings.TrimSuffix(base, ".json"))
+		if err != nil || n < 1 {
+			continue
+		}
+		parts = append(parts, n)
+	}
+	sort.Ints(parts)
+	return parts, nil
+}
+
+// Load adds the outputs already flushed under the prefix to the record, so
+// that the outputs printed by separate processes are archived together.
+// Appended outputs are not loaded, since they are archived on their own.
+func (s *ArchivingSink) Load(ctx context.Context) error {
+	a, err := readArchive(ctx, s.d, ArchiveRef(s.prefix))
+	switch {
+	case objectstore.IsObjectNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.archive.Outputs = append(a.Outputs, s.archive.Outputs...)
+	return nil
+}
+
+// PrintOutput prints the output like PrintOutput, and adds it to the record
+func (s *ArchivingSink) PrintOutput(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, err := printOutput(s.w, s.seqPath, key, value)
+	if err != nil {
+		return err
+	}
+	s.archive.Outputs = append(s.archive.Outputs, *out)
+	s.dirty = true
+	return nil
+}
+
+// Append prints the output like PrintOutput, and archives it at once in an
+// object of its own, which is created after those of the outputs appended
+// before. Outputs appended concurrently by separate processes are therefore
+// all archived, in the order in which they were written. They are read after
+// the flushed outputs.
+func (s *ArchivingSink) Append(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out, err := printOutput(s.w, s.seqPath, key, value)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(out)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal output")
+	}
+	if s.nextPart == 0 {
+		parts, err := listArchiveParts(ctx, s.d, s.prefix)
+		if err != nil {
+			return err
+		}
+		s.nextPart = 1
+		if len(parts) != 0 {
+			s.nextPart = parts[len(parts)-1] + 1
+		}
+	}
+	// Indexes taken by other processes since are skipped
+	for ; ; s.nextPart++ {
+		ref := archivePartRef(s.prefix, s.nextPart)
+		err := s.d.PutIfNotExists(ctx, ref, bytes.NewReader(data), int64(len(data)), nil)
+		switch {
+		case objectstore.IsObjectExists(err):
+			continue
+		case err != nil:
+			return errors.Wrapf(err, "Failed to write output archive %s", ref)
+		}
+		s.nextPart++
+		return nil
+	}
+}
+
+// Flush archives the outputs recorded so far, if any were added since the
+// last flush
+func (s *ArchivingSink) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	return s.flush(ctx)
+}
+
+// Complete archives the outputs, and marks the archive complete with the
+// error that the phase failed with, if any
+func (s *ArchivingSink) Complete(ctx context.Context, phaseErr error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.archive.Complete = true
+	if phaseErr != nil {
+		s.archive.Error = phaseErr.Error()
+	}
+	return s.flush(ctx)
+}
+
+func (s *ArchivingSink) flush(ctx context.Context) error {
+	s.archive.UpdatedAt = s.now().UTC()
+	data, err := json.Marshal(s.archive)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal output archive")
+	}
+	if err := s.d.PutBytes(ctx, ArchiveRef(s.prefix), data, nil); err != nil {
+		return errors.Wrapf(err, "Failed to write output archive %s", ArchiveRef(s.prefix))
+	}
+	s.dirty = false
+	return nil
+}
+
+// FlushEvery flushes the sink every interval until stop is called or ctx
+// is done. Flush errors are retried at the next interval. stop returns once
+// the flushes have stopped.
+func (s *ArchivingSink) FlushEvery(ctx context.Context, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				_ = s.Flush(ctx)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// ReadArchive reads the archive referred to by ref in d. ref is the prefix
+// under which the outputs were archived, or the path returned by
+// ArchiveRef. Appended outputs follow the flushed outputs.
+func ReadArchive(ctx context.Context, d objectstore.Directory, ref string) (*Archive, error) {
+	if path.Base(ref) != ArchiveName {
+		ref = ArchiveRef(ref)
+	}
+	a, err := readArchive(ctx, d, ref)
+	notFound := objectstore.IsObjectNotFound(err)
+	if err != nil && !notFound {
+		return nil, err
+	}
+	indexes, perr := listArchiveParts(ctx, d, path.Dir(ref))
+	if perr != nil {
+		return nil, perr
+	}
+	var parts []Output
+	for _, n := range indexes {
+		pref := archivePartRef(path.Dir(ref), n)
+		data, _, perr := d.GetBytes(ctx, pref)
+		if perr != nil {
+			return nil, errors.Wrapf(perr, "Failed to read output archive %s", pref)
+		}
+		var out Output
+		if perr := json.Unmarshal(data, &out); perr != nil {
+			return nil, errors.Wrapf(perr, "Failed to unmarshal output archive %s", pref)
+		}
+		parts = append(parts, out)
+	}
+	if notFound {
+		if len(parts) == 0 {
+			return nil, err
+		}
+		a = &Archive{}
+	}
+	a.Outputs = append(a.Outputs, parts...)
+	return a, nil
+}
+
+// readArchive reads the flushed outputs of the archive at ref
+func readArchive(ctx context.Context, d objectstore.Directory, ref string) (*Archive, error) {
+	data, _, err := d.GetBytes(ctx, ref)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read output archive %s", ref)
+	}
+	a := &Archive{}
+	if err := json.Unmarshal(data, a); err != nil {
+		return nil, errors.Wrapf(err, "Failed to unmarshal output archive %s", ref)
+	}
+	return a, nil
+}
@@ -0,0 +1,232 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	. "gopkg.in/check.v1"
+
+	"github.com/kanisterio/kanister/pkg/objectstore"
+)
+
+type ArchiveSuite struct{}
+
+var _ = Suite(&ArchiveSuite{})
+
+// memDirectory implements the parts of objectstore.Directory used by
+// ArchivingSink and ReadArchive
+type memDirectory struct {
+	objectstore.Directory
+	mu      sync.Mutex
+	objects map[string][]byte
+	lists   int
+}
+
+func newMemDirectory() *memDirectory {
+	return &memDirectory{objects: make(map[string][]byte)}
+}
+
+func (d *memDirectory) PutBytes(ctx context.Context, name string, data []byte, tags map[string]string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.objects[name] = append([]byte(nil), data...)
+	return nil
+}
+
+func (d *memDirectory) PutIfNotExists(ctx context.Context, name string, r io.Reader, size int64, tags map[string]string) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.objects[name]; ok {
+		return &objectstore.ErrObjectExists{Name: name}
+	}
+	d.objects[name] = data
+	return nil
+}
+
+func (d *memDirectory) GetBytes(ctx context.Context, name string) ([]byte, map[string]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	data, ok := d.objects[name]
+	if !ok {
+		return nil, nil, &objectstore.ErrObjectNotFound{Name: name}
+	}
+	return data, nil, nil
+}
+
+func (d *memDirectory) ListObjectsWithPrefix(ctx context.Context, prefix string) ([]string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lists++
+	var names []string
+	for name := range d.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// parseLog returns the outputs of a log like the log parser of KubeExec
+func parseLog(c *C, log string) map[string]interface{} {
+	var values map[string]interface{}
+	s := NewScanner(bytes.NewBufferString(log))
+	for s.Scan() {
+		if values == nil {
+			values = make(map[string]interface{})
+		}
+		values[s.Output().Key] = s.Output().Value
+	}
+	c.Assert(s.Err(), IsNil)
+	return values
+}
+
+func (s *ArchiveSuite) TestArchivingSink(c *C) {
+	ctx := context.Background()
+	d := newMemDirectory()
+	var log bytes.Buffer
+	seqPath := filepath.Join(c.MkDir(), "seq")
+	sink := NewArchivingSink(d, "backups/phase1")
+	sink.w = &log
+	sink.seqPath = seqPath
+
+	c.Assert(sink.PrintOutput("version", "0.14.0"), IsNil)
+	c.Assert(sink.PrintOutput("path", "/backup/path"), IsNil)
+	c.Assert(sink.Flush(ctx), IsNil)
+	a, err := ReadArchive(ctx, d, "backups/phase1")
+	c.Assert(err, IsNil)
+	c.Assert(a.Complete, Equals, false)
+	c.Assert(a.Values(), DeepEquals, parseLog(c, log.String()))
+
+	c.Assert(sink.PrintOutput("version", "0.15.0"), IsNil)
+	c.Assert(sink.Complete(ctx, errors.New("snapshot failed")), IsNil)
+	a, err = ReadArchive(ctx, d, ArchiveRef("backups/phase1"))
+	c.Assert(err, IsNil)
+	c.Assert(a.Complete, Equals, true)
+	c.Assert(a.Error, Equals, "snapshot failed")
+	c.Assert(a.Outputs, HasLen, 3)
+	c.Assert(a.Values(), DeepEquals, parseLog(c, log.String()))
+	c.Assert(a.Values(), DeepEquals, map[string]interface{}{"version": "0.15.0", "path": "/backup/path"})
+
+	_, err = ReadArchive(ctx, d, "backups/phase2")
+	c.Assert(objectstore.IsObjectNotFound(err), Equals, true)
+}
+
+func (s *ArchiveSuite) TestLoad(c *C) {
+	ctx := context.Background()
+	d := newMemDirectory()
+	var log bytes.Buffer
+	seqPath := filepath.Join(c.MkDir(), "seq")
+	// Outputs printed by separate processes are archived together
+	for _, kv := range [][2]string{{"version", "0.14.0"}, {"path", "/backup/path"}} {
+		sink := NewArchivingSink(d, "phase")
+		sink.w = &log
+		sink.seqPath = seqPath
+		c.Assert(sink.Load(ctx), IsNil)
+		c.Assert(sink.PrintOutput(kv[0], kv[1]), IsNil)
+		c.Assert(sink.Flush(ctx), IsNil)
+	}
+	a, err := ReadArchive(ctx, d, "phase")
+	c.Assert(err, IsNil)
+	c.Assert(a.Values(), DeepEquals, parseLog(c, log.String()))
+}
+
+func (s *ArchiveSuite) TestAppend(c *C) {
+	ctx := context.Background()
+	d := newMemDirectory()
+	seqPath := filepath.Join(c.MkDir(), "seq")
+	// Outputs appended concurrently by separate processes are all archived
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sink := NewArchivingSink(d, "phase")
+			sink.w = &bytes.Buffer{}
+			sink.seqPath = seqPath
+			c.Check(sink.Append(ctx, fmt.Sprintf("key%d", i), "value"), IsNil)
+		}(i)
+	}
+	wg.Wait()
+	a, err := ReadArchive(ctx, d, "phase")
+	c.Assert(err, IsNil)
+	c.Assert(a.Outputs, HasLen, 10)
+	c.Assert(a.Values(), HasLen, 10)
+	c.Assert(a.Complete, Equals, false)
+
+	// Completion does not archive the appended outputs twice
+	sink := NewArchivingSink(d, "phase")
+	sink.w = &bytes.Buffer{}
+	sink.seqPath = seqPath
+	c.Assert(sink.Load(ctx), IsNil)
+	c.Assert(sink.PrintOutput("version", "0.14.0"), IsNil)
+	c.Assert(sink.Complete(ctx, nil), IsNil)
+	a, err = ReadArchive(ctx, d, "phase")
+	c.Assert(err, IsNil)
+	c.Assert(a.Complete, Equals, true)
+	c.Assert(a.Outputs, HasLen, 11)
+	c.Assert(a.Outputs[0].Key, Equals, "version")
+
+	// The appended outputs are listed once per sink
+	d.lists = 0
+	for i := 0; i < 3; i++ {
+		c.Assert(sink.Append(ctx, fmt.Sprintf("late%d", i), "value"), IsNil)
+	}
+	c.Assert(d.lists, Equals, 1)
+	a, err = ReadArchive(ctx, d, "phase")
+	c.Assert(err, IsNil)
+	c.Assert(a.Outputs, HasLen, 14)
+	c.Assert(a.Outputs[13].Key, Equals, "late2")
+	c.Assert(d.lists, Equals, 2)
+}
+
+func (s *ArchiveSuite) TestUpdatedAt(c *C) {
+	ctx := context.Background()
+	d := newMemDirectory()
+	sink := NewArchivingSink(d, "phase")
+	sink.w = &bytes.Buffer{}
+	sink.seqPath = filepath.Join(c.MkDir(), "seq")
+	now := time.Date(2018, 6, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	sink.now = func() time.Time { return now }
+	c.Assert(sink.PrintOutput("version", "0.14.0"), IsNil)
+	c.Assert(sink.Flush(ctx), IsNil)
+	a, err := ReadArchive(ctx, d, "phase")
+	c.Assert(err, IsNil)
+	c.Assert(a.UpdatedAt.Equal(now), Equals, true)
+	c.Assert(a.UpdatedAt.Location(), Equals, time.UTC)
+}
+
+func (s *ArchiveSuite) TestFlushEvery(c *C) {
+	ctx := context.Background()
+	d := newMemDirectory()
+	sink := NewArchivingSink(d, "phase")
+	sink.w = &bytes.Buffer{}
+	sink.seqPath = filepath.Join(c.MkDir(), "seq")
+	stop := sink.FlushEvery(ctx, time.Millisecond)
+	defer stop()
+	c.Assert(sink.PrintOutput("version", "0.14.0"), IsNil)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		a, err := ReadArchive(ctx, d, "phase")
+		if err == nil {
+			c.Assert(a.Values(), DeepEquals, map[string]interface{}{"version": "0.14.0"})
+			break
+		}
+		c.Assert(objectstore.IsObjectNotFound(err), Equals, true)
+		c.Assert(time.Now().Before(deadline), Equals, true)
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,238 @@
+package output
+
+// Conversion between phase outputs and structs, using `output` field tags:
+//
+//	type Snapshot struct {
+//		ID      string    `output:"snapshot_id,required"`
+//		Size    int64     `output:"size"`
+//		Created time.Time `output:"created,omitempty"`
+//	}
+//
+// Supported field types are string, int64, bool, time.Time (RFC3339) and
+// []string (JSON encoded).
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	tagName      = "output"
+	tagRequired  = "required"
+	tagOmitEmpty = "omitempty"
+	timeFormat   = time.RFC3339
+)
+
+var (
+	timeType        = reflect.TypeOf(time.Time{})
+	stringSliceType = reflect.TypeOf([]string(nil))
+)
+
+// ErrDecode lists all the fields that could not be decoded
+type ErrDecode struct {
+	// Missing required outputs
+	Missing []string
+	// Invalid outputs, with the reason
+	Invalid []string
+}
+
+func (e *ErrDecode) Error() string {
+	var msgs []string
+	if len(e.Missing) != 0 {
+		msgs = append(msgs, "missing: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Invalid) != 0 {
+		msgs = append(msgs, "invalid: "+strings.Join(e.Invalid, ", "))
+	}
+	return "Failed to decode outputs. " + strings.Join(msgs, "; ")
+}
+
+// IsDecodeError returns true if the cause of err is an ErrDecode
+func IsDecodeError(err error) bool {
+	_, ok := errors.Cause(err).(*ErrDecode)
+	return ok
+}
+
+// field is a struct field with an output tag
+type field struct {
+	key       string
+	required  bool
+	omitEmpty bool
+	value     reflect.Value
+}
+
+// fields returns the tagged fields of the struct pointed to by v
+func fields(v interface{}) ([]field, error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, errors.Errorf("Expected a non-nil pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	t := rv.Type()
+	var fs []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup(tagName)
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		f := field{key: opts[0], value: rv.Field(i)}
+		if err := ValidateKey(f.key); err != nil {
+			return nil, errors.Wrapf(err, "Invalid output key for field %s", sf.Name)
+		}
+		for _, o := range opts[1:] {
+			switch o {
+			case tagRequired:
+				f.required = true
+			case tagOmitEmpty:
+				f.omitEmpty = true
+			default:
+				return nil, errors.Errorf("Unknown output tag option %q for field %s", o, sf.Name)
+			}
+		}
+		if !supportedType(sf.Type) {
+			return nil, errors.Errorf("Unsupported type %s for field %s", sf.Type, sf.Name)
+		}
+		fs = append(fs, f)
+	}
+	return fs, nil
+}
+
+func supportedType(t reflect.Type) bool {
+	switch t {
+	case timeType, stringSliceType:
+		return true
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Int64, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// Decode populates the tagged fields of the struct pointed to by v from the
+// outputs. Optional fields without an output are left unchanged. All
+// missing and invalid outputs are reported in a single ErrDecode.
+func Decode(outputs map[string]string, v interface{}) error {
+	fs, err := fields(v)
+	if err != nil {
+		return err
+	}
+	e := &ErrDecode{}
+	for _, f := range fs {
+		s, ok := outputs[f.key]
+		if !ok {
+			if f.required {
+				e.Missing = append(e.Missing, f.key)
+			}
+			continue
+		}
+		if err := decodeValue(s, f.value); err != nil {
+			e.Invalid = append(e.Invalid, fmt.Sprintf("%s (%s)", f.key, err))
+		}
+	}
+	if len(e.Missing)+len(e.Invalid) == 0 {
+		return nil
+	}
+	sort.Strings(e.Missing)
+	sort.Strings(e.Invalid)
+	return e
+}
+
+func decodeValue(s string, v reflect.Value) error {
+	switch v.Type() {
+	case timeType:
+		t, err := time.Parse(timeFormat, s)
+		if err != nil {
+			return errors.New("expected an RFC3339 time")
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	case stringSliceType:
+		var ss []string
+		if err := json.Unmarshal([]byte(s), &ss); err != nil {
+			return errors.New("expected a JSON encoded list of strings")
+		}
+		v.Set(reflect.ValueOf(ss))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return errors.New("expected an integer")
+		}
+		v.SetInt(i)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("expected a boolean")
+		}
+		v.SetBool(b)
+	}
+	return nil
+}
+
+// Encode returns the outputs of the tagged fields of the struct pointed to
+// by v. Fields tagged omitempty are skipped if they have their zero value.
+func Encode(v interface{}) (map[string]string, error) {
+	fs, err := fields(v)
+	if err != nil {
+		return nil, err
+	}
+	outputs := make(map[string]string, len(fs))
+	for _, f := range fs {
+		if f.omitEmpty && isZero(f.value) {
+			continue
+		}
+		s, err := encodeValue(f.value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to encode output %s", f.key)
+		}
+		outputs[f.key] = s
+	}
+	return outputs, nil
+}
+
+func encodeValue(v reflect.Value) (string, error) {
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).Format(timeFormat), nil
+	case stringSliceType:
+		ss := v.Interface().([]string)
+		if ss == nil {
+			ss = []string{}
+		}
+		b, err := json.Marshal(ss)
+		return string(b), err
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	}
+	return "", errors.Errorf("Unsupported type %s", v.Type())
+}
+
+func isZero(v reflect.Value) bool {
+	switch v.Type() {
+	case timeType:
+		return v.Interface().(time.Time).IsZero()
+	case stringSliceType:
+		return v.Len() == 0
+	}
+	return v.Interface() == reflect.Zero(v.Type()).Interface()
+}
@@ -0,0 +1,103 @@
+package output
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type DecodeSuite struct{}
+
+var _ = Suite(&DecodeSuite{})
+
+type snapshotOutputs struct {
+	ID       string    `output:"snapshot_id,required"`
+	Size     int64     `output:"size,required"`
+	Verified bool      `output:"verified"`
+	Created  time.Time `output:"created,omitempty"`
+	Volumes  []string  `output:"volumes"`
+	Note     string    // Not an output
+	internal string    `output:"internal"`
+}
+
+func (s *DecodeSuite) TestRoundTrip(c *C) {
+	in := snapshotOutputs{
+		ID:       "snap-123",
+		Size:     1 << 40,
+		Verified: true,
+		Created:  time.Date(2018, time.August, 1, 12, 0, 0, 0, time.UTC),
+		Volumes:  []string{"vol-1", "vol 2"},
+	}
+	outputs, err := Encode(&in)
+	c.Assert(err, IsNil)
+	c.Assert(outputs, DeepEquals, map[string]string{
+		"snapshot_id": "snap-123",
+		"size":        "1099511627776",
+		"verified":    "true",
+		"created":     "2018-08-01T12:00:00Z",
+		"volumes":     `["vol-1","vol 2"]`,
+	})
+	for k := range outputs {
+		c.Assert(ValidateKey(k), IsNil)
+	}
+	var out snapshotOutputs
+	c.Assert(Decode(outputs, &out), IsNil)
+	c.Assert(out, DeepEquals, in)
+}
+
+func (s *DecodeSuite) TestEncodeOmitEmpty(c *C) {
+	outputs, err := Encode(&snapshotOutputs{ID: "snap"})
+	c.Assert(err, IsNil)
+	c.Assert(outputs, DeepEquals, map[string]string{
+		"snapshot_id": "snap",
+		"size":        "0",
+		"verified":    "false",
+		"volumes":     "[]",
+	})
+}
+
+func (s *DecodeSuite) TestDecodeErrors(c *C) {
+	out := snapshotOutputs{Note: "unchanged", Verified: true}
+	err := Decode(map[string]string{
+		"verified": "maybe",
+		"created":  "yesterday",
+		"volumes":  "vol-1",
+	}, &out)
+	c.Assert(IsDecodeError(err), Equals, true)
+	e := err.(*ErrDecode)
+	c.Assert(e.Missing, DeepEquals, []string{"size", "snapshot_id"})
+	c.Assert(e.Invalid, DeepEquals, []string{
+		"created (expected an RFC3339 time)",
+		"verified (expected a boolean)",
+		"volumes (expected a JSON encoded list of strings)",
+	})
+	c.Assert(err, ErrorMatches, "Failed to decode outputs. missing: size, snapshot_id; invalid: created .*")
+	c.Assert(out.Note, Equals, "unchanged")
+	c.Assert(out.Verified, Equals, true)
+
+	// Optional outputs can be absent
+	c.Assert(Decode(map[string]string{"snapshot_id": "s", "size": "1"}, &out), IsNil)
+	c.Assert(Decode(map[string]string{"snapshot_id": "s", "size": "1.5"}, &out), ErrorMatches, ".*invalid: size \\(expected an integer\\)")
+}
+
+func (s *DecodeSuite) TestInvalidStructs(c *C) {
+	var out snapshotOutputs
+	for _, v := range []interface{}{
+		out,
+		(*snapshotOutputs)(nil),
+		new(string),
+		&struct {
+			F float64 `output:"f"`
+		}{},
+		&struct {
+			F string `output:"bad-key"`
+		}{},
+		&struct {
+			F string `output:"f,optional"`
+		}{},
+	} {
+		c.Check(Decode(map[string]string{}, v), NotNil, Commentf("%T", v))
+		_, err := Encode(v)
+		c.Check(err, NotNil, Commentf("%T", v))
+	}
+}
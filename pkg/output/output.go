@@ -3,6 +3,8 @@ package output
 import (
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"regexp"
 
 	"github.com/pkg/errors"
@@ -15,12 +17,16 @@ const (
 type Output struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
+	// Seq is the optional sequence number of the output, starting at 1,
+	// that lets a Scanner deliver outputs once when a log is read again
+	Seq int64 `json:"seq,omitempty"`
 }
 
-func marshalOutput(key, value string) (string, error) {
+func marshalOutput(key, value string, seq int64) (string, error) {
 	out := &Output{
 		Key:   key,
 		Value: value,
+		Seq:   seq,
 	}
 	outString, err := json.Marshal(out)
 	if err != nil {
@@ -50,12 +56,32 @@ func ValidateKey(key string) error {
 	return nil
 }
 
-// PrintOutput runs the `kando output` command
+// PrintOutput runs the `kando output` command. Outputs are numbered with the
+// counter of SeqPathEnv if it is set, or printed without a sequence number if
+// the counter cannot be used, e.g. on a read-only filesystem.
 func PrintOutput(key, value string) error {
-	outString, err := marshalOutput(key, value)
+	_, err := printOutput(os.Stdout, seqPath(), key, value)
+	return err
+}
+
+// printOutput prints the output to w, numbered with the counter at seqPath
+// if it is set
+func printOutput(w io.Writer, seqPath, key, value string) (*Output, error) {
+	var seq int64
+	if seqPath != "" {
+		s, unlock, err := nextSeq(seqPath)
+		if err == nil {
+			// Outputs are printed in the order of their numbers
+			defer unlock()
+			seq = s
+		}
+	}
+	outString, err := marshalOutput(key, value, seq)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	fmt.Println(PhaseOpString, outString)
-	return nil
+	if _, err := fmt.Fprintln(w, PhaseOpString, outString); err != nil {
+		return nil, errors.Wrap(err, "Failed to print output")
+	}
+	return &Output{Key: key, Value: value, Seq: seq}, nil
 }
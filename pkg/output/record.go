@@ -0,0 +1,202 @@
+package output
+
+// Outputs can also be recorded in a file, so that consumers can detect
+// output lines that were lost by the log transport.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RecordPath is the well-known file in which outputs are recorded
+const RecordPath = "/tmp/kanister-phase-output.json"
+
+// VerifyMode decides how discrepancies between the outputs parsed from logs
+// and the recorded outputs are handled
+type VerifyMode string
+
+const (
+	// VerifyNone disables the verification
+	VerifyNone VerifyMode = ""
+	// VerifyWarn logs discrepancies as warnings
+	VerifyWarn VerifyMode = "warn"
+	// VerifyStrict fails on discrepancies
+	VerifyStrict VerifyMode = "strict"
+)
+
+// ValidateVerifyMode validates the verification mode
+func ValidateVerifyMode(m VerifyMode) error {
+	switch m {
+	case VerifyNone, VerifyWarn, VerifyStrict:
+		return nil
+	}
+	return errors.Errorf("Invalid output verification mode %q", m)
+}
+
+// PrintAndRecordOutput adds the output to the record at path and prints it
+// like PrintOutput. The output is recorded first, so that a printed output
+// is always recorded.
+func PrintAndRecordOutput(key, value, path string) error {
+	if err := RecordOutput(key, value, path); err != nil {
+		return err
+	}
+	return PrintOutput(key, value)
+}
+
+// RecordOutput adds the output to the record at path. The record is locked
+// while it is updated, like the output counter, so that the outputs of
+// concurrent commands are all recorded.
+func RecordOutput(key, value, path string) error {
+	unlock, err := lockRecord(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	record := make(map[string]string)
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err != nil:
+		return errors.Wrapf(err, "Failed to read output record %s", path)
+	case len(data) != 0:
+		if record, err = ParseRecord(data); err != nil {
+			return err
+		}
+	}
+	record[key] = value
+	if data, err = json.Marshal(record); err != nil {
+		return errors.Wrap(err, "Failed to marshal output record")
+	}
+	// Replace the record atomically, so that it is never read partially
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return errors.Wrap(err, "Failed to create output record")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "Failed to write output record")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "Failed to write output record")
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		// The termination message file of a container is a mount point,
+		// which cannot be replaced. It is only read once the container has
+		// terminated, so it is written in place.
+		if werr := ioutil.WriteFile(path, data, 0644); werr != nil {
+			return errors.Wrap(err, "Failed to write output record")
+		}
+	}
+	return nil
+}
+
+// lockRecord locks the record at path, which is created empty if it does
+// not exist. The record is replaced when it is written, so the lock is
+// taken again if the file was replaced while waiting for it.
+func lockRecord(path string) (unlock func(), err error) {
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to open output record %s", path)
+		}
+		unlockFile, err := lockFile(file)
+		if err != nil {
+			file.Close()
+			return nil, errors.Wrapf(err, "Failed to lock output record %s", path)
+		}
+		unlock = func() {
+			unlockFile()
+			file.Close()
+		}
+		locked, err := file.Stat()
+		if err != nil {
+			unlock()
+			return nil, errors.Wrapf(err, "Failed to lock output record %s", path)
+		}
+		current, err := os.Stat(path)
+		if err == nil && os.SameFile(locked, current) {
+			return unlock, nil
+		}
+		unlock()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, errors.Wrapf(err, "Failed to lock output record %s", path)
+		}
+	}
+}
+
+// ParseRecord parses a record written by RecordOutput
+func ParseRecord(data []byte) (map[string]string, error) {
+	record := make(map[string]string)
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal output record")
+	}
+	return record, nil
+}
+
+// ErrOutputMismatch describes the discrepancies between the outputs parsed
+// from logs and the recorded outputs
+type ErrOutputMismatch struct {
+	// Missing outputs were recorded but not found in the logs
+	Missing []string
+	// Mismatched outputs have different values in the logs and the record
+	Mismatched []string
+	// Unrecorded outputs were found in the logs but not recorded
+	Unrecorded []string
+}
+
+func (e *ErrOutputMismatch) Error() string {
+	var msgs []string
+	for _, d := range []struct {
+		desc string
+		keys []string
+	}{
+		{"missing from logs", e.Missing},
+		{"mismatched", e.Mismatched},
+		{"not recorded", e.Unrecorded},
+	} {
+		if len(d.keys) != 0 {
+			msgs = append(msgs, fmt.Sprintf("%s: %s", d.desc, strings.Join(d.keys, ", ")))
+		}
+	}
+	return "Phase outputs do not match the record. " + strings.Join(msgs, "; ")
+}
+
+// IsOutputMismatch returns true if the cause of err is an ErrOutputMismatch
+func IsOutputMismatch(err error) bool {
+	_, ok := errors.Cause(err).(*ErrOutputMismatch)
+	return ok
+}
+
+// Verify cross-checks the outputs parsed from logs against the recorded
+// outputs, and returns an ErrOutputMismatch if they differ
+func Verify(logged map[string]interface{}, recorded map[string]string) error {
+	e := &ErrOutputMismatch{}
+	for k, v := range recorded {
+		lv, ok := logged[k]
+		switch {
+		case !ok:
+			e.Missing = append(e.Missing, k)
+		case lv != v:
+			e.Mismatched = append(e.Mismatched, k)
+		}
+	}
+	for k := range logged {
+		if _, ok := recorded[k]; !ok {
+			e.Unrecorded = append(e.Unrecorded, k)
+		}
+	}
+	if len(e.Missing)+len(e.Mismatched)+len(e.Unrecorded) == 0 {
+		return nil
+	}
+	sort.Strings(e.Missing)
+	sort.Strings(e.Mismatched)
+	sort.Strings(e.Unrecorded)
+	return e
+}
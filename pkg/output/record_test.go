@@ -0,0 +1,75 @@
+package output
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type RecordSuite struct{}
+
+var _ = Suite(&RecordSuite{})
+
+func (s *RecordSuite) TestRecordOutput(c *C) {
+	path := filepath.Join(c.MkDir(), "record.json")
+	c.Assert(RecordOutput("version", "0.14.0", path), IsNil)
+	c.Assert(RecordOutput("path", "/backup/path", path), IsNil)
+	c.Assert(RecordOutput("version", "0.15.0", path), IsNil)
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	record, err := ParseRecord(data)
+	c.Assert(err, IsNil)
+	c.Assert(record, DeepEquals, map[string]string{"version": "0.15.0", "path": "/backup/path"})
+	// No temporary files are left behind
+	files, err := ioutil.ReadDir(filepath.Dir(path))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+
+	c.Assert(ioutil.WriteFile(path, []byte("garbage"), 0644), IsNil)
+	c.Assert(RecordOutput("version", "0.14.0", path), ErrorMatches, "Failed to unmarshal output record.*")
+}
+
+func (s *RecordSuite) TestRecordOutputConcurrent(c *C) {
+	path := filepath.Join(c.MkDir(), "record.json")
+	const n = 20
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.Check(RecordOutput(fmt.Sprintf("key%d", i), "value", path), IsNil)
+		}(i)
+	}
+	wg.Wait()
+	data, err := ioutil.ReadFile(path)
+	c.Assert(err, IsNil)
+	record, err := ParseRecord(data)
+	c.Assert(err, IsNil)
+	c.Assert(record, HasLen, n)
+}
+
+func (s *RecordSuite) TestVerify(c *C) {
+	recorded := map[string]string{"version": "0.14.0", "path": "/backup/path", "id": "abc"}
+	logged := map[string]interface{}{"version": "0.14.0", "path": "/backup/path", "id": "abc"}
+	c.Assert(Verify(logged, recorded), IsNil)
+	c.Assert(Verify(nil, nil), IsNil)
+
+	// Dropped log lines
+	err := Verify(map[string]interface{}{"version": "0.14.0"}, recorded)
+	c.Assert(IsOutputMismatch(err), Equals, true)
+	c.Assert(err.(*ErrOutputMismatch).Missing, DeepEquals, []string{"id", "path"})
+	c.Assert(err, ErrorMatches, "Phase outputs do not match the record. missing from logs: id, path")
+
+	err = Verify(map[string]interface{}{"version": "0.13.0", "path": "/backup/path", "id": "abc", "extra": "x"}, recorded)
+	c.Assert(err, ErrorMatches, "Phase outputs do not match the record. mismatched: version; not recorded: extra")
+}
+
+func (s *RecordSuite) TestValidateVerifyMode(c *C) {
+	for _, m := range []VerifyMode{VerifyNone, VerifyWarn, VerifyStrict} {
+		c.Check(ValidateVerifyMode(m), IsNil)
+	}
+	c.Check(ValidateVerifyMode("lenient"), NotNil)
+}
@@ -0,0 +1,145 @@
+package output
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Checkpoint is the state of a Scanner that callers persist to resume
+// scanning a log, e.g. after reconnecting to a pod that is followed
+type Checkpoint struct {
+	// Seq is the last sequence number that was delivered
+	Seq int64 `json:"seq"`
+	// Offset is the number of bytes of the log that were scanned. It is a
+	// hint for logs that are scanned again from the start: outputs without
+	// a sequence number before Offset are skipped. It must be cleared to
+	// resume with a log that does not start at the beginning.
+	Offset int64 `json:"offset"`
+}
+
+// Gap is a range of sequence numbers, From to To inclusive, that were not
+// seen in the log. The outputs may have been lost.
+type Gap struct {
+	From int64
+	To   int64
+}
+
+func (g Gap) String() string {
+	if g.From == g.To {
+		return fmt.Sprintf("%d", g.From)
+	}
+	return fmt.Sprintf("%d-%d", g.From, g.To)
+}
+
+// Scanner reads the outputs from a log. Outputs with sequence numbers are
+// delivered once, in order; outputs whose sequence number was already
+// delivered are skipped, and missing sequence numbers are reported as
+// gaps.
+type Scanner struct {
+	r      *bufio.Reader
+	cp     Checkpoint
+	skip   int64
+	offset int64
+	out    *Output
+	err    error
+	gaps   []Gap
+}
+
+// NewScanner returns a Scanner that reads the outputs from r
+func NewScanner(r io.Reader) *Scanner {
+	return ResumeScanner(r, Checkpoint{})
+}
+
+// ResumeScanner returns a Scanner that reads the outputs from r that come
+// after the checkpoint
+func ResumeScanner(r io.Reader, cp Checkpoint) *Scanner {
+	return &Scanner{
+		r:    bufio.NewReader(r),
+		cp:   Checkpoint{Seq: cp.Seq},
+		skip: cp.Offset,
+	}
+}
+
+// Scan advances to the next output, which is then available through
+// Output. It returns false at the end of the log or on an error.
+func (s *Scanner) Scan() bool {
+	s.out = nil
+	if s.err != nil {
+		return false
+	}
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			s.err = errors.Wrap(err, "Failed to read log")
+			return false
+		}
+		start := s.offset
+		out, perr := parseLine(line)
+		if !strings.HasSuffix(line, "\n") && (out == nil || perr != nil) {
+			// The rest of the line may follow when the log is resumed
+			return false
+		}
+		s.offset += int64(len(line))
+		s.cp.Offset = s.offset
+		if perr != nil {
+			s.err = perr
+			return false
+		}
+		if out != nil && s.deliver(out, start) {
+			s.out = out
+			return true
+		}
+		if err == io.EOF {
+			return false
+		}
+	}
+}
+
+// deliver returns true if the output found at offset start is new, and
+// records gaps in the sequence numbers
+func (s *Scanner) deliver(out *Output, start int64) bool {
+	if out.Seq == 0 {
+		return start >= s.skip
+	}
+	if out.Seq <= s.cp.Seq {
+		return false
+	}
+	if out.Seq > s.cp.Seq+1 {
+		s.gaps = append(s.gaps, Gap{From: s.cp.Seq + 1, To: out.Seq - 1})
+	}
+	s.cp.Seq = out.Seq
+	return true
+}
+
+// parseLine returns the output in a log line, or nil if there is none
+func parseLine(line string) (*Output, error) {
+	i := strings.Index(line, PhaseOpString)
+	if i < 0 {
+		return nil, nil
+	}
+	return UnmarshalOutput(strings.TrimSpace(line[i+len(PhaseOpString):]))
+}
+
+// Output returns the output found by the last call to Scan
+func (s *Scanner) Output() *Output {
+	return s.out
+}
+
+// Err returns the first error, other than the end of the log
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Checkpoint returns the state to resume scanning with
+func (s *Scanner) Checkpoint() Checkpoint {
+	return s.cp
+}
+
+// Gaps returns the ranges of sequence numbers that were skipped in the log
+func (s *Scanner) Gaps() []Gap {
+	return s.gaps
+}
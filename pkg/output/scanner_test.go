@@ -0,0 +1,145 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type ScannerSuite struct{}
+
+var _ = Suite(&ScannerSuite{})
+
+// outputLine returns the line printed for an output without a counter
+func outputLine(key, value string) string {
+	var line bytes.Buffer
+	if _, err := printOutput(&line, "", key, value); err != nil {
+		panic(err)
+	}
+	return line.String()
+}
+
+// sequencedLog returns a log of n outputs printed like `kando output`,
+// interleaved with other lines, from which the outputs numbered in missing
+// were lost
+func sequencedLog(c *C, n int64, missing ...int64) string {
+	skip := make(map[int64]bool)
+	for _, m := range missing {
+		skip[m] = true
+	}
+	seqPath := filepath.Join(c.MkDir(), "seq")
+	var log string
+	for i := int64(1); i <= n; i++ {
+		log += fmt.Sprintf("processing item %d\n", i)
+		var line bytes.Buffer
+		out, err := printOutput(&line, seqPath, fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i))
+		c.Assert(err, IsNil)
+		c.Assert(out.Seq, Equals, i)
+		if !skip[i] {
+			log += line.String()
+		}
+	}
+	return log
+}
+
+// scanAll scans r and returns the sequence numbers or keys delivered
+func scanAll(c *C, s *Scanner) []string {
+	var got []string
+	for s.Scan() {
+		out := s.Output()
+		if out.Seq > 0 {
+			got = append(got, fmt.Sprint(out.Seq))
+			continue
+		}
+		got = append(got, out.Key)
+	}
+	c.Assert(s.Err(), IsNil)
+	return got
+}
+
+func (s *ScannerSuite) TestScan(c *C) {
+	log := "starting\n" + outputLine("version", "0.14.0") + "Random message " + outputLine("path", "/backup/path") + "done"
+	sc := NewScanner(strings.NewReader(log))
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.Output(), DeepEquals, &Output{Key: "version", Value: "0.14.0"})
+	c.Assert(sc.Scan(), Equals, true)
+	c.Assert(sc.Output(), DeepEquals, &Output{Key: "path", Value: "/backup/path"})
+	c.Assert(sc.Scan(), Equals, false)
+	c.Assert(sc.Err(), IsNil)
+	// The unterminated last line is not consumed
+	c.Assert(sc.Checkpoint(), Equals, Checkpoint{Offset: int64(len(log) - len("done"))})
+
+	sc = NewScanner(strings.NewReader(PhaseOpString + " Invalid message\n"))
+	c.Assert(sc.Scan(), Equals, false)
+	c.Assert(sc.Err(), NotNil)
+}
+
+func (s *ScannerSuite) TestResumeSequenced(c *C) {
+	log := sequencedLog(c, 20)
+	var cp Checkpoint
+	var got []string
+	// Chunks end in the middle of lines, and each reconnect starts before
+	// the end of what was scanned, or at the start of the log
+	start := 0
+	for i, end := range []int{101, 333, 334, 700, len(log)} {
+		if i == 2 {
+			start = 0
+		}
+		// Offsets do not apply to logs that start elsewhere
+		cp.Offset = 0
+		sc := ResumeScanner(strings.NewReader(log[start:end]), cp)
+		got = append(got, scanAll(c, sc)...)
+		c.Assert(sc.Gaps(), HasLen, 0)
+		cp = sc.Checkpoint()
+		start += int(cp.Offset) - 50
+		if start < 0 {
+			start = 0
+		}
+	}
+	var expected []string
+	for i := 1; i <= 20; i++ {
+		expected = append(expected, fmt.Sprint(i))
+	}
+	c.Assert(got, DeepEquals, expected)
+	c.Assert(cp.Seq, Equals, int64(20))
+}
+
+func (s *ScannerSuite) TestGaps(c *C) {
+	log := sequencedLog(c, 10, 1, 4, 5, 8)
+	cut := strings.Index(log, "processing item 7")
+	sc := NewScanner(strings.NewReader(log[:cut]))
+	c.Assert(scanAll(c, sc), DeepEquals, []string{"2", "3", "6"})
+	c.Assert(sc.Gaps(), DeepEquals, []Gap{{From: 1, To: 1}, {From: 4, To: 5}})
+
+	// Gaps are reported once across resumes
+	cp := sc.Checkpoint()
+	cp.Offset = 0
+	sc = ResumeScanner(strings.NewReader(log), cp)
+	c.Assert(scanAll(c, sc), DeepEquals, []string{"7", "9", "10"})
+	c.Assert(sc.Gaps(), DeepEquals, []Gap{{From: 8, To: 8}})
+	c.Assert(fmt.Sprint(sc.Gaps()), Equals, "[8]")
+}
+
+func (s *ScannerSuite) TestResumeFromStart(c *C) {
+	// Without sequence numbers, the offset skips the outputs that were
+	// scanned when the log is read again from the start
+	var log string
+	var expected []string
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i)
+		log += "message\n" + outputLine(key, "value")
+		expected = append(expected, key)
+	}
+	var cp Checkpoint
+	var got []string
+	for _, end := range []int{37, 38, 200, 201, len(log) - 3, len(log)} {
+		sc := ResumeScanner(strings.NewReader(log[:end]), cp)
+		got = append(got, scanAll(c, sc)...)
+		cp = sc.Checkpoint()
+	}
+	c.Assert(got, DeepEquals, expected)
+	c.Assert(cp.Offset, Equals, int64(len(log)))
+}
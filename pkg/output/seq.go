@@ -0,0 +1,76 @@
+package output
+
+// Outputs printed by `kando output` can be numbered, so that a Scanner that
+// follows a log delivers them once and reports the outputs lost by the log
+// transport as gaps. Numbering is opt-in: callers that follow logs set
+// SeqPathEnv to a counter file of their own, e.g. one per phase run, which
+// keeps the number of the last output. It is locked while an output is
+// numbered and printed, so that the outputs of concurrent commands are
+// printed in order.
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SeqPathEnv is the environment variable that holds the file in which the
+// sequence number of the last printed output is kept. Outputs are not
+// numbered if it is not set. Callers remove the file, or pick a new one,
+// before running a command, so that its outputs are numbered from 1.
+const SeqPathEnv = "KANISTER_OUTPUT_SEQ_PATH"
+
+// seqPath returns the counter file of SeqPathEnv, or "" if outputs are not
+// numbered
+func seqPath() string {
+	return os.Getenv(SeqPathEnv)
+}
+
+// nextSeq returns the sequence number that follows the one in the counter
+// file at path, and counts it. The file stays locked until unlock is
+// called, once the output is printed. The number is counted before it is
+// printed, so that an output that fails to print is reported as a gap
+// rather than numbered twice.
+func nextSeq(path string) (seq int64, unlock func(), err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, nil, errors.Wrapf(err, "Failed to open output counter %s", path)
+	}
+	unlockFile, err := lockFile(file)
+	if err != nil {
+		file.Close()
+		return 0, nil, errors.Wrapf(err, "Failed to lock output counter %s", path)
+	}
+	unlock = func() {
+		unlockFile()
+		file.Close()
+	}
+	if seq, err = countSeq(file); err != nil {
+		unlock()
+		return 0, nil, errors.Wrapf(err, "Failed to update output counter %s", path)
+	}
+	return seq, unlock, nil
+}
+
+// countSeq increments the counter in file and returns its new value
+func countSeq(file *os.File) (int64, error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return 0, err
+	}
+	var seq int64
+	if s := strings.TrimSpace(string(data)); s != "" {
+		if seq, err = strconv.ParseInt(s, 10, 64); err != nil {
+			return 0, err
+		}
+	}
+	seq++
+	if err := file.Truncate(0); err != nil {
+		return 0, err
+	}
+	_, err = file.WriteAt([]byte(strconv.FormatInt(seq, 10)), 0)
+	return seq, err
+}
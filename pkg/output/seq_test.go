@@ -0,0 +1,74 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type SeqSuite struct{}
+
+var _ = Suite(&SeqSuite{})
+
+// lockedWriter serializes the writes of concurrent printers, like the
+// pipe of a container log
+type lockedWriter struct {
+	mu sync.Mutex
+	w  bytes.Buffer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Write(p)
+}
+
+func (s *SeqSuite) TestPrintConcurrent(c *C) {
+	seqPath := filepath.Join(c.MkDir(), "seq")
+	var log lockedWriter
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := printOutput(&log, seqPath, fmt.Sprintf("key%d", i), "value")
+			c.Check(err, IsNil)
+		}(i)
+	}
+	wg.Wait()
+
+	// Outputs are numbered once and printed in order
+	sc := NewScanner(&log.w)
+	var expected []string
+	for i := 1; i <= 20; i++ {
+		expected = append(expected, fmt.Sprint(i))
+	}
+	c.Assert(scanAll(c, sc), DeepEquals, expected)
+	c.Assert(sc.Gaps(), HasLen, 0)
+	data, err := ioutil.ReadFile(seqPath)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "20")
+}
+
+func (s *SeqSuite) TestPrintWithoutCounter(c *C) {
+	// Outputs are printed without a number if the counter cannot be used
+	seqPath := filepath.Join(c.MkDir(), "missing", "seq")
+	var log bytes.Buffer
+	out, err := printOutput(&log, seqPath, "version", "0.14.0")
+	c.Assert(err, IsNil)
+	c.Assert(out, DeepEquals, &Output{Key: "version", Value: "0.14.0"})
+	c.Assert(log.String(), Equals, outputLine("version", "0.14.0"))
+
+	// An invalid counter is not used
+	seqPath = filepath.Join(c.MkDir(), "seq")
+	c.Assert(ioutil.WriteFile(seqPath, []byte("invalid"), 0644), IsNil)
+	out, err = printOutput(&log, seqPath, "path", "/backup")
+	c.Assert(err, IsNil)
+	c.Assert(out.Seq, Equals, int64(0))
+	c.Assert(strings.Count(log.String(), PhaseOpString), Equals, 2)
+}
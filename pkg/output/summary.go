@@ -0,0 +1,98 @@
+package output
+
+// Summaries of the transfers of kando location commands, printed as outputs
+// under a reserved key.
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ReservedKeyPrefix is the prefix of the output keys printed by Kanister
+	// itself. Blueprints cannot print outputs with these keys.
+	ReservedKeyPrefix = "kanister_"
+	// TransferSummaryKey is the output key of the TransferSummary of a kando
+	// location command
+	TransferSummaryKey = ReservedKeyPrefix + "transfer_summary"
+)
+
+// IsReservedKey returns true if key is reserved for outputs printed by
+// Kanister
+func IsReservedKey(key string) bool {
+	return strings.HasPrefix(key, ReservedKeyPrefix)
+}
+
+// TransferSummary accounts for the data moved by kando location commands
+type TransferSummary struct {
+	// Operation is push, pull or delete
+	Operation string `json:"operation"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	// Objects counts the artifacts transferred
+	Objects int64 `json:"objects"`
+	Retries int64 `json:"retries"`
+	// ThrottleWait is the time spent waiting before retries of throttled
+	// or failed requests
+	ThrottleWait time.Duration `json:"throttleWait"`
+	Elapsed      time.Duration `json:"elapsed"`
+	// Error is the error that the operation failed with, if any
+	Error string `json:"error,omitempty"`
+}
+
+// String returns the summary on one line
+func (s TransferSummary) String() string {
+	msg := fmt.Sprintf("%s %s: %d bytes, %d objects, %d retries, %s throttled in %s", s.Operation, s.Path, s.Bytes, s.Objects, s.Retries, s.ThrottleWait, s.Elapsed)
+	if s.Error != "" {
+		msg += ", failed: " + s.Error
+	}
+	return msg
+}
+
+// Add adds the totals of o to s, e.g. to account for the transfers of an
+// ActionSet. The operation and path of s are kept.
+func (s *TransferSummary) Add(o TransferSummary) {
+	s.Bytes += o.Bytes
+	s.Objects += o.Objects
+	s.Retries += o.Retries
+	s.ThrottleWait += o.ThrottleWait
+	s.Elapsed += o.Elapsed
+}
+
+// PrintTransferSummary prints the summary to w as the output with the
+// TransferSummaryKey, numbered like the outputs printed by PrintOutput
+func PrintTransferSummary(w io.Writer, s TransferSummary) error {
+	return printTransferSummary(w, seqPath(), s)
+}
+
+func printTransferSummary(w io.Writer, seqPath string, s TransferSummary) error {
+	value, err := json.Marshal(s)
+	if err != nil {
+		return errors.Wrap(err, "Failed to marshal transfer summary")
+	}
+	_, err = printOutput(w, seqPath, TransferSummaryKey, string(value))
+	return errors.Wrap(err, "Failed to print transfer summary")
+}
+
+// TransferSummaryFrom returns the summary in the outputs of a phase, or nil
+// if there is none
+func TransferSummaryFrom(outputs map[string]interface{}) (*TransferSummary, error) {
+	v, ok := outputs[TransferSummaryKey]
+	if !ok {
+		return nil, nil
+	}
+	value, ok := v.(string)
+	if !ok {
+		return nil, errors.Errorf("Invalid transfer summary of type %T", v)
+	}
+	s := &TransferSummary{}
+	if err := json.Unmarshal([]byte(value), s); err != nil {
+		return nil, errors.Wrap(err, "Failed to unmarshal transfer summary")
+	}
+	return s, nil
+}
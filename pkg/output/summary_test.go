@@ -0,0 +1,50 @@
+package output
+
+import (
+	"bytes"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SummarySuite struct{}
+
+var _ = Suite(&SummarySuite{})
+
+func (s *SummarySuite) TestTransferSummary(c *C) {
+	summary := TransferSummary{
+		Operation:    "push",
+		Path:         "backups/obj",
+		Bytes:        12000,
+		Objects:      1,
+		Retries:      2,
+		ThrottleWait: time.Second,
+		Elapsed:      3 * time.Second,
+	}
+	var log bytes.Buffer
+	c.Assert(printTransferSummary(&log, filepath.Join(c.MkDir(), "seq"), summary), IsNil)
+	c.Assert(log.String(), Matches, PhaseOpString+` \{"key":"kanister_transfer_summary","value":".*","seq":1\}\n`)
+	got, err := TransferSummaryFrom(parseLog(c, log.String()))
+	c.Assert(err, IsNil)
+	c.Assert(*got, DeepEquals, summary)
+	c.Assert(got.String(), Equals, "push backups/obj: 12000 bytes, 1 objects, 2 retries, 1s throttled in 3s")
+
+	// Summaries of the phases of an ActionSet add up
+	total := TransferSummary{}
+	total.Add(summary)
+	total.Add(TransferSummary{Operation: "pull", Bytes: 100, Objects: 1, Elapsed: time.Second, Error: "failed"})
+	c.Assert(total, DeepEquals, TransferSummary{Bytes: 12100, Objects: 2, Retries: 2, ThrottleWait: time.Second, Elapsed: 4 * time.Second})
+
+	got, err = TransferSummaryFrom(map[string]interface{}{"version": "0.14.0"})
+	c.Assert(err, IsNil)
+	c.Assert(got, IsNil)
+	_, err = TransferSummaryFrom(map[string]interface{}{TransferSummaryKey: "{"})
+	c.Assert(err, NotNil)
+	_, err = TransferSummaryFrom(map[string]interface{}{TransferSummaryKey: 1})
+	c.Assert(err, ErrorMatches, "Invalid transfer summary of type int")
+
+	c.Assert(IsReservedKey(TransferSummaryKey), Equals, true)
+	c.Assert(IsReservedKey("kanister"), Equals, false)
+	c.Assert(IsReservedKey("version"), Equals, false)
+}